@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// recordAudit appends an entry for a destructive operation to the audit log
+// stored in the repository. A failure to record the entry does not abort the
+// operation, it is only reported as a warning.
+func recordAudit(ctx context.Context, repo restic.Repository, operation string, details string, snapshots restic.IDs) {
+	e := restic.NewAuditEntry(operation, details, snapshots, time.Now())
+	e.Version = version
+	if r, ok := repo.(interface{ KeyID() restic.ID }); ok {
+		id := r.KeyID()
+		e.Key = &id
+	}
+
+	id, err := restic.AppendAuditEntry(ctx, repo, e)
+	if err != nil {
+		Warnf("unable to record %v in the audit log: %v\n", operation, err)
+		return
+	}
+
+	if r, ok := repo.(*repository.Repository); ok {
+		rememberAuditHead(r, auditAnchor{Latest: id, Sequence: e.Sequence})
+	}
+}
+
+const auditHeadCacheName = "audithead"
+
+// auditAnchor is the newest audit entry seen by this client. It is stored in
+// the local cache, so that rolling back the audit log in the repository,
+// including the audit head, can be detected.
+type auditAnchor struct {
+	Latest   restic.ID `json:"latest"`
+	Sequence uint64    `json:"sequence"`
+}
+
+// loadAuditAnchor returns the audit anchor stored in the local cache, or nil
+// if there is none.
+func loadAuditAnchor(repo *repository.Repository) (*auditAnchor, error) {
+	if repo.Cache == nil {
+		return nil, nil
+	}
+
+	buf, err := repo.Cache.ReadExtraFile(auditHeadCacheName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var a auditAnchor
+	err = json.Unmarshal(buf, &a)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	return &a, nil
+}
+
+// rememberAuditHead stores the anchor in the local cache unless a newer one
+// is already stored. Errors are only reported as a warning.
+func rememberAuditHead(repo *repository.Repository, a auditAnchor) {
+	if repo.Cache == nil {
+		return
+	}
+
+	old, err := loadAuditAnchor(repo)
+	if err == nil && old != nil && old.Sequence > a.Sequence {
+		return
+	}
+
+	buf, err := json.Marshal(a)
+	if err == nil {
+		err = repo.Cache.WriteExtraFile(auditHeadCacheName, buf)
+	}
+	if err != nil {
+		Warnf("unable to remember the audit head: %v\n", err)
+	}
+}
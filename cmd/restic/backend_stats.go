@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/backend/metrics"
+	"github.com/restic/restic/internal/ui"
+)
+
+type backendStatsOperation struct {
+	Operation  string  `json:"operation"`
+	Requests   uint64  `json:"requests"`
+	Errors     uint64  `json:"errors"`
+	Bytes      uint64  `json:"bytes"`
+	LatencyP50 float64 `json:"latency_p50"`
+	LatencyP90 float64 `json:"latency_p90"`
+	LatencyP99 float64 `json:"latency_p99"`
+	LatencyMax float64 `json:"latency_max"`
+}
+
+type backendStatsBackend struct {
+	Location   string                  `json:"location"`
+	Operations []backendStatsOperation `json:"operations"`
+}
+
+type backendStatsSummary struct {
+	MessageType string                `json:"message_type"` // "backend_stats"
+	Backends    []backendStatsBackend `json:"backends"`
+	Retries     map[string]uint64     `json:"retries"`
+}
+
+// retryOperation returns the name of the operation from a message of the
+// retry backend, e.g. "Save(<data/1234>)".
+func retryOperation(msg string) string {
+	op, _, _ := strings.Cut(msg, "(")
+	return op
+}
+
+// printBackendStats prints the statistics of all backend requests to stderr.
+func printBackendStats(c *metrics.Collector, jsonOutput bool) {
+	summary := c.Summary()
+	retries := c.Retries()
+
+	if jsonOutput {
+		msg := backendStatsSummary{MessageType: "backend_stats", Backends: []backendStatsBackend{}, Retries: retries}
+		for _, be := range summary {
+			b := backendStatsBackend{Location: be.Name}
+			for _, op := range be.Operations {
+				b.Operations = append(b.Operations, backendStatsOperation{
+					Operation:  op.Operation,
+					Requests:   op.Requests,
+					Errors:     op.Errors,
+					Bytes:      op.Bytes,
+					LatencyP50: op.LatencyP50.Seconds(),
+					LatencyP90: op.LatencyP90.Seconds(),
+					LatencyP99: op.LatencyP99.Seconds(),
+					LatencyMax: op.LatencyMax.Seconds(),
+				})
+			}
+			msg.Backends = append(msg.Backends, b)
+		}
+		if err := json.NewEncoder(globalOptions.stderr).Encode(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write to stderr: %v\n", err)
+		}
+		return
+	}
+
+	latency := func(d time.Duration) string {
+		if d < time.Millisecond {
+			return d.Round(time.Microsecond).String()
+		}
+		return d.Round(100 * time.Microsecond).String()
+	}
+
+	Warnf("\nbackend statistics:\n")
+	for _, be := range summary {
+		Warnf("  %v\n", be.Name)
+		Warnf("    %-10s %9s %7s %11s %10s %10s %10s %10s\n", "operation", "requests", "errors", "data", "p50", "p90", "p99", "max")
+		for _, op := range be.Operations {
+			Warnf("    %-10s %9d %7d %11s %10s %10s %10s %10s\n", op.Operation, op.Requests, op.Errors, ui.FormatBytes(op.Bytes),
+				latency(op.LatencyP50), latency(op.LatencyP90), latency(op.LatencyP99), latency(op.LatencyMax))
+		}
+	}
+
+	if len(retries) > 0 {
+		var ops []string
+		for op, n := range retries {
+			ops = append(ops, fmt.Sprintf("%v %d", op, n))
+		}
+		sort.Strings(ops)
+		Warnf("  retries: %v\n", strings.Join(ops, ", "))
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+// groupTag returns the tag added to the snapshots of a path group.
+func groupTag(name string) string {
+	return "path-group:" + name
+}
+
+// due returns whether a new snapshot of the group is due at now, given the
+// time of its latest snapshot. A tolerance of a twentieth of the interval
+// prevents skipping a group if the backup is run slightly early, for example
+// by a timer with a random delay.
+func (g profileGroup) due(latest, now time.Time) bool {
+	if g.interval.Zero() {
+		return true
+	}
+
+	d := g.interval
+	next := latest.AddDate(d.Years, d.Months, d.Days).Add(time.Hour * time.Duration(d.Hours))
+	tolerance := next.Sub(latest) / 20
+	return !now.Before(next.Add(-tolerance))
+}
+
+// runBackupGroups creates a separate snapshot for each selected path group of
+// the profile. With --due, only the groups whose interval has elapsed since
+// their latest snapshot are backed up.
+func runBackupGroups(ctx context.Context, opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
+	if len(args) > 0 || len(opts.FilesFrom) > 0 || len(opts.FilesFromVerbatim) > 0 || len(opts.FilesFromRaw) > 0 {
+		return errors.Fatal("--path-group or --due was specified and files/dirs were listed as arguments")
+	}
+	if opts.Stdin || opts.SourceShare != "" || opts.Watch {
+		return errors.Fatal("--path-group and --due cannot be used together with --stdin, --source-share or --watch")
+	}
+	if gopts.Profile == "" {
+		return errors.Fatal("--path-group and --due require a profile defining the groups (--profile)")
+	}
+
+	groups, err := loadProfileGroups(gopts.ConfigFile, gopts.Profile)
+	if err != nil {
+		return err
+	}
+	if len(opts.PathGroups) > 0 {
+		groups, err = selectGroups(groups, opts.PathGroups)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Due {
+		groups, err = dueGroups(ctx, opts, gopts, groups, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	opts.PathGroups, opts.Due = nil, false
+
+	var firstErr error
+	for _, g := range groups {
+		groupOpts := opts
+		groupOpts.Tags = append(append(restic.TagLists{}, opts.Tags...), restic.TagList{groupTag(g.Name)})
+
+		Verbosef("backing up path group %v\n", g.Name)
+		err := runBackupWithHooks(ctx, groupOpts, gopts, term, g.Paths)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			Warnf("backup of path group %v failed: %v\n", g.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// selectGroups returns the groups with the given names.
+func selectGroups(groups []profileGroup, names []string) ([]profileGroup, error) {
+	byName := make(map[string]profileGroup, len(groups))
+	for _, g := range groups {
+		byName[g.Name] = g
+	}
+
+	selected := make([]profileGroup, 0, len(names))
+	for _, name := range names {
+		g, ok := byName[name]
+		if !ok {
+			return nil, errors.Fatalf("path group %q not found in profile", name)
+		}
+		selected = append(selected, g)
+	}
+	return selected, nil
+}
+
+// dueGroups returns the groups for which a new snapshot is due, based on the
+// latest snapshot of each group created by this host.
+func dueGroups(ctx context.Context, opts BackupOptions, gopts GlobalOptions, groups []profileGroup, now time.Time) ([]profileGroup, error) {
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	latest := make(map[string]time.Time)
+	err = restic.ForAllSnapshots(ctx, repo.Backend(), repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if opts.Host != "" && sn.Hostname != opts.Host {
+			return nil
+		}
+		for _, g := range groups {
+			if sn.HasTags([]string{groupTag(g.Name)}) && sn.Time.After(latest[g.Name]) {
+				latest[g.Name] = sn.Time
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var due []profileGroup
+	for _, g := range groups {
+		t, ok := latest[g.Name]
+		if ok && !g.due(t, now) {
+			Verbosef("skipping path group %v, the latest snapshot was created at %v\n", g.Name, t.Local().Format(TimeFormat))
+			continue
+		}
+		due = append(due, g)
+	}
+	return due, nil
+}
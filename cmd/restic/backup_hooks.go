@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+// Values for --pre-hook-on-failure and --post-hook-on-failure.
+const (
+	hookFailureAbort = "abort"
+	hookFailureWarn  = "warn"
+)
+
+func checkHookFailure(flag, value string) error {
+	switch value {
+	case hookFailureAbort, hookFailureWarn:
+		return nil
+	default:
+		return errors.Fatalf("invalid value for %v: %q, must be %v or %v", flag, value, hookFailureAbort, hookFailureWarn)
+	}
+}
+
+// runHook runs command with the additional environment variables env. The
+// output of the command is passed through, with --json it is written to
+// stderr.
+func runHook(ctx context.Context, gopts GlobalOptions, command string, env []string) error {
+	args, err := backend.SplitShellStrings(command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("empty command")
+	}
+
+	var stdout io.Writer = gopts.stdout
+	if gopts.JSON {
+		stdout = gopts.stderr
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = gopts.stderr
+	return cmd.Run()
+}
+
+// runHooks runs all commands. Depending on failure, a failed command is
+// either returned or reported as warning.
+func runHooks(ctx context.Context, gopts GlobalOptions, name string, commands []string, failure string, env []string) error {
+	for _, command := range commands {
+		err := runHook(ctx, gopts, command, env)
+		if err == nil {
+			continue
+		}
+		err = errors.Fatalf("%v %q failed: %v", name, command, err)
+		if failure == hookFailureAbort {
+			return err
+		}
+		Warnf("%v\n", err)
+	}
+	return nil
+}
+
+// runBackupWithHooks runs the pre hooks, the backup and then the post hooks.
+// The post hooks are also run if the pre hooks or the backup failed, such that
+// e.g. a database is resumed in all cases.
+func runBackupWithHooks(ctx context.Context, opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
+	if len(opts.PreHooks) == 0 && len(opts.PostHooks) == 0 {
+		return runBackup(ctx, opts, gopts, term, args)
+	}
+	if err := opts.Check(gopts, args); err != nil {
+		return err
+	}
+
+	var snapshotID *restic.ID
+	snapshotSaved := opts.snapshotSaved
+	opts.snapshotSaved = func(id restic.ID) {
+		snapshotID = &id
+		if snapshotSaved != nil {
+			snapshotSaved(id)
+		}
+	}
+
+	err := runHooks(ctx, gopts, "pre-hook", opts.PreHooks, opts.PreHookFailure, nil)
+	if err == nil {
+		err = runBackup(ctx, opts, gopts, term, args)
+	}
+
+	result := "success"
+	env := []string{"RESTIC_SNAPSHOT_ID="}
+	switch {
+	case snapshotID != nil && err != nil:
+		result = "incomplete"
+		fallthrough
+	case snapshotID != nil:
+		env[0] += snapshotID.String()
+	case err != nil:
+		result = "failure"
+	}
+	env = append(env, "RESTIC_BACKUP_RESULT="+result)
+	if err != nil {
+		env = append(env, "RESTIC_BACKUP_ERROR="+err.Error())
+	}
+
+	// run the post hooks even if the backup was interrupted
+	hookErr := runHooks(context.Background(), gopts, "post-hook", opts.PostHooks, opts.PostHookFailure, env)
+	if err != nil {
+		return err
+	}
+	return hookErr
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+// runBackupWatch creates a snapshot of the targets and then a new one each
+// time files change. Directories which did not change since the previous
+// snapshot are not read again.
+func runBackupWatch(ctx context.Context, opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
+	err := opts.Check(gopts, args)
+	if err != nil {
+		return err
+	}
+
+	targets, err := collectTargets(opts, args)
+	if err != nil {
+		return err
+	}
+	// all snapshots contain the same targets, even if the files read by
+	// --files-from change
+	opts.FilesFrom, opts.FilesFromVerbatim, opts.FilesFromRaw = nil, nil, nil
+
+	absTargets := make([]string, 0, len(targets))
+	for _, target := range targets {
+		absTarget, err := filepath.Abs(target)
+		if err != nil {
+			return err
+		}
+		absTargets = append(absTargets, absTarget)
+	}
+
+	// start watching before the first snapshot, such that no change is missed
+	watcher, err := fs.NewWatcher(absTargets, func(item string, err error) {
+		Warnf("unable to watch %v: %v\n", item, err)
+	})
+	if err != nil {
+		return errors.Fatalf("unable to watch for changes: %v", err)
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+
+	var lastID *restic.ID
+	opts.snapshotSaved = func(id restic.ID) {
+		lastID = &id
+	}
+
+	// changes since the last snapshot, nil means that all files are read
+	var changes *fs.Changes
+	first := true
+	for {
+		roundOpts := opts
+		if changes != nil {
+			roundOpts.unchangedDir = changes.Unchanged
+		}
+
+		lastID = nil
+		err := runBackupWithHooks(ctx, roundOpts, gopts, term, targets)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if first && err != nil && !errors.Is(err, ErrInvalidSourceData) {
+			return err
+		}
+		first = false
+
+		if lastID != nil {
+			// the next snapshot only has to read what changed since this one
+			opts.Parent = lastID.String()
+			opts.Force = false
+			opts.ParentTags = nil
+			opts.ParentWithin = restic.Duration{}
+			changes = watcher.Take()
+			if errors.Is(err, ErrInvalidSourceData) {
+				// read the files which could not be read again
+				changes = nil
+			}
+		}
+		if err != nil {
+			Warnf("%v, retrying after the next change\n", err)
+		}
+
+		Verbosef("waiting for changes\n")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-watcher.Notify():
+		}
+		// collect further changes to not create a snapshot for each of them
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.WatchDelay):
+		}
+
+		if changes != nil {
+			changes.Merge(watcher.Take())
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// cachePrimer is implemented by the cache backend.
+type cachePrimer interface {
+	Prime(ctx context.Context, t restic.FileType) (int, error)
+}
+
+// primeCache makes sure that the cache contains the trees of the snapshots as
+// well as all index and snapshot files. A following command on this host then
+// does not have to download any metadata to access the snapshots.
+func primeCache(ctx context.Context, repo restic.Repository, trees restic.IDs, quiet bool) error {
+	primer, ok := repo.Backend().(cachePrimer)
+	if !ok {
+		Warnf("not priming the cache, no cache is used\n")
+		return nil
+	}
+
+	var downloaded int
+	for _, t := range []restic.FileType{restic.IndexFile, restic.SnapshotFile} {
+		n, err := primer.Prime(ctx, t)
+		downloaded += n
+		if err != nil {
+			return err
+		}
+	}
+
+	// loading the trees stores the pack files containing them in the cache
+	bar := newProgressMax(!quiet, 0, "trees loaded")
+	err := restic.FindUsedBlobs(ctx, repo, trees, restic.NewBlobSet(), bar)
+	bar.Done()
+	if err != nil {
+		return err
+	}
+
+	Verboseff("downloaded %d index and snapshot files into the cache\n", downloaded)
+	return nil
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/table"
+	"github.com/restic/restic/internal/walker"
+)
+
+var cmdAnalyze = &cobra.Command{
+	Use:   "analyze [flags] [snapshotID ...]",
+	Short: "Show what consumes space in the repository",
+	Long: `
+The "analyze" command walks the selected snapshots and reports how their data
+is deduplicated and compressed. It operates on all snapshots if none are
+specified.
+
+For each snapshot and each host, it shows the logical size of all files, the
+size of the distinct data after deduplication, the deduplication ratio and the
+unique size, which is the stored size of the data not referenced by any other
+analyzed snapshot or host. Removing a snapshot frees at most its unique size.
+
+The report also lists how much of the data was compressible, the directories
+with the most unique data at the depth given by --depth, and the churn between
+consecutive snapshots of the same host and paths, which is the stored size of
+the data added and removed by each snapshot.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAnalyze(cmd.Context(), analyzeOptions, globalOptions, args)
+	},
+}
+
+// AnalyzeOptions collects all options for the analyze command.
+type AnalyzeOptions struct {
+	Top   int
+	Depth int
+
+	restic.SnapshotFilter
+}
+
+var analyzeOptions AnalyzeOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdAnalyze)
+
+	f := cmdAnalyze.Flags()
+	f.IntVar(&analyzeOptions.Top, "top", 10, "show the `n` directories with the most unique data")
+	f.IntVar(&analyzeOptions.Depth, "depth", 2, "group the unique data by directories `n` levels below the root")
+	initMultiSnapshotFilter(f, &analyzeOptions.SnapshotFilter, true)
+}
+
+// AnalyzeSnapshot contains the statistics of a snapshot. DataSize is the size
+// of the distinct file contents, StoredSize additionally includes the trees
+// and is the size of the blobs in the repository.
+type AnalyzeSnapshot struct {
+	ID          *restic.ID `json:"id"`
+	ShortID     string     `json:"short_id"`
+	Time        time.Time  `json:"time"`
+	Hostname    string     `json:"hostname"`
+	Paths       []string   `json:"paths"`
+	LogicalSize uint64     `json:"logical_size"`
+	DataSize    uint64     `json:"data_size"`
+	StoredSize  uint64     `json:"stored_size"`
+	UniqueSize  uint64     `json:"unique_size"`
+	DedupRatio  float64    `json:"dedup_ratio"`
+}
+
+// AnalyzeHost contains the statistics of all snapshots of a host.
+type AnalyzeHost struct {
+	Hostname    string  `json:"hostname"`
+	Snapshots   int     `json:"snapshots"`
+	LogicalSize uint64  `json:"logical_size"`
+	DataSize    uint64  `json:"data_size"`
+	StoredSize  uint64  `json:"stored_size"`
+	UniqueSize  uint64  `json:"unique_size"`
+	DedupRatio  float64 `json:"dedup_ratio"`
+}
+
+// AnalyzeCompression splits the referenced blobs by how well they compress.
+type AnalyzeCompression struct {
+	CompressibleSize         uint64 `json:"compressible_size"`
+	CompressibleStoredSize   uint64 `json:"compressible_stored_size"`
+	IncompressibleSize       uint64 `json:"incompressible_size"`
+	IncompressibleStoredSize uint64 `json:"incompressible_stored_size"`
+	UncompressedSize         uint64 `json:"uncompressed_size"`
+}
+
+// AnalyzeDirectory contains the stored size of the data which is only
+// referenced by files within the directory.
+type AnalyzeDirectory struct {
+	Path       string `json:"path"`
+	UniqueSize uint64 `json:"unique_size"`
+}
+
+// AnalyzeChurn contains the changes between consecutive snapshots.
+type AnalyzeChurn struct {
+	Hostname    string     `json:"hostname"`
+	Paths       []string   `json:"paths"`
+	From        *restic.ID `json:"from"`
+	To          *restic.ID `json:"to"`
+	Time        time.Time  `json:"time"`
+	AddedSize   uint64     `json:"added_size"`
+	RemovedSize uint64     `json:"removed_size"`
+}
+
+// AnalyzeResult is the output of the analyze command.
+type AnalyzeResult struct {
+	Snapshots   []AnalyzeSnapshot  `json:"snapshots"`
+	Hosts       []AnalyzeHost      `json:"hosts"`
+	Compression AnalyzeCompression `json:"compression"`
+	Directories []AnalyzeDirectory `json:"directories"`
+	Churn       []AnalyzeChurn     `json:"churn"`
+}
+
+// sharedBlob marks a blob which is referenced by more than one snapshot, host or
+// directory.
+const sharedBlob = -1
+
+// analyzer accumulates the statistics while walking the snapshots one after
+// another.
+type analyzer struct {
+	repo  restic.Repository
+	depth int
+
+	result AnalyzeResult
+	hosts  map[string]int
+	// hostBlobs contains the blobs referenced by the snapshots of each host
+	hostBlobs []restic.BlobSet
+	dirs      map[string]int
+	dirNames  []string
+
+	// the index of the snapshot, host or directory referencing a blob
+	blobSnapshot map[restic.BlobHandle]int
+	blobHost     map[restic.BlobHandle]int
+	blobDir      map[restic.BlobHandle]int
+
+	// previous contains the blobs of the latest snapshot per host and paths
+	previous map[string]*analyzeGroup
+}
+
+type analyzeGroup struct {
+	id    *restic.ID
+	blobs restic.BlobSet
+}
+
+func newAnalyzer(repo restic.Repository, depth int) *analyzer {
+	return &analyzer{
+		repo:         repo,
+		depth:        depth,
+		hosts:        make(map[string]int),
+		dirs:         make(map[string]int),
+		blobSnapshot: make(map[restic.BlobHandle]int),
+		blobHost:     make(map[restic.BlobHandle]int),
+		blobDir:      make(map[restic.BlobHandle]int),
+		previous:     make(map[string]*analyzeGroup),
+	}
+}
+
+// lookup returns the stored and the uncompressed size of the blob.
+func (a *analyzer) lookup(h restic.BlobHandle) (restic.PackedBlob, error) {
+	pbs := a.repo.Index().Lookup(h)
+	if len(pbs) == 0 {
+		return restic.PackedBlob{}, errors.Errorf("blob %v not found", h)
+	}
+	return pbs[0], nil
+}
+
+// mark records that the blob is referenced by the object idx.
+func mark(m map[restic.BlobHandle]int, h restic.BlobHandle, idx int) {
+	old, ok := m[h]
+	switch {
+	case !ok:
+		m[h] = idx
+	case old != idx:
+		m[h] = sharedBlob
+	}
+}
+
+// dirKey returns the directory at the configured depth which contains the
+// file at nodepath.
+func (a *analyzer) dirKey(nodepath string) int {
+	dir := truncatePath(path.Dir(nodepath), a.depth)
+
+	idx, ok := a.dirs[dir]
+	if !ok {
+		idx = len(a.dirNames)
+		a.dirs[dir] = idx
+		a.dirNames = append(a.dirNames, dir)
+	}
+	return idx
+}
+
+func (a *analyzer) hostIndex(hostname string) int {
+	idx, ok := a.hosts[hostname]
+	if !ok {
+		idx = len(a.result.Hosts)
+		a.hosts[hostname] = idx
+		a.result.Hosts = append(a.result.Hosts, AnalyzeHost{Hostname: hostname})
+		a.hostBlobs = append(a.hostBlobs, restic.NewBlobSet())
+	}
+	return idx
+}
+
+// AddSnapshot walks the snapshot, snapshots must be added ordered by time.
+func (a *analyzer) AddSnapshot(ctx context.Context, sn *restic.Snapshot) error {
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	snIdx := len(a.result.Snapshots)
+	hostIdx := a.hostIndex(sn.Hostname)
+	stats := AnalyzeSnapshot{
+		ID:       sn.ID(),
+		ShortID:  sn.ID().Str(),
+		Time:     sn.Time,
+		Hostname: sn.Hostname,
+		Paths:    sn.Paths,
+	}
+
+	blobs := restic.NewBlobSet()
+	add := func(h restic.BlobHandle, dirIdx int) error {
+		if dirIdx != sharedBlob {
+			mark(a.blobDir, h, dirIdx)
+		}
+		if blobs.Has(h) {
+			return nil
+		}
+		blobs.Insert(h)
+
+		pb, err := a.lookup(h)
+		if err != nil {
+			return err
+		}
+		stats.StoredSize += uint64(pb.Length)
+		if h.Type == restic.DataBlob {
+			stats.DataSize += uint64(pb.DataLength())
+		}
+		mark(a.blobSnapshot, h, snIdx)
+		mark(a.blobHost, h, hostIdx)
+		return nil
+	}
+
+	if err := add(restic.BlobHandle{ID: *sn.Tree, Type: restic.TreeBlob}, sharedBlob); err != nil {
+		return err
+	}
+	err := walker.Walk(ctx, a.repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		if node == nil {
+			return false, nil
+		}
+
+		switch node.Type {
+		case "dir":
+			if node.Subtree != nil {
+				return false, add(restic.BlobHandle{ID: *node.Subtree, Type: restic.TreeBlob}, sharedBlob)
+			}
+		case "file":
+			stats.LogicalSize += node.Size
+			dirIdx := a.dirKey(nodepath)
+			for _, id := range node.Content {
+				if err := add(restic.BlobHandle{ID: id, Type: restic.DataBlob}, dirIdx); err != nil {
+					return false, err
+				}
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Fatalf("unable to walk snapshot %v: %v", sn.ID().Str(), err)
+	}
+	if stats.DataSize > 0 {
+		stats.DedupRatio = float64(stats.LogicalSize) / float64(stats.DataSize)
+	}
+	a.result.Snapshots = append(a.result.Snapshots, stats)
+
+	host := &a.result.Hosts[hostIdx]
+	host.Snapshots++
+	host.LogicalSize += stats.LogicalSize
+	a.hostBlobs[hostIdx].Merge(blobs)
+
+	a.addChurn(sn, blobs)
+	return nil
+}
+
+// addChurn compares the blobs of the snapshot with the previous snapshot of
+// the same host and paths.
+func (a *analyzer) addChurn(sn *restic.Snapshot, blobs restic.BlobSet) {
+	paths := append([]string{}, sn.Paths...)
+	sort.Strings(paths)
+	key := sn.Hostname + "\x00" + strings.Join(paths, "\x00")
+
+	prev, ok := a.previous[key]
+	a.previous[key] = &analyzeGroup{id: sn.ID(), blobs: blobs}
+	if !ok {
+		return
+	}
+
+	churn := AnalyzeChurn{
+		Hostname: sn.Hostname,
+		Paths:    sn.Paths,
+		From:     prev.id,
+		To:       sn.ID(),
+		Time:     sn.Time,
+	}
+	for h := range blobs {
+		if !prev.blobs.Has(h) {
+			pb, _ := a.lookup(h)
+			churn.AddedSize += uint64(pb.Length)
+		}
+	}
+	for h := range prev.blobs {
+		if !blobs.Has(h) {
+			pb, _ := a.lookup(h)
+			churn.RemovedSize += uint64(pb.Length)
+		}
+	}
+	a.result.Churn = append(a.result.Churn, churn)
+}
+
+// Finish computes the unique sizes and returns the result.
+func (a *analyzer) Finish(top int) (*AnalyzeResult, error) {
+	res := &a.result
+
+	for i, blobs := range a.hostBlobs {
+		host := &res.Hosts[i]
+		for h := range blobs {
+			pb, err := a.lookup(h)
+			if err != nil {
+				return nil, err
+			}
+			host.StoredSize += uint64(pb.Length)
+			if h.Type == restic.DataBlob {
+				host.DataSize += uint64(pb.DataLength())
+			}
+		}
+		if host.DataSize > 0 {
+			host.DedupRatio = float64(host.LogicalSize) / float64(host.DataSize)
+		}
+	}
+
+	dirs := make([]AnalyzeDirectory, len(a.dirNames))
+	for i, name := range a.dirNames {
+		dirs[i].Path = name
+	}
+
+	for h, snIdx := range a.blobSnapshot {
+		pb, err := a.lookup(h)
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(pb.Length)
+
+		if snIdx != sharedBlob {
+			res.Snapshots[snIdx].UniqueSize += size
+		}
+		if hostIdx := a.blobHost[h]; hostIdx != sharedBlob {
+			res.Hosts[hostIdx].UniqueSize += size
+		}
+		if dirIdx, ok := a.blobDir[h]; ok && dirIdx != sharedBlob {
+			dirs[dirIdx].UniqueSize += size
+		}
+
+		// a blob is compressible if it is smaller than its encrypted plaintext
+		plain := uint64(pb.DataLength())
+		switch {
+		case !pb.IsCompressed():
+			res.Compression.UncompressedSize += size
+		case size < uint64(crypto.CiphertextLength(int(plain))):
+			res.Compression.CompressibleSize += plain
+			res.Compression.CompressibleStoredSize += size
+		default:
+			res.Compression.IncompressibleSize += plain
+			res.Compression.IncompressibleStoredSize += size
+		}
+	}
+
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return dirs[i].UniqueSize > dirs[j].UniqueSize
+	})
+	if len(dirs) > top {
+		dirs = dirs[:top]
+	}
+	res.Directories = dirs
+	sort.Slice(res.Hosts, func(i, j int) bool {
+		return res.Hosts[i].Hostname < res.Hosts[j].Hostname
+	})
+	return res, nil
+}
+
+func runAnalyze(ctx context.Context, opts AnalyzeOptions, gopts GlobalOptions, args []string) error {
+	if opts.Depth < 0 {
+		return errors.Fatal("--depth must not be negative")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshotLister, err := backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	var snapshots []*restic.Snapshot
+	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, args) {
+		snapshots = append(snapshots, sn)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	if !gopts.JSON {
+		Verbosef("analyzing %d snapshots\n", len(snapshots))
+	}
+	a := newAnalyzer(repo, opts.Depth)
+	for _, sn := range snapshots {
+		if err := a.AddSnapshot(ctx, sn); err != nil {
+			return err
+		}
+	}
+	res, err := a.Finish(opts.Top)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		return json.NewEncoder(gopts.stdout).Encode(res)
+	}
+	return printAnalyzeResult(gopts, res)
+}
+
+func formatRatio(r float64) string {
+	return fmt.Sprintf("%.2fx", r)
+}
+
+func printAnalyzeResult(gopts GlobalOptions, res *AnalyzeResult) error {
+	type sizeRow struct {
+		ID, Time, Host, Snapshots, Logical, Data, Ratio, Unique string
+	}
+
+	tab := table.New()
+	tab.AddColumn("ID", "{{ .ID }}")
+	tab.AddColumn("Time", "{{ .Time }}")
+	tab.AddColumn("Host", "{{ .Host }}")
+	tab.AddColumn("Logical", "{{ .Logical }}")
+	tab.AddColumn("Data", "{{ .Data }}")
+	tab.AddColumn("Dedup", "{{ .Ratio }}")
+	tab.AddColumn("Unique", "{{ .Unique }}")
+	for _, sn := range res.Snapshots {
+		tab.AddRow(sizeRow{
+			ID:      sn.ShortID,
+			Time:    sn.Time.Local().Format(TimeFormat),
+			Host:    sn.Hostname,
+			Logical: ui.FormatBytes(sn.LogicalSize),
+			Data:    ui.FormatBytes(sn.DataSize),
+			Ratio:   formatRatio(sn.DedupRatio),
+			Unique:  ui.FormatBytes(sn.UniqueSize),
+		})
+	}
+	tab.AddFooter(fmt.Sprintf("%d snapshots", len(res.Snapshots)))
+	if err := tab.Write(gopts.stdout); err != nil {
+		return err
+	}
+
+	Printf("\n")
+	tab = table.New()
+	tab.AddColumn("Host", "{{ .Host }}")
+	tab.AddColumn("Snapshots", "{{ .Snapshots }}")
+	tab.AddColumn("Logical", "{{ .Logical }}")
+	tab.AddColumn("Data", "{{ .Data }}")
+	tab.AddColumn("Dedup", "{{ .Ratio }}")
+	tab.AddColumn("Unique", "{{ .Unique }}")
+	for _, host := range res.Hosts {
+		tab.AddRow(sizeRow{
+			Host:      host.Hostname,
+			Snapshots: fmt.Sprint(host.Snapshots),
+			Logical:   ui.FormatBytes(host.LogicalSize),
+			Data:      ui.FormatBytes(host.DataSize),
+			Ratio:     formatRatio(host.DedupRatio),
+			Unique:    ui.FormatBytes(host.UniqueSize),
+		})
+	}
+	if err := tab.Write(gopts.stdout); err != nil {
+		return err
+	}
+
+	c := res.Compression
+	Printf("\nCompression:\n")
+	Printf("    compressible:  %v stored as %v\n", ui.FormatBytes(c.CompressibleSize), ui.FormatBytes(c.CompressibleStoredSize))
+	Printf("  incompressible:  %v stored as %v\n", ui.FormatBytes(c.IncompressibleSize), ui.FormatBytes(c.IncompressibleStoredSize))
+	if c.UncompressedSize > 0 {
+		Printf("    uncompressed:  %v\n", ui.FormatBytes(c.UncompressedSize))
+	}
+
+	if len(res.Directories) > 0 {
+		Printf("\n")
+		tab = table.New()
+		tab.AddColumn("Directory", "{{ .Path }}")
+		tab.AddColumn("Unique", "{{ .Unique }}")
+		type dirRow struct{ Path, Unique string }
+		for _, dir := range res.Directories {
+			tab.AddRow(dirRow{Path: dir.Path, Unique: ui.FormatBytes(dir.UniqueSize)})
+		}
+		if err := tab.Write(gopts.stdout); err != nil {
+			return err
+		}
+	}
+
+	if len(res.Churn) > 0 {
+		Printf("\n")
+		tab = table.New()
+		tab.AddColumn("From", "{{ .From }}")
+		tab.AddColumn("To", "{{ .To }}")
+		tab.AddColumn("Host", "{{ .Host }}")
+		tab.AddColumn("Added", "{{ .Added }}")
+		tab.AddColumn("Removed", "{{ .Removed }}")
+		type churnRow struct{ From, To, Host, Added, Removed string }
+		for _, ch := range res.Churn {
+			tab.AddRow(churnRow{
+				From:    ch.From.Str(),
+				To:      ch.To.Str(),
+				Host:    ch.Hostname,
+				Added:   ui.FormatBytes(ch.AddedSize),
+				Removed: ui.FormatBytes(ch.RemovedSize),
+			})
+		}
+		if err := tab.Write(gopts.stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/table"
+	"github.com/spf13/cobra"
+)
+
+var cmdAudit = &cobra.Command{
+	Use:   "audit [flags]",
+	Short: "List the audit log of destructive operations",
+	Long: `
+The "audit" command lists the audit log stored in the repository. An entry is
+recorded for each destructive operation, like removing snapshots via "forget",
+removing data via "prune", adding or removing keys and rewriting snapshots.
+
+Each entry references the entry written before it. If entries were removed
+from the repository, the gaps are reported. The newest entry is referenced by
+the encrypted audit head, so removing the newest entries is reported as well.
+The audit head is also remembered in the local cache, which allows detecting
+that the audit log in the repository was rolled back to an older state. The
+sequence number and ID of the audit head are printed and can be recorded
+outside of the repository for the same purpose.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAudit(cmd.Context(), globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdAudit)
+}
+
+// AuditEntry helps to print audit entries as JSON with their ID included.
+type AuditEntry struct {
+	*restic.AuditEntry
+
+	ID      *restic.ID `json:"id"`
+	ShortID string     `json:"short_id"`
+}
+
+func runAudit(ctx context.Context, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the audit command expects no arguments")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := restic.LoadAllAuditEntries(ctx, repo.Backend(), repo)
+	if err != nil {
+		return err
+	}
+
+	head, _, err := restic.LoadAuditHead(ctx, repo.Backend(), repo)
+	if err != nil {
+		return err
+	}
+
+	missing := restic.FindAuditGaps(entries)
+	for _, id := range missing {
+		Warnf("audit entry %v is referenced but missing, it may have been removed\n", id.Str())
+	}
+
+	problems := len(missing)
+	if err := restic.CheckAuditHead(entries, head); err != nil {
+		Warnf("%v\n", err)
+		problems++
+	}
+
+	anchor, err := loadAuditAnchor(repo)
+	if err != nil {
+		Warnf("ignoring the audit head stored in the cache: %v\n", err)
+	}
+	if anchor != nil && (head == nil || head.Sequence < anchor.Sequence) {
+		Warnf("the audit log was rolled back, entry %v with sequence number %d was seen before\n",
+			anchor.Latest.Str(), anchor.Sequence)
+		problems++
+	}
+	if problems == 0 && head != nil {
+		rememberAuditHead(repo, auditAnchor{Latest: head.Latest, Sequence: head.Sequence})
+	}
+
+	if gopts.JSON {
+		list := make([]AuditEntry, 0, len(entries))
+		for _, e := range entries {
+			list = append(list, AuditEntry{AuditEntry: e, ID: e.ID(), ShortID: e.ID().Str()})
+		}
+		return json.NewEncoder(gopts.stdout).Encode(list)
+	}
+
+	tab := table.New()
+	tab.AddColumn("ID", "{{ .ID }}")
+	tab.AddColumn("Time", "{{ .Timestamp }}")
+	tab.AddColumn("User", "{{ .User }}")
+	tab.AddColumn("Operation", "{{ .Operation }}")
+	tab.AddColumn("Details", "{{ .Details }}")
+
+	type auditRow struct {
+		ID        string
+		Timestamp string
+		User      string
+		Operation string
+		Details   string
+	}
+
+	for _, e := range entries {
+		details := e.Details
+		if len(e.Snapshots) > 0 {
+			ids := make([]string, 0, len(e.Snapshots))
+			for _, id := range e.Snapshots {
+				ids = append(ids, id.Str())
+			}
+			if details != "" {
+				details += ": "
+			}
+			details += "snapshots " + strings.Join(ids, ", ")
+		}
+
+		tab.AddRow(auditRow{
+			ID:        e.ID().Str(),
+			Timestamp: e.Time.Local().Format(TimeFormat),
+			User:      e.Username + "@" + e.Hostname,
+			Operation: e.Operation,
+			Details:   details,
+		})
+	}
+	footer := fmt.Sprintf("%d entries", len(entries))
+	if head != nil {
+		footer += fmt.Sprintf(", head %v with sequence number %d", head.Latest.Str(), head.Sequence)
+	}
+	tab.AddFooter(footer)
+
+	err = tab.Write(gopts.stdout)
+	if err != nil {
+		return err
+	}
+
+	if problems > 0 {
+		return errors.Fatal("the audit log is incomplete, entries have been removed")
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"os"
@@ -19,6 +20,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/location"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
@@ -80,36 +82,89 @@ Exit status is 3 if some source data could not be read (incomplete snapshot crea
 		stdioWrapper := ui.NewStdioWrapper(term)
 		globalOptions.stdout, globalOptions.stderr = stdioWrapper.Stdout(), stdioWrapper.Stderr()
 
-		return runBackup(ctx, backupOptions, globalOptions, term, args)
+		if len(backupOptions.PathGroups) > 0 || backupOptions.Due {
+			return runBackupGroups(ctx, backupOptions, globalOptions, term, args)
+		}
+		if backupOptions.Watch {
+			return runBackupWatch(ctx, backupOptions, globalOptions, term, args)
+		}
+		return runBackupWithHooks(ctx, backupOptions, globalOptions, term, args)
 	},
 }
 
 // BackupOptions bundles all options for the backup command.
 type BackupOptions struct {
 	excludePatternOptions
-
-	Parent            string
-	GroupBy           restic.SnapshotGroupByOptions
-	Force             bool
-	ExcludeOtherFS    bool
-	ExcludeIfPresent  []string
-	ExcludeCaches     bool
-	ExcludeLargerThan string
-	Stdin             bool
-	StdinFilename     string
-	Tags              restic.TagLists
-	Host              string
-	FilesFrom         []string
-	FilesFromVerbatim []string
-	FilesFromRaw      []string
-	TimeStamp         string
-	WithAtime         bool
-	IgnoreInode       bool
-	IgnoreCtime       bool
-	UseFsSnapshot     bool
-	DryRun            bool
-	ReadConcurrency   uint
-	NoScan            bool
+	secondaryRepoOptions
+
+	Parent             string
+	ParentTags         restic.TagLists
+	ParentWithin       restic.Duration
+	GroupBy            restic.SnapshotGroupByOptions
+	Force              bool
+	ExcludeOtherFS     bool
+	ExcludeIfPresent   []string
+	ExcludeCaches      bool
+	ExcludeLargerThan  string
+	ExcludeSmallerThan string
+	ExcludeOlderThan   restic.Duration
+	ExcludeOwners      []string
+	ExcludeIfCommand   string
+	ExcludeMIME        []string
+	ExcludeGitignore   bool
+	GitignoreFiles     []string
+	PolicyFile         string
+	Stdin              bool
+	StdinFilename      string
+	StdinFormat        string
+	Tags               restic.TagLists
+	Host               string
+	FilesFrom          []string
+	FilesFromVerbatim  []string
+	FilesFromRaw       []string
+	TimeStamp          string
+	WithAtime          bool
+	UnpackLayers       bool
+	UnpackAllLevels    bool
+	BundleSmallerThan  string
+	DataKeys           bool
+	IgnoreInode        bool
+	IgnoreCtime        bool
+	UseFsSnapshot      bool
+	DryRun             bool
+	ReadConcurrency    uint
+	NoScan             bool
+	SigningKeyFile     string
+	ReadResticMounts   bool
+	PrimeCache         bool
+	CompactIndex       uint
+	FileHashCache      bool
+
+	Description string
+	Annotations []string
+	RecordEnv   []string
+
+	AdditionalRepos         []string
+	AdditionalPasswordFiles []string
+
+	SourceShare             string
+	SourceShareOptions      string
+	SourceSharePasswordFile string
+
+	Watch      bool
+	WatchDelay time.Duration
+
+	PathGroups []string
+	Due        bool
+
+	PreHooks        []string
+	PostHooks       []string
+	PreHookFailure  string
+	PostHookFailure string
+
+	// set by runBackupWatch for the following snapshots
+	unchangedDir  func(target string) bool
+	snapshotSaved func(id restic.ID)
 }
 
 var backupOptions BackupOptions
@@ -122,6 +177,8 @@ func init() {
 
 	f := cmdBackup.Flags()
 	f.StringVar(&backupOptions.Parent, "parent", "", "use this parent `snapshot` (default: latest snapshot in the group determined by --group-by and not newer than the timestamp determined by --time)")
+	f.Var(&backupOptions.ParentTags, "parent-tag", "use the latest snapshot with the `tags` (in the format `tag[,tag,...]`) as parent instead of the one in the group determined by --group-by (can be specified multiple times)")
+	f.Var(&backupOptions.ParentWithin, "parent-within", "only use a parent snapshot which is newer than `duration` (e.g. 7d) relative to the timestamp of the backup, otherwise read all files")
 	backupOptions.GroupBy = restic.SnapshotGroupByOptions{Host: true, Path: true}
 	f.VarP(&backupOptions.GroupBy, "group-by", "g", "`group` snapshots by host, paths and/or tags, separated by comma (disable grouping with '')")
 	f.BoolVarP(&backupOptions.Force, "force", "f", false, `force re-reading the target files/directories (overrides the "parent" flag)`)
@@ -132,8 +189,17 @@ func init() {
 	f.StringArrayVar(&backupOptions.ExcludeIfPresent, "exclude-if-present", nil, "takes `filename[:header]`, exclude contents of directories containing filename (except filename itself) if header of that file is as provided (can be specified multiple times)")
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See https://bford.info/cachedir/ for the Cache Directory Tagging Standard`)
 	f.StringVar(&backupOptions.ExcludeLargerThan, "exclude-larger-than", "", "max `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringVar(&backupOptions.ExcludeSmallerThan, "exclude-smaller-than", "", "min `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.Var(&backupOptions.ExcludeOlderThan, "exclude-older-than", "exclude files which were last modified longer than `duration` (e.g. 2y5m7d3h) ago")
+	f.StringArrayVar(&backupOptions.ExcludeOwners, "exclude-owner", nil, "exclude files and directories owned by `user` (name or uid, can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.ExcludeMIME, "exclude-mime", nil, "exclude files whose content matches the MIME `type` (e.g. video/*, can be specified multiple times)")
+	f.StringVar(&backupOptions.ExcludeIfCommand, "exclude-if-command", "", "ask the long-running `command` whether to exclude items, it receives one path per line on stdin and must reply with a line containing exclude or include")
+	f.StringArrayVar(&backupOptions.GitignoreFiles, "exclude-file-gitignore", nil, "read exclude patterns in gitignore syntax, relative to the backup targets, from a `file` (can be specified multiple times)")
+	f.BoolVar(&backupOptions.ExcludeGitignore, "exclude-gitignore", false, "exclude items which are ignored by the .gitignore files in the directories containing them")
+	f.StringVar(&backupOptions.PolicyFile, "policy-file", "", "apply the excludes and options listed in files with this `name`, e.g. .resticpolicy, to the directories containing them")
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
 	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin")
+	f.StringVar(&backupOptions.StdinFormat, "stdin-format", "raw", "`format` of the data read from stdin: raw stores a single file, tar stores the files contained in a tar archive")
 	f.Var(&backupOptions.Tags, "tag", "add `tags` for the new snapshot in the format `tag[,tag,...]` (can be specified multiple times)")
 	f.UintVar(&backupOptions.ReadConcurrency, "read-concurrency", 0, "read `n` files concurrently (default: $RESTIC_READ_CONCURRENCY or 2)")
 	f.StringVarP(&backupOptions.Host, "host", "H", "", "set the `hostname` for the snapshot manually. To prevent an expensive rescan use the \"parent\" flag")
@@ -148,10 +214,36 @@ func init() {
 	f.StringArrayVar(&backupOptions.FilesFromRaw, "files-from-raw", nil, "read the files to backup from `file` (can be combined with file args; can be specified multiple times)")
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "`time` of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
+	f.BoolVar(&backupOptions.UnpackLayers, "unpack-layers", false, "store gzip compressed tar files such as docker image layers decompressed for better deduplication")
+	f.BoolVar(&backupOptions.UnpackAllLevels, "unpack-layers-all-levels", false, "try all gzip compression levels to reproduce layers, which is considerably slower (requires --unpack-layers)")
+	f.BoolVar(&backupOptions.DataKeys, "data-keys", false, "encrypt the contents of each file with a data key of its own, which allows erasing the file later")
+	f.StringVar(&backupOptions.BundleSmallerThan, "bundle-smaller-than", "", "store files smaller than `size` together with other small files of the same directory (allowed suffixes: k/K, m/M)")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number changes when checking for modified files")
 	f.BoolVar(&backupOptions.IgnoreCtime, "ignore-ctime", false, "ignore ctime changes when checking for modified files")
+	f.BoolVar(&backupOptions.FileHashCache, "file-hash-cache", false, "detect modified files with unchanged contents using hashes stored in the local cache")
 	f.BoolVarP(&backupOptions.DryRun, "dry-run", "n", false, "do not upload or write any data, just show what would be done")
 	f.BoolVar(&backupOptions.NoScan, "no-scan", false, "do not run scanner to estimate size of backup")
+	f.StringVar(&backupOptions.SourceShare, "source-share", "", "mount the network share at `location` (smb://[user@]host/share[/path] or nfs://host/export) and back it up")
+	f.StringVar(&backupOptions.SourceShareOptions, "source-share-options", "", "additional `options` passed to mount for --source-share")
+	f.StringVar(&backupOptions.SourceSharePasswordFile, "source-share-password-file", "", "`file` to read the password for --source-share from (default: $RESTIC_SHARE_PASSWORD)")
+	f.StringVar(&backupOptions.SigningKeyFile, "signing-key", "", "sign the snapshot with the Ed25519 private key in PEM `file` (default: $RESTIC_SIGNING_KEY_FILE)")
+	f.BoolVar(&backupOptions.ReadResticMounts, "read-restic-mounts", false, "read files in snapshots mounted by restic instead of reusing the data stored in the mounted repository")
+	initSecondaryRepoOptions(f, &backupOptions.secondaryRepoOptions, "source", "to copy the data of mounted snapshots from")
+	f.BoolVar(&backupOptions.PrimeCache, "prime-cache", false, "store the metadata of the new snapshot in the local cache, such that following commands do not have to download it")
+	f.StringVar(&backupOptions.Description, "description", "", "add the free-text `description` to the new snapshot")
+	f.StringArrayVar(&backupOptions.Annotations, "annotation", nil, "add the annotation `key=value` to the new snapshot (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.RecordEnv, "record-env", nil, "record the value of the environment variable `name` in the snapshot, e.g. the ID of a CI job (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.AdditionalRepos, "additional-repo", nil, "also save the snapshot to `repository`, files are only read once (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.AdditionalPasswordFiles, "additional-password-file", nil, "`file` to read the password of the corresponding --additional-repo from (default: password of the repository, can be specified multiple times)")
+	f.BoolVar(&backupOptions.Watch, "watch", false, "keep running and create a new snapshot whenever files change, only the changed directories are read again")
+	f.DurationVar(&backupOptions.WatchDelay, "watch-delay", 30*time.Second, "with --watch, wait `duration` after the first change before creating the next snapshot")
+	f.StringArrayVar(&backupOptions.PathGroups, "path-group", nil, "back up the path group `name` of the profile as a separate snapshot (can be specified multiple times)")
+	f.BoolVar(&backupOptions.Due, "due", false, "only back up the path groups of the profile whose interval has elapsed since their latest snapshot")
+	f.StringArrayVar(&backupOptions.PreHooks, "pre-hook", nil, "run `command` before the backup (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.PostHooks, "post-hook", nil, "run `command` after the backup, also if it failed (can be specified multiple times)")
+	f.StringVar(&backupOptions.PreHookFailure, "pre-hook-on-failure", hookFailureAbort, "`action` if a pre-hook fails: abort the backup or warn and continue")
+	f.StringVar(&backupOptions.PostHookFailure, "post-hook-on-failure", hookFailureWarn, "`action` if a post-hook fails: abort with an error or warn and continue")
+	f.UintVar(&backupOptions.CompactIndex, "compact-index", 50, "merge small index files into larger ones after the backup once there are at least `n` of them (0 disables it)")
 	if runtime.GOOS == "windows" {
 		f.BoolVar(&backupOptions.UseFsSnapshot, "use-fs-snapshot", false, "use filesystem snapshot where possible (currently only Windows VSS)")
 	}
@@ -159,6 +251,74 @@ func init() {
 	// parse read concurrency from env, on error the default value will be used
 	readConcurrency, _ := strconv.ParseUint(os.Getenv("RESTIC_READ_CONCURRENCY"), 10, 32)
 	backupOptions.ReadConcurrency = uint(readConcurrency)
+
+	backupOptions.SigningKeyFile = os.Getenv("RESTIC_SIGNING_KEY_FILE")
+}
+
+const fileHashCacheName = "filehashes"
+
+// loadFileHashCache loads the file hash cache from the cache directory of the
+// repository. A missing or damaged file results in an empty cache.
+func loadFileHashCache(repo *repository.Repository) (*archiver.FileHashCache, error) {
+	if repo.Cache == nil {
+		return nil, errors.Fatal("--file-hash-cache requires the local cache, which is disabled or could not be opened")
+	}
+
+	c := archiver.NewFileHashCache(repo)
+	buf, err := repo.Cache.ReadExtraFile(fileHashCacheName)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err == nil {
+		err = c.Load(bytes.NewReader(buf))
+	}
+	if err != nil {
+		Warnf("ignoring the file hash cache: %v\n", err)
+		return archiver.NewFileHashCache(repo), nil
+	}
+	return c, nil
+}
+
+func saveFileHashCache(repo *repository.Repository, c *archiver.FileHashCache) error {
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		return err
+	}
+	return repo.Cache.WriteExtraFile(fileHashCacheName, buf.Bytes())
+}
+
+// chainLookupContent returns a function for Archiver.LookupContent which
+// tries first and then second. first may be nil.
+func chainLookupContent(first, second func(context.Context, string, os.FileInfo) (*restic.Node, error)) func(context.Context, string, os.FileInfo) (*restic.Node, error) {
+	if first == nil {
+		return second
+	}
+	return func(ctx context.Context, target string, fi os.FileInfo) (*restic.Node, error) {
+		node, err := first(ctx, target, fi)
+		if node != nil || err != nil {
+			return node, err
+		}
+		return second(ctx, target, fi)
+	}
+}
+
+// compactIndexAfterBackup merges the small index files of the repository.
+// This removes index files and thus requires an exclusive lock. If another
+// process is using the repository, the compaction is skipped.
+func compactIndexAfterBackup(ctx context.Context, gopts GlobalOptions, repo *repository.Repository) error {
+	lock, ctx, err := tryLockRepoExclusive(ctx, repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		if !gopts.JSON {
+			Verbosef("repository is in use, not compacting the index\n")
+		}
+		return nil
+	}
+
+	return compactIndexFiles(ctx, gopts, repo, repo.SmallIndexes())
 }
 
 // filterExisting returns a slice of all existing items, or an error if no
@@ -301,11 +461,123 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 		if len(args) > 0 {
 			return errors.Fatal("--stdin was specified and files/dirs were listed as arguments")
 		}
+		if opts.SourceShare != "" {
+			return errors.Fatal("--stdin and --source-share cannot be used together")
+		}
+		if opts.UnpackLayers {
+			return errors.Fatal("--stdin and --unpack-layers cannot be used together")
+		}
+		if opts.FileHashCache {
+			return errors.Fatal("--stdin and --file-hash-cache cannot be used together")
+		}
+		if opts.PolicyFile != "" {
+			return errors.Fatal("--stdin and --policy-file cannot be used together")
+		}
+		if opts.ExcludeIfCommand != "" {
+			return errors.Fatal("--stdin and --exclude-if-command cannot be used together")
+		}
+	}
+	switch opts.StdinFormat {
+	case "", "raw":
+	case "tar":
+		if !opts.Stdin {
+			return errors.Fatal("--stdin-format=tar can only be used together with --stdin")
+		}
+	default:
+		return errors.Fatalf("invalid value for --stdin-format: %q, must be raw or tar", opts.StdinFormat)
+	}
+	if len(opts.AdditionalPasswordFiles) > len(opts.AdditionalRepos) {
+		return errors.Fatal("more --additional-password-file than --additional-repo options specified")
+	}
+	if len(opts.AdditionalRepos) > 0 && opts.FileHashCache {
+		return errors.Fatal("--additional-repo and --file-hash-cache cannot be used together")
+	}
+	if opts.DataKeys {
+		if len(opts.AdditionalRepos) > 0 {
+			return errors.Fatal("--additional-repo and --data-keys cannot be used together")
+		}
+		if opts.StdinFormat == "tar" {
+			return errors.Fatal("--stdin-format=tar and --data-keys cannot be used together")
+		}
+	}
+	if opts.UseFsSnapshot && opts.FileHashCache {
+		return errors.Fatal("--use-fs-snapshot and --file-hash-cache cannot be used together")
+	}
+	if opts.UnpackAllLevels && !opts.UnpackLayers {
+		return errors.Fatal("--unpack-layers-all-levels requires --unpack-layers")
+	}
+
+	if len(opts.PreHooks) > 0 {
+		if err := checkHookFailure("--pre-hook-on-failure", opts.PreHookFailure); err != nil {
+			return err
+		}
+	}
+	if len(opts.PostHooks) > 0 {
+		if err := checkHookFailure("--post-hook-on-failure", opts.PostHookFailure); err != nil {
+			return err
+		}
+	}
+
+	if opts.Watch {
+		if opts.Stdin {
+			return errors.Fatal("--stdin and --watch cannot be used together")
+		}
+		if opts.SourceShare != "" {
+			return errors.Fatal("--source-share and --watch cannot be used together")
+		}
+		if opts.DryRun {
+			return errors.Fatal("--dry-run and --watch cannot be used together")
+		}
+		if opts.TimeStamp != "" {
+			return errors.Fatal("--time and --watch cannot be used together")
+		}
+		if opts.WatchDelay < 0 {
+			return errors.Fatal("--watch-delay must not be negative")
+		}
+	}
+
+	if opts.Parent != "" {
+		if len(opts.ParentTags) > 0 {
+			return errors.Fatal("--parent and --parent-tag cannot be used together")
+		}
+		if !opts.ParentWithin.Zero() {
+			return errors.Fatal("--parent and --parent-within cannot be used together")
+		}
+	}
+
+	if opts.SourceShare != "" {
+		if len(args) > 0 || len(opts.FilesFrom) > 0 || len(opts.FilesFromVerbatim) > 0 || len(opts.FilesFromRaw) > 0 {
+			return errors.Fatal("--source-share was specified and files/dirs were listed as arguments")
+		}
+	}
+
+	if _, err := opts.bundleThreshold(); err != nil {
+		return err
+	}
+	if _, err := parseAnnotations(opts.Annotations); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// bundleThreshold returns the size below which files are bundled, or zero if
+// bundling is disabled.
+func (opts BackupOptions) bundleThreshold() (uint64, error) {
+	if opts.BundleSmallerThan == "" {
+		return 0, nil
+	}
+
+	size, err := parseSizeStr(opts.BundleSmallerThan)
+	if err != nil {
+		return 0, errors.Fatalf("invalid --bundle-smaller-than: %v", err)
+	}
+	if size < 0 || uint64(size) > archiver.MaxBundleThreshold {
+		return 0, errors.Fatalf("--bundle-smaller-than must be at most %d KiB", archiver.MaxBundleThreshold/1024)
+	}
+	return uint64(size), nil
+}
+
 // collectRejectByNameFuncs returns a list of all functions which may reject data
 // from being saved in a snapshot based on path only
 func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectByNameFunc, err error) {
@@ -353,13 +625,50 @@ func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets
 		fs = append(fs, f)
 	}
 
-	if len(opts.ExcludeLargerThan) != 0 && !opts.Stdin {
+	if (opts.ExcludeGitignore || len(opts.GitignoreFiles) > 0) && !opts.Stdin {
+		g, err := newGitignoreExcludes(opts.GitignoreFiles, opts.ExcludeGitignore, targets)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, g.Reject)
+	}
+
+	// the files of a tar archive have a size, unlike the raw data from stdin
+	if len(opts.ExcludeLargerThan) != 0 && (!opts.Stdin || opts.StdinFormat == "tar") {
 		f, err := rejectBySize(opts.ExcludeLargerThan)
 		if err != nil {
 			return nil, err
 		}
 		fs = append(fs, f)
 	}
+	if len(opts.ExcludeSmallerThan) != 0 && (!opts.Stdin || opts.StdinFormat == "tar") {
+		f, err := rejectBySmallerSize(opts.ExcludeSmallerThan)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	if !opts.ExcludeOlderThan.Zero() && (!opts.Stdin || opts.StdinFormat == "tar") {
+		fs = append(fs, rejectByAge(opts.ExcludeOlderThan, time.Now()))
+	}
+
+	if len(opts.ExcludeOwners) > 0 && !opts.Stdin {
+		f, err := rejectByOwner(opts.ExcludeOwners)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	// reads the start of each file, thus check it last
+	if len(opts.ExcludeMIME) > 0 && !opts.Stdin {
+		f, err := rejectByMIME(opts.ExcludeMIME)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
 
 	return fs, nil
 }
@@ -431,6 +740,43 @@ func collectTargets(opts BackupOptions, args []string) (targets []string, err er
 	return targets, nil
 }
 
+// openAdditionalRepo opens the repository at location. The password is read
+// from passwordFile, if it is empty the password of the main repository is
+// used.
+func openAdditionalRepo(ctx context.Context, gopts GlobalOptions, location, passwordFile string) (*repository.Repository, error) {
+	addGopts := gopts
+	addGopts.Repo = location
+	addGopts.RepositoryFile = ""
+	addGopts.KeyHint = ""
+	if passwordFile != "" {
+		addGopts.PasswordFile = passwordFile
+		addGopts.PasswordCommand = ""
+		addGopts.IdentityFile = ""
+		var err error
+		addGopts.password, err = resolvePassword(addGopts, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return OpenRepository(ctx, addGopts)
+}
+
+// saveAdditionalSnapshot saves a copy of the snapshot sn with the ID id to
+// repo, which already contains all data of the snapshot.
+func saveAdditionalSnapshot(ctx context.Context, repo restic.Repository, sn *restic.Snapshot, id restic.ID, signingKey ed25519.PrivateKey) (restic.ID, error) {
+	snCopy := *sn
+	// the parent is only known in the main repository
+	snCopy.Parent = nil
+	// allows the copy command to detect that both snapshots are identical
+	snCopy.Original = &id
+	if signingKey != nil {
+		if err := snCopy.Sign(signingKey); err != nil {
+			return restic.ID{}, err
+		}
+	}
+	return restic.SaveSnapshot(ctx, repo, &snCopy)
+}
+
 // parent returns the ID of the parent snapshot. If there is none, nil is
 // returned.
 func findParentSnapshot(ctx context.Context, repo restic.Repository, opts BackupOptions, targets []string, timeStampLimit time.Time) (*restic.Snapshot, error) {
@@ -449,7 +795,9 @@ func findParentSnapshot(ctx context.Context, repo restic.Repository, opts Backup
 	if opts.GroupBy.Path {
 		f.Paths = targets
 	}
-	if opts.GroupBy.Tag {
+	if len(opts.ParentTags) > 0 {
+		f.Tags = opts.ParentTags
+	} else if opts.GroupBy.Tag {
 		f.Tags = []restic.TagList{opts.Tags.Flatten()}
 	}
 
@@ -458,7 +806,45 @@ func findParentSnapshot(ctx context.Context, repo restic.Repository, opts Backup
 	if opts.Parent == "" && errors.Is(err, restic.ErrNoSnapshotFound) {
 		err = nil
 	}
-	return sn, err
+	if err != nil || sn == nil {
+		return sn, err
+	}
+
+	if !opts.ParentWithin.Zero() {
+		within := opts.ParentWithin
+		cutoff := timeStampLimit.AddDate(-within.Years, -within.Months, -within.Days).Add(time.Hour * time.Duration(-within.Hours))
+		if sn.Time.Before(cutoff) {
+			Verbosef("latest snapshot %v is older than %v, reading all files\n", sn.ID().Str(), within)
+			return nil, nil
+		}
+	}
+	return sn, nil
+}
+
+// newSnapshotInvocation describes the current invocation of restic with the
+// command line args. Passwords contained in repository locations are removed.
+func newSnapshotInvocation(args []string, recordEnv []string) *restic.SnapshotInvocation {
+	inv := &restic.SnapshotInvocation{ProgramVersion: version}
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(name, "-") {
+			arg = name + "=" + location.StripPassword(value)
+		} else {
+			arg = location.StripPassword(arg)
+		}
+		inv.CommandLine = append(inv.CommandLine, arg)
+	}
+
+	for _, name := range recordEnv {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if inv.Environment == nil {
+			inv.Environment = make(map[string]string)
+		}
+		inv.Environment[name] = value
+	}
+	return inv
 }
 
 func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
@@ -467,11 +853,35 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		return err
 	}
 
+	var sourceShare string
+	if opts.SourceShare != "" {
+		share, target, unmount, err := mountShare("--source-share", opts.SourceShare, opts.SourceShareOptions, opts.SourceSharePasswordFile, false)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := unmount(); err != nil {
+				Warnf("%v\n", err)
+			}
+		}()
+
+		sourceShare = share.String()
+		args = []string{target}
+	}
+
 	targets, err := collectTargets(opts, args)
 	if err != nil {
 		return err
 	}
 
+	var signingKey ed25519.PrivateKey
+	if opts.SigningKeyFile != "" {
+		signingKey, err = loadSigningKey(opts.SigningKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	timeStamp := time.Now()
 	if opts.TimeStamp != "" {
 		timeStamp, err = time.ParseInLocation(TimeFormat, opts.TimeStamp, time.Local)
@@ -506,12 +916,51 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 	if !gopts.JSON {
 		progressPrinter.V("lock repository")
 	}
+	// the context is cancelled once the lock is released
+	unlockedCtx := ctx
 	lock, ctx, err := lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
 	defer unlockRepo(lock)
 	if err != nil {
 		return err
 	}
 
+	var additionalRepos []*repository.Repository
+	for i, location := range opts.AdditionalRepos {
+		var passwordFile string
+		if i < len(opts.AdditionalPasswordFiles) {
+			passwordFile = opts.AdditionalPasswordFiles[i]
+		}
+		addRepo, err := openAdditionalRepo(ctx, gopts, location, passwordFile)
+		if err != nil {
+			return err
+		}
+		if opts.DryRun {
+			addRepo.SetDryRun()
+		}
+		var addLock *restic.Lock
+		addLock, ctx, err = lockRepo(ctx, addRepo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(addLock)
+		if err != nil {
+			return err
+		}
+		if addRepo.Config().BlobHash != repo.Config().BlobHash {
+			// the blob IDs of the main repository are reused for all repositories
+			return errors.Fatalf("repository %v uses a different blob hash algorithm", location)
+		}
+		if addRepo.Config().ChunkerPolynomial != repo.Config().ChunkerPolynomial {
+			Warnf("repository %v uses different chunker parameters, deduplication with its existing data may not work\n", location)
+		}
+		additionalRepos = append(additionalRepos, addRepo)
+	}
+
+	// the excludes stored in the repository apply to all backups
+	if excludes := repo.Config().Defaults["exclude"]; len(excludes) > 0 {
+		opts.Excludes = append(append([]string{}, opts.Excludes...), excludes...)
+	}
+	if gopts.IgnoreCase {
+		opts.excludePatternOptions = opts.excludePatternOptions.ignoreCase()
+	}
+
 	// rejectByNameFuncs collect functions that can reject items from the backup based on path only
 	rejectByNameFuncs, err := collectRejectByNameFuncs(opts, repo, targets)
 	if err != nil {
@@ -524,6 +973,30 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		return err
 	}
 
+	var policies *policyFiles
+	if opts.PolicyFile != "" {
+		policies, err = newPolicyFiles(opts.PolicyFile, targets)
+		if err != nil {
+			return err
+		}
+		rejectByNameFuncs = append(rejectByNameFuncs, policies.RejectByName)
+		rejectFuncs = append(rejectFuncs, policies.Reject)
+	}
+
+	// the helper is consulted last, for items not excluded by other means
+	if opts.ExcludeIfCommand != "" {
+		excludeCmd, err := startExcludeCommand(ctx, gopts, opts.ExcludeIfCommand)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := excludeCmd.Close(); err != nil {
+				Warnf("%v\n", err)
+			}
+		}()
+		rejectByNameFuncs = append(rejectByNameFuncs, excludeCmd.RejectByName)
+	}
+
 	var parentSnapshot *restic.Snapshot
 	if !opts.Stdin {
 		parentSnapshot, err = findParentSnapshot(ctx, repo, opts, targets, timeStamp)
@@ -547,6 +1020,54 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 	if err != nil {
 		return err
 	}
+	// the blobs are saved to all repositories at once
+	var archRepo restic.Repository = repo
+	if len(additionalRepos) > 0 {
+		others := make([]restic.Repository, 0, len(additionalRepos))
+		for _, addRepo := range additionalRepos {
+			if err := addRepo.LoadIndex(ctx); err != nil {
+				return err
+			}
+			others = append(others, addRepo)
+		}
+		archRepo = repository.NewMultiRepository(repo, others...)
+
+		// unchanged files are only skipped if their data is stored in all
+		// repositories, e.g. not after adding another repository
+		if parentSnapshot != nil && !archRepo.Index().Has(restic.BlobHandle{ID: *parentSnapshot.Tree, Type: restic.TreeBlob}) {
+			if !gopts.JSON {
+				progressPrinter.P("parent snapshot is missing in an additional repository, will read all files\n")
+			}
+			parentSnapshot = nil
+		}
+	}
+
+	var mountSource *archiver.MountSource
+	if !opts.Stdin && !opts.ReadResticMounts && !opts.UseFsSnapshot {
+		var srcRepo restic.Repository
+		if opts.secondaryRepoOptions.Repo != "" || opts.secondaryRepoOptions.RepositoryFile != "" {
+			srcGopts, _, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "source")
+			if err != nil {
+				return err
+			}
+			srcRepo, err = OpenRepository(ctx, srcGopts)
+			if err != nil {
+				return err
+			}
+			if !gopts.NoLock {
+				var srcLock *restic.Lock
+				srcLock, ctx, err = lockRepo(ctx, srcRepo, gopts.RetryLock, gopts.JSON)
+				defer unlockRepo(srcLock)
+				if err != nil {
+					return err
+				}
+			}
+			if err = srcRepo.LoadIndex(ctx); err != nil {
+				return err
+			}
+		}
+		mountSource = archiver.NewMountSource(archRepo, srcRepo)
+	}
 
 	selectByNameFilter := func(item string) bool {
 		for _, reject := range rejectByNameFuncs {
@@ -586,7 +1107,8 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		defer localVss.DeleteSnapshots()
 		targetFS = localVss
 	}
-	if opts.Stdin {
+	stdinTar := opts.Stdin && opts.StdinFormat == "tar"
+	if opts.Stdin && !stdinTar {
 		if !gopts.JSON {
 			progressPrinter.V("read data from stdin")
 		}
@@ -604,7 +1126,8 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 	cancelCtx, cancel := context.WithCancel(wgCtx)
 	defer cancel()
 
-	if !opts.NoScan {
+	// a tar archive from stdin can only be read once, thus it is not scanned
+	if !opts.NoScan && !stdinTar {
 		sc := archiver.NewScanner(targetFS)
 		sc.SelectByName = selectByNameFilter
 		sc.Select = selectFilter
@@ -617,18 +1140,77 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		wg.Go(func() error { return sc.Scan(cancelCtx, targets) })
 	}
 
-	arch := archiver.New(repo, targetFS, archiver.Options{ReadConcurrency: backupOptions.ReadConcurrency})
+	// the nodes are saved to all repositories, thus each of them must support
+	// the features used to store them
+	requireCapability := func(name, feature string) error {
+		if err := repo.Config().RequireCapability(name, feature); err != nil {
+			return err
+		}
+		for i, addRepo := range additionalRepos {
+			if err := addRepo.Config().RequireCapability(name, feature); err != nil {
+				return errors.Fatalf("repository %v: %v", opts.AdditionalRepos[i], err)
+			}
+		}
+		return nil
+	}
+
+	arch := archiver.New(archRepo, targetFS, archiver.Options{ReadConcurrency: backupOptions.ReadConcurrency})
 	arch.SelectByName = selectByNameFilter
 	arch.Select = selectFilter
 	arch.WithAtime = opts.WithAtime
+	arch.UnpackLayers = opts.UnpackLayers
+	arch.UnpackLayersAllLevels = opts.UnpackAllLevels
+	if opts.UnpackLayers {
+		// older versions of restic would restore the decoded contents
+		if err := requireCapability(restic.CapabilityContentEncoding, "--unpack-layers"); err != nil {
+			return err
+		}
+	}
+	arch.BundleThreshold, err = opts.bundleThreshold()
+	if err != nil {
+		return err
+	}
+	if arch.BundleThreshold > 0 {
+		// older versions of restic would restore the whole bundle for each file
+		if err := requireCapability(restic.CapabilityFileBundles, "--bundle-smaller-than"); err != nil {
+			return err
+		}
+	}
+	if opts.DataKeys {
+		// older versions of restic must not read the encrypted contents
+		if err := repo.Config().RequireCapability(restic.CapabilityDataKeys, "--data-keys"); err != nil {
+			return err
+		}
+		arch.DataKeys = repo
+	}
 	success := true
 	arch.Error = func(item string, err error) error {
 		success = false
+		if errors.IsFatal(err) {
+			// e.g. a snapshot of an unknown repository mounted by restic
+			return err
+		}
 		return progressReporter.Error(item, err)
 	}
 	arch.CompleteItem = progressReporter.CompleteItem
 	arch.StartFile = progressReporter.StartFile
 	arch.CompleteBlob = progressReporter.CompleteBlob
+	arch.UnchangedDir = opts.unchangedDir
+	if policies != nil {
+		arch.Reread = policies.Reread
+	}
+	if mountSource != nil {
+		arch.LookupContent = mountSource.Lookup
+	}
+	var hashCache *archiver.FileHashCache
+	if opts.FileHashCache {
+		hashCache, err = loadFileHashCache(repo)
+		if err != nil {
+			return err
+		}
+		arch.LookupContent = chainLookupContent(arch.LookupContent, hashCache.Lookup)
+		arch.CompleteFileHash = hashCache.Add
+	}
 
 	if opts.IgnoreInode {
 		// --ignore-inode implies --ignore-ctime: on FUSE, the ctime is not
@@ -639,18 +1221,37 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		arch.ChangeIgnoreFlags |= archiver.ChangeIgnoreCtime
 	}
 
+	annotations, err := parseAnnotations(opts.Annotations)
+	if err != nil {
+		return err
+	}
 	snapshotOpts := archiver.SnapshotOptions{
+		Description:    opts.Description,
+		Annotations:    annotations,
 		Excludes:       opts.Excludes,
 		Tags:           opts.Tags.Flatten(),
 		Time:           timeStamp,
 		Hostname:       opts.Host,
 		ParentSnapshot: parentSnapshot,
+		SourceShare:    sourceShare,
+		SigningKey:     signingKey,
+		Invocation:     newSnapshotInvocation(os.Args, opts.RecordEnv),
+		Summary:        progressReporter.SnapshotSummary,
 	}
 
-	if !gopts.JSON {
-		progressPrinter.V("start backup on %v", targets)
+	var sn *restic.Snapshot
+	var id restic.ID
+	if stdinTar {
+		if !gopts.JSON {
+			progressPrinter.V("read tar archive from stdin")
+		}
+		sn, id, err = arch.SnapshotTar(ctx, os.Stdin, opts.StdinFilename, snapshotOpts)
+	} else {
+		if !gopts.JSON {
+			progressPrinter.V("start backup on %v", targets)
+		}
+		sn, id, err = arch.Snapshot(ctx, targets, snapshotOpts)
 	}
-	_, id, err := arch.Snapshot(ctx, targets, snapshotOpts)
 
 	// cleanly shutdown all running goroutines
 	cancel()
@@ -668,6 +1269,46 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 	if !gopts.JSON && !opts.DryRun {
 		progressPrinter.P("snapshot %s saved\n", id.Str())
 	}
+	if !opts.DryRun {
+		updateSnapshotCatalog(ctx, repo, restic.IDs{id}, nil)
+		if opts.snapshotSaved != nil {
+			opts.snapshotSaved(id)
+		}
+	}
+	for i, addRepo := range additionalRepos {
+		if opts.DryRun {
+			break
+		}
+		addID, err := saveAdditionalSnapshot(ctx, addRepo, sn, id, signingKey)
+		if err != nil {
+			return errors.Fatalf("unable to save snapshot to %v: %v", opts.AdditionalRepos[i], err)
+		}
+		if !gopts.JSON {
+			progressPrinter.P("snapshot %s saved to %v\n", addID.Str(), opts.AdditionalRepos[i])
+		}
+		updateSnapshotCatalog(ctx, addRepo, restic.IDs{addID}, nil)
+	}
+	if hashCache != nil && !opts.DryRun {
+		// the hashes only speed up the next backup, thus only warn
+		if err := saveFileHashCache(repo, hashCache); err != nil {
+			Warnf("unable to save the file hash cache: %v\n", err)
+		}
+	}
+	if opts.PrimeCache && !opts.DryRun {
+		if !gopts.JSON {
+			progressPrinter.V("priming cache")
+		}
+		// the snapshot is already saved, thus only warn
+		if err := primeCache(ctx, repo, restic.IDs{*sn.Tree}, gopts.Quiet || gopts.JSON); err != nil {
+			Warnf("unable to prime the cache: %v\n", err)
+		}
+	}
+	if opts.CompactIndex > 0 && !opts.DryRun && uint(len(repo.SmallIndexes())) >= opts.CompactIndex {
+		unlockRepo(lock)
+		if err := compactIndexAfterBackup(unlockedCtx, gopts, repo); err != nil {
+			Warnf("unable to compact the index: %v\n", err)
+		}
+	}
 	if !success {
 		return ErrInvalidSourceData
 	}
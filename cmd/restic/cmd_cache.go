@@ -10,7 +10,6 @@ import (
 
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/errors"
-	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/ui"
 	"github.com/restic/restic/internal/ui/table"
 	"github.com/spf13/cobra"
@@ -42,8 +41,38 @@ type CacheOptions struct {
 
 var cacheOptions CacheOptions
 
+var cmdCachePrune = &cobra.Command{
+	Use:   "prune --max-size size",
+	Short: "Shrink local cache directories",
+	Long: `
+The "cache prune" command removes the least recently used files from the cache
+directory of each repository until it uses at most the size specified with
+--max-size.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCachePrune(cachePruneOptions, globalOptions, args)
+	},
+}
+
+// CachePruneOptions bundles all options for the cache prune command.
+type CachePruneOptions struct {
+	MaxSize string
+}
+
+var cachePruneOptions CachePruneOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdCache)
+	cmdCache.AddCommand(cmdCachePrune)
+
+	pf := cmdCachePrune.Flags()
+	pf.StringVar(&cachePruneOptions.MaxSize, "max-size", "", "shrink the cache of each repository to at most `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
 
 	f := cmdCache.Flags()
 	f.BoolVar(&cacheOptions.Cleanup, "cleanup", false, "remove old cache directories")
@@ -51,25 +80,27 @@ func init() {
 	f.BoolVar(&cacheOptions.NoSize, "no-size", false, "do not output the size of the cache directories")
 }
 
-func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
-	if len(args) > 0 {
-		return errors.Fatal("the cache command expects no arguments, only options - please see `restic help cache` for usage and flags")
+// cacheBaseDir returns the directory which contains the caches of all
+// repositories.
+func cacheBaseDir(gopts GlobalOptions) (string, error) {
+	if gopts.NoCache {
+		return "", errors.Fatal("Refusing to do anything, the cache is disabled")
 	}
 
-	if gopts.NoCache {
-		return errors.Fatal("Refusing to do anything, the cache is disabled")
+	if gopts.CacheDir != "" {
+		return gopts.CacheDir, nil
 	}
+	return cache.DefaultDir()
+}
 
-	var (
-		cachedir = gopts.CacheDir
-		err      error
-	)
+func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the cache command expects no arguments, only options - please see `restic help cache` for usage and flags")
+	}
 
-	if cachedir == "" {
-		cachedir, err = cache.DefaultDir()
-		if err != nil {
-			return err
-		}
+	cachedir, err := cacheBaseDir(gopts)
+	if err != nil {
+		return err
 	}
 
 	if opts.Cleanup || gopts.CleanupCache {
@@ -87,9 +118,11 @@ func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
 
 		for _, item := range oldDirs {
 			dir := filepath.Join(cachedir, item.Name())
-			err = fs.RemoveAll(dir)
+			removed, err := cache.RemoveDir(dir)
 			if err != nil {
 				Warnf("unable to remove %v: %v\n", dir, err)
+			} else if !removed {
+				Verbosef("not removing %v, it is in use by another process\n", dir)
 			}
 		}
 
@@ -161,6 +194,45 @@ func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
 	return nil
 }
 
+func runCachePrune(opts CachePruneOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the cache prune command expects no arguments, only options - please see `restic help cache prune` for usage and flags")
+	}
+
+	if opts.MaxSize == "" {
+		return errors.Fatal("--max-size is required")
+	}
+	maxSize, err := parseSizeStr(opts.MaxSize)
+	if err != nil {
+		return errors.Fatalf("invalid --max-size: %v", err)
+	}
+
+	cachedir, err := cacheBaseDir(gopts)
+	if err != nil {
+		return err
+	}
+
+	dirs, err := cache.All(cachedir)
+	if err != nil {
+		return err
+	}
+
+	var removed int
+	var freed int64
+	for _, entry := range dirs {
+		dir := filepath.Join(cachedir, entry.Name())
+		n, size, err := cache.PruneDir(dir, maxSize)
+		if err != nil {
+			Warnf("unable to prune %v: %v\n", dir, err)
+		}
+		removed += n
+		freed += size
+	}
+
+	Verbosef("removed %d files from %d cache dirs, freed %v\n", removed, len(dirs), ui.FormatBytes(uint64(freed)))
+	return nil
+}
+
 func dirSize(path string) (int64, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/spf13/cobra"
+)
+
+var cmdCacheWarm = &cobra.Command{
+	Use:   "warm [flags] [snapshotID ...]",
+	Short: "Download the metadata of snapshots into the local cache",
+	Long: `
+The "cache warm" command downloads the index, the snapshots and the directory
+metadata (trees) of the selected snapshots into the local cache. Afterwards,
+commands like "mount", "ls" or "restore" can browse these snapshots without
+downloading metadata from the repository, which is useful for backends with a
+high latency. Without arguments, the trees of all snapshots are downloaded.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCacheWarm(cmd.Context(), cacheWarmOptions, globalOptions, args)
+	},
+}
+
+// CacheWarmOptions bundles all options for the cache warm command.
+type CacheWarmOptions struct {
+	restic.SnapshotFilter
+}
+
+var cacheWarmOptions CacheWarmOptions
+
+func init() {
+	cmdCache.AddCommand(cmdCacheWarm)
+
+	f := cmdCacheWarm.Flags()
+	initMultiSnapshotFilter(f, &cacheWarmOptions.SnapshotFilter, true)
+}
+
+func runCacheWarm(ctx context.Context, opts CacheWarmOptions, gopts GlobalOptions, args []string) error {
+	if gopts.NoCache {
+		return errors.Fatal("Refusing to do anything, the cache is disabled")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+	if repo.Cache == nil {
+		return errors.Fatal("unable to warm the cache, the cache could not be opened")
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshotLister, err := backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	var trees restic.IDs
+	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, args) {
+		trees = append(trees, *sn.Tree)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(trees) == 0 {
+		return errors.Fatal("no matching snapshots found")
+	}
+
+	Verbosef("loading the trees of %d snapshots\n", len(trees))
+	return primeCache(ctx, repo, trees, gopts.Quiet || gopts.JSON)
+}
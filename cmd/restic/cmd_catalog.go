@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/spf13/cobra"
+)
+
+var cmdCatalog = &cobra.Command{
+	Use:   "catalog [flags]",
+	Short: "Create or remove the snapshot catalog",
+	Long: `
+The "catalog" command creates a catalog which summarizes all snapshots of the
+repository in a single file. Commands which load all snapshots, for example
+"snapshots" or "forget", then only download the catalog instead of every
+snapshot file, which is much faster for repositories with many snapshots on
+backends with a high latency.
+
+Once the catalog exists, "backup" and "forget" update it. Snapshots created
+or removed by other commands or by older versions of restic are still handled
+correctly, but are loaded separately until the catalog is recreated by running
+this command again.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCatalog(cmd.Context(), catalogOptions, globalOptions, args)
+	},
+}
+
+// CatalogOptions bundles all options for the catalog command.
+type CatalogOptions struct {
+	Remove bool
+}
+
+var catalogOptions CatalogOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdCatalog)
+
+	f := cmdCatalog.Flags()
+	f.BoolVar(&catalogOptions.Remove, "remove", false, "remove the snapshot catalog from the repository")
+}
+
+func runCatalog(ctx context.Context, opts CatalogOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the catalog command expects no arguments")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, ctx, err := lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	if opts.Remove {
+		Verbosef("removing snapshot catalog\n")
+		return repo.RemoveSnapshotCatalog(ctx)
+	}
+
+	n, err := repo.CreateSnapshotCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	Verbosef("created snapshot catalog for %d snapshots\n", n)
+	return nil
+}
+
+// updateSnapshotCatalog updates the snapshot catalog, if the repository uses
+// one. The snapshots are already saved or removed at this point, thus failing
+// to update the catalog only results in a warning.
+func updateSnapshotCatalog(ctx context.Context, repo *repository.Repository, added restic.IDs, removed restic.IDs) {
+	err := repo.UpdateSnapshotCatalog(ctx, added, removed)
+	if err != nil {
+		Warnf("unable to update the snapshot catalog: %v\n", err)
+	}
+}
@@ -2,19 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"fmt"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -48,6 +54,11 @@ type CheckOptions struct {
 	ReadDataSubset string
 	CheckUnused    bool
 	WithCache      bool
+
+	VerifySignatures bool
+	TrustedKeys      []string
+
+	DuplicateSnapshots bool
 }
 
 var checkOptions CheckOptions
@@ -66,9 +77,15 @@ func init() {
 		panic(err)
 	}
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use existing cache, only read uncached data from repository")
+	f.BoolVar(&checkOptions.VerifySignatures, "verify-signatures", false, "verify that all snapshots are signed by a trusted key")
+	f.StringArrayVar(&checkOptions.TrustedKeys, "trusted-key", nil, "Ed25519 public key in PEM `file` to trust for --verify-signatures (can be specified multiple times)")
+	f.BoolVar(&checkOptions.DuplicateSnapshots, "duplicate-snapshots", false, "report snapshots with the same or almost the same content as their predecessor")
 }
 
 func checkFlags(opts CheckOptions) error {
+	if opts.VerifySignatures && len(opts.TrustedKeys) == 0 {
+		return errors.Fatal("check flag --verify-signatures requires at least one --trusted-key")
+	}
 	if opts.ReadData && opts.ReadDataSubset != "" {
 		return errors.Fatal("check flags --read-data and --read-data-subset cannot be used together")
 	}
@@ -197,6 +214,15 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		return errors.Fatal("the check command expects no arguments, only options - please see `restic help check` for usage and flags")
 	}
 
+	var trustedKeys []ed25519.PublicKey
+	if opts.VerifySignatures {
+		var err error
+		trustedKeys, err = loadVerifyKeys(opts.TrustedKeys)
+		if err != nil {
+			return err
+		}
+	}
+
 	cleanup := prepareCheckCache(opts, &gopts)
 	AddCleanupHandler(func(code int) (int, error) {
 		cleanup()
@@ -218,8 +244,18 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		}
 	}
 
+	return runCheckWithRepo(ctx, opts, gopts, repo, trustedKeys)
+}
+
+// runCheckWithRepo checks the already locked repository. The snapshot
+// signatures are verified using trustedKeys if opts.VerifySignatures is set.
+func runCheckWithRepo(ctx context.Context, opts CheckOptions, gopts GlobalOptions, repo *repository.Repository, trustedKeys []ed25519.PublicKey) error {
+
+	// check the snapshot files themselves instead of their copy in the catalog
+	repo.DisableSnapshotCatalog()
+
 	chkr := checker.New(repo, opts.CheckUnused)
-	err = chkr.LoadSnapshots(ctx)
+	err := chkr.LoadSnapshots(ctx)
 	if err != nil {
 		return err
 	}
@@ -280,6 +316,14 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		Verbosef("%d additional files were found in the repo, which likely contain duplicate data.\nThis is non-critical, you can run `restic prune` to correct this.\n", orphanedPacks)
 	}
 
+	if repo.Config().Parity != nil {
+		Verbosef("check parity files\n")
+		if err := checkParityFiles(ctx, repo, chkr.GetPacks()); err != nil {
+			errorsFound = true
+			Warnf("error: %v\n", err)
+		}
+	}
+
 	Verbosef("check snapshots, trees and blobs\n")
 	errChan = make(chan error)
 	var wg sync.WaitGroup
@@ -313,6 +357,24 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 	// deadlocking in the case of errors.
 	wg.Wait()
 
+	if opts.VerifySignatures {
+		Verbosef("verify snapshot signatures\n")
+		for _, err := range chkr.VerifySignatures(ctx, trustedKeys) {
+			errorsFound = true
+			Warnf("error: %v\n", err)
+		}
+	}
+
+	if opts.DuplicateSnapshots {
+		Verbosef("search duplicate snapshots\n")
+		dups, err := chkr.DuplicateSnapshots(ctx, nearDuplicateSimilarity)
+		if err != nil {
+			errorsFound = true
+			Warnf("error: %v\n", err)
+		}
+		printDuplicateSnapshots(dups)
+	}
+
 	if opts.CheckUnused {
 		for _, id := range chkr.UnusedBlobs(ctx) {
 			Verbosef("unused blob %v\n", id)
@@ -320,7 +382,18 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		}
 	}
 
+	damagedPacks := restic.NewIDSet()
 	doReadData := func(packs map[restic.ID]int64) {
+		archived, err := removeArchivedPacks(ctx, repo, packs)
+		if err != nil {
+			errorsFound = true
+			Warnf("%v\n", err)
+			return
+		}
+		if archived > 0 {
+			Warnf("skipping %d packs which are in archive storage, their data is not verified\n", archived)
+		}
+
 		packCount := uint64(len(packs))
 
 		p := newProgressMax(!gopts.Quiet, packCount, "packs")
@@ -331,6 +404,10 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		for err := range errChan {
 			errorsFound = true
 			Warnf("%v\n", err)
+			var e *checker.ReadError
+			if errors.As(err, &e) {
+				damagedPacks.Insert(e.ID)
+			}
 		}
 		p.Done()
 	}
@@ -376,6 +453,10 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		doReadData(packs)
 	}
 
+	if len(damagedPacks) > 0 && repo.Config().Parity != nil {
+		printParityRepairable(ctx, repo, damagedPacks)
+	}
+
 	if errorsFound {
 		return errors.Fatal("repository contains errors")
 	}
@@ -385,6 +466,135 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 	return nil
 }
 
+// removeArchivedPacks removes the packs which cannot be read as they are in
+// archive storage and returns their number.
+func removeArchivedPacks(ctx context.Context, repo restic.Repository, packs map[restic.ID]int64) (int, error) {
+	sbe := backend.AsBackend[restic.StagingBackend](repo.Backend())
+	if sbe == nil {
+		return 0, nil
+	}
+
+	var m sync.Mutex
+	var archived restic.IDs
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	ch := make(chan restic.ID)
+	wg.Go(func() error {
+		defer close(ch)
+		for id := range packs {
+			select {
+			case ch <- id:
+			case <-wgCtx.Done():
+				return wgCtx.Err()
+			}
+		}
+		return nil
+	})
+	for i := 0; i < int(repo.Connections()); i++ {
+		wg.Go(func() error {
+			for id := range ch {
+				ok, err := sbe.Archived(wgCtx, restic.Handle{Type: restic.PackFile, Name: id.String()})
+				if err != nil {
+					return errors.Wrapf(err, "pack %v", id.Str())
+				}
+				if ok {
+					m.Lock()
+					archived = append(archived, id)
+					m.Unlock()
+				}
+			}
+			return nil
+		})
+	}
+	err := wg.Wait()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range archived {
+		delete(packs, id)
+	}
+	return len(archived), nil
+}
+
+// checkParityFiles reports pack files without a parity file and parity files
+// without a pack file. Both are non-critical.
+func checkParityFiles(ctx context.Context, repo *repository.Repository, packs map[restic.ID]int64) error {
+	missing := len(packs)
+	orphaned := 0
+	err := repo.List(ctx, restic.ParityFile, func(id restic.ID, _ int64) error {
+		if _, ok := packs[id]; ok {
+			missing--
+		} else {
+			orphaned++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if missing > 0 {
+		Printf("%d pack files have no parity file, you can run `restic parity` to create them.\n", missing)
+	}
+	if orphaned > 0 {
+		Verbosef("%d parity files were found for pack files which no longer exist.\nThis is non-critical, you can run `restic parity` to remove them.\n", orphaned)
+	}
+	return nil
+}
+
+// printParityRepairable reports which of the damaged pack files can be
+// restored using their parity file.
+func printParityRepairable(ctx context.Context, repo *repository.Repository, damaged restic.IDSet) {
+	var repairable []string
+	for id := range damaged {
+		_, restored, err := repo.ReconstructPack(ctx, id)
+		if err != nil {
+			Warnf("%v\n", err)
+			continue
+		}
+		if restored {
+			repairable = append(repairable, id.String())
+		}
+	}
+	if len(repairable) == 0 {
+		return
+	}
+
+	sort.Strings(repairable)
+	Printf("%d damaged pack files can be restored using their parity files, run the following command to restore them:\n", len(repairable))
+	Printf("  restic repair packs %v\n", strings.Join(repairable, " "))
+}
+
+// nearDuplicateSimilarity is the fraction of shared data above which a
+// snapshot is reported as a near duplicate of its predecessor.
+const nearDuplicateSimilarity = 0.99
+
+// printDuplicateSnapshots lists the duplicate snapshots. They are not errors,
+// but waste entries in retention policies.
+func printDuplicateSnapshots(dups []checker.DuplicateSnapshot) {
+	if len(dups) == 0 {
+		Printf("no duplicate snapshots found\n")
+		return
+	}
+
+	Printf("%d snapshots have the same or almost the same content as their predecessor:\n", len(dups))
+	ids := make([]string, 0, len(dups))
+	for _, dup := range dups {
+		desc := "identical to"
+		if dup.Similarity < 1 {
+			desc = fmt.Sprintf("%.1f%% identical to", dup.Similarity*100)
+		}
+		Printf("  %v from %v on %v is %v %v\n", dup.Snapshot.ID().Str(), dup.Snapshot.Time.Format(TimeFormat),
+			dup.Snapshot.Hostname, desc, dup.Previous.ID().Str())
+		ids = append(ids, dup.Snapshot.ID().Str())
+	}
+
+	Printf("Duplicate snapshots are non-critical. Retention policies like --keep-last or --keep-hourly count them\n")
+	Printf("as distinct snapshots, which shortens the history that is kept. Consider backing up less frequently\n")
+	Printf("or remove them using `restic forget %v`.\n", strings.Join(ids, " "))
+}
+
 // selectPacksByBucket selects subsets of packs by ranges of buckets.
 func selectPacksByBucket(allPacks map[restic.ID]int64, bucket, totalBuckets uint) map[restic.ID]int64 {
 	packs := make(map[restic.ID]int64)
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdConfig = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the defaults stored in the repository",
+	Long: `
+The "config" command manages default settings which are stored in the
+repository config and thus apply to all clients using the repository. Options
+passed on the command line take precedence over the stored defaults.
+
+The following options can be stored:
+
+  compression      compression mode used by all commands (auto|off|max)
+  pack-size        target pack size in MiB used by all commands
+  exclude          patterns excluded by backup in addition to those passed
+                   on the command line (multiple values)
+  keep-*           retention policy used by forget if no --keep-* option is
+                   given, e.g. keep-daily or keep-within-weekly (keep-tag
+                   takes multiple values)
+`,
+}
+
+var cmdConfigGet = &cobra.Command{
+	Use:   "get [flags] [name]",
+	Short: "Print the defaults stored in the repository",
+	Long: `
+The "config get" command prints the defaults stored in the repository, or only
+the values of the option with the given name.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigGet(cmd.Context(), globalOptions, args)
+	},
+}
+
+var cmdConfigSet = &cobra.Command{
+	Use:   "set [flags] name value [value...]",
+	Short: "Store a default in the repository",
+	Long: `
+The "config set" command stores the values for the option with the given name
+in the repository, replacing the previously stored values.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigSet(cmd.Context(), globalOptions, args)
+	},
+}
+
+var cmdConfigUnset = &cobra.Command{
+	Use:   "unset [flags] name [name...]",
+	Short: "Remove defaults stored in the repository",
+	Long: `
+The "config unset" command removes the stored values of the options with the
+given names from the repository.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigUnset(cmd.Context(), globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdConfig)
+	cmdConfig.AddCommand(cmdConfigGet, cmdConfigSet, cmdConfigUnset)
+}
+
+func runConfigGet(ctx context.Context, gopts GlobalOptions, args []string) error {
+	if len(args) > 1 {
+		return errors.Fatal("config get takes at most one option name")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	defaults := repo.Config().Defaults
+	if len(args) == 1 {
+		if !isRepoDefault(args[0]) {
+			return errors.Fatalf("unknown option %q", args[0])
+		}
+		values, ok := defaults[args[0]]
+		if !ok {
+			return errors.Fatalf("option %v is not set", args[0])
+		}
+		defaults = map[string][]string{args[0]: values}
+	}
+
+	if gopts.JSON {
+		if defaults == nil {
+			defaults = map[string][]string{}
+		}
+		return json.NewEncoder(gopts.stdout).Encode(defaults)
+	}
+	for _, name := range sortedRepoDefaults(defaults) {
+		for _, value := range defaults[name] {
+			Printf("%v = %v\n", name, value)
+		}
+	}
+	return nil
+}
+
+// updateRepoDefaults locks the repository, passes a copy of the stored
+// defaults to update and then stores the modified defaults.
+func updateRepoDefaults(ctx context.Context, gopts GlobalOptions, update func(defaults map[string][]string)) error {
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, ctx, err := lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	defaults := make(map[string][]string)
+	for name, values := range repo.Config().Defaults {
+		defaults[name] = values
+	}
+	update(defaults)
+	return repo.SetDefaults(ctx, defaults)
+}
+
+func runConfigSet(ctx context.Context, gopts GlobalOptions, args []string) error {
+	if len(args) < 2 {
+		return errors.Fatal("config set requires an option name and at least one value")
+	}
+	name, values := args[0], args[1:]
+	if err := checkRepoDefault(name, values); err != nil {
+		return err
+	}
+
+	err := updateRepoDefaults(ctx, gopts, func(defaults map[string][]string) {
+		defaults[name] = values
+	})
+	if err != nil {
+		return err
+	}
+	Verbosef("stored %v in the repository\n", name)
+	return nil
+}
+
+func runConfigUnset(ctx context.Context, gopts GlobalOptions, args []string) error {
+	if len(args) == 0 {
+		return errors.Fatal("config unset requires at least one option name")
+	}
+	for _, name := range args {
+		if !isRepoDefault(name) {
+			return errors.Fatalf("unknown option %q", name)
+		}
+	}
+
+	return updateRepoDefaults(ctx, gopts, func(defaults map[string][]string) {
+		for _, name := range args {
+			if _, ok := defaults[name]; !ok {
+				Warnf("option %v is not set\n", name)
+				continue
+			}
+			delete(defaults, name)
+			Verbosef("removed %v from the repository\n", name)
+		}
+	})
+}
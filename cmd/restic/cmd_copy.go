@@ -6,6 +6,7 @@ import (
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"golang.org/x/sync/errgroup"
@@ -30,6 +31,14 @@ This means that copied files, which existed in both the source and destination
 repository, /may occupy up to twice their space/ in the destination repository.
 This can be mitigated by the "--copy-chunker-params" option when initializing a
 new destination repository using the "init" command.
+
+The "--rechunk" option splits all files again using the chunker parameters of
+the destination repository, so that the copied files are deduplicated with the
+data already stored there. This requires downloading the complete contents of
+all copied files. The data is compressed and packed according to the settings
+for the destination repository, e.g. "--compression" and "--pack-size". The
+option is also required to copy snapshots between repositories which use
+different blob hash algorithms.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runCopy(cmd.Context(), copyOptions, globalOptions, args)
@@ -40,6 +49,7 @@ new destination repository using the "init" command.
 type CopyOptions struct {
 	secondaryRepoOptions
 	restic.SnapshotFilter
+	Rechunk bool
 }
 
 var copyOptions CopyOptions
@@ -50,6 +60,7 @@ func init() {
 	f := cmdCopy.Flags()
 	initSecondaryRepoOptions(f, &copyOptions.secondaryRepoOptions, "destination", "to copy snapshots from")
 	initMultiSnapshotFilter(f, &copyOptions.SnapshotFilter, true)
+	f.BoolVar(&copyOptions.Rechunk, "rechunk", false, "split files again using the chunker parameters of the destination repository")
 }
 
 func runCopy(ctx context.Context, opts CopyOptions, gopts GlobalOptions, args []string) error {
@@ -87,28 +98,38 @@ func runCopy(ctx context.Context, opts CopyOptions, gopts GlobalOptions, args []
 		return err
 	}
 
+	_, err = copySnapshots(ctx, srcRepo, dstRepo, &opts.SnapshotFilter, args, opts.Rechunk, gopts.Quiet)
+	return err
+}
+
+// copySnapshots copies the snapshots matching filter and args from srcRepo
+// to dstRepo, which are not contained in dstRepo yet. If rechunk is set, the
+// files are split again using the chunker polynomial of dstRepo. Both
+// repositories must be locked, the index is loaded by copySnapshots. It
+// returns the number of copied snapshots.
+func copySnapshots(ctx context.Context, srcRepo, dstRepo restic.Repository, filter *restic.SnapshotFilter, args []string, rechunk bool, quiet bool) (int, error) {
 	srcSnapshotLister, err := backend.MemorizeList(ctx, srcRepo.Backend(), restic.SnapshotFile)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	dstSnapshotLister, err := backend.MemorizeList(ctx, dstRepo.Backend(), restic.SnapshotFile)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	debug.Log("Loading source index")
 	if err := srcRepo.LoadIndex(ctx); err != nil {
-		return err
+		return 0, err
 	}
 
 	debug.Log("Loading destination index")
 	if err := dstRepo.LoadIndex(ctx); err != nil {
-		return err
+		return 0, err
 	}
 
 	dstSnapshotByOriginal := make(map[restic.ID][]*restic.Snapshot)
-	for sn := range FindFilteredSnapshots(ctx, dstSnapshotLister, dstRepo, &opts.SnapshotFilter, nil) {
+	for sn := range FindFilteredSnapshots(ctx, dstSnapshotLister, dstRepo, filter, nil) {
 		if sn.Original != nil && !sn.Original.IsNull() {
 			dstSnapshotByOriginal[*sn.Original] = append(dstSnapshotByOriginal[*sn.Original], sn)
 		}
@@ -118,8 +139,24 @@ func runCopy(ctx context.Context, opts CopyOptions, gopts GlobalOptions, args []
 
 	// remember already processed trees across all snapshots
 	visitedTrees := restic.NewIDSet()
+	copied := 0
+
+	// blobs can only be copied unchanged if their IDs are computed the same way
+	sameBlobHash := srcRepo.Config().BlobHash == dstRepo.Config().BlobHash
+	if !sameBlobHash && !rechunk {
+		return 0, errors.Fatal("the repositories use different blob hash algorithms, copying requires --rechunk")
+	}
 
-	for sn := range FindFilteredSnapshots(ctx, srcSnapshotLister, srcRepo, &opts.SnapshotFilter, args) {
+	var rechunker *rechunker
+	if rechunk {
+		if sameBlobHash && srcRepo.Config().ChunkerPolynomial == dstRepo.Config().ChunkerPolynomial {
+			Verbosef("both repositories use the same chunker polynomial, files are not split again\n")
+		} else {
+			rechunker = newRechunker(srcRepo, dstRepo)
+		}
+	}
+
+	for sn := range FindFilteredSnapshots(ctx, srcSnapshotLister, srcRepo, filter, args) {
 		// check whether the destination has a snapshot with the same persistent ID which has similar snapshot fields
 		srcOriginal := *sn.ID()
 		if sn.Original != nil {
@@ -128,7 +165,12 @@ func runCopy(ctx context.Context, opts CopyOptions, gopts GlobalOptions, args []
 		if originalSns, ok := dstSnapshotByOriginal[srcOriginal]; ok {
 			isCopy := false
 			for _, originalSn := range originalSns {
-				if similarSnapshots(originalSn, sn) {
+				isSimilar := similarSnapshots(originalSn, sn)
+				if rechunker != nil {
+					// the tree of a rechunked copy differs from the original tree
+					isSimilar = similarSnapshotsExceptTree(originalSn, sn)
+				}
+				if isSimilar {
 					Verboseff("\nsnapshot %s of %v at %s)\n", sn.ID().Str(), sn.Paths, sn.Time)
 					Verboseff("skipping source snapshot %s, was already copied to snapshot %s\n", sn.ID().Str(), originalSn.ID().Str())
 					isCopy = true
@@ -141,8 +183,14 @@ func runCopy(ctx context.Context, opts CopyOptions, gopts GlobalOptions, args []
 		}
 		Verbosef("\nsnapshot %s of %v at %s)\n", sn.ID().Str(), sn.Paths, sn.Time)
 		Verbosef("  copy started, this may take a while...\n")
-		if err := copyTree(ctx, srcRepo, dstRepo, visitedTrees, *sn.Tree, gopts.Quiet); err != nil {
-			return err
+		if rechunker != nil {
+			newTree, err := rechunker.CopyTree(ctx, *sn.Tree)
+			if err != nil {
+				return 0, err
+			}
+			sn.Tree = &newTree
+		} else if err := copyTree(ctx, srcRepo, dstRepo, visitedTrees, *sn.Tree, quiet); err != nil {
+			return 0, err
 		}
 		debug.Log("tree copied")
 
@@ -154,11 +202,12 @@ func runCopy(ctx context.Context, opts CopyOptions, gopts GlobalOptions, args []
 		}
 		newID, err := restic.SaveSnapshot(ctx, dstRepo, sn)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		Verbosef("snapshot %s saved\n", newID.Str())
+		copied++
 	}
-	return nil
+	return copied, nil
 }
 
 func similarSnapshots(sna *restic.Snapshot, snb *restic.Snapshot) bool {
@@ -180,6 +229,13 @@ func similarSnapshots(sna *restic.Snapshot, snb *restic.Snapshot) bool {
 	return true
 }
 
+// similarSnapshotsExceptTree is like similarSnapshots, but ignores the tree.
+func similarSnapshotsExceptTree(sna *restic.Snapshot, snb *restic.Snapshot) bool {
+	sn := *snb
+	sn.Tree = sna.Tree
+	return similarSnapshots(sna, &sn)
+}
+
 func copyTree(ctx context.Context, srcRepo restic.Repository, dstRepo restic.Repository,
 	visitedTrees restic.IDSet, rootTreeID restic.ID, quiet bool) error {
 
@@ -193,6 +249,10 @@ func copyTree(ctx context.Context, srcRepo restic.Repository, dstRepo restic.Rep
 
 	copyBlobs := restic.NewBlobSet()
 	packList := restic.NewIDSet()
+	// bundled and decoded files must only be copied to repositories which
+	// support them
+	bundlesSupported := dstRepo.Config().RequireCapability(restic.CapabilityFileBundles, "copying bundled files")
+	encodingSupported := dstRepo.Config().RequireCapability(restic.CapabilityContentEncoding, "copying decoded layers")
 
 	enqueue := func(h restic.BlobHandle) {
 		pb := srcRepo.Index().Lookup(h)
@@ -216,6 +276,12 @@ func copyTree(ctx context.Context, srcRepo restic.Repository, dstRepo restic.Rep
 			}
 
 			for _, entry := range tree.Nodes {
+				if entry.Bundle != nil && bundlesSupported != nil {
+					return bundlesSupported
+				}
+				if entry.ContentEncoding != nil && encodingSupported != nil {
+					return encodingSupported
+				}
 				// Recursion into directories is handled by StreamTrees
 				// Copy the blobs for this file.
 				for _, blobID := range entry.Content {
@@ -233,8 +299,32 @@ func copyTree(ctx context.Context, srcRepo restic.Repository, dstRepo restic.Rep
 		return err
 	}
 
+	if err := copyDataKeys(ctx, srcRepo, dstRepo, copyBlobs); err != nil {
+		return err
+	}
+
 	bar := newProgressMax(!quiet, uint64(len(packList)), "packs copied")
 	_, err = repository.Repack(ctx, srcRepo, dstRepo, packList, copyBlobs, bar)
 	bar.Done()
 	return err
 }
+
+// copyDataKeys copies the data keys of the blobs to dstRepo, which are
+// required to read the blobs.
+func copyDataKeys(ctx context.Context, srcRepo, dstRepo restic.Repository, blobs restic.BlobSet) error {
+	if !srcRepo.Config().HasCapability(restic.CapabilityDataKeys) {
+		return nil
+	}
+
+	src, ok := srcRepo.(*repository.Repository)
+	dst, ok2 := dstRepo.(*repository.Repository)
+	if !ok || !ok2 {
+		return errors.New("copying data keys is not supported")
+	}
+	n, err := dst.CopyDataKeys(ctx, src, blobs)
+	if err != nil {
+		return err
+	}
+	debug.Log("copied %d data keys", n)
+	return nil
+}
@@ -200,7 +200,7 @@ var cmdDebugExamine = &cobra.Command{
 	},
 }
 
-func tryRepairWithBitflip(ctx context.Context, key *crypto.Key, input []byte, bytewise bool) []byte {
+func tryRepairWithBitflip(ctx context.Context, key *crypto.Key, hash restic.BlobHashFunc, input []byte, bytewise bool) []byte {
 	if bytewise {
 		Printf("        trying to repair blob by finding a broken byte\n")
 	} else {
@@ -230,7 +230,7 @@ func tryRepairWithBitflip(ctx context.Context, key *crypto.Key, input []byte, by
 				if err == nil {
 					Printf("\n")
 					Printf("        blob could be repaired by XORing byte %v with 0x%02x\n", idx, pattern)
-					Printf("        hash is %v\n", restic.Hash(plaintext))
+					Printf("        hash is %v\n", hash(plaintext))
 					close(done)
 					found = true
 					fixed = plaintext
@@ -357,7 +357,7 @@ func loadBlobs(ctx context.Context, repo restic.Repository, packID restic.ID, li
 			if err != nil {
 				Warnf("error decrypting blob: %v\n", err)
 				if tryRepair || repairByte {
-					plaintext = tryRepairWithBitflip(ctx, key, buf, repairByte)
+					plaintext = tryRepairWithBitflip(ctx, key, repo.Config().HashBlob, buf, repairByte)
 				}
 				if plaintext != nil {
 					outputPrefix = "repaired "
@@ -382,7 +382,7 @@ func loadBlobs(ctx context.Context, repo restic.Repository, packID restic.ID, li
 				}
 			}
 
-			id := restic.Hash(plaintext)
+			id := repo.Config().HashBlob(plaintext)
 			var prefix string
 			if !id.Equal(blob.ID) {
 				Printf("         successfully %vdecrypted blob (length %v), hash is %v, ID does not match, wanted %v\n", outputPrefix, len(plaintext), id, blob.ID)
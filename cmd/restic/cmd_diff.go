@@ -10,13 +10,14 @@ import (
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui"
 	"github.com/spf13/cobra"
 )
 
 var cmdDiff = &cobra.Command{
-	Use:   "diff [flags] snapshot-ID snapshot-ID",
+	Use:   "diff [flags] snapshot-ID [snapshot-ID]",
 	Short: "Show differences between two snapshots",
 	Long: `
 The "diff" command shows differences from the first to the second snapshot. The
@@ -29,6 +30,14 @@ directory:
 * M  The file's content was modified
 * T  The type was changed, e.g. a file was made a symlink
 
+With --against-local, the snapshot is compared with the current state of the
+local directory instead of a second snapshot. The directory is compared with
+the directory of the same path in the snapshot, such that it must be
+specified like for the backup command. Like backup, a file is assumed to be
+unmodified if its size, modification and change time and inode did not
+change. Otherwise the contents are compared, which reads the file from the
+repository and from the local disk.
+
 EXIT STATUS
 ===========
 
@@ -43,6 +52,7 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 // DiffOptions collects all options for the diff command.
 type DiffOptions struct {
 	ShowMetadata bool
+	AgainstLocal string
 }
 
 var diffOptions DiffOptions
@@ -52,6 +62,7 @@ func init() {
 
 	f := cmdDiff.Flags()
 	f.BoolVar(&diffOptions.ShowMetadata, "metadata", false, "print changes in metadata")
+	f.StringVar(&diffOptions.AgainstLocal, "against-local", "", "compare the snapshot with the local `directory`")
 }
 
 func loadSnapshot(ctx context.Context, be restic.Lister, repo restic.Repository, desc string) (*restic.Snapshot, error) {
@@ -67,6 +78,8 @@ type Comparer struct {
 	repo        restic.Repository
 	opts        DiffOptions
 	printChange func(change *Change)
+	// hasher is only used to compare local files
+	hasher *contentHasher
 }
 
 type Change struct {
@@ -132,7 +145,8 @@ func addBlobs(bs restic.BlobSet, node *restic.Node) {
 type DiffStatsContainer struct {
 	MessageType                          string         `json:"message_type"` // "statistics"
 	SourceSnapshot                       string         `json:"source_snapshot"`
-	TargetSnapshot                       string         `json:"target_snapshot"`
+	TargetSnapshot                       string         `json:"target_snapshot,omitempty"`
+	TargetPath                           string         `json:"target_path,omitempty"`
 	ChangedFiles                         int            `json:"changed_files"`
 	Added                                DiffStat       `json:"added"`
 	Removed                              DiffStat       `json:"removed"`
@@ -266,7 +280,7 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStatsContainer, pref
 
 			if node1.Type == "file" &&
 				node2.Type == "file" &&
-				!reflect.DeepEqual(node1.Content, node2.Content) {
+				(!reflect.DeepEqual(node1.Content, node2.Content) || !node1.Bundle.Equal(node2.Bundle)) {
 				mod += "M"
 				stats.ChangedFiles++
 			} else if c.opts.ShowMetadata && !node1.Equals(*node2) {
@@ -322,8 +336,37 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStatsContainer, pref
 	return nil
 }
 
+func newComparer(repo restic.Repository, opts DiffOptions, gopts GlobalOptions) *Comparer {
+	c := &Comparer{
+		repo: repo,
+		opts: opts,
+		printChange: func(change *Change) {
+			Printf("%-5s%v\n", change.Modifier, change.Path)
+		},
+	}
+
+	if gopts.JSON {
+		enc := json.NewEncoder(gopts.stdout)
+		c.printChange = func(change *Change) {
+			err := enc.Encode(change)
+			if err != nil {
+				Warnf("JSON encode failed: %v\n", err)
+			}
+		}
+	}
+
+	if gopts.Quiet {
+		c.printChange = func(change *Change) {}
+	}
+	return c
+}
+
 func runDiff(ctx context.Context, opts DiffOptions, gopts GlobalOptions, args []string) error {
-	if len(args) != 2 {
+	if opts.AgainstLocal != "" {
+		if len(args) != 1 {
+			return errors.Fatalf("specify one snapshot ID to compare with the local directory")
+		}
+	} else if len(args) != 2 {
 		return errors.Fatalf("specify two snapshot IDs")
 	}
 
@@ -351,6 +394,10 @@ func runDiff(ctx context.Context, opts DiffOptions, gopts GlobalOptions, args []
 		return err
 	}
 
+	if opts.AgainstLocal != "" {
+		return runDiffLocal(ctx, opts, gopts, repo, sn1, args[0])
+	}
+
 	sn2, err := loadSnapshot(ctx, be, repo, args[1])
 	if err != nil {
 		return err
@@ -372,27 +419,7 @@ func runDiff(ctx context.Context, opts DiffOptions, gopts GlobalOptions, args []
 		return errors.Errorf("snapshot %v has nil tree", sn2.ID().Str())
 	}
 
-	c := &Comparer{
-		repo: repo,
-		opts: diffOptions,
-		printChange: func(change *Change) {
-			Printf("%-5s%v\n", change.Modifier, change.Path)
-		},
-	}
-
-	if gopts.JSON {
-		enc := json.NewEncoder(gopts.stdout)
-		c.printChange = func(change *Change) {
-			err := enc.Encode(change)
-			if err != nil {
-				Warnf("JSON encode failed: %v\n", err)
-			}
-		}
-	}
-
-	if gopts.Quiet {
-		c.printChange = func(change *Change) {}
-	}
+	c := newComparer(repo, opts, gopts)
 
 	stats := &DiffStatsContainer{
 		MessageType:    "statistics",
@@ -432,3 +459,58 @@ func runDiff(ctx context.Context, opts DiffOptions, gopts GlobalOptions, args []
 
 	return nil
 }
+
+func runDiffLocal(ctx context.Context, opts DiffOptions, gopts GlobalOptions, repo restic.Repository, sn *restic.Snapshot, snapshotID string) error {
+	fi, err := fs.Lstat(opts.AgainstLocal)
+	if err != nil {
+		return errors.Fatalf("unable to compare with %v: %v", opts.AgainstLocal, err)
+	}
+	if !fi.IsDir() {
+		return errors.Fatalf("%v is not a directory", opts.AgainstLocal)
+	}
+
+	snPath := snapshotPath(opts.AgainstLocal)
+	if !gopts.JSON {
+		Verbosef("comparing snapshot %v to local directory %v:\n\n", sn.ID().Str(), opts.AgainstLocal)
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+	id, err := findSubtree(ctx, repo, *sn.Tree, snPath)
+	if err != nil {
+		return err
+	}
+
+	c := newComparer(repo, opts, gopts)
+	c.hasher = newContentHasher(repo)
+
+	stats := &DiffStatsContainer{
+		MessageType:    "statistics",
+		SourceSnapshot: snapshotID,
+		TargetPath:     opts.AgainstLocal,
+		BlobsBefore:    restic.NewBlobSet(),
+	}
+	err = c.diffLocal(ctx, stats, snPath, id, opts.AgainstLocal)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		err := json.NewEncoder(gopts.stdout).Encode(stats)
+		if err != nil {
+			Warnf("JSON encode failed: %v\n", err)
+		}
+	} else {
+		Printf("\n")
+		Printf("Files:       %5d new, %5d removed, %5d changed\n", stats.Added.Files, stats.Removed.Files, stats.ChangedFiles)
+		Printf("Dirs:        %5d new, %5d removed\n", stats.Added.Dirs, stats.Removed.Dirs)
+		Printf("Others:      %5d new, %5d removed\n", stats.Added.Others, stats.Removed.Others)
+	}
+
+	return nil
+}
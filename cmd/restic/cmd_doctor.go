@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/cache"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+)
+
+var cmdDoctor = &cobra.Command{
+	Use:   "doctor [flags]",
+	Short: "Diagnose common problems with the repository and its environment",
+	Long: `
+The "doctor" command runs a number of quick diagnostics and prints the findings,
+the most severe first, together with the commands to run next. It checks the
+backend, the clock, the local cache, the index, the locks and the repository
+config. The command does not modify the repository and does not lock it, so it
+also works while another process holds an exclusive lock.
+
+The "doctor" command is no replacement for "check", which verifies the
+integrity of the whole repository.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if no errors were found, and non-zero otherwise.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDoctor(cmd.Context(), globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdDoctor)
+}
+
+// findingSeverity orders the findings of the doctor command.
+type findingSeverity int
+
+const (
+	findingInfo findingSeverity = iota
+	findingWarning
+	findingError
+)
+
+func (s findingSeverity) String() string {
+	switch s {
+	case findingError:
+		return "error"
+	case findingWarning:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// MarshalJSON prints the severity as a string.
+func (s findingSeverity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// doctorFinding is the result of a single diagnostic.
+type doctorFinding struct {
+	Severity findingSeverity `json:"severity"`
+	Check    string          `json:"check"`
+	Message  string          `json:"message"`
+	// Command is the suggested next command, if any.
+	Command string `json:"command,omitempty"`
+}
+
+// maxClockSkew is the tolerated difference between the local clock and the
+// timestamps written by other hosts.
+const maxClockSkew = 5 * time.Minute
+
+type doctorCheck struct {
+	name string
+	fn   func(ctx context.Context, repo *repository.Repository, gopts GlobalOptions) ([]doctorFinding, error)
+}
+
+var doctorChecks = []doctorCheck{
+	{"backend", doctorBackend},
+	{"config", doctorConfig},
+	{"locks", doctorLocks},
+	{"clock", doctorClock},
+	{"cache", doctorCache},
+	{"index", doctorIndex},
+}
+
+func runDoctor(ctx context.Context, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the doctor command expects no arguments")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	var findings []doctorFinding
+	for _, check := range doctorChecks {
+		Verbosef("checking %v\n", check.name)
+		result, err := check.fn(ctx, repo, gopts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// a failing diagnostic is a finding of its own
+			result = append(result, doctorFinding{
+				Severity: findingError,
+				Message:  fmt.Sprintf("diagnostic failed: %v", err),
+			})
+		}
+		for i := range result {
+			result[i].Check = check.name
+		}
+		findings = append(findings, result...)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity > findings[j].Severity
+	})
+
+	var errorCount int
+	for _, f := range findings {
+		if f.Severity == findingError {
+			errorCount++
+		}
+	}
+
+	if gopts.JSON {
+		if findings == nil {
+			findings = []doctorFinding{}
+		}
+		err = json.NewEncoder(gopts.stdout).Encode(findings)
+		if err != nil {
+			return err
+		}
+	} else {
+		printDoctorFindings(findings)
+	}
+
+	if errorCount > 0 {
+		return errors.Fatalf("found %d errors", errorCount)
+	}
+	return nil
+}
+
+func printDoctorFindings(findings []doctorFinding) {
+	if len(findings) == 0 {
+		Printf("no problems found\n")
+		return
+	}
+
+	for _, f := range findings {
+		Printf("%-9s %-8s %v\n", "["+f.Severity.String()+"]", f.Check, f.Message)
+		if f.Command != "" {
+			Printf("%18s run: %v\n", "", f.Command)
+		}
+	}
+}
+
+// doctorBackend checks that the backend answers basic requests correctly,
+// without writing any data. Listing files already works if the repository
+// could be opened, as this requires listing the keys.
+func doctorBackend(ctx context.Context, repo *repository.Repository, _ GlobalOptions) ([]doctorFinding, error) {
+	be := repo.Backend()
+	var findings []doctorFinding
+	addError := func(format string, args ...interface{}) {
+		findings = append(findings, doctorFinding{
+			Severity: findingError,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	h := restic.Handle{Type: restic.ConfigFile}
+	start := time.Now()
+	fi, err := be.Stat(ctx, h)
+	if err != nil {
+		addError("stat of the config file failed: %v", err)
+		return findings, nil
+	}
+	latency := time.Since(start)
+
+	var full []byte
+	err = be.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		full, err = io.ReadAll(rd)
+		return err
+	})
+	if err != nil {
+		addError("loading the config file failed: %v", err)
+		return findings, nil
+	}
+	if int64(len(full)) != fi.Size {
+		addError("the backend reported a size of %d bytes for the config file, but returned %d bytes", fi.Size, len(full))
+	}
+
+	// restic loads parts of pack files, thus range requests must work
+	if len(full) > 2 {
+		var part []byte
+		err = be.Load(ctx, h, len(full)-2, 1, func(rd io.Reader) error {
+			part, err = io.ReadAll(rd)
+			return err
+		})
+		if err != nil {
+			addError("loading a part of the config file failed: %v", err)
+		} else if !bytes.Equal(part, full[1:len(full)-1]) {
+			addError("loading a part of the config file returned the wrong data, the backend does not support range requests correctly")
+		}
+	}
+
+	_, err = be.Stat(ctx, restic.Handle{Type: restic.LockFile, Name: restic.NewRandomID().String()})
+	if err == nil || !be.IsNotExist(err) {
+		addError("the backend does not report missing files correctly: %v", err)
+	}
+
+	if latency > time.Second {
+		findings = append(findings, doctorFinding{
+			Severity: findingWarning,
+			Message:  fmt.Sprintf("the backend is slow, a single request took %v", latency.Round(time.Millisecond)),
+			Command:  "restic --backend-stats snapshots",
+		})
+	}
+	return findings, nil
+}
+
+// doctorConfig checks whether this version of restic supports all features
+// used by the repository. The remaining config fields are already validated
+// when opening the repository.
+func doctorConfig(_ context.Context, repo *repository.Repository, gopts GlobalOptions) ([]doctorFinding, error) {
+	cfg := repo.Config()
+	var findings []doctorFinding
+
+	if err := cfg.CheckWriteCapabilities(); err != nil {
+		findings = append(findings, doctorFinding{
+			Severity: findingWarning,
+			Message:  err.Error(),
+		})
+	}
+
+	if cfg.Version < 2 {
+		findings = append(findings, doctorFinding{
+			Severity: findingInfo,
+			Message:  fmt.Sprintf("the repository uses format version %d, which does not support compression", cfg.Version),
+			Command:  "restic migrate upgrade_repo_v2",
+		})
+		if gopts.Compression != repository.CompressionAuto {
+			findings = append(findings, doctorFinding{
+				Severity: findingWarning,
+				Message:  "--compression is set, but is ignored for repositories with format version 1",
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// doctorLocks reports stale and exclusive locks.
+func doctorLocks(ctx context.Context, repo *repository.Repository, _ GlobalOptions) ([]doctorFinding, error) {
+	var findings []doctorFinding
+	stale := 0
+	err := restic.ForAllLocks(ctx, repo, nil, func(id restic.ID, lock *restic.Lock, err error) error {
+		if err != nil {
+			findings = append(findings, doctorFinding{
+				Severity: findingWarning,
+				Message:  fmt.Sprintf("lock %v cannot be read: %v", id.Str(), err),
+				Command:  "restic unlock",
+			})
+			return nil
+		}
+
+		if lock.Stale() {
+			stale++
+			return nil
+		}
+		if lock.Exclusive {
+			findings = append(findings, doctorFinding{
+				Severity: findingInfo,
+				Message:  fmt.Sprintf("the repository is locked exclusively: %v", lock),
+			})
+		}
+		return nil
+	})
+
+	if stale > 0 {
+		findings = append(findings, doctorFinding{
+			Severity: findingWarning,
+			Message:  fmt.Sprintf("found %d stale locks, which may block other commands", stale),
+			Command:  "restic unlock",
+		})
+	}
+	return findings, err
+}
+
+// doctorClock compares the local clock with the timestamps of snapshots and
+// locks, which may also have been written by other hosts.
+func doctorClock(ctx context.Context, repo *repository.Repository, _ GlobalOptions) ([]doctorFinding, error) {
+	var findings []doctorFinding
+	now := time.Now()
+	check := func(what string, t time.Time, hostname string) {
+		if t.Sub(now) > maxClockSkew {
+			findings = append(findings, doctorFinding{
+				Severity: findingWarning,
+				Message: fmt.Sprintf("%v created on host %q is %v in the future, the clock of this or the other host is wrong",
+					what, hostname, t.Sub(now).Round(time.Second)),
+			})
+		}
+	}
+
+	err := restic.ForAllLocks(ctx, repo, nil, func(id restic.ID, lock *restic.Lock, err error) error {
+		if err == nil {
+			check("lock "+id.Str(), lock.Time, lock.Hostname)
+		}
+		return nil
+	})
+	if err != nil {
+		return findings, err
+	}
+
+	// only report the newest snapshot of each host
+	newest := make(map[string]*restic.Snapshot)
+	err = restic.ForAllSnapshots(ctx, repo.Backend(), repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return nil
+		}
+		if other, ok := newest[sn.Hostname]; !ok || sn.Time.After(other.Time) {
+			newest[sn.Hostname] = sn
+		}
+		return nil
+	})
+	for host, sn := range newest {
+		check("snapshot "+sn.ID().Str(), sn.Time, host)
+	}
+	return findings, err
+}
+
+// doctorCache reports problems with the local cache.
+func doctorCache(_ context.Context, repo *repository.Repository, gopts GlobalOptions) ([]doctorFinding, error) {
+	if gopts.NoCache {
+		return []doctorFinding{{
+			Severity: findingInfo,
+			Message:  "the local cache is disabled, which makes most commands slower",
+		}}, nil
+	}
+
+	if repo.Cache == nil {
+		return []doctorFinding{{
+			Severity: findingWarning,
+			Message:  "the local cache could not be opened, see the warnings printed when opening the repository",
+		}}, nil
+	}
+
+	var findings []doctorFinding
+	base := repo.Cache.BaseDir()
+	size, err := dirSize(filepath.Join(base, repo.Config().ID))
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, doctorFinding{
+		Severity: findingInfo,
+		Message:  fmt.Sprintf("the cache for this repository in %v uses %v", base, ui.FormatBytes(uint64(size))),
+	})
+
+	old, err := cache.Old(base)
+	if err != nil {
+		return findings, err
+	}
+	if len(old) > 0 {
+		findings = append(findings, doctorFinding{
+			Severity: findingInfo,
+			Message:  fmt.Sprintf("found %d cache directories which were not used for %d days", len(old), cache.MaxCacheAge/(24*time.Hour)),
+			Command:  "restic cache --cleanup",
+		})
+	}
+	return findings, nil
+}
+
+// doctorIndex compares the pack files referenced by the index with the pack
+// files stored in the repository.
+func doctorIndex(ctx context.Context, repo *repository.Repository, _ GlobalOptions) ([]doctorFinding, error) {
+	if err := repo.LoadIndex(ctx); err != nil {
+		return []doctorFinding{{
+			Severity: findingError,
+			Message:  fmt.Sprintf("loading the index failed: %v", err),
+			Command:  "restic repair index",
+		}}, nil
+	}
+
+	indexed := restic.NewIDSet()
+	repo.Index().Each(ctx, func(pb restic.PackedBlob) {
+		indexed.Insert(pb.PackID)
+	})
+
+	stored := restic.NewIDSet()
+	err := repo.List(ctx, restic.PackFile, func(id restic.ID, _ int64) error {
+		stored.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []doctorFinding
+	if missing := indexed.Sub(stored); len(missing) > 0 {
+		findings = append(findings, doctorFinding{
+			Severity: findingError,
+			Message:  fmt.Sprintf("%d pack files referenced by the index are missing", len(missing)),
+			Command:  "restic repair index",
+		})
+	}
+	// pack files of running backups are only indexed once they are complete
+	if unindexed := stored.Sub(indexed); len(unindexed) > 0 {
+		findings = append(findings, doctorFinding{
+			Severity: findingWarning,
+			Message:  fmt.Sprintf("%d pack files are not referenced by the index, this is expected while a backup is running", len(unindexed)),
+			Command:  "restic prune",
+		})
+	}
+	return findings, nil
+}
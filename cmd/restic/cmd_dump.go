@@ -6,6 +6,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/dump"
@@ -16,16 +18,18 @@ import (
 )
 
 var cmdDump = &cobra.Command{
-	Use:   "dump [flags] snapshotID file",
+	Use:   "dump [flags] snapshotID file [file...]",
 	Short: "Print a backed-up file to stdout",
 	Long: `
 The "dump" command extracts files from a snapshot from the repository. If a
 single file is selected, it prints its contents to stdout. Folders are output
 as a tar (default) or zip file containing the contents of the specified folder.
-Pass "/" as file name to dump the whole snapshot as an archive file.
+Pass "/" as file name to dump the whole snapshot as an archive file. If several
+files or folders are specified, they are all packed into a single archive.
 
 The special snapshot "latest" can be used to use the latest snapshot in the
-repository.
+repository. "latest@time" and "host:name@time" select the newest snapshot
+created at or before the given time, for example "latest@2023-06-01".
 
 EXIT STATUS
 ===========
@@ -63,7 +67,22 @@ func splitPath(p string) []string {
 	return append(s, f)
 }
 
-func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, d *dump.Dumper) error {
+// findDumpNode returns the node called name in tree. If ignoreCase is set and
+// there is no exact match, the first node whose name only differs in case is
+// returned.
+func findDumpNode(tree *restic.Tree, name string, ignoreCase bool) *restic.Node {
+	if node := tree.Find(name); node != nil || !ignoreCase {
+		return node
+	}
+	for _, node := range tree.Nodes {
+		if strings.EqualFold(node.Name, name) {
+			return node
+		}
+	}
+	return nil
+}
+
+func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, d *dump.Dumper, ignoreCase bool) error {
 	// If we print / we need to assume that there are multiple nodes at that
 	// level in the tree.
 	if pathComponents[0] == "" {
@@ -75,40 +94,105 @@ func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repositor
 
 	item := filepath.Join(prefix, pathComponents[0])
 	l := len(pathComponents)
-	for _, node := range tree.Nodes {
-		// If dumping something in the highest level it will just take the
-		// first item it finds and dump that according to the switch case below.
-		if node.Name == pathComponents[0] {
-			switch {
-			case l == 1 && dump.IsFile(node):
-				return d.WriteNode(ctx, node)
-			case l > 1 && dump.IsDir(node):
-				subtree, err := restic.LoadTree(ctx, repo, *node.Subtree)
-				if err != nil {
-					return errors.Wrapf(err, "cannot load subtree for %q", item)
-				}
-				return printFromTree(ctx, subtree, repo, item, pathComponents[1:], d)
-			case dump.IsDir(node):
-				if err := checkStdoutArchive(); err != nil {
-					return err
-				}
-				subtree, err := restic.LoadTree(ctx, repo, *node.Subtree)
-				if err != nil {
-					return err
-				}
-				return d.DumpTree(ctx, subtree, item)
-			case l > 1:
-				return fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
-			case !dump.IsFile(node):
-				return fmt.Errorf("%q should be a file, but is a %q", item, node.Type)
+	// If dumping something in the highest level it will just take the
+	// first item it finds and dump that according to the switch case below.
+	node := findDumpNode(tree, pathComponents[0], ignoreCase)
+	switch {
+	case node == nil:
+		return fmt.Errorf("path %q not found in snapshot", item)
+	case l == 1 && dump.IsFile(node):
+		return d.WriteNode(ctx, node)
+	case l > 1 && dump.IsDir(node):
+		subtree, err := restic.LoadTree(ctx, repo, *node.Subtree)
+		if err != nil {
+			return errors.Wrapf(err, "cannot load subtree for %q", item)
+		}
+		return printFromTree(ctx, subtree, repo, item, pathComponents[1:], d, ignoreCase)
+	case dump.IsDir(node):
+		if err := checkStdoutArchive(); err != nil {
+			return err
+		}
+		subtree, err := restic.LoadTree(ctx, repo, *node.Subtree)
+		if err != nil {
+			return err
+		}
+		return d.DumpTree(ctx, subtree, item)
+	case l > 1:
+		return fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
+	default:
+		return fmt.Errorf("%q should be a file, but is a %q", item, node.Type)
+	}
+}
+
+// lookupDumpNode returns the node for the path consisting of pathComponents below
+// tree. The Path of the returned node is set to its location in the snapshot.
+func lookupDumpNode(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, ignoreCase bool) (*restic.Node, error) {
+	item := path.Join(prefix, pathComponents[0])
+	node := findDumpNode(tree, pathComponents[0], ignoreCase)
+	if node == nil {
+		return nil, fmt.Errorf("path %q not found in snapshot", item)
+	}
+	if len(pathComponents) == 1 {
+		node.Path = item
+		return node, nil
+	}
+	if !dump.IsDir(node) {
+		return nil, fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
+	}
+	subtree, err := restic.LoadTree(ctx, repo, *node.Subtree)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load subtree for %q", item)
+	}
+	return lookupDumpNode(ctx, subtree, repo, item, pathComponents[1:], ignoreCase)
+}
+
+// dumpPaths writes all paths into a single archive.
+func dumpPaths(ctx context.Context, tree *restic.Tree, repo restic.Repository, paths []string, d *dump.Dumper, ignoreCase bool) error {
+	if err := checkStdoutArchive(); err != nil {
+		return err
+	}
+
+	var roots []*restic.Node
+	for _, p := range paths {
+		if p == "/" {
+			return d.DumpTree(ctx, tree, "/")
+		}
+		node, err := lookupDumpNode(ctx, tree, repo, "/", splitPath(p), ignoreCase)
+		if err != nil {
+			return err
+		}
+		if !dump.IsFile(node) && !dump.IsDir(node) && !dump.IsLink(node) {
+			return fmt.Errorf("%q should be a file or dir, but is a %q", node.Path, node.Type)
+		}
+		roots = append(roots, node)
+	}
+	return d.DumpNodes(ctx, roots)
+}
+
+// uniquePaths cleans all paths and removes those which are contained in
+// another path of the list.
+func uniquePaths(paths []string) []string {
+	cleaned := make([]string, 0, len(paths))
+	for _, p := range paths {
+		cleaned = append(cleaned, path.Join("/", p))
+	}
+	sort.Strings(cleaned)
+
+	var result []string
+	for _, p := range cleaned {
+		if len(result) > 0 {
+			last := result[len(result)-1]
+			if p == last || last == "/" || strings.HasPrefix(p, last+"/") {
+				continue
 			}
 		}
+		result = append(result, p)
 	}
-	return fmt.Errorf("path %q not found in snapshot", item)
+	return result
 }
 
 func runDump(ctx context.Context, opts DumpOptions, gopts GlobalOptions, args []string) error {
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return errors.Fatal("no file and no snapshot ID specified")
 	}
 
@@ -119,11 +203,9 @@ func runDump(ctx context.Context, opts DumpOptions, gopts GlobalOptions, args []
 	}
 
 	snapshotIDString := args[0]
-	pathToPrint := args[1]
+	pathsToPrint := uniquePaths(args[1:])
 
-	debug.Log("dump file %q from %q", pathToPrint, snapshotIDString)
-
-	splittedPath := splitPath(path.Clean(pathToPrint))
+	debug.Log("dump files %q from %q", pathsToPrint, snapshotIDString)
 
 	repo, err := OpenRepository(ctx, gopts)
 	if err != nil {
@@ -159,7 +241,11 @@ func runDump(ctx context.Context, opts DumpOptions, gopts GlobalOptions, args []
 	}
 
 	d := dump.New(opts.Archive, repo, os.Stdout)
-	err = printFromTree(ctx, tree, repo, "/", splittedPath, d)
+	if len(pathsToPrint) == 1 {
+		err = printFromTree(ctx, tree, repo, "/", splitPath(pathsToPrint[0]), d, gopts.IgnoreCase)
+	} else {
+		err = dumpPaths(ctx, tree, repo, pathsToPrint, d, gopts.IgnoreCase)
+	}
 	if err != nil {
 		return errors.Fatalf("cannot dump file: %v", err)
 	}
@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 
+	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 )
 
@@ -25,3 +26,22 @@ func TestDumpSplitPath(t *testing.T) {
 		rtest.Equals(t, path.result, parts)
 	}
 }
+
+func TestDumpUniquePaths(t *testing.T) {
+	rtest.Equals(t, []string{"/a", "/b/c", "/bc"}, uniquePaths([]string{"bc", "/a/x", "/b/c/", "a", "/b/c/d", "a"}))
+	rtest.Equals(t, []string{"/"}, uniquePaths([]string{"/a", "/", "b"}))
+}
+
+func TestDumpFindNodeIgnoreCase(t *testing.T) {
+	tree := restic.NewTree(2)
+	rtest.OK(t, tree.Insert(&restic.Node{Name: "Documents", Type: "dir"}))
+	rtest.OK(t, tree.Insert(&restic.Node{Name: "documents", Type: "file"}))
+	rtest.OK(t, tree.Insert(&restic.Node{Name: "Report.TXT", Type: "file"}))
+
+	rtest.Assert(t, findDumpNode(tree, "report.txt", false) == nil, "found node with different case")
+	rtest.Equals(t, "Report.TXT", findDumpNode(tree, "report.txt", true).Name)
+	// exact matches are preferred
+	rtest.Equals(t, "documents", findDumpNode(tree, "documents", true).Name)
+	rtest.Equals(t, "Documents", findDumpNode(tree, "Documents", true).Name)
+	rtest.Assert(t, findDumpNode(tree, "missing", true) == nil, "found missing node")
+}
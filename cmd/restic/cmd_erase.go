@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+var cmdErase = &cobra.Command{
+	Use:   "erase --path path [--path path ...] [--all-snapshots | snapshotID ...]",
+	Short: "Erase files from snapshots by destroying their data keys",
+	Long: `
+The "erase" command removes files from snapshots and destroys the data keys
+their contents are encrypted with. Afterwards, the contents cannot be read
+anymore, even though the encrypted data remains in the repository until it is
+removed by the "prune" command. This only works for files which were saved by
+"backup --data-keys".
+
+Each --path removes the file or directory with this path and everything below
+it, for example "/data/user123". The snapshots to rewrite are either specified
+by their IDs or by --all-snapshots. As a data key is shared by all versions of
+a file, the command refuses to erase a key which is still used by a file in any
+other snapshot or at any other path.
+
+The affected snapshots are replaced by new snapshots without the files.
+Protected snapshots must be unprotected first.
+
+Please note that copies of the data key files, for example in mirrors or older
+backups of the repository, still allow decrypting the contents.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runErase(cmd.Context(), eraseOptions, globalOptions, args)
+	},
+}
+
+// EraseOptions collects all options for the erase command.
+type EraseOptions struct {
+	Paths        []string
+	AllSnapshots bool
+	DryRun       bool
+	signingKeyOptions
+}
+
+var eraseOptions EraseOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdErase)
+
+	f := cmdErase.Flags()
+	f.StringArrayVar(&eraseOptions.Paths, "path", nil, "erase the file or directory at `path` within the snapshots (can be specified multiple times)")
+	f.BoolVar(&eraseOptions.AllSnapshots, "all-snapshots", false, "erase the paths from all snapshots")
+	f.BoolVarP(&eraseOptions.DryRun, "dry-run", "n", false, "do not do anything, just print what would be done")
+	initSigningKeyOptions(f, &eraseOptions.signingKeyOptions)
+}
+
+// erasePaths matches the paths to erase and everything below them.
+type erasePaths []string
+
+func (e erasePaths) Match(nodepath string) bool {
+	for _, p := range e {
+		if nodepath == p || strings.HasPrefix(nodepath, p+"/") || p == "/" {
+			return true
+		}
+	}
+	return false
+}
+
+// eraseStats collects the data keys of the files to erase.
+type eraseStats struct {
+	// erase contains the data keys of the files to erase
+	erase restic.IDSet
+	// keep contains the data keys of all other files
+	keep restic.IDSet
+	// files is the number of files to erase
+	files int
+	// unprotected is the number of files to erase without a data key
+	unprotected int
+}
+
+func collectEraseKeys(ctx context.Context, repo restic.Repository, snapshotLister restic.Lister, selected map[restic.ID]*restic.Snapshot, paths erasePaths) (*eraseStats, error) {
+	stats := &eraseStats{
+		erase: restic.NewIDSet(),
+		keep:  restic.NewIDSet(),
+	}
+
+	for _, sn := range selected {
+		err := walker.Walk(ctx, repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+			if err != nil {
+				return false, err
+			}
+			if node == nil || node.Type != "file" {
+				return false, nil
+			}
+			if !paths.Match(nodepath) {
+				if node.DataKey != nil {
+					stats.keep.Insert(*node.DataKey)
+				}
+				return false, nil
+			}
+
+			stats.files++
+			if node.DataKey == nil {
+				stats.unprotected++
+				Warnf("file %v in snapshot %v has no data key, its contents remain readable until pruned\n", nodepath, sn.ID().Str())
+				return false, nil
+			}
+			stats.erase.Insert(*node.DataKey)
+			return false, nil
+		})
+		if err != nil {
+			return nil, errors.Fatalf("unable to walk snapshot %v: %v", sn.ID().Str(), err)
+		}
+	}
+
+	// all files within the other snapshots are kept, so their trees only
+	// need to be visited once
+	ignoreTrees := restic.NewIDSet()
+	err := restic.ForAllSnapshots(ctx, snapshotLister, repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, ok := selected[id]; ok {
+			return nil
+		}
+		return walker.Walk(ctx, repo, *sn.Tree, ignoreTrees, func(_ restic.ID, _ string, node *restic.Node, err error) (bool, error) {
+			if err != nil {
+				return false, err
+			}
+			if node != nil && node.DataKey != nil {
+				stats.keep.Insert(*node.DataKey)
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func eraseSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, paths erasePaths, dryRun bool, signingKey ed25519.PrivateKey) (bool, error) {
+	rewriter := walker.NewTreeRewriter(walker.RewriteOpts{
+		RewriteNode: func(node *restic.Node, nodepath string) *restic.Node {
+			if !paths.Match(nodepath) {
+				return node
+			}
+			Verbosef("erasing %s\n", nodepath)
+			return nil
+		},
+		DisableNodeCache: true,
+	})
+
+	return filterAndReplaceSnapshot(ctx, repo, sn,
+		func(ctx context.Context, sn *restic.Snapshot) (restic.ID, error) {
+			return rewriter.RewriteTree(ctx, repo, "/", *sn.Tree)
+		}, dryRun, true, nil, "erase", signingKey)
+}
+
+func runErase(ctx context.Context, opts EraseOptions, gopts GlobalOptions, args []string) error {
+	if len(opts.Paths) == 0 {
+		return errors.Fatal("Nothing to do: no paths to erase provided")
+	}
+	if opts.AllSnapshots == (len(args) > 0) {
+		return errors.Fatal("either specify snapshot IDs or --all-snapshots")
+	}
+
+	var paths erasePaths
+	for _, p := range opts.Paths {
+		if !strings.HasPrefix(p, "/") {
+			return errors.Fatalf("path %q is not absolute", p)
+		}
+		paths = append(paths, path.Clean(p))
+	}
+	signingKey, err := opts.signingKeyOptions.load()
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !opts.DryRun {
+		Verbosef("create exclusive lock for repository\n")
+		lock, lockCtx, err := lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+		ctx = lockCtx
+	} else {
+		repo.SetDryRun()
+	}
+
+	snapshotLister, err := backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	selected := make(map[restic.ID]*restic.Snapshot)
+	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &restic.SnapshotFilter{}, args) {
+		selected[*sn.ID()] = sn
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(selected) == 0 {
+		return errors.Fatal("no snapshots found")
+	}
+	for id, sn := range selected {
+		if sn.Tree == nil {
+			return errors.Fatalf("snapshot %v has nil tree", id.Str())
+		}
+		if sn.Protected {
+			return errors.Fatalf("snapshot %v is protected, unprotect it first", id.Str())
+		}
+	}
+
+	Verbosef("collecting data keys of %d snapshots\n", len(selected))
+	stats, err := collectEraseKeys(ctx, repo, snapshotLister, selected, paths)
+	if err != nil {
+		return err
+	}
+	if stats.files == 0 {
+		Verbosef("no files to erase found\n")
+		return nil
+	}
+	if shared := stats.erase.Intersect(stats.keep); len(shared) > 0 {
+		return errors.Fatalf("%d data keys are also used by files which are not erased, erase the paths from all snapshots", len(shared))
+	}
+
+	var changedIDs restic.IDs
+	for _, sn := range selected {
+		Verbosef("\nsnapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+		changed, err := eraseSnapshot(ctx, repo, sn, paths, opts.DryRun, signingKey)
+		if err != nil {
+			return errors.Fatalf("unable to rewrite snapshot ID %q: %v", sn.ID().Str(), err)
+		}
+		if changed {
+			changedIDs = append(changedIDs, *sn.ID())
+		}
+	}
+
+	Verbosef("\n")
+	if opts.DryRun {
+		Verbosef("would erase %d files from %d snapshots and destroy %d data keys\n", stats.files, len(changedIDs), len(stats.erase))
+		return nil
+	}
+
+	replaced, err := repo.EraseDataKeys(ctx, stats.erase)
+	if err != nil {
+		return errors.Fatalf("unable to erase data keys: %v", err)
+	}
+	recordAudit(ctx, repo, "erase", "erased "+strings.Join(paths, ", "), changedIDs)
+
+	Verbosef("erased %d files from %d snapshots, destroyed %d data keys in %d data key files\n", stats.files, len(changedIDs), len(stats.erase), replaced)
+	if stats.unprotected > 0 {
+		Warnf("%d files had no data key, run prune to remove their contents\n", stats.unprotected)
+	} else {
+		Verbosef("run prune to free the space used by the erased files\n")
+	}
+	return nil
+}
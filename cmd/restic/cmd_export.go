@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/export"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/textfile"
+	"github.com/restic/restic/internal/ui"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdExport = &cobra.Command{
+	Use:   "export [flags] [snapshotID ...]",
+	Short: "Write snapshots to a self-contained encrypted stream",
+	Long: `
+The "export" command writes the snapshots together with all data they
+reference to a single encrypted stream, which is printed to stdout or written
+to the file given by --output. The stream can be loaded into another
+repository using the "import" command, without access to this repository. If
+no snapshot is specified, all snapshots matching the filters are exported.
+
+The stream is encrypted and authenticated with a separate password, which is
+read from the file given by --stream-password-file, the environment variable
+RESTIC_STREAM_PASSWORD or is requested interactively.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runExport(cmd.Context(), exportOptions, globalOptions, args)
+	},
+}
+
+// ExportOptions collects all options for the export command.
+type ExportOptions struct {
+	restic.SnapshotFilter
+	Output             string
+	StreamPasswordFile string
+}
+
+var exportOptions ExportOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdExport)
+
+	flags := cmdExport.Flags()
+	initMultiSnapshotFilter(flags, &exportOptions.SnapshotFilter, true)
+	flags.StringVar(&exportOptions.Output, "output", "", "write the stream to `file` instead of stdout")
+	flags.StringVar(&exportOptions.StreamPasswordFile, "stream-password-file", "", "read the password for the stream from `file`")
+}
+
+// readStreamPassword returns the password for an export stream.
+func readStreamPassword(file string, confirm bool) (string, error) {
+	if file != "" {
+		s, err := textfile.Read(file)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", errors.Fatalf("%s does not exist", file)
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "Readfile")
+		}
+		return strings.TrimSpace(string(s)), nil
+	}
+	if pwd := os.Getenv("RESTIC_STREAM_PASSWORD"); pwd != "" {
+		return pwd, nil
+	}
+	if !stdinIsTerminal() {
+		return "", errors.Fatal("please specify the stream password using --stream-password-file or RESTIC_STREAM_PASSWORD")
+	}
+
+	if confirm {
+		return ReadPasswordTwice(GlobalOptions{}, "enter password for stream: ", "enter password again: ")
+	}
+	return ReadPassword(GlobalOptions{}, "enter password for stream: ")
+}
+
+func runExport(ctx context.Context, opts ExportOptions, gopts GlobalOptions, args []string) error {
+	password, err := readStreamPassword(opts.StreamPasswordFile, true)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	var outFile *os.File
+	if opts.Output != "" {
+		outFile, err = os.Create(opts.Output)
+		if err != nil {
+			return errors.Fatalf("unable to create output file: %v", err)
+		}
+		defer func() {
+			_ = outFile.Close()
+		}()
+		out = outFile
+	} else if err := checkStdoutArchive(); err != nil {
+		return errors.Fatal(err.Error())
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshotLister, err := backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+
+	if err := repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	params := crypto.DefaultKDFParams
+	if repository.Params != nil {
+		params = *repository.Params
+	} else if params, err = crypto.Calibrate(repository.KDFTimeout, repository.KDFMemory); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(out, 1<<20)
+	w, err := export.NewWriter(bw, password, params, repo.Config().BlobHash)
+	if err != nil {
+		return err
+	}
+
+	exported := restic.NewBlobSet()
+	visitedTrees := restic.NewIDSet()
+	var snapshots int
+	var size uint64
+	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, args) {
+		Verbosef("exporting snapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+		n, err := exportTree(ctx, repo, w, exported, visitedTrees, *sn.Tree)
+		if err != nil {
+			return err
+		}
+		size += n
+
+		if sn.Original == nil {
+			sn.Original = sn.ID()
+		}
+		if err := w.WriteSnapshot(sn); err != nil {
+			return err
+		}
+		snapshots++
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+	if outFile != nil {
+		if err := outFile.Close(); err != nil {
+			return errors.Wrap(err, "Close")
+		}
+	}
+
+	Verbosef("exported %d snapshots with %d blobs (%s)\n", snapshots, len(exported), ui.FormatBytes(size))
+	return nil
+}
+
+// exportTree writes all tree and data blobs below the tree rootID which are
+// not contained in exported to the stream, trees in visitedTrees are skipped.
+// It returns the size of the newly written blobs.
+func exportTree(ctx context.Context, repo restic.Repository, w *export.Writer,
+	exported restic.BlobSet, visitedTrees restic.IDSet, rootID restic.ID) (uint64, error) {
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	treeStream := restic.StreamTrees(wgCtx, wg, repo, restic.IDs{rootID}, func(treeID restic.ID) bool {
+		visited := visitedTrees.Has(treeID)
+		visitedTrees.Insert(treeID)
+		return visited
+	}, nil)
+
+	var size uint64
+	var buf []byte
+	write := func(h restic.BlobHandle) error {
+		if exported.Has(h) {
+			return nil
+		}
+		var err error
+		buf, err = repo.LoadBlob(wgCtx, h.Type, h.ID, buf)
+		if err != nil {
+			return fmt.Errorf("LoadBlob(%v) returned error %v", h, err)
+		}
+		if err := w.WriteBlob(h.Type, h.ID, buf); err != nil {
+			return err
+		}
+		exported.Insert(h)
+		size += uint64(len(buf))
+		return nil
+	}
+
+	wg.Go(func() error {
+		for tree := range treeStream {
+			if tree.Error != nil {
+				return fmt.Errorf("LoadTree(%v) returned error %v", tree.ID.Str(), tree.Error)
+			}
+			// the raw tree is copied to avoid problems if the serialization changes
+			if err := write(restic.BlobHandle{ID: tree.ID, Type: restic.TreeBlob}); err != nil {
+				return err
+			}
+			for _, node := range tree.Nodes {
+				for _, id := range node.Content {
+					if err := write(restic.BlobHandle{ID: id, Type: restic.DataBlob}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return size, wg.Wait()
+}
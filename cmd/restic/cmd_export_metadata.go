@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/sqlite"
+)
+
+var cmdExportMetadata = &cobra.Command{
+	Use:   "export-metadata --sqlite file [flags] [snapshotID ...]",
+	Short: "Write the repository metadata to an SQLite database",
+	Long: `
+The "export-metadata" command writes the metadata of the repository to a new
+SQLite database, which can be queried offline using SQL. It contains the
+snapshots, which default to all snapshots if none are specified, their trees
+and the nodes within them, the data blobs referenced by each file and which
+pack files contain the blobs. The file contents are not exported.
+
+Trees shared by several snapshots are exported only once. The database does
+not contain indexes, these can be created using the sqlite3 tool as required
+by the queries. Refer to the online manual for a description of the tables.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runExportMetadata(cmd.Context(), exportMetadataOptions, globalOptions, args)
+	},
+}
+
+// ExportMetadataOptions collects all options for the export-metadata command.
+type ExportMetadataOptions struct {
+	SQLite string
+
+	restic.SnapshotFilter
+}
+
+var exportMetadataOptions ExportMetadataOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdExportMetadata)
+
+	f := cmdExportMetadata.Flags()
+	f.StringVar(&exportMetadataOptions.SQLite, "sqlite", "", "write the metadata to the new SQLite database `file`")
+	initMultiSnapshotFilter(f, &exportMetadataOptions.SnapshotFilter, true)
+}
+
+// metadataExporter writes the metadata to the tables of the database. Each
+// object is assigned the row ID of its table, which is used to reference it
+// from the other tables.
+type metadataExporter struct {
+	repo restic.Repository
+
+	snapshots, trees, nodes, content, blobs, packs, packBlobs *sqlite.Table
+
+	treeIDs map[restic.ID]int64
+	blobIDs map[restic.BlobHandle]int64
+	packIDs map[restic.ID]int64
+}
+
+func newMetadataExporter(repo restic.Repository, db *sqlite.DB) *metadataExporter {
+	return &metadataExporter{
+		repo: repo,
+		snapshots: db.CreateTable("snapshots", `CREATE TABLE snapshots (id INTEGER PRIMARY KEY, snapshot_id TEXT, `+
+			`time TEXT, hostname TEXT, username TEXT, paths TEXT, tags TEXT, tree INTEGER REFERENCES trees(id), parent_id TEXT)`),
+		trees: db.CreateTable("trees", `CREATE TABLE trees (id INTEGER PRIMARY KEY, tree_id TEXT)`),
+		nodes: db.CreateTable("nodes", `CREATE TABLE nodes (id INTEGER PRIMARY KEY, tree INTEGER REFERENCES trees(id), `+
+			`name TEXT, type TEXT, mode INTEGER, mtime TEXT, uid INTEGER, gid INTEGER, user TEXT, "group" TEXT, inode INTEGER, `+
+			`links INTEGER, size INTEGER, link_target TEXT, content_id TEXT, subtree INTEGER REFERENCES trees(id))`),
+		content: db.CreateTable("content", `CREATE TABLE content (node INTEGER REFERENCES nodes(id), position INTEGER, `+
+			`blob INTEGER REFERENCES blobs(id))`),
+		blobs: db.CreateTable("blobs", `CREATE TABLE blobs (id INTEGER PRIMARY KEY, blob_id TEXT, type TEXT, `+
+			`uncompressed_length INTEGER)`),
+		packs: db.CreateTable("packs", `CREATE TABLE packs (id INTEGER PRIMARY KEY, pack_id TEXT, size INTEGER)`),
+		packBlobs: db.CreateTable("pack_blobs", `CREATE TABLE pack_blobs (pack INTEGER REFERENCES packs(id), `+
+			`blob INTEGER REFERENCES blobs(id), offset INTEGER, length INTEGER)`),
+
+		treeIDs: make(map[restic.ID]int64),
+		blobIDs: make(map[restic.BlobHandle]int64),
+		packIDs: make(map[restic.ID]int64),
+	}
+}
+
+// ExportIndex writes the pack files and the blobs contained in them.
+func (e *metadataExporter) ExportIndex(ctx context.Context) error {
+	err := e.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		rowid, err := e.packs.Insert(nil, id.String(), size)
+		e.packIDs[id] = rowid
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	e.repo.Index().Each(ctx, func(pb restic.PackedBlob) {
+		if err != nil {
+			return
+		}
+
+		pack, ok := e.packIDs[pb.PackID]
+		if !ok {
+			// the pack file is missing, its size is unknown
+			pack, err = e.packs.Insert(nil, pb.PackID.String(), nil)
+			if err != nil {
+				return
+			}
+			e.packIDs[pb.PackID] = pack
+		}
+
+		blob, ok := e.blobIDs[pb.BlobHandle]
+		if !ok {
+			blob, err = e.blobs.Insert(nil, pb.ID.String(), pb.Type.String(), int64(pb.DataLength()))
+			if err != nil {
+				return
+			}
+			e.blobIDs[pb.BlobHandle] = blob
+		}
+		_, err = e.packBlobs.Insert(pack, blob, int64(pb.Offset), int64(pb.Length))
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// ExportTree writes the tree, all subtrees and their nodes unless the tree
+// was already exported, and returns the row ID of the tree.
+func (e *metadataExporter) ExportTree(ctx context.Context, id restic.ID) (int64, error) {
+	if rowid, ok := e.treeIDs[id]; ok {
+		return rowid, nil
+	}
+
+	tree, err := restic.LoadTree(ctx, e.repo, id)
+	if err != nil {
+		return 0, errors.Fatalf("unable to load tree %v: %v", id.Str(), err)
+	}
+
+	// the subtrees are exported first, such that the nodes can reference them
+	subtrees := make([]interface{}, len(tree.Nodes))
+	for i, node := range tree.Nodes {
+		if node.Type != "dir" || node.Subtree == nil {
+			continue
+		}
+		subtrees[i], err = e.ExportTree(ctx, *node.Subtree)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	rowid, err := e.trees.Insert(nil, id.String())
+	if err != nil {
+		return 0, err
+	}
+	e.treeIDs[id] = rowid
+
+	for i, node := range tree.Nodes {
+		var contentID interface{}
+		if node.Type == "file" {
+			fid := makeFileIDByContents(node)
+			contentID = hex.EncodeToString(fid[:])
+		}
+
+		nodeID, err := e.nodes.Insert(nil, rowid, node.Name, node.Type, uint32(node.Mode),
+			node.ModTime.Format(time.RFC3339Nano), node.UID, node.GID, node.User, node.Group,
+			node.Inode, node.Links, node.Size, node.LinkTarget, contentID, subtrees[i])
+		if err != nil {
+			return 0, err
+		}
+
+		for pos, blobID := range node.Content {
+			// blobs missing from the index are not referenced
+			var blob interface{}
+			if rowid, ok := e.blobIDs[restic.BlobHandle{ID: blobID, Type: restic.DataBlob}]; ok {
+				blob = rowid
+			}
+			if _, err := e.content.Insert(nodeID, pos, blob); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return rowid, nil
+}
+
+// ExportSnapshot writes the snapshot and its trees.
+func (e *metadataExporter) ExportSnapshot(ctx context.Context, sn *restic.Snapshot) error {
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+	tree, err := e.ExportTree(ctx, *sn.Tree)
+	if err != nil {
+		return err
+	}
+
+	// the paths and tags are stored as JSON arrays, which can be queried
+	// using json_each
+	paths, err := json.Marshal(append([]string{}, sn.Paths...))
+	if err != nil {
+		return err
+	}
+	tags, err := json.Marshal(append([]string{}, sn.Tags...))
+	if err != nil {
+		return err
+	}
+	var parent interface{}
+	if sn.Parent != nil {
+		parent = sn.Parent.String()
+	}
+
+	_, err = e.snapshots.Insert(nil, sn.ID().String(), sn.Time.Format(time.RFC3339Nano), sn.Hostname,
+		sn.Username, string(paths), string(tags), tree, parent)
+	return err
+}
+
+func runExportMetadata(ctx context.Context, opts ExportMetadataOptions, gopts GlobalOptions, args []string) error {
+	if opts.SQLite == "" {
+		return errors.Fatal("please specify the database file with --sqlite")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshotLister, err := backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	db, err := sqlite.Create(opts.SQLite)
+	if errors.Is(err, os.ErrExist) {
+		return errors.Fatalf("%v already exists", opts.SQLite)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = exportMetadata(ctx, repo, db, FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, args))
+	if err == nil {
+		err = db.Close()
+	} else {
+		_ = db.Close()
+	}
+	if err != nil {
+		// do not leave an incomplete database behind
+		_ = os.Remove(opts.SQLite)
+		return err
+	}
+
+	Verbosef("wrote metadata to %v\n", opts.SQLite)
+	return nil
+}
+
+func exportMetadata(ctx context.Context, repo restic.Repository, db *sqlite.DB, snapshots <-chan *restic.Snapshot) error {
+	e := newMetadataExporter(repo, db)
+	if err := e.ExportIndex(ctx); err != nil {
+		return err
+	}
+
+	count := 0
+	for sn := range snapshots {
+		Verboseff("exporting snapshot %v\n", sn.ID().Str())
+		if err := e.ExportSnapshot(ctx, sn); err != nil {
+			return err
+		}
+		count++
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	Verbosef("exported %d snapshots with %d trees\n", count, len(e.treeIDs))
+	return nil
+}
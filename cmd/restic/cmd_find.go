@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"path"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/spf13/cobra"
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/dump"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/restic"
@@ -23,13 +28,31 @@ var cmdFind = &cobra.Command{
 	Long: `
 The "find" command searches for files or directories in snapshots stored in the
 repo.
-It can also be used to search for restic blobs or trees for troubleshooting.`,
+It can also be used to search for restic blobs or trees for troubleshooting.
+
+With --content, only files containing the given text are found. Similarly,
+--hash only finds files whose contents have the given SHA-256 hash, and
+--min-size and --max-size only find files within the size range. If no pattern
+is given, all files are searched.
+
+With --regex, the patterns are regular expressions which are matched against
+the full path of each entry. Instead of the matching entries, --count prints
+the number of matches in each snapshot and --print-snapshots-only prints only
+the snapshots which contain matches.
+
+If the repository contains a search index created by the "index-files"
+command, only the trees containing matching entries are loaded for the
+snapshots in the index.`,
 	Example: `restic find config.json
 restic find --json "*.yml" "*.json"
 restic find --json --blob 420f620f b46ebe8a ddd38656
 restic find --show-pack-id --blob 420f620f
 restic find --tree 577c2bc9 f81f2e22 a62827a9
 restic find --pack 025c1d06
+restic find --content "password" "*.conf"
+restic find --hash 2159dd48f8a24f33c307b750592773f8b71ff8d11452132a7b2e2a6a01611be1
+restic find --min-size 1G
+restic find --regex --count '/\.ssh/id_[a-z0-9]+$'
 
 EXIT STATUS
 ===========
@@ -51,6 +74,13 @@ type FindOptions struct {
 	PackID, ShowPackID bool
 	CaseInsensitive    bool
 	ListLong           bool
+	Content            string
+	Hashes             []string
+	MinSize, MaxSize   string
+	Regex              bool
+	SnapshotsOnly      bool
+	Count              bool
+	ShowSnapshot       bool
 	restic.SnapshotFilter
 }
 
@@ -69,6 +99,14 @@ func init() {
 	f.BoolVar(&findOptions.ShowPackID, "show-pack-id", false, "display the pack-ID the blobs belong to (with --blob or --tree)")
 	f.BoolVarP(&findOptions.CaseInsensitive, "ignore-case", "i", false, "ignore case for pattern")
 	f.BoolVarP(&findOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
+	f.BoolVar(&findOptions.Regex, "regex", false, "pattern is a regular expression matched against the full path")
+	f.BoolVar(&findOptions.SnapshotsOnly, "print-snapshots-only", false, "only print the snapshots containing matches")
+	f.BoolVar(&findOptions.Count, "count", false, "only print the number of matches in each snapshot")
+	f.BoolVar(&findOptions.ShowSnapshot, "show-snapshot", false, "show the snapshot ID and time next to each match")
+	f.StringVar(&findOptions.Content, "content", "", "only find files containing `text`")
+	f.StringArrayVar(&findOptions.Hashes, "hash", nil, "only find files whose contents have the SHA-256 `hash` (can be given multiple times)")
+	f.StringVar(&findOptions.MinSize, "min-size", "", "only find files of at least `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringVar(&findOptions.MaxSize, "max-size", "", "only find files of at most `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
 
 	initMultiSnapshotFilter(f, &findOptions.SnapshotFilter, true)
 }
@@ -76,7 +114,20 @@ func init() {
 type findPattern struct {
 	oldest, newest time.Time
 	pattern        []string
-	ignoreCase     bool
+	// regexps is only set if the patterns are regular expressions
+	regexps    []*regexp.Regexp
+	ignoreCase bool
+	// content is the text searched in the files
+	content string
+	// hashes contains the SHA-256 hashes of the searched file contents
+	hashes restic.IDSet
+	// minSize and maxSize limit the size of files, maxSize is -1 if unset
+	minSize, maxSize int64
+}
+
+// filesOnly returns true if only files can match.
+func (pat findPattern) filesOnly() bool {
+	return pat.content != "" || pat.hashes != nil || pat.minSize > 0 || pat.maxSize >= 0
 }
 
 var timeFormats = []string{
@@ -110,25 +161,52 @@ type statefulOutput struct {
 	newsn    *restic.Snapshot
 	oldsn    *restic.Snapshot
 	hits     int
+
+	// Count and SnapshotsOnly only print a summary for each snapshot
+	Count         bool
+	SnapshotsOnly bool
+	ShowSnapshot  bool
+}
+
+type findNode restic.Node
+
+// findMatch is the JSON representation of a node matching the pattern.
+type findMatch struct {
+	// Add these attributes
+	Path        string `json:"path,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+
+	*findNode
+
+	// Make the following attributes disappear
+	Name               byte `json:"name,omitempty" jsonschema:"-"`
+	Inode              byte `json:"inode,omitempty" jsonschema:"-"`
+	ExtendedAttributes byte `json:"extended_attributes,omitempty" jsonschema:"-"`
+	Device             byte `json:"device,omitempty" jsonschema:"-"`
+	Content            byte `json:"content,omitempty" jsonschema:"-"`
+	Subtree            byte `json:"subtree,omitempty" jsonschema:"-"`
+}
+
+// findSnapshot is the JSON representation of a snapshot containing matches,
+// which is printed with --count or --print-snapshots-only.
+type findSnapshot struct {
+	SnapshotID string    `json:"snapshot"`
+	Time       time.Time `json:"time"`
+	Hits       int       `json:"hits,omitempty"`
+}
+
+// findObject is the JSON representation of a blob or pack found by ID.
+type findObject struct {
+	ObjectType string    `json:"object_type"`
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`
+	ParentTree string    `json:"parent_tree,omitempty"`
+	SnapshotID string    `json:"snapshot"`
+	Time       time.Time `json:"time,omitempty"`
 }
 
 func (s *statefulOutput) PrintPatternJSON(path string, node *restic.Node) {
-	type findNode restic.Node
-	b, err := json.Marshal(struct {
-		// Add these attributes
-		Path        string `json:"path,omitempty"`
-		Permissions string `json:"permissions,omitempty"`
-
-		*findNode
-
-		// Make the following attributes disappear
-		Name               byte `json:"name,omitempty"`
-		Inode              byte `json:"inode,omitempty"`
-		ExtendedAttributes byte `json:"extended_attributes,omitempty"`
-		Device             byte `json:"device,omitempty"`
-		Content            byte `json:"content,omitempty"`
-		Subtree            byte `json:"subtree,omitempty"`
-	}{
+	b, err := json.Marshal(findMatch{
 		Path:        path,
 		Permissions: node.Mode.String(),
 		findNode:    (*findNode)(node),
@@ -164,27 +242,73 @@ func (s *statefulOutput) PrintPatternNormal(path string, node *restic.Node) {
 		s.oldsn = s.newsn
 		Verbosef("Found matching entries in snapshot %s from %s\n", s.oldsn.ID().Str(), s.oldsn.Time.Local().Format(TimeFormat))
 	}
+	if s.ShowSnapshot {
+		Printf("%s %s  %s\n", s.newsn.ID().Str(), s.newsn.Time.Local().Format(TimeFormat), formatNode(path, node, s.ListLong))
+		return
+	}
 	Println(formatNode(path, node, s.ListLong))
 }
 
+// CountPattern counts a match for the summary of the snapshot.
+func (s *statefulOutput) CountPattern() {
+	if s.newsn != s.oldsn {
+		s.PrintSnapshot()
+		s.oldsn = s.newsn
+		s.hits = 0
+	}
+	s.hits++
+}
+
+// PrintSnapshot prints the summary of the previous snapshot with matches.
+func (s *statefulOutput) PrintSnapshot() {
+	if s.oldsn == nil {
+		return
+	}
+
+	hits := s.hits
+	if s.SnapshotsOnly {
+		hits = 0
+	}
+	if !s.JSON {
+		if s.SnapshotsOnly {
+			Printf("%s %s\n", s.oldsn.ID().Str(), s.oldsn.Time.Local().Format(TimeFormat))
+		} else {
+			Printf("%s %s %d\n", s.oldsn.ID().Str(), s.oldsn.Time.Local().Format(TimeFormat), hits)
+		}
+		return
+	}
+
+	b, err := json.Marshal(findSnapshot{
+		SnapshotID: s.oldsn.ID().String(),
+		Time:       s.oldsn.Time,
+		Hits:       hits,
+	})
+	if err != nil {
+		Warnf("Marshall failed: %v\n", err)
+		return
+	}
+	if !s.inuse {
+		Printf("[")
+		s.inuse = true
+	} else {
+		Printf(",")
+	}
+	Print(string(b))
+}
+
 func (s *statefulOutput) PrintPattern(path string, node *restic.Node) {
-	if s.JSON {
+	switch {
+	case s.Count || s.SnapshotsOnly:
+		s.CountPattern()
+	case s.JSON:
 		s.PrintPatternJSON(path, node)
-	} else {
+	default:
 		s.PrintPatternNormal(path, node)
 	}
 }
 
 func (s *statefulOutput) PrintObjectJSON(kind, id, nodepath, treeID string, sn *restic.Snapshot) {
-	b, err := json.Marshal(struct {
-		// Add these attributes
-		ObjectType string    `json:"object_type"`
-		ID         string    `json:"id"`
-		Path       string    `json:"path"`
-		ParentTree string    `json:"parent_tree,omitempty"`
-		SnapshotID string    `json:"snapshot"`
-		Time       time.Time `json:"time,omitempty"`
-	}{
+	b, err := json.Marshal(findObject{
 		ObjectType: kind,
 		ID:         id,
 		Path:       nodepath,
@@ -227,6 +351,17 @@ func (s *statefulOutput) PrintObject(kind, id, nodepath, treeID string, sn *rest
 }
 
 func (s *statefulOutput) Finish() {
+	if s.Count || s.SnapshotsOnly {
+		s.PrintSnapshot()
+		if s.JSON {
+			if s.inuse {
+				Printf("]\n")
+			} else {
+				Printf("[]\n")
+			}
+		}
+		return
+	}
 	if s.JSON {
 		// do some finishing up
 		if s.oldsn != nil {
@@ -250,6 +385,138 @@ type Finder struct {
 	blobIDs     map[string]struct{}
 	treeIDs     map[string]struct{}
 	itemsFound  int
+
+	index   *searchIndex
+	matcher *searchIndexMatcher
+
+	text       *textMatcher
+	dumper     *dump.Dumper
+	textResult map[restic.ID]bool
+
+	hasher     *contentHasher
+	hashResult map[restic.ID]restic.ID
+}
+
+// matchPath returns whether the path matches any pattern and whether the
+// children of a directory at the path may match.
+func (f *Finder) matchPath(nodepath string, dir bool) (found, childMayMatch bool, err error) {
+	if f.pat.regexps != nil {
+		for _, re := range f.pat.regexps {
+			if re.MatchString(nodepath) {
+				return true, dir, nil
+			}
+		}
+		// any child may match a regular expression
+		return false, dir, nil
+	}
+
+	if f.pat.ignoreCase {
+		nodepath = strings.ToLower(nodepath)
+	}
+
+	for _, pat := range f.pat.pattern {
+		found, err = filter.Match(pat, nodepath)
+		if err != nil {
+			return false, false, err
+		}
+		if found {
+			break
+		}
+	}
+
+	if dir {
+		for _, pat := range f.pat.pattern {
+			childMayMatch, err = filter.ChildMatch(pat, nodepath)
+			if err != nil {
+				return false, false, err
+			}
+			if childMayMatch {
+				break
+			}
+		}
+	}
+	return found, childMayMatch, nil
+}
+
+// matchNode checks the node whose path matches against the remaining
+// criteria.
+func (f *Finder) matchNode(ctx context.Context, nodepath string, node *restic.Node) bool {
+	if !f.pat.oldest.IsZero() && node.ModTime.Before(f.pat.oldest) {
+		debug.Log("    ModTime is older than %s\n", f.pat.oldest)
+		return false
+	}
+
+	if !f.pat.newest.IsZero() && node.ModTime.After(f.pat.newest) {
+		debug.Log("    ModTime is newer than %s\n", f.pat.newest)
+		return false
+	}
+
+	if !f.pat.filesOnly() {
+		return true
+	}
+	if node.Type != "file" {
+		return false
+	}
+
+	if int64(node.Size) < f.pat.minSize || (f.pat.maxSize >= 0 && int64(node.Size) > f.pat.maxSize) {
+		debug.Log("    size %d is not within the range\n", node.Size)
+		return false
+	}
+
+	if f.pat.hashes != nil {
+		hash, err := f.contentHash(ctx, node)
+		if err != nil {
+			Warnf("unable to read %v: %v\n", nodepath, err)
+			return false
+		}
+		if !f.pat.hashes.Has(hash) {
+			return false
+		}
+	}
+
+	if f.pat.content != "" {
+		found, err := f.containsText(ctx, node)
+		if err != nil {
+			Warnf("unable to read %v: %v\n", nodepath, err)
+			return false
+		}
+		return found
+	}
+	return true
+}
+
+// containsText returns true if the file contains the searched text. Each
+// distinct content is only read once.
+func (f *Finder) containsText(ctx context.Context, node *restic.Node) (bool, error) {
+	key := fileContentKey(node)
+	if found, ok := f.textResult[key]; ok {
+		return found, nil
+	}
+
+	f.text.Reset()
+	err := f.dumper.WriteNode(ctx, node)
+	if err != nil && !errors.Is(err, errTextFound) {
+		return false, err
+	}
+	found := errors.Is(err, errTextFound)
+	f.textResult[key] = found
+	return found, nil
+}
+
+// contentHash returns the SHA-256 hash of the contents of the file. Each
+// distinct content is only hashed once.
+func (f *Finder) contentHash(ctx context.Context, node *restic.Node) (restic.ID, error) {
+	key := fileContentKey(node)
+	if hash, ok := f.hashResult[key]; ok {
+		return hash, nil
+	}
+
+	hash, err := f.hasher.Hash(ctx, node)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	f.hashResult[key] = hash
+	return hash, nil
 }
 
 func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error {
@@ -260,7 +527,7 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 	}
 
 	f.out.newsn = sn
-	return walker.Walk(ctx, f.repo, *sn.Tree, f.ignoreTrees, func(parentTreeID restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+	err := walker.Walk(ctx, f.repo, *sn.Tree, f.ignoreTrees, func(parentTreeID restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
 		if err != nil {
 			debug.Log("Error loading tree %v: %v", parentTreeID, err)
 
@@ -273,22 +540,9 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 			return false, nil
 		}
 
-		normalizedNodepath := nodepath
-		if f.pat.ignoreCase {
-			normalizedNodepath = strings.ToLower(nodepath)
-		}
-
-		var foundMatch bool
-
-		for _, pat := range f.pat.pattern {
-			found, err := filter.Match(pat, normalizedNodepath)
-			if err != nil {
-				return false, err
-			}
-			if found {
-				foundMatch = true
-				break
-			}
+		foundMatch, childMayMatch, err := f.matchPath(nodepath, node.Type == "dir")
+		if err != nil {
+			return false, err
 		}
 
 		var (
@@ -296,18 +550,6 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 			errIfNoMatch    error
 		)
 		if node.Type == "dir" {
-			var childMayMatch bool
-			for _, pat := range f.pat.pattern {
-				mayMatch, err := filter.ChildMatch(pat, normalizedNodepath)
-				if err != nil {
-					return false, err
-				}
-				if mayMatch {
-					childMayMatch = true
-					break
-				}
-			}
-
 			if !childMayMatch {
 				ignoreIfNoMatch = true
 				errIfNoMatch = walker.ErrSkipNode
@@ -320,20 +562,95 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 			return ignoreIfNoMatch, errIfNoMatch
 		}
 
-		if !f.pat.oldest.IsZero() && node.ModTime.Before(f.pat.oldest) {
-			debug.Log("    ModTime is older than %s\n", f.pat.oldest)
-			return ignoreIfNoMatch, errIfNoMatch
-		}
-
-		if !f.pat.newest.IsZero() && node.ModTime.After(f.pat.newest) {
-			debug.Log("    ModTime is newer than %s\n", f.pat.newest)
+		if !f.matchNode(ctx, nodepath, node) {
 			return ignoreIfNoMatch, errIfNoMatch
 		}
 
 		debug.Log("    found match\n")
 		f.out.PrintPattern(nodepath, node)
+		if f.out.SnapshotsOnly {
+			return false, errSnapshotMatched
+		}
 		return false, nil
 	})
+	if err == errSnapshotMatched {
+		return nil
+	}
+	return err
+}
+
+// errSnapshotMatched stops searching a snapshot once it is known to contain
+// matches.
+var errSnapshotMatched = errors.New("snapshot contains matches")
+
+// findInIndex searches the snapshot using the search index. Only the trees
+// which contain entries whose path matches are loaded from the repository.
+func (f *Finder) findInIndex(ctx context.Context, sn *restic.Snapshot, ref searchIndexRef) error {
+	debug.Log("searching in snapshot %s using the search index", sn.ID())
+
+	f.out.newsn = sn
+	err := f.findInIndexTree(ctx, sn, ref.index, ref.tree, "/", false)
+	if err == errSnapshotMatched {
+		return nil
+	}
+	return err
+}
+
+func (f *Finder) findInIndexTree(ctx context.Context, sn *restic.Snapshot, idx *searchIndexFile, treeIdx int, prefix string, parentMatch bool) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !f.matcher.TreeMayMatch(idx, treeIdx, parentMatch) {
+		return nil
+	}
+
+	entry := idx.Trees[treeIdx]
+	var tree *restic.Tree
+	for i, name := range entry.Names {
+		nodepath := path.Join(prefix, idx.Names[name])
+		dir := entry.Subtrees[i] >= 0
+
+		found, childMayMatch, err := f.matchPath(nodepath, dir)
+		if err != nil {
+			return err
+		}
+
+		printed := false
+		if found && f.matcher.ContentMayMatch(idx, treeIdx, i) {
+			if tree == nil {
+				tree, err = restic.LoadTree(ctx, f.repo, entry.ID)
+				if err == nil && len(tree.Nodes) != len(entry.Names) {
+					err = errors.New("tree does not match the search index")
+				}
+				if err != nil {
+					debug.Log("Error loading tree %v: %v", entry.ID, err)
+
+					Printf("Unable to load tree %s\n ... which belongs to snapshot %s\n", entry.ID, sn.ID())
+
+					return nil
+				}
+				// the index is sorted by name like the walker
+				sort.Sort(restic.Nodes(tree.Nodes))
+			}
+
+			node := tree.Nodes[i]
+			if f.matchNode(ctx, nodepath, node) {
+				debug.Log("    found match\n")
+				f.out.PrintPattern(nodepath, node)
+				if f.out.SnapshotsOnly {
+					return errSnapshotMatched
+				}
+				printed = true
+			}
+		}
+
+		if dir && (printed || childMayMatch) {
+			if err := f.findInIndexTree(ctx, sn, idx, entry.Subtrees[i], nodepath, found); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (f *Finder) findIDs(ctx context.Context, sn *restic.Snapshot) error {
@@ -535,17 +852,64 @@ func (f *Finder) findObjectsPacks(ctx context.Context) {
 }
 
 func runFind(ctx context.Context, opts FindOptions, gopts GlobalOptions, args []string) error {
-	if len(args) == 0 {
+	var err error
+	pat := findPattern{content: opts.Content, maxSize: -1}
+	if len(opts.Hashes) > 0 {
+		if pat.hashes, err = parseContentHashes(opts.Hashes); err != nil {
+			return err
+		}
+	}
+	if opts.MinSize != "" {
+		if pat.minSize, err = parseSizeStr(opts.MinSize); err != nil {
+			return errors.Fatalf("invalid --min-size: %v", err)
+		}
+	}
+	if opts.MaxSize != "" {
+		if pat.maxSize, err = parseSizeStr(opts.MaxSize); err != nil {
+			return errors.Fatalf("invalid --max-size: %v", err)
+		}
+		if pat.maxSize < 0 {
+			return errors.Fatal("--max-size must not be negative")
+		}
+	}
+
+	if len(args) == 0 && !pat.filesOnly() {
 		return errors.Fatal("wrong number of arguments")
 	}
+	if pat.filesOnly() && (opts.BlobID || opts.TreeID || opts.PackID) {
+		return errors.Fatal("--content, --hash, --min-size and --max-size cannot be used with --blob, --tree or --pack")
+	}
+	if len(args) == 0 {
+		// search all files
+		args = []string{"*"}
+	}
+	pat.pattern = args
+	if opts.Count && opts.SnapshotsOnly {
+		return errors.Fatal("--count and --print-snapshots-only cannot be used together")
+	}
+	if (opts.Regex || opts.Count || opts.SnapshotsOnly || opts.ShowSnapshot) && (opts.BlobID || opts.TreeID || opts.PackID) {
+		return errors.Fatal("--regex, --count, --print-snapshots-only and --show-snapshot cannot be used with --blob, --tree or --pack")
+	}
 
-	var err error
-	pat := findPattern{pattern: args}
-	if opts.CaseInsensitive {
+	if opts.CaseInsensitive || gopts.IgnoreCase {
+		pat.ignoreCase = true
+		pat.content = strings.ToLower(pat.content)
+	}
+	if opts.Regex {
+		for _, p := range pat.pattern {
+			if pat.ignoreCase {
+				p = "(?i)" + p
+			}
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return errors.Fatalf("invalid regular expression %q: %v", p, err)
+			}
+			pat.regexps = append(pat.regexps, re)
+		}
+	} else if pat.ignoreCase {
 		for i := range pat.pattern {
 			pat.pattern[i] = strings.ToLower(pat.pattern[i])
 		}
-		pat.ignoreCase = true
 	}
 
 	if opts.Oldest != "" {
@@ -594,7 +958,7 @@ func runFind(ctx context.Context, opts FindOptions, gopts GlobalOptions, args []
 	f := &Finder{
 		repo:        repo,
 		pat:         pat,
-		out:         statefulOutput{ListLong: opts.ListLong, JSON: globalOptions.JSON},
+		out:         statefulOutput{ListLong: opts.ListLong, JSON: globalOptions.JSON, Count: opts.Count, SnapshotsOnly: opts.SnapshotsOnly, ShowSnapshot: opts.ShowSnapshot},
 		ignoreTrees: restic.NewIDSet(),
 	}
 
@@ -618,6 +982,24 @@ func runFind(ctx context.Context, opts FindOptions, gopts GlobalOptions, args []
 		}
 	}
 
+	if f.blobIDs == nil && f.treeIDs == nil {
+		f.index, err = loadSearchIndex(ctx, repo)
+		if err != nil {
+			Warnf("unable to load the search index, searching without it: %v\n", err)
+			f.index = nil
+		}
+		f.matcher = newSearchIndexMatcher(pat)
+	}
+	if pat.content != "" {
+		f.text = newTextMatcher(pat.content, pat.ignoreCase)
+		f.dumper = dump.New("", repo, f.text)
+		f.textResult = make(map[restic.ID]bool)
+	}
+	if pat.hashes != nil {
+		f.hasher = newContentHasher(repo)
+		f.hashResult = make(map[restic.ID]restic.ID)
+	}
+
 	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, opts.Snapshots) {
 		if f.blobIDs != nil || f.treeIDs != nil {
 			if err = f.findIDs(ctx, sn); err != nil && err.Error() != "OK" {
@@ -625,6 +1007,14 @@ func runFind(ctx context.Context, opts FindOptions, gopts GlobalOptions, args []
 			}
 			continue
 		}
+		if f.index != nil {
+			if ref, ok := f.index.Lookup(sn); ok {
+				if err = f.findInIndex(ctx, sn, ref); err != nil {
+					return err
+				}
+				continue
+			}
+		}
 		if err = f.findInSnapshot(ctx, sn); err != nil {
 			return err
 		}
@@ -637,3 +1027,54 @@ func runFind(ctx context.Context, opts FindOptions, gopts GlobalOptions, args []
 
 	return nil
 }
+
+var errTextFound = errors.New("text found")
+
+// textMatcher checks whether the data written to it contains the text. Once
+// the text is found, Write returns errTextFound to stop reading the file.
+type textMatcher struct {
+	text       []byte
+	ignoreCase bool
+	// tail contains the end of the previous data, in which a match may start
+	tail []byte
+}
+
+func newTextMatcher(text string, ignoreCase bool) *textMatcher {
+	return &textMatcher{text: []byte(text), ignoreCase: ignoreCase}
+}
+
+func (m *textMatcher) Reset() {
+	m.tail = m.tail[:0]
+}
+
+func (m *textMatcher) Write(p []byte) (int, error) {
+	buf := append(m.tail, p...)
+
+	data := buf
+	keep := len(m.text) - 1
+	if m.ignoreCase {
+		// only convert complete characters, the case conversion may change
+		// the length of a character
+		n := len(buf)
+		for i := 1; i < utf8.UTFMax && i <= n; i++ {
+			if utf8.RuneStart(buf[n-i]) {
+				if !utf8.FullRune(buf[n-i:]) {
+					n -= i
+				}
+				break
+			}
+		}
+		data = bytes.ToLower(buf[:n])
+		keep = len(buf) - n + len(m.text)*utf8.UTFMax
+	}
+
+	if bytes.Contains(data, m.text) {
+		return len(p), errTextFound
+	}
+
+	if keep > len(buf) {
+		keep = len(buf)
+	}
+	m.tail = append(buf[:0], buf[len(buf)-keep:]...)
+	return len(p), nil
+}
@@ -4,10 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"strings"
+	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var cmdForget = &cobra.Command{
@@ -43,6 +48,7 @@ type ForgetOptions struct {
 	Daily         int
 	Weekly        int
 	Monthly       int
+	Quarterly     int
 	Yearly        int
 	Within        restic.Duration
 	WithinHourly  restic.Duration
@@ -51,6 +57,15 @@ type ForgetOptions struct {
 	WithinMonthly restic.Duration
 	WithinYearly  restic.Duration
 	KeepTags      restic.TagLists
+	KeepIDsFrom   string
+	ForgetIDsFrom string
+	TagPolicies   []string
+
+	// Calendar
+	WeekStart      string
+	MonthStartDay  int
+	YearStartMonth int
+	KeepFirst      bool
 
 	restic.SnapshotFilter
 	Compact bool
@@ -59,6 +74,12 @@ type ForgetOptions struct {
 	GroupBy restic.SnapshotGroupByOptions
 	DryRun  bool
 	Prune   bool
+
+	ForceUnprotect bool
+
+	SimulateUntil    string
+	SimulateInterval restic.Duration
+	simulateUntil    time.Time
 }
 
 var forgetOptions ForgetOptions
@@ -67,19 +88,10 @@ func init() {
 	cmdRoot.AddCommand(cmdForget)
 
 	f := cmdForget.Flags()
-	f.IntVarP(&forgetOptions.Last, "keep-last", "l", 0, "keep the last `n` snapshots (use '-1' to keep all snapshots)")
-	f.IntVarP(&forgetOptions.Hourly, "keep-hourly", "H", 0, "keep the last `n` hourly snapshots (use '-1' to keep all hourly snapshots)")
-	f.IntVarP(&forgetOptions.Daily, "keep-daily", "d", 0, "keep the last `n` daily snapshots (use '-1' to keep all daily snapshots)")
-	f.IntVarP(&forgetOptions.Weekly, "keep-weekly", "w", 0, "keep the last `n` weekly snapshots (use '-1' to keep all weekly snapshots)")
-	f.IntVarP(&forgetOptions.Monthly, "keep-monthly", "m", 0, "keep the last `n` monthly snapshots (use '-1' to keep all monthly snapshots)")
-	f.IntVarP(&forgetOptions.Yearly, "keep-yearly", "y", 0, "keep the last `n` yearly snapshots (use '-1' to keep all yearly snapshots)")
-	f.VarP(&forgetOptions.Within, "keep-within", "", "keep snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
-	f.VarP(&forgetOptions.WithinHourly, "keep-within-hourly", "", "keep hourly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
-	f.VarP(&forgetOptions.WithinDaily, "keep-within-daily", "", "keep daily snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
-	f.VarP(&forgetOptions.WithinWeekly, "keep-within-weekly", "", "keep weekly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
-	f.VarP(&forgetOptions.WithinMonthly, "keep-within-monthly", "", "keep monthly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
-	f.VarP(&forgetOptions.WithinYearly, "keep-within-yearly", "", "keep yearly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
-	f.Var(&forgetOptions.KeepTags, "keep-tag", "keep snapshots with this `taglist` (can be specified multiple times)")
+	initForgetPolicyFlags(f, &forgetOptions)
+	f.StringVar(&forgetOptions.KeepIDsFrom, "keep-ids-from", "", "always keep the snapshots whose IDs are listed in `file` (use - for stdin)")
+	f.StringArrayVar(&forgetOptions.TagPolicies, "policy", nil, "apply a separate policy to snapshots with tags, in the format `tag=taglist:keep-option=value[,...]` (can be specified multiple times)")
+	f.StringVar(&forgetOptions.ForgetIDsFrom, "forget-ids-from", "", "always remove the snapshots whose IDs are listed in `file` (use - for stdin)")
 
 	initMultiSnapshotFilter(f, &forgetOptions.SnapshotFilter, false)
 	f.StringArrayVar(&forgetOptions.Hosts, "hostname", nil, "only consider snapshots with the given `hostname` (can be specified multiple times)")
@@ -94,14 +106,178 @@ func init() {
 	f.VarP(&forgetOptions.GroupBy, "group-by", "g", "`group` snapshots by host, paths and/or tags, separated by comma (disable grouping with '')")
 	f.BoolVarP(&forgetOptions.DryRun, "dry-run", "n", false, "do not delete anything, just print what would be done")
 	f.BoolVar(&forgetOptions.Prune, "prune", false, "automatically run the 'prune' command if snapshots have been removed")
+	f.BoolVar(&forgetOptions.ForceUnprotect, "force-unprotect", false, "also remove protected snapshots")
+	f.StringVar(&forgetOptions.SimulateUntil, "simulate-until", "", "do not remove anything, but show when snapshots would be removed by regular runs until `time`")
+	forgetOptions.SimulateInterval = restic.Duration{Days: 1}
+	f.Var(&forgetOptions.SimulateInterval, "simulate-interval", "assume a new backup is created every `duration` (eg. 1d12h) for --simulate-until")
 
 	f.SortFlags = false
 	addPruneOptions(cmdForget)
 }
 
+// initForgetPolicyFlags registers the flags of the retention policy.
+func initForgetPolicyFlags(f *pflag.FlagSet, opts *ForgetOptions) {
+	f.IntVarP(&opts.Last, "keep-last", "l", 0, "keep the last `n` snapshots (use '-1' to keep all snapshots)")
+	f.IntVarP(&opts.Hourly, "keep-hourly", "H", 0, "keep the last `n` hourly snapshots (use '-1' to keep all hourly snapshots)")
+	f.IntVarP(&opts.Daily, "keep-daily", "d", 0, "keep the last `n` daily snapshots (use '-1' to keep all daily snapshots)")
+	f.IntVarP(&opts.Weekly, "keep-weekly", "w", 0, "keep the last `n` weekly snapshots (use '-1' to keep all weekly snapshots)")
+	f.IntVarP(&opts.Monthly, "keep-monthly", "m", 0, "keep the last `n` monthly snapshots (use '-1' to keep all monthly snapshots)")
+	f.IntVar(&opts.Quarterly, "keep-quarterly", 0, "keep the last `n` quarterly snapshots (use '-1' to keep all quarterly snapshots)")
+	f.IntVarP(&opts.Yearly, "keep-yearly", "y", 0, "keep the last `n` yearly snapshots (use '-1' to keep all yearly snapshots)")
+	f.VarP(&opts.Within, "keep-within", "", "keep snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&opts.WithinHourly, "keep-within-hourly", "", "keep hourly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&opts.WithinDaily, "keep-within-daily", "", "keep daily snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&opts.WithinWeekly, "keep-within-weekly", "", "keep weekly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&opts.WithinMonthly, "keep-within-monthly", "", "keep monthly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&opts.WithinYearly, "keep-within-yearly", "", "keep yearly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.Var(&opts.KeepTags, "keep-tag", "keep snapshots with this `taglist` (can be specified multiple times)")
+	f.StringVar(&opts.WeekStart, "week-start", "", "first `day` of a week for weekly snapshots (default: monday)")
+	f.IntVar(&opts.MonthStartDay, "month-start-day", 0, "`day` of the month (1-28) on which months begin for monthly, quarterly and yearly snapshots")
+	f.IntVar(&opts.YearStartMonth, "year-start-month", 0, "first `month` (1-12) of a (fiscal) year and its quarters")
+	f.BoolVar(&opts.KeepFirst, "keep-first", false, "keep the first instead of the last snapshot of each hour, day, week, month, quarter and year")
+}
+
+// parseWeekday parses the English name of a day of the week.
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) {
+			return d, nil
+		}
+	}
+	return 0, errors.Fatalf("invalid day of the week %q", s)
+}
+
+// policy returns the retention policy set by the --keep-* options.
+func (opts *ForgetOptions) policy() restic.ExpirePolicy {
+	policy := restic.ExpirePolicy{
+		Last:          opts.Last,
+		Hourly:        opts.Hourly,
+		Daily:         opts.Daily,
+		Weekly:        opts.Weekly,
+		Monthly:       opts.Monthly,
+		Quarterly:     opts.Quarterly,
+		Yearly:        opts.Yearly,
+		Within:        opts.Within,
+		WithinHourly:  opts.WithinHourly,
+		WithinDaily:   opts.WithinDaily,
+		WithinWeekly:  opts.WithinWeekly,
+		WithinMonthly: opts.WithinMonthly,
+		WithinYearly:  opts.WithinYearly,
+		Tags:          opts.KeepTags,
+		Calendar: restic.PolicyCalendar{
+			MonthStartDay:  opts.MonthStartDay,
+			YearStartMonth: time.Month(opts.YearStartMonth),
+			KeepFirst:      opts.KeepFirst,
+		},
+	}
+	if opts.WeekStart != "" {
+		// the value is checked in verifyForgetOptions
+		weekStart, _ := parseWeekday(opts.WeekStart)
+		policy.Calendar.WeekStart = &weekStart
+	}
+	return policy
+}
+
+// tagPolicy is a retention policy for the snapshots which have all tags of a
+// tag list, set using --policy.
+type tagPolicy struct {
+	tags   restic.TagList
+	policy restic.ExpirePolicy
+}
+
+// parseTagPolicy parses a tag policy in the format
+// "tag=taglist:keep-option=value[,keep-option=value...]". The options are the
+// same as the policy options of forget without the leading dashes.
+func parseTagPolicy(s string) (tagPolicy, error) {
+	selector, options, ok := strings.Cut(s, ":")
+	if !ok || !strings.HasPrefix(selector, "tag=") || options == "" {
+		return tagPolicy{}, errors.Fatalf("invalid policy %q, must be in the format tag=taglist:keep-option=value[,...]", s)
+	}
+
+	var tp tagPolicy
+	if err := tp.tags.Set(strings.TrimPrefix(selector, "tag=")); err != nil {
+		return tagPolicy{}, err
+	}
+	for _, tag := range tp.tags {
+		if tag == "" {
+			return tagPolicy{}, errors.Fatalf("invalid policy %q: empty tag", s)
+		}
+	}
+
+	var opts ForgetOptions
+	f := forgetPolicyFlags(&opts)
+	for _, option := range strings.Split(options, ",") {
+		name, value, _ := strings.Cut(option, "=")
+		if f.Lookup(name) == nil {
+			return tagPolicy{}, errors.Fatalf("invalid policy %q: unknown option %q", s, name)
+		}
+		if err := f.Set(name, value); err != nil {
+			return tagPolicy{}, errors.Fatalf("invalid policy %q: invalid value %q for %v: %v", s, value, name, err)
+		}
+	}
+	if err := verifyForgetOptions(&opts); err != nil {
+		return tagPolicy{}, err
+	}
+
+	tp.policy = opts.policy()
+	if tp.policy.Empty() {
+		return tagPolicy{}, errors.Fatalf("invalid policy %q: no keep option given", s)
+	}
+	return tp, nil
+}
+
+// tagPolicies parses the policies set using --policy.
+func (opts *ForgetOptions) tagPolicies() ([]tagPolicy, error) {
+	var policies []tagPolicy
+	for _, s := range opts.TagPolicies {
+		tp, err := parseTagPolicy(s)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, tp)
+	}
+	return policies, nil
+}
+
+// applyForgetPolicies applies the first of tagPolicies whose tags are all
+// contained in a snapshot to it, and policy to the remaining snapshots. The
+// order of keep and reasons matches.
+func applyForgetPolicies(list restic.Snapshots, policy restic.ExpirePolicy, tagPolicies []tagPolicy) (keep, remove restic.Snapshots, reasons []restic.KeepReason) {
+	if len(tagPolicies) == 0 {
+		return restic.ApplyPolicy(list, policy)
+	}
+
+	parts := make([]restic.Snapshots, len(tagPolicies)+1)
+	for _, sn := range list {
+		i := len(tagPolicies)
+		for j, tp := range tagPolicies {
+			if sn.HasTags(tp.tags) {
+				i = j
+				break
+			}
+		}
+		parts[i] = append(parts[i], sn)
+	}
+
+	for i, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		p := policy
+		if i < len(tagPolicies) {
+			p = tagPolicies[i].policy
+		}
+		k, r, kr := restic.ApplyPolicy(part, p)
+		keep = append(keep, k...)
+		remove = append(remove, r...)
+		reasons = append(reasons, kr...)
+	}
+	return keep, remove, reasons
+}
+
 func verifyForgetOptions(opts *ForgetOptions) error {
 	if opts.Last < -1 || opts.Hourly < -1 || opts.Daily < -1 || opts.Weekly < -1 ||
-		opts.Monthly < -1 || opts.Yearly < -1 {
+		opts.Monthly < -1 || opts.Quarterly < -1 || opts.Yearly < -1 {
 		return errors.Fatal("negative values other than -1 are not allowed for --keep-*")
 	}
 
@@ -112,9 +288,81 @@ func verifyForgetOptions(opts *ForgetOptions) error {
 		}
 	}
 
+	if opts.WeekStart != "" {
+		if _, err := parseWeekday(opts.WeekStart); err != nil {
+			return errors.Fatalf("invalid day of the week %q for --week-start", opts.WeekStart)
+		}
+	}
+	if opts.MonthStartDay < 0 || opts.MonthStartDay > 28 {
+		return errors.Fatal("--month-start-day must be between 1 and 28")
+	}
+	if opts.YearStartMonth < 0 || opts.YearStartMonth > 12 {
+		return errors.Fatal("--year-start-month must be between 1 and 12")
+	}
+
+	if opts.KeepIDsFrom == "-" && opts.ForgetIDsFrom == "-" {
+		return errors.Fatal("--keep-ids-from and --forget-ids-from cannot both read from stdin")
+	}
+
+	if _, err := opts.tagPolicies(); err != nil {
+		return err
+	}
+
+	if opts.SimulateUntil != "" {
+		d := opts.SimulateInterval
+		if d.Hours < 0 || d.Days < 0 || d.Months < 0 || d.Years < 0 || d.Zero() {
+			return errors.Fatal("--simulate-interval must be a positive duration")
+		}
+		if opts.KeepIDsFrom != "" || opts.ForgetIDsFrom != "" {
+			return errors.Fatal("--simulate-until cannot be combined with --keep-ids-from or --forget-ids-from")
+		}
+		if opts.Prune || opts.ForceUnprotect {
+			return errors.Fatal("--simulate-until cannot be combined with --prune or --force-unprotect")
+		}
+		if len(opts.TagPolicies) > 0 {
+			return errors.Fatal("--simulate-until cannot be combined with --policy")
+		}
+	}
+
 	return nil
 }
 
+// readSnapshotIDs reads the snapshot IDs listed in filename, one per line.
+// Empty lines and lines starting with # are ignored. Short IDs are resolved
+// against all, IDs of snapshots which do not exist are skipped with a warning.
+func readSnapshotIDs(filename string, all restic.IDs) (restic.IDSet, error) {
+	lines, err := readLines(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read snapshot IDs from %v: %v", filename, err)
+	}
+
+	ids := restic.NewIDSet()
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var matches restic.IDs
+		for _, id := range all {
+			if strings.HasPrefix(id.String(), line) {
+				matches = append(matches, id)
+			}
+		}
+
+		switch len(matches) {
+		case 0:
+			Warnf("%v: snapshot %v not found, ignoring\n", filename, line)
+		case 1:
+			ids.Insert(matches[0])
+		default:
+			return nil, errors.Fatalf("%v: multiple snapshots with prefix %q found", filename, line)
+		}
+	}
+
+	return ids, nil
+}
+
 func runForget(ctx context.Context, opts ForgetOptions, gopts GlobalOptions, args []string) error {
 	err := verifyForgetOptions(&opts)
 	if err != nil {
@@ -126,6 +374,18 @@ func runForget(ctx context.Context, opts ForgetOptions, gopts GlobalOptions, arg
 		return err
 	}
 
+	if opts.SimulateUntil != "" {
+		if len(args) > 0 {
+			return errors.Fatal("--simulate-until cannot be combined with explicit snapshot IDs")
+		}
+		opts.simulateUntil, err = parseTime(opts.SimulateUntil)
+		if err != nil {
+			return err
+		}
+		// a simulation never removes anything
+		opts.DryRun = true
+	}
+
 	repo, err := OpenRepository(ctx, gopts)
 	if err != nil {
 		return err
@@ -144,10 +404,83 @@ func runForget(ctx context.Context, opts ForgetOptions, gopts GlobalOptions, arg
 		}
 	}
 
+	jsonGroups, removeSnIDs, err := forgetSnapshots(ctx, opts, gopts, repo, args)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON && len(jsonGroups) > 0 {
+		err = printJSONForget(gopts.stdout, jsonGroups)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(removeSnIDs) > 0 && opts.Prune {
+		if !gopts.JSON {
+			if opts.DryRun {
+				Verbosef("%d snapshots would be removed, running prune dry run\n", len(removeSnIDs))
+			} else {
+				Verbosef("%d snapshots have been removed, running prune\n", len(removeSnIDs))
+			}
+		}
+		pruneOptions.DryRun = opts.DryRun
+		return runPruneWithRepo(ctx, pruneOptions, gopts, repo, removeSnIDs)
+	}
+
+	return nil
+}
+
+// forgetSnapshots applies the policy from opts to the snapshots in repo, or
+// removes the snapshots given in args. The repository must already be locked.
+// It returns the groups of snapshots for the JSON output and the IDs of the
+// removed snapshots, which are only reported but not removed for a dry run.
+func forgetSnapshots(ctx context.Context, opts ForgetOptions, gopts GlobalOptions, repo *repository.Repository, args []string) ([]*ForgetGroup, restic.IDSet, error) {
+	var err error
+	var snapshotLister restic.Lister = repo.Backend()
+	var keepIDs, forgetIDs restic.IDSet
+	if opts.KeepIDsFrom != "" || opts.ForgetIDsFrom != "" {
+		if len(args) > 0 {
+			return nil, nil, errors.Fatal("--keep-ids-from and --forget-ids-from cannot be combined with explicit snapshot IDs")
+		}
+
+		snapshotLister, err = backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var all restic.IDs
+		err = snapshotLister.List(ctx, restic.SnapshotFile, func(fi restic.FileInfo) error {
+			id, err := restic.ParseID(fi.Name)
+			if err == nil {
+				all = append(all, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		keepIDs, err = readSnapshotIDs(opts.KeepIDsFrom, all)
+		if err != nil {
+			return nil, nil, err
+		}
+		forgetIDs, err = readSnapshotIDs(opts.ForgetIDsFrom, all)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for id := range keepIDs {
+			if forgetIDs.Has(id) {
+				return nil, nil, errors.Fatalf("snapshot %v is listed both in %v and %v", id.Str(), opts.KeepIDsFrom, opts.ForgetIDsFrom)
+			}
+		}
+	}
+	useIDLists := len(keepIDs) > 0 || len(forgetIDs) > 0
+
 	var snapshots restic.Snapshots
 	removeSnIDs := restic.NewIDSet()
 
-	for sn := range FindFilteredSnapshots(ctx, repo.Backend(), repo, &opts.SnapshotFilter, args) {
+	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, args) {
 		snapshots = append(snapshots, sn)
 	}
 
@@ -156,52 +489,65 @@ func runForget(ctx context.Context, opts ForgetOptions, gopts GlobalOptions, arg
 	if len(args) > 0 {
 		// When explicit snapshots args are given, remove them immediately.
 		for _, sn := range snapshots {
+			if sn.Protected && !opts.ForceUnprotect {
+				Warnf("snapshot %v is protected, not removing it (use --force-unprotect to override)\n", sn.ID().Str())
+				continue
+			}
 			removeSnIDs.Insert(*sn.ID())
 		}
 	} else {
 		snapshotGroups, _, err := restic.GroupSnapshots(snapshots, opts.GroupBy)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
-		policy := restic.ExpirePolicy{
-			Last:          opts.Last,
-			Hourly:        opts.Hourly,
-			Daily:         opts.Daily,
-			Weekly:        opts.Weekly,
-			Monthly:       opts.Monthly,
-			Yearly:        opts.Yearly,
-			Within:        opts.Within,
-			WithinHourly:  opts.WithinHourly,
-			WithinDaily:   opts.WithinDaily,
-			WithinWeekly:  opts.WithinWeekly,
-			WithinMonthly: opts.WithinMonthly,
-			WithinYearly:  opts.WithinYearly,
-			Tags:          opts.KeepTags,
+		policy := opts.policy()
+		if policy.Empty() && !useIDLists {
+			// use the retention policy stored in the repository
+			defaults, err := forgetPolicyDefaults(repo.Config().Defaults)
+			if err != nil {
+				return nil, nil, err
+			}
+			policy = defaults.policy()
 		}
 
-		if policy.Empty() && len(args) == 0 {
+		// the options were checked in verifyForgetOptions
+		tagPolicies, _ := opts.tagPolicies()
+
+		if policy.Empty() && !useIDLists && len(tagPolicies) == 0 {
 			if !gopts.JSON {
 				Verbosef("no policy was specified, no snapshots will be removed\n")
 			}
 		}
 
-		if !policy.Empty() {
+		if !policy.Empty() || useIDLists || len(tagPolicies) > 0 {
 			if !gopts.JSON {
-				Verbosef("Applying Policy: %v\n", policy)
+				for _, tp := range tagPolicies {
+					Verbosef("Applying Policy to snapshots with tags %v: %v\n", tp.tags, tp.policy)
+				}
+				if !policy.Empty() {
+					if len(tagPolicies) > 0 {
+						Verbosef("Applying Policy to other snapshots: %v\n", policy)
+					} else {
+						Verbosef("Applying Policy: %v\n", policy)
+					}
+				}
+				if useIDLists {
+					Verbosef("Applying snapshot ID lists: keeping %d, forgetting %d snapshots\n", len(keepIDs), len(forgetIDs))
+				}
 			}
 
 			for k, snapshotGroup := range snapshotGroups {
 				if gopts.Verbose >= 1 && !gopts.JSON {
 					err = PrintSnapshotGroupHeader(gopts.stdout, k)
 					if err != nil {
-						return err
+						return nil, nil, err
 					}
 				}
 
 				var key restic.SnapshotGroupKey
 				if json.Unmarshal([]byte(k), &key) != nil {
-					return err
+					return nil, nil, err
 				}
 
 				var fg ForgetGroup
@@ -209,7 +555,19 @@ func runForget(ctx context.Context, opts ForgetOptions, gopts GlobalOptions, arg
 				fg.Host = key.Hostname
 				fg.Paths = key.Paths
 
-				keep, remove, reasons := restic.ApplyPolicy(snapshotGroup, policy)
+				if !opts.simulateUntil.IsZero() {
+					fg.Simulation = simulateForget(gopts, snapshotGroup, policy, opts, opts.simulateUntil)
+					jsonGroups = append(jsonGroups, &fg)
+					continue
+				}
+
+				keep, remove, reasons := applyForgetPolicies(snapshotGroup, policy, tagPolicies)
+				if useIDLists {
+					keep, remove, reasons = restic.ApplyIDLists(keep, remove, reasons, keepIDs, forgetIDs, "listed in "+opts.KeepIDsFrom)
+				}
+				if !opts.ForceUnprotect {
+					keep, remove, reasons = restic.KeepProtected(keep, remove, reasons)
+				}
 
 				if len(keep) != 0 && !gopts.Quiet && !gopts.JSON {
 					Printf("keep %d snapshots:\n", len(keep))
@@ -240,8 +598,10 @@ func runForget(ctx context.Context, opts ForgetOptions, gopts GlobalOptions, arg
 		if !opts.DryRun {
 			err := DeleteFilesChecked(ctx, gopts, repo, removeSnIDs, restic.SnapshotFile)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
+			recordAudit(ctx, repo, "forget", "", removeSnIDs.List())
+			updateSnapshotCatalog(ctx, repo, nil, removeSnIDs.List())
 		} else {
 			if !gopts.JSON {
 				Printf("Would have removed the following snapshots:\n%v\n\n", removeSnIDs)
@@ -249,26 +609,7 @@ func runForget(ctx context.Context, opts ForgetOptions, gopts GlobalOptions, arg
 		}
 	}
 
-	if gopts.JSON && len(jsonGroups) > 0 {
-		err = printJSONForget(gopts.stdout, jsonGroups)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(removeSnIDs) > 0 && opts.Prune {
-		if !gopts.JSON {
-			if opts.DryRun {
-				Verbosef("%d snapshots would be removed, running prune dry run\n", len(removeSnIDs))
-			} else {
-				Verbosef("%d snapshots have been removed, running prune\n", len(removeSnIDs))
-			}
-		}
-		pruneOptions.DryRun = opts.DryRun
-		return runPruneWithRepo(ctx, pruneOptions, gopts, repo, removeSnIDs)
-	}
-
-	return nil
+	return jsonGroups, removeSnIDs, nil
 }
 
 // ForgetGroup helps to print what is forgotten in JSON.
@@ -279,6 +620,8 @@ type ForgetGroup struct {
 	Keep    []Snapshot          `json:"keep"`
 	Remove  []Snapshot          `json:"remove"`
 	Reasons []restic.KeepReason `json:"reasons"`
+
+	Simulation *ForgetSimulation `json:"simulation,omitempty"`
 }
 
 func addJSONSnapshots(js *[]Snapshot, list restic.Snapshots) {
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/table"
+)
+
+// ForgetSimulation is the result of simulating regular forget runs for a
+// group of snapshots, printed as JSON.
+type ForgetSimulation struct {
+	Until     time.Time                  `json:"until"`
+	Interval  string                     `json:"interval"`
+	Snapshots []ForgetSimulationSnapshot `json:"snapshots"`
+	Kept      int                        `json:"kept"`
+	Simulated int                        `json:"simulated"`
+}
+
+// ForgetSimulationSnapshot is an existing snapshot and the time at which it
+// is removed during the simulation, if at all.
+type ForgetSimulationSnapshot struct {
+	Snapshot
+	RemovedAt *time.Time `json:"removed_at,omitempty"`
+}
+
+// simulateForget simulates regular forget runs with policy for the snapshots
+// of a group until the time until and prints when each snapshot would be
+// removed.
+func simulateForget(gopts GlobalOptions, list restic.Snapshots, policy restic.ExpirePolicy, opts ForgetOptions, until time.Time) *ForgetSimulation {
+	res := restic.SimulatePolicy(list, policy, opts.SimulateInterval, time.Now(), until)
+
+	sim := &ForgetSimulation{
+		Until:     until,
+		Interval:  opts.SimulateInterval.String(),
+		Kept:      len(res.Keep),
+		Simulated: res.Simulated,
+	}
+	// list the snapshots from oldest to newest, as PrintSnapshots does
+	sorted := make(restic.Snapshots, len(list))
+	copy(sorted, list)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+	for _, sn := range sorted {
+		s := ForgetSimulationSnapshot{
+			Snapshot: Snapshot{
+				Snapshot: sn,
+				ID:       sn.ID(),
+				ShortID:  sn.ID().Str(),
+			},
+		}
+		if t, ok := res.Removed[*sn.ID()]; ok {
+			s.RemovedAt = &t
+		}
+		sim.Snapshots = append(sim.Snapshots, s)
+	}
+
+	if gopts.JSON || gopts.Quiet {
+		return sim
+	}
+
+	tab := table.New()
+	tab.AddColumn("ID", "{{ .ID }}")
+	tab.AddColumn("Time", "{{ .Timestamp }}")
+	tab.AddColumn("Removed at", "{{ .Removed }}")
+
+	type snapshot struct {
+		ID        string
+		Timestamp string
+		Removed   string
+	}
+
+	var removed int
+	for _, s := range sim.Snapshots {
+		data := snapshot{
+			ID:        s.ShortID,
+			Timestamp: s.Time.Local().Format(TimeFormat),
+			Removed:   "kept",
+		}
+		if s.RemovedAt != nil {
+			data.Removed = s.RemovedAt.Local().Format(TimeFormat)
+			removed++
+		}
+		tab.AddRow(data)
+	}
+	tab.AddFooter(fmt.Sprintf("%d of %d snapshots removed until %v", removed, len(list), until.Local().Format(TimeFormat)))
+
+	Printf("simulating a backup and forget run every %v until %v:\n", opts.SimulateInterval, until.Local().Format(TimeFormat))
+	if err := tab.Write(gopts.stdout); err != nil {
+		Warnf("error printing: %v\n", err)
+	}
+	Printf("%d snapshots are kept at the end, %d of them simulated\n\n", sim.Kept, sim.Simulated)
+	return sim
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -51,6 +52,14 @@ func TestForgetOptionValues(t *testing.T) {
 		{ForgetOptions{WithinWeekly: restic.ParseDurationOrPanic("1y2m3d-3h")}, true, negDurationValErrorMsg},
 		{ForgetOptions{WithinMonthly: restic.ParseDurationOrPanic("-2y4m6d8h")}, true, negDurationValErrorMsg},
 		{ForgetOptions{WithinYearly: restic.ParseDurationOrPanic("2y-4m6d8h")}, true, negDurationValErrorMsg},
+		{ForgetOptions{Quarterly: 1}, false, ""},
+		{ForgetOptions{Quarterly: -2}, true, negValErrorMsg},
+		{ForgetOptions{WeekStart: "Sunday", MonthStartDay: 26, YearStartMonth: 4}, false, ""},
+		{ForgetOptions{WeekStart: "sun"}, true, "Fatal: invalid day of the week \"sun\" for --week-start"},
+		{ForgetOptions{MonthStartDay: 29}, true, "Fatal: --month-start-day must be between 1 and 28"},
+		{ForgetOptions{YearStartMonth: 13}, true, "Fatal: --year-start-month must be between 1 and 12"},
+		{ForgetOptions{KeepIDsFrom: "-", ForgetIDsFrom: "ids"}, false, ""},
+		{ForgetOptions{KeepIDsFrom: "-", ForgetIDsFrom: "-"}, true, "Fatal: --keep-ids-from and --forget-ids-from cannot both read from stdin"},
 	}
 
 	for _, testCase := range testCases {
@@ -63,3 +72,58 @@ func TestForgetOptionValues(t *testing.T) {
 		}
 	}
 }
+
+func TestParseTagPolicy(t *testing.T) {
+	tp, err := parseTagPolicy("tag=db,prod:keep-hourly=48,keep-daily=7,week-start=sunday")
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.TagList{"db", "prod"}, tp.tags)
+	rtest.Equals(t, 48, tp.policy.Hourly)
+	rtest.Equals(t, 7, tp.policy.Daily)
+	rtest.Assert(t, tp.policy.Calendar.WeekStart != nil && *tp.policy.Calendar.WeekStart == time.Sunday,
+		"unexpected week start %v", tp.policy.Calendar.WeekStart)
+
+	tp, err = parseTagPolicy("tag=home:keep-within=1y2m")
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.ParseDurationOrPanic("1y2m"), tp.policy.Within)
+
+	for _, s := range []string{
+		"",
+		"tag=db",
+		"host=web1:keep-last=1",
+		"tag=:keep-last=1",
+		"tag=db:keep-last",
+		"tag=db:keep-last=-2",
+		"tag=db:keep-weird=1",
+		"tag=db:week-start=monday",
+	} {
+		_, err := parseTagPolicy(s)
+		rtest.Assert(t, err != nil, "expected error for policy %q", s)
+	}
+}
+
+func TestApplyForgetPolicies(t *testing.T) {
+	var list restic.Snapshots
+	for i := 1; i <= 4; i++ {
+		for _, tag := range []string{"db", "home", "other"} {
+			list = append(list, &restic.Snapshot{
+				Time: time.Date(2020, 1, i, 10, 0, 0, 0, time.UTC),
+				Tags: []string{tag},
+			})
+		}
+	}
+
+	tagPolicies := []tagPolicy{
+		{restic.TagList{"db"}, restic.ExpirePolicy{Last: 3}},
+		{restic.TagList{"home"}, restic.ExpirePolicy{Last: 1}},
+	}
+	keep, remove, reasons := applyForgetPolicies(list, restic.ExpirePolicy{Last: 2}, tagPolicies)
+	rtest.Equals(t, len(keep), len(reasons))
+
+	kept := make(map[string]int)
+	for i, sn := range keep {
+		rtest.Assert(t, reasons[i].Snapshot == sn, "reason %d does not belong to snapshot %v", i, sn)
+		kept[sn.Tags[0]]++
+	}
+	rtest.Equals(t, map[string]int{"db": 3, "home": 1, "other": 2}, kept)
+	rtest.Equals(t, 6, len(remove))
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/jsonschema"
+	"github.com/restic/restic/internal/ui/backup"
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
 )
@@ -15,6 +20,9 @@ var cmdGenerate = &cobra.Command{
 The "generate" command writes automatically generated files (like the man pages
 and the auto-completion files for bash, fish and zsh).
 
+The --json-schema option writes a JSON Schema document for each message printed
+by commands run with --json, which can be used to generate client libraries.
+
 EXIT STATUS
 ===========
 
@@ -30,6 +38,7 @@ type generateOptions struct {
 	FishCompletionFile       string
 	ZSHCompletionFile        string
 	PowerShellCompletionFile string
+	JSONSchemaDir            string
 }
 
 var genOpts generateOptions
@@ -42,6 +51,7 @@ func init() {
 	fs.StringVar(&genOpts.FishCompletionFile, "fish-completion", "", "write fish completion `file`")
 	fs.StringVar(&genOpts.ZSHCompletionFile, "zsh-completion", "", "write zsh completion `file`")
 	fs.StringVar(&genOpts.PowerShellCompletionFile, "powershell-completion", "", "write powershell completion `file`")
+	fs.StringVar(&genOpts.JSONSchemaDir, "json-schema", "", "write JSON schemas for the --json output to `directory`")
 }
 
 func writeManpages(dir string) error {
@@ -90,6 +100,66 @@ func writePowerShellCompletion(file string) error {
 	return cmdRoot.GenPowerShellCompletionFile(file)
 }
 
+// jsonSchemaTypes returns a value of each type printed by commands with --json,
+// keyed by the name of the schema.
+func jsonSchemaTypes() map[string]interface{} {
+	types := map[string]interface{}{
+		"analyze":           AnalyzeResult{},
+		"audit":             []AuditEntry{},
+		"backend_stats":     backendStatsSummary{},
+		"config_get":        map[string][]string{},
+		"diff_change":       Change{},
+		"diff_statistics":   DiffStatsContainer{},
+		"doctor":            []doctorFinding{},
+		"failover":          failoverEvent{},
+		"find_match":        findMatch{},
+		"find_object":       findObject{},
+		"find_snapshot":     findSnapshot{},
+		"forget":            []ForgetGroup{},
+		"init":              initSuccess{},
+		"key_list":          []keyInfo{},
+		"maintain":          MaintainReport{},
+		"migrate_plan":      []migrationStep{},
+		"ls_du":             lsDiskUsageEntry{},
+		"ls_node":           lsNode{},
+		"ls_snapshot":       lsSnapshot{},
+		"snapshots":         []Snapshot{},
+		"snapshots_grouped": []SnapshotGroup{},
+		"stats":             statsContainer{},
+		"stats_timeline":    []statsTimelineEntry{},
+		"verify_difference": VerifyDifference{},
+	}
+
+	for name, v := range backup.JSONMessageTypes() {
+		types["backup_"+name] = v
+	}
+
+	return types
+}
+
+func writeJSONSchemas(dir string) error {
+	Verbosef("writing JSON schemas to directory %v\n", dir)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for name, v := range jsonSchemaTypes() {
+		buf, err := json.MarshalIndent(jsonschema.Generate(name, v), "", "  ")
+		if err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+
+		err = os.WriteFile(filepath.Join(dir, name+".json"), buf, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func runGenerate(cmd *cobra.Command, args []string) error {
 	if genOpts.ManDir != "" {
 		err := writeManpages(genOpts.ManDir)
@@ -126,6 +196,13 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if genOpts.JSONSchemaDir != "" {
+		err := writeJSONSchemas(genOpts.JSONSchemaDir)
+		if err != nil {
+			return err
+		}
+	}
+
 	var empty generateOptions
 	if genOpts == empty {
 		return errors.Fatal("nothing to do, please specify at least one output file/dir")
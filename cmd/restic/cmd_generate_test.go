@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/jsonschema"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestWriteJSONSchemas(t *testing.T) {
+	dir := rtest.TempDir(t)
+	rtest.OK(t, writeJSONSchemas(dir))
+
+	for name := range jsonSchemaTypes() {
+		buf, err := os.ReadFile(filepath.Join(dir, name+".json"))
+		rtest.OK(t, err)
+
+		var schema struct {
+			Title string `json:"title"`
+			Type  interface{}
+		}
+		rtest.OK(t, json.Unmarshal(buf, &schema))
+		rtest.Equals(t, name, schema.Title)
+		rtest.Assert(t, schema.Type != nil, "schema %v has no type", name)
+	}
+}
+
+// schemaTypes returns the JSON types allowed by s.
+func schemaTypes(s *jsonschema.Schema) []string {
+	switch typ := s.Type.(type) {
+	case string:
+		return []string{typ}
+	case []string:
+		return typ
+	}
+	return nil
+}
+
+// validateJSON checks that v, decoded from JSON, matches the schema s. Only
+// the parts of JSON Schema used by the generated schemas are supported.
+func validateJSON(defs map[string]*jsonschema.Schema, s *jsonschema.Schema, v interface{}, path string) error {
+	if s.Ref != "" {
+		def, ok := defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		if !ok {
+			return fmt.Errorf("%v: unknown reference %v", path, s.Ref)
+		}
+		s = def
+	}
+
+	if len(s.AnyOf) > 0 {
+		var errs []string
+		for _, alt := range s.AnyOf {
+			err := validateJSON(defs, alt, v, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%v: no alternative matches: %v", path, strings.Join(errs, "; "))
+	}
+
+	types := schemaTypes(s)
+	if len(types) == 0 {
+		// any value
+		return nil
+	}
+	allowed := func(typ string) bool {
+		for _, t := range types {
+			if t == typ || (typ == "integer" && t == "number") {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch v := v.(type) {
+	case nil:
+		if !allowed("null") {
+			return fmt.Errorf("%v: null is not allowed by %v", path, types)
+		}
+	case bool:
+		if !allowed("boolean") {
+			return fmt.Errorf("%v: boolean is not allowed by %v", path, types)
+		}
+	case string:
+		if !allowed("string") {
+			return fmt.Errorf("%v: string is not allowed by %v", path, types)
+		}
+	case float64:
+		typ := "number"
+		if v == float64(int64(v)) {
+			typ = "integer"
+		}
+		if !allowed(typ) && !allowed("number") {
+			return fmt.Errorf("%v: %v is not allowed by %v", path, typ, types)
+		}
+	case []interface{}:
+		if !allowed("array") {
+			return fmt.Errorf("%v: array is not allowed by %v", path, types)
+		}
+		for i, item := range v {
+			if err := validateJSON(defs, s.Items, item, fmt.Sprintf("%v[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if !allowed("object") {
+			return fmt.Errorf("%v: object is not allowed by %v", path, types)
+		}
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				return fmt.Errorf("%v: required property %q is missing", path, key)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			prop, ok := s.Properties[key]
+			if !ok {
+				prop = s.Additional
+			}
+			if prop == nil {
+				return fmt.Errorf("%v: property %q is not described by the schema", path, key)
+			}
+			if err := validateJSON(defs, prop, v[key], path+"."+key); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%v: unexpected value %v", path, v)
+	}
+	return nil
+}
+
+// testCheckJSONSchema checks that each JSON value in out matches the schema
+// name returned by jsonSchemaTypes.
+func testCheckJSONSchema(t testing.TB, name string, out []byte) {
+	t.Helper()
+	v, ok := jsonSchemaTypes()[name]
+	rtest.Assert(t, ok, "no schema %v", name)
+	schema := jsonschema.Generate(name, v)
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	messages := 0
+	for dec.More() {
+		var value interface{}
+		rtest.OK(t, dec.Decode(&value))
+		if err := validateJSON(schema.Defs, schema, value, name); err != nil {
+			t.Errorf("output does not match schema: %v\n%s", err, out)
+		}
+		messages++
+	}
+	rtest.Assert(t, messages > 0, "no output for schema %v", name)
+}
+
+func TestJSONSchemaOutput(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	// several commands list the snapshots and index files
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{Host: "laptop"}, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{Host: "server"}, env.gopts)
+
+	run := func(name string, fn func(gopts GlobalOptions) error) {
+		buf := bytes.NewBuffer(nil)
+		gopts := env.gopts
+		gopts.stdout = buf
+		gopts.JSON = true
+		rtest.OK(t, fn(gopts))
+		testCheckJSONSchema(t, name, buf.Bytes())
+	}
+
+	run("analyze", func(gopts GlobalOptions) error {
+		return runAnalyze(context.TODO(), AnalyzeOptions{Top: 5}, gopts, nil)
+	})
+	run("doctor", func(gopts GlobalOptions) error {
+		return runDoctor(context.TODO(), gopts, nil)
+	})
+	rtest.OK(t, runConfigSet(context.TODO(), env.gopts, []string{"keep-last", "1"}))
+	run("config_get", func(gopts GlobalOptions) error {
+		return runConfigGet(context.TODO(), gopts, nil)
+	})
+	run("maintain", func(gopts GlobalOptions) error {
+		return runMaintain(context.TODO(), MaintainOptions{DryRun: true, ReadDataSubset: "100%"}, gopts, nil)
+	})
+
+	testCheckJSONSchema(t, "stats", testRunStatsOutput(t, env.gopts, StatsOptions{countMode: countModeRestoreSize, byHost: true}, true))
+	testCheckJSONSchema(t, "stats", testRunStatsOutput(t, env.gopts, StatsOptions{countMode: countModeRawData, byHost: true}, true))
+	testCheckJSONSchema(t, "stats_timeline", testRunStatsOutput(t, env.gopts, StatsOptions{countMode: countModeRestoreSize, timeline: true}, true))
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, []string{"laptop"})
+	rtest.OK(t, os.WriteFile(filepath.Join(restoredir, "testdata", "changed"), []byte("new file"), 0644))
+	out, err := testRunVerify(env.gopts, restoredir)
+	rtest.Assert(t, err != nil, "verify found no difference")
+	testCheckJSONSchema(t, "verify_difference", []byte(out))
+}
+
+func TestValidateJSON(t *testing.T) {
+	type item struct {
+		Name  string  `json:"name"`
+		Size  uint64  `json:"size,omitempty"`
+		Ratio float64 `json:"ratio"`
+	}
+	schema := jsonschema.Generate("test", []item{})
+
+	for _, test := range []struct {
+		out string
+		ok  bool
+	}{
+		{`[{"name": "a", "ratio": 1.5}]`, true},
+		{`[{"name": "a", "size": 3, "ratio": 2}]`, true},
+		{`null`, true},
+		{`[{"ratio": 1.5}]`, false},
+		{`[{"name": "a", "ratio": 1.5, "other": true}]`, false},
+		{`[{"name": 1, "ratio": 1.5}]`, false},
+		{`{"name": "a", "ratio": 1.5}`, false},
+	} {
+		var v interface{}
+		rtest.OK(t, json.Unmarshal([]byte(test.out), &v))
+		err := validateJSON(schema.Defs, schema, v, "test")
+		rtest.Assert(t, (err == nil) == test.ok, "unexpected result for %v: %v", test.out, err)
+	}
+}
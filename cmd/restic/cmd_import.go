@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/export"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdImport = &cobra.Command{
+	Use:   "import [flags] [file]",
+	Short: "Load snapshots from a stream created by export",
+	Long: `
+The "import" command loads all snapshots and their data from a stream created
+by the "export" command into the repository. The stream is read from file, or
+from stdin if no file or "-" is specified. Snapshots which were already
+imported or copied before are skipped.
+
+The password for the stream is read from the file given by
+--stream-password-file, the environment variable RESTIC_STREAM_PASSWORD or is
+requested interactively.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runImport(cmd.Context(), importOptions, globalOptions, args)
+	},
+}
+
+// ImportOptions collects all options for the import command.
+type ImportOptions struct {
+	StreamPasswordFile string
+}
+
+var importOptions ImportOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdImport)
+
+	flags := cmdImport.Flags()
+	flags.StringVar(&importOptions.StreamPasswordFile, "stream-password-file", "", "read the password for the stream from `file`")
+}
+
+func runImport(ctx context.Context, opts ImportOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 1 {
+		return errors.Fatal("more than one stream specified")
+	}
+
+	var in io.Reader = os.Stdin
+	if len(args) == 1 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return errors.Fatalf("unable to open stream: %v", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		in = f
+	}
+
+	password, err := readStreamPassword(opts.StreamPasswordFile, false)
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, ctx, err := lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	r, err := export.NewReader(bufio.NewReaderSize(in, 1<<20), password)
+	if err != nil {
+		return errors.Fatalf("unable to read stream: %v", err)
+	}
+	if r.BlobHash() != repo.Config().BlobHash {
+		return errors.Fatal("the stream and the repository use different blob hash algorithms")
+	}
+
+	snapshotsByOriginal := make(map[restic.ID][]*restic.Snapshot)
+	err = restic.ForAllSnapshots(ctx, repo.Backend(), repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if sn.Original != nil && !sn.Original.IsNull() {
+			snapshotsByOriginal[*sn.Original] = append(snapshotsByOriginal[*sn.Original], sn)
+		}
+		snapshotsByOriginal[id] = append(snapshotsByOriginal[id], sn)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+
+	var snapshots []*restic.Snapshot
+	var blobs int
+	var size uint64
+	wg.Go(func() error {
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Fatalf("unable to read stream: %v", err)
+			}
+
+			if rec.Type == export.SnapshotRecord {
+				snapshots = append(snapshots, rec.Snapshot)
+				continue
+			}
+			if repo.Index().Has(restic.BlobHandle{ID: rec.ID, Type: rec.BlobType()}) {
+				continue
+			}
+			_, known, _, err := repo.SaveBlob(wgCtx, rec.BlobType(), rec.Data, rec.ID, false)
+			if err != nil {
+				return err
+			}
+			if !known {
+				blobs++
+				size += uint64(len(rec.Data))
+			}
+		}
+		return repo.Flush(wgCtx)
+	})
+	if err := wg.Wait(); err != nil {
+		return err
+	}
+
+	// the snapshots are only saved once the complete stream was read
+	var imported, skipped int
+	for _, sn := range snapshots {
+		if isImportedSnapshot(snapshotsByOriginal, sn) {
+			Verboseff("skipping snapshot of %v at %s, it already exists\n", sn.Paths, sn.Time)
+			skipped++
+			continue
+		}
+
+		sn.Parent = nil
+		id, err := restic.SaveSnapshot(ctx, repo, sn)
+		if err != nil {
+			return err
+		}
+		Verbosef("imported snapshot %s of %v at %s\n", id.Str(), sn.Paths, sn.Time)
+		if sn.Original != nil {
+			snapshotsByOriginal[*sn.Original] = append(snapshotsByOriginal[*sn.Original], sn)
+		}
+		imported++
+	}
+
+	Verbosef("imported %d snapshots, skipped %d, added %d blobs (%s)\n", imported, skipped, blobs, ui.FormatBytes(size))
+	return nil
+}
+
+// isImportedSnapshot returns whether a snapshot similar to sn exists already.
+func isImportedSnapshot(snapshotsByOriginal map[restic.ID][]*restic.Snapshot, sn *restic.Snapshot) bool {
+	if sn.Original == nil {
+		return false
+	}
+	for _, existing := range snapshotsByOriginal[*sn.Original] {
+		if similarSnapshots(existing, sn) {
+			return true
+		}
+	}
+	return false
+}
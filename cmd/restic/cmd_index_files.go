@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdIndexFiles = &cobra.Command{
+	Use:   "index-files [flags]",
+	Short: "Create or remove the search index used by find",
+	Long: `
+The "index-files" command creates a search index which contains the names of
+the files and directories in all snapshots. The "find" command then only loads
+the trees containing matching entries instead of walking every tree of every
+snapshot, which is much faster for repositories with many snapshots on
+backends with a high latency.
+
+With --content, the index also contains the trigrams of the contents of all
+files up to the size given by --content-max-size. This allows "find --content"
+to skip the files which cannot contain the searched text.
+
+The index is not updated by other commands. Snapshots created afterwards are
+searched without it until this command is run again, which only loads the
+trees which are not already contained in the index.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runIndexFiles(cmd.Context(), indexFilesOptions, globalOptions, args)
+	},
+}
+
+// IndexFilesOptions bundles all options for the index-files command.
+type IndexFilesOptions struct {
+	Content        bool
+	ContentMaxSize string
+	Remove         bool
+}
+
+var indexFilesOptions IndexFilesOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdIndexFiles)
+
+	f := cmdIndexFiles.Flags()
+	f.BoolVar(&indexFilesOptions.Content, "content", false, "also index the contents of the files")
+	f.StringVar(&indexFilesOptions.ContentMaxSize, "content-max-size", "1M", "only index the contents of files up to `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.BoolVar(&indexFilesOptions.Remove, "remove", false, "remove the search index from the repository")
+}
+
+func runIndexFiles(ctx context.Context, opts IndexFilesOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the index-files command expects no arguments")
+	}
+
+	maxSize, err := parseSizeStr(opts.ContentMaxSize)
+	if err != nil {
+		return errors.Fatalf("invalid --content-max-size: %v", err)
+	}
+	if maxSize < 0 {
+		return errors.Fatal("--content-max-size must not be negative")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, ctx, err := lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	previous, err := loadSearchIndex(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	if opts.Remove {
+		Verbosef("removing search index\n")
+		return removeSearchIndex(ctx, repo, previous)
+	}
+
+	snapshotLister, err := backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	b := newSearchIndexBuilder(repo, previous, opts.Content, uint64(maxSize))
+	count := 0
+	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &restic.SnapshotFilter{}, nil) {
+		Verboseff("indexing snapshot %v\n", sn.ID().Str())
+		if err := b.AddSnapshot(ctx, sn); err != nil {
+			return err
+		}
+		count++
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if _, err := b.Save(ctx, previous); err != nil {
+		return err
+	}
+	Verbosef("created search index for %d snapshots with %d trees\n", count, len(b.file.Trees))
+	return nil
+}
@@ -20,6 +20,13 @@ var cmdInit = &cobra.Command{
 	Long: `
 The "init" command initializes a new repository.
 
+The "--blob-hash" option selects the hash algorithm used to compute the IDs of
+blobs. BLAKE3 is considerably faster than SHA-256 on processors without
+dedicated SHA instructions. It cannot be changed later, and repositories using
+BLAKE3 can only be accessed by restic versions which support it. Snapshots can
+only be copied between repositories using different algorithms with
+"restic copy --rechunk".
+
 EXIT STATUS
 ===========
 
@@ -36,6 +43,7 @@ type InitOptions struct {
 	secondaryRepoOptions
 	CopyChunkerParameters bool
 	RepositoryVersion     string
+	BlobHash              string
 }
 
 var initOptions InitOptions
@@ -47,6 +55,7 @@ func init() {
 	initSecondaryRepoOptions(f, &initOptions.secondaryRepoOptions, "secondary", "to copy chunker parameters from")
 	f.BoolVar(&initOptions.CopyChunkerParameters, "copy-chunker-params", false, "copy chunker parameters from the secondary repository (useful with the copy command)")
 	f.StringVar(&initOptions.RepositoryVersion, "repository-version", "stable", "repository format version to use, allowed values are a format version, 'latest' and 'stable'")
+	f.StringVar(&initOptions.BlobHash, "blob-hash", restic.BlobHashSHA256, "hash `algorithm` for the IDs of blobs, allowed values are 'sha256' and 'blake3'")
 }
 
 func runInit(ctx context.Context, opts InitOptions, gopts GlobalOptions, args []string) error {
@@ -65,6 +74,16 @@ func runInit(ctx context.Context, opts InitOptions, gopts GlobalOptions, args []
 	if version < restic.MinRepoVersion || version > restic.MaxRepoVersion {
 		return errors.Fatalf("only repository versions between %v and %v are allowed", restic.MinRepoVersion, restic.MaxRepoVersion)
 	}
+	if _, err := restic.LookupBlobHash(opts.BlobHash); err != nil {
+		return errors.Fatalf("invalid --blob-hash: %v", err)
+	}
+	if opts.BlobHash != "" && opts.BlobHash != restic.BlobHashSHA256 && version < restic.CapabilitiesRepoVersion {
+		// older versions of restic would compute the blob IDs using SHA-256
+		if opts.RepositoryVersion != "stable" {
+			return errors.Fatalf("--blob-hash %v requires repository version %v", opts.BlobHash, restic.CapabilitiesRepoVersion)
+		}
+		version = restic.CapabilitiesRepoVersion
+	}
 
 	chunkerPolynomial, err := maybeReadChunkerPolynomial(ctx, opts, gopts)
 	if err != nil {
@@ -91,6 +110,7 @@ func runInit(ctx context.Context, opts InitOptions, gopts GlobalOptions, args []
 	s, err := repository.New(be, repository.Options{
 		Compression: gopts.Compression,
 		PackSize:    gopts.PackSize * 1024 * 1024,
+		BlobHash:    opts.BlobHash,
 	})
 	if err != nil {
 		return err
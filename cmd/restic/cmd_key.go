@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
@@ -16,11 +17,22 @@ import (
 )
 
 var cmdKey = &cobra.Command{
-	Use:   "key [flags] [list|add|remove|passwd] [ID]",
+	Use:   "key [flags] [list|add|remove|passwd|verify|new-identity] [ID|FILE]",
 	Short: "Manage keys (passwords)",
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
 
+"key verify ID" checks that the current password opens the key with the given
+ID. It fails if the password does not match or opens a different key.
+
+"key new-identity FILE" generates a new identity for a hybrid post-quantum key
+encapsulation (X25519 and ML-KEM-768). The identity is written to FILE, the
+public recipient to FILE.pub. The repository is not accessed. "key add
+--recipient-file FILE.pub" then adds a key which cannot be opened by a
+password, but only by passing the identity to --identity-file. Unlike password
+keys, these keys are not exposed to attackers who record the key files now and
+decrypt them once large quantum computers exist.
+
 EXIT STATUS
 ===========
 
@@ -33,9 +45,10 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 }
 
 var (
-	newPasswordFile string
-	keyUsername     string
-	keyHostname     string
+	newPasswordFile  string
+	keyRecipientFile string
+	keyUsername      string
+	keyHostname      string
 )
 
 func init() {
@@ -43,23 +56,63 @@ func init() {
 
 	flags := cmdKey.Flags()
 	flags.StringVarP(&newPasswordFile, "new-password-file", "", "", "`file` from which to read the new password")
+	flags.StringVarP(&keyRecipientFile, "recipient-file", "", "", "`file` from which to read the recipient of the new key, instead of a password")
 	flags.StringVarP(&keyUsername, "user", "", "", "the username for new keys")
 	flags.StringVarP(&keyHostname, "host", "", "", "the hostname for new keys")
 }
 
-func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions) error {
-	type keyInfo struct {
-		Current  bool   `json:"current"`
-		ID       string `json:"id"`
-		UserName string `json:"userName"`
-		HostName string `json:"hostName"`
-		Created  string `json:"created"`
+type keyInfo struct {
+	Current  bool   `json:"current"`
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	HostName string `json:"hostName"`
+	Created  string `json:"created"`
+
+	// Fingerprint is the SHA-256 hash of the key file, it changes whenever
+	// the key file is modified.
+	Fingerprint string     `json:"fingerprint"`
+	KDF         keyKDFInfo `json:"kdf"`
+	// LastModified is the time of the latest audit log entry recorded by an
+	// operation which used the key to modify the repository.
+	LastModified *time.Time `json:"last_modified,omitempty"`
+}
+
+type keyKDFInfo struct {
+	Name string `json:"name"`
+	N    int    `json:"N"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// keyLastModified returns the time of the latest audit log entry for each key.
+func keyLastModified(ctx context.Context, repo restic.Repository) (map[restic.ID]time.Time, error) {
+	entries, err := restic.LoadAllAuditEntries(ctx, repo.Backend(), repo)
+	if err != nil {
+		return nil, err
+	}
+
+	last := make(map[restic.ID]time.Time)
+	for _, e := range entries {
+		if e.Key == nil {
+			continue
+		}
+		if t, ok := last[*e.Key]; !ok || e.Time.After(t) {
+			last[*e.Key] = e.Time
+		}
 	}
+	return last, nil
+}
 
+func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions) error {
 	var m sync.Mutex
 	var keys []keyInfo
 
-	err := restic.ParallelList(ctx, s.Backend(), restic.KeyFile, s.Connections(), func(ctx context.Context, id restic.ID, size int64) error {
+	lastModified, err := keyLastModified(ctx, s)
+	if err != nil {
+		Warnf("unable to load the audit log: %v\n", err)
+	}
+
+	err = restic.ParallelList(ctx, s.Backend(), restic.KeyFile, s.Connections(), func(ctx context.Context, id restic.ID, size int64) error {
 		k, err := repository.LoadKey(ctx, s, id)
 		if err != nil {
 			Warnf("LoadKey() failed: %v\n", err)
@@ -72,6 +125,16 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 			UserName: k.Username,
 			HostName: k.Hostname,
 			Created:  k.Created.Local().Format(TimeFormat),
+
+			Fingerprint: id.String(),
+			KDF:         keyKDFInfo{Name: k.KDF, N: k.N, R: k.R, P: k.P},
+		}
+		if k.KEM != nil {
+			// the scrypt parameters are only used to make old versions skip the key
+			key.KDF = keyKDFInfo{Name: k.KEM.Type}
+		}
+		if t, ok := lastModified[id]; ok {
+			key.LastModified = &t
 		}
 
 		m.Lock()
@@ -124,6 +187,10 @@ func getNewPassword(gopts GlobalOptions) (string, error) {
 }
 
 func addKey(ctx context.Context, repo *repository.Repository, gopts GlobalOptions) error {
+	if keyRecipientFile != "" {
+		return addRecipientKey(ctx, repo)
+	}
+
 	pw, err := getNewPassword(gopts)
 	if err != nil {
 		return err
@@ -140,6 +207,25 @@ func addKey(ctx context.Context, repo *repository.Repository, gopts GlobalOption
 	}
 
 	Verbosef("saved new key as %s\n", id)
+	recordAudit(ctx, repo, "key add", "added key "+id.ID().String(), nil)
+
+	return nil
+}
+
+// addRecipientKey adds a key for the recipient read from keyRecipientFile.
+func addRecipientKey(ctx context.Context, repo *repository.Repository) error {
+	recipient, err := loadRecipient(keyRecipientFile)
+	if err != nil {
+		return err
+	}
+
+	id, err := repository.AddKeyRecipient(ctx, repo, recipient, keyUsername, keyHostname, repo.Key())
+	if err != nil {
+		return errors.Fatalf("creating new key failed: %v\n", err)
+	}
+
+	Verbosef("saved new key for recipient %v as %s\n", recipient.Fingerprint(), id)
+	recordAudit(ctx, repo, "key add", "added key "+id.ID().String(), nil)
 
 	return nil
 }
@@ -156,6 +242,7 @@ func deleteKey(ctx context.Context, repo *repository.Repository, id restic.ID) e
 	}
 
 	Verbosef("removed key %v\n", id)
+	recordAudit(ctx, repo, "key remove", "removed key "+id.String(), nil)
 	return nil
 }
 
@@ -183,6 +270,7 @@ func changePassword(ctx context.Context, repo *repository.Repository, gopts Glob
 	}
 
 	Verbosef("saved new key as %s\n", id)
+	recordAudit(ctx, repo, "key passwd", "replaced key "+oldID.String()+" with "+id.ID().String(), nil)
 
 	return nil
 }
@@ -200,11 +288,38 @@ func switchToNewKeyAndRemoveIfBroken(ctx context.Context, repo *repository.Repos
 	return nil
 }
 
+// verifyKey checks that the repository was opened using the key matching
+// idPrefix.
+func verifyKey(ctx context.Context, repo *repository.Repository, idPrefix string) error {
+	id, err := restic.Find(ctx, repo.Backend(), restic.KeyFile, idPrefix)
+	if err != nil {
+		return err
+	}
+
+	if current := repo.KeyID(); current != id {
+		return errors.Fatalf("password does not match key %v, it opens key %v", id.Str(), current.Str())
+	}
+
+	Printf("password matches key %v\n", id.Str())
+	return nil
+}
+
 func runKey(ctx context.Context, gopts GlobalOptions, args []string) error {
-	if len(args) < 1 || (args[0] == "remove" && len(args) != 2) || (args[0] != "remove" && len(args) != 1) {
+	withID := len(args) > 0 && (args[0] == "remove" || args[0] == "verify" || args[0] == "new-identity")
+	if len(args) < 1 || (withID && len(args) != 2) || (!withID && len(args) != 1) {
 		return errors.Fatal("wrong number of arguments")
 	}
 
+	if args[0] == "new-identity" {
+		// does not need the repository
+		return newIdentity(args[1])
+	}
+
+	if args[0] == "verify" {
+		// try the key to verify first, the password may match other keys as well
+		gopts.KeyHint = args[1]
+	}
+
 	repo, err := OpenRepository(ctx, gopts)
 	if err != nil {
 		return err
@@ -248,6 +363,8 @@ func runKey(ctx context.Context, gopts GlobalOptions, args []string) error {
 		}
 
 		return changePassword(ctx, repo, gopts)
+	case "verify":
+		return verifyKey(ctx, repo, args[1])
 	}
 
 	return nil
@@ -11,7 +11,7 @@ import (
 )
 
 var cmdList = &cobra.Command{
-	Use:   "list [flags] [blobs|packs|index|snapshots|keys|locks]",
+	Use:   "list [flags] [blobs|packs|index|snapshots|keys|locks|audit|audithead|catalog|parity|scrub|datakeys|searchindex]",
 	Short: "List objects in the repository",
 	Long: `
 The "list" command allows listing objects in the repository based on type.
@@ -62,6 +62,20 @@ func runList(ctx context.Context, cmd *cobra.Command, gopts GlobalOptions, args
 		t = restic.KeyFile
 	case "locks":
 		t = restic.LockFile
+	case "audit":
+		t = restic.AuditFile
+	case "audithead":
+		t = restic.AuditHeadFile
+	case "catalog":
+		t = restic.CatalogFile
+	case "parity":
+		t = restic.ParityFile
+	case "scrub":
+		t = restic.ScrubFile
+	case "datakeys":
+		t = restic.DataKeyFile
+	case "searchindex":
+		t = restic.SearchIndexFile
 	case "blobs":
 		return index.ForAllIndexes(ctx, repo, func(id restic.ID, idx *index.Index, oldFormat bool, err error) error {
 			if err != nil {
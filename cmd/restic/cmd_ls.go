@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"path"
 	"strings"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
 	"github.com/restic/restic/internal/walker"
 )
 
@@ -25,7 +27,9 @@ The "ls" command lists files and directories in a snapshot.
 The special snapshot ID "latest" can be used to list files and
 directories of the latest snapshot in the repository. The
 --host flag can be used in conjunction to select the latest
-snapshot originating from a certain host only.
+snapshot originating from a certain host only. "latest@time" and
+"host:name@time" select the newest snapshot created at or before
+the given time, for example "latest@2023-06-01".
 
 File listings can optionally be filtered by directories. Any
 positional arguments after the snapshot ID are interpreted as
@@ -35,6 +39,11 @@ will allow traversing into matching directories' subfolders.
 Any directory paths specified must be absolute (starting with
 a path separator); paths use the forward slash '/' as separator.
 
+The --du flag prints the cumulative statistics of each listed entry instead,
+similar to "du": the logical size of all files, the size of all unique data
+blobs (i.e. after deduplication within the entry) and the number of files.
+Directories are printed after their contents.
+
 EXIT STATUS
 ===========
 
@@ -51,6 +60,7 @@ type LsOptions struct {
 	ListLong bool
 	restic.SnapshotFilter
 	Recursive bool
+	DiskUsage bool
 }
 
 var lsOptions LsOptions
@@ -62,6 +72,7 @@ func init() {
 	initSingleSnapshotFilter(flags, &lsOptions.SnapshotFilter)
 	flags.BoolVarP(&lsOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
 	flags.BoolVar(&lsOptions.Recursive, "recursive", false, "include files in subfolders of the listed directories")
+	flags.BoolVar(&lsOptions.DiskUsage, "du", false, "print cumulative sizes and file counts of the listed entries")
 }
 
 type lsSnapshot struct {
@@ -71,24 +82,27 @@ type lsSnapshot struct {
 	StructType string     `json:"struct_type"` // "snapshot"
 }
 
+// lsNode is the JSON representation of a node printed by ls.
+type lsNode struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Path        string      `json:"path"`
+	UID         uint32      `json:"uid"`
+	GID         uint32      `json:"gid"`
+	Size        *uint64     `json:"size,omitempty"`
+	Mode        os.FileMode `json:"mode,omitempty"`
+	Permissions string      `json:"permissions,omitempty"`
+	ModTime     time.Time   `json:"mtime,omitempty"`
+	AccessTime  time.Time   `json:"atime,omitempty"`
+	ChangeTime  time.Time   `json:"ctime,omitempty"`
+	StructType  string      `json:"struct_type"` // "node"
+
+	size uint64 // Target for Size pointer.
+}
+
 // Print node in our custom JSON format, followed by a newline.
 func lsNodeJSON(enc *json.Encoder, path string, node *restic.Node) error {
-	n := &struct {
-		Name        string      `json:"name"`
-		Type        string      `json:"type"`
-		Path        string      `json:"path"`
-		UID         uint32      `json:"uid"`
-		GID         uint32      `json:"gid"`
-		Size        *uint64     `json:"size,omitempty"`
-		Mode        os.FileMode `json:"mode,omitempty"`
-		Permissions string      `json:"permissions,omitempty"`
-		ModTime     time.Time   `json:"mtime,omitempty"`
-		AccessTime  time.Time   `json:"atime,omitempty"`
-		ChangeTime  time.Time   `json:"ctime,omitempty"`
-		StructType  string      `json:"struct_type"` // "node"
-
-		size uint64 // Target for Size pointer.
-	}{
+	n := &lsNode{
 		Name:        node.Name,
 		Type:        node.Type,
 		Path:        path,
@@ -115,6 +129,9 @@ func runLs(ctx context.Context, opts LsOptions, gopts GlobalOptions, args []stri
 	if len(args) == 0 {
 		return errors.Fatal("no snapshot ID specified, specify snapshot ID or use special ID 'latest'")
 	}
+	if opts.DiskUsage && opts.ListLong {
+		return errors.Fatal("--du and --long cannot be used together")
+	}
 
 	// extract any specific directories to walk
 	var dirs []string
@@ -221,6 +238,10 @@ func runLs(ctx context.Context, opts LsOptions, gopts GlobalOptions, args []stri
 
 	printSnapshot(sn)
 
+	if opts.DiskUsage {
+		return lsDiskUsage(ctx, repo, gopts, sn, dirs, opts.Recursive, withinDir, approachingMatchingTree)
+	}
+
 	err = walker.Walk(ctx, repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
 		if err != nil {
 			return false, err
@@ -260,3 +281,145 @@ func runLs(ctx context.Context, opts LsOptions, gopts GlobalOptions, args []stri
 
 	return nil
 }
+
+// duStats are the cumulative statistics of a file or directory for ls --du.
+type duStats struct {
+	Size       uint64
+	UniqueSize uint64
+	Files      uint64
+
+	// blobs maps the unique data blobs to their size
+	blobs map[restic.ID]uint
+}
+
+// add merges the statistics of other into s. other must not be used afterwards.
+func (s *duStats) add(other *duStats) {
+	s.Size += other.Size
+	s.Files += other.Files
+
+	// merge the smaller set into the larger one
+	if len(other.blobs) > len(s.blobs) {
+		s.blobs, other.blobs = other.blobs, s.blobs
+		s.UniqueSize = other.UniqueSize
+	}
+	for id, size := range other.blobs {
+		if _, ok := s.blobs[id]; !ok {
+			s.blobs[id] = size
+			s.UniqueSize += uint64(size)
+		}
+	}
+}
+
+// lsDiskUsageEntry is the JSON representation of the statistics printed by
+// ls --du.
+type lsDiskUsageEntry struct {
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Size       uint64 `json:"size"`
+	UniqueSize uint64 `json:"unique_size"`
+	Files      uint64 `json:"files"`
+	StructType string `json:"struct_type"` // "du"
+}
+
+type duWalker struct {
+	repo restic.Repository
+
+	// show reports whether the statistics for nodepath should be printed,
+	// showChildren whether this also applies to the children of the directory.
+	show         func(nodepath string) bool
+	showChildren func(nodepath string) bool
+	report       func(nodepath string, nodeType string, stats *duStats)
+}
+
+// walk returns the statistics of the tree. Nodes are reported after their
+// statistics are complete, so directories come after their contents.
+func (w *duWalker) walk(ctx context.Context, treeID restic.ID, dir string, visible bool) (*duStats, error) {
+	tree, err := restic.LoadTree(ctx, w.repo, treeID)
+	if err != nil {
+		return nil, err
+	}
+
+	total := &duStats{blobs: make(map[restic.ID]uint)}
+	for _, node := range tree.Nodes {
+		nodepath := path.Join(dir, node.Name)
+		stats := &duStats{blobs: make(map[restic.ID]uint)}
+
+		switch node.Type {
+		case "file":
+			stats.Size = node.Size
+			stats.Files = 1
+			for _, id := range node.Content {
+				size, found := w.repo.LookupBlobSize(id, restic.DataBlob)
+				if !found {
+					return nil, errors.Errorf("blob %v not found for file %v", id.Str(), nodepath)
+				}
+				if _, ok := stats.blobs[id]; !ok {
+					stats.blobs[id] = size
+					stats.UniqueSize += uint64(size)
+				}
+			}
+		case "dir":
+			if node.Subtree == nil {
+				return nil, errors.Errorf("subtree for directory %v is missing", nodepath)
+			}
+			stats, err = w.walk(ctx, *node.Subtree, nodepath, visible && w.showChildren(nodepath))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if visible && w.show(nodepath) {
+			w.report(nodepath, node.Type, stats)
+		}
+		total.add(stats)
+	}
+
+	return total, nil
+}
+
+func lsDiskUsage(ctx context.Context, repo restic.Repository, gopts GlobalOptions, sn *restic.Snapshot, dirs []string, recursive bool,
+	withinDir, approachingMatchingTree func(string) bool) error {
+
+	var report func(nodepath string, nodeType string, stats *duStats)
+	if gopts.JSON {
+		enc := json.NewEncoder(gopts.stdout)
+		report = func(nodepath string, nodeType string, stats *duStats) {
+			err := enc.Encode(lsDiskUsageEntry{
+				Path:       nodepath,
+				Type:       nodeType,
+				Size:       stats.Size,
+				UniqueSize: stats.UniqueSize,
+				Files:      stats.Files,
+				StructType: "du",
+			})
+			if err != nil {
+				Warnf("JSON encode failed: %v\n", err)
+			}
+		}
+	} else {
+		Verbosef("%12s %12s %8s  %s\n", "size", "unique", "files", "path")
+		report = func(nodepath string, nodeType string, stats *duStats) {
+			Printf("%12s %12s %8d  %s\n", ui.FormatBytes(stats.Size), ui.FormatBytes(stats.UniqueSize), stats.Files, nodepath)
+		}
+	}
+
+	w := &duWalker{
+		repo: repo,
+		show: withinDir,
+		showChildren: func(nodepath string) bool {
+			// mirror the traversal of the regular listing
+			return (recursive && withinDir(nodepath)) || approachingMatchingTree(nodepath)
+		},
+		report: report,
+	}
+
+	total, err := w.walk(ctx, *sn.Tree, "/", true)
+	if err != nil {
+		return err
+	}
+
+	if len(dirs) == 0 {
+		report("/", "dir", total)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+)
+
+var cmdMaintain = &cobra.Command{
+	Use:   "maintain [flags]",
+	Short: "Run all regular maintenance tasks",
+	Long: `
+The "maintain" command runs the regular maintenance tasks for a repository in
+a single session, while holding an exclusive lock:
+
+  1. remove snapshots according to the retention policy stored in the
+     repository using "restic config set keep-...", as "forget" would
+  2. remove unneeded data as "prune" would, limited by the --max-* options
+  3. merge small index files as "repair index --compact" would
+  4. check the repository and read a subset of the data as "check" would
+
+This makes it the single command to schedule for the maintenance of a
+repository. At the end, a summary of all steps is printed, with --json as a
+single JSON document. With --json, the output of the single steps is written
+to stderr.
+
+With --dry-run, the repository is not modified and only a non-exclusive lock
+is created, thus backups can continue while the command is running.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any
+error, including errors found in the repository.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMaintain(cmd.Context(), maintainOptions, globalOptions, args)
+	},
+}
+
+// MaintainOptions collects all options for the maintain command.
+type MaintainOptions struct {
+	DryRun         bool
+	SkipCheck      bool
+	ReadDataSubset string
+}
+
+var maintainOptions MaintainOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdMaintain)
+
+	f := cmdMaintain.Flags()
+	f.BoolVarP(&maintainOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+	f.BoolVar(&maintainOptions.SkipCheck, "skip-check", false, "do not check the repository")
+	f.StringVar(&maintainOptions.ReadDataSubset, "read-data-subset", "1%", "read a `subset` of data packs when checking the repository, see 'restic check' (empty: do not read data)")
+	f.SortFlags = false
+	addPruneOptions(cmdMaintain)
+}
+
+// MaintainReport is the summary of a maintain run, printed as JSON.
+type MaintainReport struct {
+	DryRun bool                  `json:"dry_run"`
+	Forget *MaintainForgetReport `json:"forget,omitempty"`
+	Prune  *MaintainPruneReport  `json:"prune,omitempty"`
+	Index  *MaintainIndexReport  `json:"index,omitempty"`
+	Check  *MaintainCheckReport  `json:"check,omitempty"`
+
+	Duration float64 `json:"duration"`
+}
+
+// MaintainForgetReport describes the snapshots removed by the stored policy.
+type MaintainForgetReport struct {
+	Policy  string         `json:"policy"`
+	Groups  []*ForgetGroup `json:"groups"`
+	Removed int            `json:"removed"`
+}
+
+// MaintainPruneReport contains the statistics of the prune step.
+type MaintainPruneReport struct {
+	RepackBytes    uint64 `json:"repack_bytes"`
+	RemoveBytes    uint64 `json:"remove_bytes"`
+	RemainingBytes uint64 `json:"remaining_bytes"`
+	UnusedBytes    uint64 `json:"unused_bytes_after"`
+	RepackPacks    uint   `json:"repack_packs"`
+	RemovePacks    uint   `json:"remove_packs"`
+}
+
+// MaintainIndexReport describes the compaction of small index files.
+type MaintainIndexReport struct {
+	SmallIndexes int `json:"small_indexes"`
+	Compacted    int `json:"compacted"`
+}
+
+// MaintainCheckReport contains the result of the check step.
+type MaintainCheckReport struct {
+	ReadDataSubset string `json:"read_data_subset,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+func runMaintain(ctx context.Context, opts MaintainOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the maintain command expects no arguments")
+	}
+
+	pruneOpts := pruneOptions
+	pruneOpts.DryRun = opts.DryRun
+	if err := verifyPruneOptions(&pruneOpts); err != nil {
+		return err
+	}
+
+	checkOpts := CheckOptions{ReadDataSubset: opts.ReadDataSubset, WithCache: true}
+	if !opts.SkipCheck {
+		if err := checkFlags(checkOpts); err != nil {
+			return err
+		}
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if repo.Backend().Connections() < 2 {
+		return errors.Fatal("maintain requires a backend connection limit of at least two")
+	}
+
+	if gopts.NoLock && !opts.DryRun {
+		return errors.Fatal("--no-lock is only applicable in combination with --dry-run for maintain command")
+	}
+
+	if !opts.DryRun {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	} else if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if gopts.JSON {
+		// keep stdout for the report
+		stdout := globalOptions.stdout
+		globalOptions.stdout = globalOptions.stderr
+		defer func() {
+			globalOptions.stdout = stdout
+		}()
+	}
+
+	start := time.Now()
+	report := MaintainReport{DryRun: opts.DryRun}
+
+	Verbosef("removing snapshots according to the stored policy\n")
+	removed, err := maintainForget(ctx, opts, gopts, repo, &report)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("\nremoving unneeded data\n")
+	stats, err := pruneRepo(ctx, pruneOpts, gopts, repo, removed)
+	if err != nil {
+		return err
+	}
+	report.Prune = newMaintainPruneReport(stats)
+
+	Verbosef("\nmerging small index files\n")
+	report.Index, err = maintainIndex(ctx, opts, gopts, repo)
+	if err != nil {
+		return err
+	}
+
+	var checkErr error
+	if !opts.SkipCheck {
+		Verbosef("\nchecking the repository\n")
+		checkErr = runCheckWithRepo(ctx, checkOpts, gopts, repo, nil)
+		report.Check = &MaintainCheckReport{ReadDataSubset: opts.ReadDataSubset}
+		if checkErr != nil {
+			report.Check.Error = checkErr.Error()
+		}
+	}
+
+	report.Duration = time.Since(start).Seconds()
+	if gopts.JSON {
+		if err := json.NewEncoder(gopts.stdout).Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printMaintainReport(report)
+	}
+
+	return checkErr
+}
+
+// maintainForget removes the snapshots according to the retention policy
+// stored in the repository. It returns the IDs of the removed snapshots.
+func maintainForget(ctx context.Context, opts MaintainOptions, gopts GlobalOptions, repo *repository.Repository, report *MaintainReport) (restic.IDSet, error) {
+	forgetOpts, err := forgetPolicyDefaults(repo.Config().Defaults)
+	if err != nil {
+		return nil, err
+	}
+	policy := forgetOpts.policy()
+	if policy.Empty() {
+		Verbosef("no policy is stored in the repository, no snapshots will be removed\n")
+		return restic.NewIDSet(), nil
+	}
+
+	forgetOpts.GroupBy = restic.SnapshotGroupByOptions{Host: true, Path: true}
+	forgetOpts.DryRun = opts.DryRun
+	groups, removed, err := forgetSnapshots(ctx, forgetOpts, gopts, repo, nil)
+	if err != nil {
+		return nil, err
+	}
+	report.Forget = &MaintainForgetReport{
+		Policy:  policy.String(),
+		Groups:  groups,
+		Removed: len(removed),
+	}
+	return removed, nil
+}
+
+// maintainIndex merges the small index files, unless opts.DryRun is set.
+func maintainIndex(ctx context.Context, opts MaintainOptions, gopts GlobalOptions, repo *repository.Repository) (*MaintainIndexReport, error) {
+	// prune may have replaced the index files, load the current ones
+	if err := repo.SetIndex(index.NewMasterIndex()); err != nil {
+		return nil, err
+	}
+	if err := repo.LoadIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	small := repo.SmallIndexes()
+	report := &MaintainIndexReport{SmallIndexes: len(small)}
+	if len(small) < 2 {
+		Verbosef("no index files to compact\n")
+		return report, nil
+	}
+	if opts.DryRun {
+		Verbosef("would have compacted %d small index files\n", len(small))
+		return report, nil
+	}
+
+	if err := compactIndexFiles(ctx, gopts, repo, small); err != nil {
+		return nil, err
+	}
+	report.Compacted = len(small)
+	return report, nil
+}
+
+func newMaintainPruneReport(stats pruneStats) *MaintainPruneReport {
+	totalSize := stats.size.used + stats.size.duplicate + stats.size.unused + stats.size.unref
+	removeSize := stats.size.remove + stats.size.repackrm + stats.size.unref
+	return &MaintainPruneReport{
+		RepackBytes:    stats.size.repack,
+		RemoveBytes:    removeSize,
+		RemainingBytes: totalSize - removeSize,
+		UnusedBytes:    stats.size.duplicate + stats.size.unused - stats.size.remove - stats.size.repackrm,
+		RepackPacks:    stats.packs.repack,
+		RemovePacks:    stats.packs.remove + stats.packs.unref,
+	}
+}
+
+func printMaintainReport(report MaintainReport) {
+	verb := "removed"
+	if report.DryRun {
+		verb = "would remove"
+	}
+
+	Printf("\nsummary:\n")
+	if report.Forget != nil {
+		Printf("  forget: %s %d snapshots\n", verb, report.Forget.Removed)
+	} else {
+		Printf("  forget: no policy stored in the repository\n")
+	}
+	Printf("  prune:  %s %s, repacking %s, %s remaining\n", verb,
+		ui.FormatBytes(report.Prune.RemoveBytes), ui.FormatBytes(report.Prune.RepackBytes), ui.FormatBytes(report.Prune.RemainingBytes))
+	Printf("  index:  %d small index files, %d compacted\n", report.Index.SmallIndexes, report.Index.Compacted)
+	switch {
+	case report.Check == nil:
+		Printf("  check:  skipped\n")
+	case report.Check.Error != "":
+		Printf("  check:  %v\n", report.Check.Error)
+	default:
+		Printf("  check:  no errors were found\n")
+	}
+	Printf("finished after %v\n", ui.FormatDuration(time.Duration(report.Duration*float64(time.Second))))
+}
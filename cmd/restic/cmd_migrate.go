@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/migrations"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
 
 	"github.com/spf13/cobra"
 )
@@ -17,6 +23,23 @@ The "migrate" command checks which migrations can be applied for a repository
 and prints a list with available migration names. If one or more migration
 names are specified, these migrations are applied.
 
+The "--check-all" option prints all migrations in the order in which they have
+to be applied, together with the reason why a migration is not applicable, the
+migrations it depends on and an estimate of the data it rewrites. The duration
+is estimated assuming that the rewritten data is downloaded and uploaded again
+at the rate given by "--assumed-throughput".
+
+The "rechunk" migration splits all files again using the chunker polynomial
+given by "--chunker-polynomial", for example the polynomial of another
+repository as shown by "restic cat config". Afterwards, "copy" deduplicates
+data between both repositories. The value "random" selects a new random
+polynomial.
+
+The "--apply-all" option applies all applicable migrations in that order. Each
+migration is checked again before it is applied. If a migration fails or is
+interrupted, running "migrate --apply-all" again continues with the migrations
+which have not been completed yet.
+
 EXIT STATUS
 ===========
 
@@ -30,7 +53,13 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // MigrateOptions bundles all options for the 'check' command.
 type MigrateOptions struct {
-	Force bool
+	Force             bool
+	CheckAll          bool
+	ApplyAll          bool
+	AssumedThroughput string
+	// ChunkerPolynomial is used by the rechunk migration
+	ChunkerPolynomial string
+	signingKeyOptions
 }
 
 var migrateOptions MigrateOptions
@@ -39,13 +68,18 @@ func init() {
 	cmdRoot.AddCommand(cmdMigrate)
 	f := cmdMigrate.Flags()
 	f.BoolVarP(&migrateOptions.Force, "force", "f", false, `apply a migration a second time`)
+	f.BoolVar(&migrateOptions.CheckAll, "check-all", false, "list all migrations with their dependencies and estimated cost")
+	f.BoolVar(&migrateOptions.ApplyAll, "apply-all", false, "apply all applicable migrations in dependency order")
+	f.StringVar(&migrateOptions.ChunkerPolynomial, "chunker-polynomial", "", "use the chunker `polynomial` (hexadecimal or \"random\") for the rechunk migration")
+	f.StringVar(&migrateOptions.AssumedThroughput, "assumed-throughput", "20M", "assume a transfer `rate` per second to estimate durations (allowed suffixes: k/K, m/M, g/G, t/T)")
+	initSigningKeyOptions(f, &migrateOptions.signingKeyOptions)
 }
 
-func checkMigrations(ctx context.Context, repo restic.Repository) error {
+func checkMigrations(ctx context.Context, opts MigrateOptions, gopts GlobalOptions, repo restic.Repository) error {
 	Printf("available migrations:\n")
 	found := false
 
-	for _, m := range migrations.All {
+	for _, m := range allMigrations(opts, gopts) {
 		ok, _, err := m.Check(ctx, repo)
 		if err != nil {
 			return err
@@ -67,7 +101,7 @@ func checkMigrations(ctx context.Context, repo restic.Repository) error {
 func applyMigrations(ctx context.Context, opts MigrateOptions, gopts GlobalOptions, repo restic.Repository, args []string) error {
 	var firsterr error
 	for _, name := range args {
-		for _, m := range migrations.All {
+		for _, m := range allMigrations(opts, gopts) {
 			if m.Name() == name {
 				ok, reason, err := m.Check(ctx, repo)
 				if err != nil {
@@ -86,29 +120,17 @@ func applyMigrations(ctx context.Context, opts MigrateOptions, gopts GlobalOptio
 					Warnf("check for migration %v failed, continuing anyway\n", m.Name())
 				}
 
-				if m.RepoCheck() {
-					Printf("checking repository integrity...\n")
-
-					checkOptions := CheckOptions{}
-					checkGopts := gopts
-					// the repository is already locked
-					checkGopts.NoLock = true
-					err = runCheck(ctx, checkOptions, checkGopts, []string{})
-					if err != nil {
-						return err
-					}
+				if err = checkRepoForMigration(ctx, gopts, m); err != nil {
+					return err
 				}
 
-				Printf("applying migration %v...\n", m.Name())
-				if err = m.Apply(ctx, repo); err != nil {
+				if err = applyMigration(ctx, repo, m); err != nil {
 					Warnf("migration %v failed: %v\n", m.Name(), err)
 					if firsterr == nil {
 						firsterr = err
 					}
 					continue
 				}
-
-				Printf("migration %v: success\n", m.Name())
 			}
 		}
 	}
@@ -116,20 +138,233 @@ func applyMigrations(ctx context.Context, opts MigrateOptions, gopts GlobalOptio
 	return firsterr
 }
 
+// checkRepoForMigration checks the repository integrity if this is required
+// by the migration.
+func checkRepoForMigration(ctx context.Context, gopts GlobalOptions, m migrations.Migration) error {
+	if !m.RepoCheck() {
+		return nil
+	}
+
+	Printf("checking repository integrity...\n")
+
+	checkOptions := CheckOptions{}
+	checkGopts := gopts
+	// the repository is already locked
+	checkGopts.NoLock = true
+	return runCheck(ctx, checkOptions, checkGopts, []string{})
+}
+
+// applyMigration applies the migration and records it in the audit log.
+func applyMigration(ctx context.Context, repo restic.Repository, m migrations.Migration) error {
+	Printf("applying migration %v...\n", m.Name())
+	if err := m.Apply(ctx, repo); err != nil {
+		return err
+	}
+
+	// the migration may have modified the config
+	if r, ok := repo.(interface{ ReloadConfig(context.Context) error }); ok {
+		if err := r.ReloadConfig(ctx); err != nil {
+			return err
+		}
+	}
+
+	recordAudit(ctx, repo, "migrate", "applied "+m.Name(), nil)
+	Printf("migration %v: success\n", m.Name())
+	return nil
+}
+
+// migrationStep describes a migration in the output of migrate --check-all.
+type migrationStep struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Applicable  bool     `json:"applicable"`
+	Reason      string   `json:"reason,omitempty"`
+	Requires    []string `json:"requires,omitempty"`
+	RepoCheck   bool     `json:"repo_check"`
+	Bytes       uint64   `json:"bytes,omitempty"`
+	Files       uint64   `json:"files,omitempty"`
+	Duration    float64  `json:"duration_seconds,omitempty"`
+}
+
+// planMigrations checks all migrations in the order in which they have to be
+// applied. Only applicable migrations are listed as requirements.
+func planMigrations(ctx context.Context, opts MigrateOptions, gopts GlobalOptions, repo restic.Repository) ([]migrationStep, error) {
+	throughput, err := parseSizeStr(opts.AssumedThroughput)
+	if err != nil || throughput <= 0 {
+		return nil, errors.Fatalf("invalid value %q for --assumed-throughput", opts.AssumedThroughput)
+	}
+
+	sorted, err := migrations.Sort(allMigrations(opts, gopts))
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []migrationStep
+	applicable := make(map[string]bool)
+	for _, m := range sorted {
+		ok, reason, err := m.Check(ctx, repo)
+		if err != nil {
+			return nil, errors.Fatalf("checking migration %v failed: %v", m.Name(), err)
+		}
+
+		step := migrationStep{
+			Name:        m.Name(),
+			Description: m.Desc(),
+			Applicable:  ok,
+			Reason:      reason,
+			RepoCheck:   m.RepoCheck(),
+		}
+		if ok {
+			applicable[m.Name()] = true
+			for _, dep := range migrations.Dependencies(m) {
+				if applicable[dep] {
+					step.Requires = append(step.Requires, dep)
+				}
+			}
+
+			if e, isEstimator := m.(migrations.Estimator); isEstimator {
+				est, err := e.Estimate(ctx, repo)
+				if err != nil {
+					return nil, errors.Fatalf("estimating migration %v failed: %v", m.Name(), err)
+				}
+				step.Bytes = est.Bytes
+				step.Files = est.Files
+				// the data is downloaded and uploaded again
+				step.Duration = float64(2*est.Bytes) / float64(throughput)
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func printMigrationPlan(steps []migrationStep) {
+	Printf("migration plan:\n")
+	n := 0
+	for _, step := range steps {
+		if !step.Applicable {
+			continue
+		}
+		n++
+		Printf("  %d. %v\t%v\n", n, step.Name, step.Description)
+
+		var details []string
+		if step.Files > 0 {
+			details = append(details, fmt.Sprintf("rewrites %v in %d files", ui.FormatBytes(step.Bytes), step.Files))
+			details = append(details, "about "+ui.FormatDuration(time.Duration(step.Duration*float64(time.Second))))
+		}
+		if step.RepoCheck {
+			details = append(details, "checks the repository first")
+		}
+		if len(step.Requires) > 0 {
+			details = append(details, "requires "+strings.Join(step.Requires, ", "))
+		}
+		if len(details) > 0 {
+			Printf("     %v\n", strings.Join(details, ", "))
+		}
+	}
+	if n == 0 {
+		Printf("  no migrations found\n")
+	}
+
+	first := true
+	for _, step := range steps {
+		if step.Applicable {
+			continue
+		}
+		if first {
+			Printf("\nnot applicable:\n")
+			first = false
+		}
+		reason := step.Reason
+		if reason == "" {
+			reason = "check failed"
+		}
+		Printf("  %v\t%v\n", step.Name, reason)
+	}
+}
+
+// applyAllMigrations applies all applicable migrations in dependency order.
+// Each migration is checked again right before it is applied, such that
+// migrations completed by an earlier run are skipped.
+func applyAllMigrations(ctx context.Context, opts MigrateOptions, gopts GlobalOptions, repo restic.Repository) error {
+	sorted, err := migrations.Sort(allMigrations(opts, gopts))
+	if err != nil {
+		return err
+	}
+
+	applied := 0
+	for _, m := range sorted {
+		ok, reason, err := m.Check(ctx, repo)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			Verboseff("skipping migration %v: %v\n", m.Name(), reason)
+			continue
+		}
+
+		if err := checkRepoForMigration(ctx, gopts, m); err != nil {
+			return err
+		}
+		if err := applyMigration(ctx, repo, m); err != nil {
+			return errors.Fatalf("migration %v failed: %v\nFix the problem and run 'migrate --apply-all' again to continue", m.Name(), err)
+		}
+		applied++
+	}
+
+	if applied == 0 {
+		Printf("no migrations found\n")
+	}
+	return nil
+}
+
 func runMigrate(ctx context.Context, opts MigrateOptions, gopts GlobalOptions, args []string) error {
+	if (opts.CheckAll || opts.ApplyAll) && len(args) > 0 {
+		return errors.Fatal("--check-all and --apply-all cannot be combined with migration names")
+	}
+	if opts.CheckAll && opts.ApplyAll {
+		return errors.Fatal("--check-all and --apply-all are mutually exclusive")
+	}
+	if opts.ApplyAll && opts.Force {
+		return errors.Fatal("--force cannot be used with --apply-all")
+	}
+
 	repo, err := OpenRepository(ctx, gopts)
 	if err != nil {
 		return err
 	}
 
+	if opts.CheckAll {
+		lock, ctx, err := lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		steps, err := planMigrations(ctx, opts, gopts, repo)
+		if err != nil {
+			return err
+		}
+		if gopts.JSON {
+			return json.NewEncoder(globalOptions.stdout).Encode(steps)
+		}
+		printMigrationPlan(steps)
+		return nil
+	}
+
 	lock, ctx, err := lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
 	defer unlockRepo(lock)
 	if err != nil {
 		return err
 	}
 
+	if opts.ApplyAll {
+		return applyAllMigrations(ctx, opts, gopts, repo)
+	}
+
 	if len(args) == 0 {
-		return checkMigrations(ctx, repo)
+		return checkMigrations(ctx, opts, gopts, repo)
 	}
 
 	return applyMigrations(ctx, opts, gopts, repo, args)
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdMigrateFrom = &cobra.Command{
+	Use:   "migrate-from [flags] borg:repository [archive ...]",
+	Short: "Convert archives of another backup program into snapshots",
+	Long: `
+The "migrate-from" command converts the archives of another backup program
+into restic snapshots. Currently, Borg repositories are supported, which are
+specified as "borg:" followed by the location of the Borg repository. If
+archive names are given, only these archives are converted, otherwise all
+archives are converted from the oldest to the newest one.
+
+The archives are read using the "borg" command, which must be installed. Its
+passphrase is read by borg itself, e.g. from the environment variable
+BORG_PASSPHRASE. Each archive becomes a snapshot of "/" with the time and
+hostname of the archive, as borg does not store absolute paths. Archives which
+were converted before are skipped.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigrateFrom(cmd.Context(), migrateFromOptions, globalOptions, args)
+	},
+}
+
+// MigrateFromOptions collects all options for the migrate-from command.
+type MigrateFromOptions struct {
+	BorgCommand string
+	Host        string
+	Tags        restic.TagLists
+}
+
+var migrateFromOptions MigrateFromOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdMigrateFrom)
+
+	f := cmdMigrateFrom.Flags()
+	f.StringVar(&migrateFromOptions.BorgCommand, "borg-command", "borg", "run `command` to read borg repositories")
+	f.StringVarP(&migrateFromOptions.Host, "host", "H", "", "set the `hostname` for the snapshots instead of the hostname stored in the archives")
+	f.Var(&migrateFromOptions.Tags, "tag", "add `tags` for the new snapshots in the format `tag[,tag,...]` (can be specified multiple times)")
+}
+
+func runMigrateFrom(ctx context.Context, opts MigrateFromOptions, gopts GlobalOptions, args []string) error {
+	if len(args) == 0 {
+		return errors.Fatal("no source repository specified")
+	}
+	tpe, location, ok := strings.Cut(args[0], ":")
+	if !ok || location == "" {
+		return errors.Fatalf("invalid source %q, must be specified as borg:repository", args[0])
+	}
+	if tpe != "borg" {
+		return errors.Fatalf("unsupported source type %q, only borg is supported", tpe)
+	}
+	src := borgSource{command: opts.BorgCommand, repo: location}
+
+	archives, err := src.Archives(ctx)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		archives, err = selectBorgArchives(archives, args[1:])
+		if err != nil {
+			return err
+		}
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, ctx, err := lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	var existing []*restic.Snapshot
+	err = restic.ForAllSnapshots(ctx, repo.Backend(), repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		existing = append(existing, sn)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	var migrated, skipped int
+	for _, a := range archives {
+		info, err := src.Info(ctx, a.Name)
+		if err != nil {
+			return err
+		}
+		host := info.Hostname
+		if opts.Host != "" {
+			host = opts.Host
+		}
+		if isMigratedArchive(existing, host, info) {
+			Verbosef("skipping archive %v, it was already converted\n", a.Name)
+			skipped++
+			continue
+		}
+
+		Verbosef("converting archive %v of %v at %s\n", a.Name, host, info.Start)
+		sn, id, err := migrateBorgArchive(ctx, repo, src, info, archiver.SnapshotOptions{
+			Tags:     opts.Tags.Flatten(),
+			Time:     info.Start,
+			Hostname: host,
+		})
+		if err != nil {
+			return errors.Fatalf("unable to convert archive %v: %v", a.Name, err)
+		}
+		Verbosef("saved snapshot %s\n", id.Str())
+		existing = append(existing, sn)
+		migrated++
+	}
+
+	Verbosef("converted %d archives, skipped %d\n", migrated, skipped)
+	return nil
+}
+
+// migrateBorgArchive saves the archive as a new snapshot.
+func migrateBorgArchive(ctx context.Context, repo restic.Repository, src borgSource, a borgArchive, opts archiver.SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
+	// stop borg if the snapshot cannot be saved
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rd, err := src.ExportTar(ctx, a.Name)
+	if err != nil {
+		return nil, restic.ID{}, err
+	}
+
+	arch := archiver.New(repo, fs.Local{}, archiver.Options{})
+	sn, id, err := arch.SnapshotTar(ctx, rd, "/", opts)
+	if err != nil {
+		cancel()
+		_ = rd.Close()
+		return nil, restic.ID{}, err
+	}
+
+	if err := rd.Close(); err != nil {
+		// the archive may be incomplete
+		h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+		if rerr := repo.Backend().Remove(ctx, h); rerr != nil {
+			Warnf("unable to remove incomplete snapshot %v: %v\n", id.Str(), rerr)
+		}
+		return nil, restic.ID{}, err
+	}
+	return sn, id, nil
+}
+
+// selectBorgArchives returns the archives with the given names.
+func selectBorgArchives(archives []borgArchive, names []string) ([]borgArchive, error) {
+	byName := make(map[string]borgArchive, len(archives))
+	for _, a := range archives {
+		byName[a.Name] = a
+	}
+
+	var res []borgArchive
+	for _, name := range names {
+		a, ok := byName[name]
+		if !ok {
+			return nil, errors.Fatalf("archive %v not found", name)
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+// isMigratedArchive returns whether a snapshot of the archive exists already.
+func isMigratedArchive(snapshots []*restic.Snapshot, host string, a borgArchive) bool {
+	for _, sn := range snapshots {
+		if sn.Hostname == host && sn.Time.Equal(a.Start) && len(sn.Paths) == 1 && sn.Paths[0] == "/" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdParity = &cobra.Command{
+	Use:   "parity [flags]",
+	Short: "Store parity files to repair damaged pack files",
+	Long: `
+The "parity" command enables storing a parity file for each pack file of the
+repository. A parity file contains Reed-Solomon parity data, which allows
+restoring the pack file using "restic repair packs" if some of it was damaged,
+for example by a storage medium which silently corrupts data. With the
+default overhead of 5%, a pack file is split into 20 parts and damage within
+any single part can be repaired.
+
+Once enabled, all commands which create pack files also store their parity
+files. Older versions of restic can then no longer modify the repository.
+The command creates the missing parity files for existing pack files and
+removes parity files for which the pack file no longer exists. Run it again
+to change the overhead, which only affects parity files created afterwards.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runParity(cmd.Context(), cmd, parityOptions, globalOptions, args)
+	},
+}
+
+// ParityOptions bundles all options for the parity command.
+type ParityOptions struct {
+	Overhead string
+}
+
+var parityOptions ParityOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdParity)
+
+	f := cmdParity.Flags()
+	f.StringVar(&parityOptions.Overhead, "overhead", "5%", "size of the parity files relative to the pack files, in `percent`")
+}
+
+func parseParityOverhead(s string) (restic.ParityConfig, error) {
+	if !strings.HasSuffix(s, "%") {
+		return restic.ParityConfig{}, errors.Fatalf("invalid parity overhead %q, must be a percentage like 5%%", s)
+	}
+	overhead, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return restic.ParityConfig{}, errors.Fatalf("invalid parity overhead %q: %v", s, err)
+	}
+	return repository.NewParityConfig(overhead)
+}
+
+func runParity(ctx context.Context, cmd *cobra.Command, opts ParityOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the parity command expects no arguments")
+	}
+
+	pcfg, err := parseParityOverhead(opts.Overhead)
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, ctx, err := lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	// keep the current configuration unless --overhead is given explicitly
+	current := repo.Config().Parity
+	if current == nil || (cmd != nil && cmd.Flags().Changed("overhead") && *current != pcfg) {
+		Verbosef("storing parity files with %d parity shards for every %d data shards\n", pcfg.ParityShards, pcfg.DataShards)
+		if err := repo.EnableParity(ctx, pcfg); err != nil {
+			return err
+		}
+	}
+
+	packs := restic.NewIDSet()
+	missing := restic.NewIDSet()
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, _ int64) error {
+		packs.Insert(id)
+		missing.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	orphaned := restic.NewIDSet()
+	err = repo.List(ctx, restic.ParityFile, func(id restic.ID, _ int64) error {
+		if packs.Has(id) {
+			missing.Delete(id)
+		} else {
+			orphaned.Insert(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(orphaned) > 0 {
+		Verbosef("removing %d parity files of removed pack files\n", len(orphaned))
+		if err := DeleteFilesChecked(ctx, gopts, repo, orphaned, restic.ParityFile); err != nil {
+			return err
+		}
+	}
+
+	Verbosef("creating parity files for %d pack files\n", len(missing))
+	damaged, err := createParityFiles(ctx, gopts, repo, missing)
+	if err != nil {
+		return err
+	}
+	if len(damaged) > 0 {
+		return errors.Fatalf("no parity files were created for the damaged pack files %v, run `restic check --read-data` for details", damaged)
+	}
+	Verbosef("done\n")
+	return nil
+}
+
+// createParityFiles creates the parity files for the pack files. Damaged
+// pack files are skipped, they are returned sorted.
+func createParityFiles(ctx context.Context, gopts GlobalOptions, repo *repository.Repository, packs restic.IDSet) (restic.IDs, error) {
+	var (
+		m       sync.Mutex
+		damaged restic.IDs
+	)
+
+	ch := make(chan restic.ID)
+	wg, wgCtx := errgroup.WithContext(ctx)
+	wg.Go(func() error {
+		defer close(ch)
+		for id := range packs {
+			select {
+			case ch <- id:
+			case <-wgCtx.Done():
+				return wgCtx.Err()
+			}
+		}
+		return nil
+	})
+
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packs)), "pack files")
+	// creating parity files is IO-bound
+	for i := 0; i < int(repo.Connections()); i++ {
+		wg.Go(func() error {
+			for id := range ch {
+				buf, restored, err := repo.ReconstructPack(wgCtx, id)
+				if err == nil && restored {
+					err = errors.New("pack file is damaged")
+				}
+				if err != nil {
+					// never compute the parity of damaged data
+					Warnf("pack %v: %v, skipping it\n", id.Str(), err)
+					m.Lock()
+					damaged = append(damaged, id)
+					m.Unlock()
+					bar.Add(1)
+					continue
+				}
+				if err := repo.SaveParity(wgCtx, id, buf); err != nil {
+					return err
+				}
+				bar.Add(1)
+			}
+			return nil
+		})
+	}
+	err := wg.Wait()
+	bar.Done()
+
+	sort.Sort(damaged)
+	return damaged, err
+}
+
+// deleteParityFiles removes the parity files of the removed pack files, if the
+// repository uses parity files. Parity files which are left behind are
+// removed the next time "parity" runs.
+func deleteParityFiles(ctx context.Context, gopts GlobalOptions, repo restic.Repository, removedPacks restic.IDSet) {
+	if repo.Config().Parity == nil || len(removedPacks) == 0 {
+		return
+	}
+
+	remove := restic.NewIDSet()
+	err := repo.List(ctx, restic.ParityFile, func(id restic.ID, _ int64) error {
+		if removedPacks.Has(id) {
+			remove.Insert(id)
+		}
+		return nil
+	})
+	if err != nil {
+		Warnf("unable to list parity files: %v\n", err)
+		return
+	}
+	DeleteFiles(ctx, gopts, repo, remove, restic.ParityFile)
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/index"
@@ -14,8 +18,10 @@ import (
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/progress"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
 )
 
 var errorIndexIncomplete = errors.Fatal("index is not complete")
@@ -40,6 +46,13 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 	},
 }
 
+// Strategies for ordering the repack candidates.
+const (
+	repackStrategyEfficiency = "efficiency"
+	repackStrategyAge        = "age"
+	repackStrategyBalanced   = "balanced"
+)
+
 // PruneOptions collects all options for the cleanup command.
 type PruneOptions struct {
 	DryRun                bool
@@ -56,6 +69,11 @@ type PruneOptions struct {
 	RepackCachableOnly bool
 	RepackSmall        bool
 	RepackUncompressed bool
+	RepackRecompress   bool
+	RepackStrategy     string
+
+	MaxDuration time.Duration
+	deadline    time.Time
 }
 
 var pruneOptions PruneOptions
@@ -75,6 +93,9 @@ func addPruneOptions(c *cobra.Command) {
 	f.BoolVar(&pruneOptions.RepackCachableOnly, "repack-cacheable-only", false, "only repack packs which are cacheable")
 	f.BoolVar(&pruneOptions.RepackSmall, "repack-small", false, "repack pack files below 80% of target pack size")
 	f.BoolVar(&pruneOptions.RepackUncompressed, "repack-uncompressed", false, "repack all uncompressed data")
+	f.BoolVar(&pruneOptions.RepackRecompress, "repack-recompress", false, "repack all data to compress it again, requires --compression max")
+	f.StringVar(&pruneOptions.RepackStrategy, "repack-strategy", repackStrategyEfficiency, "`strategy` for choosing the pack files to repack first: efficiency (most reclaimable space per repacked byte), age (oldest data) or balanced (efficiency weighted by age)")
+	f.DurationVar(&pruneOptions.MaxDuration, "max-duration", 0, "stop repacking in time to finish within `duration` after pruning started (0: no limit)")
 }
 
 func verifyPruneOptions(opts *PruneOptions) error {
@@ -91,6 +112,18 @@ func verifyPruneOptions(opts *PruneOptions) error {
 		opts.MaxRepackBytes = 0
 	}
 
+	switch opts.RepackStrategy {
+	case "":
+		opts.RepackStrategy = repackStrategyEfficiency
+	case repackStrategyEfficiency, repackStrategyAge, repackStrategyBalanced:
+	default:
+		return errors.Fatalf("invalid value %q for --repack-strategy, must be one of efficiency, age or balanced", opts.RepackStrategy)
+	}
+
+	if opts.MaxDuration < 0 {
+		return errors.Fatal("--max-duration must not be negative")
+	}
+
 	maxUnused := strings.TrimSpace(opts.MaxUnused)
 	if maxUnused == "" {
 		return errors.Fatalf("invalid value for --max-unused: %q", opts.MaxUnused)
@@ -145,6 +178,10 @@ func runPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions) error
 	if opts.RepackUncompressed && gopts.Compression == repository.CompressionOff {
 		return errors.Fatal("disabled compression and `--repack-uncompressed` are mutually exclusive")
 	}
+	if opts.RepackRecompress && gopts.Compression != repository.CompressionMax {
+		// data compressed using auto would just be compressed the same way again
+		return errors.Fatal("`--repack-recompress` requires `--compression max`")
+	}
 
 	repo, err := OpenRepository(ctx, gopts)
 	if err != nil {
@@ -155,7 +192,7 @@ func runPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions) error
 		return errors.Fatal("prune requires a backend connection limit of at least two")
 	}
 
-	if repo.Config().Version < 2 && opts.RepackUncompressed {
+	if repo.Config().Version < 2 && (opts.RepackUncompressed || opts.RepackRecompress) {
 		return errors.Fatal("compression requires at least repository format version 2")
 	}
 
@@ -167,16 +204,43 @@ func runPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions) error
 		opts.unsafeRecovery = true
 	}
 
-	lock, ctx, err := lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
-	defer unlockRepo(lock)
-	if err != nil {
-		return err
+	if gopts.NoLock && !opts.DryRun {
+		return errors.Fatal("--no-lock is only applicable in combination with --dry-run for prune command")
+	}
+
+	// a dry run does not modify the repository, thus concurrent backups can
+	// continue while it is running
+	if !opts.DryRun {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	} else if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
 	}
 
 	return runPruneWithRepo(ctx, opts, gopts, repo, restic.NewIDSet())
 }
 
 func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOptions, repo *repository.Repository, ignoreSnapshots restic.IDSet) error {
+	_, err := pruneRepo(ctx, opts, gopts, repo, ignoreSnapshots)
+	return err
+}
+
+// pruneRepo prunes the already locked repository and returns the statistics of
+// the prune plan.
+func pruneRepo(ctx context.Context, opts PruneOptions, gopts GlobalOptions, repo *repository.Repository, ignoreSnapshots restic.IDSet) (pruneStats, error) {
+	if opts.MaxDuration > 0 {
+		opts.deadline = time.Now().Add(opts.MaxDuration)
+	}
+
 	// we do not need index updates while pruning!
 	repo.DisableAutoIndexUpdate()
 
@@ -184,16 +248,28 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOption
 		Print("warning: running prune without a cache, this may be very slow!\n")
 	}
 
+	var snapshotLister restic.Lister = repo.Backend()
+	if opts.DryRun {
+		// A dry run may not hold an exclusive lock. List the snapshots before
+		// loading the index, such that the index contains all blobs referenced
+		// by snapshots of concurrent backups.
+		var err error
+		snapshotLister, err = backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+		if err != nil {
+			return pruneStats{}, err
+		}
+	}
+
 	Verbosef("loading indexes...\n")
-	// loading the index before the snapshots is ok, as we use an exclusive lock here
+	// loading the index before the snapshots is ok if we use an exclusive lock
 	err := repo.LoadIndex(ctx)
 	if err != nil {
-		return err
+		return pruneStats{}, err
 	}
 
-	plan, stats, err := planPrune(ctx, opts, repo, ignoreSnapshots, gopts.Quiet)
+	plan, stats, err := planPrune(ctx, opts, repo, snapshotLister, ignoreSnapshots, gopts.Quiet)
 	if err != nil {
-		return err
+		return stats, err
 	}
 
 	if opts.DryRun {
@@ -202,10 +278,16 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOption
 
 	err = printPruneStats(stats)
 	if err != nil {
-		return err
+		return stats, err
+	}
+
+	err = doPrune(ctx, opts, gopts, repo, plan)
+	if err != nil || opts.DryRun {
+		return stats, err
 	}
 
-	return doPrune(ctx, opts, gopts, repo, plan)
+	// doPrune has added the repacked packs to removePacks
+	return stats, extendRetention(ctx, gopts, repo, plan.usedPacks.Sub(plan.removePacks), ignoreSnapshots)
 }
 
 type pruneStats struct {
@@ -235,15 +317,19 @@ type pruneStats struct {
 		keep       uint
 		repack     uint
 		remove     uint
+		retained   uint
+		archived   uint
 	}
 }
 
 type prunePlan struct {
 	removePacksFirst restic.IDSet          // packs to remove first (unreferenced packs)
 	repackPacks      restic.IDSet          // packs to repack
+	repackOrder      restic.IDs            // repackPacks, the most beneficial first
 	keepBlobs        restic.CountedBlobSet // blobs to keep during repacking
 	removePacks      restic.IDSet          // packs to remove
 	ignorePacks      restic.IDSet          // packs to ignore when rebuilding the index
+	usedPacks        restic.IDSet          // packs containing used blobs
 }
 
 type packInfo struct {
@@ -259,14 +345,20 @@ type packInfoWithID struct {
 	ID restic.ID
 	packInfo
 	mustCompress bool
+	firstUsed    time.Time // time of the oldest snapshot using the pack, if known
 }
 
 // planPrune selects which files to rewrite and which to delete and which blobs to keep.
 // Also some summary statistics are returned.
-func planPrune(ctx context.Context, opts PruneOptions, repo restic.Repository, ignoreSnapshots restic.IDSet, quiet bool) (prunePlan, pruneStats, error) {
+func planPrune(ctx context.Context, opts PruneOptions, repo restic.Repository, snapshotLister restic.Lister, ignoreSnapshots restic.IDSet, quiet bool) (prunePlan, pruneStats, error) {
 	var stats pruneStats
 
-	usedBlobs, err := getUsedBlobs(ctx, repo, ignoreSnapshots, quiet)
+	var packFirstUsed map[restic.ID]time.Time
+	if opts.RepackStrategy == repackStrategyAge || opts.RepackStrategy == repackStrategyBalanced {
+		packFirstUsed = make(map[restic.ID]time.Time)
+	}
+
+	usedBlobs, err := getUsedBlobs(ctx, repo, snapshotLister, ignoreSnapshots, packFirstUsed, quiet)
 	if err != nil {
 		return prunePlan{}, stats, err
 	}
@@ -278,7 +370,7 @@ func planPrune(ctx context.Context, opts PruneOptions, repo restic.Repository, i
 	}
 
 	Verbosef("collecting packs for deletion and repacking\n")
-	plan, err := decidePackAction(ctx, opts, repo, indexPack, &stats, quiet)
+	plan, err := decidePackAction(ctx, opts, repo, indexPack, packFirstUsed, &stats, quiet)
 	if err != nil {
 		return prunePlan{}, stats, err
 	}
@@ -457,7 +549,7 @@ func packInfoFromIndex(ctx context.Context, idx restic.MasterIndex, usedBlobs re
 	return usedBlobs, indexPack, nil
 }
 
-func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Repository, indexPack map[restic.ID]packInfo, stats *pruneStats, quiet bool) (prunePlan, error) {
+func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Repository, indexPack map[restic.ID]packInfo, packFirstUsed map[restic.ID]time.Time, stats *pruneStats, quiet bool) (prunePlan, error) {
 	removePacksFirst := restic.NewIDSet()
 	removePacks := restic.NewIDSet()
 	repackPacks := restic.NewIDSet()
@@ -472,10 +564,20 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Reposi
 		targetPackSize = repo.PackSize() / 5 * 4
 	}
 
+	isRetained := newRetentionCheck(ctx, repo.Backend())
+	isArchived := newArchiveCheck(ctx, repo.Backend())
+	usedPacks := restic.NewIDSet()
+
 	// loop over all packs and decide what to do
 	bar := newProgressMax(!quiet, uint64(len(indexPack)), "packs processed")
 	err := repo.List(ctx, restic.PackFile, func(id restic.ID, packSize int64) error {
 		p, ok := indexPack[id]
+		if !ok && isRetained(id) {
+			// Pack is still protected by the backend => keep it for now
+			stats.packs.keep++
+			stats.packs.retained++
+			return nil
+		}
 		if !ok {
 			// Pack was not referenced in index and is not used  => immediately remove!
 			Verboseff("will remove pack %v as it is unused and not indexed\n", id.Str())
@@ -484,6 +586,10 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Reposi
 			return nil
 		}
 
+		if p.usedBlobs > 0 {
+			usedPacks.Insert(id)
+		}
+
 		if p.unusedSize+p.usedSize != uint64(packSize) && p.usedBlobs != 0 {
 			// Pack size does not fit and pack is needed => error
 			// If the pack is not needed, this is no error, the pack can
@@ -511,10 +617,17 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Reposi
 			// repo v2: always repack tree blobs if uncompressed
 			// compress data blobs if requested
 			mustCompress = (p.tpe == restic.TreeBlob || opts.RepackUncompressed) && p.uncompressed
+			// recompress all data if requested
+			mustCompress = mustCompress || opts.RepackRecompress
 		}
 
 		// decide what to do
 		switch {
+		case p.usedBlobs == 0 && isRetained(id):
+			// Pack is still protected by the backend => keep pack!
+			stats.packs.keep++
+			stats.packs.retained++
+
 		case p.usedBlobs == 0:
 			// All blobs in pack are no longer used => remove pack!
 			removePacks.Insert(id)
@@ -530,12 +643,12 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Reposi
 				// All blobs in pack are used and not mixed => keep pack!
 				stats.packs.keep++
 			} else {
-				repackSmallCandidates = append(repackSmallCandidates, packInfoWithID{ID: id, packInfo: p, mustCompress: mustCompress})
+				repackSmallCandidates = append(repackSmallCandidates, packInfoWithID{ID: id, packInfo: p, mustCompress: mustCompress, firstUsed: packFirstUsed[id]})
 			}
 
 		default:
 			// all other packs are candidates for repacking
-			repackCandidates = append(repackCandidates, packInfoWithID{ID: id, packInfo: p, mustCompress: mustCompress})
+			repackCandidates = append(repackCandidates, packInfoWithID{ID: id, packInfo: p, mustCompress: mustCompress, firstUsed: packFirstUsed[id]})
 		}
 
 		delete(indexPack, id)
@@ -582,12 +695,10 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Reposi
 		repackCandidates = append(repackCandidates, repackSmallCandidates...)
 	}
 
-	// Sort repackCandidates such that packs with highest ratio unused/used space are picked first.
-	// This is equivalent to sorting by unused / total space.
-	// Instead of unused[i] / used[i] > unused[j] / used[j] we use
-	// unused[i] * used[j] > unused[j] * used[i] as uint32*uint32 < uint64
-	// Moreover packs containing trees and too small packs are sorted to the beginning
-	sort.Slice(repackCandidates, func(i, j int) bool {
+	// Sort repackCandidates such that packs containing trees and too small packs
+	// are picked first, the remaining packs are ordered by the repack strategy.
+	now := time.Now()
+	sort.SliceStable(repackCandidates, func(i, j int) bool {
 		pi := repackCandidates[i].packInfo
 		pj := repackCandidates[j].packInfo
 		switch {
@@ -600,11 +711,13 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Reposi
 		case pj.unusedSize+pj.usedSize < uint64(targetPackSize) && pi.unusedSize+pi.usedSize >= uint64(targetPackSize):
 			return false
 		}
-		return pi.unusedSize*pj.usedSize > pj.unusedSize*pi.usedSize
+		return repackBefore(opts.RepackStrategy, repackCandidates[i], repackCandidates[j], now)
 	})
 
+	var repackOrder restic.IDs
 	repack := func(id restic.ID, p packInfo) {
 		repackPacks.Insert(id)
+		repackOrder = append(repackOrder, id)
 		stats.blobs.repack += p.unusedBlobs + p.usedBlobs
 		stats.size.repack += p.unusedSize + p.usedSize
 		stats.blobs.repackrm += p.unusedBlobs
@@ -626,6 +739,16 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Reposi
 		case reachedRepackSize:
 			stats.packs.keep++
 
+		case isRetained(p.ID):
+			// the pack could not be removed after repacking
+			stats.packs.keep++
+			stats.packs.retained++
+
+		case isArchived(p.ID):
+			// the pack cannot be read for repacking
+			stats.packs.keep++
+			stats.packs.archived++
+
 		case p.tpe != restic.DataBlob, p.mustCompress:
 			// repacking non-data packs / uncompressed-trees is only limited by repackSize
 			repack(p.ID, p.packInfo)
@@ -651,10 +774,165 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo restic.Reposi
 	return prunePlan{removePacksFirst: removePacksFirst,
 		removePacks: removePacks,
 		repackPacks: repackPacks,
+		repackOrder: repackOrder,
 		ignorePacks: ignorePacks,
+		usedPacks:   usedPacks,
 	}, nil
 }
 
+// repackBefore reports whether pack pi should be repacked before pj according
+// to strategy.
+func repackBefore(strategy string, pi, pj packInfoWithID, now time.Time) bool {
+	// The efficiency is the reclaimable space per repacked byte, packs with
+	// the highest ratio unused/used space come first. This is equivalent to
+	// sorting by unused / total space. Instead of unused[i] / used[i] >
+	// unused[j] / used[j] we use unused[i] * used[j] > unused[j] * used[i] as
+	// uint32*uint32 < uint64
+	moreEfficient := pi.unusedSize*pj.usedSize > pj.unusedSize*pi.usedSize
+
+	switch strategy {
+	case repackStrategyAge:
+		if !pi.firstUsed.Equal(pj.firstUsed) {
+			return pi.firstUsed.Before(pj.firstUsed)
+		}
+		return moreEfficient
+	case repackStrategyBalanced:
+		return repackScore(pi, now) > repackScore(pj, now)
+	default:
+		return moreEfficient
+	}
+}
+
+// repackScore weights the reclaimable space per repacked byte of p with the
+// age of its data in days. Old data is unlikely to become unused soon, which
+// makes repacking it more worthwhile.
+func repackScore(p packInfoWithID, now time.Time) float64 {
+	days := 0.0
+	if !p.firstUsed.IsZero() && p.firstUsed.Before(now) {
+		days = now.Sub(p.firstUsed).Hours() / 24
+	}
+	return float64(p.unusedSize) / float64(p.usedSize+1) * (1 + days)
+}
+
+// newRetentionCheck returns a function which reports whether a pack file is
+// still protected from removal by the backend, e.g. by S3 Object Lock.
+func newRetentionCheck(ctx context.Context, be restic.Backend) func(id restic.ID) bool {
+	rbe := backend.AsBackend[restic.RetentionBackend](be)
+	if rbe == nil {
+		return func(restic.ID) bool { return false }
+	}
+
+	now := time.Now()
+	return func(id restic.ID) bool {
+		until, err := rbe.RetainUntil(ctx, restic.Handle{Type: restic.PackFile, Name: id.String()})
+		if err != nil {
+			Warnf("unable to determine retention of pack %v, keeping it: %v\n", id.Str(), err)
+			return true
+		}
+		if until.After(now) {
+			debug.Log("pack %v is retained until %v", id.Str(), until)
+			return true
+		}
+		return false
+	}
+}
+
+// newArchiveCheck returns a function which reports whether a pack is in
+// archive storage and thus cannot be read for repacking.
+func newArchiveCheck(ctx context.Context, be restic.Backend) func(id restic.ID) bool {
+	sbe := backend.AsBackend[restic.StagingBackend](be)
+	if sbe == nil {
+		return func(restic.ID) bool { return false }
+	}
+
+	return func(id restic.ID) bool {
+		archived, err := sbe.Archived(ctx, restic.Handle{Type: restic.PackFile, Name: id.String()})
+		if err != nil {
+			Warnf("unable to determine whether pack %v is archived, keeping it: %v\n", id.Str(), err)
+			return true
+		}
+		if archived {
+			debug.Log("pack %v is archived", id.Str())
+		}
+		return archived
+	}
+}
+
+// extendRetention renews the retention of the files which are still in use,
+// for backends which protect files from removal. Otherwise the retention of
+// the packs which are referenced by new snapshots, but were added by older
+// ones, would expire.
+func extendRetention(ctx context.Context, gopts GlobalOptions, repo restic.Repository, packs restic.IDSet, ignoreSnapshots restic.IDSet) error {
+	rbe := backend.AsBackend[restic.RetentionBackend](repo.Backend())
+	if rbe == nil || !rbe.RetentionEnabled() {
+		return nil
+	}
+
+	handles := make([]restic.Handle, 0, len(packs))
+	for id := range packs {
+		handles = append(handles, restic.Handle{Type: restic.PackFile, Name: id.String()})
+	}
+	for _, t := range []restic.FileType{restic.IndexFile, restic.SnapshotFile} {
+		err := repo.List(ctx, t, func(id restic.ID, size int64) error {
+			if t == restic.SnapshotFile && ignoreSnapshots.Has(id) {
+				return nil
+			}
+			handles = append(handles, restic.Handle{Type: t, Name: id.String()})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	Verbosef("extending the retention of files still in use\n")
+	bar := newProgressMax(!gopts.JSON && !gopts.Quiet, uint64(len(handles)), "files checked")
+	var m sync.Mutex
+	extended, failed := 0, 0
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	ch := make(chan restic.Handle)
+	wg.Go(func() error {
+		defer close(ch)
+		for _, h := range handles {
+			select {
+			case ch <- h:
+			case <-wgCtx.Done():
+				return wgCtx.Err()
+			}
+		}
+		return nil
+	})
+	for i := 0; i < int(repo.Connections()); i++ {
+		wg.Go(func() error {
+			for h := range ch {
+				changed, err := rbe.ExtendRetention(wgCtx, h)
+				m.Lock()
+				if err != nil {
+					Warnf("unable to extend the retention of %v: %v\n", h, err)
+					failed++
+				} else if changed {
+					extended++
+				}
+				m.Unlock()
+				bar.Add(1)
+			}
+			return nil
+		})
+	}
+	err := wg.Wait()
+	bar.Done()
+	if err != nil {
+		return err
+	}
+
+	Verbosef("extended the retention of %d files\n", extended)
+	if failed > 0 {
+		return errors.Fatalf("failed to extend the retention of %d files", failed)
+	}
+	return nil
+}
+
 // printPruneStats prints out the statistics
 func printPruneStats(stats pruneStats) error {
 	Verboseff("\nused:         %10d blobs / %s\n", stats.blobs.used, ui.FormatBytes(stats.size.used))
@@ -689,6 +967,12 @@ func printPruneStats(stats pruneStats) error {
 	Verboseff("unused packs:       %10d\n\n", stats.packs.unused)
 
 	Verboseff("to keep:      %10d packs\n", stats.packs.keep)
+	if stats.packs.retained > 0 {
+		Verbosef("kept %d packs which are still protected from removal by the backend\n", stats.packs.retained)
+	}
+	if stats.packs.archived > 0 {
+		Verbosef("kept %d packs which cannot be repacked as they are in archive storage\n", stats.packs.archived)
+	}
 	Verboseff("to repack:    %10d packs\n", stats.packs.repack)
 	Verboseff("to delete:    %10d packs\n", stats.packs.remove)
 	if stats.packs.unref > 0 {
@@ -706,7 +990,8 @@ func printPruneStats(stats pruneStats) error {
 func doPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions, repo restic.Repository, plan prunePlan) (err error) {
 	if opts.DryRun {
 		if !gopts.JSON && gopts.verbosity >= 2 {
-			Printf("Repeated prune dry-runs can report slightly different amounts of data to keep or repack. This is expected behavior.\n\n")
+			Printf("Repeated prune dry-runs can report slightly different amounts of data to keep or repack. This is expected behavior.\n")
+			Printf("Data of concurrently running backups may be reported as unreferenced.\n\n")
 			if len(plan.removePacksFirst) > 0 {
 				Printf("Would have removed the following unreferenced packs:\n%v\n\n", plan.removePacksFirst)
 			}
@@ -721,19 +1006,32 @@ func doPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions, repo r
 	if len(plan.removePacksFirst) != 0 {
 		Verbosef("deleting unreferenced packs\n")
 		DeleteFiles(ctx, gopts, repo, plan.removePacksFirst, restic.PackFile)
+		deleteParityFiles(ctx, gopts, repo, plan.removePacksFirst)
 	}
 
 	if len(plan.repackPacks) != 0 {
 		Verbosef("repacking packs\n")
 		bar := newProgressMax(!gopts.Quiet, uint64(len(plan.repackPacks)), "packs repacked")
-		_, err := repository.Repack(ctx, repo, repo, plan.repackPacks, plan.keepBlobs, bar)
+		repacked := plan.repackPacks
+		var err error
+		if opts.deadline.IsZero() {
+			_, err = repository.Repack(ctx, repo, repo, plan.repackPacks, plan.keepBlobs, bar)
+		} else {
+			repacked, err = repackUntil(ctx, repo, plan, opts.deadline, bar)
+		}
 		bar.Done()
 		if err != nil {
 			return errors.Fatalf("%s", err)
 		}
 
+		if len(repacked) < len(plan.repackPacks) {
+			Verbosef("stopped repacking to finish within --max-duration, repacked %d of %d packs\n", len(repacked), len(plan.repackPacks))
+			// the remaining packs are kept, repackUntil has checked the repacked ones
+			plan.keepBlobs = nil
+		}
+
 		// Also remove repacked packs
-		plan.removePacks.Merge(plan.repackPacks)
+		plan.removePacks.Merge(repacked)
 
 		if len(plan.keepBlobs) != 0 {
 			Warnf("%v was not repacked\n\n"+
@@ -770,6 +1068,7 @@ func doPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions, repo r
 	if len(plan.removePacks) != 0 {
 		Verbosef("removing %d old packs\n", len(plan.removePacks))
 		DeleteFiles(ctx, gopts, repo, plan.removePacks, restic.PackFile)
+		deleteParityFiles(ctx, gopts, repo, plan.removePacks)
 	}
 
 	if opts.unsafeRecovery {
@@ -779,10 +1078,60 @@ func doPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions, repo r
 		}
 	}
 
+	if len(plan.removePacksFirst) != 0 || len(plan.removePacks) != 0 {
+		recordAudit(ctx, repo, "prune", fmt.Sprintf("removed %d packs, repacked %d packs",
+			len(plan.removePacksFirst)+len(plan.removePacks), len(plan.repackPacks)), nil)
+	}
+
 	Verbosef("done\n")
 	return nil
 }
 
+// pruneRepackBatchSize is the number of packs repacked at once by repackUntil.
+var pruneRepackBatchSize = 32
+
+// repackUntil repacks the packs of plan in the planned order in batches. It
+// stops before a batch which is not expected to finish before the deadline,
+// based on the time needed for the previous batches. The repacked packs are
+// returned.
+func repackUntil(ctx context.Context, repo restic.Repository, plan prunePlan, deadline time.Time, bar *progress.Counter) (restic.IDSet, error) {
+	repacked := restic.NewIDSet()
+	start := time.Now()
+
+	for len(repacked) < len(plan.repackOrder) {
+		end := len(repacked) + pruneRepackBatchSize
+		if end > len(plan.repackOrder) {
+			end = len(plan.repackOrder)
+		}
+		batch := restic.NewIDSet(plan.repackOrder[len(repacked):end]...)
+
+		var expected time.Duration
+		if len(repacked) > 0 {
+			expected = time.Since(start) / time.Duration(len(repacked)) * time.Duration(len(batch))
+		}
+		if time.Now().Add(expected).After(deadline) {
+			debug.Log("stopping repack after %d packs, expected %v for the next batch", len(repacked), expected)
+			break
+		}
+
+		if _, err := repository.Repack(ctx, repo, repo, batch, plan.keepBlobs, bar); err != nil {
+			return nil, err
+		}
+
+		// all needed blobs of the batch must have been saved to new packs
+		for pbs := range repo.Index().ListPacks(ctx, batch) {
+			for _, blob := range pbs.Blobs {
+				if plan.keepBlobs.Has(blob.BlobHandle) {
+					return nil, errors.Errorf("internal error: blob %v of pack %v was not repacked", blob.BlobHandle, pbs.PackID.Str())
+				}
+			}
+		}
+		repacked.Merge(batch)
+	}
+
+	return repacked, ctx.Err()
+}
+
 func writeIndexFiles(ctx context.Context, gopts GlobalOptions, repo restic.Repository, removePacks restic.IDSet, extraObsolete restic.IDs) (restic.IDSet, error) {
 	Verbosef("rebuilding index\n")
 
@@ -802,31 +1151,42 @@ func rebuildIndexFiles(ctx context.Context, gopts GlobalOptions, repo restic.Rep
 	return DeleteFilesChecked(ctx, gopts, repo, obsoleteIndexes, restic.IndexFile)
 }
 
-func getUsedBlobs(ctx context.Context, repo restic.Repository, ignoreSnapshots restic.IDSet, quiet bool) (usedBlobs restic.CountedBlobSet, err error) {
-	var snapshotTrees restic.IDs
+// getUsedBlobs returns the blobs used by the snapshots from snapshotLister which
+// are not listed in ignoreSnapshots. If packFirstUsed is not nil, it is filled with the time of the
+// oldest snapshot using each pack file.
+func getUsedBlobs(ctx context.Context, repo restic.Repository, snapshotLister restic.Lister, ignoreSnapshots restic.IDSet, packFirstUsed map[restic.ID]time.Time, quiet bool) (usedBlobs restic.CountedBlobSet, err error) {
+	var snapshots restic.Snapshots
 	Verbosef("loading all snapshots...\n")
-	err = restic.ForAllSnapshots(ctx, repo.Backend(), repo, ignoreSnapshots,
+	err = restic.ForAllSnapshots(ctx, snapshotLister, repo, ignoreSnapshots,
 		func(id restic.ID, sn *restic.Snapshot, err error) error {
 			if err != nil {
 				debug.Log("failed to load snapshot %v (error %v)", id, err)
 				return err
 			}
 			debug.Log("add snapshot %v (tree %v)", id, *sn.Tree)
-			snapshotTrees = append(snapshotTrees, *sn.Tree)
+			snapshots = append(snapshots, sn)
 			return nil
 		})
 	if err != nil {
 		return nil, errors.Fatalf("failed loading snapshot: %v", err)
 	}
 
-	Verbosef("finding data that is still in use for %d snapshots\n", len(snapshotTrees))
+	Verbosef("finding data that is still in use for %d snapshots\n", len(snapshots))
 
 	usedBlobs = restic.NewCountedBlobSet()
 
-	bar := newProgressMax(!quiet, uint64(len(snapshotTrees)), "snapshots")
+	bar := newProgressMax(!quiet, uint64(len(snapshots)), "snapshots")
 	defer bar.Done()
 
-	err = restic.FindUsedBlobs(ctx, repo, snapshotTrees, usedBlobs, bar)
+	if packFirstUsed == nil {
+		snapshotTrees := make(restic.IDs, 0, len(snapshots))
+		for _, sn := range snapshots {
+			snapshotTrees = append(snapshotTrees, *sn.Tree)
+		}
+		err = restic.FindUsedBlobs(ctx, repo, snapshotTrees, usedBlobs, bar)
+	} else {
+		err = findUsedBlobsByAge(ctx, repo, snapshots, usedBlobs, packFirstUsed, bar)
+	}
 	if err != nil {
 		if repo.Backend().IsNotExist(err) {
 			return nil, errors.Fatal("unable to load a tree from the repository: " + err.Error())
@@ -836,3 +1196,41 @@ func getUsedBlobs(ctx context.Context, repo restic.Repository, ignoreSnapshots r
 	}
 	return usedBlobs, nil
 }
+
+// findUsedBlobsByAge adds the blobs used by snapshots to usedBlobs. The
+// snapshots are processed from oldest to newest, the time of the snapshot which
+// first uses a blob from a pack file is recorded in packFirstUsed.
+func findUsedBlobsByAge(ctx context.Context, repo restic.Repository, snapshots restic.Snapshots, usedBlobs restic.CountedBlobSet, packFirstUsed map[restic.ID]time.Time, bar *progress.Counter) error {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	set := &firstUsedBlobSet{CountedBlobSet: usedBlobs, idx: repo.Index(), packFirstUsed: packFirstUsed}
+	for _, sn := range snapshots {
+		set.time = sn.Time
+		if err := restic.FindUsedBlobs(ctx, repo, restic.IDs{*sn.Tree}, set, bar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstUsedBlobSet records for each pack file the time at which one of its
+// blobs was first inserted into the set.
+type firstUsedBlobSet struct {
+	restic.CountedBlobSet
+	idx           restic.MasterIndex
+	time          time.Time
+	packFirstUsed map[restic.ID]time.Time
+}
+
+func (s *firstUsedBlobSet) Insert(h restic.BlobHandle) {
+	if !s.Has(h) {
+		for _, pb := range s.idx.Lookup(h) {
+			if _, ok := s.packFirstUsed[pb.PackID]; !ok {
+				s.packFirstUsed[pb.PackID] = s.time
+			}
+		}
+	}
+	s.CountedBlobSet.Insert(h)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRepackBefore(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	pack := func(name string, used, unused uint64, days int) packInfoWithID {
+		return packInfoWithID{
+			ID:        restic.Hash([]byte(name)),
+			packInfo:  packInfo{usedSize: used, unusedSize: unused},
+			firstUsed: now.AddDate(0, 0, -days),
+		}
+	}
+
+	// efficiency: a 50%, b 25%, c 75% unused data
+	a := pack("a", 500, 500, 100)
+	b := pack("b", 750, 250, 400)
+	c := pack("c", 250, 750, 1)
+
+	for _, test := range []struct {
+		strategy string
+		order    []packInfoWithID
+	}{
+		{repackStrategyEfficiency, []packInfoWithID{c, a, b}},
+		{repackStrategyAge, []packInfoWithID{b, a, c}},
+		{repackStrategyBalanced, []packInfoWithID{b, a, c}},
+	} {
+		t.Run(test.strategy, func(t *testing.T) {
+			list := []packInfoWithID{a, b, c}
+			sort.SliceStable(list, func(i, j int) bool {
+				return repackBefore(test.strategy, list[i], list[j], now)
+			})
+			for i := range list {
+				rtest.Equals(t, test.order[i].ID, list[i].ID)
+			}
+		})
+	}
+}
+
+func TestVerifyPruneOptionsRepackStrategy(t *testing.T) {
+	opts := PruneOptions{MaxUnused: "5%"}
+	rtest.OK(t, verifyPruneOptions(&opts))
+	rtest.Equals(t, repackStrategyEfficiency, opts.RepackStrategy)
+
+	opts = PruneOptions{MaxUnused: "5%", RepackStrategy: "random"}
+	err := verifyPruneOptions(&opts)
+	rtest.Assert(t, err != nil, "expected error for invalid strategy")
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/index"
 	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/repository"
@@ -18,6 +19,11 @@ var cmdRepairIndex = &cobra.Command{
 The "repair index" command creates a new index based on the pack files in the
 repository.
 
+With --compact, the command only merges small index files into larger ones,
+without checking the pack files. Repositories with a large number of small
+index files, for example after many small backups, are slow to open on
+backends with a high latency.
+
 EXIT STATUS
 ===========
 
@@ -41,6 +47,7 @@ var cmdRebuildIndex = &cobra.Command{
 // RepairIndexOptions collects all options for the repair index command.
 type RepairIndexOptions struct {
 	ReadAllPacks bool
+	Compact      bool
 }
 
 var repairIndexOptions RepairIndexOptions
@@ -52,10 +59,15 @@ func init() {
 
 	for _, f := range []*pflag.FlagSet{cmdRepairIndex.Flags(), cmdRebuildIndex.Flags()} {
 		f.BoolVar(&repairIndexOptions.ReadAllPacks, "read-all-packs", false, "read all pack files to generate new index from scratch")
+		f.BoolVar(&repairIndexOptions.Compact, "compact", false, "only merge small index files into larger ones")
 	}
 }
 
 func runRebuildIndex(ctx context.Context, opts RepairIndexOptions, gopts GlobalOptions) error {
+	if opts.Compact && opts.ReadAllPacks {
+		return errors.Fatal("--compact and --read-all-packs cannot be specified at the same time")
+	}
+
 	repo, err := OpenRepository(ctx, gopts)
 	if err != nil {
 		return err
@@ -67,9 +79,40 @@ func runRebuildIndex(ctx context.Context, opts RepairIndexOptions, gopts GlobalO
 		return err
 	}
 
+	if opts.Compact {
+		Verbosef("loading indexes...\n")
+		if err = repo.LoadIndex(ctx); err != nil {
+			return err
+		}
+		if len(repo.SmallIndexes()) < 2 {
+			Verbosef("no index files to compact\n")
+			return nil
+		}
+		return compactIndexFiles(ctx, gopts, repo, repo.SmallIndexes())
+	}
+
 	return rebuildIndex(ctx, opts, gopts, repo, restic.NewIDSet())
 }
 
+// compactIndexFiles merges the given index files into larger ones and removes
+// them afterwards.
+func compactIndexFiles(ctx context.Context, gopts GlobalOptions, repo *repository.Repository, ids restic.IDs) error {
+	if !gopts.JSON {
+		Verbosef("compacting %d small index files\n", len(ids))
+	}
+	bar := newProgressMax(!gopts.Quiet && !gopts.JSON, 0, "packs processed")
+	obsoleteIndexes, err := repo.CompactIndexes(ctx, ids, bar)
+	bar.Done()
+	if err != nil {
+		return err
+	}
+
+	if !gopts.JSON {
+		Verbosef("deleting obsolete index files\n")
+	}
+	return DeleteFilesChecked(ctx, gopts, repo, obsoleteIndexes, restic.IndexFile)
+}
+
 func rebuildIndex(ctx context.Context, opts RepairIndexOptions, gopts GlobalOptions, repo *repository.Repository, ignorePacks restic.IDSet) error {
 	var obsoleteIndexes restic.IDs
 	packSizeFromList := make(map[restic.ID]int64)
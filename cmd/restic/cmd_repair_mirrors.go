@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairMirrors = &cobra.Command{
+	Use:   "mirrors [flags]",
+	Short: "Resynchronize the mirrors of a repository",
+	Long: `
+The "repair mirrors" command copies files which are missing in one of the
+mirrors of a "mirror:" repository, for example after a mirror was unavailable
+during a backup, from the other mirrors. The content of each file is verified
+before it is copied. Files whose size differs between the mirrors are replaced
+by an intact copy.
+
+All mirrors must be available to run the command.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairMirrors(cmd.Context(), globalOptions, repairMirrorsOptions)
+	},
+}
+
+// RepairMirrorsOptions collects all options for the repair mirrors command.
+type RepairMirrorsOptions struct {
+	DryRun bool
+}
+
+var repairMirrorsOptions RepairMirrorsOptions
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairMirrors)
+	flags := cmdRepairMirrors.Flags()
+
+	flags.BoolVarP(&repairMirrorsOptions.DryRun, "dry-run", "n", false, "do not do anything, just print what would be done")
+}
+
+func runRepairMirrors(ctx context.Context, gopts GlobalOptions, opts RepairMirrorsOptions) error {
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	be := backend.AsBackend[*mirror.Backend](repo.Backend())
+	if be == nil {
+		return errors.Fatal("the repository is not mirrored, use a \"mirror:\" location")
+	}
+
+	if !opts.DryRun {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	copied, unrepairable := 0, 0
+	err = be.Repair(ctx, opts.DryRun, func(h restic.Handle, dst string, err error) {
+		if err != nil {
+			Warnf("unable to repair %v/%v: %v\n", h.Type, h.Name, err)
+			unrepairable++
+			return
+		}
+		if opts.DryRun {
+			Verbosef("would copy %v/%v to %v\n", h.Type, h.Name, dst)
+		} else {
+			Verbosef("copied %v/%v to %v\n", h.Type, h.Name, dst)
+		}
+		copied++
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		Printf("would copy %d files\n", copied)
+	} else {
+		Printf("copied %d files\n", copied)
+	}
+	if unrepairable > 0 {
+		return errors.Fatalf("%d files could not be repaired", unrepairable)
+	}
+	return nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/chunker"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdRepairPacks = &cobra.Command{
+	Use:   "packs [flags] packID [...]",
+	Short: "Repair damaged pack files",
+	Long: `
+The "repair packs" command rewrites the given pack files, for example those
+reported as damaged by "check --read-data". If the repository stores parity
+files, see "restic parity", damaged pack files are first restored using them.
+For the remaining pack files all blobs are verified. Damaged blobs are
+replaced by an intact copy, taken from another pack file of the repository,
+from the repository given by --from-repo, for example a mirror created using
+"copy", or from the files in the directories given by --from-source. The
+latter only works for file contents, which must not have been modified since
+the backup.
+
+Pack files containing blobs for which no intact copy was found are left
+unchanged. Afterwards, "repair snapshots --forget" can be used to remove the
+damaged files from the snapshots.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairPacks(cmd.Context(), globalOptions, repairPacksOptions, args)
+	},
+}
+
+// RepairPacksOptions collects all options for the repair packs command.
+type RepairPacksOptions struct {
+	secondaryRepoOptions
+	FromSource []string
+	DryRun     bool
+}
+
+var repairPacksOptions RepairPacksOptions
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairPacks)
+	f := cmdRepairPacks.Flags()
+
+	initSecondaryRepoOptions(f, &repairPacksOptions.secondaryRepoOptions, "source", "to copy intact blobs from")
+	f.StringArrayVar(&repairPacksOptions.FromSource, "from-source", nil, "read intact file contents from the files in `directory` (can be specified multiple times)")
+	f.BoolVarP(&repairPacksOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+}
+
+func runRepairPacks(ctx context.Context, gopts GlobalOptions, opts RepairPacksOptions, args []string) error {
+	if len(args) == 0 {
+		return errors.Fatal("no pack files to repair specified")
+	}
+	ids := restic.NewIDSet()
+	for _, arg := range args {
+		id, err := restic.ParseID(arg)
+		if err != nil {
+			return errors.Fatalf("invalid pack ID %q: %v", arg, err)
+		}
+		ids.Insert(id)
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !opts.DryRun {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the index is rewritten completely after repairing the packs
+	repo.DisableAutoIndexUpdate()
+
+	Verbosef("loading indexes...\n")
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	var srcRepo restic.Repository
+	if opts.secondaryRepoOptions.Repo != "" || opts.secondaryRepoOptions.RepositoryFile != "" {
+		srcGopts, _, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "source")
+		if err != nil {
+			return err
+		}
+		r, err := OpenRepository(ctx, srcGopts)
+		if err != nil {
+			return err
+		}
+		if !gopts.NoLock {
+			var srcLock *restic.Lock
+			srcLock, ctx, err = lockRepo(ctx, r, gopts.RetryLock, gopts.JSON)
+			defer unlockRepo(srcLock)
+			if err != nil {
+				return err
+			}
+		}
+		Verbosef("loading indexes of the source repository...\n")
+		if err = r.LoadIndex(ctx); err != nil {
+			return err
+		}
+		srcRepo = r
+	}
+
+	var packs []restic.PackBlobs
+	for pbs := range repo.Index().ListPacks(ctx, ids) {
+		packs = append(packs, pbs)
+		ids.Delete(pbs.PackID)
+	}
+	if len(ids) > 0 {
+		return errors.Fatalf("pack files %v are not contained in the index, run 'restic repair index' first", ids)
+	}
+
+	if repo.Config().Parity != nil {
+		packs, err = restorePacksFromParity(ctx, repo, packs, opts.DryRun)
+		if err != nil {
+			return err
+		}
+		if len(packs) == 0 {
+			Verbosef("done\n")
+			return nil
+		}
+	}
+
+	Verbosef("verifying %d pack files\n", len(packs))
+	damaged, err := findDamagedBlobs(ctx, repo, packs, gopts.Quiet)
+	if err != nil {
+		return err
+	}
+	if len(damaged) == 0 {
+		Verbosef("no damaged blobs found\n")
+		return nil
+	}
+	Verbosef("found %d damaged blobs, searching intact copies\n", len(damaged))
+
+	recovered, err := recoverBlobs(ctx, repo, srcRepo, opts.FromSource, damaged)
+	if err != nil {
+		return err
+	}
+
+	var repairable []restic.PackBlobs
+	for _, pbs := range packs {
+		missing := 0
+		for _, blob := range pbs.Blobs {
+			if damaged.Has(blob.BlobHandle) && recovered[blob.BlobHandle] == nil {
+				missing++
+			}
+		}
+		if missing > 0 {
+			Warnf("pack %v: no intact copy found for %d blobs, leaving the pack file unchanged\n", pbs.PackID.Str(), missing)
+			continue
+		}
+		repairable = append(repairable, pbs)
+	}
+
+	if opts.DryRun {
+		for _, pbs := range repairable {
+			Verbosef("would repair pack %v\n", pbs.PackID.Str())
+		}
+		return nil
+	}
+
+	if len(repairable) > 0 {
+		if err := rewriteRepairedPacks(ctx, gopts, repo, repairable, recovered); err != nil {
+			return err
+		}
+	}
+
+	if len(repairable) < len(packs) {
+		return errors.Fatalf("%d of %d pack files could not be repaired", len(packs)-len(repairable), len(packs))
+	}
+	Verbosef("done\n")
+	return nil
+}
+
+// restorePacksFromParity restores the damaged pack files using their parity
+// files. It returns the pack files which could not be restored.
+func restorePacksFromParity(ctx context.Context, repo *repository.Repository, packs []restic.PackBlobs, dryRun bool) ([]restic.PackBlobs, error) {
+	var remaining []restic.PackBlobs
+	for _, pbs := range packs {
+		buf, restored, err := repo.ReconstructPack(ctx, pbs.PackID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			Warnf("%v\n", err)
+		}
+		if !restored {
+			remaining = append(remaining, pbs)
+			continue
+		}
+
+		if dryRun {
+			Verbosef("would restore pack %v using its parity file\n", pbs.PackID.Str())
+			continue
+		}
+		if err := repo.ReplacePack(ctx, pbs.PackID, pbs.Blobs[0].Type, buf); err != nil {
+			return nil, err
+		}
+		Verbosef("restored pack %v using its parity file\n", pbs.PackID.Str())
+	}
+	return remaining, nil
+}
+
+// findDamagedBlobs reads all blobs of the pack files and returns those which
+// cannot be decrypted or are damaged.
+func findDamagedBlobs(ctx context.Context, repo restic.Repository, packs []restic.PackBlobs, quiet bool) (restic.BlobSet, error) {
+	filters, err := repository.LookupBlobFilters(repo.Config().Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	damaged := restic.NewBlobSet()
+	bar := newProgressMax(!quiet, uint64(len(packs)), "packs verified")
+	defer bar.Done()
+	for _, pbs := range packs {
+		intact := restic.NewBlobSet()
+		err := repository.StreamPack(ctx, repo.Backend().Load, repo.Key(), filters, repo.Config().HashBlob, pbs.PackID, pbs.Blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+			if err != nil {
+				debug.Log("blob %v of pack %v damaged: %v", blob, pbs.PackID, err)
+				return nil
+			}
+			intact.Insert(blob)
+			return nil
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// the pack file could not be read, the blobs read so far are intact
+			Warnf("pack %v: %v\n", pbs.PackID.Str(), err)
+		}
+		for _, blob := range pbs.Blobs {
+			if !intact.Has(blob.BlobHandle) {
+				damaged.Insert(blob.BlobHandle)
+			}
+		}
+		bar.Add(1)
+	}
+	return damaged, nil
+}
+
+// recoverBlobs searches intact copies of the damaged blobs in the other pack
+// files of repo, in srcRepo and in the files within the source directories.
+func recoverBlobs(ctx context.Context, repo restic.Repository, srcRepo restic.Repository, sources []string, damaged restic.BlobSet) (map[restic.BlobHandle][]byte, error) {
+	recovered := make(map[restic.BlobHandle][]byte)
+	for h := range damaged {
+		// LoadBlob tries all copies of the blob and verifies the content
+		buf, err := repo.LoadBlob(ctx, h.Type, h.ID, nil)
+		if err == nil {
+			Verboseff("blob %v: found intact copy in the repository\n", h)
+			recovered[h] = buf
+			continue
+		}
+		if srcRepo == nil || !srcRepo.Index().Has(h) {
+			continue
+		}
+		buf, err = srcRepo.LoadBlob(ctx, h.Type, h.ID, nil)
+		if err != nil {
+			Warnf("blob %v: unable to load the copy from the source repository: %v\n", h, err)
+			continue
+		}
+		Verboseff("blob %v: found intact copy in the source repository\n", h)
+		recovered[h] = buf
+	}
+
+	missing := restic.NewIDSet()
+	for h := range damaged {
+		if h.Type == restic.DataBlob && recovered[h] == nil {
+			missing.Insert(h.ID)
+		}
+	}
+	for _, dir := range sources {
+		if len(missing) == 0 {
+			break
+		}
+		Verbosef("searching %d blobs in %v\n", len(missing), dir)
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				Warnf("%v\n", err)
+				return nil
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			return chunkSourceFile(ctx, path, repo.Config(), missing, func(id restic.ID, buf []byte) {
+				Verboseff("blob %v: found intact copy in %v\n", id.Str(), path)
+				recovered[restic.BlobHandle{ID: id, Type: restic.DataBlob}] = buf
+				missing.Delete(id)
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return recovered, nil
+}
+
+// chunkSourceFile splits the file into chunks as the backup command would and
+// passes the chunks whose ID is contained in wanted to fn. The chunker
+// parameters and blob hash are taken from cfg.
+func chunkSourceFile(ctx context.Context, path string, cfg restic.Config, wanted restic.IDSet, fn func(id restic.ID, buf []byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		Warnf("%v\n", err)
+		return nil
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	chnker := chunker.New(f, cfg.ChunkerPolynomial)
+	buf := make([]byte, chunker.MaxSize)
+	for len(wanted) > 0 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		chunk, err := chnker.Next(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			Warnf("%v: %v\n", path, err)
+			return nil
+		}
+		id := cfg.HashBlob(chunk.Data)
+		if wanted.Has(id) {
+			fn(id, append([]byte(nil), chunk.Data...))
+		}
+	}
+	return nil
+}
+
+// rewriteRepairedPacks saves the intact blobs of the pack files together with
+// the recovered blobs to new pack files and removes the old pack files.
+func rewriteRepairedPacks(ctx context.Context, gopts GlobalOptions, repo restic.Repository, packs []restic.PackBlobs, recovered map[restic.BlobHandle][]byte) error {
+	filters, err := repository.LookupBlobFilters(repo.Config().Filters)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("rewriting %d pack files\n", len(packs))
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packs)), "packs repaired")
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+	wg.Go(func() error {
+		for _, pbs := range packs {
+			saved := restic.NewBlobSet()
+			err := repository.StreamPack(wgCtx, repo.Backend().Load, repo.Key(), filters, repo.Config().HashBlob, pbs.PackID, pbs.Blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+				if err != nil {
+					// replaced by the recovered copy below
+					return nil
+				}
+				saved.Insert(blob)
+				_, _, _, err = repo.SaveBlob(wgCtx, blob.Type, buf, blob.ID, true)
+				return err
+			})
+			if err != nil && wgCtx.Err() != nil {
+				return wgCtx.Err()
+			}
+			for _, blob := range pbs.Blobs {
+				if saved.Has(blob.BlobHandle) {
+					continue
+				}
+				buf := recovered[blob.BlobHandle]
+				if buf == nil {
+					return errors.Errorf("internal error: no intact copy of blob %v", blob.BlobHandle)
+				}
+				if _, _, _, err := repo.SaveBlob(wgCtx, blob.Type, buf, blob.ID, true); err != nil {
+					return err
+				}
+				saved.Insert(blob.BlobHandle)
+			}
+			bar.Add(1)
+		}
+		return repo.Flush(wgCtx)
+	})
+	err = wg.Wait()
+	bar.Done()
+	if err != nil {
+		return err
+	}
+
+	removePacks := restic.NewIDSet()
+	for _, pbs := range packs {
+		removePacks.Insert(pbs.PackID)
+	}
+	if err := rebuildIndexFiles(ctx, gopts, repo, removePacks, nil); err != nil {
+		return err
+	}
+
+	Verbosef("removing damaged pack files\n")
+	if err := DeleteFilesChecked(ctx, gopts, repo, removePacks, restic.PackFile); err != nil {
+		return err
+	}
+	deleteParityFiles(ctx, gopts, repo, removePacks)
+	return nil
+}
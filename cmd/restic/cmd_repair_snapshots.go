@@ -52,6 +52,7 @@ type RepairOptions struct {
 	Forget bool
 
 	restic.SnapshotFilter
+	signingKeyOptions
 }
 
 var repairSnapshotOptions RepairOptions
@@ -64,9 +65,15 @@ func init() {
 	flags.BoolVarP(&repairSnapshotOptions.Forget, "forget", "", false, "remove original snapshots after creating new ones")
 
 	initMultiSnapshotFilter(flags, &repairSnapshotOptions.SnapshotFilter, true)
+	initSigningKeyOptions(flags, &repairSnapshotOptions.signingKeyOptions)
 }
 
 func runRepairSnapshots(ctx context.Context, gopts GlobalOptions, opts RepairOptions, args []string) error {
+	signingKey, err := opts.signingKeyOptions.load()
+	if err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(ctx, globalOptions)
 	if err != nil {
 		return err
@@ -115,6 +122,24 @@ func runRepairSnapshots(ctx context.Context, gopts GlobalOptions, opts RepairOpt
 					newSize += uint64(size)
 				}
 			}
+			if node.Bundle != nil {
+				if ok {
+					// node.Size is the size of the file within the bundle
+					return node
+				}
+				node.Bundle = nil
+			}
+			if node.ContentEncoding != nil {
+				if ok {
+					// node.Size is the size of the original file, not of the content
+					node.ContentEncoding.Size = newSize
+					return node
+				}
+				// without the complete content, the original file cannot be
+				// reconstructed anymore, keep the decoded content instead
+				node.ContentEncoding = nil
+			}
+
 			if !ok {
 				Verbosef("  file %q: removed missing content\n", path)
 			} else if newSize != node.Size {
@@ -142,21 +167,30 @@ func runRepairSnapshots(ctx context.Context, gopts GlobalOptions, opts RepairOpt
 		AllowUnstableSerialization: true,
 	})
 
-	changedCount := 0
+	var changedIDs restic.IDs
 	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, args) {
 		Verbosef("\nsnapshot %s of %v at %s)\n", sn.ID().Str(), sn.Paths, sn.Time)
 		changed, err := filterAndReplaceSnapshot(ctx, repo, sn,
 			func(ctx context.Context, sn *restic.Snapshot) (restic.ID, error) {
 				return rewriter.RewriteTree(ctx, repo, "/", *sn.Tree)
-			}, opts.DryRun, opts.Forget, "repaired")
+			}, opts.DryRun, opts.Forget, nil, "repaired", signingKey)
 		if err != nil {
 			return errors.Fatalf("unable to rewrite snapshot ID %q: %v", sn.ID().Str(), err)
 		}
 		if changed {
-			changedCount++
+			changedIDs = append(changedIDs, *sn.ID())
+		}
+	}
+
+	if len(changedIDs) > 0 && !opts.DryRun {
+		details := "kept original snapshots"
+		if opts.Forget {
+			details = "removed original snapshots"
 		}
+		recordAudit(ctx, repo, "repair snapshots", details, changedIDs)
 	}
 
+	changedCount := len(changedIDs)
 	Verbosef("\n")
 	if changedCount == 0 {
 		if !opts.DryRun {
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdReplicate = &cobra.Command{
+	Use:   "replicate [flags]",
+	Short: "Continuously copy new snapshots to another repository",
+	Long: `
+The "replicate" command watches the source repository for new snapshots and
+copies them to the destination repository, in the same way as the "copy"
+command. Snapshots which already exist in the destination repository are
+skipped, so replication is resumed after an interruption.
+
+The source repository is checked for new snapshots every "--poll" interval
+until the command is interrupted. Both repositories are only locked while
+snapshots are copied, so that e.g. "forget" and "prune" can run in between.
+Errors of a single round are reported and the snapshots are copied again in
+the next round. With "--poll 0" the snapshots are copied once.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runReplicate(cmd.Context(), replicateOptions, globalOptions, args)
+	},
+}
+
+// ReplicateOptions bundles all options for the replicate command.
+type ReplicateOptions struct {
+	secondaryRepoOptions
+	restic.SnapshotFilter
+	Poll time.Duration
+}
+
+var replicateOptions ReplicateOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdReplicate)
+
+	f := cmdReplicate.Flags()
+	initSecondaryRepoOptions(f, &replicateOptions.secondaryRepoOptions, "destination", "to copy snapshots from")
+	initMultiSnapshotFilter(f, &replicateOptions.SnapshotFilter, true)
+	f.DurationVar(&replicateOptions.Poll, "poll", 5*time.Minute, "check the source repository for new snapshots every `duration` (0: copy once and exit)")
+}
+
+func runReplicate(ctx context.Context, opts ReplicateOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the replicate command takes no arguments, use the snapshot filter options instead")
+	}
+	if opts.Poll < 0 {
+		return errors.Fatal("--poll must not be negative")
+	}
+
+	secondaryGopts, isFromRepo, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "destination")
+	if err != nil {
+		return err
+	}
+	if isFromRepo {
+		// swap global options, if the secondary repo was set via from-repo
+		gopts, secondaryGopts = secondaryGopts, gopts
+	}
+
+	srcRepo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	dstRepo, err := OpenRepository(ctx, secondaryGopts)
+	if err != nil {
+		return err
+	}
+
+	for {
+		copied, err := replicateSnapshots(ctx, srcRepo, dstRepo, &opts.SnapshotFilter, gopts)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if opts.Poll == 0 {
+			return err
+		}
+		if err != nil {
+			Warnf("replication failed, retrying in %v: %v\n", opts.Poll, err)
+		} else {
+			Verbosef("copied %d snapshots, next check at %s\n", copied, time.Now().Add(opts.Poll).Format(TimeFormat))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.Poll):
+		}
+	}
+}
+
+// replicateSnapshots locks both repositories and copies all new snapshots.
+func replicateSnapshots(ctx context.Context, srcRepo, dstRepo restic.Repository, filter *restic.SnapshotFilter, gopts GlobalOptions) (int, error) {
+	if !gopts.NoLock {
+		srcLock, srcCtx, err := lockRepo(ctx, srcRepo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(srcLock)
+		if err != nil {
+			return 0, err
+		}
+		ctx = srcCtx
+	}
+
+	dstLock, ctx, err := lockRepo(ctx, dstRepo, gopts.RetryLock, gopts.JSON)
+	defer unlockRepo(dstLock)
+	if err != nil {
+		return 0, err
+	}
+
+	// the repositories may have changed since the last round
+	for _, repo := range []restic.Repository{srcRepo, dstRepo} {
+		if err := repo.SetIndex(index.NewMasterIndex()); err != nil {
+			return 0, err
+		}
+	}
+	return copySnapshots(ctx, srcRepo, dstRepo, filter, nil, false, gopts.Quiet)
+}
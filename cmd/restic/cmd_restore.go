@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,7 +30,19 @@ The "restore" command extracts the data from a snapshot from the repository to
 a directory.
 
 The special snapshot "latest" can be used to restore the latest snapshot in the
-repository.
+repository. "latest@time" and "host:name@time" select the newest snapshot
+created at or before the given time, for example "latest@2023-06-01".
+
+If the data is stored in archive storage like S3 Glacier or the Azure archive
+tier, the --stage option first requests the retrieval of all pack files that
+are needed for the restore and waits until they can be read.
+
+On Linux, --target-share mounts an SMB or NFS share for the duration of the
+restore and restores the snapshot to the selected directory within the share.
+
+The --interactive option opens a full-screen browser for the snapshot in the
+terminal, in which the files and directories to restore can be marked using
+the arrow keys and space.
 
 EXIT STATUS
 ===========
@@ -71,8 +87,32 @@ type RestoreOptions struct {
 	InsensitiveInclude []string
 	Target             string
 	restic.SnapshotFilter
+
+	TargetShare             string
+	TargetShareOptions      string
+	TargetSharePasswordFile string
+
 	Sparse bool
 	Verify bool
+
+	Stage         bool
+	StageInterval time.Duration
+
+	TargetFS         string
+	WriteConcurrency int
+	Fsync            string
+
+	ReuseExisting bool
+	ReferenceDirs []string
+
+	Delete       bool
+	MetadataOnly bool
+
+	MapUser  []string
+	MapGroup []string
+	Chown    string
+
+	Interactive bool
 }
 
 var restoreOptions RestoreOptions
@@ -86,10 +126,26 @@ func init() {
 	flags.StringArrayVarP(&restoreOptions.Include, "include", "i", nil, "include a `pattern`, exclude everything else (can be specified multiple times)")
 	flags.StringArrayVar(&restoreOptions.InsensitiveInclude, "iinclude", nil, "same as `--include` but ignores the casing of filenames")
 	flags.StringVarP(&restoreOptions.Target, "target", "t", "", "directory to extract data to")
+	flags.StringVar(&restoreOptions.TargetShare, "target-share", "", "mount the network share at `location` (smb://[user@]host/share[/path] or nfs://host/export) and restore to it")
+	flags.StringVar(&restoreOptions.TargetShareOptions, "target-share-options", "", "additional `options` passed to mount for --target-share")
+	flags.StringVar(&restoreOptions.TargetSharePasswordFile, "target-share-password-file", "", "`file` to read the password for --target-share from (default: $RESTIC_SHARE_PASSWORD)")
 
 	initSingleSnapshotFilter(flags, &restoreOptions.SnapshotFilter)
 	flags.BoolVar(&restoreOptions.Sparse, "sparse", false, "restore files as sparse")
 	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
+	flags.BoolVar(&restoreOptions.Stage, "stage", false, "retrieve the required data from archive storage before restoring")
+	flags.DurationVar(&restoreOptions.StageInterval, "stage-interval", 10*time.Minute, "check whether staged data is available every `duration`")
+	flags.StringVar(&restoreOptions.TargetFS, "target-fs", "auto", "optimize writing for the filesystem `type` of the target (auto, ssd, hdd, nfs, smb)")
+	flags.IntVar(&restoreOptions.WriteConcurrency, "write-concurrency", 0, "write `n` files concurrently (default: depends on the target filesystem)")
+	flags.StringVar(&restoreOptions.Fsync, "fsync", "auto", "sync restored files to disk: `mode` auto, file (after each file) or none")
+	flags.BoolVar(&restoreOptions.ReuseExisting, "reuse-existing", false, "keep data of files already present in the target instead of downloading it again")
+	flags.BoolVar(&restoreOptions.Delete, "delete", false, "delete files from the target which are not contained in the snapshot")
+	flags.BoolVar(&restoreOptions.MetadataOnly, "metadata-only", false, "only restore the metadata of files which already exist in the target, but not their contents")
+	flags.StringArrayVar(&restoreOptions.MapUser, "map-user", nil, "restore items owned by user `old:new` with the owner new, both can be names or numeric IDs (can be specified multiple times)")
+	flags.StringArrayVar(&restoreOptions.MapGroup, "map-group", nil, "restore items of group `old:new` with the group new, both can be names or numeric IDs (can be specified multiple times)")
+	flags.StringVar(&restoreOptions.Chown, "chown", "", "restore all items with the owner and group `user:group`, either part can be omitted")
+	flags.StringArrayVar(&restoreOptions.ReferenceDirs, "reference-dir", nil, "copy matching file data from the same location below `dir` instead of downloading it (can be specified multiple times)")
+	flags.BoolVar(&restoreOptions.Interactive, "interactive", false, "select the files to restore in a full-screen browser for the snapshot")
 }
 
 func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
@@ -120,6 +176,12 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 		}
 	}
 
+	if gopts.IgnoreCase {
+		opts.InsensitiveExclude = append(append([]string{}, opts.InsensitiveExclude...), opts.Exclude...)
+		opts.InsensitiveInclude = append(append([]string{}, opts.InsensitiveInclude...), opts.Include...)
+		opts.Exclude, opts.Include = nil, nil
+	}
+
 	for i, str := range opts.InsensitiveExclude {
 		opts.InsensitiveExclude[i] = strings.ToLower(str)
 	}
@@ -135,14 +197,83 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 		return errors.Fatalf("more than one snapshot ID specified: %v", args)
 	}
 
-	if opts.Target == "" {
+	if opts.Target != "" && opts.TargetShare != "" {
+		return errors.Fatal("--target and --target-share cannot be used together")
+	}
+	if opts.Target == "" && opts.TargetShare == "" {
 		return errors.Fatal("please specify a directory to restore to (--target)")
 	}
+	if opts.TargetShare == "" && (opts.TargetShareOptions != "" || opts.TargetSharePasswordFile != "") {
+		return errors.Fatal("--target-share-options and --target-share-password-file require --target-share")
+	}
 
 	if hasExcludes && hasIncludes {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	if opts.Interactive {
+		if hasExcludes || hasIncludes {
+			return errors.Fatal("--interactive cannot be combined with include or exclude patterns")
+		}
+		if !stdinIsTerminal() || !stdoutIsTerminal() || gopts.JSON {
+			return errors.Fatal("--interactive requires a terminal")
+		}
+	}
+
+	targetFS, err := restorer.ParseTargetFS(opts.TargetFS)
+	if err != nil {
+		return errors.Fatalf("--target-fs: %v", err)
+	}
+	if opts.WriteConcurrency < 0 {
+		return errors.Fatal("--write-concurrency must not be negative")
+	}
+	switch opts.Fsync {
+	case "", "auto", "file", "none":
+	default:
+		return errors.Fatalf("--fsync: invalid mode %q, must be one of auto, file or none", opts.Fsync)
+	}
+
+	for i, dir := range opts.ReferenceDirs {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return errors.Fatalf("--reference-dir: %v", err)
+		}
+		if !fi.IsDir() {
+			return errors.Fatalf("--reference-dir: %v is not a directory", dir)
+		}
+		opts.ReferenceDirs[i], err = filepath.Abs(dir)
+		if err != nil {
+			return errors.Fatalf("--reference-dir: %v", err)
+		}
+	}
+
+	if opts.MetadataOnly && (opts.Delete || opts.Stage || opts.ReuseExisting || len(opts.ReferenceDirs) > 0) {
+		return errors.Fatal("--metadata-only cannot be combined with --delete, --stage, --reuse-existing or --reference-dir")
+	}
+
+	owners, err := parseOwnerMap(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.TargetShare != "" {
+		share, target, unmount, err := mountShare("--target-share", opts.TargetShare, opts.TargetShareOptions, opts.TargetSharePasswordFile, true)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := unmount(); err != nil {
+				Warnf("%v\n", err)
+			}
+		}()
+
+		opts.Target = target
+		if targetFS == restorer.TargetUnknown {
+			// the type of the share is known, no need to detect it
+			targetFS = restorer.TargetFS(share.Scheme)
+		}
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -175,12 +306,40 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 		return err
 	}
 
+	var browser *restoreBrowser
+	if opts.Interactive {
+		// the screen must not be buffered by the line-based stdio wrapper
+		browser = newRestoreBrowser(repo, *sn.Tree, os.Stdout)
+		restore, err := browser.runTerminal(ctx)
+		if err != nil {
+			return err
+		}
+		if !restore {
+			Verbosef("nothing restored\n")
+			return nil
+		}
+	}
+
 	var progress *restoreui.Progress
 	if !globalOptions.Quiet && !globalOptions.JSON {
 		progress = restoreui.NewProgress(restoreui.NewProgressPrinter(term), calculateProgressInterval(!gopts.Quiet, gopts.JSON))
 	}
 
 	res := restorer.NewRestorer(ctx, repo, sn, opts.Sparse, progress)
+	res.WriteStrategy = restoreWriteStrategy(opts, targetFS)
+	res.ReuseTarget = opts.ReuseExisting
+	res.ReferenceDirs = opts.ReferenceDirs
+	res.Owners = owners
+	res.MetadataOnly = opts.MetadataOnly
+
+	var removedItems int
+	if opts.Delete {
+		res.Delete = true
+		res.ItemRemoved = func(location string) {
+			Verboseff("removed %v\n", location)
+			removedItems++
+		}
+	}
 
 	totalErrors := 0
 	res.Error = func(location string, err error) error {
@@ -235,9 +394,22 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 		res.SelectFilter = selectExcludeFilter
 	} else if hasIncludes {
 		res.SelectFilter = selectIncludeFilter
+	} else if browser != nil {
+		res.SelectFilter = browser.selectFilter
 	}
 
-	Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	if opts.Stage {
+		err = stageRestore(ctx, repo, res, opts.StageInterval)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.MetadataOnly {
+		Verbosef("restoring metadata of %s to %s\n", res.Snapshot(), opts.Target)
+	} else {
+		Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	}
 
 	err = res.RestoreTo(ctx, opts.Target)
 	if err != nil {
@@ -248,6 +420,13 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 		progress.Finish()
 	}
 
+	if removedItems > 0 {
+		Verbosef("removed %d files and directories not contained in the snapshot\n", removedItems)
+	}
+	if res.ReusedBytes() > 0 {
+		Verbosef("reused %s of local data\n", ui.FormatBytes(res.ReusedBytes()))
+	}
+
 	if totalErrors > 0 {
 		return errors.Fatalf("There were %d errors\n", totalErrors)
 	}
@@ -269,3 +448,138 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 
 	return nil
 }
+
+// parseOwnerMap returns the owner mapping configured by --map-user,
+// --map-group and --chown, or nil if none of them is used.
+func parseOwnerMap(opts RestoreOptions) (*restorer.OwnerMap, error) {
+	if len(opts.MapUser) == 0 && len(opts.MapGroup) == 0 && opts.Chown == "" {
+		return nil, nil
+	}
+
+	owners := &restorer.OwnerMap{}
+	for _, mapping := range opts.MapUser {
+		old, name, ok := strings.Cut(mapping, ":")
+		if !ok || old == "" || name == "" {
+			return nil, errors.Fatalf("--map-user: invalid mapping %q, expected old:new", mapping)
+		}
+		uid, err := lookupUID(name)
+		if err != nil {
+			return nil, errors.Fatalf("--map-user: %v", err)
+		}
+		owners.MapUser(old, uid)
+	}
+	for _, mapping := range opts.MapGroup {
+		old, name, ok := strings.Cut(mapping, ":")
+		if !ok || old == "" || name == "" {
+			return nil, errors.Fatalf("--map-group: invalid mapping %q, expected old:new", mapping)
+		}
+		gid, err := lookupGID(name)
+		if err != nil {
+			return nil, errors.Fatalf("--map-group: %v", err)
+		}
+		owners.MapGroup(old, gid)
+	}
+
+	if opts.Chown != "" {
+		userName, groupName, _ := strings.Cut(opts.Chown, ":")
+		if userName == "" && groupName == "" {
+			return nil, errors.Fatalf("--chown: invalid owner %q, expected user:group", opts.Chown)
+		}
+		if userName != "" {
+			uid, err := lookupUID(userName)
+			if err != nil {
+				return nil, errors.Fatalf("--chown: %v", err)
+			}
+			owners.SetUser(uid)
+		}
+		if groupName != "" {
+			gid, err := lookupGID(groupName)
+			if err != nil {
+				return nil, errors.Fatalf("--chown: %v", err)
+			}
+			owners.SetGroup(gid)
+		}
+	}
+
+	return owners, nil
+}
+
+// lookupUID returns the numeric ID of the local user name, which can also be
+// a numeric ID.
+func lookupUID(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, errors.Errorf("user %v has no numeric ID", name)
+	}
+	return uint32(id), nil
+}
+
+// lookupGID returns the numeric ID of the local group name, which can also be
+// a numeric ID.
+func lookupGID(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, errors.Errorf("group %v has no numeric ID", name)
+	}
+	return uint32(id), nil
+}
+
+// restoreWriteStrategy returns the write strategy for the target filesystem,
+// which is detected unless it was passed via --target-fs.
+func restoreWriteStrategy(opts RestoreOptions, targetFS restorer.TargetFS) restorer.WriteStrategy {
+	if targetFS == restorer.TargetUnknown {
+		targetFS = restorer.DetectTargetFS(opts.Target)
+	}
+
+	strategy := targetFS.Strategy()
+	if opts.WriteConcurrency > 0 {
+		strategy.Writers = opts.WriteConcurrency
+	}
+	switch opts.Fsync {
+	case "file":
+		strategy.Fsync = true
+	case "none":
+		strategy.Fsync = false
+	}
+
+	debug.Log("target filesystem %v, write strategy %+v", targetFS, strategy)
+	if strategy.Writers > 0 {
+		Verbosef("target filesystem: %v, writing %d files concurrently\n", targetFS, strategy.Writers)
+	}
+	return strategy
+}
+
+// stageRestore retrieves all packs needed for the restore from archive storage.
+func stageRestore(ctx context.Context, repo restic.Repository, res *restorer.Restorer, interval time.Duration) error {
+	packs, err := res.RequiredPacks(ctx)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("staging %d packs from archive storage\n", len(packs))
+	err = restorer.StagePacks(ctx, repo, packs, interval, func(ready, total int) {
+		if ready < total {
+			Verbosef("%d of %d packs are available, checking again in %v\n", ready, total, interval)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	Verbosef("all packs are available\n")
+	return nil
+}
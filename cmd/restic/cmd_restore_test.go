@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestoreTargetShareOptions(t *testing.T) {
+	for _, opts := range []RestoreOptions{
+		{Target: "/tmp/restore", TargetShare: "smb://nas/data"},
+		{Target: "/tmp/restore", TargetShareOptions: "vers=3.0"},
+		{Target: "/tmp/restore", TargetSharePasswordFile: "/tmp/password"},
+		{TargetShare: "ftp://nas/data"},
+	} {
+		err := runRestore(context.TODO(), opts, GlobalOptions{}, nil, []string{"latest"})
+		rtest.Assert(t, err != nil, "invalid options %#v not rejected", opts)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
@@ -21,7 +24,9 @@ var cmdRewrite = &cobra.Command{
 	Long: `
 The "rewrite" command excludes files from existing snapshots. It creates new
 snapshots containing the same data as the original ones, but without the files
-you specify to exclude. All metadata (time, host, tags) will be preserved.
+you specify to exclude. All metadata (time, host, tags) will be preserved,
+unless it is changed using the --new-host, --new-time, --shift-time or
+--replace-path options.
 
 The snapshots to rewrite are specified using the --host, --tag and --path options,
 or by providing a list of snapshot IDs. Please note that specifying neither any of
@@ -31,6 +36,11 @@ The special tag 'rewrite' will be added to the new snapshots to distinguish
 them from the original ones, unless --forget is used. If the --forget option is
 used, the original snapshots will instead be directly removed from the repository.
 
+The --forget-content option removes every file which references a data blob of
+a file with the given SHA-256 hash of its contents, for example to purge
+sensitive content that was backed up accidentally. Finding the files requires
+reading the contents of all files in the selected snapshots.
+
 Please note that the --forget option only removes the snapshots and not the actual
 data stored in the repository. In order to delete the no longer referenced data,
 use the "prune" command.
@@ -48,11 +58,98 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // RewriteOptions collects all options for the rewrite command.
 type RewriteOptions struct {
-	Forget bool
-	DryRun bool
+	Forget        bool
+	DryRun        bool
+	ForgetContent []string
 
+	Metadata snapshotMetadataArgs
 	restic.SnapshotFilter
 	excludePatternOptions
+	signingKeyOptions
+}
+
+// snapshotMetadataArgs collects the options which modify the metadata of the
+// rewritten snapshots.
+type snapshotMetadataArgs struct {
+	Hostname     string
+	Time         string
+	ShiftTime    time.Duration
+	ReplacePaths []string
+}
+
+func (sma snapshotMetadataArgs) empty() bool {
+	return sma.Hostname == "" && sma.Time == "" && sma.ShiftTime == 0 && len(sma.ReplacePaths) == 0
+}
+
+func (sma snapshotMetadataArgs) convert() (*snapshotMetadata, error) {
+	if sma.empty() {
+		return nil, nil
+	}
+	if sma.Time != "" && sma.ShiftTime != 0 {
+		return nil, errors.Fatal("--new-time and --shift-time cannot be used together")
+	}
+
+	md := &snapshotMetadata{
+		Hostname:  sma.Hostname,
+		ShiftTime: sma.ShiftTime,
+		Paths:     make(map[string]string),
+	}
+	if sma.Time != "" {
+		t, err := time.ParseInLocation(TimeFormat, sma.Time, time.Local)
+		if err != nil {
+			return nil, errors.Fatalf("error in time option: %v", err)
+		}
+		md.Time = &t
+	}
+	for _, r := range sma.ReplacePaths {
+		oldPath, newPath, ok := strings.Cut(r, "=")
+		if !ok || oldPath == "" || newPath == "" {
+			return nil, errors.Fatalf("invalid path replacement %q, expected old=new", r)
+		}
+		md.Paths[oldPath] = newPath
+	}
+	return md, nil
+}
+
+// snapshotMetadata contains the changes to the metadata of a snapshot.
+type snapshotMetadata struct {
+	Hostname  string
+	Time      *time.Time
+	ShiftTime time.Duration
+	// Paths maps recorded paths to their replacement
+	Paths map[string]string
+}
+
+// apply modifies the metadata of the snapshot and returns true if anything
+// was changed.
+func (md *snapshotMetadata) apply(sn *restic.Snapshot) bool {
+	if md == nil {
+		return false
+	}
+
+	changed := false
+	if md.Hostname != "" && md.Hostname != sn.Hostname {
+		Verbosef("setting host to %s\n", md.Hostname)
+		sn.Hostname = md.Hostname
+		changed = true
+	}
+	newTime := sn.Time.Add(md.ShiftTime)
+	if md.Time != nil {
+		newTime = *md.Time
+	}
+	if !newTime.Equal(sn.Time) {
+		Verbosef("setting time to %s\n", newTime)
+		sn.Time = newTime
+		changed = true
+	}
+	for i, p := range sn.Paths {
+		if newPath, ok := md.Paths[p]; ok && newPath != p {
+			Verbosef("replacing path %s with %s\n", p, newPath)
+			sn.Paths[i] = newPath
+			changed = true
+		}
+	}
+	return changed
 }
 
 var rewriteOptions RewriteOptions
@@ -63,12 +160,18 @@ func init() {
 	f := cmdRewrite.Flags()
 	f.BoolVarP(&rewriteOptions.Forget, "forget", "", false, "remove original snapshots after creating new ones")
 	f.BoolVarP(&rewriteOptions.DryRun, "dry-run", "n", false, "do not do anything, just print what would be done")
+	f.StringVar(&rewriteOptions.Metadata.Hostname, "new-host", "", "replace the hostname of the snapshots with `host`")
+	f.StringVar(&rewriteOptions.Metadata.Time, "new-time", "", "replace the time of the snapshots with `time` (format: "+TimeFormat+")")
+	f.DurationVar(&rewriteOptions.Metadata.ShiftTime, "shift-time", 0, "shift the time of the snapshots by `duration`, e.g. -2h30m")
+	f.StringArrayVar(&rewriteOptions.Metadata.ReplacePaths, "replace-path", nil, "replace the recorded `old=new` path of the snapshots (can be specified multiple times)")
+	f.StringArrayVar(&rewriteOptions.ForgetContent, "forget-content", nil, "remove all files which share data with the file whose contents have the SHA-256 `hash` (can be specified multiple times)")
 
 	initMultiSnapshotFilter(f, &rewriteOptions.SnapshotFilter, true)
 	initExcludePatternOptions(f, &rewriteOptions.excludePatternOptions)
+	initSigningKeyOptions(f, &rewriteOptions.signingKeyOptions)
 }
 
-func rewriteSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, opts RewriteOptions) (bool, error) {
+func rewriteSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, opts RewriteOptions, forget *forgottenContent, metadata *snapshotMetadata, signingKey ed25519.PrivateKey) (bool, error) {
 	if sn.Tree == nil {
 		return false, errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
 	}
@@ -87,8 +190,28 @@ func rewriteSnapshot(ctx context.Context, repo *repository.Repository, sn *resti
 		return true
 	}
 
+	// RewriteNode cannot return errors, the rewrite is aborted afterwards
+	var rewriteErr error
 	rewriter := walker.NewTreeRewriter(walker.RewriteOpts{
 		RewriteNode: func(node *restic.Node, path string) *restic.Node {
+			if rewriteErr != nil {
+				return nil
+			}
+			if forget != nil {
+				newNode, err := forget.rewriteNode(ctx, repo, node)
+				if err != nil {
+					rewriteErr = errors.Fatalf("unable to rewrite %v: %v", path, err)
+					return nil
+				}
+				if newNode == nil {
+					Verbosef("removing %s\n", path)
+					return nil
+				}
+				if newNode.Bundle == nil && node.Bundle != nil {
+					Verbosef("storing %s separately\n", path)
+				}
+				node = newNode
+			}
 			if selectByName(path) {
 				return node
 			}
@@ -98,13 +221,26 @@ func rewriteSnapshot(ctx context.Context, repo *repository.Repository, sn *resti
 		DisableNodeCache: true,
 	})
 
-	return filterAndReplaceSnapshot(ctx, repo, sn,
-		func(ctx context.Context, sn *restic.Snapshot) (restic.ID, error) {
-			return rewriter.RewriteTree(ctx, repo, "/", *sn.Tree)
-		}, opts.DryRun, opts.Forget, "rewrite")
+	filter := func(ctx context.Context, sn *restic.Snapshot) (restic.ID, error) {
+		id, err := rewriter.RewriteTree(ctx, repo, "/", *sn.Tree)
+		if err == nil {
+			err = rewriteErr
+		}
+		return id, err
+	}
+	if opts.excludePatternOptions.Empty() && (forget == nil || forget.empty()) {
+		// only the metadata is changed
+		filter = func(ctx context.Context, sn *restic.Snapshot) (restic.ID, error) {
+			return *sn.Tree, nil
+		}
+	}
+
+	return filterAndReplaceSnapshot(ctx, repo, sn, filter, opts.DryRun, opts.Forget, metadata, "rewrite", signingKey)
 }
 
-func filterAndReplaceSnapshot(ctx context.Context, repo restic.Repository, sn *restic.Snapshot, filter func(ctx context.Context, sn *restic.Snapshot) (restic.ID, error), dryRun bool, forget bool, addTag string) (bool, error) {
+// filterAndReplaceSnapshot saves a copy of the snapshot with the tree returned
+// by filter and the metadata changed by newMetadata, which may be nil.
+func filterAndReplaceSnapshot(ctx context.Context, repo restic.Repository, sn *restic.Snapshot, filter func(ctx context.Context, sn *restic.Snapshot) (restic.ID, error), dryRun bool, forget bool, newMetadata *snapshotMetadata, addTag string, signingKey ed25519.PrivateKey) (bool, error) {
 
 	wg, wgCtx := errgroup.WithContext(ctx)
 	repo.StartPackUploader(wgCtx, wg)
@@ -138,7 +274,8 @@ func filterAndReplaceSnapshot(ctx context.Context, repo restic.Repository, sn *r
 		return true, nil
 	}
 
-	if filteredTree == *sn.Tree {
+	metadataChanged := newMetadata.apply(sn)
+	if filteredTree == *sn.Tree && !metadataChanged {
 		debug.Log("Snapshot %v not modified", sn)
 		return false, nil
 	}
@@ -161,6 +298,9 @@ func filterAndReplaceSnapshot(ctx context.Context, repo restic.Repository, sn *r
 	if !forget {
 		sn.AddTags([]string{addTag})
 	}
+	if err = resignSnapshot(sn, signingKey); err != nil {
+		return false, err
+	}
 
 	// Save the new snapshot.
 	id, err := restic.SaveSnapshot(ctx, repo, sn)
@@ -180,9 +320,54 @@ func filterAndReplaceSnapshot(ctx context.Context, repo restic.Repository, sn *r
 	return true, nil
 }
 
+// replaceSnapshot saves the modified snapshot sn as a new snapshot and removes
+// the old one. The ID of the original snapshot is retained over all changes.
+// The new snapshot is signed with signingKey, or saved without a signature if
+// signingKey is nil.
+func replaceSnapshot(ctx context.Context, repo restic.Repository, sn *restic.Snapshot, signingKey ed25519.PrivateKey) (restic.ID, error) {
+	if sn.Original == nil {
+		sn.Original = sn.ID()
+	}
+	if err := resignSnapshot(sn, signingKey); err != nil {
+		return restic.ID{}, err
+	}
+
+	// Save the new snapshot.
+	id, err := restic.SaveSnapshot(ctx, repo, sn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	debug.Log("new snapshot saved as %v", id)
+
+	// Remove the old snapshot.
+	h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+	if err = repo.Backend().Remove(ctx, h); err != nil {
+		return restic.ID{}, err
+	}
+
+	debug.Log("old snapshot %v removed", sn.ID())
+	return id, nil
+}
+
 func runRewrite(ctx context.Context, opts RewriteOptions, gopts GlobalOptions, args []string) error {
-	if opts.excludePatternOptions.Empty() {
-		return errors.Fatal("Nothing to do: no excludes provided")
+	if opts.excludePatternOptions.Empty() && len(opts.ForgetContent) == 0 && opts.Metadata.empty() {
+		return errors.Fatal("Nothing to do: no excludes provided and no new metadata provided")
+	}
+	metadata, err := opts.Metadata.convert()
+	if err != nil {
+		return err
+	}
+	contentHashes, err := parseContentHashes(opts.ForgetContent)
+	if err != nil {
+		return err
+	}
+	signingKey, err := opts.signingKeyOptions.load()
+	if err != nil {
+		return err
+	}
+	if gopts.IgnoreCase {
+		opts.excludePatternOptions = opts.excludePatternOptions.ignoreCase()
 	}
 
 	repo, err := OpenRepository(ctx, gopts)
@@ -216,18 +401,44 @@ func runRewrite(ctx context.Context, opts RewriteOptions, gopts GlobalOptions, a
 		return err
 	}
 
-	changedCount := 0
+	var snapshots []*restic.Snapshot
 	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, args) {
+		snapshots = append(snapshots, sn)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var forget *forgottenContent
+	if len(contentHashes) > 0 {
+		Verbosef("searching %d snapshots for the contents to forget\n", len(snapshots))
+		forget, err = findContentBlobs(ctx, repo, snapshots, contentHashes)
+		if err != nil {
+			return err
+		}
+	}
+
+	var changedIDs restic.IDs
+	for _, sn := range snapshots {
 		Verbosef("\nsnapshot %s of %v at %s)\n", sn.ID().Str(), sn.Paths, sn.Time)
-		changed, err := rewriteSnapshot(ctx, repo, sn, opts)
+		changed, err := rewriteSnapshot(ctx, repo, sn, opts, forget, metadata, signingKey)
 		if err != nil {
 			return errors.Fatalf("unable to rewrite snapshot ID %q: %v", sn.ID().Str(), err)
 		}
 		if changed {
-			changedCount++
+			changedIDs = append(changedIDs, *sn.ID())
+		}
+	}
+
+	if len(changedIDs) > 0 && !opts.DryRun {
+		details := "kept original snapshots"
+		if opts.Forget {
+			details = "removed original snapshots"
 		}
+		recordAudit(ctx, repo, "rewrite", details, changedIDs)
 	}
 
+	changedCount := len(changedIDs)
 	Verbosef("\n")
 	if changedCount == 0 {
 		if !opts.DryRun {
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+)
+
+var cmdScrub = &cobra.Command{
+	Use:   "scrub [flags]",
+	Short: "Read a part of the repository data to verify all data regularly",
+	Long: `
+The "scrub" command reads and verifies a part of the pack files of the
+repository, as "check --read-data-subset" would. In contrast to the latter, it
+records in the repository when each pack file was last verified, and each run
+verifies the pack files whose last verification is oldest.
+
+If "scrub" is run regularly, for example daily, every pack file is verified
+at least once within the time given by --target-coverage. Each run reads the
+share of the repository corresponding to the time since the previous run, and
+additionally all pack files which would otherwise not be verified in time. The
+first run assumes that "scrub" is run daily. New pack files are verified
+within --target-coverage after "scrub" first sees them.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any
+error, including damaged pack files.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runScrub(cmd.Context(), scrubOptions, globalOptions, args)
+	},
+}
+
+// ScrubOptions collects all options for the scrub command.
+type ScrubOptions struct {
+	TargetCoverage restic.Duration
+	DryRun         bool
+}
+
+var scrubOptions ScrubOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdScrub)
+
+	f := cmdScrub.Flags()
+	scrubOptions.TargetCoverage = restic.Duration{Days: 30}
+	f.Var(&scrubOptions.TargetCoverage, "target-coverage", "verify every pack file at least once within `duration` (e.g. 30d)")
+	f.BoolVarP(&scrubOptions.DryRun, "dry-run", "n", false, "do not read any data, just print what would be verified")
+}
+
+// durationFrom returns the length of d when going back in time from now.
+func durationFrom(d restic.Duration, now time.Time) time.Duration {
+	start := now.AddDate(-d.Years, -d.Months, -d.Days).Add(time.Duration(-d.Hours) * time.Hour)
+	return now.Sub(start)
+}
+
+func runScrub(ctx context.Context, opts ScrubOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the scrub command expects no arguments")
+	}
+
+	now := time.Now()
+	window := durationFrom(opts.TargetCoverage, now)
+	if window <= 0 {
+		return errors.Fatal("--target-coverage must be positive")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	chkr := checker.New(repo, false)
+	Verbosef("load indexes\n")
+	_, errs := chkr.LoadIndex(ctx)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			Warnf("error: %v\n", err)
+		}
+		return errors.Fatal("LoadIndex returned errors")
+	}
+
+	state, err := checker.LoadScrubState(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	allPacks := chkr.GetPacks()
+	packs := state.SelectPacks(allPacks, now, window)
+	var size, totalSize int64
+	for _, s := range packs {
+		size += s
+	}
+	for _, s := range allPacks {
+		totalSize += s
+	}
+	Verbosef("verifying %d of %d pack files (%s of %s)\n", len(packs), len(allPacks),
+		ui.FormatBytes(uint64(size)), ui.FormatBytes(uint64(totalSize)))
+
+	if opts.DryRun {
+		for id := range packs {
+			Verboseff("would verify pack %v\n", id.Str())
+		}
+		return nil
+	}
+
+	damaged := restic.NewIDSet()
+	var readErr error
+	errChan := make(chan error)
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packs)), "packs")
+	go chkr.ReadPacks(ctx, packs, bar, errChan)
+	for err := range errChan {
+		Warnf("%v\n", err)
+		var e *checker.ReadError
+		if errors.As(err, &e) {
+			damaged.Insert(e.ID)
+		} else {
+			readErr = err
+		}
+	}
+	bar.Done()
+	if readErr != nil {
+		// it is unknown which pack files were verified
+		return readErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for id := range packs {
+		if !damaged.Has(id) {
+			state.MarkVerified(id, now)
+		}
+	}
+	state.LastRun = now
+	if err := state.Save(ctx, repo); err != nil {
+		return err
+	}
+
+	oldest, unverified := state.Oldest()
+	if !oldest.IsZero() {
+		Verbosef("the oldest verification is from %v\n", oldest.Local().Format(TimeFormat))
+	}
+	if unverified > 0 {
+		Verbosef("%d pack files were not verified yet\n", unverified)
+	}
+
+	if len(damaged) > 0 {
+		if repo.Config().Parity != nil {
+			printParityRepairable(ctx, repo, damaged)
+		}
+		return errors.Fatalf("%d pack files are damaged", len(damaged))
+	}
+	Verbosef("no errors were found\n")
+	return nil
+}
@@ -37,6 +37,8 @@ type SnapshotOptions struct {
 	Last    bool // This option should be removed in favour of Latest.
 	Latest  int
 	GroupBy restic.SnapshotGroupByOptions
+
+	FilterAnnotations []string
 }
 
 var snapshotOptions SnapshotOptions
@@ -55,6 +57,7 @@ func init() {
 	}
 	f.IntVar(&snapshotOptions.Latest, "latest", 0, "only show the last `n` snapshots for each host and path")
 	f.VarP(&snapshotOptions.GroupBy, "group-by", "g", "`group` snapshots by host, paths and/or tags, separated by comma")
+	f.StringArrayVar(&snapshotOptions.FilterAnnotations, "filter-annotation", nil, "only consider snapshots which have the annotation `key[=value]` (can be specified multiple times)")
 }
 
 func runSnapshots(ctx context.Context, opts SnapshotOptions, gopts GlobalOptions, args []string) error {
@@ -74,6 +77,9 @@ func runSnapshots(ctx context.Context, opts SnapshotOptions, gopts GlobalOptions
 
 	var snapshots restic.Snapshots
 	for sn := range FindFilteredSnapshots(ctx, repo.Backend(), repo, &opts.SnapshotFilter, args) {
+		if !sn.HasAnnotations(opts.FilterAnnotations) {
+			continue
+		}
 		snapshots = append(snapshots, sn)
 	}
 	snapshotGroups, grouped, err := restic.GroupSnapshots(snapshots, opts.GroupBy)
@@ -198,25 +204,33 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 			tab.AddColumn("Reasons", `{{ join .Reasons "\n" }}`)
 		}
 		tab.AddColumn("Paths", `{{ join .Paths "\n" }}`)
+		for _, sn := range list {
+			if sn.Description != "" {
+				tab.AddColumn("Description", "{{ .Description }}")
+				break
+			}
+		}
 	}
 
 	type snapshot struct {
-		ID        string
-		Timestamp string
-		Hostname  string
-		Tags      []string
-		Reasons   []string
-		Paths     []string
+		ID          string
+		Timestamp   string
+		Hostname    string
+		Tags        []string
+		Reasons     []string
+		Paths       []string
+		Description string
 	}
 
 	var multiline bool
 	for _, sn := range list {
 		data := snapshot{
-			ID:        sn.ID().Str(),
-			Timestamp: sn.Time.Local().Format(TimeFormat),
-			Hostname:  sn.Hostname,
-			Tags:      sn.Tags,
-			Paths:     sn.Paths,
+			ID:          sn.ID().Str(),
+			Timestamp:   sn.Time.Local().Format(TimeFormat),
+			Hostname:    sn.Hostname,
+			Tags:        sn.Tags,
+			Paths:       sn.Paths,
+			Description: sn.Description,
 		}
 
 		if len(reasons) > 0 {
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdSnapshotsEdit = &cobra.Command{
+	Use:   "edit [flags] snapshotID",
+	Short: "Edit the description of a snapshot",
+	Long: `
+The "snapshots edit" command changes the description of an existing snapshot.
+As for the "tag" command, the modified snapshot is saved with a new ID and the
+old snapshot is removed. The ID of the original snapshot is retained in the
+"original" field.
+
+The special snapshotID "latest" can be used to edit the latest snapshot in the
+repository.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSnapshotsEdit(cmd.Context(), snapshotsEditOptions, globalOptions, args)
+	},
+}
+
+// SnapshotsEditOptions bundles all options for the 'snapshots edit' command.
+type SnapshotsEditOptions struct {
+	restic.SnapshotFilter
+	Description      string
+	ClearDescription bool
+	signingKeyOptions
+}
+
+var snapshotsEditOptions SnapshotsEditOptions
+
+func init() {
+	cmdSnapshots.AddCommand(cmdSnapshotsEdit)
+
+	f := cmdSnapshotsEdit.Flags()
+	initSingleSnapshotFilter(f, &snapshotsEditOptions.SnapshotFilter)
+	f.StringVar(&snapshotsEditOptions.Description, "description", "", "set the free-text `description` of the snapshot")
+	f.BoolVar(&snapshotsEditOptions.ClearDescription, "clear-description", false, "remove the description of the snapshot")
+	initSigningKeyOptions(f, &snapshotsEditOptions.signingKeyOptions)
+}
+
+func runSnapshotsEdit(ctx context.Context, opts SnapshotsEditOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 1 {
+		return errors.Fatal("no snapshot ID specified")
+	}
+	if opts.Description == "" && !opts.ClearDescription {
+		return errors.Fatal("nothing to do!")
+	}
+	if opts.Description != "" && opts.ClearDescription {
+		return errors.Fatal("--description and --clear-description cannot be given at the same time")
+	}
+	signingKey, err := opts.signingKeyOptions.load()
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		Verbosef("create exclusive lock for repository\n")
+		var lock *restic.Lock
+		lock, ctx, err = lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	sn, err := opts.SnapshotFilter.FindLatest(ctx, repo.Backend(), repo, args[0])
+	if err != nil {
+		return errors.Fatalf("failed to find snapshot: %v", err)
+	}
+
+	if sn.Description == opts.Description {
+		Verbosef("snapshot %v was not modified\n", sn.ID().Str())
+		return nil
+	}
+	sn.Description = opts.Description
+
+	oldID := *sn.ID()
+	id, err := replaceSnapshot(ctx, repo, sn, signingKey)
+	if err != nil {
+		return err
+	}
+	recordAudit(ctx, repo, "snapshots edit", "replaced snapshot with modified description", restic.IDs{oldID})
+	Verbosef("saved modified snapshot %v as %v\n", sn.ID().Str(), id.Str())
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdSnapshotsProtect = &cobra.Command{
+	Use:   "protect [flags] [snapshot ID] [...]",
+	Short: "Protect snapshots from removal",
+	Long: `
+The "snapshots protect" command marks snapshots as protected. Protected
+snapshots are never removed by "forget", neither when they are passed
+explicitly nor when a retention policy would remove them, unless the option
+"--force-unprotect" is given. As long as a snapshot exists, "prune" does not
+remove any of the data it references.
+
+When no snapshot ID is given, all snapshots matching the host, tag and path
+filter criteria are protected.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSnapshotsProtect(cmd.Context(), snapshotsProtectOptions, globalOptions, args, true)
+	},
+}
+
+var cmdSnapshotsUnprotect = &cobra.Command{
+	Use:   "unprotect [flags] [snapshot ID] [...]",
+	Short: "Remove the protection from snapshots",
+	Long: `
+The "snapshots unprotect" command removes the protection from snapshots, so
+that they can be removed by "forget" again.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSnapshotsProtect(cmd.Context(), snapshotsUnprotectOptions, globalOptions, args, false)
+	},
+}
+
+// SnapshotsProtectOptions bundles all options for the 'snapshots protect'
+// and 'snapshots unprotect' commands.
+type SnapshotsProtectOptions struct {
+	restic.SnapshotFilter
+	signingKeyOptions
+}
+
+var snapshotsProtectOptions, snapshotsUnprotectOptions SnapshotsProtectOptions
+
+func init() {
+	cmdSnapshots.AddCommand(cmdSnapshotsProtect)
+	cmdSnapshots.AddCommand(cmdSnapshotsUnprotect)
+
+	initMultiSnapshotFilter(cmdSnapshotsProtect.Flags(), &snapshotsProtectOptions.SnapshotFilter, true)
+	initMultiSnapshotFilter(cmdSnapshotsUnprotect.Flags(), &snapshotsUnprotectOptions.SnapshotFilter, true)
+	initSigningKeyOptions(cmdSnapshotsProtect.Flags(), &snapshotsProtectOptions.signingKeyOptions)
+	initSigningKeyOptions(cmdSnapshotsUnprotect.Flags(), &snapshotsUnprotectOptions.signingKeyOptions)
+}
+
+func changeProtection(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, protect bool, signingKey ed25519.PrivateKey) (bool, error) {
+	if sn.Protected == protect {
+		return false, nil
+	}
+	sn.Protected = protect
+
+	if _, err := replaceSnapshot(ctx, repo, sn, signingKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func runSnapshotsProtect(ctx context.Context, opts SnapshotsProtectOptions, gopts GlobalOptions, args []string, protect bool) error {
+	if !protect && len(args) == 0 && len(opts.Hosts) == 0 && len(opts.Tags) == 0 && len(opts.Paths) == 0 {
+		return errors.Fatal("refusing to unprotect all snapshots, please specify snapshot IDs or filters")
+	}
+	signingKey, err := opts.signingKeyOptions.load()
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		Verbosef("create exclusive lock for repository\n")
+		var lock *restic.Lock
+		lock, ctx, err = lockRepoExclusive(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if protect {
+		// clients unaware of protected snapshots must not run forget
+		err = repo.Config().RequireCapability(restic.CapabilityProtectedSnapshots, "protecting snapshots")
+		if err != nil {
+			return err
+		}
+	}
+
+	var changedIDs restic.IDs
+	for sn := range FindFilteredSnapshots(ctx, repo.Backend(), repo, &opts.SnapshotFilter, args) {
+		changed, err := changeProtection(ctx, repo, sn, protect, signingKey)
+		if err != nil {
+			Warnf("unable to modify snapshot ID %q, ignoring: %v\n", sn.ID(), err)
+			continue
+		}
+		if changed {
+			changedIDs = append(changedIDs, *sn.ID())
+		}
+	}
+
+	action, operation := "protected", "protect"
+	if !protect {
+		action, operation = "unprotected", "unprotect"
+	}
+	if len(changedIDs) > 0 {
+		recordAudit(ctx, repo, operation, "", changedIDs)
+	}
+
+	if len(changedIDs) == 0 {
+		Verbosef("no snapshots were modified\n")
+	} else {
+		Verbosef("%v %v snapshots\n", action, len(changedIDs))
+	}
+	return nil
+}
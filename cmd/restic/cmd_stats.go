@@ -4,12 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/table"
 	"github.com/restic/restic/internal/walker"
 
 	"github.com/minio/sha256-simd"
@@ -40,6 +45,15 @@ The modes are:
   how many files reference them.
 * blobs-per-file: A combination of files-by-contents and raw-data.
 
+In the restore-size and raw-data modes, --by-host and --by-path-depth
+additionally break down the statistics per host and per directory at the
+given depth below the root. In raw-data mode, a blob is counted for every
+group which references it.
+
+The --timeline option prints for each snapshot, ordered by time, the stored
+size of the data it added to the repository and the cumulative size of the
+data of all snapshots up to it. The output is CSV, or JSON with --json.
+
 Refer to the online manual for more details about each mode.
 
 EXIT STATUS
@@ -57,6 +71,11 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 type StatsOptions struct {
 	// the mode of counting to perform (see consts for available modes)
 	countMode string
+	// group the statistics by host and by directories at the given depth
+	byHost      bool
+	byPathDepth int
+	// print the data added by each snapshot
+	timeline bool
 
 	restic.SnapshotFilter
 }
@@ -67,6 +86,9 @@ func init() {
 	cmdRoot.AddCommand(cmdStats)
 	f := cmdStats.Flags()
 	f.StringVar(&statsOptions.countMode, "mode", countModeRestoreSize, "counting mode: restore-size (default), files-by-contents, blobs-per-file or raw-data")
+	f.BoolVar(&statsOptions.byHost, "by-host", false, "show the statistics per host")
+	f.IntVar(&statsOptions.byPathDepth, "by-path-depth", 0, "show the statistics per directory `n` levels below the root")
+	f.BoolVar(&statsOptions.timeline, "timeline", false, "print the data added by each snapshot as CSV")
 	initMultiSnapshotFilter(f, &statsOptions.SnapshotFilter, true)
 }
 
@@ -99,6 +121,21 @@ func runStats(ctx context.Context, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if statsOptions.timeline {
+		var snapshots []*restic.Snapshot
+		for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &statsOptions.SnapshotFilter, args) {
+			snapshots = append(snapshots, sn)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		entries, err := statsTimeline(ctx, repo, snapshots)
+		if err != nil {
+			return fmt.Errorf("error walking snapshot: %v", err)
+		}
+		return printStatsTimeline(gopts, entries)
+	}
+
 	if !gopts.JSON {
 		Printf("scanning...\n")
 	}
@@ -109,6 +146,8 @@ func runStats(ctx context.Context, gopts GlobalOptions, args []string) error {
 		fileBlobs:      make(map[string]restic.IDSet),
 		blobs:          restic.NewBlobSet(),
 		SnapshotsCount: 0,
+		groups:         make(map[statsGroupKey]*statsGroup),
+		groupBlobs:     make(map[statsGroupKey]restic.BlobSet),
 	}
 
 	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &statsOptions.SnapshotFilter, args) {
@@ -146,8 +185,30 @@ func runStats(ctx context.Context, gopts GlobalOptions, args []string) error {
 			stats.CompressionProgress = float64(stats.TotalCompressedBlobsUncompressedSize) / float64(stats.TotalUncompressedSize) * 100
 			stats.CompressionSpaceSaving = (1 - float64(stats.TotalSize)/float64(stats.TotalUncompressedSize)) * 100
 		}
+
+		for key, blobs := range stats.groupBlobs {
+			group := stats.groups[key]
+			for blobHandle := range blobs {
+				pbs := repo.Index().Lookup(blobHandle)
+				if len(pbs) == 0 {
+					return fmt.Errorf("blob %v not found", blobHandle)
+				}
+				group.TotalSize += uint64(pbs[0].Length)
+				group.TotalBlobCount++
+			}
+		}
 	}
 
+	for _, group := range stats.groups {
+		stats.Groups = append(stats.Groups, *group)
+	}
+	sort.Slice(stats.Groups, func(i, j int) bool {
+		if stats.Groups[i].Hostname != stats.Groups[j].Hostname {
+			return stats.Groups[i].Hostname < stats.Groups[j].Hostname
+		}
+		return stats.Groups[i].Path < stats.Groups[j].Path
+	})
+
 	if gopts.JSON {
 		err = json.NewEncoder(globalOptions.stdout).Encode(stats)
 		if err != nil {
@@ -178,9 +239,47 @@ func runStats(ctx context.Context, gopts GlobalOptions, args []string) error {
 		Printf("Compression Space Saving:  %.2f%%\n", stats.CompressionSpaceSaving)
 	}
 
+	if len(stats.Groups) > 0 {
+		Printf("\n")
+		return printStatsGroups(gopts, stats.Groups)
+	}
 	return nil
 }
 
+func printStatsGroups(gopts GlobalOptions, groups []statsGroup) error {
+	type groupRow struct {
+		Host, Path, Count, Size string
+	}
+
+	tab := table.New()
+	if statsOptions.byHost {
+		tab.AddColumn("Host", "{{ .Host }}")
+	}
+	if statsOptions.byPathDepth > 0 {
+		tab.AddColumn("Path", "{{ .Path }}")
+	}
+	if statsOptions.countMode == countModeRawData {
+		tab.AddColumn("Blobs", "{{ .Count }}")
+	} else {
+		tab.AddColumn("Files", "{{ .Count }}")
+	}
+	tab.AddColumn("Size", "{{ .Size }}")
+
+	for _, group := range groups {
+		count := group.TotalFileCount
+		if statsOptions.countMode == countModeRawData {
+			count = group.TotalBlobCount
+		}
+		tab.AddRow(groupRow{
+			Host:  group.Hostname,
+			Path:  group.Path,
+			Count: fmt.Sprint(count),
+			Size:  ui.FormatBytes(group.TotalSize),
+		})
+	}
+	return tab.Write(gopts.stdout)
+}
+
 func statsWalkSnapshot(ctx context.Context, snapshot *restic.Snapshot, repo restic.Repository, stats *statsContainer) error {
 	if snapshot.Tree == nil {
 		return fmt.Errorf("snapshot %s has nil tree", snapshot.ID().Str())
@@ -191,11 +290,15 @@ func statsWalkSnapshot(ctx context.Context, snapshot *restic.Snapshot, repo rest
 	if statsOptions.countMode == countModeRawData {
 		// count just the sizes of unique blobs; we don't need to walk the tree
 		// ourselves in this case, since a nifty function does it for us
-		return restic.FindUsedBlobs(ctx, repo, restic.IDs{*snapshot.Tree}, stats.blobs, nil)
+		err := restic.FindUsedBlobs(ctx, repo, restic.IDs{*snapshot.Tree}, stats.blobs, nil)
+		if err != nil || !statsOptions.grouped() {
+			return err
+		}
+		return statsWalkGroupBlobs(ctx, snapshot, repo, stats)
 	}
 
 	uniqueInodes := make(map[uint64]struct{})
-	err := walker.Walk(ctx, repo, *snapshot.Tree, restic.NewIDSet(), statsWalkTree(repo, stats, uniqueInodes))
+	err := walker.Walk(ctx, repo, *snapshot.Tree, restic.NewIDSet(), statsWalkTree(repo, stats, uniqueInodes, snapshot.Hostname))
 	if err != nil {
 		return fmt.Errorf("walking tree %s: %v", *snapshot.Tree, err)
 	}
@@ -203,7 +306,46 @@ func statsWalkSnapshot(ctx context.Context, snapshot *restic.Snapshot, repo rest
 	return nil
 }
 
-func statsWalkTree(repo restic.Repository, stats *statsContainer, uniqueInodes map[uint64]struct{}) walker.WalkFunc {
+// statsWalkGroupBlobs collects the blobs referenced by each group. The trees
+// above the grouping depth are walked, everything below is collected at once.
+func statsWalkGroupBlobs(ctx context.Context, snapshot *restic.Snapshot, repo restic.Repository, stats *statsContainer) error {
+	if statsOptions.byPathDepth == 0 {
+		blobs := stats.groupBlobSet(snapshot.Hostname, "/")
+		return restic.FindUsedBlobs(ctx, repo, restic.IDs{*snapshot.Tree}, blobs, nil)
+	}
+
+	stats.groupBlobSet(snapshot.Hostname, "/").Insert(restic.BlobHandle{ID: *snapshot.Tree, Type: restic.TreeBlob})
+	return walker.Walk(ctx, repo, *snapshot.Tree, nil, func(_ restic.ID, npath string, node *restic.Node, nodeErr error) (bool, error) {
+		if nodeErr != nil {
+			return false, nodeErr
+		}
+		if node == nil {
+			return false, nil
+		}
+
+		switch node.Type {
+		case "file":
+			blobs := stats.groupBlobSet(snapshot.Hostname, path.Dir(npath))
+			for _, id := range node.Content {
+				blobs.Insert(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+			}
+		case "dir":
+			blobs := stats.groupBlobSet(snapshot.Hostname, npath)
+			if pathDepth(npath) < statsOptions.byPathDepth {
+				blobs.Insert(restic.BlobHandle{ID: *node.Subtree, Type: restic.TreeBlob})
+				return false, nil
+			}
+			err := restic.FindUsedBlobs(ctx, repo, restic.IDs{*node.Subtree}, blobs, nil)
+			if err != nil {
+				return false, err
+			}
+			return false, walker.ErrSkipNode
+		}
+		return false, nil
+	})
+}
+
+func statsWalkTree(repo restic.Repository, stats *statsContainer, uniqueInodes map[uint64]struct{}, hostname string) walker.WalkFunc {
 	return func(parentTreeID restic.ID, npath string, node *restic.Node, nodeErr error) (bool, error) {
 		if nodeErr != nil {
 			return true, nodeErr
@@ -262,9 +404,17 @@ func statsWalkTree(repo restic.Repository, stats *statsContainer, uniqueInodes m
 
 			// if inodes are present, only count each inode once
 			// (hard links do not increase restore size)
+			var group *statsGroup
+			if statsOptions.grouped() {
+				group = stats.group(hostname, path.Dir(npath))
+				group.TotalFileCount++
+			}
 			if _, ok := uniqueInodes[node.Inode]; !ok || node.Inode == 0 {
 				uniqueInodes[node.Inode] = struct{}{}
 				stats.TotalSize += node.Size
+				if group != nil {
+					group.TotalSize += node.Size
+				}
 			}
 
 			return false, nil
@@ -275,12 +425,16 @@ func statsWalkTree(repo restic.Repository, stats *statsContainer, uniqueInodes m
 }
 
 // makeFileIDByContents returns a hash of the blob IDs of the
-// node's Content in sequence.
+// node's Content in sequence. For bundled files, the position within the
+// bundle is included.
 func makeFileIDByContents(node *restic.Node) fileID {
 	var bb []byte
 	for _, c := range node.Content {
 		bb = append(bb, []byte(c[:])...)
 	}
+	if node.Bundle != nil {
+		bb = append(bb, []byte(fmt.Sprintf("bundle:%d:%d", node.Bundle.Offset, node.Size))...)
+	}
 	return sha256.Sum256(bb)
 }
 
@@ -295,6 +449,16 @@ func verifyStatsInput(gopts GlobalOptions, args []string) error {
 		return fmt.Errorf("unknown counting mode: %s (use the -h flag to get a list of supported modes)", statsOptions.countMode)
 	}
 
+	if statsOptions.byPathDepth < 0 {
+		return errors.Fatal("--by-path-depth must not be negative")
+	}
+	if statsOptions.grouped() && statsOptions.countMode != countModeRestoreSize && statsOptions.countMode != countModeRawData {
+		return errors.Fatalf("--by-host and --by-path-depth are not supported in %s mode", statsOptions.countMode)
+	}
+	if statsOptions.timeline && statsOptions.grouped() {
+		return errors.Fatal("--timeline cannot be combined with --by-host or --by-path-depth")
+	}
+
 	return nil
 }
 
@@ -313,6 +477,8 @@ type statsContainer struct {
 	TotalBlobCount                       uint64  `json:"total_blob_count,omitempty"`
 	// holds count of all considered snapshots
 	SnapshotsCount int `json:"snapshots_count"`
+	// the statistics per host and directory, sorted by both
+	Groups []statsGroup `json:"groups,omitempty"`
 
 	// uniqueFiles marks visited files according to their
 	// contents (hashed sequence of content blob IDs)
@@ -325,6 +491,79 @@ type statsContainer struct {
 	// blobs is used to count individual unique blobs,
 	// independent of references to files
 	blobs restic.BlobSet
+
+	// groups collects the statistics per host and directory
+	groups map[statsGroupKey]*statsGroup
+	// groupBlobs holds the blobs referenced by each group in raw-data mode
+	groupBlobs map[statsGroupKey]restic.BlobSet
+}
+
+// statsGroup holds the statistics of a host or directory.
+type statsGroup struct {
+	Hostname       string `json:"hostname,omitempty"`
+	Path           string `json:"path,omitempty"`
+	TotalSize      uint64 `json:"total_size"`
+	TotalFileCount uint64 `json:"total_file_count,omitempty"`
+	TotalBlobCount uint64 `json:"total_blob_count,omitempty"`
+}
+
+type statsGroupKey struct {
+	hostname, path string
+}
+
+func (opts StatsOptions) grouped() bool {
+	return opts.byHost || opts.byPathDepth > 0
+}
+
+// groupKey returns the key of the group for the directory dir.
+func groupKey(hostname, dir string) statsGroupKey {
+	var key statsGroupKey
+	if statsOptions.byHost {
+		key.hostname = hostname
+	}
+	if statsOptions.byPathDepth > 0 {
+		key.path = truncatePath(dir, statsOptions.byPathDepth)
+	}
+	return key
+}
+
+func (stats *statsContainer) group(hostname, dir string) *statsGroup {
+	key := groupKey(hostname, dir)
+	group, ok := stats.groups[key]
+	if !ok {
+		group = &statsGroup{Hostname: key.hostname, Path: key.path}
+		stats.groups[key] = group
+	}
+	return group
+}
+
+func (stats *statsContainer) groupBlobSet(hostname, dir string) restic.BlobSet {
+	key := groupKey(hostname, dir)
+	stats.group(hostname, dir)
+	blobs, ok := stats.groupBlobs[key]
+	if !ok {
+		blobs = restic.NewBlobSet()
+		stats.groupBlobs[key] = blobs
+	}
+	return blobs
+}
+
+// pathDepth returns the number of path components of the absolute path p.
+func pathDepth(p string) int {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return 0
+	}
+	return strings.Count(p, "/") + 1
+}
+
+// truncatePath returns the first depth components of the absolute path p.
+func truncatePath(p string, depth int) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return "/" + strings.Join(parts, "/")
 }
 
 // fileID is a 256-bit hash that distinguishes unique files.
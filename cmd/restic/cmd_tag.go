@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"strings"
 
 	"github.com/spf13/cobra"
 
-	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -20,6 +21,9 @@ The "tag" command allows you to modify tags on exiting snapshots.
 You can either set/replace the entire set of tags on a snapshot, or
 add tags to/remove tags from the existing set.
 
+Annotations, which are key/value pairs, can be set or removed using
+--set-annotation and --remove-annotation.
+
 When no snapshot-ID is given, all snapshots matching the host, tag and path filter criteria are modified.
 
 EXIT STATUS
@@ -39,6 +43,10 @@ type TagOptions struct {
 	SetTags    restic.TagLists
 	AddTags    restic.TagLists
 	RemoveTags restic.TagLists
+
+	SetAnnotations    []string
+	RemoveAnnotations []string
+	signingKeyOptions
 }
 
 var tagOptions TagOptions
@@ -50,10 +58,30 @@ func init() {
 	tagFlags.Var(&tagOptions.SetTags, "set", "`tags` which will replace the existing tags in the format `tag[,tag,...]` (can be given multiple times)")
 	tagFlags.Var(&tagOptions.AddTags, "add", "`tags` which will be added to the existing tags in the format `tag[,tag,...]` (can be given multiple times)")
 	tagFlags.Var(&tagOptions.RemoveTags, "remove", "`tags` which will be removed from the existing tags in the format `tag[,tag,...]` (can be given multiple times)")
+	tagFlags.StringArrayVar(&tagOptions.SetAnnotations, "set-annotation", nil, "set the annotation `key=value`, replacing an existing value of key (can be given multiple times)")
+	tagFlags.StringArrayVar(&tagOptions.RemoveAnnotations, "remove-annotation", nil, "remove the annotation with the `key` (can be given multiple times)")
 	initMultiSnapshotFilter(tagFlags, &tagOptions.SnapshotFilter, true)
+	initSigningKeyOptions(tagFlags, &tagOptions.signingKeyOptions)
 }
 
-func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string) (bool, error) {
+// parseAnnotations parses a list of annotations in the format key=value.
+func parseAnnotations(list []string) (map[string]string, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	annotations := make(map[string]string, len(list))
+	for _, s := range list {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return nil, errors.Fatalf("invalid annotation %q, must be in the format key=value", s)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
+
+func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string, setAnnotations map[string]string, removeAnnotations []string, signingKey ed25519.PrivateKey) (bool, error) {
 	var changed bool
 
 	if len(setTags) != 0 {
@@ -69,39 +97,37 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 			changed = true
 		}
 	}
+	if sn.RemoveAnnotations(removeAnnotations) {
+		changed = true
+	}
+	if sn.SetAnnotations(setAnnotations) {
+		changed = true
+	}
 
 	if changed {
-		// Retain the original snapshot id over all tag changes.
-		if sn.Original == nil {
-			sn.Original = sn.ID()
-		}
-
-		// Save the new snapshot.
-		id, err := restic.SaveSnapshot(ctx, repo, sn)
-		if err != nil {
+		if _, err := replaceSnapshot(ctx, repo, sn, signingKey); err != nil {
 			return false, err
 		}
-
-		debug.Log("new snapshot saved as %v", id)
-
-		// Remove the old snapshot.
-		h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
-		if err = repo.Backend().Remove(ctx, h); err != nil {
-			return false, err
-		}
-
-		debug.Log("old snapshot %v removed", sn.ID())
 	}
 	return changed, nil
 }
 
 func runTag(ctx context.Context, opts TagOptions, gopts GlobalOptions, args []string) error {
-	if len(opts.SetTags) == 0 && len(opts.AddTags) == 0 && len(opts.RemoveTags) == 0 {
+	if len(opts.SetTags) == 0 && len(opts.AddTags) == 0 && len(opts.RemoveTags) == 0 &&
+		len(opts.SetAnnotations) == 0 && len(opts.RemoveAnnotations) == 0 {
 		return errors.Fatal("nothing to do!")
 	}
 	if len(opts.SetTags) != 0 && (len(opts.AddTags) != 0 || len(opts.RemoveTags) != 0) {
 		return errors.Fatal("--set and --add/--remove cannot be given at the same time")
 	}
+	setAnnotations, err := parseAnnotations(opts.SetAnnotations)
+	if err != nil {
+		return err
+	}
+	signingKey, err := opts.signingKeyOptions.load()
+	if err != nil {
+		return err
+	}
 
 	repo, err := OpenRepository(ctx, gopts)
 	if err != nil {
@@ -118,21 +144,23 @@ func runTag(ctx context.Context, opts TagOptions, gopts GlobalOptions, args []st
 		}
 	}
 
-	changeCnt := 0
+	var changedIDs restic.IDs
 	for sn := range FindFilteredSnapshots(ctx, repo.Backend(), repo, &opts.SnapshotFilter, args) {
-		changed, err := changeTags(ctx, repo, sn, opts.SetTags.Flatten(), opts.AddTags.Flatten(), opts.RemoveTags.Flatten())
+		changed, err := changeTags(ctx, repo, sn, opts.SetTags.Flatten(), opts.AddTags.Flatten(), opts.RemoveTags.Flatten(),
+			setAnnotations, opts.RemoveAnnotations, signingKey)
 		if err != nil {
 			Warnf("unable to modify the tags for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
 			continue
 		}
 		if changed {
-			changeCnt++
+			changedIDs = append(changedIDs, *sn.ID())
 		}
 	}
-	if changeCnt == 0 {
+	if len(changedIDs) == 0 {
 		Verbosef("no snapshots were modified\n")
 	} else {
-		Verbosef("modified tags on %v snapshots\n", changeCnt)
+		recordAudit(ctx, repo, "tag", "replaced snapshots with modified tags or annotations", changedIDs)
+		Verbosef("modified tags on %v snapshots\n", len(changedIDs))
 	}
 	return nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restorer"
+	"github.com/spf13/cobra"
+)
+
+var cmdVerify = &cobra.Command{
+	Use:   "verify [flags] snapshotID dir",
+	Short: "Compare a directory with a snapshot",
+	Long: `
+The "verify" command compares the directory dir with the snapshot, for
+example to validate a restore or to detect modified or corrupted files. The
+contents of all files are read and compared with the hashes stored in the
+snapshot. In addition, the metadata of all files and directories is compared.
+Nothing is written to the directory.
+
+Each difference is printed together with the path of the affected item within
+the snapshot. The special snapshotID "latest" can be used to compare with the
+latest snapshot in the repository.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the directory matches the snapshot, and non-zero if there
+were any differences or errors.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runVerify(cmd.Context(), verifyOptions, globalOptions, args)
+	},
+}
+
+// VerifyOptions collects all options for the verify command.
+type VerifyOptions struct {
+	restic.SnapshotFilter
+}
+
+var verifyOptions VerifyOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdVerify)
+
+	flags := cmdVerify.Flags()
+	initSingleSnapshotFilter(flags, &verifyOptions.SnapshotFilter)
+}
+
+// VerifyDifference is printed for each difference in JSON mode.
+type VerifyDifference struct {
+	MessageType string `json:"message_type"` // "difference"
+	Path        string `json:"path"`
+	Difference  string `json:"difference"`
+}
+
+func runVerify(ctx context.Context, opts VerifyOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 2 {
+		return errors.Fatal("specify a snapshot ID and a directory")
+	}
+
+	repo, err := OpenRepository(ctx, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, gopts.JSON)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	sn, err := (&restic.SnapshotFilter{
+		Hosts: opts.Hosts,
+		Paths: opts.Paths,
+		Tags:  opts.Tags,
+	}).FindLatest(ctx, repo.Backend(), repo, args[0])
+	if err != nil {
+		return errors.Fatalf("failed to find snapshot: %v", err)
+	}
+
+	err = repo.LoadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	res := restorer.NewRestorer(ctx, repo, sn, false, nil)
+	totalErrors := 0
+	res.Error = func(location string, err error) error {
+		Warnf("ignoring error for %s: %s\n", location, err)
+		totalErrors++
+		return nil
+	}
+
+	enc := json.NewEncoder(gopts.stdout)
+	differences := 0
+	report := func(location string, msg string) {
+		differences++
+		if gopts.JSON {
+			err := enc.Encode(VerifyDifference{MessageType: "difference", Path: location, Difference: msg})
+			if err != nil {
+				Warnf("JSON encode failed: %v\n", err)
+			}
+			return
+		}
+		Printf("%v: %v\n", location, msg)
+	}
+
+	Verbosef("comparing %s with %s\n", res.Snapshot(), args[1])
+	count, err := res.CompareTo(ctx, args[1], report)
+	if err != nil {
+		return err
+	}
+
+	if totalErrors > 0 {
+		return errors.Fatalf("There were %d errors\n", totalErrors)
+	}
+	if differences > 0 {
+		return errors.Fatalf("found %d differences in %d items\n", differences, count)
+	}
+	Verbosef("compared %d items, no differences found\n", count)
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+	"golang.org/x/sync/errgroup"
+)
+
+// rechunkRepo loads trees from the source and saves them to the destination
+// repository.
+type rechunkRepo struct {
+	restic.BlobLoader
+	restic.BlobSaver
+	// srcHash computes the IDs of blobs in the source repository
+	srcHash restic.BlobHashFunc
+}
+
+// LoadBlob checks that trees can be encoded again without losing information,
+// as they are saved again with the new contents of the files.
+func (r rechunkRepo) LoadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
+	buf, err := r.BlobLoader.LoadBlob(ctx, t, id, buf)
+	if err != nil || t != restic.TreeBlob {
+		return buf, err
+	}
+
+	tree := &restic.Tree{}
+	if err := json.Unmarshal(buf, tree); err != nil {
+		return nil, err
+	}
+	testID, err := restic.SaveTree(ctx, hashSaver{r.srcHash}, tree)
+	if err != nil {
+		return nil, err
+	}
+	if testID != id {
+		return nil, errors.Errorf("cannot encode tree %v without losing information", id.Str())
+	}
+	return buf, nil
+}
+
+// hashSaver only computes the ID of blobs.
+type hashSaver struct {
+	hash restic.BlobHashFunc
+}
+
+func (s hashSaver) SaveBlob(_ context.Context, _ restic.BlobType, buf []byte, _ restic.ID, _ bool) (restic.ID, bool, int, error) {
+	return s.hash(buf), false, len(buf), nil
+}
+
+// rechunker copies trees to another repository and splits the contents of
+// all files again using the chunker polynomial of the destination repository.
+type rechunker struct {
+	srcRepo restic.Repository
+	dstRepo restic.Repository
+
+	// the rewriter remembers the trees which were already copied
+	rewriter *walker.TreeRewriter
+	// contents maps the content of already copied files to their new content
+	contents map[string]restic.IDs
+	buf      []byte
+	chunkBuf []byte
+
+	// context and first error of the current CopyTree call
+	ctx context.Context
+	err error
+}
+
+func newRechunker(srcRepo, dstRepo restic.Repository) *rechunker {
+	r := &rechunker{
+		srcRepo:  srcRepo,
+		dstRepo:  dstRepo,
+		contents: make(map[string]restic.IDs),
+	}
+	r.rewriter = walker.NewTreeRewriter(walker.RewriteOpts{
+		// checked by rechunkRepo, the rewriter would save the original trees
+		AllowUnstableSerialization: true,
+		RewriteNode: func(node *restic.Node, path string) *restic.Node {
+			if node.Type != "file" || r.err != nil {
+				return node
+			}
+			if node.ContentEncoding != nil {
+				if err := r.dstRepo.Config().RequireCapability(restic.CapabilityContentEncoding, "copying decoded layers"); err != nil {
+					r.err = err
+					return node
+				}
+			}
+
+			var content restic.IDs
+			var err error
+			if node.Bundle != nil {
+				content, err = r.rechunkBundled(r.ctx, node)
+			} else {
+				content, err = r.rechunkFile(r.ctx, node.Content)
+			}
+			if err != nil {
+				r.err = errors.Wrapf(err, "rechunking %v", path)
+				return node
+			}
+			node.Content = content
+			// the file is stored as a regular file in the destination
+			node.Bundle = nil
+			// the new blobs are not encrypted with the data key
+			node.DataKey = nil
+			return node
+		},
+	})
+	return r
+}
+
+// CopyTree copies the tree rootID and returns the ID of the new tree in the
+// destination repository.
+func (r *rechunker) CopyTree(ctx context.Context, rootID restic.ID) (restic.ID, error) {
+	var newID restic.ID
+	wg, wgCtx := errgroup.WithContext(ctx)
+	r.dstRepo.StartPackUploader(wgCtx, wg)
+	wg.Go(func() error {
+		r.ctx, r.err = wgCtx, nil
+		var err error
+		newID, err = r.rewriter.RewriteTree(wgCtx, rechunkRepo{r.srcRepo, r.dstRepo, r.srcRepo.Config().HashBlob}, "/", rootID)
+		if r.err != nil {
+			return r.err
+		}
+		if err != nil {
+			return err
+		}
+		return r.dstRepo.Flush(wgCtx)
+	})
+	return newID, wg.Wait()
+}
+
+// rechunkFile saves the data of the blobs in content as new chunks and
+// returns their IDs.
+func (r *rechunker) rechunkFile(ctx context.Context, content restic.IDs) (restic.IDs, error) {
+	if len(content) == 0 {
+		return content, nil
+	}
+	key := contentKey(content)
+	if ids, ok := r.contents[key]; ok {
+		return ids, nil
+	}
+
+	ids, err := r.saveChunks(ctx, func(ctx context.Context, wr io.Writer) error {
+		var err error
+		for _, id := range content {
+			r.buf, err = r.srcRepo.LoadBlob(ctx, restic.DataBlob, id, r.buf)
+			if err != nil {
+				return err
+			}
+			if _, err = wr.Write(r.buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.contents[key] = ids
+	return ids, nil
+}
+
+// rechunkBundled extracts the data of a file stored in a bundle and saves it
+// as new chunks, which only contain the data of this file.
+func (r *rechunker) rechunkBundled(ctx context.Context, node *restic.Node) (restic.IDs, error) {
+	if len(node.Content) != 1 {
+		return nil, errors.Errorf("bundled file references %d blobs", len(node.Content))
+	}
+	key := fmt.Sprintf("%s@%d+%d", contentKey(node.Content), node.Bundle.Offset, node.Size)
+	if ids, ok := r.contents[key]; ok {
+		return ids, nil
+	}
+
+	ids, err := r.saveChunks(ctx, func(ctx context.Context, wr io.Writer) error {
+		var err error
+		r.buf, err = r.srcRepo.LoadBlob(ctx, restic.DataBlob, node.Content[0], r.buf)
+		if err != nil {
+			return err
+		}
+		end := node.Bundle.Offset + node.Size
+		if end < node.Bundle.Offset || end > uint64(len(r.buf)) {
+			return errors.Errorf("file exceeds bundle %v", node.Content[0].Str())
+		}
+		_, err = wr.Write(r.buf[node.Bundle.Offset:end])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.contents[key] = ids
+	return ids, nil
+}
+
+// saveChunks splits the data written by write using the chunker polynomial
+// of the destination repository and returns the IDs of the saved chunks.
+func (r *rechunker) saveChunks(ctx context.Context, write func(ctx context.Context, wr io.Writer) error) (restic.IDs, error) {
+	rd, wr := io.Pipe()
+	wg, wgCtx := errgroup.WithContext(ctx)
+	wg.Go(func() error {
+		return wr.CloseWithError(write(wgCtx, wr))
+	})
+
+	var ids restic.IDs
+	wg.Go(func() error {
+		chnker := chunker.New(rd, r.dstRepo.Config().ChunkerPolynomial)
+		for {
+			chunk, err := chnker.Next(r.chunkBuf)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				// stop the reader
+				_ = rd.CloseWithError(err)
+				return err
+			}
+			r.chunkBuf = chunk.Data
+
+			id, _, _, err := r.dstRepo.SaveBlob(wgCtx, restic.DataBlob, chunk.Data, restic.ID{}, false)
+			if err != nil {
+				_ = rd.CloseWithError(err)
+				return err
+			}
+			ids = append(ids, id)
+		}
+	})
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func contentKey(content restic.IDs) string {
+	var sb strings.Builder
+	for _, id := range content {
+		sb.Write(id[:])
+	}
+	return sb.String()
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"path"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// snapshotPath returns the path within a snapshot at which the backup command
+// stores the target p, see pathComponents in the archiver.
+func snapshotPath(p string) string {
+	volume := filepath.VolumeName(p)
+	p = path.Clean("/" + filepath.ToSlash(p[len(volume):]))
+	if volume != "" {
+		// strip colon
+		if len(volume) == 2 && volume[1] == ':' {
+			volume = volume[:1]
+		}
+		p = path.Join("/", volume, p)
+	}
+	return p
+}
+
+// findSubtree returns the ID of the tree of the directory at the path within
+// the tree.
+func findSubtree(ctx context.Context, repo restic.BlobLoader, id restic.ID, p string) (restic.ID, error) {
+	for _, name := range splitTreePath(p) {
+		tree, err := restic.LoadTree(ctx, repo, id)
+		if err != nil {
+			return restic.ID{}, err
+		}
+
+		found := false
+		for _, node := range tree.Nodes {
+			if node.Name == name && node.Type == "dir" && node.Subtree != nil {
+				id = *node.Subtree
+				found = true
+				break
+			}
+		}
+		if !found {
+			return restic.ID{}, errors.Fatalf("directory %v not found in snapshot", p)
+		}
+	}
+	return id, nil
+}
+
+func splitTreePath(p string) []string {
+	var names []string
+	for p != "/" && p != "." {
+		names = append([]string{path.Base(p)}, names...)
+		p = path.Dir(p)
+	}
+	return names
+}
+
+// readLocalDir returns the nodes for the entries of the directory. Entries
+// which cannot be read are reported as a warning and returned in skipped.
+func readLocalDir(dir string) (tree *restic.Tree, skipped map[string]struct{}, err error) {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	_ = f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree = restic.NewTree(len(names))
+	skipped = make(map[string]struct{})
+	for _, name := range names {
+		filename := filepath.Join(dir, name)
+		fi, err := fs.Lstat(filename)
+		if err != nil {
+			Warnf("unable to stat %v: %v\n", filename, err)
+			skipped[name] = struct{}{}
+			continue
+		}
+
+		node, err := restic.NodeFromFileInfo(filename, fi)
+		if err != nil {
+			// the node is still usable, some of the metadata may be missing
+			Warnf("incomplete metadata for %v: %v\n", filename, err)
+		}
+		tree.Nodes = append(tree.Nodes, node)
+	}
+	return tree, skipped, nil
+}
+
+// sameLocalMetadata returns true if the metadata of the node in the snapshot
+// is the same as that of the local file. The access and change time, the
+// inode and the device ID are ignored, as they can change without any
+// modification and are not restored.
+func sameLocalMetadata(node, local *restic.Node) bool {
+	return node.Mode == local.Mode &&
+		node.ModTime.Equal(local.ModTime) &&
+		node.UID == local.UID &&
+		node.GID == local.GID &&
+		node.User == local.User &&
+		node.Group == local.Group &&
+		node.LinkTarget == local.LinkTarget &&
+		node.Device == local.Device &&
+		node.SameExtendedAttributes(*local)
+}
+
+// localContentChanged returns true if the contents of the local file differ
+// from the file in the snapshot. Like backup, a file whose size, modification
+// and change time and inode are unchanged is assumed to be unmodified.
+// Otherwise the SHA-256 hashes of the contents are compared.
+func (c *Comparer) localContentChanged(ctx context.Context, node, local *restic.Node, filename string) (bool, error) {
+	if node.Size != local.Size {
+		return true, nil
+	}
+	if node.ModTime.Equal(local.ModTime) && node.ChangeTime.Equal(local.ChangeTime) && node.Inode == local.Inode {
+		return false, nil
+	}
+
+	debug.Log("comparing contents of %v", filename)
+	f, err := fs.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	_ = f.Close()
+	if err != nil {
+		return false, err
+	}
+	var localHash restic.ID
+	h.Sum(localHash[:0])
+
+	hash, err := c.hasher.Hash(ctx, node)
+	if err != nil {
+		return false, err
+	}
+	return hash != localHash, nil
+}
+
+// printLocalDir prints all entries of the local directory as added.
+func (c *Comparer) printLocalDir(stats *DiffStat, prefix string, dir string) error {
+	tree, _, err := readLocalDir(dir)
+	if err != nil {
+		return err
+	}
+	_, nodes, names := uniqueNodeNames(restic.NewTree(0), tree)
+
+	for _, name := range names {
+		node := nodes[name]
+		nodepath := path.Join(prefix, name)
+		if node.Type == "dir" {
+			nodepath += "/"
+		}
+		c.printChange(NewChange(nodepath, "+"))
+		stats.Add(node)
+
+		if node.Type == "dir" {
+			err := c.printLocalDir(stats, nodepath, filepath.Join(dir, name))
+			if err != nil {
+				Warnf("error: %v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+// diffLocal compares the tree in the snapshot with the local directory.
+func (c *Comparer) diffLocal(ctx context.Context, stats *DiffStatsContainer, prefix string, id restic.ID, dir string) error {
+	debug.Log("diffing %v to %v", id, dir)
+	tree, err := restic.LoadTree(ctx, c.repo, id)
+	if err != nil {
+		return err
+	}
+
+	local, skipped, err := readLocalDir(dir)
+	if err != nil {
+		return err
+	}
+
+	treeNodes, localNodes, names := uniqueNodeNames(tree, local)
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, ok := skipped[name]; ok {
+			continue
+		}
+
+		node1, t1 := treeNodes[name]
+		node2, t2 := localNodes[name]
+		filename := filepath.Join(dir, name)
+
+		switch {
+		case t1 && t2:
+			name := path.Join(prefix, name)
+			mod := ""
+
+			if node1.Type != node2.Type {
+				mod += "T"
+			}
+
+			if node2.Type == "dir" {
+				name += "/"
+			}
+
+			changed := false
+			if node1.Type == "file" && node2.Type == "file" {
+				changed, err = c.localContentChanged(ctx, node1, node2, filename)
+				if err != nil {
+					Warnf("unable to compare %v: %v\n", filename, err)
+				}
+			}
+			if changed {
+				mod += "M"
+				stats.ChangedFiles++
+			} else if c.opts.ShowMetadata && !sameLocalMetadata(node1, node2) {
+				mod += "U"
+			}
+
+			if mod != "" {
+				c.printChange(NewChange(name, mod))
+			}
+
+			if node1.Type == "dir" && node2.Type == "dir" {
+				err := c.diffLocal(ctx, stats, name, *node1.Subtree, filename)
+				if err != nil {
+					Warnf("error: %v\n", err)
+				}
+			}
+		case t1 && !t2:
+			prefix := path.Join(prefix, name)
+			if node1.Type == "dir" {
+				prefix += "/"
+			}
+			c.printChange(NewChange(prefix, "-"))
+			stats.Removed.Add(node1)
+
+			if node1.Type == "dir" {
+				err := c.printDir(ctx, "-", &stats.Removed, stats.BlobsBefore, prefix, *node1.Subtree)
+				if err != nil {
+					Warnf("error: %v\n", err)
+				}
+			}
+		case !t1 && t2:
+			prefix := path.Join(prefix, name)
+			if node2.Type == "dir" {
+				prefix += "/"
+			}
+			c.printChange(NewChange(prefix, "+"))
+			stats.Added.Add(node2)
+
+			if node2.Type == "dir" {
+				err := c.printLocalDir(&stats.Added, prefix, filename)
+				if err != nil {
+					Warnf("error: %v\n", err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
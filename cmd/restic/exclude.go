@@ -5,17 +5,23 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"os/user"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/textfile"
 	"github.com/spf13/pflag"
 )
@@ -339,6 +345,39 @@ func rejectByDevice(samples []string) (RejectFunc, error) {
 	}, nil
 }
 
+// targetDirs contains the absolute paths of the backup targets.
+type targetDirs []string
+
+func newTargetDirs(targets []string) (targetDirs, error) {
+	dirs := make(targetDirs, 0, len(targets))
+	for _, target := range targets {
+		target, err := filepath.Abs(target)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, target)
+	}
+	return dirs, nil
+}
+
+// base returns the innermost target which is item or contains it.
+func (dirs targetDirs) base(item string) (string, bool) {
+	var base string
+	for _, target := range dirs {
+		if len(target) > len(base) && fs.HasPathPrefix(target, item) {
+			base = target
+		}
+	}
+	return base, base != ""
+}
+
+// contains returns whether item is one of the targets or is located within
+// one of them.
+func (dirs targetDirs) contains(item string) bool {
+	_, ok := dirs.base(item)
+	return ok
+}
+
 // rejectResticCache returns a RejectByNameFunc that rejects the restic cache
 // directory (if set).
 func rejectResticCache(repo *repository.Repository) (RejectByNameFunc, error) {
@@ -385,6 +424,135 @@ func rejectBySize(maxSizeStr string) (RejectFunc, error) {
 	}, nil
 }
 
+func rejectBySmallerSize(minSizeStr string) (RejectFunc, error) {
+	minSize, err := parseSizeStr(minSizeStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		// directory will be ignored
+		if fi.IsDir() {
+			return false
+		}
+
+		filesize := fi.Size()
+		if filesize < minSize {
+			debug.Log("file %s is undersize: %d", item, filesize)
+			return true
+		}
+
+		return false
+	}, nil
+}
+
+// rejectByAge returns a RejectFunc which rejects files which were last
+// modified longer than maxAge before now. Directories are never rejected, as
+// their modification time does not reflect the age of their contents.
+func rejectByAge(maxAge restic.Duration, now time.Time) RejectFunc {
+	cutoff := now.AddDate(-maxAge.Years, -maxAge.Months, -maxAge.Days).Add(time.Hour * time.Duration(-maxAge.Hours))
+
+	return func(item string, fi os.FileInfo) bool {
+		if fi.IsDir() {
+			return false
+		}
+
+		if fi.ModTime().Before(cutoff) {
+			debug.Log("file %s is older than %v: %v", item, maxAge, fi.ModTime())
+			return true
+		}
+
+		return false
+	}
+}
+
+// rejectByOwner returns a RejectFunc which rejects files and directories
+// owned by one of the users, which are given as user name or numeric user ID.
+func rejectByOwner(owners []string) (RejectFunc, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.Fatal("--exclude-owner is not supported on Windows")
+	}
+
+	uids := make(map[uint32]struct{})
+	for _, owner := range owners {
+		uid, err := strconv.ParseUint(owner, 10, 32)
+		if err != nil {
+			u, lookupErr := user.Lookup(owner)
+			if lookupErr != nil {
+				return nil, errors.Fatalf("invalid owner %q: %v", owner, lookupErr)
+			}
+			uid, err = strconv.ParseUint(u.Uid, 10, 32)
+			if err != nil {
+				return nil, errors.Fatalf("invalid user ID %q of %v", u.Uid, owner)
+			}
+		}
+		uids[uint32(uid)] = struct{}{}
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		uid := fs.ExtendedStat(fi).UID
+		if _, ok := uids[uid]; ok {
+			debug.Log("item %s is owned by %d", item, uid)
+			return true
+		}
+
+		return false
+	}, nil
+}
+
+// rejectByMIME returns a RejectFunc which rejects files whose content type
+// matches one of the patterns, e.g. "video/*". The content type is detected
+// from the first bytes of each file, see http.DetectContentType.
+func rejectByMIME(patterns []string) (RejectFunc, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.Contains(pattern, "/") {
+			return nil, errors.Fatalf("invalid MIME type pattern %q", pattern)
+		}
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		if !fi.Mode().IsRegular() {
+			return false
+		}
+
+		mimeType, err := detectMIMEType(item)
+		if err != nil {
+			debug.Log("unable to detect MIME type of %v: %v", item, err)
+			return false
+		}
+
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, mimeType); ok {
+				debug.Log("file %s has excluded MIME type %v", item, mimeType)
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// detectMIMEType returns the content type of the file without parameters like
+// the charset.
+func detectMIMEType(filename string) (string, error) {
+	f, err := fs.OpenFile(filename, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// DetectContentType considers at most 512 bytes
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	mimeType, _, _ := strings.Cut(http.DetectContentType(buf[:n]), ";")
+	return strings.TrimSpace(mimeType), nil
+}
+
 func parseSizeStr(sizeStr string) (int64, error) {
 	if sizeStr == "" {
 		return 0, errors.New("expected size, got empty string")
@@ -479,6 +647,15 @@ func (opts *excludePatternOptions) Empty() bool {
 	return len(opts.Excludes) == 0 && len(opts.InsensitiveExcludes) == 0 && len(opts.ExcludeFiles) == 0 && len(opts.InsensitiveExcludeFiles) == 0
 }
 
+// ignoreCase returns the options with all patterns changed to ignore the
+// casing of filenames.
+func (opts excludePatternOptions) ignoreCase() excludePatternOptions {
+	return excludePatternOptions{
+		InsensitiveExcludes:     append(append([]string{}, opts.InsensitiveExcludes...), opts.Excludes...),
+		InsensitiveExcludeFiles: append(append([]string{}, opts.InsensitiveExcludeFiles...), opts.ExcludeFiles...),
+	}
+}
+
 func (opts excludePatternOptions) CollectPatterns() ([]RejectByNameFunc, error) {
 	var fs []RejectByNameFunc
 	// add patterns from file
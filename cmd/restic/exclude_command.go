@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// excludeCommand asks a long-running helper process whether items should be
+// excluded. For each item, the absolute path is written as a line to the
+// stdin of the helper, which must reply with a line containing either
+// "exclude" or "include".
+type excludeCommand struct {
+	command string
+
+	m      sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	// err is set once the helper failed, all remaining items are included
+	err error
+}
+
+func startExcludeCommand(ctx context.Context, gopts GlobalOptions, command string) (*excludeCommand, error) {
+	args, err := backend.SplitShellStrings(command)
+	if err != nil {
+		return nil, errors.Fatalf("--exclude-if-command: %v", err)
+	}
+	if len(args) == 0 {
+		return nil, errors.Fatal("--exclude-if-command: empty command")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = gopts.stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Fatalf("--exclude-if-command: %v", err)
+	}
+	debug.Log("started exclude command %q", command)
+
+	return &excludeCommand{
+		command: command,
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  bufio.NewReader(stdout),
+	}, nil
+}
+
+// RejectByName asks the helper whether item should be excluded. If the helper
+// fails, a warning is printed and all items are included.
+func (c *excludeCommand) RejectByName(item string) bool {
+	if strings.ContainsAny(item, "\r\n") {
+		// cannot be sent to the helper as a single line
+		debug.Log("including %q without asking the exclude command", item)
+		return false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.err != nil {
+		return false
+	}
+
+	exclude, err := c.ask(item)
+	if err != nil {
+		c.err = err
+		Warnf("--exclude-if-command %q failed, including all remaining files: %v\n", c.command, err)
+		return false
+	}
+	if exclude {
+		debug.Log("path %q excluded by exclude command", item)
+	}
+	return exclude
+}
+
+func (c *excludeCommand) ask(item string) (bool, error) {
+	if _, err := fmt.Fprintln(c.stdin, item); err != nil {
+		return false, err
+	}
+
+	answer, err := c.stdout.ReadString('\n')
+	if err != nil {
+		return false, errors.Errorf("no answer for %v: %v", item, err)
+	}
+
+	switch strings.TrimSpace(answer) {
+	case "exclude":
+		return true, nil
+	case "include":
+		return false, nil
+	default:
+		return false, errors.Errorf("invalid answer %q for %v", strings.TrimSpace(answer), item)
+	}
+}
+
+// Close stops the helper by closing its stdin and waits for it to exit.
+func (c *excludeCommand) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	_ = c.stdin.Close()
+	err := c.cmd.Wait()
+	if c.err != nil {
+		// already reported
+		return nil
+	}
+	if err != nil {
+		return errors.Fatalf("--exclude-if-command %q failed: %v", c.command, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/textfile"
+)
+
+const gitignoreFilename = ".gitignore"
+
+// gitignoreExcludes rejects items which are ignored according to patterns in
+// gitignore syntax. As for git, the patterns of a .gitignore file take
+// precedence over those in the directories containing it, and the patterns
+// read from exclude files have the lowest precedence.
+type gitignoreExcludes struct {
+	targets targetDirs
+
+	// patterns are relative to the backup targets
+	patterns []filter.GitignorePattern
+
+	// readFiles enables reading .gitignore files
+	readFiles bool
+	m         sync.Mutex
+	dirs      map[string][]filter.GitignorePattern
+}
+
+func newGitignoreExcludes(excludeFiles []string, readFiles bool, targets []string) (*gitignoreExcludes, error) {
+	dirs, err := newTargetDirs(targets)
+	if err != nil {
+		return nil, err
+	}
+	g := &gitignoreExcludes{
+		targets:   dirs,
+		readFiles: readFiles,
+		dirs:      make(map[string][]filter.GitignorePattern),
+	}
+
+	for _, filename := range excludeFiles {
+		patterns, err := readGitignore(filename)
+		if err != nil {
+			return nil, errors.Fatalf("--exclude-file-gitignore: %v", err)
+		}
+		g.patterns = append(g.patterns, patterns...)
+	}
+	return g, nil
+}
+
+func readGitignore(filename string) ([]filter.GitignorePattern, error) {
+	data, err := textfile.Read(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	patterns, err := filter.ParseGitignore(lines)
+	if err != nil {
+		return nil, errors.Wrap(err, filename)
+	}
+	return patterns, nil
+}
+
+// load returns the patterns of the .gitignore file in dir.
+func (g *gitignoreExcludes) load(dir string) []filter.GitignorePattern {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if patterns, ok := g.dirs[dir]; ok {
+		return patterns
+	}
+
+	patterns, err := readGitignore(filepath.Join(dir, gitignoreFilename))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		Warnf("ignoring .gitignore file: %v\n", err)
+	}
+	g.dirs[dir] = patterns
+	return patterns
+}
+
+// match checks item against patterns relative to dir.
+func (g *gitignoreExcludes) match(patterns []filter.GitignorePattern, dir, item string, isDir bool) (ignored, matched bool) {
+	if len(patterns) == 0 {
+		return false, false
+	}
+	rel, err := filepath.Rel(dir, item)
+	if err != nil || rel == "." {
+		return false, false
+	}
+
+	ignored, matched, err = filter.MatchGitignore(patterns, filepath.ToSlash(rel), isDir)
+	if err != nil {
+		Warnf("error for gitignore pattern: %v", err)
+	}
+	return ignored, matched
+}
+
+// Reject rejects items which are ignored by the gitignore patterns.
+func (g *gitignoreExcludes) Reject(item string, fi os.FileInfo) bool {
+	if g.readFiles {
+		for dir := filepath.Dir(item); g.targets.contains(dir); dir = filepath.Dir(dir) {
+			ignored, matched := g.match(g.load(dir), dir, item, fi.IsDir())
+			if matched {
+				if ignored {
+					debug.Log("path %q ignored by %v", item, filepath.Join(dir, gitignoreFilename))
+				}
+				return ignored
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+
+	base, ok := g.targets.base(item)
+	if !ok {
+		return false
+	}
+	ignored, _ := g.match(g.patterns, base, item, fi.IsDir())
+	return ignored
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/textfile"
+)
+
+// dirPolicy contains the directives of a policy file, which apply to all
+// items within the directory containing the file.
+type dirPolicy struct {
+	// excludes are matched against the path relative to the directory
+	excludes []string
+	reread   bool
+
+	oneFileSystem bool
+	deviceID      uint64
+}
+
+// parseDirPolicy parses the content of the policy file filename. Invalid
+// lines are reported as warnings and ignored.
+func parseDirPolicy(filename string, data []byte) *dirPolicy {
+	p := &dirPolicy{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		directive, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch {
+		case directive == "exclude" && arg != "":
+			if err := filter.ValidatePatterns([]string{arg}); err != nil {
+				Warnf("%v:%d: %v\n", filename, lineNo, err)
+				continue
+			}
+			p.excludes = append(p.excludes, arg)
+		case directive == "reread" && arg == "":
+			p.reread = true
+		case directive == "one-file-system" && arg == "":
+			p.oneFileSystem = true
+		default:
+			Warnf("%v:%d: invalid directive %q\n", filename, lineNo, line)
+		}
+	}
+	return p
+}
+
+// policyFiles loads the policy files with a given name from the directories
+// within the backup targets. Each directory is only checked once.
+type policyFiles struct {
+	name    string
+	targets targetDirs
+
+	m    sync.Mutex
+	dirs map[string]*dirPolicy
+}
+
+func newPolicyFiles(name string, targets []string) (*policyFiles, error) {
+	if name == "" || strings.ContainsRune(name, filepath.Separator) {
+		return nil, errors.Fatalf("invalid name for policy files: %q", name)
+	}
+
+	dirs, err := newTargetDirs(targets)
+	if err != nil {
+		return nil, err
+	}
+	return &policyFiles{name: name, targets: dirs, dirs: make(map[string]*dirPolicy)}, nil
+}
+
+// load returns the policy of dir, or nil if it does not contain a policy
+// file. The caller must hold p.m.
+func (p *policyFiles) load(dir string) *dirPolicy {
+	if policy, ok := p.dirs[dir]; ok {
+		return policy
+	}
+
+	var policy *dirPolicy
+	filename := filepath.Join(dir, p.name)
+	data, err := textfile.Read(filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		Warnf("could not read policy file: %v\n", err)
+	default:
+		debug.Log("using policy file %v", filename)
+		policy = parseDirPolicy(filename, data)
+	}
+
+	if policy != nil && policy.oneFileSystem {
+		id, err := deviceIDOf(dir)
+		if err != nil {
+			Warnf("%v: ignoring one-file-system: %v\n", filename, err)
+			policy.oneFileSystem = false
+		}
+		policy.deviceID = id
+	}
+
+	p.dirs[dir] = policy
+	return policy
+}
+
+func deviceIDOf(item string) (uint64, error) {
+	fi, err := fs.Lstat(item)
+	if err != nil {
+		return 0, err
+	}
+	return fs.DeviceID(fi)
+}
+
+// dirPolicyRef is the policy of the directory dir.
+type dirPolicyRef struct {
+	dir string
+	*dirPolicy
+}
+
+// policies returns the policies of all directories containing item, which is
+// an absolute path.
+func (p *policyFiles) policies(item string) []dirPolicyRef {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var list []dirPolicyRef
+	for dir := filepath.Dir(item); p.targets.contains(dir); dir = filepath.Dir(dir) {
+		if policy := p.load(dir); policy != nil {
+			list = append(list, dirPolicyRef{dir, policy})
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return list
+}
+
+// RejectByName rejects items which match an exclude pattern of the policy
+// files in the directories containing them.
+func (p *policyFiles) RejectByName(item string) bool {
+	for _, policy := range p.policies(item) {
+		if len(policy.excludes) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(policy.dir, item)
+		if err != nil {
+			continue
+		}
+		rel = "/" + filepath.ToSlash(rel)
+		for _, pattern := range policy.excludes {
+			matched, err := filter.Match(pattern, rel)
+			if err != nil {
+				Warnf("error for exclude pattern: %v", err)
+			}
+			if matched {
+				debug.Log("path %q excluded by policy file in %v", item, policy.dir)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reject rejects items which are located on a different file system than a
+// directory containing them whose policy file contains one-file-system.
+// Mountpoints are kept as empty directories.
+func (p *policyFiles) Reject(item string, fi os.FileInfo) bool {
+	var parentID *uint64
+	for _, policy := range p.policies(item) {
+		if !policy.oneFileSystem {
+			continue
+		}
+		id, err := fs.DeviceID(fi)
+		if err != nil || id == policy.deviceID {
+			continue
+		}
+		if !fi.IsDir() {
+			return true
+		}
+
+		// keep mountpoints, whose parent directory is on the allowed device
+		if parentID == nil {
+			id, err := deviceIDOf(filepath.Dir(item))
+			if err != nil {
+				debug.Log("item %v: getting device ID of parent directory: %v", item, err)
+				// if in doubt, reject
+				return true
+			}
+			parentID = &id
+		}
+		if *parentID != policy.deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// Reread returns whether a directory containing item has a policy file which
+// contains reread.
+func (p *policyFiles) Reread(item string) bool {
+	for _, policy := range p.policies(item) {
+		if policy.reread {
+			return true
+		}
+	}
+	return false
+}
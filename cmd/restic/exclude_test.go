@@ -3,8 +3,12 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/test"
 )
 
@@ -358,3 +362,177 @@ func TestDeviceMap(t *testing.T) {
 		})
 	}
 }
+
+func TestPolicyFiles(t *testing.T) {
+	tempDir := test.TempDir(t)
+
+	files := map[string]string{
+		"project/.resticpolicy":            "# build output\nexclude /build\nexclude *.o\n",
+		"project/build/out":                "",
+		"project/src/main.c":               "",
+		"project/src/main.o":               "",
+		"project/src/build/notes":          "",
+		"project/vendor/.resticpolicy":     "reread\n",
+		"project/vendor/lib.c":             "",
+		"other/main.o":                     "",
+		"other/invalid/.resticpolicy":      "exclude\nfoo bar\n",
+		"other/invalid/foo":                "",
+		"outside/.resticpolicy":            "exclude *\n",
+		"outside/target/file":              "",
+		"outside/target/sub/.resticpolicy": "",
+	}
+	for name, content := range files {
+		filename := filepath.Join(tempDir, filepath.FromSlash(name))
+		test.OK(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		test.OK(t, os.WriteFile(filename, []byte(content), 0644))
+	}
+
+	policies, err := newPolicyFiles(".resticpolicy", []string{
+		filepath.Join(tempDir, "project"),
+		filepath.Join(tempDir, "other"),
+		filepath.Join(tempDir, "outside", "target"),
+	})
+	test.OK(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		excluded bool
+		reread   bool
+	}{
+		{"project/build", true, false},
+		{"project/src/main.c", false, false},
+		{"project/src/main.o", true, false},
+		{"project/src/build/notes", false, false},
+		{"project/vendor/lib.c", false, true},
+		{"other/main.o", false, false},
+		{"other/invalid/foo", false, false},
+		{"outside/target/file", false, false},
+	} {
+		item := filepath.Join(tempDir, filepath.FromSlash(tc.name))
+		if got := policies.RejectByName(item); got != tc.excluded {
+			t.Errorf("RejectByName(%v) returned %v, want %v", tc.name, got, tc.excluded)
+		}
+		if got := policies.Reread(item); got != tc.reread {
+			t.Errorf("Reread(%v) returned %v, want %v", tc.name, got, tc.reread)
+		}
+	}
+}
+
+func TestRejectBySmallerSize(t *testing.T) {
+	tempDir := test.TempDir(t)
+
+	for name, size := range map[string]int64{"small": 100, "large": 2048} {
+		test.OK(t, os.WriteFile(filepath.Join(tempDir, name), make([]byte, size), 0600))
+	}
+
+	reject, err := rejectBySmallerSize("1k")
+	test.OK(t, err)
+
+	for name, excluded := range map[string]bool{"small": true, "large": false, ".": false} {
+		p := filepath.Join(tempDir, name)
+		fi, err := os.Lstat(p)
+		test.OK(t, err)
+		if got := reject(p, fi); got != excluded {
+			t.Errorf("exclusion status of %v is wrong: want %v, got %v", name, excluded, got)
+		}
+	}
+}
+
+func TestRejectByAge(t *testing.T) {
+	tempDir := test.TempDir(t)
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, mtime := range map[string]time.Time{
+		"old":    now.AddDate(-2, 0, -1),
+		"recent": now.AddDate(-1, -11, 0),
+		"dir":    now.AddDate(-5, 0, 0),
+	} {
+		p := filepath.Join(tempDir, name)
+		if name == "dir" {
+			test.OK(t, os.Mkdir(p, 0700))
+		} else {
+			test.OK(t, os.WriteFile(p, []byte(name), 0600))
+		}
+		test.OK(t, os.Chtimes(p, mtime, mtime))
+	}
+
+	reject := rejectByAge(restic.ParseDurationOrPanic("2y"), now)
+
+	for name, excluded := range map[string]bool{"old": true, "recent": false, "dir": false} {
+		p := filepath.Join(tempDir, name)
+		fi, err := os.Lstat(p)
+		test.OK(t, err)
+		if got := reject(p, fi); got != excluded {
+			t.Errorf("exclusion status of %v is wrong: want %v, got %v", name, excluded, got)
+		}
+	}
+}
+
+func TestRejectByOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file owners are not supported on Windows")
+	}
+
+	tempDir := test.TempDir(t)
+	p := filepath.Join(tempDir, "file")
+	test.OK(t, os.WriteFile(p, []byte("foo"), 0600))
+	fi, err := os.Lstat(p)
+	test.OK(t, err)
+
+	uid := strconv.Itoa(os.Getuid())
+	for _, tc := range []struct {
+		owners   []string
+		excluded bool
+	}{
+		{[]string{uid}, true},
+		{[]string{"4294967294", uid}, true},
+		{[]string{"4294967294"}, false},
+	} {
+		reject, err := rejectByOwner(tc.owners)
+		test.OK(t, err)
+		if got := reject(p, fi); got != tc.excluded {
+			t.Errorf("owners %v: want excluded %v, got %v", tc.owners, tc.excluded, got)
+		}
+	}
+
+	_, err = rejectByOwner([]string{"no-such-user-for-restic"})
+	test.Assert(t, err != nil, "expected error for unknown user")
+}
+
+func TestRejectByMIME(t *testing.T) {
+	tempDir := test.TempDir(t)
+
+	files := map[string][]byte{
+		// the extensions do not match the content on purpose
+		"movie.txt":   append([]byte("\x1A\x45\xDF\xA3"), make([]byte, 100)...),
+		"archive.dat": []byte("\x1F\x8B\x08\x00rest of the data"),
+		"notes.mkv":   []byte("just some text\n"),
+		"empty":       nil,
+	}
+	for name, data := range files {
+		test.OK(t, os.WriteFile(filepath.Join(tempDir, name), data, 0600))
+	}
+
+	reject, err := rejectByMIME([]string{"video/*", "application/x-gzip"})
+	test.OK(t, err)
+
+	for name, excluded := range map[string]bool{
+		"movie.txt":   true,
+		"archive.dat": true,
+		"notes.mkv":   false,
+		"empty":       false,
+		".":           false,
+	} {
+		p := filepath.Join(tempDir, name)
+		fi, err := os.Lstat(p)
+		test.OK(t, err)
+		if got := reject(p, fi); got != excluded {
+			t.Errorf("exclusion status of %v is wrong: want %v, got %v", name, excluded, got)
+		}
+	}
+
+	for _, pattern := range []string{"video", "video/[", ""} {
+		_, err := rejectByMIME([]string{pattern})
+		test.Assert(t, err != nil, "expected error for invalid pattern %q", pattern)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -16,21 +18,28 @@ import (
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/azure"
 	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/failover"
+	"github.com/restic/restic/internal/backend/gdrive"
 	"github.com/restic/restic/internal/backend/gs"
 	"github.com/restic/restic/internal/backend/limiter"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/location"
 	"github.com/restic/restic/internal/backend/logger"
+	"github.com/restic/restic/internal/backend/metrics"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/plugin"
 	"github.com/restic/restic/internal/backend/rclone"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/retry"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sema"
 	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/smb"
+	"github.com/restic/restic/internal/backend/split"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/debug"
-	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/options"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -57,6 +66,7 @@ type GlobalOptions struct {
 	RepositoryFile  string
 	PasswordFile    string
 	PasswordCommand string
+	IdentityFile    string
 	KeyHint         string
 	Quiet           bool
 	Verbose         int
@@ -64,13 +74,22 @@ type GlobalOptions struct {
 	RetryLock       time.Duration
 	JSON            bool
 	CacheDir        string
+	CacheMaxSize    string
+	CacheShared     bool
 	NoCache         bool
 	CleanupCache    bool
+	IgnoreCase      bool
 	Compression     repository.CompressionMode
 	PackSize        uint
+	Index           repository.IndexMode
+	BackendStats    bool
+	ConfigFile      string
+	Profile         string
 
 	backend.TransportOptions
 	limiter.Limits
+	OperationLimits
+	LimitSchedule string
 
 	password string
 	stdout   io.Writer
@@ -78,6 +97,10 @@ type GlobalOptions struct {
 
 	backendTestHook, backendInnerTestHook backendWrapper
 
+	// backendMetrics records the requests to all backends if --backend-stats
+	// is set
+	backendMetrics *metrics.Collector
+
 	// verbosity is set as follows:
 	//  0 means: don't print any messages except errors, this is used when --quiet is specified
 	//  1 is the default: print essential messages
@@ -90,6 +113,44 @@ type GlobalOptions struct {
 	extended options.Options
 }
 
+// OperationLimits contains bandwidth limits which only apply to specific
+// commands and take precedence over the global limits. Zero means the global
+// limit is used.
+type OperationLimits struct {
+	Backup  limiter.Limits
+	Restore limiter.Limits
+	Prune   limiter.Limits
+}
+
+// limitsFor returns the bandwidth limits for running the command with the
+// given name.
+func (l OperationLimits) limitsFor(command string, global limiter.Limits) limiter.Limits {
+	var op limiter.Limits
+	switch command {
+	case "backup":
+		op = l.Backup
+	case "restore":
+		op = l.Restore
+	case "prune", "maintain":
+		op = l.Prune
+	case "forget":
+		if !forgetOptions.Prune {
+			return global
+		}
+		op = l.Prune
+	default:
+		return global
+	}
+
+	if op.UploadKb != 0 {
+		global.UploadKb = op.UploadKb
+	}
+	if op.DownloadKb != 0 {
+		global.DownloadKb = op.DownloadKb
+	}
+	return global
+}
+
 var globalOptions = GlobalOptions{
 	stdout: os.Stdout,
 	stderr: os.Stderr,
@@ -114,23 +175,44 @@ func init() {
 	f.StringVarP(&globalOptions.PasswordFile, "password-file", "p", "", "`file` to read the repository password from (default: $RESTIC_PASSWORD_FILE)")
 	f.StringVarP(&globalOptions.KeyHint, "key-hint", "", "", "`key` ID of key to try decrypting first (default: $RESTIC_KEY_HINT)")
 	f.StringVarP(&globalOptions.PasswordCommand, "password-command", "", "", "shell `command` to obtain the repository password from (default: $RESTIC_PASSWORD_COMMAND)")
+	f.StringVar(&globalOptions.IdentityFile, "identity-file", "", "`file` to read the identity from, which opens the repository instead of a password (default: $RESTIC_IDENTITY_FILE)")
 	f.BoolVarP(&globalOptions.Quiet, "quiet", "q", false, "do not output comprehensive progress report")
 	// use empty paremeter name as `-v, --verbose n` instead of the correct `--verbose=n` is confusing
 	f.CountVarP(&globalOptions.Verbose, "verbose", "v", "be verbose (specify multiple times or a level using --verbose=n``, max level/times is 2)")
 	f.BoolVar(&globalOptions.NoLock, "no-lock", false, "do not lock the repository, this allows some operations on read-only repositories")
 	f.DurationVar(&globalOptions.RetryLock, "retry-lock", 0, "retry to lock the repository if it is already locked, takes a value like 5m or 2h (default: no retries)")
 	f.BoolVarP(&globalOptions.JSON, "json", "", false, "set output mode to JSON for commands that support it")
+	f.BoolVar(&globalOptions.BackendStats, "backend-stats", false, "print statistics about the backend requests to stderr when the command finishes")
 	f.StringVar(&globalOptions.CacheDir, "cache-dir", "", "set the cache `directory`. (default: use system default cache directory)")
+	f.StringVar(&globalOptions.CacheMaxSize, "cache-max-size", "", "limit the cache of each repository to `size`, removing the least recently used files (allowed suffixes: k/K, m/M, g/G, t/T) (default: unlimited)")
+	f.BoolVar(&globalOptions.CacheShared, "cache-shared", false, "make new cache directories and files accessible to the group owning the cache directory")
 	f.BoolVar(&globalOptions.NoCache, "no-cache", false, "do not use a local cache")
 	f.StringSliceVar(&globalOptions.RootCertFilenames, "cacert", nil, "`file` to load root certificates from (default: use system certificates)")
 	f.StringVar(&globalOptions.TLSClientCertKeyFilename, "tls-client-cert", "", "path to a `file` containing PEM encoded TLS client certificate and private key")
 	f.BoolVar(&globalOptions.InsecureTLS, "insecure-tls", false, "skip TLS certificate verification when connecting to the repository (insecure)")
+	f.StringVar(&globalOptions.Proxy, "proxy", "", "proxy `URL` for HTTP based backends (http, https or socks5), or 'direct' to not use a proxy (default: $HTTPS_PROXY or $HTTP_PROXY)")
 	f.BoolVar(&globalOptions.CleanupCache, "cleanup-cache", false, "auto remove old cache directories")
+	f.BoolVar(&globalOptions.IgnoreCase, "ignore-case", false, "ignore the casing of filenames in all include and exclude patterns and in paths passed to dump")
 	f.Var(&globalOptions.Compression, "compression", "compression mode (only available for repository format version 2), one of (auto|off|max) (default: $RESTIC_COMPRESSION)")
+	f.Var(&globalOptions.Index, "index", "keep the loaded index in memory or on disk, one of (memory|disk), disk uses less memory but is slower")
 	f.IntVar(&globalOptions.Limits.UploadKb, "limit-upload", 0, "limits uploads to a maximum `rate` in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.Limits.DownloadKb, "limit-download", 0, "limits downloads to a maximum `rate` in KiB/s. (default: unlimited)")
+	for _, op := range []struct {
+		name   string
+		limits *limiter.Limits
+	}{
+		{"backup", &globalOptions.OperationLimits.Backup},
+		{"restore", &globalOptions.OperationLimits.Restore},
+		{"prune", &globalOptions.OperationLimits.Prune},
+	} {
+		f.IntVar(&op.limits.UploadKb, "limit-upload-"+op.name, 0, "limits uploads of "+op.name+" to a maximum `rate` in KiB/s, -1 for unlimited (default: --limit-upload)")
+		f.IntVar(&op.limits.DownloadKb, "limit-download-"+op.name, 0, "limits downloads of "+op.name+" to a maximum `rate` in KiB/s, -1 for unlimited (default: --limit-download)")
+	}
+	f.StringVar(&globalOptions.LimitSchedule, "limit-schedule", "", "limit uploads and downloads depending on the time of day, e.g. `08:00-18:00=5M,18:00-08:00=0` (rates in KiB/s, upload/download can be set separately)")
 	f.UintVar(&globalOptions.PackSize, "pack-size", 0, "set target pack `size` in MiB, created pack files may be larger (default: $RESTIC_PACK_SIZE)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
+	f.StringVar(&globalOptions.ConfigFile, "config-file", "", "read profiles from `file` (default: $RESTIC_CONFIG_FILE or restic/restic.yaml in the user config directory)")
+	f.StringVarP(&globalOptions.Profile, "profile", "P", "", "use the options of the `profile` from the config file (default: $RESTIC_PROFILE)")
 	// Use our "generate" command instead of the cobra provided "completion" command
 	cmdRoot.CompletionOptions.DisableDefaultCmd = true
 
@@ -139,6 +221,9 @@ func init() {
 	globalOptions.PasswordFile = os.Getenv("RESTIC_PASSWORD_FILE")
 	globalOptions.KeyHint = os.Getenv("RESTIC_KEY_HINT")
 	globalOptions.PasswordCommand = os.Getenv("RESTIC_PASSWORD_COMMAND")
+	globalOptions.IdentityFile = os.Getenv("RESTIC_IDENTITY_FILE")
+	globalOptions.ConfigFile = os.Getenv("RESTIC_CONFIG_FILE")
+	globalOptions.Profile = os.Getenv("RESTIC_PROFILE")
 	comp := os.Getenv("RESTIC_COMPRESSION")
 	if comp != "" {
 		// ignore error as there's no good way to handle it
@@ -430,18 +515,29 @@ func OpenRepository(ctx context.Context, opts GlobalOptions) (*repository.Reposi
 		return nil, err
 	}
 
+	retryCfg := retry.NewConfig()
+	if err := opts.extended.Extract("retry").Apply("retry", &retryCfg); err != nil {
+		return nil, err
+	}
+	if err := retryCfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	be, err := open(ctx, repo, opts, opts.extended)
 	if err != nil {
 		return nil, err
 	}
 
 	report := func(msg string, err error, d time.Duration) {
+		if opts.backendMetrics != nil {
+			opts.backendMetrics.AddRetry(retryOperation(msg))
+		}
 		Warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
 	}
 	success := func(msg string, retries int) {
 		Warnf("%v operation successful after %d retries\n", msg, retries)
 	}
-	be = retry.New(be, 10, report, success)
+	be = retry.NewWithConfig(be, retryCfg, report, success)
 
 	// wrap backend if a test specified a hook
 	if opts.backendTestHook != nil {
@@ -454,6 +550,7 @@ func OpenRepository(ctx context.Context, opts GlobalOptions) (*repository.Reposi
 	s, err := repository.New(be, repository.Options{
 		Compression: opts.Compression,
 		PackSize:    opts.PackSize * 1024 * 1024,
+		Index:       opts.Index,
 	})
 	if err != nil {
 		return nil, err
@@ -464,6 +561,19 @@ func OpenRepository(ctx context.Context, opts GlobalOptions) (*repository.Reposi
 		passwordTriesLeft = 3
 	}
 
+	if opts.IdentityFile != "" {
+		// the identity replaces the password
+		passwordTriesLeft = 0
+		identity, err := loadIdentity(opts.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		err = s.SearchIdentity(ctx, identity, maxKeys, opts.KeyHint)
+		if errors.Is(err, repository.ErrNoKeyFound) {
+			return nil, errors.Fatalf("no key for identity %v found", opts.IdentityFile)
+		}
+	}
+
 	for ; passwordTriesLeft > 0; passwordTriesLeft-- {
 		opts.password, err = ReadPassword(opts, "enter password for repository: ")
 		if err != nil && passwordTriesLeft > 1 {
@@ -487,6 +597,10 @@ func OpenRepository(ctx context.Context, opts GlobalOptions) (*repository.Reposi
 		return nil, errors.Fatalf("%s", err)
 	}
 
+	if err := applyRepositoryDefaults(s, &opts); err != nil {
+		return nil, err
+	}
+
 	if stdoutIsTerminal() && !opts.JSON {
 		id := s.Config().ID
 		if len(id) > 8 {
@@ -505,7 +619,7 @@ func OpenRepository(ctx context.Context, opts GlobalOptions) (*repository.Reposi
 		return s, nil
 	}
 
-	c, err := cache.New(s.Config().ID, opts.CacheDir)
+	c, err := cache.NewWithOptions(s.Config().ID, opts.CacheDir, cache.Options{Shared: opts.CacheShared})
 	if err != nil {
 		Warnf("unable to open cache: %v\n", err)
 		return s, nil
@@ -515,6 +629,16 @@ func OpenRepository(ctx context.Context, opts GlobalOptions) (*repository.Reposi
 		Verbosef("created new cache in %v\n", c.Base)
 	}
 
+	if opts.CacheMaxSize != "" {
+		maxSize, err := parseSizeStr(opts.CacheMaxSize)
+		if err != nil {
+			return nil, errors.Fatalf("invalid --cache-max-size: %v", err)
+		}
+		if err := c.SetMaxSize(maxSize); err != nil {
+			Warnf("unable to limit the cache size: %v\n", err)
+		}
+	}
+
 	// start using the cache
 	s.UseCache(c)
 
@@ -535,9 +659,11 @@ func OpenRepository(ctx context.Context, opts GlobalOptions) (*repository.Reposi
 		}
 		for _, item := range oldCacheDirs {
 			dir := filepath.Join(c.Base, item.Name())
-			err = fs.RemoveAll(dir)
+			removed, err := cache.RemoveDir(dir)
 			if err != nil {
 				Warnf("unable to remove %v: %v\n", dir, err)
+			} else if !removed {
+				Verbosef("not removing %v, it is in use by another process\n", dir)
 			}
 		}
 	} else {
@@ -613,6 +739,27 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 		debug.Log("opening gs repository at %#v", cfg)
 		return cfg, nil
 
+	case "gdrive":
+		cfg := loc.Config.(gdrive.Config)
+		if cfg.ClientID == "" {
+			cfg.ClientID = os.Getenv("GOOGLE_DRIVE_CLIENT_ID")
+		}
+
+		if cfg.ClientSecret.String() == "" {
+			cfg.ClientSecret = options.NewSecretString(os.Getenv("GOOGLE_DRIVE_CLIENT_SECRET"))
+		}
+
+		if cfg.TokenFile == "" {
+			cfg.TokenFile = os.Getenv("GOOGLE_DRIVE_TOKEN_FILE")
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening gdrive repository at %#v", cfg)
+		return cfg, nil
+
 	case "azure":
 		cfg := loc.Config.(azure.Config)
 		if cfg.AccountName == "" {
@@ -689,13 +836,165 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 
 		debug.Log("opening rest repository at %#v", cfg)
 		return cfg, nil
+	case "webdav":
+		cfg := loc.Config.(webdav.Config)
+
+		if cfg.User == "" {
+			cfg.User = os.Getenv("WEBDAV_USERNAME")
+		}
+
+		if cfg.Password.String() == "" {
+			cfg.Password = options.NewSecretString(os.Getenv("WEBDAV_PASSWORD"))
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening webdav repository at %#v", cfg)
+		return cfg, nil
+
+	case "smb":
+		cfg := loc.Config.(smb.Config)
+
+		if cfg.User == "" {
+			cfg.User = os.Getenv("SMB_USERNAME")
+		}
+
+		if cfg.Password.String() == "" {
+			cfg.Password = options.NewSecretString(os.Getenv("SMB_PASSWORD"))
+		}
+
+		if cfg.Domain == "" {
+			cfg.Domain = os.Getenv("SMB_DOMAIN")
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening smb repository at %v/%v/%v", cfg.Host, cfg.Share, cfg.Path)
+		return cfg, nil
+
+	case "plugin":
+		cfg := loc.Config.(plugin.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening plugin repository at %#v", cfg)
+		return cfg, nil
+
+	case "mirror", "failover", "split":
+		// the options are applied to each nested location
+		return loc.Config, nil
 	}
 
 	return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
 }
 
+// backendProxy returns the proxy configured for an HTTP based backend.
+func backendProxy(cfg interface{}) string {
+	switch cfg := cfg.(type) {
+	case s3.Config:
+		return cfg.Proxy
+	case gs.Config:
+		return cfg.Proxy
+	case gdrive.Config:
+		return cfg.Proxy
+	case azure.Config:
+		return cfg.Proxy
+	case swift.Config:
+		return cfg.Proxy
+	case b2.Config:
+		return cfg.Proxy
+	case rest.Config:
+		return cfg.Proxy
+	case webdav.Config:
+		return cfg.Proxy
+	}
+	return ""
+}
+
+// backendTransport returns the transport for the backend configured by cfg.
+// Backends without their own TLS or proxy settings use the shared transport
+// rt. If lim is not nil, it limits the throughput of a separate transport.
+func backendTransport(cfg interface{}, rt http.RoundTripper, lim limiter.Limiter) (http.RoundTripper, error) {
+	opts := globalOptions.TransportOptions
+	separate := false
+
+	if restCfg, ok := cfg.(rest.Config); ok && restCfg.HasTLSOptions() {
+		opts = restCfg.TransportOptions(opts)
+		separate = true
+	}
+	if proxy := backendProxy(cfg); proxy != "" {
+		opts.Proxy = proxy
+		separate = true
+	}
+
+	if !separate {
+		return rt, nil
+	}
+
+	tr, err := backend.Transport(opts)
+	if err != nil {
+		return nil, err
+	}
+	if lim != nil {
+		tr = lim.Transport(tr)
+	}
+	return tr, nil
+}
+
+// newLimiter returns the limiter for the bandwidth limits in gopts. Outside
+// of the periods of --limit-schedule, the static limits apply.
+func newLimiter(gopts GlobalOptions) (limiter.Limiter, error) {
+	if gopts.LimitSchedule == "" {
+		return limiter.NewStaticLimiter(gopts.Limits), nil
+	}
+
+	schedule, err := limiter.ParseSchedule(gopts.LimitSchedule)
+	if err != nil {
+		return nil, errors.Fatalf("invalid --limit-schedule: %v", err)
+	}
+	return limiter.NewScheduledLimiter(schedule, gopts.Limits), nil
+}
+
 // Open the backend specified by a location config.
 func open(ctx context.Context, s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
+	rt, err := backend.Transport(globalOptions.TransportOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// wrap the transport so that the throughput via HTTP is limited
+	lim, err := newLimiter(gopts)
+	if err != nil {
+		return nil, err
+	}
+	rt = lim.Transport(rt)
+
+	be, err := openBackend(ctx, s, gopts, opts, rt, lim)
+	if err != nil {
+		return nil, err
+	}
+
+	// check if config is there
+	fi, err := be.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, location.StripPassword(s))
+	}
+
+	if fi.Size == 0 {
+		return nil, errors.New("config file has zero size, invalid repository?")
+	}
+
+	return be, nil
+}
+
+// openBackend opens the backend at location s. All backends share the
+// transport rt and the limiter lim.
+func openBackend(ctx context.Context, s string, gopts GlobalOptions, opts options.Options, rt http.RoundTripper, lim limiter.Limiter) (restic.Backend, error) {
 	debug.Log("parsing location %v", location.StripPassword(s))
 	loc, err := location.Parse(s)
 	if err != nil {
@@ -709,15 +1008,11 @@ func open(ctx context.Context, s string, gopts GlobalOptions, opts options.Optio
 		return nil, err
 	}
 
-	rt, err := backend.Transport(globalOptions.TransportOptions)
+	rt, err = backendTransport(cfg, rt, lim)
 	if err != nil {
 		return nil, err
 	}
 
-	// wrap the transport so that the throughput via HTTP is limited
-	lim := limiter.NewStaticLimiter(gopts.Limits)
-	rt = lim.Transport(rt)
-
 	switch loc.Scheme {
 	case "local":
 		be, err = local.Open(ctx, cfg.(local.Config))
@@ -727,6 +1022,8 @@ func open(ctx context.Context, s string, gopts GlobalOptions, opts options.Optio
 		be, err = s3.Open(ctx, cfg.(s3.Config), rt)
 	case "gs":
 		be, err = gs.Open(cfg.(gs.Config), rt)
+	case "gdrive":
+		be, err = gdrive.Open(cfg.(gdrive.Config), rt)
 	case "azure":
 		be, err = azure.Open(ctx, cfg.(azure.Config), rt)
 	case "swift":
@@ -737,6 +1034,18 @@ func open(ctx context.Context, s string, gopts GlobalOptions, opts options.Optio
 		be, err = rest.Open(cfg.(rest.Config), rt)
 	case "rclone":
 		be, err = rclone.Open(cfg.(rclone.Config), lim)
+	case "webdav":
+		be, err = webdav.Open(cfg.(webdav.Config), rt)
+	case "smb":
+		be, err = smb.Open(ctx, cfg.(smb.Config))
+	case "plugin":
+		be, err = plugin.Open(ctx, cfg.(plugin.Config))
+	case "mirror":
+		be, err = openMirror(ctx, cfg.(mirror.Config), gopts, opts, rt, lim)
+	case "failover":
+		be, err = openFailover(ctx, cfg.(failover.Config), gopts, opts, rt, lim)
+	case "split":
+		be, err = openSplit(ctx, cfg.(split.Config), gopts, opts, rt, lim)
 
 	default:
 		return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
@@ -746,6 +1055,11 @@ func open(ctx context.Context, s string, gopts GlobalOptions, opts options.Optio
 		return nil, errors.Fatalf("unable to open repository at %v: %v", location.StripPassword(s), err)
 	}
 
+	// record the requests, composite backends are covered by their parts
+	if gopts.backendMetrics != nil && loc.Scheme != "mirror" && loc.Scheme != "failover" && loc.Scheme != "split" {
+		be = metrics.New(be, gopts.backendMetrics, location.StripPassword(s))
+	}
+
 	// wrap with debug logging and connection limiting
 	be = logger.New(sema.NewBackend(be))
 
@@ -757,22 +1071,112 @@ func open(ctx context.Context, s string, gopts GlobalOptions, opts options.Optio
 		}
 	}
 
-	if loc.Scheme == "local" || loc.Scheme == "sftp" {
+	if loc.Scheme == "local" || loc.Scheme == "sftp" || loc.Scheme == "smb" || loc.Scheme == "plugin" {
 		// wrap the backend in a LimitBackend so that the throughput is limited
 		be = limiter.LimitBackend(be, lim)
 	}
 
-	// check if config is there
-	fi, err := be.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	return be, nil
+}
+
+// openMirror opens all mirrored backends. Mirrors which cannot be opened are
+// only reported, as the files can still be read from the other mirrors.
+func openMirror(ctx context.Context, cfg mirror.Config, gopts GlobalOptions, opts options.Options, rt http.RoundTripper, lim limiter.Limiter) (restic.Backend, error) {
+	// test hooks only apply to the mirror backend itself
+	gopts.backendInnerTestHook = nil
+
+	var mirrors []restic.Backend
+	available := 0
+	for _, s := range cfg.Locations {
+		be, err := openBackend(ctx, s, gopts, opts, rt, lim)
+		if err != nil {
+			Warnf("mirror %v is unavailable: %v\n", location.StripPassword(s), err)
+			be = mirror.Unavailable(location.StripPassword(s), err)
+		} else {
+			available++
+		}
+		mirrors = append(mirrors, be)
+	}
+
+	if available == 0 {
+		return nil, errors.New("none of the mirrors is available")
+	}
+	return mirror.New(mirrors), nil
+}
+
+// openFailover opens all backends of a failover location. Backends which
+// cannot be opened are reported, they are skipped until they become healthy.
+func openFailover(ctx context.Context, cfg failover.Config, gopts GlobalOptions, opts options.Options, rt http.RoundTripper, lim limiter.Limiter) (restic.Backend, error) {
+	// test hooks only apply to the failover backend itself
+	gopts.backendInnerTestHook = nil
+
+	var backends []restic.Backend
+	available := 0
+	for _, s := range cfg.Locations {
+		be, err := openBackend(ctx, s, gopts, opts, rt, lim)
+		if err != nil {
+			Warnf("backend %v is unavailable: %v\n", location.StripPassword(s), err)
+			be = mirror.Unavailable(location.StripPassword(s), err)
+		} else {
+			available++
+		}
+		backends = append(backends, be)
+	}
+
+	if available == 0 {
+		return nil, errors.New("none of the backends is available")
+	}
+	return failover.New(backends, reportFailover(gopts.JSON)), nil
+}
+
+// openSplit opens the hot and the cold backend. If the cold backend cannot be
+// opened, the metadata in the hot backend can still be accessed.
+func openSplit(ctx context.Context, cfg split.Config, gopts GlobalOptions, opts options.Options, rt http.RoundTripper, lim limiter.Limiter) (restic.Backend, error) {
+	// test hooks only apply to the split backend itself
+	gopts.backendInnerTestHook = nil
+
+	hot, err := openBackend(ctx, cfg.Hot, gopts, opts, rt, lim)
 	if err != nil {
-		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, location.StripPassword(s))
+		return nil, err
 	}
 
-	if fi.Size == 0 {
-		return nil, errors.New("config file has zero size, invalid repository?")
+	cold, err := openBackend(ctx, cfg.Cold, gopts, opts, rt, lim)
+	if err != nil {
+		Warnf("cold backend %v is unavailable: %v\n", location.StripPassword(cfg.Cold), err)
+		cold = mirror.Unavailable(location.StripPassword(cfg.Cold), err)
 	}
+	return split.New(hot, cold), nil
+}
 
-	return be, nil
+type failoverEvent struct {
+	MessageType string `json:"message_type"` // "failover"
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Error       string `json:"error,omitempty"`
+}
+
+// reportFailover returns a function which prints a message to stderr whenever
+// a failover backend switches to another backend.
+func reportFailover(jsonOutput bool) func(failover.Event) {
+	return func(ev failover.Event) {
+		from, to := location.StripPassword(ev.From), location.StripPassword(ev.To)
+		if jsonOutput {
+			msg := failoverEvent{MessageType: "failover", From: from, To: to}
+			if ev.Err != nil {
+				msg.Error = ev.Err.Error()
+			}
+			if err := json.NewEncoder(globalOptions.stderr).Encode(msg); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to write to stderr: %v\n", err)
+			}
+			return
+		}
+
+		if ev.Err != nil {
+			Warnf("backend %v failed, switching to %v: %v\n", from, to, ev.Err)
+		} else {
+			Warnf("backend %v is healthy again, switching back from %v\n", to, from)
+		}
+	}
 }
 
 // Create the backend specified by URI.
@@ -792,6 +1196,10 @@ func create(ctx context.Context, s string, opts options.Options) (restic.Backend
 	if err != nil {
 		return nil, err
 	}
+	rt, err = backendTransport(cfg, rt, nil)
+	if err != nil {
+		return nil, err
+	}
 
 	var be restic.Backend
 	switch loc.Scheme {
@@ -803,6 +1211,8 @@ func create(ctx context.Context, s string, opts options.Options) (restic.Backend
 		be, err = s3.Create(ctx, cfg.(s3.Config), rt)
 	case "gs":
 		be, err = gs.Create(ctx, cfg.(gs.Config), rt)
+	case "gdrive":
+		be, err = gdrive.Create(ctx, cfg.(gdrive.Config), rt)
 	case "azure":
 		be, err = azure.Create(ctx, cfg.(azure.Config), rt)
 	case "swift":
@@ -813,6 +1223,18 @@ func create(ctx context.Context, s string, opts options.Options) (restic.Backend
 		be, err = rest.Create(ctx, cfg.(rest.Config), rt)
 	case "rclone":
 		be, err = rclone.Create(ctx, cfg.(rclone.Config))
+	case "webdav":
+		be, err = webdav.Create(ctx, cfg.(webdav.Config), rt)
+	case "smb":
+		be, err = smb.Create(ctx, cfg.(smb.Config))
+	case "plugin":
+		be, err = plugin.Create(ctx, cfg.(plugin.Config))
+	case "mirror":
+		be, err = createMirror(ctx, cfg.(mirror.Config), opts)
+	case "failover":
+		be, err = createFailover(ctx, cfg.(failover.Config), opts)
+	case "split":
+		be, err = createSplit(ctx, cfg.(split.Config), opts)
 	default:
 		debug.Log("invalid repository scheme: %v", s)
 		return nil, errors.Fatalf("invalid scheme %q", loc.Scheme)
@@ -824,3 +1246,47 @@ func create(ctx context.Context, s string, opts options.Options) (restic.Backend
 
 	return logger.New(sema.NewBackend(be)), nil
 }
+
+// createMirror creates the backends at all mirrored locations.
+func createMirror(ctx context.Context, cfg mirror.Config, opts options.Options) (restic.Backend, error) {
+	mirrors, err := createAll(ctx, cfg.Locations, opts)
+	if err != nil {
+		return nil, err
+	}
+	return mirror.New(mirrors), nil
+}
+
+// createFailover creates the backends at all locations of a failover backend.
+func createFailover(ctx context.Context, cfg failover.Config, opts options.Options) (restic.Backend, error) {
+	backends, err := createAll(ctx, cfg.Locations, opts)
+	if err != nil {
+		return nil, err
+	}
+	return failover.New(backends, reportFailover(globalOptions.JSON)), nil
+}
+
+// createSplit creates the hot and the cold backend.
+func createSplit(ctx context.Context, cfg split.Config, opts options.Options) (restic.Backend, error) {
+	backends, err := createAll(ctx, []string{cfg.Hot, cfg.Cold}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return split.New(backends[0], backends[1]), nil
+}
+
+// createAll creates the backends at all locations. If one of them cannot be
+// created, the others are closed again.
+func createAll(ctx context.Context, locations []string, opts options.Options) ([]restic.Backend, error) {
+	var backends []restic.Backend
+	for _, s := range locations {
+		be, err := create(ctx, s, opts)
+		if err != nil {
+			for _, b := range backends {
+				_ = b.Close()
+			}
+			return nil, errors.Wrapf(err, "location %v", location.StripPassword(s))
+		}
+		backends = append(backends, be)
+	}
+	return backends, nil
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/restic/restic/internal/backend/limiter"
 	"github.com/restic/restic/internal/test"
 	rtest "github.com/restic/restic/internal/test"
 )
@@ -58,3 +59,33 @@ func TestReadRepo(t *testing.T) {
 		t.Fatal("must not read repository path from invalid file path")
 	}
 }
+
+func TestOperationLimits(t *testing.T) {
+	prune := forgetOptions.Prune
+	defer func() {
+		forgetOptions.Prune = prune
+	}()
+
+	global := limiter.Limits{UploadKb: 100, DownloadKb: 200}
+	ops := OperationLimits{
+		Backup: limiter.Limits{UploadKb: 1000},
+		Prune:  limiter.Limits{UploadKb: 10, DownloadKb: -1},
+	}
+
+	for _, test := range []struct {
+		command     string
+		forgetPrune bool
+		want        limiter.Limits
+	}{
+		{"backup", false, limiter.Limits{UploadKb: 1000, DownloadKb: 200}},
+		{"restore", false, global},
+		{"prune", false, limiter.Limits{UploadKb: 10, DownloadKb: -1}},
+		{"maintain", false, limiter.Limits{UploadKb: 10, DownloadKb: -1}},
+		{"forget", false, global},
+		{"forget", true, limiter.Limits{UploadKb: 10, DownloadKb: -1}},
+		{"check", false, global},
+	} {
+		forgetOptions.Prune = test.forgetPrune
+		rtest.Equals(t, test.want, ops.limitsFor(test.command, global))
+	}
+}
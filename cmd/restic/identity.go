@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+)
+
+// loadIdentity reads the identity stored in the JSON file.
+func loadIdentity(filename string) (*crypto.Identity, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read identity: %v", err)
+	}
+
+	var identity crypto.Identity
+	if err := json.Unmarshal(buf, &identity); err != nil {
+		return nil, errors.Fatalf("invalid identity in %v: %v", filename, err)
+	}
+	if _, err := identity.Recipient(); err != nil {
+		return nil, errors.Fatalf("invalid identity in %v: %v", filename, err)
+	}
+	return &identity, nil
+}
+
+// loadRecipient reads the recipient stored in the JSON file.
+func loadRecipient(filename string) (*crypto.Recipient, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read recipient: %v", err)
+	}
+
+	var recipient crypto.Recipient
+	if err := json.Unmarshal(buf, &recipient); err != nil {
+		return nil, errors.Fatalf("invalid recipient in %v: %v", filename, err)
+	}
+	if recipient.Type != crypto.HybridKEM {
+		return nil, errors.Fatalf("unsupported recipient type %q in %v", recipient.Type, filename)
+	}
+	return &recipient, nil
+}
+
+// writeNewFile writes the value as JSON to a file which must not exist yet.
+func writeNewFile(filename string, v interface{}, perm os.FileMode) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return errors.Fatalf("unable to create file: %v", err)
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// newIdentity generates a new identity, which is written to filename. The
+// recipient is written to filename with the suffix ".pub".
+func newIdentity(filename string) error {
+	identity, err := crypto.NewIdentity()
+	if err != nil {
+		return err
+	}
+	recipient, err := identity.Recipient()
+	if err != nil {
+		return err
+	}
+
+	if err := writeNewFile(filename, identity, 0600); err != nil {
+		return err
+	}
+	if err := writeNewFile(filename+".pub", recipient, 0644); err != nil {
+		return err
+	}
+
+	Verbosef("saved new identity to %v, recipient %v\n", filename, recipient.Fingerprint())
+	return nil
+}
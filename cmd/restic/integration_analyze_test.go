@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunAnalyze(t testing.TB, gopts GlobalOptions, opts AnalyzeOptions) AnalyzeResult {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+	rtest.OK(t, runAnalyze(context.TODO(), opts, gopts, nil))
+
+	var res AnalyzeResult
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &res))
+	return res
+}
+
+func TestAnalyze(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "0", "9", "new"), 1024*1024))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	res := testRunAnalyze(t, env.gopts, AnalyzeOptions{Top: 3, Depth: 4})
+	rtest.Equals(t, 3, len(res.Snapshots))
+	rtest.Equals(t, 1, len(res.Hosts))
+	rtest.Equals(t, 3, res.Hosts[0].Snapshots)
+
+	first, last := res.Snapshots[0], res.Snapshots[2]
+	rtest.Assert(t, first.LogicalSize > 0 && first.DataSize > 0, "empty snapshot statistics %v", first)
+	rtest.Assert(t, last.LogicalSize > first.LogicalSize, "new file is not included in %v", res.Snapshots)
+	// all data except the new file is shared by all snapshots
+	rtest.Equals(t, uint64(0), res.Snapshots[1].UniqueSize)
+	rtest.Assert(t, last.UniqueSize > 1024*1024, "unique size %v does not include the new file", last.UniqueSize)
+	rtest.Assert(t, res.Hosts[0].DedupRatio >= first.DedupRatio, "host dedup ratio %v is smaller than %v", res.Hosts[0].DedupRatio, first.DedupRatio)
+
+	rtest.Assert(t, len(res.Directories) > 0 && len(res.Directories) <= 3, "unexpected directories %v", res.Directories)
+	rtest.Equals(t, "/testdata/0/0/9", res.Directories[0].Path)
+
+	rtest.Equals(t, 2, len(res.Churn))
+	rtest.Equals(t, uint64(0), res.Churn[0].AddedSize)
+	rtest.Equals(t, uint64(0), res.Churn[0].RemovedSize)
+	rtest.Assert(t, res.Churn[1].AddedSize > 1024*1024, "churn %v does not include the new file", res.Churn[1])
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testSaveDir(t testing.TB, src string) map[string][]byte {
+	files := make(map[string][]byte)
+	entries, err := os.ReadDir(src)
+	rtest.OK(t, err)
+	for _, e := range entries {
+		buf, err := os.ReadFile(filepath.Join(src, e.Name()))
+		rtest.OK(t, err)
+		files[e.Name()] = buf
+	}
+	return files
+}
+
+func testRestoreDir(t testing.TB, dir string, files map[string][]byte) {
+	rtest.RemoveAll(t, dir)
+	rtest.OK(t, os.MkdirAll(dir, 0700))
+	for name, buf := range files {
+		rtest.OK(t, os.WriteFile(filepath.Join(dir, name), buf, 0600))
+	}
+}
+
+func TestAuditRollback(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+	for i := 0; i < 3; i++ {
+		testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	}
+	snapshotIDs := testListSnapshots(t, env.gopts, 3)
+
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+	rtest.OK(t, runAudit(context.TODO(), env.gopts, nil))
+
+	auditDir := filepath.Join(env.repo, "audit")
+	headDir := filepath.Join(env.repo, "audithead")
+	savedAudit := testSaveDir(t, auditDir)
+	savedHead := testSaveDir(t, headDir)
+
+	testRunForget(t, env.gopts, snapshotIDs[1].String())
+	rtest.OK(t, runAudit(context.TODO(), env.gopts, nil))
+
+	// replaying an older state of the audit log including its head
+	testRestoreDir(t, auditDir, savedAudit)
+	testRestoreDir(t, headDir, savedHead)
+	rtest.Assert(t, runAudit(context.TODO(), env.gopts, nil) != nil, "rollback of the audit log not detected")
+}
+
+func TestAuditRemovedNewest(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+
+	auditDir := filepath.Join(env.repo, "audit")
+	savedAudit := testSaveDir(t, auditDir)
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+
+	// removing the newest entry without a local anchor
+	env.gopts.NoCache = true
+	testRestoreDir(t, auditDir, savedAudit)
+	rtest.Assert(t, runAudit(context.TODO(), env.gopts, nil) != nil, "removal of the newest audit entry not detected")
+}
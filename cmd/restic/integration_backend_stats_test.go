@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/metrics"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestBackendStats(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+
+	env.gopts.backendMetrics = metrics.NewCollector()
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	summary := env.gopts.backendMetrics.Summary()
+	rtest.Equals(t, 1, len(summary))
+	rtest.Equals(t, env.gopts.Repo, summary[0].Name)
+
+	ops := make(map[string]metrics.OperationSummary)
+	for _, op := range summary[0].Operations {
+		ops[op.Operation] = op
+	}
+	rtest.Assert(t, ops["save"].Requests > 0 && ops["save"].Bytes > 0, "no saved data recorded: %v", ops)
+	rtest.Assert(t, ops["list"].Requests > 0, "no list requests recorded: %v", ops)
+}
+
+func TestRetryOperation(t *testing.T) {
+	rtest.Equals(t, "Save", retryOperation("Save(<data/1234567890>)"))
+	rtest.Equals(t, "List", retryOperation("List(snapshot)"))
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+const excludeCommandScript = `#!/bin/sh
+while read -r path; do
+	case "$path" in
+		*/secret*) echo exclude ;;
+		*) echo include ;;
+	esac
+done
+`
+
+func TestBackupExcludeIfCommand(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	for _, filename := range []string{"public.txt", "secret.txt", "docs/notes.txt", "docs/secrets/key"} {
+		fp := filepath.Join(datadir, filepath.FromSlash(filename))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(fp), 0755))
+		rtest.OK(t, os.WriteFile(fp, []byte(filename), 0644))
+	}
+
+	script := filepath.Join(env.base, "exclude.sh")
+	rtest.OK(t, os.WriteFile(script, []byte(excludeCommandScript), 0755))
+
+	opts := BackupOptions{ExcludeIfCommand: script}
+	testRunBackup(t, env.testdata, []string{"."}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	files := testRunLs(t, env.gopts, snapshotIDs[0].String())
+	for _, file := range []string{"/public.txt", "/docs/notes.txt"} {
+		rtest.Assert(t, includes(files, file), "expected file %q in snapshot, but it's not included", file)
+	}
+	for _, file := range []string{"/secret.txt", "/docs/secrets"} {
+		rtest.Assert(t, !includes(files, file), "expected file %q not in snapshot, but it's included", file)
+	}
+
+	// a failing helper does not exclude anything
+	rtest.OK(t, os.WriteFile(script, []byte("#!/bin/sh\nexit 1\n"), 0755))
+	testRunBackup(t, env.testdata, []string{"."}, opts, env.gopts)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+}
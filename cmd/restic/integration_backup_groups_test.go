@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/termstatus"
+	"golang.org/x/sync/errgroup"
+)
+
+func testRunBackupGroups(t testing.TB, opts BackupOptions, gopts GlobalOptions) error {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var wg errgroup.Group
+	term := termstatus.New(gopts.stdout, gopts.stderr, gopts.Quiet)
+	wg.Go(func() error { term.Run(ctx); return nil })
+
+	gopts.stdout = io.Discard
+	opts.GroupBy = restic.SnapshotGroupByOptions{Host: true, Path: true}
+	backupErr := runBackupGroups(ctx, opts, gopts, term, nil)
+
+	cancel()
+	rtest.OK(t, wg.Wait())
+	return backupErr
+}
+
+// testGroupSnapshots returns the number of snapshots of each path group.
+func testGroupSnapshots(t testing.TB, gopts GlobalOptions) map[string]int {
+	counts := make(map[string]int)
+	for _, id := range testRunList(t, "snapshots", gopts) {
+		sn := testLoadSnapshot(t, gopts, id)
+		rtest.Equals(t, 1, len(sn.Tags))
+		counts[sn.Tags[0]]++
+	}
+	return counts
+}
+
+func TestBackupPathGroups(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	testRunInit(t, env.gopts)
+	// each group lists the snapshots to find the parent
+	env.gopts.backendTestHook = nil
+
+	var paths []string
+	for _, dir := range []string{"etc", "home"} {
+		p := filepath.Join(env.testdata, dir)
+		rtest.OK(t, os.MkdirAll(p, 0755))
+		rtest.OK(t, os.WriteFile(filepath.Join(p, "file"), []byte(dir), 0644))
+		paths = append(paths, p)
+	}
+
+	config := fmt.Sprintf(`
+profiles:
+  server:
+    path-groups:
+      etc:
+        paths: [%q]
+        every: 1h
+      home:
+        paths: [%q]
+        every: 1d
+`, paths[0], paths[1])
+	env.gopts.ConfigFile = filepath.Join(env.base, "restic.yaml")
+	env.gopts.Profile = "server"
+	rtest.OK(t, os.WriteFile(env.gopts.ConfigFile, []byte(config), 0600))
+
+	opts := BackupOptions{Host: "example", Due: true}
+	rtest.OK(t, testRunBackupGroups(t, opts, env.gopts))
+	rtest.Equals(t, map[string]int{"path-group:etc": 1, "path-group:home": 1}, testGroupSnapshots(t, env.gopts))
+
+	// neither group is due yet
+	rtest.OK(t, testRunBackupGroups(t, opts, env.gopts))
+	rtest.Equals(t, map[string]int{"path-group:etc": 1, "path-group:home": 1}, testGroupSnapshots(t, env.gopts))
+
+	// a group selected without --due is always backed up
+	opts = BackupOptions{Host: "example", PathGroups: []string{"etc"}}
+	rtest.OK(t, testRunBackupGroups(t, opts, env.gopts))
+	rtest.Equals(t, map[string]int{"path-group:etc": 2, "path-group:home": 1}, testGroupSnapshots(t, env.gopts))
+
+	for _, sn := range testRunList(t, "snapshots", env.gopts) {
+		s := testLoadSnapshot(t, env.gopts, sn)
+		rtest.Equals(t, 1, len(s.Paths))
+		if s.Tags[0] == "path-group:etc" {
+			rtest.Equals(t, paths[0], s.Paths[0])
+		} else {
+			rtest.Equals(t, paths[1], s.Paths[0])
+		}
+	}
+
+	// snapshots of other hosts are ignored
+	opts = BackupOptions{Host: "other", Due: true}
+	rtest.OK(t, testRunBackupGroups(t, opts, env.gopts))
+	rtest.Equals(t, map[string]int{"path-group:etc": 3, "path-group:home": 2}, testGroupSnapshots(t, env.gopts))
+
+	opts = BackupOptions{Host: "example", PathGroups: []string{"missing"}}
+	rtest.Assert(t, testRunBackupGroups(t, opts, env.gopts) != nil, "missing group not reported")
+	env.gopts.Profile = ""
+	rtest.Assert(t, testRunBackupGroups(t, BackupOptions{Due: true}, env.gopts) != nil, "missing profile not reported")
+}
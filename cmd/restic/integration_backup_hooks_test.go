@@ -0,0 +1,91 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/termstatus"
+	"golang.org/x/sync/errgroup"
+)
+
+func testRunBackupWithHooks(t testing.TB, target []string, opts BackupOptions, gopts GlobalOptions) error {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var wg errgroup.Group
+	term := termstatus.New(gopts.stdout, gopts.stderr, gopts.Quiet)
+	wg.Go(func() error { term.Run(ctx); return nil })
+
+	gopts.stdout = io.Discard
+	gopts.stderr = io.Discard
+	opts.GroupBy = restic.SnapshotGroupByOptions{Host: true, Path: true}
+	backupErr := runBackupWithHooks(ctx, opts, gopts, term, target)
+
+	cancel()
+	rtest.OK(t, wg.Wait())
+	return backupErr
+}
+
+func TestBackupHooks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	testSetupBackupData(t, env)
+	// the hooks have to be run for each backup
+	env.gopts.backendTestHook = nil
+
+	logfile := filepath.Join(env.base, "hooks.log")
+	script := filepath.Join(env.base, "hook.sh")
+	rtest.OK(t, os.WriteFile(script, []byte(`#!/bin/sh
+echo "$1 $RESTIC_BACKUP_RESULT $RESTIC_SNAPSHOT_ID" >> `+logfile+`
+exit $2
+`), 0755))
+	readLog := func() []string {
+		buf, err := os.ReadFile(logfile)
+		rtest.OK(t, err)
+		rtest.OK(t, os.Remove(logfile))
+		return strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	}
+
+	opts := BackupOptions{
+		PreHooks:        []string{script + " pre 0"},
+		PostHooks:       []string{script + " post 0"},
+		PreHookFailure:  hookFailureAbort,
+		PostHookFailure: hookFailureWarn,
+	}
+	rtest.OK(t, testRunBackupWithHooks(t, []string{env.testdata}, opts, env.gopts))
+	ids := testListSnapshots(t, env.gopts, 1)
+	rtest.Equals(t, []string{"pre  ", "post success " + ids[0].String()}, readLog())
+
+	// a failed pre-hook aborts the backup, the post-hook is still run
+	opts.PreHooks = []string{script + " pre 1"}
+	err := testRunBackupWithHooks(t, []string{env.testdata}, opts, env.gopts)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "pre-hook"), "unexpected error %v", err)
+	testListSnapshots(t, env.gopts, 1)
+	rtest.Equals(t, []string{"pre  ", "post failure "}, readLog())
+
+	// only warn about failed hooks
+	opts.PreHookFailure = hookFailureWarn
+	opts.PostHooks = []string{script + " post 1"}
+	rtest.OK(t, testRunBackupWithHooks(t, []string{env.testdata}, opts, env.gopts))
+	testListSnapshots(t, env.gopts, 2)
+	readLog()
+
+	opts.PostHookFailure = hookFailureAbort
+	err = testRunBackupWithHooks(t, []string{env.testdata}, opts, env.gopts)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "post-hook"), "unexpected error %v", err)
+	testListSnapshots(t, env.gopts, 3)
+	readLog()
+
+	opts.PostHookFailure = "ignore"
+	err = testRunBackupWithHooks(t, []string{env.testdata}, opts, env.gopts)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "--post-hook-on-failure"), "unexpected error %v", err)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestBackupAdditionalRepos(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+	env3, cleanup3 := withTestEnvironment(t)
+	defer cleanup3()
+
+	testSetupBackupData(t, env)
+	testRunInit(t, env2.gopts)
+	testRunInit(t, env3.gopts)
+
+	passwordFile := filepath.Join(env.base, "password2")
+	rtest.OK(t, os.WriteFile(passwordFile, []byte(env2.gopts.password), 0600))
+	opts := BackupOptions{
+		AdditionalRepos:         []string{env2.gopts.Repo},
+		AdditionalPasswordFiles: []string{passwordFile},
+	}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+	copiedIDs := testListSnapshots(t, env2.gopts, 1)
+	testRunCheck(t, env.gopts)
+	testRunCheck(t, env2.gopts)
+
+	repo, err := OpenRepository(context.TODO(), env2.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, copiedIDs[0])
+	rtest.OK(t, err)
+	rtest.Equals(t, snapshotIDs[0], *sn.Original)
+
+	// a repository added later receives all data, although files are unchanged
+	opts.AdditionalRepos = append(opts.AdditionalRepos, env3.gopts.Repo)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	testListSnapshots(t, env.gopts, 2)
+	testListSnapshots(t, env2.gopts, 2)
+	copiedIDs = testListSnapshots(t, env3.gopts, 1)
+	testRunCheck(t, env3.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env3.gopts, restoredir, copiedIDs[0])
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "restored snapshot differs from the original:\n%v", diff)
+
+	// the copy command detects that the snapshots are identical
+	testRunCopy(t, env.gopts, env2.gopts)
+	testListSnapshots(t, env2.gopts, 2)
+}
+
+func TestBackupAdditionalRepoBlobHash(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testSetupBackupData(t, env)
+	testRunInitBlobHash(t, env2.gopts, restic.BlobHashBLAKE3)
+
+	passwordFile := filepath.Join(env.base, "password2")
+	rtest.OK(t, os.WriteFile(passwordFile, []byte(env2.gopts.password), 0600))
+	opts := BackupOptions{
+		AdditionalRepos:         []string{env2.gopts.Repo},
+		AdditionalPasswordFiles: []string{passwordFile},
+	}
+	err := testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "different blob hash algorithm"),
+		"expected blob hash error, got %v", err)
+	testListSnapshots(t, env.gopts, 0)
+	testListSnapshots(t, env2.gopts, 0)
+}
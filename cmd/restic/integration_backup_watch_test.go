@@ -0,0 +1,89 @@
+//go:build linux || windows || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux windows darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/termstatus"
+	"golang.org/x/sync/errgroup"
+)
+
+func waitForSnapshots(t *testing.T, gopts GlobalOptions, n int) restic.IDs {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ids := testRunList(t, "snapshots", gopts)
+		if len(ids) >= n || time.Now().After(deadline) {
+			rtest.Equals(t, n, len(ids))
+			return ids
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestBackupWatch(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	testRunInit(t, env.gopts)
+	// every round lists the snapshots to find the parent
+	env.gopts.backendTestHook = nil
+
+	for _, dir := range []string{"clean", "dirty"} {
+		rtest.OK(t, os.MkdirAll(filepath.Join(env.testdata, dir), 0755))
+		rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, dir, "file"), []byte("old "+dir), 0644))
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	var wg errgroup.Group
+	term := termstatus.New(io.Discard, io.Discard, true)
+	wg.Go(func() error { term.Run(ctx); return nil })
+
+	gopts := env.gopts
+	gopts.stdout = io.Discard
+	opts := BackupOptions{
+		GroupBy:    restic.SnapshotGroupByOptions{Host: true, Path: true},
+		Watch:      true,
+		WatchDelay: 50 * time.Millisecond,
+	}
+	var watchErr error
+	wg.Go(func() error {
+		watchErr = runBackupWatch(ctx, opts, gopts, term, []string{env.testdata})
+		return nil
+	})
+
+	firstID := waitForSnapshots(t, env.gopts, 1)[0]
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "dirty", "file"), []byte("new dirty"), 0644))
+	ids := waitForSnapshots(t, env.gopts, 2)
+	cancel()
+	rtest.OK(t, wg.Wait())
+	rtest.Assert(t, watchErr == context.Canceled, "unexpected error %v", watchErr)
+
+	secondID := ids[0]
+	if secondID == firstID {
+		secondID = ids[1]
+	}
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, secondID)
+	rtest.OK(t, err)
+	rtest.Assert(t, sn.Parent != nil && *sn.Parent == firstID, "wrong parent %v", sn.Parent)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, secondID)
+	restored := filepath.Join(restoredir, env.testdata)
+	for dir, content := range map[string]string{"clean": "old clean", "dirty": "new dirty"} {
+		buf, err := os.ReadFile(filepath.Join(restored, dir, "file"))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(buf))
+	}
+	testRunCheck(t, env.gopts)
+}
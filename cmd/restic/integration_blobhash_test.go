@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunInitBlobHash(t testing.TB, gopts GlobalOptions, blobHash string) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+
+	rtest.OK(t, runInit(context.TODO(), InitOptions{BlobHash: blobHash, RepositoryVersion: "stable"}, gopts, nil))
+}
+
+func TestBlobHashBLAKE3(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	// older versions of restic must not be able to open the repository
+	err := runInit(context.TODO(), InitOptions{BlobHash: restic.BlobHashBLAKE3, RepositoryVersion: "2"}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "BLAKE3 repository with version 2 was created")
+	testRunInitBlobHash(t, env.gopts, restic.BlobHashBLAKE3)
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(restic.CapabilitiesRepoVersion), repo.Config().Version)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+	rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true}, env.gopts, nil))
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "restored directory differs:\n%v", diff)
+
+	// copying to a repository using SHA-256 requires splitting the files again
+	testRunInit(t, env2.gopts)
+	gopts := env.gopts
+	gopts.Repo = env2.gopts.Repo
+	gopts.password = env2.gopts.password
+	copyOpts := CopyOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env.gopts.Repo,
+			password: env.gopts.password,
+		},
+	}
+	rtest.Assert(t, runCopy(context.TODO(), copyOpts, gopts, nil) != nil,
+		"expected copy between repositories with different blob hashes to fail")
+	testListSnapshots(t, env2.gopts, 0)
+
+	copyOpts.Rechunk = true
+	rtest.OK(t, runCopy(context.TODO(), copyOpts, gopts, nil))
+	copiedSnapshotIDs := testListSnapshots(t, env2.gopts, 1)
+	testRunCheck(t, env2.gopts)
+
+	restoredir2 := filepath.Join(env2.base, "restore")
+	testRunRestore(t, env2.gopts, restoredir2, copiedSnapshotIDs[0])
+	diff = directoriesContentsDiff(restoredir, restoredir2)
+	rtest.Assert(t, diff == "", "copied snapshot differs:\n%v", diff)
+}
+
+func TestInitBlobHashInvalid(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	err := runInit(context.TODO(), InitOptions{BlobHash: "md5"}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "expected error for unknown blob hash")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/walker"
+)
+
+func TestBackupBundleSmallFiles(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	dir := filepath.Join(env.testdata, "small")
+	rtest.OK(t, os.MkdirAll(dir, 0755))
+	for i := 0; i < 300; i++ {
+		data := rtest.Random(i, 100+i*10)
+		rtest.OK(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%03d", i)), data, 0644))
+	}
+	rtest.OK(t, os.WriteFile(filepath.Join(dir, "large"), rtest.Random(1000, 64*1024), 0644))
+
+	opts := BackupOptions{BundleSmallerThan: "4K"}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+	testRunCheck(t, env.gopts)
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, snapshotIDs[0])
+	rtest.OK(t, err)
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	tree, err = restic.LoadTree(context.TODO(), repo, *tree.Find("testdata").Subtree)
+	rtest.OK(t, err)
+	tree, err = restic.LoadTree(context.TODO(), repo, *tree.Find("small").Subtree)
+	rtest.OK(t, err)
+
+	bundled := 0
+	for _, node := range tree.Nodes {
+		if node.Bundle != nil {
+			bundled++
+		}
+	}
+	rtest.Equals(t, 300, bundled)
+
+	restoredir := filepath.Join(env.base, "restore")
+	rtest.OK(t, runRestore(context.TODO(), RestoreOptions{Target: restoredir, Verify: true}, env.gopts, nil, []string{snapshotIDs[0].String()}))
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "directories are not equal %v", diff)
+}
+
+func TestBackupBundleSmallerThanInvalid(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+
+	for _, size := range []string{"foo", "1M"} {
+		err := testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{BundleSmallerThan: size}, env.gopts)
+		rtest.Assert(t, err != nil, "missing error for --bundle-smaller-than %v", size)
+	}
+}
+
+func TestBackupBundleRequiresVersion3(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "2"}, env.gopts, nil))
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	for i := 0; i < 2; i++ {
+		rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, fmt.Sprintf("file%d", i)), rtest.Random(i, 100), 0644))
+	}
+
+	// older versions of restic must not be able to read bundled files
+	opts := BackupOptions{BundleSmallerThan: "4K"}
+	err := testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "bundling files in a version 2 repository did not fail")
+	testListSnapshots(t, env.gopts, 0)
+
+	// neither must bundled files be copied to such a repository
+	rtest.OK(t, runMigrate(context.TODO(), MigrateOptions{}, env.gopts, []string{"upgrade_repo_v3"}))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "2"}, env2.gopts, nil))
+	gopts := env2.gopts
+	copyOpts := CopyOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env.gopts.Repo,
+			password: env.gopts.password,
+		},
+	}
+	rtest.Assert(t, runCopy(context.TODO(), copyOpts, gopts, nil) != nil, "copying bundled files to a version 2 repository did not fail")
+	testListSnapshots(t, env2.gopts, 0)
+
+	// nor saved to it as an additional repository
+	passwordFile := filepath.Join(env.base, "password2")
+	rtest.OK(t, os.WriteFile(passwordFile, []byte(env2.gopts.password), 0600))
+	opts.AdditionalRepos = []string{env2.gopts.Repo}
+	opts.AdditionalPasswordFiles = []string{passwordFile}
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "bundling files for an additional version 2 repository did not fail")
+	testListSnapshots(t, env.gopts, 1)
+	testListSnapshots(t, env2.gopts, 0)
+}
+
+// testCheckNotBundled checks that no file in the directory tree of the
+// snapshot is stored in a bundle.
+func testCheckNotBundled(t testing.TB, gopts GlobalOptions, id restic.ID) {
+	repo, err := OpenRepository(context.TODO(), gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	err = walker.Walk(context.TODO(), repo, *sn.Tree, restic.NewIDSet(), func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		if node != nil && node.Bundle != nil {
+			t.Errorf("file %v is still bundled", nodepath)
+		}
+		return true, nil
+	})
+	rtest.OK(t, err)
+}
+
+func TestCopyRechunkBundled(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	for i := 0; i < 20; i++ {
+		rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, fmt.Sprintf("file%d", i)), rtest.Random(i, 100+i*10), 0644))
+	}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{BundleSmallerThan: "4K"}, env.gopts)
+
+	// the bundled files are stored as regular files, which is also supported
+	// by repositories of version 2
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "2"}, env2.gopts, nil))
+	gopts := env2.gopts
+	copyOpts := CopyOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env.gopts.Repo,
+			password: env.gopts.password,
+		},
+		Rechunk: true,
+	}
+	rtest.OK(t, runCopy(context.TODO(), copyOpts, gopts, nil))
+	copiedSnapshotIDs := testListSnapshots(t, env2.gopts, 1)
+	testRunCheck(t, env2.gopts)
+	testCheckNotBundled(t, env2.gopts, copiedSnapshotIDs[0])
+
+	restoredir := filepath.Join(env2.base, "restore")
+	testRunRestore(t, env2.gopts, restoredir, copiedSnapshotIDs[0])
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "rechunked snapshot differs:\n%v", diff)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// testUncachedTreePacks returns the number of pack files with trees of the
+// snapshot which are not contained in the cache.
+func testUncachedTreePacks(t testing.TB, gopts GlobalOptions, snapshotID restic.ID) int {
+	// collect the trees without modifying the cache
+	noCacheOpts := gopts
+	noCacheOpts.NoCache = true
+	repo, err := OpenRepository(context.TODO(), noCacheOpts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, snapshotID)
+	rtest.OK(t, err)
+	trees := restic.NewBlobSet()
+	rtest.OK(t, restic.FindUsedBlobs(context.TODO(), repo, restic.IDs{*sn.Tree}, trees, nil))
+
+	packs := restic.NewIDSet()
+	for h := range trees {
+		if h.Type != restic.TreeBlob {
+			continue
+		}
+		for _, pb := range repo.Index().Lookup(h) {
+			packs.Insert(pb.PackID)
+		}
+	}
+	rtest.Assert(t, len(packs) > 0, "no tree packs found")
+
+	repo, err = OpenRepository(context.TODO(), gopts)
+	rtest.OK(t, err)
+	missing := 0
+	for id := range packs {
+		if !repo.Cache.Has(restic.Handle{Type: restic.PackFile, Name: id.String()}) {
+			missing++
+		}
+	}
+	return missing
+}
+
+func TestBackupPrimeCache(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// priming lists the index files again
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	target := []string{filepath.Join(env.testdata, "0", "0", "9")}
+
+	// the trees of this snapshot are not cached
+	env.gopts.NoCache = true
+	testRunBackup(t, "", target, BackupOptions{}, env.gopts)
+	env.gopts.NoCache = false
+
+	// without a parent, the existing trees are neither loaded nor uploaded
+	testRunBackup(t, "", target, BackupOptions{Force: true}, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, testUncachedTreePacks(t, env.gopts, *newest.ID) > 0, "tree packs were cached without priming")
+
+	testRunBackup(t, "", target, BackupOptions{Force: true, PrimeCache: true}, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 0, testUncachedTreePacks(t, env.gopts, *newest.ID))
+}
+
+func TestCacheWarm(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// warming lists the index files again
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	target := []string{filepath.Join(env.testdata, "0", "0", "9")}
+
+	env.gopts.NoCache = true
+	testRunBackup(t, "", target, BackupOptions{}, env.gopts)
+	env.gopts.NoCache = false
+
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, testUncachedTreePacks(t, env.gopts, *newest.ID) > 0, "tree packs are already cached")
+
+	rtest.OK(t, runCacheWarm(context.TODO(), CacheWarmOptions{}, env.gopts, []string{newest.ID.String()}))
+	rtest.Equals(t, 0, testUncachedTreePacks(t, env.gopts, *newest.ID))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunConfigGet(t testing.TB, gopts GlobalOptions) map[string][]string {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+	rtest.OK(t, runConfigGet(context.TODO(), gopts, nil))
+
+	var defaults map[string][]string
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &defaults))
+	return defaults
+}
+
+func TestConfigDefaults(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	testRunInit(t, env.gopts)
+	// the snapshots are listed by several commands
+	env.gopts.backendTestHook = nil
+
+	rtest.Equals(t, map[string][]string{}, testRunConfigGet(t, env.gopts))
+
+	rtest.OK(t, runConfigSet(context.TODO(), env.gopts, []string{"exclude", "*.tmp", "*.bak"}))
+	rtest.OK(t, runConfigSet(context.TODO(), env.gopts, []string{"keep-last", "1"}))
+	rtest.OK(t, runConfigSet(context.TODO(), env.gopts, []string{"pack-size", "32"}))
+	rtest.Equals(t, map[string][]string{
+		"exclude":   {"*.tmp", "*.bak"},
+		"keep-last": {"1"},
+		"pack-size": {"32"},
+	}, testRunConfigGet(t, env.gopts))
+
+	for _, args := range [][]string{
+		{"no-such-option", "1"},
+		{"keep-last", "abc"},
+		{"keep-last", "1", "2"},
+		{"compression", "fast"},
+		{"pack-size", "1"},
+		{"exclude", "["},
+	} {
+		err := runConfigSet(context.TODO(), env.gopts, args)
+		rtest.Assert(t, err != nil, "missing error for %v", args)
+	}
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(32*1024*1024), repo.PackSize())
+	gopts := env.gopts
+	gopts.PackSize = 16
+	repo, err = OpenRepository(context.TODO(), gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(16*1024*1024), repo.PackSize())
+
+	// the stored excludes are applied to each backup
+	for _, name := range []string{"file", "file.tmp", "file.bak"} {
+		rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+		rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, name), []byte(name), 0644))
+	}
+	testRunBackup(t, env.testdata, []string{"."}, BackupOptions{}, env.gopts)
+	testRunBackup(t, env.testdata, []string{"."}, BackupOptions{}, env.gopts)
+	ids := testListSnapshots(t, env.gopts, 2)
+	rtest.Equals(t, []string{"/file", ""}, testRunLs(t, env.gopts, ids[0].String()))
+
+	// forget uses the stored policy if no policy is passed
+	testRunForget(t, env.gopts)
+	testListSnapshots(t, env.gopts, 1)
+
+	rtest.OK(t, runConfigUnset(context.TODO(), env.gopts, []string{"exclude", "keep-last"}))
+	rtest.Equals(t, map[string][]string{"pack-size": {"32"}}, testRunConfigGet(t, env.gopts))
+	rtest.OK(t, runConfigUnset(context.TODO(), env.gopts, []string{"pack-size"}))
+	rtest.Equals(t, map[string][]string{}, testRunConfigGet(t, env.gopts))
+	testRunCheck(t, env.gopts)
+}
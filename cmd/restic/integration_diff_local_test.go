@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunDiffLocal(t testing.TB, gopts GlobalOptions, opts DiffOptions, snapshotID string) map[string]string {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	// quiet suppresses the changes
+	gopts.Quiet = false
+	rtest.OK(t, runDiff(context.TODO(), opts, gopts, []string{snapshotID}))
+
+	changes := make(map[string]string)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.HasPrefix(fields[1], "/") {
+			changes[fields[1]] = fields[0]
+		}
+	}
+	return changes
+}
+
+func TestDiffAgainstLocal(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Equals(t, 1, len(snapshotIDs))
+	snapshotID := snapshotIDs[0].String()
+
+	opts := DiffOptions{AgainstLocal: env.testdata}
+	rtest.Equals(t, map[string]string{}, testRunDiffLocal(t, env.gopts, opts, snapshotID))
+
+	dir := filepath.Join(env.testdata, "0", "0", "9")
+	// modified contents with the same size
+	buf, err := os.ReadFile(filepath.Join(dir, "3"))
+	rtest.OK(t, err)
+	buf[0]++
+	rtest.OK(t, os.WriteFile(filepath.Join(dir, "3"), buf, 0644))
+	// appended data
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "5"), 100))
+	// only the modification time changed
+	rtest.OK(t, os.Chtimes(filepath.Join(dir, "8"), time.Now(), time.Now().Add(-time.Hour)))
+	rtest.OK(t, os.Remove(filepath.Join(dir, "17")))
+	rtest.OK(t, os.RemoveAll(filepath.Join(env.testdata, "0", "tests")))
+	rtest.OK(t, os.MkdirAll(filepath.Join(env.testdata, "0", "new", "sub"), 0755))
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "0", "new", "sub", "file"), []byte("new"), 0644))
+	rtest.OK(t, os.Mkdir(filepath.Join(dir, "23.tmp"), 0755))
+	rtest.OK(t, os.Rename(filepath.Join(dir, "23"), filepath.Join(dir, "23.tmp", "23")))
+	rtest.OK(t, os.Rename(filepath.Join(dir, "23.tmp"), filepath.Join(dir, "23")))
+
+	prefix := snapshotPath(env.testdata)
+	snDir := path.Join(prefix, "0", "0", "9")
+	expected := map[string]string{
+		snDir + "/3":                          "M",
+		snDir + "/5":                          "M",
+		snDir + "/17":                         "-",
+		snDir + "/23/":                        "T",
+		prefix + "/0/tests/":                  "-",
+		prefix + "/0/tests/empty-file":        "-",
+		prefix + "/0/tests/testfile":          "-",
+		prefix + "/0/tests/testfile-symlink":  "-",
+		prefix + "/0/tests/testfile-hardlink": "-",
+		prefix + "/0/new/":                    "+",
+		prefix + "/0/new/sub/":                "+",
+		prefix + "/0/new/sub/file":            "+",
+	}
+	changes := testRunDiffLocal(t, env.gopts, opts, snapshotID)
+	// the modified directories are only reported with --metadata
+	rtest.Equals(t, expected, changes)
+
+	opts.ShowMetadata = true
+	changes = testRunDiffLocal(t, env.gopts, opts, snapshotID)
+	rtest.Equals(t, "U", changes[snDir+"/8"])
+	rtest.Equals(t, "U", changes[snDir+"/"])
+
+	// a subdirectory of the backup can be compared
+	opts = DiffOptions{AgainstLocal: dir}
+	changes = testRunDiffLocal(t, env.gopts, opts, snapshotID)
+	rtest.Equals(t, "M", changes[snDir+"/3"])
+	rtest.Equals(t, "T", changes[snDir+"/23/"])
+	_, ok := changes[prefix+"/0/new/"]
+	rtest.Assert(t, !ok, "changes outside of the directory were reported")
+
+	opts = DiffOptions{AgainstLocal: filepath.Join(env.testdata, "0", "new")}
+	err = runDiff(context.TODO(), opts, env.gopts, []string{snapshotID})
+	rtest.Assert(t, err != nil, "comparing a directory missing in the snapshot did not fail")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type testDoctorFinding struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Message  string `json:"message"`
+	Command  string `json:"command"`
+}
+
+func testRunDoctor(t testing.TB, gopts GlobalOptions) ([]testDoctorFinding, error) {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+
+	runErr := runDoctor(context.TODO(), gopts, nil)
+
+	var findings []testDoctorFinding
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &findings))
+	return findings, runErr
+}
+
+func TestDoctor(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	findings, err := testRunDoctor(t, env.gopts)
+	rtest.OK(t, err)
+	for _, f := range findings {
+		rtest.Assert(t, f.Severity == "info", "unexpected finding %v", f)
+	}
+
+	removePacksExcept(env.gopts, t, restic.NewIDSet(), false)
+
+	findings, err = testRunDoctor(t, env.gopts)
+	rtest.Assert(t, err != nil, "doctor did not fail for missing pack files")
+	rtest.Assert(t, len(findings) > 0, "no findings reported")
+	rtest.Equals(t, "error", findings[0].Severity)
+	rtest.Equals(t, "index", findings[0].Check)
+	rtest.Equals(t, "restic repair index", findings[0].Command)
+}
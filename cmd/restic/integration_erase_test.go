@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunErase(gopts GlobalOptions, opts EraseOptions, args []string) error {
+	return runErase(context.TODO(), opts, gopts, args)
+}
+
+func TestEraseDataKeys(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	// must list keys more than once
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{DataKeys: true}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+	rtest.Assert(t, len(testRunList(t, "datakeys", env.gopts)) > 0, "no data keys were saved")
+	testRunCheck(t, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "directories are not equal %v", diff)
+
+	// the data keys are shared by both snapshots
+	err := testRunErase(env.gopts, EraseOptions{Paths: []string{"/testdata/0/0"}}, []string{snapshotIDs[0].String()})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "also used by files"), "unexpected error %v", err)
+
+	rtest.OK(t, testRunErase(env.gopts, EraseOptions{Paths: []string{"/testdata/0/0"}, AllSnapshots: true}, nil))
+	for _, id := range testListSnapshots(t, env.gopts, 2) {
+		var found bool
+		for _, line := range testRunLs(t, env.gopts, id.String()) {
+			if strings.HasPrefix(line, "/testdata/0/0") {
+				t.Errorf("snapshot %v still contains %v", id.Str(), line)
+			}
+			found = found || strings.HasPrefix(line, "/testdata/0/")
+		}
+		rtest.Assert(t, found, "snapshot %v does not contain the remaining files", id.Str())
+	}
+
+	// check forbids unused blobs, thus remove them first
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	testRunCheck(t, env.gopts)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunExportMetadata(gopts GlobalOptions, opts ExportMetadataOptions) error {
+	return runExportMetadata(context.TODO(), opts, gopts, nil)
+}
+
+func TestExportMetadata(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	err := testRunExportMetadata(env.gopts, ExportMetadataOptions{})
+	rtest.Assert(t, err != nil, "export without database file did not fail")
+
+	filename := filepath.Join(env.base, "metadata.db")
+	rtest.OK(t, testRunExportMetadata(env.gopts, ExportMetadataOptions{SQLite: filename}))
+	buf, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, "SQLite format 3\x00", string(buf[:16]))
+	rtest.Assert(t, len(buf) > 4096, "database only contains the schema")
+
+	// an existing database is not overwritten
+	err = testRunExportMetadata(env.gopts, ExportMetadataOptions{SQLite: filename})
+	rtest.Assert(t, err != nil, "overwriting the database did not fail")
+	newBuf, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, buf, newBuf)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunExport(t testing.TB, gopts GlobalOptions, opts ExportOptions, args ...string) {
+	rtest.OK(t, runExport(context.TODO(), opts, gopts, args))
+}
+
+func testRunImport(t testing.TB, gopts GlobalOptions, opts ImportOptions, stream string) {
+	rtest.OK(t, runImport(context.TODO(), opts, gopts, []string{stream}))
+}
+
+func TestExportImport(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+
+	passwordFile := filepath.Join(env.base, "stream-password")
+	rtest.OK(t, os.WriteFile(passwordFile, []byte("stream secret\n"), 0600))
+	stream := filepath.Join(env.base, "export.stream")
+	testRunExport(t, env.gopts, ExportOptions{Output: stream, StreamPasswordFile: passwordFile})
+
+	testRunInit(t, env2.gopts)
+	testRunImport(t, env2.gopts, ImportOptions{StreamPasswordFile: passwordFile}, stream)
+	importedIDs := testListSnapshots(t, env2.gopts, 2)
+	testRunCheck(t, env2.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env2.gopts, restoredir, importedIDs[0])
+	original := filepath.Join(env.base, "original")
+	testRunRestore(t, env.gopts, original, snapshotIDs[0])
+	diff1 := directoriesContentsDiff(original, restoredir)
+	original2 := filepath.Join(env.base, "original2")
+	testRunRestore(t, env.gopts, original2, snapshotIDs[1])
+	diff2 := directoriesContentsDiff(original2, restoredir)
+	rtest.Assert(t, diff1 == "" || diff2 == "", "imported snapshot differs from both originals:\n%v\n%v", diff1, diff2)
+
+	// importing the stream again does not create duplicate snapshots
+	testRunImport(t, env2.gopts, ImportOptions{StreamPasswordFile: passwordFile}, stream)
+	testListSnapshots(t, env2.gopts, 2)
+
+	// a wrong password is rejected
+	rtest.OK(t, os.WriteFile(passwordFile, []byte("wrong"), 0600))
+	err := runImport(context.TODO(), ImportOptions{StreamPasswordFile: passwordFile}, env2.gopts, []string{stream})
+	rtest.Assert(t, err != nil, "missing error for wrong stream password")
+}
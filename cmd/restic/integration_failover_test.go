@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestFailover(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	primary := filepath.Join(env.base, "primary")
+	secondary := filepath.Join(env.base, "secondary")
+	// the test hook hides the nested backends
+	env.gopts.backendTestHook = nil
+
+	// keep both copies in sync by writing them as mirrors
+	env.gopts.Repo = "mirror:" + primary + "," + secondary
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	env.gopts.Repo = "failover:" + primary + "," + secondary
+	testListSnapshots(t, env.gopts, 1)
+
+	// the primary copy is lost
+	rtest.OK(t, os.RemoveAll(primary))
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stderr = buf
+	defer func() {
+		globalOptions.stderr = os.Stderr
+	}()
+
+	env.gopts.JSON = true
+	testListSnapshots(t, env.gopts, 1)
+
+	var events []failoverEvent
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var ev failoverEvent
+		if json.Unmarshal(sc.Bytes(), &ev) == nil && ev.MessageType == "failover" {
+			events = append(events, ev)
+		}
+	}
+	rtest.Equals(t, 1, len(events))
+	rtest.Equals(t, primary, events[0].From)
+	rtest.Equals(t, secondary, events[0].To)
+	rtest.Assert(t, events[0].Error != "", "failover event contains no error")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestFindHashAndSize(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "0", "small"), []byte("small file\n"), 0644))
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "0", "small-copy"), []byte("small file\n"), 0644))
+	// a file consisting of several blobs
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "large"), 5*1024*1024))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	find := func(opts FindOptions, args ...string) []string {
+		return strings.Fields(string(testRunFindOutput(t, env.gopts, opts, args...)))
+	}
+
+	// collect the expected results from the backed up files
+	var sized []string
+	err := filepath.Walk(env.testdata, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		nodepath := "/testdata/" + filepath.ToSlash(strings.TrimPrefix(p, env.testdata+string(filepath.Separator)))
+		if fi.Size() >= 10*1024 && fi.Size() <= 5*1024*1024 {
+			sized = append(sized, nodepath)
+		}
+		return nil
+	})
+	rtest.OK(t, err)
+	rtest.Assert(t, len(sized) > 1, "test data contains no matching files")
+
+	buf, err := os.ReadFile(filepath.Join(env.testdata, "0", "large"))
+	rtest.OK(t, err)
+	hash := sha256.Sum256(buf)
+	largeHash := hex.EncodeToString(hash[:])
+
+	hash = sha256.Sum256([]byte("small file\n"))
+	smallHash := hex.EncodeToString(hash[:])
+	rtest.Equals(t, []string{"/testdata/0/small", "/testdata/0/small-copy"}, find(FindOptions{Hashes: []string{smallHash}}))
+	rtest.Equals(t, []string{"/testdata/0/small"}, find(FindOptions{Hashes: []string{smallHash}}, "small"))
+	rtest.Equals(t, []string{"/testdata/0/large"}, find(FindOptions{Hashes: []string{largeHash}}))
+	rtest.Equals(t, []string{}, find(FindOptions{Hashes: []string{strings.Repeat("0", 64)}}))
+
+	rtest.Equals(t, sized, find(FindOptions{MinSize: "10K", MaxSize: "5M"}))
+	rtest.Equals(t, []string{"/testdata/0/small", "/testdata/0/small-copy"}, find(FindOptions{MinSize: "1", MaxSize: "11"}, "/testdata/0/*"))
+
+	// the search index does not change the results
+	testRunIndexFiles(t, env.gopts, IndexFilesOptions{})
+	rtest.Equals(t, []string{"/testdata/0/small", "/testdata/0/small-copy"}, find(FindOptions{Hashes: []string{smallHash}}))
+	rtest.Equals(t, sized, find(FindOptions{MinSize: "10K", MaxSize: "5M"}))
+}
+
+func TestFindRegexAndCount(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "0", "testfile-extra"), []byte("extra\n"), 0644))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	newest, snapshots := testRunSnapshots(t, env.gopts)
+
+	find := func(opts FindOptions, args ...string) []string {
+		return strings.Split(strings.TrimSpace(string(testRunFindOutput(t, env.gopts, opts, args...))), "\n")
+	}
+	findSnapshots := func(opts FindOptions, args ...string) map[string]int {
+		env.gopts.JSON = true
+		defer func() {
+			env.gopts.JSON = false
+		}()
+		var list []findSnapshot
+		rtest.OK(t, json.Unmarshal(testRunFindOutput(t, env.gopts, opts, args...), &list))
+		result := make(map[string]int)
+		for _, sn := range list {
+			result[sn.SnapshotID] = sn.Hits
+		}
+		return result
+	}
+
+	check := func() {
+		rtest.Equals(t, find(FindOptions{}, "testfile*"), find(FindOptions{Regex: true}, "/testfile[^/]*$"))
+		rtest.Equals(t, find(FindOptions{}, "testfile*"), find(FindOptions{Regex: true, CaseInsensitive: true}, `/TESTFILE[^/]*$`))
+		rtest.Equals(t, find(FindOptions{}, "/testdata/0/0/9"), find(FindOptions{Regex: true}, "^/testdata/0/0/9(/|$)"))
+		rtest.Equals(t, []string{""}, find(FindOptions{Regex: true}, "^testdata"))
+
+		counts := findSnapshots(FindOptions{Count: true}, "testfile*")
+		rtest.Equals(t, 2, len(counts))
+		rtest.Equals(t, 4, counts[newest.ID.String()])
+		for id, hits := range counts {
+			if id != newest.ID.String() {
+				rtest.Equals(t, 3, hits)
+			}
+		}
+
+		rtest.Equals(t, map[string]int{newest.ID.String(): 0}, findSnapshots(FindOptions{SnapshotsOnly: true}, "testfile-extra"))
+		rtest.Equals(t, 2, len(findSnapshots(FindOptions{SnapshotsOnly: true}, "testfile*")))
+		rtest.Equals(t, []string{newest.ID.Str() + " " + newest.Time.Local().Format(TimeFormat)}, find(FindOptions{SnapshotsOnly: true}, "testfile-extra"))
+
+		lines := find(FindOptions{ShowSnapshot: true}, "testfile*")
+		rtest.Equals(t, 7, len(lines))
+		for _, line := range lines {
+			found := false
+			for id, sn := range snapshots {
+				found = found || strings.HasPrefix(line, id.Str()+" "+sn.Time.Local().Format(TimeFormat)+"  /testdata/")
+			}
+			rtest.Assert(t, found, "unexpected line %q", line)
+		}
+	}
+
+	check()
+	testRunIndexFiles(t, env.gopts, IndexFilesOptions{})
+	check()
+}
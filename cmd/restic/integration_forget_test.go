@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestForgetIDLists(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+	for i := 0; i < 3; i++ {
+		testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	}
+	_, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 3, len(snapshots))
+
+	var list []restic.ID
+	for id := range snapshots {
+		list = append(list, id)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return snapshots[list[i]].Time.Before(snapshots[list[j]].Time)
+	})
+	oldest, middle, newest := list[0], list[1], list[2]
+
+	keepFile := filepath.Join(env.base, "keep-ids")
+	rtest.OK(t, os.WriteFile(keepFile, []byte("# legal hold\n"+oldest.Str()+"\n\n"+restic.NewRandomID().String()+"\n"), 0600))
+	forgetFile := filepath.Join(env.base, "forget-ids")
+	rtest.OK(t, os.WriteFile(forgetFile, []byte(newest.String()+"\n"), 0600))
+
+	// the policy keeps the newest two snapshots, the lists override it
+	rtest.OK(t, runForget(context.TODO(), ForgetOptions{Last: 2, KeepIDsFrom: keepFile, ForgetIDsFrom: forgetFile}, env.gopts, nil))
+	_, snapshots = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 2, len(snapshots))
+	for _, id := range []restic.ID{oldest, middle} {
+		_, ok := snapshots[id]
+		rtest.Assert(t, ok, "snapshot %v was removed", id.Str())
+	}
+
+	// without a policy, only the listed snapshots are removed
+	rtest.OK(t, os.WriteFile(forgetFile, []byte(middle.Str()+"\n"), 0600))
+	rtest.OK(t, runForget(context.TODO(), ForgetOptions{ForgetIDsFrom: forgetFile}, env.gopts, nil))
+	_, snapshots = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapshots))
+	_, ok := snapshots[oldest]
+	rtest.Assert(t, ok, "snapshot %v was removed", oldest.Str())
+
+	// a snapshot must not be both kept and forgotten
+	rtest.OK(t, os.WriteFile(forgetFile, []byte(oldest.String()+"\n"), 0600))
+	err := runForget(context.TODO(), ForgetOptions{KeepIDsFrom: keepFile, ForgetIDsFrom: forgetFile}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "conflicting ID lists were accepted")
+	testRunCheck(t, env.gopts)
+}
+
+func TestForgetSimulateUntil(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	now := time.Now()
+	for i := 3; i > 0; i-- {
+		opts := BackupOptions{TimeStamp: now.AddDate(0, 0, -i).Format(TimeFormat)}
+		testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	gopts.JSON = true
+	opts := ForgetOptions{
+		Daily:            2,
+		SimulateUntil:    now.AddDate(0, 0, 5).Format(TimeFormat),
+		SimulateInterval: restic.Duration{Days: 1},
+	}
+	rtest.OK(t, runForget(context.TODO(), opts, gopts, nil))
+
+	var groups []ForgetGroup
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &groups))
+	rtest.Equals(t, 1, len(groups))
+	sim := groups[0].Simulation
+	rtest.Assert(t, sim != nil, "no simulation result in %s", buf.String())
+	rtest.Equals(t, 3, len(sim.Snapshots))
+	for _, sn := range sim.Snapshots {
+		rtest.Assert(t, sn.RemovedAt != nil, "snapshot %v of %v is never removed", sn.ShortID, sn.Time)
+	}
+	rtest.Equals(t, 2, sim.Kept)
+	rtest.Equals(t, 2, sim.Simulated)
+
+	// the simulation does not remove anything
+	_, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 3, len(snapshots))
+
+	err := runForget(context.TODO(), ForgetOptions{Daily: 2, SimulateUntil: "someday", SimulateInterval: restic.Duration{Days: 1}}, gopts, nil)
+	rtest.Assert(t, err != nil, "invalid time was accepted")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestKeyIdentity(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	// must list keys more than once
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+
+	identityFile := filepath.Join(env.base, "identity")
+	rtest.OK(t, runKey(context.TODO(), env.gopts, []string{"new-identity", identityFile}))
+	rtest.Assert(t, runKey(context.TODO(), env.gopts, []string{"new-identity", identityFile}) != nil,
+		"expected error for existing identity file")
+
+	keyRecipientFile = identityFile + ".pub"
+	defer func() {
+		keyRecipientFile = ""
+	}()
+	rtest.OK(t, runKey(context.TODO(), env.gopts, []string{"add"}))
+	keyRecipientFile = ""
+
+	out := testRunKeyList(t, env.gopts)
+	found := false
+	for _, key := range out {
+		if key.KDF.Name == crypto.HybridKEM {
+			found = true
+		}
+	}
+	rtest.Assert(t, found, "key for recipient not listed in %v", out)
+
+	// open the repository using the identity instead of the password
+	gopts := env.gopts
+	gopts.password = ""
+	gopts.IdentityFile = identityFile
+	rtest.Equals(t, snapshotIDs, testListSnapshots(t, gopts, 1))
+	testRunCheck(t, gopts)
+
+	otherFile := filepath.Join(env.base, "other")
+	rtest.OK(t, runKey(context.TODO(), env.gopts, []string{"new-identity", otherFile}))
+	gopts.IdentityFile = otherFile
+	_, err := OpenRepository(context.TODO(), gopts)
+	rtest.Assert(t, err != nil, "expected error for identity without key")
+}
+
+func testRunKeyList(t testing.TB, gopts GlobalOptions) []keyInfo {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	gopts.JSON = true
+	rtest.OK(t, runKey(context.TODO(), gopts, []string{"list"}))
+
+	var keys []keyInfo
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &keys))
+	return keys
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func writeTestLayer(t testing.TB, filename string, level int) []byte {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	data := bytes.Repeat(rtest.Random(42, 64*1024), 8)
+	rtest.OK(t, tw.WriteHeader(&tar.Header{Name: "usr/lib/libfoo.so", Mode: 0644, Size: int64(len(data))}))
+	_, err := tw.Write(data)
+	rtest.OK(t, err)
+	rtest.OK(t, tw.Close())
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	rtest.OK(t, err)
+	_, err = zw.Write(tarBuf.Bytes())
+	rtest.OK(t, err)
+	rtest.OK(t, zw.Close())
+
+	rtest.OK(t, os.WriteFile(filename, buf.Bytes(), 0644))
+	return buf.Bytes()
+}
+
+func TestBackupUnpackLayers(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	layers := map[string][]byte{
+		"layer1.tar.gz": writeTestLayer(t, filepath.Join(env.testdata, "layer1.tar.gz"), gzip.BestSpeed),
+		"layer2.tar.gz": writeTestLayer(t, filepath.Join(env.testdata, "layer2.tar.gz"), gzip.BestCompression),
+	}
+
+	opts := BackupOptions{UnpackLayers: true}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, snapshotIDs[0])
+	rtest.OK(t, err)
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(tree.Nodes))
+	tree, err = restic.LoadTree(context.TODO(), repo, *tree.Nodes[0].Subtree)
+	rtest.OK(t, err)
+
+	// both layers share the same decompressed content
+	rtest.Equals(t, 2, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		rtest.Assert(t, node.ContentEncoding != nil, "layer %v was not unpacked", node.Name)
+		rtest.Equals(t, uint64(len(layers[node.Name])), node.Size)
+		rtest.Equals(t, restic.Hash(layers[node.Name]), *node.ContentEncoding.Hash)
+	}
+	rtest.Equals(t, tree.Nodes[0].Content, tree.Nodes[1].Content)
+
+	restoredir := filepath.Join(env.base, "restore")
+	rtest.OK(t, runRestore(context.TODO(), RestoreOptions{Target: restoredir, Verify: true}, env.gopts, nil, []string{snapshotIDs[0].String()}))
+	for name, data := range layers {
+		buf, err := os.ReadFile(filepath.Join(restoredir, "testdata", name))
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(data, buf), "restored layer %v differs", name)
+	}
+}
+
+func TestBackupUnpackLayersRequiresVersion3(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "2"}, env.gopts, nil))
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	writeTestLayer(t, filepath.Join(env.testdata, "layer.tar.gz"), gzip.DefaultCompression)
+
+	// older versions of restic would restore the decompressed data
+	opts := BackupOptions{UnpackLayers: true}
+	err := testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "unpacking layers in a version 2 repository did not fail")
+	testListSnapshots(t, env.gopts, 0)
+
+	rtest.OK(t, runMigrate(context.TODO(), MigrateOptions{}, env.gopts, []string{"upgrade_repo_v3"}))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	testListSnapshots(t, env.gopts, 1)
+
+	// the decompressed data must not be saved to additional version 2 repositories
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "2"}, env2.gopts, nil))
+	passwordFile := filepath.Join(env.base, "password2")
+	rtest.OK(t, os.WriteFile(passwordFile, []byte(env2.gopts.password), 0600))
+	opts.AdditionalRepos = []string{env2.gopts.Repo}
+	opts.AdditionalPasswordFiles = []string{passwordFile}
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "unpacking layers for an additional version 2 repository did not fail")
+	testListSnapshots(t, env.gopts, 1)
+	testListSnapshots(t, env2.gopts, 0)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunMaintain(t testing.TB, opts MaintainOptions, gopts GlobalOptions) MaintainReport {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+	rtest.OK(t, runMaintain(context.TODO(), opts, gopts, nil))
+
+	var report MaintainReport
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &report))
+	return report
+}
+
+func TestMaintain(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	// the snapshots and index files are listed by several steps
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	// remove some data between the backups of the same path
+	dir := filepath.Join(env.testdata, "0", "0", "9")
+	for _, sub := range []string{"2", "3", "4"} {
+		testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+		rtest.OK(t, os.RemoveAll(filepath.Join(dir, sub)))
+	}
+
+	// without a stored policy no snapshot is removed
+	report := testRunMaintain(t, MaintainOptions{ReadDataSubset: "100%"}, env.gopts)
+	rtest.Assert(t, report.Forget == nil, "unexpected forget report %v", report.Forget)
+	rtest.Assert(t, report.Check != nil && report.Check.Error == "", "unexpected check report %v", report.Check)
+	rtest.Equals(t, 3, len(testRunList(t, "snapshots", env.gopts)))
+
+	rtest.OK(t, runConfigSet(context.TODO(), env.gopts, []string{"keep-last", "1"}))
+
+	report = testRunMaintain(t, MaintainOptions{DryRun: true, SkipCheck: true}, env.gopts)
+	rtest.Assert(t, report.DryRun, "expected dry run")
+	rtest.Equals(t, 2, report.Forget.Removed)
+	rtest.Assert(t, report.Prune.RemoveBytes > 0, "expected data to be removed, got %v", report.Prune)
+	rtest.Assert(t, report.Check == nil, "unexpected check report %v", report.Check)
+	rtest.Equals(t, 3, len(testRunList(t, "snapshots", env.gopts)))
+
+	report = testRunMaintain(t, MaintainOptions{ReadDataSubset: "100%"}, env.gopts)
+	rtest.Equals(t, 2, report.Forget.Removed)
+	rtest.Assert(t, report.Prune.RemoveBytes > 0, "expected data to be removed, got %v", report.Prune)
+	rtest.Assert(t, report.Check != nil && report.Check.Error == "", "unexpected check report %v", report.Check)
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+
+	rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+}
@@ -0,0 +1,86 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// writeFakeBorg creates a script which mimics the borg commands used by
+// migrate-from for archives stored as directories below dir.
+func writeFakeBorg(t testing.TB, dir string) string {
+	script := fmt.Sprintf(`#!/bin/sh
+name=${2##*::}
+case "$1" in
+list)
+	echo '{"archives": [{"name": "old", "start": "2020-01-02T03:04:05.000000"}, {"name": "new", "start": "2021-06-07T08:09:10.123456"}]}'
+	;;
+info)
+	case "${3##*::}" in
+	old) echo '{"archives": [{"name": "old", "hostname": "borghost", "start": "2020-01-02T03:04:05.000000"}]}' ;;
+	new) echo '{"archives": [{"name": "new", "hostname": "borghost", "start": "2021-06-07T08:09:10.123456"}]}' ;;
+	esac
+	;;
+export-tar)
+	tar -C %q/"$name" -cf - .
+	;;
+esac
+`, dir)
+
+	filename := filepath.Join(t.TempDir(), "borg")
+	rtest.OK(t, os.WriteFile(filename, []byte(script), 0755))
+	return filename
+}
+
+func TestMigrateFromBorg(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	testRunInit(t, env.gopts)
+
+	archives := filepath.Join(env.base, "archives")
+	for _, name := range []string{"old", "new"} {
+		dir := filepath.Join(archives, name, "home", "user")
+		rtest.OK(t, os.MkdirAll(dir, 0755))
+		rtest.OK(t, os.WriteFile(filepath.Join(dir, "file"), []byte("content of "+name), 0644))
+	}
+
+	opts := MigrateFromOptions{BorgCommand: writeFakeBorg(t, archives)}
+	rtest.OK(t, runMigrateFrom(context.TODO(), opts, env.gopts, []string{"borg:/srv/borg"}))
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	var times []time.Time
+	for _, id := range snapshotIDs {
+		sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+		rtest.OK(t, err)
+		rtest.Equals(t, "borghost", sn.Hostname)
+		rtest.Equals(t, []string{"/"}, sn.Paths)
+		times = append(times, sn.Time)
+	}
+	want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	rtest.Assert(t, times[0].Equal(want) || times[1].Equal(want), "snapshot times %v do not contain %v", times, want)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	buf, err := os.ReadFile(filepath.Join(restoredir, "home", "user", "file"))
+	rtest.OK(t, err)
+	rtest.Assert(t, string(buf) == "content of old" || string(buf) == "content of new", "wrong content of restored file %q", buf)
+	testRunCheck(t, env.gopts)
+
+	// archives which were converted before are skipped
+	rtest.OK(t, runMigrateFrom(context.TODO(), opts, env.gopts, []string{"borg:/srv/borg", "old"}))
+	testListSnapshots(t, env.gopts, 2)
+
+	err = runMigrateFrom(context.TODO(), opts, env.gopts, []string{"duplicity:/srv/backup"})
+	rtest.Assert(t, err != nil, "missing error for unsupported source")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunMigrateCheckAll(t testing.TB, gopts GlobalOptions) map[string]migrationStep {
+	buf := bytes.NewBuffer(nil)
+
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	gopts.JSON = true
+	opts := MigrateOptions{CheckAll: true, AssumedThroughput: "20M"}
+	rtest.OK(t, runMigrate(context.TODO(), opts, gopts, nil))
+
+	var steps []migrationStep
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &steps))
+
+	res := make(map[string]migrationStep)
+	for _, step := range steps {
+		res[step.Name] = step
+	}
+	return res
+}
+
+func TestMigrateApplyAll(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// each migration loads the index
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "1"}, env.gopts, nil))
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	steps := testRunMigrateCheckAll(t, env.gopts)
+	upgrade := steps["upgrade_repo_v2"]
+	rtest.Assert(t, upgrade.Applicable, "upgrade_repo_v2 is not applicable: %v", upgrade.Reason)
+	rtest.Assert(t, upgrade.RepoCheck, "upgrade_repo_v2 does not check the repository")
+
+	compress := steps["compress_repo"]
+	rtest.Assert(t, compress.Applicable, "compress_repo is not applicable: %v", compress.Reason)
+	rtest.Equals(t, []string{"upgrade_repo_v2"}, compress.Requires)
+	rtest.Assert(t, compress.Bytes > 0 && compress.Files > 0, "missing estimate for compress_repo: %+v", compress)
+
+	rtest.OK(t, runMigrate(context.TODO(), MigrateOptions{ApplyAll: true}, env.gopts, nil))
+	testRunCheck(t, env.gopts)
+
+	steps = testRunMigrateCheckAll(t, env.gopts)
+	for _, name := range []string{"upgrade_repo_v2", "compress_repo"} {
+		rtest.Assert(t, !steps[name].Applicable, "%v is still applicable", name)
+	}
+
+	// running the migrations again does nothing
+	rtest.OK(t, runMigrate(context.TODO(), MigrateOptions{ApplyAll: true}, env.gopts, nil))
+}
+
+func TestMigrateRechunk(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// each migration loads the index
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	// a file which consists of several chunks
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "large"), rtest.Random(42, 5*1024*1024), 0644))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotID := testListSnapshots(t, env.gopts, 1)[0]
+
+	steps := testRunMigrateCheckAll(t, env.gopts)
+	rtest.Assert(t, !steps["rechunk"].Applicable, "rechunk is applicable without a polynomial")
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	oldPol := repo.Config().ChunkerPolynomial
+
+	rtest.OK(t, runMigrate(context.TODO(), MigrateOptions{ChunkerPolynomial: "random"}, env.gopts, []string{"rechunk"}))
+
+	repo, err = OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, repo.Config().ChunkerPolynomial != oldPol, "chunker polynomial was not changed")
+
+	newSnapshotID := testListSnapshots(t, env.gopts, 1)[0]
+	rtest.Assert(t, newSnapshotID != snapshotID, "snapshot was not rewritten")
+	rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, newSnapshotID)
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "directories are not equal %v", diff)
+
+	// continuing the migration does not change anything
+	pol := repo.Config().ChunkerPolynomial.String()
+	rtest.OK(t, runMigrate(context.TODO(), MigrateOptions{ChunkerPolynomial: pol, Force: true}, env.gopts, []string{"rechunk"}))
+	rtest.Equals(t, newSnapshotID, testListSnapshots(t, env.gopts, 1)[0])
+}
+
+func TestMigrateRechunkBundled(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// each migration loads the index
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	for i := 0; i < 20; i++ {
+		rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, fmt.Sprintf("file%d", i)), rtest.Random(i, 100+i*10), 0644))
+	}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{BundleSmallerThan: "4K"}, env.gopts)
+
+	rtest.OK(t, runMigrate(context.TODO(), MigrateOptions{ChunkerPolynomial: "random"}, env.gopts, []string{"rechunk"}))
+
+	// the bundles are no longer referenced and were removed
+	newSnapshotID := testListSnapshots(t, env.gopts, 1)[0]
+	testCheckNotBundled(t, env.gopts, newSnapshotID)
+	rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, newSnapshotID)
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "directories are not equal %v", diff)
+}
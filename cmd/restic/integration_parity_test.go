@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunParity(t testing.TB, gopts GlobalOptions) {
+	rtest.OK(t, runParity(context.TODO(), nil, ParityOptions{Overhead: "5%"}, gopts, nil))
+}
+
+func assertParityFiles(t testing.TB, gopts GlobalOptions) {
+	packs := testRunList(t, "packs", gopts)
+	parity := testRunList(t, "parity", gopts)
+	rtest.Assert(t, len(packs) > 0, "no pack files found")
+	sort.Sort(packs)
+	sort.Sort(parity)
+	rtest.Equals(t, packs, parity)
+}
+
+func TestParity(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	dir := filepath.Join(env.testdata, "0", "0", "9")
+	testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+	rtest.Equals(t, 0, len(testRunList(t, "parity", env.gopts)))
+
+	// parity files are created for the existing packs and for new ones
+	testRunParity(t, env.gopts)
+	assertParityFiles(t, env.gopts)
+	rtest.OK(t, os.RemoveAll(filepath.Join(dir, "2")))
+	testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+	assertParityFiles(t, env.gopts)
+
+	// a damaged pack is restored in place
+	packs := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+	id := damageDataPack(t, env)
+	rtest.Assert(t, runCheck(context.TODO(), CheckOptions{ReadData: true}, env.gopts, nil) != nil,
+		"expected check to find the damaged pack")
+	rtest.OK(t, runRepairPacks(context.TODO(), env.gopts, RepairPacksOptions{}, []string{id.String()}))
+	rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+	rtest.Equals(t, packs, restic.NewIDSet(testRunList(t, "packs", env.gopts)...))
+
+	// prune removes the parity files of removed packs
+	testRunForget(t, env.gopts, "--keep-last", "1")
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	assertParityFiles(t, env.gopts)
+
+	// orphaned parity files are removed
+	orphan := restic.NewRandomID().String()
+	rtest.OK(t, os.WriteFile(filepath.Join(env.repo, "parity", orphan), []byte("orphaned"), 0600))
+	testRunParity(t, env.gopts)
+	assertParityFiles(t, env.gopts)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunSnapshotsProtect(t testing.TB, gopts GlobalOptions, protect bool, args ...string) {
+	rtest.OK(t, runSnapshotsProtect(context.TODO(), SnapshotsProtectOptions{}, gopts, args, protect))
+}
+
+func TestSnapshotsProtect(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	testRunSnapshotsProtect(t, env.gopts, true)
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+
+	// the policy must not remove the protected first snapshot
+	rtest.OK(t, runForget(context.TODO(), ForgetOptions{Last: 1}, env.gopts, nil))
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+
+	var protectedID string
+	_, snapshots := testRunSnapshots(t, env.gopts)
+	for id, sn := range snapshots {
+		if sn.Protected {
+			protectedID = id.String()
+		}
+	}
+	rtest.Assert(t, protectedID != "", "no protected snapshot found")
+
+	// neither is it removed when passed explicitly
+	testRunForget(t, env.gopts, protectedID)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+
+	rtest.OK(t, runForget(context.TODO(), ForgetOptions{ForceUnprotect: true}, env.gopts, []string{protectedID}))
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+}
+
+func TestSnapshotsProtectRequiresVersion3(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "2"}, env.gopts, nil))
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	// the config is not rewritten by routine commands
+	err := runSnapshotsProtect(context.TODO(), SnapshotsProtectOptions{}, env.gopts, nil, true)
+	rtest.Assert(t, err != nil, "protecting a snapshot in a version 2 repository did not fail")
+
+	rtest.OK(t, runMigrate(context.TODO(), MigrateOptions{}, env.gopts, []string{"upgrade_repo_v3"}))
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(restic.CapabilitiesRepoVersion), repo.Config().Version)
+	testRunSnapshotsProtect(t, env.gopts, true)
+	testRunCheck(t, env.gopts)
+}
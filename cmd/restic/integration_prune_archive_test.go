@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// archiveBackend simulates a backend which stores data packs in archive
+// storage, from which they cannot be read.
+type archiveBackend struct {
+	restic.Backend
+
+	m        sync.Mutex
+	data     restic.IDSet
+	archived restic.IDSet
+}
+
+func (be *archiveBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type == restic.PackFile && h.ContainedBlobType == restic.DataBlob {
+		id, err := restic.ParseID(h.Name)
+		if err != nil {
+			return err
+		}
+		be.m.Lock()
+		be.data.Insert(id)
+		be.m.Unlock()
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *archiveBackend) isArchived(h restic.Handle) bool {
+	if h.Type != restic.PackFile {
+		return false
+	}
+	id, err := restic.ParseID(h.Name)
+	if err != nil {
+		return false
+	}
+	be.m.Lock()
+	defer be.m.Unlock()
+	return be.archived.Has(id)
+}
+
+func (be *archiveBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if be.isArchived(h) {
+		return errors.Errorf("%v is archived", h)
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func (be *archiveBackend) Stage(ctx context.Context, h restic.Handle) (bool, error) {
+	return !be.isArchived(h), nil
+}
+
+func (be *archiveBackend) Archived(ctx context.Context, h restic.Handle) (bool, error) {
+	return be.isArchived(h), nil
+}
+
+func (be *archiveBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
+
+func TestPruneCheckArchivedPacks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	be := &archiveBackend{data: restic.NewIDSet(), archived: restic.NewIDSet()}
+	env.gopts.backendTestHook = func(r restic.Backend) (restic.Backend, error) {
+		be.Backend = r
+		return be, nil
+	}
+
+	dir := filepath.Join(env.testdata, "0", "0", "9")
+	testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+	for _, sub := range []string{"2", "3", "4"} {
+		rtest.OK(t, os.RemoveAll(filepath.Join(dir, sub)))
+	}
+	testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+	testRunForget(t, env.gopts, testListSnapshots(t, env.gopts, 2)[0].String())
+
+	be.m.Lock()
+	be.archived.Merge(be.data)
+	be.m.Unlock()
+
+	// archived packs cannot be read and are therefore not repacked
+	pruneOpts := PruneOptions{MaxUnused: "0", RepackRecompress: true}
+	pruneGopts := env.gopts
+	pruneGopts.Compression = repository.CompressionMax
+	rtest.OK(t, runPrune(context.TODO(), pruneOpts, pruneGopts))
+	archived := testListPacks(t, env.gopts).Intersect(be.archived)
+	rtest.Assert(t, len(archived) > 0, "all archived packs were removed")
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stderr = buf
+	defer func() {
+		globalOptions.stderr = os.Stderr
+	}()
+	rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true}, env.gopts, nil))
+	msg := fmt.Sprintf("skipping %d packs which are in archive storage", len(archived))
+	rtest.Assert(t, strings.Contains(buf.String(), msg), "missing warning, output: %q", buf.String())
+
+	// once the packs have been retrieved, they are repacked
+	be.m.Lock()
+	be.archived = restic.NewIDSet()
+	be.m.Unlock()
+	rtest.OK(t, runPrune(context.TODO(), pruneOpts, pruneGopts))
+	rtest.Equals(t, 0, len(testListPacks(t, env.gopts).Intersect(archived)))
+	testRunCheck(t, env.gopts)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// retentionState is shared by all instances of retentionBackend of a test.
+type retentionState struct {
+	m        sync.Mutex
+	until    map[restic.Handle]time.Time
+	extended map[restic.Handle]struct{}
+}
+
+// retentionBackend simulates a backend which protects files from removal.
+type retentionBackend struct {
+	restic.Backend
+	state *retentionState
+}
+
+func (be *retentionBackend) RetentionEnabled() bool {
+	return true
+}
+
+func (be *retentionBackend) RetainUntil(ctx context.Context, h restic.Handle) (time.Time, error) {
+	be.state.m.Lock()
+	defer be.state.m.Unlock()
+	return be.state.until[h], nil
+}
+
+func (be *retentionBackend) ExtendRetention(ctx context.Context, h restic.Handle) (bool, error) {
+	be.state.m.Lock()
+	defer be.state.m.Unlock()
+	be.state.extended[h] = struct{}{}
+	return true, nil
+}
+
+func (be *retentionBackend) Remove(ctx context.Context, h restic.Handle) error {
+	be.state.m.Lock()
+	retained := be.state.until[h].After(time.Now())
+	be.state.m.Unlock()
+	if retained {
+		return errors.Errorf("%v is retained", h)
+	}
+	return be.Backend.Remove(ctx, h)
+}
+
+func (be *retentionBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
+
+func testListPacks(t testing.TB, gopts GlobalOptions) restic.IDSet {
+	packs := restic.NewIDSet()
+	for _, id := range testRunList(t, "packs", gopts) {
+		packs.Insert(id)
+	}
+	return packs
+}
+
+func TestPruneExtendsRetention(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	dir := filepath.Join(env.testdata, "0", "0", "9")
+	testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+	// the data of the removed directories is only referenced by the first snapshot
+	for _, sub := range []string{"2", "3", "4"} {
+		rtest.OK(t, os.RemoveAll(filepath.Join(dir, sub)))
+	}
+	testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+	remaining := testListSnapshots(t, env.gopts, 1)[0]
+
+	// all existing packs are still retained
+	state := &retentionState{
+		until:    make(map[restic.Handle]time.Time),
+		extended: make(map[restic.Handle]struct{}),
+	}
+	for id := range testListPacks(t, env.gopts) {
+		state.until[restic.Handle{Type: restic.PackFile, Name: id.String()}] = time.Now().Add(time.Hour)
+	}
+
+	env.gopts.backendTestHook = func(r restic.Backend) (restic.Backend, error) {
+		return &retentionBackend{Backend: r, state: state}, nil
+	}
+	rtest.OK(t, runPrune(context.TODO(), PruneOptions{MaxUnused: "0"}, env.gopts))
+
+	// only the packs which are still used are extended
+	extendedPacks := restic.NewIDSet()
+	extendedSnapshots := restic.NewIDSet()
+	for h := range state.extended {
+		id, err := restic.ParseID(h.Name)
+		rtest.OK(t, err)
+		switch h.Type {
+		case restic.PackFile:
+			extendedPacks.Insert(id)
+		case restic.SnapshotFile:
+			extendedSnapshots.Insert(id)
+		}
+	}
+	rtest.Equals(t, restic.NewIDSet(remaining), extendedSnapshots)
+
+	packs := testListPacks(t, env.gopts)
+	rtest.Assert(t, len(extendedPacks) < len(packs), "unused packs were extended: %v of %v", len(extendedPacks), len(packs))
+
+	// once the retention has expired, the unused packs are removed
+	state.until = make(map[restic.Handle]time.Time)
+	rtest.OK(t, runPrune(context.TODO(), PruneOptions{MaxUnused: "0"}, env.gopts))
+	// packs which are partly used may have been repacked
+	unused := packs.Sub(extendedPacks)
+	rtest.Equals(t, 0, len(testListPacks(t, env.gopts).Intersect(unused)))
+	testRunCheck(t, env.gopts)
+}
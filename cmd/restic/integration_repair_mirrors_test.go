@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRepairMirrors(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	mirror1 := filepath.Join(env.base, "mirror1")
+	mirror2 := filepath.Join(env.base, "mirror2")
+	env.gopts.Repo = "mirror:" + mirror1 + "," + mirror2
+	// the test hook hides the mirror backend
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	// lose the second mirror, except for the config file
+	for _, dir := range []string{"data", "index", "snapshots", "keys"} {
+		rtest.OK(t, os.RemoveAll(filepath.Join(mirror2, dir)))
+	}
+
+	// reading works from the first mirror
+	testListSnapshots(t, env.gopts, 1)
+
+	rtest.OK(t, runRepairMirrors(context.TODO(), env.gopts, RepairMirrorsOptions{}))
+
+	// the second mirror is complete again
+	env.gopts.Repo = mirror2
+	testRunCheck(t, env.gopts)
+	testListSnapshots(t, env.gopts, 1)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// damageDataPack overwrites a part of a data pack file of the repository and
+// returns its ID.
+func damageDataPack(t testing.TB, env *testEnvironment) restic.ID {
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	var id restic.ID
+	found := false
+	repo.Index().Each(context.TODO(), func(pb restic.PackedBlob) {
+		if !found && pb.Type == restic.DataBlob {
+			id = pb.PackID
+			found = true
+		}
+	})
+	rtest.Assert(t, found, "no data pack found")
+
+	name := filepath.Join(env.repo, "data", id.String()[:2], id.String())
+	rtest.OK(t, os.Chmod(name, 0644))
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	rtest.OK(t, err)
+	_, err = f.WriteAt([]byte("damaged data of the pack file"), 10)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+	return id
+}
+
+func TestRepairPacks(t *testing.T) {
+	for _, source := range []string{"repo", "dir"} {
+		t.Run(source, func(t *testing.T) {
+			env, cleanup := withTestEnvironment(t)
+			defer cleanup()
+			env.gopts.backendTestHook = nil
+
+			testSetupBackupData(t, env)
+			dir := filepath.Join(env.testdata, "0", "0", "9")
+			testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+
+			opts := RepairPacksOptions{}
+			if source == "repo" {
+				env2, cleanup2 := withTestEnvironment(t)
+				defer cleanup2()
+				testRunInit(t, env2.gopts)
+				testRunCopy(t, env.gopts, env2.gopts)
+				opts.Repo = env2.gopts.Repo
+				opts.password = env2.gopts.password
+			} else {
+				opts.FromSource = []string{dir}
+			}
+
+			id := damageDataPack(t, env)
+			rtest.Assert(t, runCheck(context.TODO(), CheckOptions{ReadData: true}, env.gopts, nil) != nil,
+				"expected check to find the damaged pack")
+
+			// without a source for intact copies, the pack cannot be repaired
+			err := runRepairPacks(context.TODO(), env.gopts, RepairPacksOptions{}, []string{id.String()})
+			rtest.Assert(t, err != nil, "expected repair without intact copies to fail")
+
+			rtest.OK(t, runRepairPacks(context.TODO(), env.gopts, opts, []string{id.String()}))
+			rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testReplicateOptions(srcGopts GlobalOptions, dstGopts GlobalOptions, poll time.Duration) (ReplicateOptions, GlobalOptions) {
+	gopts := srcGopts
+	gopts.Repo = dstGopts.Repo
+	gopts.password = dstGopts.password
+	// each round lists the snapshots again
+	gopts.backendTestHook = nil
+	opts := ReplicateOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     srcGopts.Repo,
+			password: srcGopts.password,
+		},
+		Poll: poll,
+	}
+	return opts, gopts
+}
+
+func TestReplicate(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	testRunInit(t, env2.gopts)
+
+	opts, gopts := testReplicateOptions(env.gopts, env2.gopts, 0)
+	rtest.OK(t, runReplicate(context.TODO(), opts, gopts, nil))
+	testListSnapshots(t, env2.gopts, 1)
+
+	// new snapshots are copied in the following rounds until the command is interrupted
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, BackupOptions{}, env.gopts)
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error)
+	opts.Poll = 50 * time.Millisecond
+	go func() {
+		done <- runReplicate(ctx, opts, gopts, nil)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(testRunList(t, "snapshots", env2.gopts)) != 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	err := <-done
+	rtest.Assert(t, err == context.Canceled, "unexpected error %v", err)
+
+	testListSnapshots(t, env2.gopts, 2)
+	testRunCheck(t, env2.gopts)
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -71,3 +75,130 @@ func TestRewriteReplace(t *testing.T) {
 	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
 	testRunCheck(t, env.gopts)
 }
+
+func TestRewriteForgetContent(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	testSetupBackupData(t, env)
+
+	// a file which consists of several chunks, and a copy of it
+	data := rtest.Random(23, 5*1024*1024)
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "secret"), data, 0644))
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "0", "secret-copy"), data, 0644))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotID := testListSnapshots(t, env.gopts, 1)[0]
+
+	hash := sha256.Sum256(data)
+	opts := RewriteOptions{
+		Forget:        true,
+		ForgetContent: []string{hex.EncodeToString(hash[:])},
+	}
+	rtest.OK(t, runRewrite(context.TODO(), opts, env.gopts, nil))
+
+	newSnapshotID := testListSnapshots(t, env.gopts, 1)[0]
+	rtest.Assert(t, snapshotID != newSnapshotID, "snapshot id should have changed")
+	files := testRunLs(t, env.gopts, newSnapshotID.String())
+	for _, file := range []string{"/testdata/secret", "/testdata/0/secret-copy"} {
+		rtest.Assert(t, !includes(files, file), "file %v was not removed", file)
+	}
+	rtest.Assert(t, includes(files, "/testdata/0"), "other files were removed")
+
+	// check forbids unused blobs, thus remove them first
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	testRunCheck(t, env.gopts)
+}
+
+// loadTestdataTree returns the tree of the testdata directory in the snapshot.
+func loadTestdataTree(t testing.TB, gopts GlobalOptions, id restic.ID) *restic.Tree {
+	repo, err := OpenRepository(context.TODO(), gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	tree, err = restic.LoadTree(context.TODO(), repo, *tree.Find("testdata").Subtree)
+	rtest.OK(t, err)
+	return tree
+}
+
+func TestRewriteForgetContentBundled(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	testRunInit(t, env.gopts)
+
+	// two small files which are stored in the same bundle
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	secret := rtest.Random(23, 100)
+	other := rtest.Random(42, 200)
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "secret"), secret, 0644))
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "other"), other, 0644))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{BundleSmallerThan: "4K"}, env.gopts)
+	snapshotID := testListSnapshots(t, env.gopts, 1)[0]
+
+	tree := loadTestdataTree(t, env.gopts, snapshotID)
+	rtest.Assert(t, tree.Find("secret").Bundle != nil && tree.Find("other").Bundle != nil, "files were not bundled")
+	bundle := tree.Find("secret").Content[0]
+	rtest.Equals(t, restic.IDs{bundle}, restic.IDs(tree.Find("other").Content))
+
+	hash := sha256.Sum256(secret)
+	opts := RewriteOptions{
+		Forget:        true,
+		ForgetContent: []string{hex.EncodeToString(hash[:])},
+	}
+	rtest.OK(t, runRewrite(context.TODO(), opts, env.gopts, nil))
+
+	// the other file is kept, but no longer references the bundle
+	newSnapshotID := testListSnapshots(t, env.gopts, 1)[0]
+	tree = loadTestdataTree(t, env.gopts, newSnapshotID)
+	rtest.Assert(t, tree.Find("secret") == nil, "file secret was not removed")
+	node := tree.Find("other")
+	rtest.Assert(t, node != nil, "file other was removed")
+	rtest.Assert(t, node.Bundle == nil, "file other still references the bundle")
+	rtest.Equals(t, restic.IDs{restic.Hash(other)}, restic.IDs(node.Content))
+
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	testRunCheck(t, env.gopts)
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	rtest.Assert(t, !repo.Index().Has(restic.BlobHandle{Type: restic.DataBlob, ID: bundle}), "bundle was not pruned")
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, newSnapshotID)
+	buf, err := os.ReadFile(filepath.Join(restoredir, "testdata", "other"))
+	rtest.OK(t, err)
+	rtest.Equals(t, other, buf)
+}
+
+func TestRewriteMetadata(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	createBasicRewriteRepo(t, env)
+	sn, _ := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(sn.Paths))
+
+	opts := RewriteOptions{
+		Forget: true,
+		Metadata: snapshotMetadataArgs{
+			Hostname:     "renamed",
+			ShiftTime:    -time.Hour,
+			ReplacePaths: []string{sn.Paths[0] + "=/data"},
+		},
+	}
+	rtest.OK(t, runRewrite(context.TODO(), opts, env.gopts, nil))
+
+	newest, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapshots))
+	rtest.Assert(t, *newest.ID != *sn.ID, "snapshot id should have changed")
+	rtest.Equals(t, "renamed", newest.Hostname)
+	rtest.Equals(t, []string{"/data"}, newest.Paths)
+	rtest.Assert(t, newest.Time.Equal(sn.Time.Add(-time.Hour)), "unexpected time %v, original %v", newest.Time, sn.Time)
+	rtest.Equals(t, *sn.Tree, *newest.Tree)
+	testRunCheck(t, env.gopts)
+
+	// the metadata is already set
+	rtest.OK(t, runRewrite(context.TODO(), RewriteOptions{Metadata: snapshotMetadataArgs{Hostname: "renamed"}}, env.gopts, nil))
+	testListSnapshots(t, env.gopts, 1)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunScrub(gopts GlobalOptions) error {
+	return runScrub(context.TODO(), ScrubOptions{TargetCoverage: restic.Duration{Days: 1}}, gopts, nil)
+}
+
+func TestScrub(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+
+	rtest.OK(t, runScrub(context.TODO(), ScrubOptions{TargetCoverage: restic.Duration{Days: 1}, DryRun: true}, env.gopts, nil))
+	rtest.Equals(t, 0, len(testRunList(t, "scrub", env.gopts)))
+
+	// the first run reads all data within a window of one day
+	rtest.OK(t, testRunScrub(env.gopts))
+	rtest.OK(t, testRunScrub(env.gopts))
+	rtest.Equals(t, 1, len(testRunList(t, "scrub", env.gopts)))
+}
+
+func TestScrubDamagedPack(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	damageDataPack(t, env)
+
+	rtest.Assert(t, testRunScrub(env.gopts) != nil, "expected scrub to find the damaged pack")
+	// the damaged pack is not recorded as verified and thus read again first
+	rtest.Assert(t, testRunScrub(env.gopts) != nil, "expected scrub to find the damaged pack again")
+	rtest.Equals(t, 1, len(testRunList(t, "scrub", env.gopts)))
+}
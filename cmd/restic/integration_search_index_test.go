@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunFindOutput(t testing.TB, gopts GlobalOptions, opts FindOptions, args ...string) []byte {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = gopts.JSON
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.JSON = false
+	}()
+
+	rtest.OK(t, runFind(context.TODO(), opts, gopts, args))
+	return buf.Bytes()
+}
+
+func testRunIndexFiles(t testing.TB, gopts GlobalOptions, opts IndexFilesOptions) {
+	if opts.ContentMaxSize == "" {
+		opts.ContentMaxSize = "1M"
+	}
+	rtest.OK(t, runIndexFiles(context.TODO(), opts, gopts, nil))
+}
+
+func testSearchIndexFiles(t testing.TB, gopts GlobalOptions) int {
+	repo, err := OpenRepository(context.TODO(), gopts)
+	rtest.OK(t, err)
+	idx, err := loadSearchIndex(context.TODO(), repo)
+	rtest.OK(t, err)
+	return len(idx.files)
+}
+
+func TestSearchIndex(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "0", "9", "37"), 1000))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	patterns := [][]string{
+		{"unexistingfile"},
+		{"37"},
+		{"testfile*"},
+		{"0/9"},
+		{"/testdata/0/0/*"},
+		{"**/9/3*"},
+		{"*"},
+	}
+	env.gopts.JSON = true
+	defer func() {
+		env.gopts.JSON = false
+	}()
+
+	find := func() []string {
+		var results []string
+		for _, args := range patterns {
+			results = append(results, string(testRunFindOutput(t, env.gopts, FindOptions{}, args...)))
+		}
+		return results
+	}
+	expected := find()
+	rtest.Assert(t, strings.Count(expected[1], `"path"`) == 2, "expected two matches, got %v", expected[1])
+
+	testRunIndexFiles(t, env.gopts, IndexFilesOptions{})
+	rtest.Equals(t, 1, testSearchIndexFiles(t, env.gopts))
+	rtest.Equals(t, expected, find())
+
+	// a snapshot missing from the index is searched without it
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "0", "9", "37"), 1000))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	withIndex := find()
+	testRunIndexFiles(t, env.gopts, IndexFilesOptions{Remove: true})
+	rtest.Equals(t, 0, testSearchIndexFiles(t, env.gopts))
+	rtest.Equals(t, find(), withIndex)
+
+	// the index is replaced when it is created again
+	testRunIndexFiles(t, env.gopts, IndexFilesOptions{})
+	testRunIndexFiles(t, env.gopts, IndexFilesOptions{})
+	rtest.Equals(t, 1, testSearchIndexFiles(t, env.gopts))
+	rtest.Equals(t, withIndex, find())
+}
+
+func TestSearchIndexContent(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "0", "needle.txt"), []byte("some text\nwith a Needle in it\n"), 0644))
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "0", "other.txt"), []byte("some other text\n"), 0644))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	find := func(text string, ignoreCase bool, args ...string) []string {
+		out := testRunFindOutput(t, env.gopts, FindOptions{Content: text, CaseInsensitive: ignoreCase}, args...)
+		return strings.Fields(string(out))
+	}
+	check := func() {
+		rtest.Equals(t, []string{"/testdata/0/needle.txt"}, find("a Needle", false))
+		rtest.Equals(t, []string{}, find("a needle", false))
+		rtest.Equals(t, []string{"/testdata/0/needle.txt"}, find("A NEEDLE", true))
+		rtest.Equals(t, []string{"/testdata/0/needle.txt", "/testdata/0/other.txt"}, find("some", false, "*.txt"))
+		rtest.Equals(t, []string{"/testdata/0/other.txt"}, find("some", false, "other*"))
+		rtest.Equals(t, []string{}, find("not contained", false))
+	}
+
+	check()
+	testRunIndexFiles(t, env.gopts, IndexFilesOptions{Content: true})
+	check()
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	idx, err := loadSearchIndex(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(idx.indexes))
+	rtest.Assert(t, idx.indexes[0].Content, "index does not contain the file contents")
+
+	// files in the index which cannot contain the text are not loaded
+	matcher := newSearchIndexMatcher(findPattern{pattern: []string{"*"}, content: "a Needle"})
+	candidates := make(map[string]bool)
+	f := idx.indexes[0]
+	for tree, entry := range f.Trees {
+		for i, name := range entry.Names {
+			candidates[f.Names[name]] = matcher.ContentMayMatch(f, tree, i)
+		}
+	}
+	rtest.Assert(t, candidates["needle.txt"], "needle.txt is not a candidate")
+	rtest.Assert(t, !candidates["other.txt"], "other.txt is a candidate")
+	var ref searchIndexRef
+	for sn := range FindFilteredSnapshots(context.TODO(), repo.Backend(), repo, &restic.SnapshotFilter{}, nil) {
+		var ok bool
+		ref, ok = idx.Lookup(sn)
+		rtest.Assert(t, ok, "snapshot %v is not indexed", sn.ID().Str())
+	}
+	rtest.Assert(t, matcher.TreeMayMatch(f, ref.tree, false), "tree does not match")
+	matcher = newSearchIndexMatcher(findPattern{pattern: []string{"*"}, content: "not contained"})
+	rtest.Assert(t, !matcher.TreeMayMatch(f, ref.tree, false), "tree matches text which is not contained")
+}
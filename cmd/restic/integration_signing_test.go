@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func writeTestSigningKey(t testing.TB, filename string) ed25519.PublicKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	rtest.OK(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	rtest.OK(t, err)
+	rtest.OK(t, os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	return pub
+}
+
+func testLoadSnapshot(t testing.TB, gopts GlobalOptions, id restic.ID) *restic.Snapshot {
+	repo, err := OpenRepository(context.TODO(), gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	return sn
+}
+
+// testCountAuditEntries returns the number of audit entries for operation.
+func testCountAuditEntries(t testing.TB, gopts GlobalOptions, operation string) int {
+	repo, err := OpenRepository(context.TODO(), gopts)
+	rtest.OK(t, err)
+	entries, err := restic.LoadAllAuditEntries(context.TODO(), repo.Backend(), repo)
+	rtest.OK(t, err)
+	n := 0
+	for _, e := range entries {
+		if e.Operation == operation {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTagSignature(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	keyFile := filepath.Join(env.base, "signing.pem")
+	pub := writeTestSigningKey(t, keyFile)
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{SigningKeyFile: keyFile}, env.gopts)
+	sn := testLoadSnapshot(t, env.gopts, testListSnapshots(t, env.gopts, 1)[0])
+	rtest.OK(t, sn.VerifySignature([]ed25519.PublicKey{pub}))
+
+	// without a signing key, the signature no longer matches and is removed
+	testRunTag(t, TagOptions{AddTags: restic.TagLists{[]string{"foo"}}}, env.gopts)
+	sn = testLoadSnapshot(t, env.gopts, testListSnapshots(t, env.gopts, 1)[0])
+	rtest.Assert(t, sn.Signature == nil, "signature of modified snapshot was kept")
+
+	// the modified snapshot is signed again with the key
+	opts := TagOptions{AddTags: restic.TagLists{[]string{"bar"}}}
+	opts.SigningKeyFile = keyFile
+	testRunTag(t, opts, env.gopts)
+	sn = testLoadSnapshot(t, env.gopts, testListSnapshots(t, env.gopts, 1)[0])
+	rtest.OK(t, sn.VerifySignature([]ed25519.PublicKey{pub}))
+	rtest.Equals(t, restic.TagList{"foo", "bar"}, restic.TagList(sn.Tags))
+
+	// both modifications are recorded in the audit log
+	rtest.Equals(t, 2, testCountAuditEntries(t, env.gopts, "tag"))
+}
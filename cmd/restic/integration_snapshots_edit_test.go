@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunSnapshotsEdit(t testing.TB, opts SnapshotsEditOptions, gopts GlobalOptions, snapshotID string) {
+	rtest.OK(t, runSnapshotsEdit(context.TODO(), opts, gopts, []string{snapshotID}))
+}
+
+func TestSnapshotsEdit(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{Description: "before upgrade"}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Equals(t, "before upgrade", newest.Description)
+	originalID := *newest.ID
+
+	testRunSnapshotsEdit(t, SnapshotsEditOptions{Description: "last good state"}, env.gopts, "latest")
+	testRunSnapshotsEdit(t, SnapshotsEditOptions{Description: "known good state"}, env.gopts, "latest")
+	testRunCheck(t, env.gopts)
+
+	newest, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, len(snapshots) == 1, "expected one snapshot, got %v", len(snapshots))
+	rtest.Equals(t, "known good state", newest.Description)
+	rtest.Assert(t, newest.Original != nil && *newest.Original == originalID,
+		"expected original ID %v, got %v", originalID, newest.Original)
+
+	testRunSnapshotsEdit(t, SnapshotsEditOptions{ClearDescription: true}, env.gopts, newest.ID.String())
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, "", newest.Description)
+	rtest.Assert(t, *newest.Original == originalID, "expected original ID to be retained")
+	rtest.Equals(t, 3, testCountAuditEntries(t, env.gopts, "snapshots edit"))
+
+	err := runSnapshotsEdit(context.TODO(), SnapshotsEditOptions{}, env.gopts, []string{"latest"})
+	rtest.Assert(t, err != nil, "expected an error if there is nothing to do")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSplitBackend(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	hot := filepath.Join(env.base, "hot")
+	cold := filepath.Join(env.base, "cold")
+	env.gopts.Repo = "split:hot=" + hot + ",cold=" + cold
+	// the test hook hides the nested backends
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	for _, dir := range []string{"index", "snapshots", "keys"} {
+		_, err := os.Stat(filepath.Join(cold, dir))
+		rtest.Assert(t, os.IsNotExist(err) || isEmptyDir(t, filepath.Join(cold, dir)), "cold backend contains %v files", dir)
+	}
+
+	// browsing the snapshot does not require the cold backend
+	rtest.OK(t, os.RemoveAll(cold))
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+	files := testRunLs(t, env.gopts, snapshotIDs[0].String())
+	rtest.Assert(t, len(files) > 1, "expected files in snapshot, got %v", files)
+}
+
+func isEmptyDir(t testing.TB, dir string) bool {
+	entries, err := os.ReadDir(dir)
+	rtest.OK(t, err)
+	return len(entries) == 0
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunStatsOutput(t testing.TB, gopts GlobalOptions, opts StatsOptions, jsonOutput bool) []byte {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = jsonOutput
+	oldOpts := statsOptions
+	statsOptions = opts
+	defer func() {
+		globalOptions.stdout = gopts.stdout
+		globalOptions.JSON = gopts.JSON
+		statsOptions = oldOpts
+	}()
+
+	rtest.OK(t, runStats(context.TODO(), globalOptions, nil))
+	return buf.Bytes()
+}
+
+func testRunStats(t testing.TB, gopts GlobalOptions, opts StatsOptions) statsContainer {
+	var stats statsContainer
+	rtest.OK(t, json.Unmarshal(testRunStatsOutput(t, gopts, opts, true), &stats))
+	return stats
+}
+
+func TestStatsGroups(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{Host: "laptop"}, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{Host: "server"}, env.gopts)
+
+	for _, mode := range []string{countModeRestoreSize, countModeRawData} {
+		stats := testRunStats(t, env.gopts, StatsOptions{countMode: mode, byHost: true})
+		rtest.Equals(t, 2, len(stats.Groups))
+		rtest.Equals(t, "laptop", stats.Groups[0].Hostname)
+		// both hosts backed up the same data
+		rtest.Equals(t, stats.Groups[0].TotalSize, stats.Groups[1].TotalSize)
+		if mode == countModeRestoreSize {
+			rtest.Equals(t, stats.TotalSize, 2*stats.Groups[0].TotalSize)
+		} else {
+			rtest.Assert(t, stats.Groups[0].TotalSize <= stats.TotalSize, "group %v is larger than total %v", stats.Groups[0].TotalSize, stats.TotalSize)
+		}
+
+		stats = testRunStats(t, env.gopts, StatsOptions{countMode: mode, byPathDepth: 2})
+		var sum uint64
+		paths := make(map[string]struct{})
+		for _, group := range stats.Groups {
+			rtest.Equals(t, "", group.Hostname)
+			rtest.Assert(t, pathDepth(group.Path) <= 2, "path %v is too deep", group.Path)
+			paths[group.Path] = struct{}{}
+			sum += group.TotalSize
+		}
+		rtest.Assert(t, len(paths) == len(stats.Groups), "duplicate groups in %v", stats.Groups)
+		_, ok := paths["/testdata/0"]
+		rtest.Assert(t, ok, "missing group /testdata/0 in %v", stats.Groups)
+		if mode == countModeRestoreSize {
+			rtest.Equals(t, stats.TotalSize, sum)
+		}
+	}
+
+	oldOpts := statsOptions
+	statsOptions = StatsOptions{countMode: countModeBlobsPerFile, byHost: true}
+	defer func() {
+		statsOptions = oldOpts
+	}()
+	rtest.Assert(t, runStats(context.TODO(), env.gopts, nil) != nil, "grouping in %v mode did not fail", countModeBlobsPerFile)
+}
+
+func TestStatsTimeline(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "new"), 1024*1024))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	var entries []statsTimelineEntry
+	out := testRunStatsOutput(t, env.gopts, StatsOptions{countMode: countModeRestoreSize, timeline: true}, true)
+	rtest.OK(t, json.Unmarshal(out, &entries))
+	rtest.Equals(t, 3, len(entries))
+	rtest.Assert(t, entries[0].AddedSize > 0, "first snapshot added no data")
+	// the second snapshot only adds the tree containing the new timestamps
+	rtest.Assert(t, entries[1].AddedSize < entries[0].AddedSize, "second snapshot added %v", entries[1].AddedSize)
+	rtest.Assert(t, entries[2].AddedSize > 1024*1024, "third snapshot added %v", entries[2].AddedSize)
+	rtest.Equals(t, entries[0].AddedSize+entries[1].AddedSize+entries[2].AddedSize, entries[2].CumulativeSize)
+
+	// the cumulative size is the raw data size of all snapshots
+	stats := testRunStats(t, env.gopts, StatsOptions{countMode: countModeRawData})
+	rtest.Equals(t, stats.TotalSize, entries[2].CumulativeSize)
+
+	out = testRunStatsOutput(t, env.gopts, StatsOptions{countMode: countModeRestoreSize, timeline: true}, false)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	rtest.Equals(t, 4, len(lines))
+	rtest.Assert(t, strings.HasPrefix(lines[0], "time,snapshot,"), "unexpected header %q", lines[0])
+	rtest.Assert(t, strings.HasSuffix(lines[3], fmt.Sprint(entries[2].CumulativeSize)), "unexpected line %q", lines[3])
+}
@@ -694,6 +694,141 @@ func TestBackupExclude(t *testing.T) {
 		"expected file %q not in first snapshot, but it's included", "passwords.txt")
 }
 
+func TestBackupPolicyFile(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	for filename, content := range map[string]string{
+		".resticpolicy":         "exclude *.tar.gz\n",
+		"foo.tar.gz":            "foo",
+		"private/.resticpolicy": "exclude /secret\n",
+		"private/secret/pw.txt": "secret",
+		"private/public/secret": "public",
+		"private/notes.txt":     "notes",
+		"public/archive.tar.gz": "archive",
+		"public/other.tar.bz2":  "other",
+	} {
+		fp := filepath.Join(datadir, filepath.FromSlash(filename))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(fp), 0755))
+		rtest.OK(t, os.WriteFile(fp, []byte(content), 0644))
+	}
+
+	opts := BackupOptions{PolicyFile: ".resticpolicy"}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	files := testRunLs(t, env.gopts, snapshotIDs[0].String())
+	for _, file := range []string{"/testdata/.resticpolicy", "/testdata/private/public/secret", "/testdata/private/notes.txt", "/testdata/public/other.tar.bz2"} {
+		rtest.Assert(t, includes(files, file), "expected file %q in snapshot, but it's not included", file)
+	}
+	for _, file := range []string{"/testdata/foo.tar.gz", "/testdata/private/secret", "/testdata/public/archive.tar.gz"} {
+		rtest.Assert(t, !includes(files, file), "expected file %q not in snapshot, but it's included", file)
+	}
+}
+
+func TestBackupGitignore(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	for filename, content := range map[string]string{
+		".gitignore":          "*.log\n!important.log\nbuild/\n",
+		"debug.log":           "debug",
+		"important.log":       "important",
+		"build/out":           "out",
+		"src/.gitignore":      "/generated\n!*.log\n",
+		"src/generated":       "generated",
+		"src/main.log":        "main",
+		"src/sub/generated":   "generated",
+		"src/sub/build":       "not a directory",
+		"docs/notes.txt":      "notes",
+		"docs/draft/todo.txt": "todo",
+	} {
+		fp := filepath.Join(datadir, filepath.FromSlash(filename))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(fp), 0755))
+		rtest.OK(t, os.WriteFile(fp, []byte(content), 0644))
+	}
+
+	excludeFile := filepath.Join(env.base, "gitignore")
+	rtest.OK(t, os.WriteFile(excludeFile, []byte("/docs/draft\n"), 0644))
+
+	opts := BackupOptions{ExcludeGitignore: true, GitignoreFiles: []string{excludeFile}}
+	testRunBackup(t, env.testdata, []string{"."}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	files := testRunLs(t, env.gopts, snapshotIDs[0].String())
+	for _, file := range []string{"/.gitignore", "/important.log", "/src/main.log", "/src/sub/generated", "/src/sub/build", "/docs/notes.txt"} {
+		rtest.Assert(t, includes(files, file), "expected file %q in snapshot, but it's not included", file)
+	}
+	for _, file := range []string{"/debug.log", "/build", "/src/generated", "/docs/draft"} {
+		rtest.Assert(t, !includes(files, file), "expected file %q not in snapshot, but it's included", file)
+	}
+}
+
+func TestBackupParentTagWithin(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{Tags: restic.TagLists{{"baseline"}}}, env.gopts)
+	baseline, _ := testRunSnapshots(t, env.gopts)
+
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest.Parent != nil && *newest.Parent == *baseline.ID,
+		"expected parent %v, got %v", baseline.ID, newest.Parent)
+
+	// the baseline is used instead of the latest snapshot
+	opts.ParentTags = restic.TagLists{{"baseline"}}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest.Parent != nil && *newest.Parent == *baseline.ID,
+		"expected parent %v, got %v", baseline.ID, newest.Parent)
+
+	// no parent is used if all snapshots are too old
+	opts = BackupOptions{ParentWithin: restic.ParseDurationOrPanic("1d"), TimeStamp: "2200-01-01 00:00:00"}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest.Parent == nil, "expected no parent, got %v", newest.Parent)
+
+	opts = BackupOptions{Parent: baseline.ID.String(), ParentTags: restic.TagLists{{"baseline"}}}
+	err := testRunBackupAssumeFailure(t, "", []string{env.testdata}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "expected error for --parent together with --parent-tag")
+}
+
+func TestBackupInvocationMetadata(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	t.Setenv("RESTIC_TEST_JOB_ID", "42")
+
+	opts := BackupOptions{RecordEnv: []string{"RESTIC_TEST_JOB_ID"}}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	sn, _ := testRunSnapshots(t, env.gopts)
+
+	rtest.Assert(t, sn.Invocation != nil, "snapshot contains no invocation")
+	rtest.Equals(t, version, sn.Invocation.ProgramVersion)
+	rtest.Equals(t, map[string]string{"RESTIC_TEST_JOB_ID": "42"}, sn.Invocation.Environment)
+
+	rtest.Assert(t, sn.Summary != nil, "snapshot contains no summary")
+	rtest.Assert(t, sn.Summary.FilesNew > 0 && sn.Summary.FilesNew == sn.Summary.TotalFilesProcessed,
+		"unexpected file counts in summary %+v", sn.Summary)
+	rtest.Assert(t, sn.Summary.DataAdded > 0 && sn.Summary.TotalBytesProcessed > 0,
+		"unexpected data sizes in summary %+v", sn.Summary)
+	rtest.Assert(t, !sn.Summary.BackupEnd.Before(sn.Summary.BackupStart),
+		"backup ended before it started: %+v", sn.Summary)
+}
+
 func TestBackupErrors(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		return
@@ -941,6 +1076,45 @@ func TestCopyIncremental(t *testing.T) {
 		len(copiedSnapshotIDs), len(snapshotIDs))
 }
 
+func TestCopyRechunk(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testSetupBackupData(t, env)
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "large"), rtest.Random(42, 5*1024*1024), 0644))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+
+	// the destination uses a different chunker polynomial
+	testRunInit(t, env2.gopts)
+	gopts := env.gopts
+	gopts.Repo = env2.gopts.Repo
+	gopts.password = env2.gopts.password
+	copyOpts := CopyOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env.gopts.Repo,
+			password: env.gopts.password,
+		},
+		Rechunk: true,
+	}
+	rtest.OK(t, runCopy(context.TODO(), copyOpts, gopts, nil))
+	copiedSnapshotIDs := testListSnapshots(t, env2.gopts, 1)
+	testRunCheck(t, env2.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	restoredir2 := filepath.Join(env2.base, "restore")
+	testRunRestore(t, env2.gopts, restoredir2, copiedSnapshotIDs[0])
+	diff := directoriesContentsDiff(restoredir, restoredir2)
+	rtest.Assert(t, diff == "", "rechunked snapshot differs:\n%v", diff)
+
+	// the rechunked snapshot is not copied again
+	rtest.OK(t, runCopy(context.TODO(), copyOpts, gopts, nil))
+	testListSnapshots(t, env2.gopts, 1)
+}
+
 func TestCopyUnstableJSON(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1075,6 +1249,59 @@ func TestTag(t *testing.T) {
 		"expected original ID to be set to the first snapshot id")
 }
 
+func TestSnapshotAnnotations(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{Annotations: []string{"ticket=OPS-1", "lsn=42"}}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	var annotated restic.ID
+	for id, sn := range snapmap {
+		if len(sn.Annotations) != 0 {
+			annotated = id
+		}
+	}
+	rtest.Assert(t, !annotated.IsNull(), "expected an annotated snapshot")
+	rtest.Equals(t, map[string]string{"ticket": "OPS-1", "lsn": "42"}, snapmap[annotated].Annotations)
+
+	for _, test := range []struct {
+		filter []string
+		count  int
+	}{
+		{nil, 2},
+		{[]string{"ticket"}, 1},
+		{[]string{"ticket=OPS-1", "lsn=42"}, 1},
+		{[]string{"ticket=OPS-2"}, 0},
+	} {
+		buf := bytes.NewBuffer(nil)
+		gopts := env.gopts
+		gopts.stdout = buf
+		gopts.JSON = true
+		rtest.OK(t, runSnapshots(context.TODO(), SnapshotOptions{FilterAnnotations: test.filter}, gopts, nil))
+
+		var snapshots []Snapshot
+		rtest.OK(t, json.Unmarshal(buf.Bytes(), &snapshots))
+		rtest.Assert(t, len(snapshots) == test.count,
+			"filter %v: expected %d snapshots, got %d", test.filter, test.count, len(snapshots))
+	}
+
+	testRunTag(t, TagOptions{
+		SetAnnotations:    []string{"ticket=OPS-2"},
+		RemoveAnnotations: []string{"lsn"},
+	}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	_, snapmap = testRunSnapshots(t, env.gopts)
+	for _, sn := range snapmap {
+		rtest.Equals(t, map[string]string{"ticket": "OPS-2"}, sn.Annotations)
+	}
+}
+
 func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
 	buf := bytes.NewBuffer(nil)
 
@@ -1212,6 +1439,62 @@ func TestKeyProblems(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func testRunKeyListJSON(t testing.TB, gopts GlobalOptions) []keyInfo {
+	buf := bytes.NewBuffer(nil)
+
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	gopts.JSON = true
+	rtest.OK(t, runKey(context.TODO(), gopts, []string{"list"}))
+
+	var keys []keyInfo
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &keys))
+	return keys
+}
+
+func TestKeyListJSONVerify(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// must list keys more than once
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	testRunKeyAddNewKey(t, "geheim2", env.gopts)
+
+	keys := testRunKeyListJSON(t, env.gopts)
+	rtest.Equals(t, 2, len(keys))
+
+	var current, other keyInfo
+	modified := 0
+	for _, k := range keys {
+		if k.LastModified != nil {
+			modified++
+		}
+		rtest.Equals(t, 64, len(k.Fingerprint))
+		rtest.Assert(t, strings.HasPrefix(k.Fingerprint, k.ID), "fingerprint %v does not match ID %v", k.Fingerprint, k.ID)
+		rtest.Equals(t, "scrypt", k.KDF.Name)
+		rtest.Assert(t, k.KDF.N > 0 && k.KDF.R > 0 && k.KDF.P > 0, "invalid KDF parameters %v", k.KDF)
+		if k.Current {
+			current = k
+		} else {
+			other = k
+		}
+	}
+
+	// adding the key was recorded in the audit log
+	rtest.Equals(t, 1, modified)
+
+	rtest.OK(t, runKey(context.TODO(), env.gopts, []string{"verify", current.ID}))
+	err := runKey(context.TODO(), env.gopts, []string{"verify", other.ID})
+	rtest.Assert(t, err != nil, "verifying a key with the wrong password succeeded")
+
+	env.gopts.password = "geheim2"
+	rtest.OK(t, runKey(context.TODO(), env.gopts, []string{"verify", other.ID}))
+}
+
 func testFileSize(filename string, size int64) error {
 	fi, err := os.Stat(filename)
 	if err != nil {
@@ -1225,6 +1508,39 @@ func testFileSize(filename string, size int64) error {
 	return nil
 }
 
+func TestRestoreIgnoreCase(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for _, name := range []string{"Docs/Report.DOCX", "Docs/notes.txt", "Program Files/app.EXE"} {
+		p := filepath.Join(env.testdata, filepath.FromSlash(name))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, 100))
+	}
+
+	testRunBackup(t, env.testdata, []string{"."}, BackupOptions{}, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	gopts := env.gopts
+	gopts.IgnoreCase = true
+
+	target := filepath.Join(env.base, "restore-include")
+	opts := RestoreOptions{Target: target, Include: []string{"/docs/*.docx"}}
+	rtest.OK(t, runRestore(context.TODO(), opts, gopts, nil, []string{snapshotID.String()}))
+	rtest.OK(t, testFileSize(filepath.Join(target, "Docs", "Report.DOCX"), 100))
+	_, err := os.Stat(filepath.Join(target, "Docs", "notes.txt"))
+	rtest.Assert(t, os.IsNotExist(err), "expected notes.txt to not be restored, err %v", err)
+
+	target = filepath.Join(env.base, "restore-exclude")
+	opts = RestoreOptions{Target: target, Exclude: []string{"*.exe"}}
+	rtest.OK(t, runRestore(context.TODO(), opts, gopts, nil, []string{snapshotID.String()}))
+	rtest.OK(t, testFileSize(filepath.Join(target, "Docs", "notes.txt"), 100))
+	_, err = os.Stat(filepath.Join(target, "Program Files", "app.EXE"))
+	rtest.Assert(t, os.IsNotExist(err), "expected app.EXE to not be restored, err %v", err)
+}
+
 func TestRestoreFilter(t *testing.T) {
 	testfiles := []struct {
 		name string
@@ -1300,6 +1616,60 @@ func TestRestore(t *testing.T) {
 	rtest.Assert(t, diff == "", "directories are not equal %v", diff)
 }
 
+func testRunVerify(gopts GlobalOptions, dir string) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+
+	err := runVerify(context.TODO(), VerifyOptions{}, gopts, []string{"latest", dir})
+	return buf.String(), err
+}
+
+func TestVerify(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, BackupOptions{}, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, nil)
+	out, err := testRunVerify(env.gopts, restoredir)
+	rtest.OK(t, err)
+	rtest.Equals(t, "", out)
+
+	// modify the contents of a file without changing its size or timestamps
+	var filename string
+	rtest.OK(t, filepath.Walk(restoredir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && filename == "" && fi.Mode().IsRegular() && fi.Size() > 0 {
+			filename = path
+		}
+		return err
+	}))
+	fi, err := os.Stat(filename)
+	rtest.OK(t, err)
+	data, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	data[0] ^= 1
+	rtest.OK(t, os.WriteFile(filename, data, 0644))
+	rtest.OK(t, os.Chtimes(filename, fi.ModTime(), fi.ModTime()))
+	rtest.OK(t, os.WriteFile(filepath.Join(restoredir, "extra"), []byte("extra"), 0644))
+
+	out, err = testRunVerify(env.gopts, restoredir)
+	rtest.Assert(t, err != nil, "verify did not report the differences")
+
+	var paths []string
+	dec := json.NewDecoder(strings.NewReader(out))
+	for dec.More() {
+		var diff VerifyDifference
+		rtest.OK(t, dec.Decode(&diff))
+		paths = append(paths, diff.Path)
+	}
+	location, err := filepath.Rel(restoredir, filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{string(filepath.Separator) + location, string(filepath.Separator) + "extra"}, paths)
+}
+
 func TestRestoreLatest(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1397,6 +1767,61 @@ func TestRestoreWithPermissionFailure(t *testing.T) {
 	}
 }
 
+func TestLsDiskUsage(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	data := rtest.Random(23, 512*1024)
+	for _, name := range []string{"a/file1", "a/file2", "b/file3"} {
+		p := filepath.Join(env.testdata, filepath.FromSlash(name))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, os.WriteFile(p, data, 0644))
+	}
+
+	testRunBackup(t, env.testdata, []string{"a", "b"}, BackupOptions{}, env.gopts)
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.JSON = false
+	}()
+	rtest.OK(t, runLs(context.TODO(), LsOptions{DiskUsage: true}, globalOptions, []string{"latest"}))
+
+	type duEntry struct {
+		Path       string `json:"path"`
+		Size       uint64 `json:"size"`
+		UniqueSize uint64 `json:"unique_size"`
+		Files      uint64 `json:"files"`
+		StructType string `json:"struct_type"`
+	}
+
+	entries := make(map[string]duEntry)
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e duEntry
+		rtest.OK(t, dec.Decode(&e))
+		if e.StructType == "du" {
+			entries[e.Path] = e
+		}
+	}
+
+	size := uint64(len(data))
+	root, ok := entries["/"]
+	rtest.Assert(t, ok, "total for snapshot missing in %v", entries)
+	rtest.Equals(t, 3*size, root.Size)
+	rtest.Equals(t, uint64(3), root.Files)
+	rtest.Equals(t, size, root.UniqueSize)
+
+	rtest.Equals(t, 2*size, entries["/a"].Size)
+	rtest.Equals(t, size, entries["/a"].UniqueSize)
+	rtest.Equals(t, uint64(1), entries["/b"].Files)
+	rtest.Equals(t, size, entries["/a/file1"].UniqueSize)
+}
+
 func setZeroModTime(filename string) error {
 	var utimes = []syscall.Timespec{
 		syscall.NsecToTimespec(0),
@@ -1556,6 +1981,127 @@ func TestRebuildIndexAlwaysFull(t *testing.T) {
 	testRebuildIndex(t, nil)
 }
 
+func TestCompactIndex(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	testfile := filepath.Join(env.testdata, "file")
+
+	backup := func(opts BackupOptions) {
+		rtest.OK(t, appendRandomData(testfile, 1024))
+		testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	}
+	for i := 0; i < 3; i++ {
+		backup(BackupOptions{})
+	}
+	rtest.Equals(t, 3, len(testRunList(t, "index", env.gopts)))
+
+	// the small index files of the previous backups are merged
+	backup(BackupOptions{CompactIndex: 3})
+	rtest.Equals(t, 2, len(testRunList(t, "index", env.gopts)))
+	testRunCheck(t, env.gopts)
+
+	backup(BackupOptions{CompactIndex: 3})
+	rtest.Equals(t, 3, len(testRunList(t, "index", env.gopts)))
+
+	globalOptions.stdout = io.Discard
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+	rtest.OK(t, runRebuildIndex(context.TODO(), RepairIndexOptions{Compact: true}, env.gopts))
+	rtest.Equals(t, 1, len(testRunList(t, "index", env.gopts)))
+	testRunCheck(t, env.gopts)
+
+	err := runRebuildIndex(context.TODO(), RepairIndexOptions{Compact: true, ReadAllPacks: true}, env.gopts)
+	rtest.Assert(t, err != nil, "expected an error for --compact and --read-all-packs")
+}
+
+func TestBackupFileHashCache(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	testfile := filepath.Join(env.testdata, "file")
+	rtest.OK(t, appendRandomData(testfile, 5*1024*1024))
+
+	opts := BackupOptions{FileHashCache: true}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+
+	// only the modification time changes
+	rtest.OK(t, os.Chtimes(testfile, time.Now(), time.Now().Add(time.Hour)))
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	testListSnapshots(t, env.gopts, 2)
+	testRunCheck(t, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, nil)
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, env.testdata))
+	rtest.Assert(t, diff == "", "directories are not equal: %v", diff)
+}
+
+func TestBackupFileHashCacheDataKeys(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	// must list keys more than once
+	env.gopts.backendTestHook = nil
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	testfile := filepath.Join(env.testdata, "file")
+	rtest.OK(t, appendRandomData(testfile, 5*1024*1024))
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{FileHashCache: true, DataKeys: true}, env.gopts)
+	rtest.OK(t, os.Chtimes(testfile, time.Now(), time.Now().Add(time.Hour)))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{FileHashCache: true}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+	testRunCheck(t, env.gopts)
+
+	// the second snapshot must not depend on the data key without referencing it
+	var first restic.ID
+	for _, id := range snapshotIDs {
+		if first.IsNull() || testLoadSnapshot(t, env.gopts, id).Time.Before(testLoadSnapshot(t, env.gopts, first).Time) {
+			first = id
+		}
+	}
+	rtest.OK(t, testRunErase(env.gopts, EraseOptions{Paths: []string{"/testdata/file"}}, []string{first.String()}))
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, nil)
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "directories are not equal: %v", diff)
+}
+
+func TestCatalog(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.OK(t, runCatalog(context.TODO(), CatalogOptions{}, env.gopts, nil))
+	rtest.Equals(t, 1, len(testRunList(t, "catalog", env.gopts)))
+
+	// backup and forget replace the catalog
+	oldCatalog := testRunList(t, "catalog", env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+	newCatalog := testRunList(t, "catalog", env.gopts)
+	rtest.Equals(t, 1, len(newCatalog))
+	rtest.Assert(t, !oldCatalog[0].Equal(newCatalog[0]), "catalog was not updated")
+
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapmap))
+	testRunCheck(t, env.gopts)
+
+	rtest.OK(t, runCatalog(context.TODO(), CatalogOptions{Remove: true}, env.gopts, nil))
+	rtest.Equals(t, 0, len(testRunList(t, "catalog", env.gopts)))
+}
+
 // indexErrorBackend modifies the first index after reading.
 type indexErrorBackend struct {
 	restic.Backend
@@ -1692,6 +2238,13 @@ func testPruneVariants(t *testing.T, unsafeNoSpaceRecovery bool) {
 		checkOpts := CheckOptions{ReadData: true, CheckUnused: true}
 		testPrune(t, opts, checkOpts)
 	})
+	for _, strategy := range []string{repackStrategyAge, repackStrategyBalanced} {
+		t.Run("Strategy-"+strategy+suffix, func(t *testing.T) {
+			opts := PruneOptions{MaxUnused: "0%", RepackStrategy: strategy, unsafeRecovery: unsafeNoSpaceRecovery}
+			checkOpts := CheckOptions{ReadData: true, CheckUnused: true}
+			testPrune(t, opts, checkOpts)
+		})
+	}
 }
 
 func createPrunableRepo(t *testing.T, env *testEnvironment) {
@@ -1723,6 +2276,68 @@ func testPrune(t *testing.T, pruneOpts PruneOptions, checkOpts CheckOptions) {
 	rtest.OK(t, runCheck(context.TODO(), checkOpts, env.gopts, nil))
 }
 
+func TestPruneDiskIndex(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	env.gopts.Index = repository.IndexDisk
+	createPrunableRepo(t, env)
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+}
+
+func TestPruneRepackRecompress(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	// must list packs more than once
+	env.gopts.backendTestHook = nil
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	oldPacks := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+
+	err := runPrune(context.TODO(), PruneOptions{MaxUnused: "0%", RepackRecompress: true}, env.gopts)
+	rtest.Assert(t, err != nil, "recompressing without --compression max should fail")
+
+	env.gopts.Compression = repository.CompressionMax
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%", RepackRecompress: true})
+	for _, id := range testRunList(t, "packs", env.gopts) {
+		rtest.Assert(t, !oldPacks.Has(id), "pack %v was not repacked", id.Str())
+	}
+	rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+}
+
+func TestPruneMaxDuration(t *testing.T) {
+	t.Run("exhausted", func(t *testing.T) {
+		env, cleanup := withTestEnvironment(t)
+		defer cleanup()
+
+		createPrunableRepo(t, env)
+		// the deadline has passed before repacking starts, so the packs
+		// planned for repacking must be kept
+		testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%", MaxDuration: time.Nanosecond})
+		rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true}, env.gopts, nil))
+
+		// a later prune without time limit finishes the job
+		testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+		rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+	})
+
+	t.Run("batched", func(t *testing.T) {
+		env, cleanup := withTestEnvironment(t)
+		defer cleanup()
+
+		defer func(size int) {
+			pruneRepackBatchSize = size
+		}(pruneRepackBatchSize)
+		pruneRepackBatchSize = 1
+
+		createPrunableRepo(t, env)
+		testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%", MaxDuration: time.Hour})
+		rtest.OK(t, runCheck(context.TODO(), CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+	})
+}
+
 var pruneDefaultOptions = PruneOptions{MaxUnused: "5%"}
 
 func listPacks(gopts GlobalOptions, t *testing.T) restic.IDSet {
@@ -1738,6 +2353,35 @@ func listPacks(gopts GlobalOptions, t *testing.T) restic.IDSet {
 	return packs
 }
 
+func TestPruneDryRunNoLock(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	createPrunableRepo(t, env)
+
+	// simulate a concurrent backup holding a non-exclusive lock
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	lock, err := restic.NewLock(context.TODO(), repo)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, lock.Unlock())
+	}()
+
+	packsBefore := listPacks(env.gopts, t)
+	opts := PruneOptions{MaxUnused: "0%", DryRun: true}
+	testRunPrune(t, env.gopts, opts)
+
+	env.gopts.NoLock = true
+	testRunPrune(t, env.gopts, opts)
+	rtest.Equals(t, packsBefore, listPacks(env.gopts, t))
+
+	opts.DryRun = false
+	err = runPrune(context.TODO(), opts, env.gopts)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "--no-lock"),
+		"expected --no-lock error for prune without --dry-run, got %v", err)
+}
+
 func TestPruneWithDamagedRepository(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -52,6 +52,10 @@ func lockRepository(ctx context.Context, repo restic.Repository, exclusive bool,
 
 	lockFn := restic.NewLock
 	if exclusive {
+		// commands using an exclusive lock remove or modify data
+		if err := repo.Config().CheckWriteCapabilities(); err != nil {
+			return nil, ctx, err
+		}
 		lockFn = restic.NewExclusiveLock
 	}
 
@@ -101,6 +105,36 @@ retryLoop:
 	}
 	debug.Log("create lock %p (exclusive %v)", lock, exclusive)
 
+	ctx = monitorLock(ctx, lock)
+	return lock, ctx, err
+}
+
+// tryLockRepoExclusive works like lockRepoExclusive, but returns a nil lock
+// instead of waiting or failing if the repository is already locked.
+func tryLockRepoExclusive(ctx context.Context, repo restic.Repository) (*restic.Lock, context.Context, error) {
+	globalLocks.Do(func() {
+		AddCleanupHandler(unlockAll)
+	})
+
+	if err := repo.Config().CheckWriteCapabilities(); err != nil {
+		return nil, ctx, err
+	}
+	lock, err := restic.NewExclusiveLock(ctx, repo)
+	if restic.IsAlreadyLocked(err) {
+		debug.Log("repo already locked, not waiting: %v", err)
+		return nil, ctx, nil
+	}
+	if err != nil {
+		return nil, ctx, errors.Fatalf("unable to create lock in backend: %v", err)
+	}
+	debug.Log("create lock %p (exclusive true)", lock)
+
+	return lock, monitorLock(ctx, lock), nil
+}
+
+// monitorLock starts refreshing the lock and returns a context which is
+// cancelled once the lock is released or could not be refreshed in time.
+func monitorLock(ctx context.Context, lock *restic.Lock) context.Context {
 	ctx, cancel := context.WithCancel(ctx)
 	lockInfo := &lockContext{
 		cancel: cancel,
@@ -114,7 +148,7 @@ retryLoop:
 	go monitorLockRefresh(ctx, lock, lockInfo, refreshChan)
 	globalLocks.Unlock()
 
-	return lock, ctx, err
+	return ctx
 }
 
 var refreshInterval = 5 * time.Minute
@@ -8,6 +8,7 @@ import (
 	"os"
 	"runtime"
 
+	"github.com/restic/restic/internal/backend/metrics"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/options"
 	"github.com/restic/restic/internal/restic"
@@ -30,6 +31,12 @@ directories in an encrypted repository stored on different backends.
 	DisableAutoGenTag: true,
 
 	PersistentPreRunE: func(c *cobra.Command, args []string) error {
+		if globalOptions.Profile != "" {
+			if err := applyProfile(c, globalOptions.ConfigFile, globalOptions.Profile); err != nil {
+				return err
+			}
+		}
+
 		// set verbosity, default is one
 		globalOptions.verbosity = 1
 		if globalOptions.Quiet && globalOptions.Verbose > 0 {
@@ -51,15 +58,22 @@ directories in an encrypted repository stored on different backends.
 			return err
 		}
 		globalOptions.extended = opts
+		globalOptions.Limits = globalOptions.OperationLimits.limitsFor(c.Name(), globalOptions.Limits)
+		if globalOptions.BackendStats {
+			globalOptions.backendMetrics = metrics.NewCollector()
+		}
 		if !needsPassword(c.Name()) {
 			return nil
 		}
-		pwd, err := resolvePassword(globalOptions, "RESTIC_PASSWORD")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Resolving password failed: %v\n", err)
-			Exit(1)
+		// the repository is opened using the identity instead of a password
+		if globalOptions.IdentityFile == "" {
+			pwd, err := resolvePassword(globalOptions, "RESTIC_PASSWORD")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Resolving password failed: %v\n", err)
+				Exit(1)
+			}
+			globalOptions.password = pwd
 		}
-		globalOptions.password = pwd
 
 		// run the debug functions for all subcommands (if build tag "debug" is
 		// enabled)
@@ -95,6 +109,10 @@ func main() {
 		version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 	err := cmdRoot.ExecuteContext(internalGlobalCtx)
 
+	if globalOptions.backendMetrics != nil {
+		printBackendStats(globalOptions.backendMetrics, globalOptions.JSON)
+	}
+
 	switch {
 	case restic.IsAlreadyLocked(err):
 		fmt.Fprintf(os.Stderr, "%v\nthe `unlock` command can be used to remove stale locks\n", err)
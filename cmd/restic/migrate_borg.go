@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// borgTimeFormat is the format of the timestamps printed by borg, which are
+// in local time.
+const borgTimeFormat = "2006-01-02T15:04:05.999999"
+
+// borgArchive describes an archive in a borg repository.
+type borgArchive struct {
+	Name     string    `json:"name"`
+	Hostname string    `json:"hostname"`
+	Start    time.Time `json:"-"`
+
+	StartString string `json:"start"`
+}
+
+// borgSource reads archives from a borg repository using the borg command.
+type borgSource struct {
+	command string
+	repo    string
+}
+
+func (b borgSource) run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, b.command, args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Fatalf("running %v %v failed: %v", b.command, args[0], err)
+	}
+	return out, nil
+}
+
+// parseBorgArchives parses the JSON output of "borg list --json" and
+// "borg info --json".
+func parseBorgArchives(buf []byte) ([]borgArchive, error) {
+	var list struct {
+		Archives []borgArchive `json:"archives"`
+	}
+	if err := json.Unmarshal(buf, &list); err != nil {
+		return nil, errors.Fatalf("unable to parse output of borg: %v", err)
+	}
+	for i := range list.Archives {
+		t, err := time.ParseInLocation(borgTimeFormat, list.Archives[i].StartString, time.Local)
+		if err != nil {
+			return nil, errors.Fatalf("invalid start time of borg archive %v: %v", list.Archives[i].Name, err)
+		}
+		list.Archives[i].Start = t
+	}
+	return list.Archives, nil
+}
+
+// Archives returns all archives of the repository, sorted from the oldest to
+// the newest archive.
+func (b borgSource) Archives(ctx context.Context) ([]borgArchive, error) {
+	out, err := b.run(ctx, "list", "--json", b.repo)
+	if err != nil {
+		return nil, err
+	}
+	return parseBorgArchives(out)
+}
+
+// Info returns the details of the archive name, which are not contained in
+// the list of archives.
+func (b borgSource) Info(ctx context.Context, name string) (borgArchive, error) {
+	out, err := b.run(ctx, "info", "--json", b.repo+"::"+name)
+	if err != nil {
+		return borgArchive{}, err
+	}
+	archives, err := parseBorgArchives(out)
+	if err != nil {
+		return borgArchive{}, err
+	}
+	if len(archives) != 1 {
+		return borgArchive{}, errors.Fatalf("borg returned %d archives for %v", len(archives), name)
+	}
+	return archives[0], nil
+}
+
+// ExportTar returns the contents of the archive name as a tar stream. The
+// error of the borg command is returned when the end of the stream is reached.
+func (b borgSource) ExportTar(ctx context.Context, name string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, b.command, "export-tar", b.repo+"::"+name, "-")
+	cmd.Stderr = os.Stderr
+	rd, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "StdoutPipe")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Fatalf("unable to start %v: %v", b.command, err)
+	}
+	return &commandReader{rd: rd, cmd: cmd}, nil
+}
+
+// commandReader reads the output of a command and reports whether the command
+// succeeded on Close.
+type commandReader struct {
+	rd  io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	return r.rd.Read(p)
+}
+
+// Close reads the remaining output and waits for the command to exit.
+func (r *commandReader) Close() error {
+	_, _ = io.Copy(io.Discard, r.rd)
+	if err := r.cmd.Wait(); err != nil {
+		return errors.Fatalf("%v failed: %v", r.cmd.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/restic/chunker"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/migrations"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// rechunkMigration splits the files of all snapshots again using a new
+// chunker polynomial. The polynomial is stored in the config first, such that
+// applying the migration again after an interruption continues with the
+// remaining snapshots. Files which were already split using the new polynomial
+// are read again, but their blobs are not saved a second time.
+type rechunkMigration struct {
+	gopts GlobalOptions
+	// polynomial is the hexadecimal polynomial or "random"
+	polynomial string
+	// signing selects the key to sign the rechunked snapshots with
+	signing signingKeyOptions
+
+	pol *chunker.Pol
+}
+
+func (m *rechunkMigration) Name() string {
+	return "rechunk"
+}
+
+func (m *rechunkMigration) Desc() string {
+	return "split all files again using a new chunker polynomial"
+}
+
+func (m *rechunkMigration) RepoCheck() bool {
+	return false
+}
+
+// parsePolynomial returns the polynomial or generates a random one. The
+// result is cached, such that Check and Apply use the same polynomial.
+func (m *rechunkMigration) parsePolynomial() (chunker.Pol, error) {
+	if m.pol != nil {
+		return *m.pol, nil
+	}
+
+	var pol chunker.Pol
+	if m.polynomial == "random" {
+		var err error
+		pol, err = chunker.RandomPolynomial()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		v, err := strconv.ParseUint(strings.TrimPrefix(m.polynomial, "0x"), 16, 64)
+		if err != nil {
+			return 0, errors.Fatalf("invalid chunker polynomial %q: %v", m.polynomial, err)
+		}
+		pol = chunker.Pol(v)
+		if !pol.Irreducible() {
+			return 0, errors.Fatalf("invalid chunker polynomial %q, it is not irreducible", m.polynomial)
+		}
+	}
+	m.pol = &pol
+	return pol, nil
+}
+
+// usesDataKeys returns true if the repository contains data key files. The
+// capability alone does not suffice, it is set for all repositories of
+// version 3.
+func usesDataKeys(ctx context.Context, repo restic.Repository) (bool, error) {
+	if !repo.Config().HasCapability(restic.CapabilityDataKeys) {
+		return false, nil
+	}
+	found := false
+	err := repo.List(ctx, restic.DataKeyFile, func(restic.ID, int64) error {
+		found = true
+		return nil
+	})
+	return found, err
+}
+
+func (m *rechunkMigration) Check(ctx context.Context, repo restic.Repository) (bool, string, error) {
+	if m.polynomial == "" {
+		return false, "no chunker polynomial specified, use --chunker-polynomial", nil
+	}
+	dataKeys, err := usesDataKeys(ctx, repo)
+	if err != nil {
+		return false, "", err
+	}
+	if dataKeys {
+		return false, "files encrypted with data keys cannot be split again", nil
+	}
+	if repo.Backend().Connections() < 2 {
+		return false, "prune requires a backend connection limit of at least two", nil
+	}
+
+	pol, err := m.parsePolynomial()
+	if err != nil {
+		return false, "", err
+	}
+	if repo.Config().ChunkerPolynomial == pol {
+		return false, "the repository already uses this chunker polynomial, use --force to continue an interrupted migration", nil
+	}
+	return true, "", nil
+}
+
+// Estimate returns the size of all pack files containing data, which are read
+// and written again.
+func (m *rechunkMigration) Estimate(ctx context.Context, repo restic.Repository) (migrations.Estimate, error) {
+	var est migrations.Estimate
+	err := repo.List(ctx, restic.PackFile, func(_ restic.ID, size int64) error {
+		est.Bytes += uint64(size)
+		est.Files++
+		return nil
+	})
+	return est, err
+}
+
+func (m *rechunkMigration) Apply(ctx context.Context, repo restic.Repository) error {
+	r, ok := repo.(*repository.Repository)
+	if !ok {
+		return errors.Errorf("migration %v is not supported for this repository", m.Name())
+	}
+	signingKey, err := m.signing.load()
+	if err != nil {
+		return err
+	}
+	dataKeys, err := usesDataKeys(ctx, r)
+	if err != nil {
+		return err
+	}
+	if dataKeys {
+		return errors.New("files encrypted with data keys cannot be split again")
+	}
+
+	if m.polynomial != "" {
+		pol, err := m.parsePolynomial()
+		if err != nil {
+			return err
+		}
+		if r.Config().ChunkerPolynomial != pol {
+			if err := r.SetChunkerPolynomial(ctx, pol); err != nil {
+				return err
+			}
+			Verbosef("set chunker polynomial to %v\n", pol)
+		}
+	}
+
+	// a previous migration may have modified the index
+	if err := r.SetIndex(index.NewMasterIndex()); err != nil {
+		return err
+	}
+	if err := r.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	var snapshots []*restic.Snapshot
+	err = restic.ForAllSnapshots(ctx, r.Backend(), r, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if sn.Tree == nil {
+			return errors.Errorf("snapshot %v has nil tree", id.Str())
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rechunker := newRechunker(r, r)
+	changed := 0
+	for _, sn := range snapshots {
+		Verbosef("rechunking snapshot %v of %v at %v\n", sn.ID().Str(), sn.Paths, sn.Time)
+		newTree, err := rechunker.CopyTree(ctx, *sn.Tree)
+		if err != nil {
+			return errors.Errorf("rechunking snapshot %v failed: %v", sn.ID().Str(), err)
+		}
+		if newTree == *sn.Tree {
+			continue
+		}
+
+		sn.Tree = &newTree
+		id, err := replaceSnapshot(ctx, r, sn, signingKey)
+		if err != nil {
+			return err
+		}
+		Verboseff("saved new snapshot %v\n", id.Str())
+		changed++
+	}
+	Verbosef("rechunked %d snapshots\n", changed)
+
+	// remove the data split using the old polynomial
+	opts := PruneOptions{MaxUnused: "5%"}
+	if err := verifyPruneOptions(&opts); err != nil {
+		return err
+	}
+	if err := r.SetIndex(index.NewMasterIndex()); err != nil {
+		return err
+	}
+	return runPruneWithRepo(ctx, opts, m.gopts, r, restic.NewIDSet())
+}
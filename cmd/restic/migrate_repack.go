@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/migrations"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// repackMigration is a migration which rewrites pack files using prune. As
+// prune can be interrupted at any time, the migration can simply be applied
+// again to continue.
+type repackMigration struct {
+	name string
+	desc string
+	deps []string
+
+	gopts GlobalOptions
+	opts  PruneOptions
+	// selected reports whether a pack file is rewritten by the migration.
+	selected func(repo restic.Repository, p repackPackInfo) bool
+	// minPacks is the minimum number of selected pack files for the
+	// migration to be applicable.
+	minPacks int
+	// needsCompression is set if the migration compresses data.
+	needsCompression bool
+
+	// checked contains the pack files selected by the last call to Check.
+	checked []repackPackInfo
+}
+
+type repackPackInfo struct {
+	size         int64
+	uncompressed bool
+	mixed        bool
+}
+
+// repackMigrations returns the migrations which are implemented using prune.
+func repackMigrations(gopts GlobalOptions) []migrations.Migration {
+	return []migrations.Migration{
+		&repackMigration{
+			name:  "compress_repo",
+			desc:  "compress all data which is not compressed yet",
+			deps:  []string{"upgrade_repo_v2"},
+			gopts: gopts,
+			opts:  PruneOptions{MaxUnused: "unlimited", RepackUncompressed: true},
+			selected: func(repo restic.Repository, p repackPackInfo) bool {
+				return p.uncompressed || repo.Config().Version < 2
+			},
+			minPacks:         1,
+			needsCompression: true,
+		},
+		&repackMigration{
+			name:  "consolidate_packs",
+			desc:  "combine small pack files into pack files of the target size",
+			deps:  []string{"compress_repo"},
+			gopts: gopts,
+			opts:  PruneOptions{MaxUnused: "unlimited", RepackSmall: true},
+			selected: func(repo restic.Repository, p repackPackInfo) bool {
+				// same threshold as used by prune --repack-small
+				return !p.mixed && p.size < int64(repo.PackSize()/5*4)
+			},
+			// prune ignores fewer small pack files
+			minPacks: 10,
+		},
+	}
+}
+
+// allMigrations returns all migrations known to restic.
+func allMigrations(opts MigrateOptions, gopts GlobalOptions) []migrations.Migration {
+	var ms []migrations.Migration
+	ms = append(ms, migrations.All...)
+	ms = append(ms, repackMigrations(gopts)...)
+	return append(ms, &rechunkMigration{gopts: gopts, polynomial: opts.ChunkerPolynomial, signing: opts.signingKeyOptions})
+}
+
+func (m *repackMigration) Name() string {
+	return m.name
+}
+
+func (m *repackMigration) Desc() string {
+	return m.desc
+}
+
+func (m *repackMigration) RepoCheck() bool {
+	return false
+}
+
+func (m *repackMigration) Dependencies() []string {
+	return m.deps
+}
+
+// packs returns the pack files which the migration would rewrite. The index
+// is loaded from the repository each time, as a previous migration may have
+// modified it.
+func (m *repackMigration) packs(ctx context.Context, repo restic.Repository) ([]repackPackInfo, error) {
+	mi := index.NewMasterIndex()
+	err := index.ForAllIndexes(ctx, repo, func(id restic.ID, idx *index.Index, oldFormat bool, err error) error {
+		if err != nil {
+			return err
+		}
+		mi.Insert(idx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := mi.MergeFinalIndexes(); err != nil {
+		return nil, err
+	}
+
+	type packState struct {
+		tpe          restic.BlobType
+		uncompressed bool
+		mixed        bool
+	}
+	state := make(map[restic.ID]*packState)
+	mi.Each(ctx, func(pb restic.PackedBlob) {
+		s, ok := state[pb.PackID]
+		if !ok {
+			s = &packState{tpe: pb.Type}
+			state[pb.PackID] = s
+		}
+		if s.tpe != pb.Type {
+			s.mixed = true
+		}
+		if !pb.IsCompressed() {
+			s.uncompressed = true
+		}
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var packs []repackPackInfo
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		s, ok := state[id]
+		if !ok {
+			// unreferenced pack files are removed by prune anyway
+			return nil
+		}
+		p := repackPackInfo{size: size, uncompressed: s.uncompressed, mixed: s.mixed}
+		if m.selected(repo, p) {
+			packs = append(packs, p)
+		}
+		return nil
+	})
+	return packs, err
+}
+
+func (m *repackMigration) Check(ctx context.Context, repo restic.Repository) (bool, string, error) {
+	if m.needsCompression && m.gopts.Compression == repository.CompressionOff {
+		return false, "compression is disabled", nil
+	}
+	if repo.Backend().Connections() < 2 {
+		return false, "prune requires a backend connection limit of at least two", nil
+	}
+
+	packs, err := m.packs(ctx, repo)
+	if err != nil {
+		return false, "", err
+	}
+	m.checked = packs
+	if len(packs) == 0 {
+		return false, "no pack files need to be rewritten", nil
+	}
+	if len(packs) < m.minPacks {
+		return false, "too few pack files need to be rewritten", nil
+	}
+	return true, "", nil
+}
+
+// Estimate returns the size of all pack files which are rewritten, as
+// determined by the preceding call to Check.
+func (m *repackMigration) Estimate(ctx context.Context, repo restic.Repository) (migrations.Estimate, error) {
+	var est migrations.Estimate
+	for _, p := range m.checked {
+		est.Bytes += uint64(p.size)
+		est.Files++
+	}
+	return est, nil
+}
+
+func (m *repackMigration) Apply(ctx context.Context, repo restic.Repository) error {
+	if m.needsCompression && repo.Config().Version < 2 {
+		return errors.New("compression requires at least repository format version 2, apply upgrade_repo_v2 first")
+	}
+
+	r, ok := repo.(*repository.Repository)
+	if !ok {
+		return errors.Errorf("migration %v is not supported for this repository", m.name)
+	}
+
+	opts := m.opts
+	if err := verifyPruneOptions(&opts); err != nil {
+		return err
+	}
+
+	// prune loads the index itself, drop entries loaded by a previous migration
+	if err := r.SetIndex(index.NewMasterIndex()); err != nil {
+		return err
+	}
+	return runPruneWithRepo(ctx, opts, m.gopts, r, restic.NewIDSet())
+}
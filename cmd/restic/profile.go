@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// profileFile is the content of the config file.
+type profileFile struct {
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// profileGroup is a group of paths within a profile, which is backed up as a
+// separate snapshot at its own interval.
+type profileGroup struct {
+	Name  string   `yaml:"-"`
+	Paths []string `yaml:"paths"`
+	Every string   `yaml:"every"`
+
+	interval restic.Duration
+}
+
+// defaultConfigFile returns the location of the config file used if neither
+// --config-file nor $RESTIC_CONFIG_FILE is set.
+func defaultConfigFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "restic", "restic.yaml"), nil
+}
+
+func loadProfile(filename, name string) (map[string]interface{}, error) {
+	if filename == "" {
+		var err error
+		filename, err = defaultConfigFile()
+		if err != nil {
+			return nil, errors.Fatalf("unable to find the config file: %v", err)
+		}
+	}
+
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read the config file: %v", err)
+	}
+	var f profileFile
+	if err := yaml.Unmarshal(buf, &f); err != nil {
+		return nil, errors.Fatalf("unable to parse the config file %v: %v", filename, err)
+	}
+
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return nil, errors.Fatalf("profile %q not found in %v", name, filename)
+	}
+	return profile, nil
+}
+
+// loadProfileGroups returns the path groups of the profile, sorted by name.
+func loadProfileGroups(filename, name string) ([]profileGroup, error) {
+	profile, err := loadProfile(filename, name)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := profile["path-groups"]
+	if !ok {
+		return nil, errors.Fatalf("profile %q does not define any path groups", name)
+	}
+	buf, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	var m map[string]profileGroup
+	if err := dec.Decode(&m); err != nil {
+		return nil, errors.Fatalf("profile %q: invalid path groups: %v", name, err)
+	}
+
+	groups := make([]profileGroup, 0, len(m))
+	for groupName, g := range m {
+		g.Name = groupName
+		if len(g.Paths) == 0 {
+			return nil, errors.Fatalf("profile %q, path group %q: no paths specified", name, groupName)
+		}
+		if g.Every != "" {
+			g.interval, err = restic.ParseDuration(g.Every)
+			if err != nil {
+				return nil, errors.Fatalf("profile %q, path group %q: invalid interval: %v", name, groupName, err)
+			}
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// applyProfile sets all flags of c from the profile name, which were not
+// specified on the command line. The keys of a profile are the names of
+// global flags, or the name of a command such as "backup" or "key add" with
+// the flags of that command.
+func applyProfile(c *cobra.Command, filename, name string) error {
+	profile, err := loadProfile(filename, name)
+	if err != nil {
+		return err
+	}
+
+	cmdName := strings.TrimPrefix(c.CommandPath(), c.Root().Name()+" ")
+	global := make(map[string]interface{})
+	for _, key := range sortedKeys(profile) {
+		value := profile[key]
+		section, isSection := value.(map[string]interface{})
+		switch {
+		case key == "path-groups":
+			// path groups for the backup command, see loadProfileGroups
+		case isSection && key == cmdName:
+			if err := setFlags(c.Flags(), section); err != nil {
+				return errors.Fatalf("profile %q, section %q: %v", name, key, err)
+			}
+		case isSection:
+			// settings for another command
+		case c.Root().PersistentFlags().Lookup(key) == nil:
+			return errors.Fatalf("profile %q: unknown global option %q", name, key)
+		default:
+			global[key] = value
+		}
+	}
+
+	if err := setFlags(c.Flags(), global); err != nil {
+		return errors.Fatalf("profile %q: %v", name, err)
+	}
+	return nil
+}
+
+// setFlags sets the flags to the values, unless they were specified on the
+// command line. Values which are lists are set once for each element.
+func setFlags(flags *pflag.FlagSet, values map[string]interface{}) error {
+	for _, key := range sortedKeys(values) {
+		flag := flags.Lookup(key)
+		if flag == nil {
+			return errors.Errorf("unknown option %q", key)
+		}
+		if flag.Changed {
+			continue
+		}
+
+		items, ok := values[key].([]interface{})
+		if !ok {
+			items = []interface{}{values[key]}
+		}
+		for _, item := range items {
+			if _, ok := item.(map[string]interface{}); ok || item == nil {
+				return errors.Errorf("invalid value for option %q", key)
+			}
+			if err := flags.Set(key, fmt.Sprint(item)); err != nil {
+				return errors.Errorf("invalid value for option %q: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/spf13/cobra"
+)
+
+const testProfileFile = `
+profiles:
+  homedir:
+    repo: /srv/restic-repo
+    option:
+      - sftp.connections=10
+      - s3.region=eu
+    backup:
+      exclude: ["*.tmp", "*.o"]
+      one-file-system: true
+    forget:
+      keep-daily: 7
+    key add:
+      host: example
+  broken:
+    repo: /srv/restic-repo
+    no-such-option: true
+`
+
+func testProfileCommands() (root, sub, nested *cobra.Command) {
+	root = &cobra.Command{Use: "restic"}
+	root.PersistentFlags().String("repo", "", "")
+	root.PersistentFlags().StringSlice("option", nil, "")
+
+	sub = &cobra.Command{Use: "backup", Run: func(*cobra.Command, []string) {}}
+	sub.Flags().StringArray("exclude", nil, "")
+	sub.Flags().Bool("one-file-system", false, "")
+
+	parent := &cobra.Command{Use: "key"}
+	nested = &cobra.Command{Use: "add", Run: func(*cobra.Command, []string) {}}
+	nested.Flags().String("host", "", "")
+	parent.AddCommand(nested)
+	root.AddCommand(sub, parent)
+	return root, sub, nested
+}
+
+func TestApplyProfile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "restic.yaml")
+	rtest.OK(t, os.WriteFile(filename, []byte(testProfileFile), 0600))
+
+	root, sub, nested := testProfileCommands()
+	root.SetArgs([]string{"backup", "--exclude", "*.bak"})
+	rtest.OK(t, root.Execute())
+	rtest.OK(t, applyProfile(sub, filename, "homedir"))
+
+	repo, _ := sub.Flags().GetString("repo")
+	rtest.Equals(t, "/srv/restic-repo", repo)
+	options, _ := sub.Flags().GetStringSlice("option")
+	rtest.Equals(t, []string{"sftp.connections=10", "s3.region=eu"}, options)
+	// flags from the command line take precedence
+	excludes, _ := sub.Flags().GetStringArray("exclude")
+	rtest.Equals(t, []string{"*.bak"}, excludes)
+	oneFS, _ := sub.Flags().GetBool("one-file-system")
+	rtest.Assert(t, oneFS, "one-file-system not set")
+
+	root, _, nested = testProfileCommands()
+	root.SetArgs([]string{"key", "add"})
+	rtest.OK(t, root.Execute())
+	rtest.OK(t, applyProfile(nested, filename, "homedir"))
+	host, _ := nested.Flags().GetString("host")
+	rtest.Equals(t, "example", host)
+
+	for profile, msg := range map[string]string{
+		"broken":  "no-such-option",
+		"missing": "not found",
+	} {
+		root, sub, _ = testProfileCommands()
+		root.SetArgs([]string{"backup"})
+		rtest.OK(t, root.Execute())
+		err := applyProfile(sub, filename, profile)
+		rtest.Assert(t, err != nil && strings.Contains(err.Error(), msg), "unexpected error for profile %v: %v", profile, err)
+	}
+}
+
+const testProfileGroupsFile = `
+profiles:
+  server:
+    repo: /srv/restic-repo
+    path-groups:
+      home:
+        paths: [/home]
+        every: 1d
+      etc:
+        paths: [/etc, /usr/local/etc]
+        every: 1h
+      media:
+        paths: [/var/media]
+  nogroups:
+    repo: /srv/restic-repo
+  nopaths:
+    path-groups:
+      etc:
+        every: 1h
+  invalid:
+    path-groups:
+      etc:
+        paths: [/etc]
+        every: 1x
+  unknown:
+    path-groups:
+      etc:
+        paths: [/etc]
+        exclude: ["*.bak"]
+`
+
+func TestLoadProfileGroups(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "restic.yaml")
+	rtest.OK(t, os.WriteFile(filename, []byte(testProfileGroupsFile), 0600))
+
+	groups, err := loadProfileGroups(filename, "server")
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, len(groups))
+	rtest.Equals(t, "etc", groups[0].Name)
+	rtest.Equals(t, []string{"/etc", "/usr/local/etc"}, groups[0].Paths)
+	rtest.Equals(t, restic.Duration{Hours: 1}, groups[0].interval)
+	rtest.Equals(t, "home", groups[1].Name)
+	rtest.Equals(t, restic.Duration{Days: 1}, groups[1].interval)
+	rtest.Equals(t, "media", groups[2].Name)
+	rtest.Assert(t, groups[2].interval.Zero(), "unexpected interval %v", groups[2].interval)
+
+	for _, profile := range []string{"nogroups", "nopaths", "invalid", "unknown"} {
+		_, err := loadProfileGroups(filename, profile)
+		rtest.Assert(t, err != nil, "no error for profile %v", profile)
+	}
+
+	// path groups are ignored when applying the profile
+	root, sub, _ := testProfileCommands()
+	root.SetArgs([]string{"backup"})
+	rtest.OK(t, root.Execute())
+	rtest.OK(t, applyProfile(sub, filename, "server"))
+}
+
+func TestProfileGroupDue(t *testing.T) {
+	latest := time.Date(2023, 5, 2, 20, 0, 0, 0, time.UTC)
+	hourly := profileGroup{interval: restic.Duration{Hours: 1}}
+	daily := profileGroup{interval: restic.Duration{Days: 1}}
+
+	for _, test := range []struct {
+		g   profileGroup
+		now time.Time
+		due bool
+	}{
+		{hourly, latest.Add(30 * time.Minute), false},
+		{hourly, latest.Add(58 * time.Minute), true},
+		{hourly, latest.Add(2 * time.Hour), true},
+		{daily, latest.Add(12 * time.Hour), false},
+		{daily, latest.Add(23 * time.Hour), true},
+		{profileGroup{}, latest, true},
+	} {
+		rtest.Equals(t, test.due, test.g.due(latest, test.now))
+	}
+}
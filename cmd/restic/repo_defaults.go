@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/repository"
+	"github.com/spf13/pflag"
+)
+
+// forgetPolicyFlags returns the flags of the retention policy bound to opts.
+func forgetPolicyFlags(opts *ForgetOptions) *pflag.FlagSet {
+	f := pflag.NewFlagSet("defaults", pflag.ContinueOnError)
+	initForgetPolicyFlags(f, opts)
+	return f
+}
+
+// isRepoDefault returns whether the default of the option name can be stored
+// in the repository config.
+func isRepoDefault(name string) bool {
+	switch name {
+	case "compression", "pack-size", "exclude":
+		return true
+	default:
+		return forgetPolicyFlags(&ForgetOptions{}).Lookup(name) != nil
+	}
+}
+
+// checkRepoDefault returns an error if values are invalid for the option name.
+func checkRepoDefault(name string, values []string) error {
+	if !isRepoDefault(name) {
+		return errors.Fatalf("unknown option %q", name)
+	}
+
+	if len(values) != 1 && name != "exclude" && name != "keep-tag" {
+		return errors.Fatalf("option %v takes exactly one value", name)
+	}
+
+	switch name {
+	case "compression":
+		var mode repository.CompressionMode
+		return mode.Set(values[0])
+	case "pack-size":
+		_, err := parseRepoPackSize(values[0])
+		return err
+	case "exclude":
+		return filter.ValidatePatterns(values)
+	default:
+		_, err := forgetPolicyDefaults(map[string][]string{name: values})
+		return err
+	}
+}
+
+func parseRepoPackSize(value string) (uint, error) {
+	size, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, errors.Fatalf("invalid pack size %q: %v", value, err)
+	}
+	packSize := uint(size) * 1024 * 1024
+	if packSize < repository.MinPackSize || packSize > repository.MaxPackSize {
+		return 0, errors.Fatalf("pack size must be between %d and %d MiB", repository.MinPackSize/1024/1024, repository.MaxPackSize/1024/1024)
+	}
+	return packSize, nil
+}
+
+// forgetPolicyDefaults returns the forget options with the retention policy
+// stored in defaults.
+func forgetPolicyDefaults(defaults map[string][]string) (ForgetOptions, error) {
+	var opts ForgetOptions
+	f := forgetPolicyFlags(&opts)
+	for _, name := range sortedRepoDefaults(defaults) {
+		if f.Lookup(name) == nil {
+			continue
+		}
+		for _, value := range defaults[name] {
+			if err := f.Set(name, value); err != nil {
+				return ForgetOptions{}, errors.Fatalf("invalid value %q for %v stored in the repository: %v", value, name, err)
+			}
+		}
+	}
+	if err := verifyForgetOptions(&opts); err != nil {
+		return ForgetOptions{}, err
+	}
+	return opts, nil
+}
+
+// applyRepositoryDefaults sets the compression mode and pack size of repo from
+// the defaults stored in the repository config, unless they were set using
+// options or environment variables.
+func applyRepositoryDefaults(repo *repository.Repository, opts *GlobalOptions) error {
+	defaults := repo.Config().Defaults
+
+	if values := defaults["compression"]; len(values) == 1 && opts.Compression == repository.CompressionAuto &&
+		!cmdRoot.PersistentFlags().Changed("compression") && os.Getenv("RESTIC_COMPRESSION") == "" {
+		var mode repository.CompressionMode
+		if err := mode.Set(values[0]); err != nil {
+			return errors.Fatalf("invalid compression mode stored in the repository: %v", err)
+		}
+		if err := repo.SetCompression(mode); err != nil {
+			return err
+		}
+		opts.Compression = mode
+	}
+
+	if values := defaults["pack-size"]; len(values) == 1 && opts.PackSize == 0 {
+		size, err := parseRepoPackSize(values[0])
+		if err != nil {
+			return errors.Fatalf("invalid pack size stored in the repository: %v", err)
+		}
+		if err := repo.SetPackSize(size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedRepoDefaults(defaults map[string][]string) []string {
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
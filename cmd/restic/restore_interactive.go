@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+	"golang.org/x/term"
+)
+
+const restoreBrowserKeys = "up/down: move  enter: open  backspace: back  space: mark  a: mark all  r: restore  q: quit"
+
+// keys as returned by readRestoreBrowserKey, other keys are returned as the
+// character they produce
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyHome      = "home"
+	keyEnd       = "end"
+	keyPageUp    = "pgup"
+	keyPageDown  = "pgdown"
+	keyEnter     = "enter"
+	keyBackspace = "backspace"
+	keyInterrupt = "interrupt"
+	keyUnknown   = "unknown"
+)
+
+// restoreBrowser is a full-screen terminal user interface which lets the user
+// browse a snapshot and select the files and directories to restore. All
+// paths are absolute paths within the snapshot, separated by slashes.
+type restoreBrowser struct {
+	repo  restic.BlobLoader
+	root  restic.ID
+	out   io.Writer
+	trees map[string]*restic.Tree
+	// size returns the width and height of the terminal
+	size func() (int, int)
+
+	cwd    string
+	cursor int
+	offset int
+	status string
+
+	marked map[string]struct{}
+}
+
+func newRestoreBrowser(repo restic.BlobLoader, root restic.ID, out io.Writer) *restoreBrowser {
+	return &restoreBrowser{
+		repo:   repo,
+		root:   root,
+		out:    out,
+		trees:  make(map[string]*restic.Tree),
+		size:   func() (int, int) { return 80, 24 },
+		cwd:    "/",
+		marked: make(map[string]struct{}),
+	}
+}
+
+// runTerminal switches the terminal to raw mode and runs the browser on the
+// alternate screen, on which it is restored afterwards.
+func (b *restoreBrowser) runTerminal(ctx context.Context) (bool, error) {
+	outFd, inFd := int(os.Stdout.Fd()), int(os.Stdin.Fd())
+	if _, _, err := term.GetSize(outFd); err != nil {
+		return false, errors.Fatalf("--interactive requires a terminal: %v", err)
+	}
+	b.size = func() (int, int) {
+		width, height, err := term.GetSize(outFd)
+		if err != nil {
+			return 80, 24
+		}
+		return width, height
+	}
+
+	resetOutput, err := enableTerminalSequences(os.Stdout)
+	if err != nil {
+		return false, err
+	}
+	defer resetOutput()
+
+	state, err := term.MakeRaw(inFd)
+	if err != nil {
+		return false, errors.Wrap(err, "MakeRaw")
+	}
+	defer func() {
+		_ = term.Restore(inFd, state)
+	}()
+
+	// switch to the alternate screen and hide the cursor
+	b.printf("\x1b[?1049h\x1b[?25l")
+	defer b.printf("\x1b[?25h\x1b[?1049l")
+
+	return b.run(ctx, os.Stdin)
+}
+
+// run reads key presses from in until the selection is restored or the user
+// quits. It returns whether the selection should be restored.
+func (b *restoreBrowser) run(ctx context.Context, in io.Reader) (bool, error) {
+	rd := bufio.NewReader(in)
+	if _, err := b.loadDir(ctx, b.cwd); err != nil {
+		return false, err
+	}
+
+	for {
+		b.draw()
+		key, err := readRestoreBrowserKey(rd)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		b.status = ""
+		restore, done, err := b.handle(ctx, key)
+		if err != nil {
+			b.status = err.Error()
+		}
+		if done {
+			return restore, nil
+		}
+	}
+}
+
+// readRestoreBrowserKey returns the next key pressed. Escape sequences are
+// decoded to the key names defined above.
+func readRestoreBrowserKey(rd *bufio.Reader) (string, error) {
+	r, _, err := rd.ReadRune()
+	if err != nil {
+		return "", err
+	}
+
+	switch r {
+	case '\r', '\n':
+		return keyEnter, nil
+	case 0x7f, 0x08:
+		return keyBackspace, nil
+	case 0x03, 0x04:
+		return keyInterrupt, nil
+	case 0x1b:
+	default:
+		return string(r), nil
+	}
+
+	if rd.Buffered() == 0 {
+		// the escape key itself
+		return keyUnknown, nil
+	}
+	intro, err := rd.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if intro != '[' && intro != 'O' {
+		return keyUnknown, nil
+	}
+
+	// parameters are followed by the final byte of the sequence
+	var params []byte
+	for {
+		c, err := rd.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c >= 0x40 && c <= 0x7e {
+			return escapeSequenceKey(string(params), c), nil
+		}
+		params = append(params, c)
+	}
+}
+
+func escapeSequenceKey(params string, final byte) string {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "5":
+			return keyPageUp
+		case "6":
+			return keyPageDown
+		}
+	}
+	return keyUnknown
+}
+
+// handle processes the key. done is set once the user has finished the
+// selection.
+func (b *restoreBrowser) handle(ctx context.Context, key string) (restore bool, done bool, err error) {
+	nodes := b.trees[b.cwd].Nodes
+	switch key {
+	case keyUp, "k":
+		b.cursor--
+	case keyDown, "j":
+		b.cursor++
+	case keyPageUp:
+		b.cursor -= b.rows()
+	case keyPageDown:
+		b.cursor += b.rows()
+	case keyHome:
+		b.cursor = 0
+	case keyEnd:
+		b.cursor = len(nodes) - 1
+	case keyEnter, keyRight, "l":
+		if len(nodes) > 0 {
+			err = b.open(ctx, nodes[b.cursor])
+		}
+	case keyBackspace, keyLeft, "h":
+		b.up()
+	case " ":
+		if len(nodes) > 0 {
+			err = b.toggle(path.Join(b.cwd, nodes[b.cursor].Name))
+			b.cursor++
+		}
+	case "a":
+		err = b.toggleAll(nodes)
+	case "r":
+		if len(b.marked) == 0 {
+			return false, false, errors.New("nothing marked for restore")
+		}
+		return true, true, nil
+	case "q", keyInterrupt:
+		return false, true, nil
+	}
+
+	b.clampCursor(len(nodes))
+	return false, false, err
+}
+
+func (b *restoreBrowser) clampCursor(entries int) {
+	if b.cursor >= entries {
+		b.cursor = entries - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+}
+
+// open changes to the directory node within the current directory.
+func (b *restoreBrowser) open(ctx context.Context, node *restic.Node) error {
+	if node.Type != "dir" {
+		return errors.Errorf("%v is not a directory", node.Name)
+	}
+	dir := path.Join(b.cwd, node.Name)
+	if _, err := b.loadDir(ctx, dir); err != nil {
+		return err
+	}
+	b.cwd, b.cursor, b.offset = dir, 0, 0
+	return nil
+}
+
+// up changes to the parent directory and places the cursor on the directory
+// which was left.
+func (b *restoreBrowser) up() {
+	if b.cwd == "/" {
+		return
+	}
+	name := path.Base(b.cwd)
+	b.cwd, b.cursor, b.offset = path.Dir(b.cwd), 0, 0
+	for i, node := range b.trees[b.cwd].Nodes {
+		if node.Name == name {
+			b.cursor = i
+		}
+	}
+}
+
+// toggle adds p to the selection, or removes it if it is marked already.
+func (b *restoreBrowser) toggle(p string) error {
+	if _, ok := b.marked[p]; ok {
+		b.unmarkBelow(p)
+		return nil
+	}
+	if parent := b.markedParent(p); parent != "" {
+		return errors.Errorf("%v is marked as part of %v, unmark the directory instead", p, parent)
+	}
+	b.unmarkBelow(p)
+	b.marked[p] = struct{}{}
+	return nil
+}
+
+// toggleAll marks all nodes, or removes them from the selection if all of
+// them are marked already.
+func (b *restoreBrowser) toggleAll(nodes []*restic.Node) error {
+	if parent := b.markedParent(b.cwd); parent != "" {
+		return errors.Errorf("%v is marked as part of %v, unmark the directory instead", b.cwd, parent)
+	}
+
+	all := true
+	for _, node := range nodes {
+		if _, ok := b.marked[path.Join(b.cwd, node.Name)]; !ok {
+			all = false
+		}
+	}
+	for _, node := range nodes {
+		p := path.Join(b.cwd, node.Name)
+		b.unmarkBelow(p)
+		if !all {
+			b.marked[p] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// rows returns the number of lines available to list directory entries.
+func (b *restoreBrowser) rows() int {
+	_, height := b.size()
+	if height < 4 {
+		return 1
+	}
+	// header, status and key help
+	return height - 3
+}
+
+// draw redraws the screen. It consists of a header with the current directory,
+// the directory entries, a status line and the key help.
+func (b *restoreBrowser) draw() {
+	width, _ := b.size()
+	rows := b.rows()
+	nodes := b.trees[b.cwd].Nodes
+
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+rows {
+		b.offset = b.cursor - rows + 1
+	}
+
+	buf := &bytes.Buffer{}
+	line := func(s string, highlight bool) {
+		s = truncateTerminalLine(s, width)
+		if highlight {
+			s = "\x1b[7m" + s + "\x1b[0m"
+		}
+		// clear the rest of each line instead of the screen to avoid flicker
+		buf.WriteString(s + "\x1b[K\r\n")
+	}
+
+	buf.WriteString("\x1b[H")
+	line(fmt.Sprintf("%s  (%d items marked)", b.cwd, len(b.marked)), true)
+	for i := b.offset; i < b.offset+rows; i++ {
+		switch {
+		case i < len(nodes):
+			line(b.entry(nodes[i]), i == b.cursor)
+		case i == 0:
+			line("  (empty directory)", false)
+		default:
+			line("", false)
+		}
+	}
+	line(b.status, false)
+	buf.WriteString(truncateTerminalLine(restoreBrowserKeys, width) + "\x1b[K")
+
+	_, _ = b.out.Write(buf.Bytes())
+}
+
+// entry formats the node within the current directory for the listing.
+func (b *restoreBrowser) entry(node *restic.Node) string {
+	p := path.Join(b.cwd, node.Name)
+	state := " "
+	if b.isMarked(p) {
+		state = "*"
+	} else if b.hasMarkedChild(p) {
+		state = "+"
+	}
+	name := node.Name
+	size := ""
+	switch node.Type {
+	case "dir":
+		name += "/"
+	case "symlink":
+		name += " -> " + node.LinkTarget
+	case "file":
+		size = ui.FormatBytes(node.Size)
+	}
+	return fmt.Sprintf("[%s] %10s  %s", state, size, name)
+}
+
+// truncateTerminalLine shortens s to at most width characters.
+func truncateTerminalLine(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+func (b *restoreBrowser) printf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(b.out, format, args...)
+}
+
+// loadDir returns the tree of the directory dir.
+func (b *restoreBrowser) loadDir(ctx context.Context, dir string) (*restic.Tree, error) {
+	if tree, ok := b.trees[dir]; ok {
+		return tree, nil
+	}
+
+	var id restic.ID
+	if dir == "/" {
+		id = b.root
+	} else {
+		parent, err := b.loadDir(ctx, path.Dir(dir))
+		if err != nil {
+			return nil, err
+		}
+		node := parent.Find(path.Base(dir))
+		if node == nil {
+			return nil, errors.Errorf("%v: no such file or directory", dir)
+		}
+		if node.Type != "dir" || node.Subtree == nil {
+			return nil, errors.Errorf("%v: not a directory", dir)
+		}
+		id = *node.Subtree
+	}
+
+	tree, err := restic.LoadTree(ctx, b.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	b.trees[dir] = tree
+	return tree, nil
+}
+
+// unmarkBelow removes p and all marked items below p from the selection.
+func (b *restoreBrowser) unmarkBelow(p string) {
+	for m := range b.marked {
+		if isBelowPath(m, p) {
+			delete(b.marked, m)
+		}
+	}
+}
+
+// markedParent returns the marked directory containing p, or p itself if it
+// is marked.
+func (b *restoreBrowser) markedParent(p string) string {
+	for m := range b.marked {
+		if isBelowPath(p, m) {
+			return m
+		}
+	}
+	return ""
+}
+
+func (b *restoreBrowser) isMarked(p string) bool {
+	return b.markedParent(p) != ""
+}
+
+func (b *restoreBrowser) hasMarkedChild(p string) bool {
+	for m := range b.marked {
+		if m != p && isBelowPath(m, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *restoreBrowser) markedPaths() []string {
+	paths := make([]string, 0, len(b.marked))
+	for p := range b.marked {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// selectFilter selects the marked items and everything below them for
+// restore.
+func (b *restoreBrowser) selectFilter(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+	item = filepath.ToSlash(item)
+	if b.isMarked(item) {
+		return true, node.Type == "dir"
+	}
+	return false, node.Type == "dir" && b.hasMarkedChild(item)
+}
+
+// isBelowPath returns whether p equals dir or is contained in it.
+func isBelowPath(p, dir string) bool {
+	if dir == "/" || p == dir {
+		return true
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
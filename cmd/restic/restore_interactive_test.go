@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestoreBrowser(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for _, name := range []string{"dir/a.txt", "dir/b.txt", "dir/sub/c.txt", "other/d.txt", "e.txt"} {
+		p := filepath.Join(env.testdata, filepath.FromSlash(name))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, os.WriteFile(p, []byte(name), 0644))
+	}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, snapshotIDs[0])
+	rtest.OK(t, err)
+
+	out := &bytes.Buffer{}
+	browser := newRestoreBrowser(repo, *sn.Tree, out)
+	keys := strings.Join([]string{
+		"\r",     // open /testdata
+		"\r",     // open /testdata/dir
+		" ", " ", // mark a.txt and b.txt
+		"\x1b[A",   // up to b.txt
+		" ",        // unmark b.txt
+		" ",        // mark sub
+		"\x7f",     // back to /testdata, the cursor is on dir
+		"\x1b[F",   // end, to other
+		" ",        // mark other
+		"\x1b[C",   // open /testdata/other
+		" ",        // d.txt is marked as part of other
+		"\x1b[D",   // back to /testdata
+		"\r",       // open dir
+		"\x1b[B\r", // b.txt is not a directory
+		"r",
+	}, "")
+	restore, err := browser.run(context.TODO(), strings.NewReader(keys))
+	rtest.OK(t, err)
+	rtest.Assert(t, restore, "browser did not return restore")
+	rtest.Equals(t, []string{"/testdata/dir/a.txt", "/testdata/dir/sub", "/testdata/other"}, browser.markedPaths())
+
+	output := out.String()
+	rtest.Assert(t, strings.Contains(output, "/testdata/other/d.txt is marked as part of /testdata/other"),
+		"missing message about marked parent in output:\n%v", output)
+	rtest.Assert(t, strings.Contains(output, "b.txt is not a directory"),
+		"missing error for opening a file in output:\n%v", output)
+	rtest.Assert(t, strings.Contains(output, "[*]             sub/"),
+		"missing marked directory in listing:\n%v", output)
+	rtest.Assert(t, strings.Contains(output, "[+]             dir/"),
+		"missing directory with marked items in listing:\n%v", output)
+
+	for _, test := range []struct {
+		selected, childMayBeSelected bool
+		item, tpe                    string
+	}{
+		{false, true, "/testdata", "dir"},
+		{false, true, "/testdata/dir", "dir"},
+		{true, false, "/testdata/dir/a.txt", "file"},
+		{false, false, "/testdata/dir/b.txt", "file"},
+		{true, true, "/testdata/dir/sub", "dir"},
+		{true, false, "/testdata/dir/sub/c.txt", "file"},
+		{true, false, "/testdata/other/d.txt", "file"},
+		{false, false, "/testdata/e.txt", "file"},
+	} {
+		selected, childMayBeSelected := browser.selectFilter(filepath.FromSlash(test.item), "", &restic.Node{Type: test.tpe})
+		rtest.Assert(t, selected == test.selected && childMayBeSelected == test.childMayBeSelected,
+			"unexpected result for %v: %v %v", test.item, selected, childMayBeSelected)
+	}
+
+	// quitting does not restore anything
+	restore, err = newRestoreBrowser(repo, *sn.Tree, out).run(context.TODO(), strings.NewReader(" q"))
+	rtest.OK(t, err)
+	rtest.Assert(t, !restore, "browser returned restore after quit")
+
+	// restoring requires a selection, pressing a twice marks and unmarks all
+	// items of a directory
+	out.Reset()
+	browser = newRestoreBrowser(repo, *sn.Tree, out)
+	restore, err = browser.run(context.TODO(), strings.NewReader("r\r\raa\x7far"))
+	rtest.OK(t, err)
+	rtest.Assert(t, restore, "browser did not return restore")
+	rtest.Assert(t, strings.Contains(out.String(), "nothing marked for restore"),
+		"missing error for empty selection in output:\n%v", out.String())
+	rtest.Equals(t, []string{"/testdata/dir", "/testdata/e.txt", "/testdata/other"}, browser.markedPaths())
+
+	// the listing scrolls to keep the cursor visible
+	out.Reset()
+	browser = newRestoreBrowser(repo, *sn.Tree, out)
+	browser.size = func() (int, int) { return 40, 5 }
+	_, err = browser.run(context.TODO(), strings.NewReader("\r\x1b[B\x1b[B"))
+	rtest.OK(t, err)
+	screens := strings.Split(out.String(), "\x1b[H")
+	last := screens[len(screens)-1]
+	rtest.Assert(t, strings.Contains(last, "other/") && !strings.Contains(last, "dir/"),
+		"listing did not scroll:\n%q", last)
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os"
+
+// enableTerminalSequences is a no-op, terminals on unix interpret the escape
+// sequences used by the restore browser.
+func enableTerminalSequences(_ *os.File) (func(), error) {
+	return func() {}, nil
+}
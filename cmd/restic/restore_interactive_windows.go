@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/sys/windows"
+)
+
+// enableTerminalSequences enables the processing of the escape sequences used
+// by the restore browser for the console f. The returned function restores
+// the previous console mode.
+func enableTerminalSequences(f *os.File) (func(), error) {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return nil, errors.Wrap(err, "GetConsoleMode")
+	}
+	if err := windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		return nil, errors.Wrap(err, "SetConsoleMode")
+	}
+	return func() {
+		_ = windows.SetConsoleMode(h, mode)
+	}, nil
+}
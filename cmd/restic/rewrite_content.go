@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+
+	"github.com/restic/restic/internal/dump"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+// parseContentHashes parses the SHA-256 hashes of file contents.
+func parseContentHashes(hashes []string) (restic.IDSet, error) {
+	ids := restic.NewIDSet()
+	for _, s := range hashes {
+		id, err := restic.ParseID(s)
+		if err != nil {
+			return nil, errors.Fatalf("invalid content hash %q: %v", s, err)
+		}
+		ids.Insert(id)
+	}
+	return ids, nil
+}
+
+// fileContentKey identifies the contents of a file node independent of its
+// metadata, such that each distinct file is only hashed once.
+func fileContentKey(node *restic.Node) restic.ID {
+	h := sha256.New()
+	for _, id := range node.Content {
+		_, _ = h.Write(id[:])
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], node.Size)
+	_, _ = h.Write(buf[:])
+	for _, v := range []interface{}{node.Bundle, node.ContentEncoding} {
+		if v != nil {
+			enc, _ := json.Marshal(v)
+			_, _ = h.Write(enc)
+		}
+	}
+
+	var id restic.ID
+	h.Sum(id[:0])
+	return id
+}
+
+// contentHasher computes the SHA-256 hash of the contents of file nodes.
+type contentHasher struct {
+	repo   restic.Repository
+	h      hash.Hash
+	dumper *dump.Dumper
+}
+
+func newContentHasher(repo restic.Repository) *contentHasher {
+	h := sha256.New()
+	return &contentHasher{repo: repo, h: h, dumper: dump.New("", repo, h)}
+}
+
+func (c *contentHasher) Hash(ctx context.Context, node *restic.Node) (restic.ID, error) {
+	// a file consisting of a single blob has its hash as blob ID
+	if len(node.Content) == 1 && node.Bundle == nil && node.ContentEncoding == nil &&
+		node.DataKey == nil && c.repo.Config().BlobHash == "" {
+		return node.Content[0], nil
+	}
+
+	c.h.Reset()
+	if err := c.dumper.WriteNode(ctx, node); err != nil {
+		return restic.ID{}, err
+	}
+
+	var id restic.ID
+	c.h.Sum(id[:0])
+	return id, nil
+}
+
+// bundledFile identifies a file stored within a bundle.
+type bundledFile struct {
+	bundle restic.ID
+	offset uint64
+	size   uint64
+}
+
+func newBundledFile(node *restic.Node) bundledFile {
+	return bundledFile{bundle: node.Content[0], offset: node.Bundle.Offset, size: node.Size}
+}
+
+// forgottenContent describes the data of the files to forget.
+type forgottenContent struct {
+	// blobs which are removed together with all files referencing them
+	blobs restic.IDSet
+	// bundles which contain a forgotten file. The other files of these
+	// bundles are stored separately, such that the bundle can be pruned.
+	bundles restic.IDSet
+	files   map[bundledFile]struct{}
+
+	// the last bundle loaded by rewriteNode
+	bundleID  restic.ID
+	bundleBuf []byte
+}
+
+func newForgottenContent() *forgottenContent {
+	return &forgottenContent{
+		blobs:   restic.NewIDSet(),
+		bundles: restic.NewIDSet(),
+		files:   make(map[bundledFile]struct{}),
+	}
+}
+
+func (c *forgottenContent) empty() bool {
+	return len(c.blobs) == 0 && len(c.bundles) == 0
+}
+
+func (c *forgottenContent) add(node *restic.Node) {
+	if node.Bundle != nil {
+		c.bundles.Insert(node.Content[0])
+		c.files[newBundledFile(node)] = struct{}{}
+		return
+	}
+	c.blobs.Merge(restic.NewIDSet(node.Content...))
+}
+
+// rewriteNode returns nil if node is a file which shares data with a
+// forgotten file. Files which are only stored in the same bundle as a
+// forgotten file are saved again as a blob of their own.
+func (c *forgottenContent) rewriteNode(ctx context.Context, repo restic.Repository, node *restic.Node) (*restic.Node, error) {
+	if referencesBlobs(node, c.blobs) {
+		return nil, nil
+	}
+	if node.Type != "file" || node.Bundle == nil || !c.bundles.Has(node.Content[0]) {
+		return node, nil
+	}
+	if _, ok := c.files[newBundledFile(node)]; ok {
+		return nil, nil
+	}
+
+	if c.bundleBuf == nil || c.bundleID != node.Content[0] {
+		buf, err := repo.LoadBlob(ctx, restic.DataBlob, node.Content[0], c.bundleBuf)
+		if err != nil {
+			return nil, err
+		}
+		c.bundleID, c.bundleBuf = node.Content[0], buf
+	}
+
+	end := node.Bundle.Offset + node.Size
+	if end < node.Bundle.Offset || end > uint64(len(c.bundleBuf)) {
+		return nil, errors.Errorf("file exceeds bundle %v", node.Content[0].Str())
+	}
+	id, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, c.bundleBuf[node.Bundle.Offset:end], restic.ID{}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	node.Content = restic.IDs{id}
+	node.Bundle = nil
+	return node, nil
+}
+
+// findContentBlobs returns the data of all files in the snapshots whose
+// contents have one of the hashes. Hashes for which no file was found are
+// reported as a warning.
+func findContentBlobs(ctx context.Context, repo restic.Repository, snapshots []*restic.Snapshot, hashes restic.IDSet) (*forgottenContent, error) {
+	forget := newForgottenContent()
+	found := restic.NewIDSet()
+	hashed := make(map[restic.ID]restic.ID)
+	hasher := newContentHasher(repo)
+	ignoreTrees := restic.NewIDSet()
+
+	for _, sn := range snapshots {
+		if sn.Tree == nil {
+			return nil, errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+		}
+		err := walker.Walk(ctx, repo, *sn.Tree, ignoreTrees, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+			if err != nil {
+				return false, err
+			}
+			if node == nil || node.Type != "file" {
+				return true, nil
+			}
+
+			key := fileContentKey(node)
+			hash, ok := hashed[key]
+			if !ok {
+				hash, err = hasher.Hash(ctx, node)
+				if err != nil {
+					return false, errors.Fatalf("unable to hash %v: %v", nodepath, err)
+				}
+				hashed[key] = hash
+			}
+
+			if hashes.Has(hash) {
+				Verbosef("found content %v at %v in snapshot %v\n", hash.Str(), nodepath, sn.ID().Str())
+				found.Insert(hash)
+				forget.add(node)
+			}
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for hash := range hashes {
+		if !found.Has(hash) {
+			Warnf("no file with content %v found\n", hash)
+		}
+	}
+	return forget, nil
+}
+
+// referencesBlobs returns true if the file node references one of the blobs.
+func referencesBlobs(node *restic.Node, blobs restic.IDSet) bool {
+	if node.Type != "file" {
+		return false
+	}
+	for _, id := range node.Content {
+		if blobs.Has(id) {
+			return true
+		}
+	}
+	return false
+}
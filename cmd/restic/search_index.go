@@ -0,0 +1,625 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/dump"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The search index contains the names of all entries of the trees of the
+// indexed snapshots, such that find only has to load the trees which contain
+// matching entries. Trees shared by several snapshots are only contained
+// once. Optionally, the index contains the trigrams of the contents of small
+// files, which allows skipping files which cannot contain a searched string.
+//
+// Each file stored as restic.SearchIndexFile is self-contained. If several
+// files exist, for example because index-files ran concurrently, a snapshot
+// is looked up in the first file containing it.
+
+// searchIndexFile is stored as restic.SearchIndexFile.
+type searchIndexFile struct {
+	// Content is true if the contents of the files were indexed
+	Content   bool                  `json:"content,omitempty"`
+	Names     []string              `json:"names"`
+	Trees     []searchIndexTree     `json:"trees"`
+	Contents  []searchIndexContent  `json:"contents,omitempty"`
+	Snapshots []searchIndexSnapshot `json:"snapshots"`
+}
+
+// searchIndexTree contains for each node, ordered by name, the index of its
+// name, of the tree of a directory and of the content of a file. Nodes
+// without tree use -1, for the contents see contentNotIndexed and
+// contentNoFile.
+type searchIndexTree struct {
+	ID       restic.ID `json:"id"`
+	Names    []int     `json:"names"`
+	Subtrees []int     `json:"subtrees"`
+	Contents []int     `json:"contents,omitempty"`
+}
+
+// searchIndexContent contains the sorted trigrams of the contents of a file, encoded as differences to the previous trigram using varints.
+// Key identifies the content independent of the file metadata.
+type searchIndexContent struct {
+	Key      restic.ID `json:"key"`
+	Trigrams []byte    `json:"trigrams"`
+}
+
+const (
+	// contentNotIndexed marks files whose content is not indexed
+	contentNotIndexed = -1
+	// contentNoFile marks nodes which are not files
+	contentNoFile = -2
+)
+
+type searchIndexSnapshot struct {
+	ID   restic.ID `json:"id"`
+	Tree int       `json:"tree"`
+}
+
+// searchIndex contains all search index files of a repository.
+type searchIndex struct {
+	files     restic.IDs
+	indexes   []*searchIndexFile
+	snapshots map[restic.ID]searchIndexRef
+}
+
+// searchIndexRef references a tree within an index file.
+type searchIndexRef struct {
+	index *searchIndexFile
+	tree  int
+}
+
+// loadSearchIndex loads all search index files, it returns an empty index if
+// the repository has none.
+func loadSearchIndex(ctx context.Context, repo restic.Repository) (*searchIndex, error) {
+	idx := &searchIndex{snapshots: make(map[restic.ID]searchIndexRef)}
+	err := repo.List(ctx, restic.SearchIndexFile, func(id restic.ID, _ int64) error {
+		idx.files = append(idx.files, id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range idx.files {
+		f := &searchIndexFile{}
+		if err := restic.LoadJSONUnpacked(ctx, repo, restic.SearchIndexFile, id, f); err != nil {
+			return nil, errors.Wrapf(err, "loading search index %v", id.Str())
+		}
+		if err := f.check(); err != nil {
+			return nil, errors.Wrapf(err, "search index %v", id.Str())
+		}
+		idx.indexes = append(idx.indexes, f)
+		for _, sn := range f.Snapshots {
+			if _, ok := idx.snapshots[sn.ID]; !ok {
+				idx.snapshots[sn.ID] = searchIndexRef{index: f, tree: sn.Tree}
+			}
+		}
+	}
+	debug.Log("loaded search index for %d snapshots from %d files", len(idx.snapshots), len(idx.files))
+	return idx, nil
+}
+
+// check verifies that all references within the file are valid. As trees are
+// stored after their subtrees, a tree can only reference the trees before it.
+func (f *searchIndexFile) check() error {
+	valid := func(i, n int, allowUnset bool) bool {
+		return (i >= 0 && i < n) || (allowUnset && i == -1)
+	}
+	validContent := func(i int) bool {
+		return valid(i, len(f.Contents), true) || i == contentNoFile
+	}
+	for idx, tree := range f.Trees {
+		if len(tree.Subtrees) != len(tree.Names) || (tree.Contents != nil && len(tree.Contents) != len(tree.Names)) {
+			return errors.Errorf("invalid tree %v", tree.ID.Str())
+		}
+		for i := range tree.Names {
+			if !valid(tree.Names[i], len(f.Names), false) || !valid(tree.Subtrees[i], idx, true) ||
+				(tree.Contents != nil && !validContent(tree.Contents[i])) {
+				return errors.Errorf("invalid tree %v", tree.ID.Str())
+			}
+		}
+	}
+	for _, sn := range f.Snapshots {
+		if !valid(sn.Tree, len(f.Trees), false) {
+			return errors.Errorf("invalid snapshot %v", sn.ID.Str())
+		}
+	}
+	return nil
+}
+
+// Lookup returns the tree of the snapshot, if the snapshot is indexed.
+func (idx *searchIndex) Lookup(sn *restic.Snapshot) (searchIndexRef, bool) {
+	ref, ok := idx.snapshots[*sn.ID()]
+	if !ok || sn.Tree == nil || ref.index.Trees[ref.tree].ID != *sn.Tree {
+		return searchIndexRef{}, false
+	}
+	return ref, true
+}
+
+// searchIndexBuilder creates a new search index file. Trees contained in a
+// previous index are copied without loading them from the repository.
+type searchIndexBuilder struct {
+	repo           restic.Repository
+	content        bool
+	maxContentSize uint64
+
+	file     searchIndexFile
+	names    map[string]int
+	trees    map[restic.ID]int
+	contents map[restic.ID]int
+
+	// previous contains the trees of the previous index files
+	previous map[restic.ID]searchIndexRef
+
+	trigrams *trigramWriter
+	dumper   *dump.Dumper
+}
+
+func newSearchIndexBuilder(repo restic.Repository, previous *searchIndex, content bool, maxContentSize uint64) *searchIndexBuilder {
+	b := &searchIndexBuilder{
+		repo:           repo,
+		content:        content,
+		maxContentSize: maxContentSize,
+		file:           searchIndexFile{Content: content},
+		names:          make(map[string]int),
+		trees:          make(map[restic.ID]int),
+		contents:       make(map[restic.ID]int),
+		previous:       make(map[restic.ID]searchIndexRef),
+		trigrams:       newTrigramWriter(),
+	}
+	b.dumper = dump.New("", repo, b.trigrams)
+
+	for _, f := range previous.indexes {
+		// trees without contents must be loaded again to index them
+		if content && !f.Content {
+			continue
+		}
+		for i, tree := range f.Trees {
+			b.previous[tree.ID] = searchIndexRef{index: f, tree: i}
+		}
+	}
+	return b
+}
+
+func (b *searchIndexBuilder) name(name string) int {
+	idx, ok := b.names[name]
+	if !ok {
+		idx = len(b.file.Names)
+		b.names[name] = idx
+		b.file.Names = append(b.file.Names, name)
+	}
+	return idx
+}
+
+// AddSnapshot adds the snapshot and all its trees.
+func (b *searchIndexBuilder) AddSnapshot(ctx context.Context, sn *restic.Snapshot) error {
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+	tree, err := b.addTree(ctx, *sn.Tree)
+	if err != nil {
+		return err
+	}
+	b.file.Snapshots = append(b.file.Snapshots, searchIndexSnapshot{ID: *sn.ID(), Tree: tree})
+	return nil
+}
+
+func (b *searchIndexBuilder) addTree(ctx context.Context, id restic.ID) (int, error) {
+	if idx, ok := b.trees[id]; ok {
+		return idx, nil
+	}
+	if ref, ok := b.previous[id]; ok {
+		return b.copyTree(ctx, ref)
+	}
+
+	tree, err := restic.LoadTree(ctx, b.repo, id)
+	if err != nil {
+		return 0, errors.Fatalf("unable to load tree %v: %v", id.Str(), err)
+	}
+	sort.Sort(restic.Nodes(tree.Nodes))
+
+	entry := searchIndexTree{
+		ID:       id,
+		Names:    make([]int, len(tree.Nodes)),
+		Subtrees: make([]int, len(tree.Nodes)),
+	}
+	if b.content {
+		entry.Contents = make([]int, len(tree.Nodes))
+	}
+	for i, node := range tree.Nodes {
+		entry.Names[i] = b.name(node.Name)
+		entry.Subtrees[i] = -1
+		if node.Type == "dir" && node.Subtree != nil {
+			entry.Subtrees[i], err = b.addTree(ctx, *node.Subtree)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if b.content {
+			entry.Contents[i], err = b.addContent(ctx, node)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return b.appendTree(entry), nil
+}
+
+func (b *searchIndexBuilder) appendTree(entry searchIndexTree) int {
+	idx := len(b.file.Trees)
+	b.trees[entry.ID] = idx
+	b.file.Trees = append(b.file.Trees, entry)
+	return idx
+}
+
+// copyTree copies a tree from a previous index file.
+func (b *searchIndexBuilder) copyTree(ctx context.Context, ref searchIndexRef) (int, error) {
+	old := ref.index.Trees[ref.tree]
+	entry := searchIndexTree{
+		ID:       old.ID,
+		Names:    make([]int, len(old.Names)),
+		Subtrees: make([]int, len(old.Names)),
+	}
+	if b.content {
+		entry.Contents = make([]int, len(old.Names))
+	}
+
+	for i := range old.Names {
+		entry.Names[i] = b.name(ref.index.Names[old.Names[i]])
+		entry.Subtrees[i] = -1
+		if old.Subtrees[i] >= 0 {
+			var err error
+			entry.Subtrees[i], err = b.addTree(ctx, ref.index.Trees[old.Subtrees[i]].ID)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if b.content {
+			switch {
+			case old.Contents == nil:
+				entry.Contents[i] = contentNotIndexed
+			case old.Contents[i] < 0:
+				entry.Contents[i] = old.Contents[i]
+			default:
+				content := ref.index.Contents[old.Contents[i]]
+				idx, ok := b.contents[content.Key]
+				if !ok {
+					idx = b.appendContent(content)
+				}
+				entry.Contents[i] = idx
+			}
+		}
+	}
+	return b.appendTree(entry), nil
+}
+
+func (b *searchIndexBuilder) appendContent(content searchIndexContent) int {
+	idx := len(b.file.Contents)
+	b.contents[content.Key] = idx
+	b.file.Contents = append(b.file.Contents, content)
+	return idx
+}
+
+// addContent indexes the trigrams of a file which is not larger than the
+// maximum size of indexed contents.
+func (b *searchIndexBuilder) addContent(ctx context.Context, node *restic.Node) (int, error) {
+	if node.Type != "file" {
+		return contentNoFile, nil
+	}
+	if node.Size > b.maxContentSize {
+		return contentNotIndexed, nil
+	}
+	key := fileContentKey(node)
+	if idx, ok := b.contents[key]; ok {
+		return idx, nil
+	}
+
+	b.trigrams.Reset()
+	if err := b.dumper.WriteNode(ctx, node); err != nil {
+		return 0, errors.Fatalf("unable to read %v: %v", node.Name, err)
+	}
+	return b.appendContent(searchIndexContent{Key: key, Trigrams: b.trigrams.Encode()}), nil
+}
+
+// Save stores the index file and removes the previous files.
+func (b *searchIndexBuilder) Save(ctx context.Context, previous *searchIndex) (restic.ID, error) {
+	id, err := restic.SaveJSONUnpacked(ctx, b.repo, restic.SearchIndexFile, &b.file)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "saving search index")
+	}
+	return id, removeSearchIndex(ctx, b.repo, previous)
+}
+
+// removeSearchIndex removes the files of the search index.
+func removeSearchIndex(ctx context.Context, repo restic.Repository, idx *searchIndex) error {
+	for _, id := range idx.files {
+		err := repo.Backend().Remove(ctx, restic.Handle{Type: restic.SearchIndexFile, Name: id.String()})
+		// a concurrent run may already have removed the file
+		if err != nil && !repo.Backend().IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// trigramWriter collects the trigrams of the data written to it. ASCII letters
+// are converted to lowercase, such that the trigrams can be used for case
+// insensitive searches.
+type trigramWriter struct {
+	set  map[uint32]struct{}
+	last uint32
+	n    int
+}
+
+func newTrigramWriter() *trigramWriter {
+	return &trigramWriter{set: make(map[uint32]struct{})}
+}
+
+func (w *trigramWriter) Reset() {
+	w.set = make(map[uint32]struct{})
+	w.last = 0
+	w.n = 0
+}
+
+func (w *trigramWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		w.last = (w.last<<8 | uint32(lowerASCII(c))) & 0xffffff
+		w.n++
+		if w.n >= 3 {
+			w.set[w.last] = struct{}{}
+		}
+	}
+	return len(p), nil
+}
+
+func (w *trigramWriter) Sorted() []uint32 {
+	list := make([]uint32, 0, len(w.set))
+	for t := range w.set {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+func (w *trigramWriter) Encode() []byte {
+	var buf []byte
+	var prev uint32
+	var tmp [binary.MaxVarintLen32]byte
+	for _, t := range w.Sorted() {
+		n := binary.PutUvarint(tmp[:], uint64(t-prev))
+		buf = append(buf, tmp[:n]...)
+		prev = t
+	}
+	return buf
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// textTrigrams returns the sorted trigrams which any file containing text
+// must contain. For case insensitive searches only the trigrams consisting of
+// ASCII characters are used, as the case of other characters is not folded in
+// the index.
+func textTrigrams(text string, ignoreCase bool) []uint32 {
+	w := newTrigramWriter()
+	_, _ = w.Write([]byte(text))
+	list := w.Sorted()
+	if !ignoreCase {
+		return list
+	}
+
+	ascii := list[:0]
+	for _, t := range list {
+		if t&0x808080 == 0 {
+			ascii = append(ascii, t)
+		}
+	}
+	return ascii
+}
+
+// decodeTrigrams returns the sorted trigrams encoded by trigramWriter.Encode.
+func decodeTrigrams(buf []byte) ([]uint32, error) {
+	var list []uint32
+	var prev uint32
+	for len(buf) > 0 {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, errors.New("invalid trigrams")
+		}
+		prev += uint32(v)
+		list = append(list, prev)
+		buf = buf[n:]
+	}
+	return list, nil
+}
+
+// containsTrigrams returns true if all trigrams in needle are contained in
+// the sorted list.
+func containsTrigrams(list []uint32, needle []uint32) bool {
+	for _, t := range needle {
+		i := sort.Search(len(list), func(i int) bool { return list[i] >= t })
+		if i == len(list) || list[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// searchIndexMatcher determines which trees of the search index may contain
+// entries matching the patterns and the searched file contents. The results are
+// remembered for each tree, such that trees shared by several snapshots are
+// only checked once.
+type searchIndexMatcher struct {
+	// names contains the last component of each pattern, nil matches all
+	// names
+	names      []string
+	ignoreCase bool
+	// filesOnly is true if only files can match
+	filesOnly bool
+	// trigrams is nil unless the contents are searched
+	trigrams []uint32
+	content  bool
+
+	memo map[*searchIndexFile]*searchIndexMemo
+}
+
+// searchIndexMemo contains the results for an index file, zero means unknown.
+type searchIndexMemo struct {
+	// trees contains the results for trees whose parent paths did or did not
+	// match
+	trees    [2][]int8
+	names    []int8
+	contents []int8
+}
+
+const (
+	memoUnknown = iota
+	memoNoMatch
+	memoMayMatch
+)
+
+func newSearchIndexMatcher(pat findPattern) *searchIndexMatcher {
+	m := &searchIndexMatcher{
+		names:      lastPatternComponents(pat.pattern),
+		ignoreCase: pat.ignoreCase,
+		filesOnly:  pat.filesOnly(),
+		memo:       make(map[*searchIndexFile]*searchIndexMemo),
+	}
+	if pat.regexps != nil {
+		// a regular expression can match any name
+		m.names = nil
+	}
+	if pat.content != "" {
+		m.content = true
+		m.trigrams = textTrigrams(pat.content, pat.ignoreCase)
+	}
+	return m
+}
+
+func (m *searchIndexMatcher) memoFor(f *searchIndexFile) *searchIndexMemo {
+	memo, ok := m.memo[f]
+	if !ok {
+		memo = &searchIndexMemo{
+			trees:    [2][]int8{make([]int8, len(f.Trees)), make([]int8, len(f.Trees))},
+			names:    make([]int8, len(f.Names)),
+			contents: make([]int8, len(f.Contents)),
+		}
+		m.memo[f] = memo
+	}
+	return memo
+}
+
+func result(match bool) int8 {
+	if match {
+		return memoMayMatch
+	}
+	return memoNoMatch
+}
+
+// NameMayMatch returns true if the name matches the last component of any
+// pattern. Only then the path of the node or of its children can match.
+func (m *searchIndexMatcher) NameMayMatch(f *searchIndexFile, name int) bool {
+	if m.names == nil {
+		return true
+	}
+	memo := m.memoFor(f)
+	if memo.names[name] == memoUnknown {
+		s := f.Names[name]
+		if m.ignoreCase {
+			s = strings.ToLower(s)
+		}
+		match := false
+		for _, pat := range m.names {
+			ok, err := filepath.Match(pat, s)
+			// invalid patterns are reported when matching the path
+			if ok || err != nil {
+				match = true
+				break
+			}
+		}
+		memo.names[name] = result(match)
+	}
+	return memo.names[name] == memoMayMatch
+}
+
+// ContentMayMatch returns true if the i-th node of the tree may be a file
+// matching the search, for example by containing the searched text.
+func (m *searchIndexMatcher) ContentMayMatch(f *searchIndexFile, tree, i int) bool {
+	entry := f.Trees[tree]
+	switch {
+	case !m.filesOnly:
+		return true
+	case entry.Subtrees[i] >= 0:
+		return false
+	case !f.Content || entry.Contents == nil || entry.Contents[i] == contentNotIndexed:
+		return true
+	case entry.Contents[i] == contentNoFile:
+		return false
+	case !m.content:
+		return true
+	}
+
+	memo := m.memoFor(f)
+	content := entry.Contents[i]
+	if memo.contents[content] == memoUnknown {
+		list, err := decodeTrigrams(f.Contents[content].Trigrams)
+		memo.contents[content] = result(err != nil || containsTrigrams(list, m.trigrams))
+	}
+	return memo.contents[content] == memoMayMatch
+}
+
+// TreeMayMatch returns true if the tree or its subtrees may contain matching
+// entries. If parentMatch is true, the path of the tree matched a pattern.
+func (m *searchIndexMatcher) TreeMayMatch(f *searchIndexFile, tree int, parentMatch bool) bool {
+	memo := m.memoFor(f)
+	results := memo.trees[0]
+	if parentMatch {
+		results = memo.trees[1]
+	}
+	if results[tree] != memoUnknown {
+		return results[tree] == memoMayMatch
+	}
+
+	match := false
+	entry := f.Trees[tree]
+	for i, name := range entry.Names {
+		nameMatch := parentMatch || m.NameMayMatch(f, name)
+		if nameMatch && m.ContentMayMatch(f, tree, i) {
+			match = true
+			break
+		}
+		if entry.Subtrees[i] >= 0 && m.TreeMayMatch(f, entry.Subtrees[i], nameMatch) {
+			match = true
+			break
+		}
+	}
+	results[tree] = result(match)
+	return match
+}
+
+// lastPatternComponents returns the last path component of each pattern.
+// The result is nil if any pattern can match arbitrary names.
+func lastPatternComponents(patterns []string) []string {
+	var list []string
+	for _, pat := range patterns {
+		last := path.Base(filepath.ToSlash(filepath.Clean(pat)))
+		if last == "**" || last == "/" || last == "." {
+			return nil
+		}
+		list = append(list, last)
+	}
+	return list
+}
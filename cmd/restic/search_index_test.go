@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestTrigrams(t *testing.T) {
+	w := newTrigramWriter()
+	_, _ = w.Write([]byte("Fo"))
+	_, _ = w.Write([]byte("oBar\xff"))
+	list, err := decodeTrigrams(w.Encode())
+	rtest.OK(t, err)
+	rtest.Equals(t, w.Sorted(), list)
+
+	for _, text := range []string{"foob", "OOBA", "bar\xff"} {
+		rtest.Assert(t, containsTrigrams(list, textTrigrams(text, false)), "trigrams of %q not found", text)
+	}
+	rtest.Assert(t, !containsTrigrams(list, textTrigrams("fob", false)), "trigrams of fob found")
+	// non-ASCII trigrams are not used for case insensitive searches
+	rtest.Equals(t, 0, len(textTrigrams("\xffab", true)))
+
+	_, err = decodeTrigrams([]byte{0xff})
+	rtest.Assert(t, err != nil, "decoding invalid trigrams did not fail")
+}
+
+func TestTextMatcher(t *testing.T) {
+	var tests = []struct {
+		data       []string
+		text       string
+		ignoreCase bool
+		found      bool
+	}{
+		{[]string{"foo bar"}, "o b", false, true},
+		{[]string{"foo", " ", "bar"}, "o b", false, true},
+		{[]string{"foo", " bar"}, "O B", false, false},
+		{[]string{"foo", " bar"}, "o b", true, true},
+		{[]string{"xÄ", "ÖÜ"}, "äöü", true, true},
+		{[]string{"x\xc3", "\x84"}, "ä", true, true},
+		{[]string{strings.Repeat("x", 100), "y"}, "xy", false, true},
+		{[]string{"ab", "cd"}, "ac", false, false},
+	}
+
+	for _, test := range tests {
+		m := newTextMatcher(test.text, test.ignoreCase)
+		if test.ignoreCase {
+			m = newTextMatcher(strings.ToLower(test.text), true)
+		}
+		found := false
+		for _, data := range test.data {
+			if _, err := m.Write([]byte(data)); errors.Is(err, errTextFound) {
+				found = true
+				break
+			}
+		}
+		rtest.Assert(t, found == test.found, "%q in %q: expected %v, got %v", test.text, test.data, test.found, found)
+	}
+}
+
+func TestLastPatternComponents(t *testing.T) {
+	rtest.Equals(t, []string{"foo", "*.txt"}, lastPatternComponents([]string{"/a/foo", "b/*.txt/"}))
+	rtest.Equals(t, []string(nil), lastPatternComponents([]string{"foo", "a/**"}))
+	rtest.Equals(t, []string(nil), lastPatternComponents([]string{"/"}))
+}
@@ -100,6 +100,8 @@ func fillSecondaryGlobalOpts(opts secondaryRepoOptions, gopts GlobalOptions, rep
 
 		pwdEnv = "RESTIC_PASSWORD2"
 	}
+	// the identity only applies to the main repository
+	dstGopts.IdentityFile = ""
 
 	if opts.password != "" {
 		dstGopts.password = opts.password
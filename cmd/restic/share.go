@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/options"
+	"github.com/restic/restic/internal/textfile"
+)
+
+// mountShare mounts the share at location, which was passed via the option
+// flag, and returns the path of the selected directory within the share. The
+// password is read from passwordFile or $RESTIC_SHARE_PASSWORD. The share is
+// mounted read-only unless writable is set.
+func mountShare(flag, location, mountOptions, passwordFile string, writable bool) (share fs.Share, target string, unmount func() error, err error) {
+	share, err = fs.ParseShare(location)
+	if err != nil {
+		return fs.Share{}, "", nil, errors.Fatalf("invalid %v: %v", flag, err)
+	}
+	share.Options = mountOptions
+
+	password := os.Getenv("RESTIC_SHARE_PASSWORD")
+	if passwordFile != "" {
+		data, err := textfile.Read(passwordFile)
+		if err != nil {
+			return fs.Share{}, "", nil, errors.Fatalf("unable to read share password: %v", err)
+		}
+		password = strings.TrimSpace(string(data))
+	}
+	if password != "" {
+		share.Password = options.NewSecretString(password)
+	}
+
+	mountpoint := share.DefaultMountpoint()
+	if writable {
+		// do not interfere with a backup of the same share
+		mountpoint += "-rw"
+	}
+	unmount, err = fs.MountShare(share, mountpoint, writable)
+	if err != nil {
+		return fs.Share{}, "", nil, errors.Fatal(err.Error())
+	}
+
+	return share, filepath.Join(mountpoint, filepath.FromSlash(share.Path)), unmount, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/textfile"
+	"github.com/spf13/pflag"
+)
+
+// signingKeyOptions selects the key used to sign snapshots which are modified
+// by a command.
+type signingKeyOptions struct {
+	SigningKeyFile string
+}
+
+func initSigningKeyOptions(f *pflag.FlagSet, opts *signingKeyOptions) {
+	f.StringVar(&opts.SigningKeyFile, "signing-key", "", "sign the modified snapshots with the Ed25519 private key in PEM `file`, otherwise their signature is removed (default: $RESTIC_SIGNING_KEY_FILE)")
+	opts.SigningKeyFile = os.Getenv("RESTIC_SIGNING_KEY_FILE")
+}
+
+// load returns the signing key, or nil if none was configured.
+func (opts signingKeyOptions) load() (ed25519.PrivateKey, error) {
+	if opts.SigningKeyFile == "" {
+		return nil, nil
+	}
+	return loadSigningKey(opts.SigningKeyFile)
+}
+
+// resignSnapshot signs the modified snapshot sn with key. Without a key, an
+// existing signature is removed, as it does not match the modified snapshot.
+func resignSnapshot(sn *restic.Snapshot, key ed25519.PrivateKey) error {
+	if key != nil {
+		return sn.Sign(key)
+	}
+	if sn.Signature != nil {
+		Warnf("removing the signature of snapshot %v, pass --signing-key to sign it again\n", sn.ID().Str())
+		sn.Signature = nil
+	}
+	return nil
+}
+
+// loadSigningKey reads the Ed25519 private key used to sign snapshots.
+func loadSigningKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := textfile.Read(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Fatalf("signing key %s does not exist", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := restic.ParseSigningKey(data)
+	if err != nil {
+		return nil, errors.Fatalf("unable to load signing key %s: %v", filename, err)
+	}
+	return key, nil
+}
+
+// loadVerifyKeys reads the trusted Ed25519 public keys used to verify
+// snapshot signatures.
+func loadVerifyKeys(filenames []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(filenames))
+	for _, filename := range filenames {
+		data, err := textfile.Read(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Fatalf("public key %s does not exist", filename)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := restic.ParseVerifyKey(data)
+		if err != nil {
+			return nil, errors.Fatalf("unable to load public key %s: %v", filename, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// statsTimelineEntry contains the data added to the repository by a snapshot.
+type statsTimelineEntry struct {
+	Time     time.Time  `json:"time"`
+	ID       *restic.ID `json:"id"`
+	ShortID  string     `json:"short_id"`
+	Hostname string     `json:"hostname"`
+	Paths    []string   `json:"paths"`
+	// AddedSize is the stored size of the blobs not referenced by any
+	// earlier snapshot
+	AddedSize      uint64 `json:"added_size"`
+	AddedBlobCount uint64 `json:"added_blob_count"`
+	// CumulativeSize is the stored size of all blobs referenced by this and
+	// all earlier snapshots
+	CumulativeSize uint64 `json:"cumulative_size"`
+}
+
+// timelineBlobSet records the blobs which were not seen before.
+type timelineBlobSet struct {
+	seen  restic.BlobSet
+	added []restic.BlobHandle
+}
+
+func (s *timelineBlobSet) Has(h restic.BlobHandle) bool {
+	return s.seen.Has(h)
+}
+
+func (s *timelineBlobSet) Insert(h restic.BlobHandle) {
+	if !s.seen.Has(h) {
+		s.seen.Insert(h)
+		s.added = append(s.added, h)
+	}
+}
+
+// statsTimeline returns the growth of the repository caused by each snapshot,
+// ordered by the time of the snapshots.
+func statsTimeline(ctx context.Context, repo restic.Repository, snapshots []*restic.Snapshot) ([]statsTimelineEntry, error) {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	blobs := &timelineBlobSet{seen: restic.NewBlobSet()}
+	entries := make([]statsTimelineEntry, 0, len(snapshots))
+	var cumulative uint64
+	for _, sn := range snapshots {
+		if sn.Tree == nil {
+			return nil, fmt.Errorf("snapshot %s has nil tree", sn.ID().Str())
+		}
+
+		blobs.added = blobs.added[:0]
+		err := restic.FindUsedBlobs(ctx, repo, restic.IDs{*sn.Tree}, blobs, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := statsTimelineEntry{
+			Time:     sn.Time,
+			ID:       sn.ID(),
+			ShortID:  sn.ID().Str(),
+			Hostname: sn.Hostname,
+			Paths:    sn.Paths,
+		}
+		for _, h := range blobs.added {
+			pbs := repo.Index().Lookup(h)
+			if len(pbs) == 0 {
+				return nil, fmt.Errorf("blob %v not found", h)
+			}
+			entry.AddedSize += uint64(pbs[0].Length)
+			entry.AddedBlobCount++
+		}
+		cumulative += entry.AddedSize
+		entry.CumulativeSize = cumulative
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func printStatsTimeline(gopts GlobalOptions, entries []statsTimelineEntry) error {
+	if gopts.JSON {
+		err := json.NewEncoder(gopts.stdout).Encode(entries)
+		if err != nil {
+			return fmt.Errorf("encoding output: %v", err)
+		}
+		return nil
+	}
+
+	w := csv.NewWriter(gopts.stdout)
+	err := w.Write([]string{"time", "snapshot", "hostname", "paths", "added_size", "added_blob_count", "cumulative_size"})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = w.Write([]string{
+			entry.Time.Format(time.RFC3339),
+			entry.ID.String(),
+			entry.Hostname,
+			strings.Join(entry.Paths, ","),
+			fmt.Sprint(entry.AddedSize),
+			fmt.Sprint(entry.AddedBlobCount),
+			fmt.Sprint(entry.CumulativeSize),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -32,6 +32,7 @@ require (
 	golang.org/x/term v0.7.0
 	golang.org/x/text v0.9.0
 	google.golang.org/api v0.116.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -67,7 +68,6 @@ require (
 	google.golang.org/grpc v1.54.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 go 1.18
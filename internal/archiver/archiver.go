@@ -2,6 +2,7 @@ package archiver
 
 import (
 	"context"
+	"crypto/ed25519"
 	"os"
 	"path"
 	"runtime"
@@ -86,8 +87,52 @@ type Archiver struct {
 	// default.
 	WithAtime bool
 
+	// UnpackLayers configures if gzip compressed tar files, for example
+	// docker image layers, are stored decompressed to improve deduplication.
+	UnpackLayers bool
+	// UnpackLayersAllLevels tries all gzip compression levels to reproduce a
+	// layer. By default only the commonly used levels are tried.
+	UnpackLayersAllLevels bool
+
+	// BundleThreshold enables storing files smaller than this many bytes
+	// together with other small files of the same directory in a single data
+	// blob. Bundling is disabled if BundleThreshold is zero.
+	BundleThreshold uint64
+
 	// Flags controlling change detection. See doc/040_backup.rst for details.
 	ChangeIgnoreFlags uint
+
+	// LookupContent may return a node with the same content as the file
+	// target, whose data blobs are all stored in the repository. The file is
+	// then not read, see MountSource.
+	LookupContent func(ctx context.Context, target string, fi os.FileInfo) (*restic.Node, error)
+
+	// CompleteFileHash is called with the SHA-256 hash of the contents of
+	// each file which was read and chunked, see FileHashCache. Small files
+	// stored in bundles are not reported.
+	CompleteFileHash func(target string, fi os.FileInfo, node *restic.Node, hash restic.ID)
+
+	// UnchangedDir may report that the directory target (an absolute path)
+	// and everything within it has not changed since the parent snapshot. The
+	// directory is then not read and the tree of the parent snapshot is used.
+	UnchangedDir func(target string) bool
+
+	// Reread may report that the file target (an absolute path) must be read
+	// even if it has not changed since the parent snapshot.
+	Reread func(target string) bool
+
+	// DataKeys enables encrypting the contents of each file with a data key
+	// of its own. A file keeps the key of its previous node. The contents of
+	// other files are not reused and small files are not bundled, so that no
+	// blob is shared by files with different keys.
+	DataKeys DataKeyRepository
+}
+
+// DataKeyRepository manages the data keys of a repository.
+type DataKeyRepository interface {
+	NewDataKey(ctx context.Context) (restic.ID, error)
+	HasDataKey(ctx context.Context, id restic.ID) (bool, error)
+	SealDataBlob(ctx context.Context, key restic.ID, data []byte) ([]byte, error)
 }
 
 // Flags for the ChangeIgnoreFlags bitfield.
@@ -230,6 +275,11 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, dir string, fi
 
 	nodes := make([]FutureNode, 0, len(names))
 
+	var bundler *fileBundler
+	if arch.BundleThreshold > 0 {
+		bundler = newFileBundler(arch)
+	}
+
 	for _, name := range names {
 		// test if context has been cancelled
 		if ctx.Err() != nil {
@@ -240,7 +290,7 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, dir string, fi
 		pathname := arch.FS.Join(dir, name)
 		oldNode := previous.Find(name)
 		snItem := join(snPath, name)
-		fn, excluded, err := arch.Save(ctx, snItem, pathname, oldNode)
+		fn, excluded, err := arch.save(ctx, snItem, pathname, oldNode, bundler)
 
 		// return error early if possible
 		if err != nil {
@@ -260,6 +310,10 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, dir string, fi
 		nodes = append(nodes, fn)
 	}
 
+	if bundler != nil {
+		bundler.flush(ctx)
+	}
+
 	fn := arch.treeSaver.Save(ctx, snPath, dir, treeNode, nodes, complete)
 
 	return fn, nil
@@ -312,6 +366,38 @@ func (fn *FutureNode) take(ctx context.Context) futureNodeResult {
 	return futureNodeResult{err: errors.Errorf("no result")}
 }
 
+// dataKey returns the data key of the previous node, or a new data key.
+func (arch *Archiver) dataKey(ctx context.Context, previous *restic.Node) (*restic.ID, error) {
+	if previous != nil && previous.DataKey != nil {
+		ok, err := arch.DataKeys.HasDataKey(ctx, *previous.DataKey)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			id := *previous.DataKey
+			return &id, nil
+		}
+	}
+
+	id, err := arch.DataKeys.NewDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// unchangedDir returns whether the tree of the previous node can be used for
+// the directory target without reading it.
+func (arch *Archiver) unchangedDir(target string, previous *restic.Node) bool {
+	if arch.UnchangedDir == nil || previous == nil || previous.Type != "dir" || previous.Subtree == nil {
+		return false
+	}
+	if !arch.Repo.Index().Has(restic.BlobHandle{ID: *previous.Subtree, Type: restic.TreeBlob}) {
+		return false
+	}
+	return arch.UnchangedDir(target)
+}
+
 // allBlobsPresent checks if all blobs (contents) of the given node are
 // present in the index.
 func (arch *Archiver) allBlobsPresent(previous *restic.Node) bool {
@@ -332,6 +418,11 @@ func (arch *Archiver) allBlobsPresent(previous *restic.Node) bool {
 //
 // snPath is the path within the current snapshot.
 func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous *restic.Node) (fn FutureNode, excluded bool, err error) {
+	return arch.save(ctx, snPath, target, previous, nil)
+}
+
+// save implements Save. Small files are added to bundler if it is not nil.
+func (arch *Archiver) save(ctx context.Context, snPath, target string, previous *restic.Node, bundler *fileBundler) (fn FutureNode, excluded bool, err error) {
 	start := time.Now()
 
 	debug.Log("%v target %q, previous %v", snPath, target, previous)
@@ -367,7 +458,13 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 		// check if the file has not changed before performing a fopen operation (more expensive, specially
 		// in network filesystems)
-		if previous != nil && !fileChanged(fi, previous, arch.ChangeIgnoreFlags) {
+		reread := previous != nil && arch.Reread != nil && arch.Reread(abstarget)
+		if reread {
+			debug.Log("%v must be read again", target)
+		}
+		// files are read again to encrypt them with a data key
+		needsDataKey := arch.DataKeys != nil && previous != nil && previous.DataKey == nil
+		if previous != nil && !reread && !needsDataKey && !fileChanged(fi, previous, arch.ChangeIgnoreFlags) {
 			if arch.allBlobsPresent(previous) {
 				debug.Log("%v hasn't changed, using old list of blobs", target)
 				arch.CompleteItem(snPath, previous, previous, ItemStats{}, time.Since(start))
@@ -379,6 +476,10 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 				// copy list of blobs
 				node.Content = previous.Content
+				node.ContentEncoding = previous.ContentEncoding
+				node.Bundle = previous.Bundle
+				node.Holes = previous.Holes
+				node.DataKey = previous.DataKey
 
 				fn = newFutureNodeWithResult(futureNodeResult{
 					snPath: snPath,
@@ -397,6 +498,35 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 			}
 		}
 
+		if arch.LookupContent != nil && arch.DataKeys == nil {
+			source, err := arch.LookupContent(ctx, target, fi)
+			if err != nil {
+				return FutureNode{}, false, err
+			}
+			if source != nil {
+				debug.Log("%v is already stored in the repository, using its list of blobs", target)
+				node, err := arch.nodeFromFileInfo(snPath, target, fi)
+				if err != nil {
+					return FutureNode{}, false, err
+				}
+
+				node.Content = source.Content
+				node.ContentEncoding = source.ContentEncoding
+				node.Bundle = source.Bundle
+				node.Holes = source.Holes
+				node.DataKey = source.DataKey
+				arch.CompleteItem(snPath, previous, node, ItemStats{}, time.Since(start))
+				arch.CompleteBlob(node.Size)
+
+				fn = newFutureNodeWithResult(futureNodeResult{
+					snPath: snPath,
+					target: target,
+					node:   node,
+				})
+				return fn, false, nil
+			}
+		}
+
 		// reopen file and do an fstat() on the open file to check it is still
 		// a file (and has not been exchanged for e.g. a symlink)
 		file, err := arch.FS.OpenFile(target, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
@@ -431,8 +561,32 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 			return FutureNode{}, true, nil
 		}
 
+		if bundler != nil && arch.DataKeys == nil && fi.Size() > 0 && uint64(fi.Size()) < arch.BundleThreshold {
+			var handled bool
+			fn, handled, err = bundler.add(ctx, snPath, target, file, fi, previous, start)
+			if err != nil {
+				err = arch.error(abstarget, err)
+				if err != nil {
+					return FutureNode{}, false, err
+				}
+				return FutureNode{}, true, nil
+			}
+			if handled {
+				break
+			}
+		}
+
+		var dataKey *restic.ID
+		if arch.DataKeys != nil {
+			dataKey, err = arch.dataKey(ctx, previous)
+			if err != nil {
+				_ = file.Close()
+				return FutureNode{}, false, err
+			}
+		}
+
 		// Save will close the file, we don't need to do that
-		fn = arch.fileSaver.Save(ctx, snPath, target, file, fi, func() {
+		fn = arch.fileSaver.SaveWithDataKey(ctx, snPath, target, file, fi, dataKey, func() {
 			arch.StartFile(snPath)
 		}, func() {
 			arch.CompleteItem(snPath, nil, nil, ItemStats{}, 0)
@@ -444,6 +598,18 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		debug.Log("  %v dir", target)
 
 		snItem := snPath + "/"
+		if arch.unchangedDir(abstarget, previous) {
+			debug.Log("%v hasn't changed, using old tree", target)
+			node := *previous
+			arch.CompleteItem(snItem, previous, &node, ItemStats{}, time.Since(start))
+			fn = newFutureNodeWithResult(futureNodeResult{
+				snPath: snPath,
+				target: target,
+				node:   &node,
+			})
+			return fn, false, nil
+		}
+
 		oldSubtree, err := arch.loadSubtree(ctx, previous)
 		if err != nil {
 			err = arch.error(abstarget, err)
@@ -564,6 +730,11 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 	nodeNames := atree.NodeNames()
 	nodes := make([]FutureNode, 0, len(nodeNames))
 
+	var bundler *fileBundler
+	if arch.BundleThreshold > 0 {
+		bundler = newFileBundler(arch)
+	}
+
 	// iterate over the nodes of atree in lexicographic (=deterministic) order
 	for _, name := range nodeNames {
 		subatree := atree.Nodes[name]
@@ -575,7 +746,7 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 
 		// this is a leaf node
 		if subatree.Leaf() {
-			fn, excluded, err := arch.Save(ctx, join(snPath, name), subatree.Path, previous.Find(name))
+			fn, excluded, err := arch.save(ctx, join(snPath, name), subatree.Path, previous.Find(name), bundler)
 
 			if err != nil {
 				err = arch.error(subatree.Path, err)
@@ -618,6 +789,10 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 		nodes = append(nodes, fn)
 	}
 
+	if bundler != nil {
+		bundler.flush(ctx)
+	}
+
 	fn := arch.treeSaver.Save(ctx, snPath, atree.FileInfoPath, node, nodes, complete)
 	return fn, len(nodes), nil
 }
@@ -680,6 +855,52 @@ type SnapshotOptions struct {
 	Excludes       []string
 	Time           time.Time
 	ParentSnapshot *restic.Snapshot
+
+	// SourceShare is recorded in the snapshot as the origin of the data.
+	SourceShare string
+
+	// SigningKey is used to sign the snapshot, if set.
+	SigningKey ed25519.PrivateKey
+
+	// Description and Annotations are added to the snapshot.
+	Description string
+	Annotations map[string]string
+
+	// Invocation is recorded in the snapshot, if set.
+	Invocation *restic.SnapshotInvocation
+	// Summary is called once all data is saved, the result is recorded in
+	// the snapshot.
+	Summary func() *restic.SnapshotSummary
+}
+
+// newSnapshot returns the snapshot for the tree rootTreeID, which is not yet
+// saved.
+func (opts SnapshotOptions) newSnapshot(targets []string, rootTreeID restic.ID) (*restic.Snapshot, error) {
+	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	sn.Excludes = opts.Excludes
+	sn.SourceShare = opts.SourceShare
+	if opts.ParentSnapshot != nil {
+		sn.Parent = opts.ParentSnapshot.ID()
+	}
+	sn.Tree = &rootTreeID
+	sn.Description = opts.Description
+	sn.Annotations = opts.Annotations
+	sn.Invocation = opts.Invocation
+	if opts.Summary != nil {
+		sn.Summary = opts.Summary()
+	}
+
+	if opts.SigningKey != nil {
+		err = sn.Sign(opts.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sn, nil
 }
 
 // loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
@@ -713,6 +934,12 @@ func (arch *Archiver) runWorkers(ctx context.Context, wg *errgroup.Group) {
 		arch.Options.ReadConcurrency, arch.Options.SaveBlobConcurrency)
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
+	arch.fileSaver.UnpackLayers = arch.UnpackLayers
+	arch.fileSaver.UnpackLayersAllLevels = arch.UnpackLayersAllLevels
+	arch.fileSaver.CompleteFileHash = arch.CompleteFileHash
+	if arch.DataKeys != nil {
+		arch.fileSaver.SealDataBlob = arch.DataKeys.SealDataBlob
+	}
 
 	arch.treeSaver = NewTreeSaver(ctx, wg, arch.Options.SaveTreeConcurrency, arch.blobSaver.Save, arch.Error)
 }
@@ -791,17 +1018,11 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
-	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	sn, err := opts.newSnapshot(targets, rootTreeID)
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
-	sn.Excludes = opts.Excludes
-	if opts.ParentSnapshot != nil {
-		sn.Parent = opts.ParentSnapshot.ID()
-	}
-	sn.Tree = &rootTreeID
-
 	id, err := restic.SaveSnapshot(ctx, arch.Repo, sn)
 	if err != nil {
 		return nil, restic.ID{}, err
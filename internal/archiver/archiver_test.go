@@ -1708,6 +1708,75 @@ func TestArchiverParent(t *testing.T) {
 	}
 }
 
+func TestArchiverUnchangedDir(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo := prepareTempdirRepoSrc(t, TestDir{
+		"clean": TestDir{"file": TestFile{Content: "old clean"}},
+		"dirty": TestDir{"file": TestFile{Content: "old dirty"}},
+	})
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	firstSnapshot, _, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	restictest.OK(t, err)
+
+	// the changes in the directory reported as unchanged are not noticed
+	for _, dir := range []string{"clean", "dirty"} {
+		restictest.OK(t, os.WriteFile(filepath.Join(tempdir, dir, "file"), []byte("new "+dir), 0644))
+	}
+	arch.UnchangedDir = func(target string) bool {
+		return target == filepath.Join(tempdir, "clean")
+	}
+	_, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: firstSnapshot})
+	restictest.OK(t, err)
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"clean": TestDir{"file": TestFile{Content: "old clean"}},
+		"dirty": TestDir{"file": TestFile{Content: "new dirty"}},
+	})
+	checker.TestCheckRepo(t, repo)
+}
+
+func TestArchiverReread(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo := prepareTempdirRepoSrc(t, TestDir{
+		"keep":   TestFile{Content: "old keep"},
+		"reread": TestFile{Content: "old data"},
+	})
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.ChangeIgnoreFlags = ChangeIgnoreCtime
+	firstSnapshot, _, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	restictest.OK(t, err)
+
+	// modify the files without changing their size and modification time
+	for name, content := range map[string]string{"keep": "new keep", "reread": "new data"} {
+		filename := filepath.Join(tempdir, name)
+		fi, err := os.Lstat(filename)
+		restictest.OK(t, err)
+		restictest.OK(t, os.WriteFile(filename, []byte(content), 0644))
+		restictest.OK(t, os.Chtimes(filename, fi.ModTime(), fi.ModTime()))
+	}
+
+	arch.Reread = func(target string) bool {
+		return target == filepath.Join(tempdir, "reread")
+	}
+	_, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: firstSnapshot})
+	restictest.OK(t, err)
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"keep":   TestFile{Content: "old keep"},
+		"reread": TestFile{Content: "new data"},
+	})
+}
+
 func TestArchiverErrorReporting(t *testing.T) {
 	ignoreErrorForBasename := func(basename string) ErrorFunc {
 		return func(item string, err error) error {
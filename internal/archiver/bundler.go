@@ -0,0 +1,139 @@
+package archiver
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// bundleSize is the size above which a bundle is saved. Restoring a bundled
+// file requires loading the whole bundle, so bundles are kept as small as the
+// smallest chunk of a large file.
+const bundleSize = chunker.MinSize
+
+// MaxBundleThreshold is the largest supported value for
+// Archiver.BundleThreshold.
+const MaxBundleThreshold = bundleSize / 4
+
+// fileBundler collects the contents of small files of a single directory and
+// saves them as one data blob. This avoids an index entry for each of the
+// files, see Archiver.BundleThreshold.
+type fileBundler struct {
+	arch  *Archiver
+	data  []byte
+	files []bundledFile
+}
+
+type bundledFile struct {
+	ch       chan<- futureNodeResult
+	snPath   string
+	target   string
+	node     *restic.Node
+	previous *restic.Node
+	start    time.Time
+}
+
+func newFileBundler(arch *Archiver) *fileBundler {
+	return &fileBundler{arch: arch}
+}
+
+// add reads the file f and appends its contents to the current bundle. If the
+// file has grown to at least the bundle threshold since it was inspected, the
+// file is rewound and handled is false. The caller must then save the file as
+// usual. Otherwise f is closed.
+func (b *fileBundler) add(ctx context.Context, snPath, target string, f fs.File, fi os.FileInfo, previous *restic.Node, start time.Time) (fn FutureNode, handled bool, err error) {
+	b.arch.StartFile(snPath)
+
+	data, err := io.ReadAll(io.LimitReader(f, int64(b.arch.BundleThreshold)))
+	if err != nil {
+		_ = f.Close()
+		return FutureNode{}, false, err
+	}
+	if uint64(len(data)) >= b.arch.BundleThreshold {
+		debug.Log("%v has grown to at least %d bytes, not bundling it", target, len(data))
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			_ = f.Close()
+			return FutureNode{}, false, err
+		}
+		return FutureNode{}, false, nil
+	}
+
+	node, err := b.arch.nodeFromFileInfo(snPath, f.Name(), fi)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return FutureNode{}, false, err
+	}
+
+	node.Content = restic.IDs{{}}
+	node.Size = uint64(len(data))
+	node.Bundle = &restic.ContentBundle{Offset: uint64(len(b.data))}
+	b.data = append(b.data, data...)
+
+	fn, ch := newFutureNode()
+	b.files = append(b.files, bundledFile{
+		ch:       ch,
+		snPath:   snPath,
+		target:   target,
+		node:     node,
+		previous: previous,
+		start:    start,
+	})
+	b.arch.CompleteItem(snPath, nil, nil, ItemStats{}, 0)
+	b.arch.CompleteBlob(node.Size)
+
+	if len(b.data) >= bundleSize {
+		b.flush(ctx)
+	}
+	return fn, true, nil
+}
+
+// flush saves the current bundle. Each future node completes once the bundle
+// has been saved.
+func (b *fileBundler) flush(ctx context.Context) {
+	if len(b.files) == 0 {
+		return
+	}
+
+	files := b.files
+	data := b.data
+	b.files = nil
+	b.data = nil
+
+	if len(files) == 1 {
+		// the blob contains only this file, store it like any other small file
+		files[0].node.Bundle = nil
+	}
+
+	debug.Log("saving bundle of %d files with %d bytes", len(files), len(data))
+	b.arch.blobSaver.Save(ctx, restic.DataBlob, &Buffer{Data: data}, func(res SaveBlobResponse) {
+		for i, file := range files {
+			file.node.Content[0] = res.id
+
+			// the bundle is accounted for by its first file
+			var stats ItemStats
+			if i == 0 && !res.known {
+				stats.DataBlobs++
+				stats.DataSize += uint64(res.length)
+				stats.DataSizeInRepo += uint64(res.sizeInRepo)
+			}
+
+			b.arch.CompleteItem(file.snPath, file.previous, file.node, stats, time.Since(file.start))
+			file.ch <- futureNodeResult{
+				snPath: file.snPath,
+				target: file.target,
+				node:   file.node,
+				stats:  stats,
+			}
+			close(file.ch)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+func bundleTestDir(seed int) TestDir {
+	small := TestDir{
+		"empty": TestFile{},
+		"large": TestFile{Content: string(restictest.Random(seed, 8192))},
+	}
+	// enough small files for more than one bundle
+	for i := 0; i < 400; i++ {
+		small[fmt.Sprintf("file%03d", i)] = TestFile{Content: string(restictest.Random(seed+i, 2000))}
+	}
+
+	return TestDir{
+		"small": small,
+		"single": TestDir{
+			"file": TestFile{Content: "foo"},
+		},
+	}
+}
+
+func bundleSnapshot(t testing.TB, repo restic.Repository, parent *restic.Snapshot) (*restic.Snapshot, restic.ID) {
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.BundleThreshold = 4096
+
+	sn, id, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sn, id
+}
+
+func loadSubtree(t testing.TB, repo restic.Repository, id restic.ID, dir string) *restic.Tree {
+	tree, err := restic.LoadTree(context.TODO(), repo, id)
+	restictest.OK(t, err)
+	node := tree.Find(dir)
+	restictest.Assert(t, node != nil && node.Subtree != nil, "directory %v not found", dir)
+	tree, err = restic.LoadTree(context.TODO(), repo, *node.Subtree)
+	restictest.OK(t, err)
+	return tree
+}
+
+func TestArchiverBundleSmallFiles(t *testing.T) {
+	src := bundleTestDir(23)
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	sn, id := bundleSnapshot(t, repo, nil)
+	TestEnsureSnapshot(t, repo, id, src)
+	checker.TestCheckRepo(t, repo)
+
+	bundles := restic.NewIDSet()
+	for _, node := range loadSubtree(t, repo, *sn.Tree, "small").Nodes {
+		switch node.Name {
+		case "empty":
+			restictest.Assert(t, node.Bundle == nil && len(node.Content) == 0, "empty file was bundled")
+		case "large":
+			restictest.Assert(t, node.Bundle == nil, "large file was bundled")
+		default:
+			restictest.Assert(t, node.Bundle != nil, "small file %v was not bundled", node.Name)
+			restictest.Equals(t, 1, len(node.Content))
+			bundles.Insert(node.Content[0])
+		}
+	}
+	restictest.Equals(t, 2, len(bundles))
+
+	// a bundle with a single file is stored as a regular blob
+	node := loadSubtree(t, repo, *sn.Tree, "single").Find("file")
+	restictest.Assert(t, node.Bundle == nil, "single file was bundled")
+	restictest.Equals(t, restic.IDs{restic.Hash([]byte("foo"))}, node.Content)
+}
+
+func TestArchiverBundleIncremental(t *testing.T) {
+	src := bundleTestDir(42)
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	parent, _ := bundleSnapshot(t, repo, nil)
+	old := loadSubtree(t, repo, *parent.Tree, "small")
+
+	// make sure the modification time changes
+	time.Sleep(10 * time.Millisecond)
+	save(t, filepath.Join(tempdir, "small", "file007"), []byte("modified"))
+	src["small"].(TestDir)["file007"] = TestFile{Content: "modified"}
+
+	sn, id := bundleSnapshot(t, repo, parent)
+	TestEnsureSnapshot(t, repo, id, src)
+	checker.TestCheckRepo(t, repo)
+
+	for _, node := range loadSubtree(t, repo, *sn.Tree, "small").Nodes {
+		oldNode := old.Find(node.Name)
+		if node.Name == "file007" {
+			// the modified file is the only file of its bundle
+			restictest.Assert(t, node.Bundle == nil, "modified file was bundled")
+			continue
+		}
+		restictest.Equals(t, oldNode.Content, node.Content)
+		restictest.Assert(t, oldNode.Bundle.Equal(node.Bundle), "bundle of unmodified file %v changed", node.Name)
+	}
+}
@@ -0,0 +1,217 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// FileHashCache remembers the SHA-256 hash of the contents of files saved by
+// previous backups together with their list of blobs. Files whose
+// modification time changed, but whose contents are still the same, are then
+// only read and hashed instead of chunked again. This is common for files
+// written by build systems or some sync tools.
+//
+// A file is only looked up if its path, size, device and inode still match
+// the cached entry. The hash of the contents is always verified before the
+// cached list of blobs is reused.
+type FileHashCache struct {
+	repo restic.Repository
+
+	m       sync.Mutex
+	entries map[string]*fileHashEntry
+	updated map[string]*fileHashEntry
+}
+
+type fileHashEntry struct {
+	Path            string                  `json:"path"`
+	Size            uint64                  `json:"size"`
+	DeviceID        uint64                  `json:"device_id"`
+	Inode           uint64                  `json:"inode"`
+	Hash            restic.ID               `json:"hash"`
+	Content         restic.IDs              `json:"content"`
+	ContentEncoding *restic.ContentEncoding `json:"content_encoding,omitempty"`
+}
+
+func (e *fileHashEntry) matches(fi os.FileInfo) bool {
+	extFI := fs.ExtendedStat(fi)
+	return uint64(fi.Size()) == e.Size && extFI.DeviceID == e.DeviceID && extFI.Inode == e.Inode
+}
+
+// NewFileHashCache returns an empty cache for backups to repo.
+func NewFileHashCache(repo restic.Repository) *FileHashCache {
+	return &FileHashCache{
+		repo:    repo,
+		entries: make(map[string]*fileHashEntry),
+		updated: make(map[string]*fileHashEntry),
+	}
+}
+
+// Load reads the entries written by Write from rd.
+func (c *FileHashCache) Load(rd io.Reader) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	dec := json.NewDecoder(rd)
+	for {
+		e := &fileHashEntry{}
+		err := dec.Decode(e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "decoding file hash cache")
+		}
+		c.entries[e.Path] = e
+	}
+	debug.Log("loaded %d entries", len(c.entries))
+	return nil
+}
+
+// Write writes all entries to wr. Entries of files which were not saved or
+// looked up since Load are only kept if the file still exists.
+func (c *FileHashCache) Write(wr io.Writer) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	bw := bufio.NewWriter(wr)
+	enc := json.NewEncoder(bw)
+	for path, e := range c.entries {
+		if _, ok := c.updated[path]; ok {
+			continue
+		}
+		if _, err := fs.Lstat(path); err != nil {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, e := range c.updated {
+		if err := enc.Encode(e); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(bw.Flush())
+}
+
+// Add records that the file target with the file info fi was saved as node
+// and that the SHA-256 hash of the file contents is hash. Files encrypted with
+// a data key are not recorded, as their blobs cannot be used without it.
+func (c *FileHashCache) Add(target string, fi os.FileInfo, node *restic.Node, hash restic.ID) {
+	if node.DataKey != nil {
+		return
+	}
+	path, err := filepath.Abs(target)
+	if err != nil {
+		return
+	}
+	extFI := fs.ExtendedStat(fi)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.updated[path] = &fileHashEntry{
+		Path:            path,
+		Size:            uint64(fi.Size()),
+		DeviceID:        extFI.DeviceID,
+		Inode:           extFI.Inode,
+		Hash:            hash,
+		Content:         append(restic.IDs(nil), node.Content...),
+		ContentEncoding: node.ContentEncoding,
+	}
+}
+
+// Lookup returns a node containing the list of blobs of the file target if
+// its contents match the cached entry, otherwise nil is returned. It can be
+// used as Archiver.LookupContent.
+func (c *FileHashCache) Lookup(ctx context.Context, target string, fi os.FileInfo) (*restic.Node, error) {
+	path, err := filepath.Abs(target)
+	if err != nil {
+		return nil, nil
+	}
+
+	c.m.Lock()
+	e, ok := c.updated[path]
+	if !ok {
+		e, ok = c.entries[path]
+	}
+	c.m.Unlock()
+	if !ok || !e.matches(fi) {
+		return nil, nil
+	}
+
+	for _, id := range e.Content {
+		if !c.repo.Index().Has(restic.BlobHandle{ID: id, Type: restic.DataBlob}) {
+			debug.Log("%v: blob %v is missing", path, id)
+			return nil, nil
+		}
+	}
+
+	hash, ok, err := hashFile(ctx, target, e)
+	if err != nil || !ok {
+		// the file is read again while saving it, which reports any error
+		debug.Log("%v: hashing failed or file changed: %v", path, err)
+		return nil, nil
+	}
+	if hash != e.Hash {
+		debug.Log("%v: contents changed", path)
+		return nil, nil
+	}
+
+	debug.Log("%v: contents unchanged", path)
+	c.m.Lock()
+	c.updated[path] = e
+	c.m.Unlock()
+
+	return &restic.Node{
+		Content:         e.Content,
+		ContentEncoding: e.ContentEncoding,
+	}, nil
+}
+
+// hashFile returns the SHA-256 hash of the contents of target. ok is false if
+// the file no longer matches e.
+func hashFile(ctx context.Context, target string, e *fileHashEntry) (hash restic.ID, ok bool, err error) {
+	f, err := fs.OpenFile(target, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+	if err != nil {
+		return restic.ID{}, false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return restic.ID{}, false, err
+	}
+	if !fs.IsRegularFile(fi) || !e.matches(fi) {
+		return restic.ID{}, false, nil
+	}
+
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	for {
+		if ctx.Err() != nil {
+			return restic.ID{}, false, ctx.Err()
+		}
+		n, err := f.Read(buf)
+		h.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restic.ID{}, false, err
+		}
+	}
+	return restic.IDFromHash(h.Sum(nil)), true, nil
+}
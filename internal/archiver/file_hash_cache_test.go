@@ -0,0 +1,69 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+func TestFileHashCache(t *testing.T) {
+	repo := repository.TestRepository(t)
+	tempdir := restictest.TempDir(t)
+	content := restictest.Random(23, 3*1024*1024)
+	TestCreateFiles(t, tempdir, TestDir{
+		"file":  TestFile{Content: string(content)},
+		"other": TestFile{Content: "foo"},
+	})
+	filename := filepath.Join(tempdir, "file")
+
+	c := NewFileHashCache(repo)
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.CompleteFileHash = c.Add
+	back := restictest.Chdir(t, tempdir)
+	sn, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	back()
+	restictest.OK(t, err)
+	restictest.OK(t, repo.LoadIndex(context.TODO()))
+
+	var buf bytes.Buffer
+	restictest.OK(t, c.Write(&buf))
+
+	// only a changed modification time still matches the cached hash
+	restictest.OK(t, os.Chtimes(filename, time.Now(), time.Now().Add(time.Hour)))
+	c = NewFileHashCache(repo)
+	restictest.OK(t, c.Load(&buf))
+	fi, err := os.Lstat(filename)
+	restictest.OK(t, err)
+	node, err := c.Lookup(context.TODO(), filename, fi)
+	restictest.OK(t, err)
+	restictest.Assert(t, node != nil, "unchanged file not found in the cache")
+
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	restictest.OK(t, err)
+	restictest.Equals(t, tree.Find("file").Content, node.Content)
+
+	// same size, but different contents
+	content[0] ^= 1
+	restictest.OK(t, os.WriteFile(filename, content, 0644))
+	fi, err = os.Lstat(filename)
+	restictest.OK(t, err)
+	node, err = c.Lookup(context.TODO(), filename, fi)
+	restictest.OK(t, err)
+	restictest.Assert(t, node == nil, "modified file found in the cache")
+
+	// entries of removed files are dropped
+	restictest.OK(t, os.Remove(filepath.Join(tempdir, "other")))
+	buf.Reset()
+	restictest.OK(t, c.Write(&buf))
+	c = NewFileHashCache(repo)
+	restictest.OK(t, c.Load(&buf))
+	restictest.Equals(t, 1, len(c.entries))
+}
@@ -2,6 +2,8 @@ package archiver
 
 import (
 	"context"
+	"crypto/sha256"
+	"hash"
 	"io"
 	"os"
 	"sync"
@@ -10,6 +12,7 @@ import (
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/layer"
 	"github.com/restic/restic/internal/restic"
 	"golang.org/x/sync/errgroup"
 )
@@ -28,7 +31,22 @@ type FileSaver struct {
 
 	CompleteBlob func(bytes uint64)
 
+	// UnpackLayers configures whether gzip compressed tar files are stored
+	// decompressed, see package layer.
+	UnpackLayers bool
+	// UnpackLayersAllLevels tries all compression levels to reproduce a
+	// layer instead of only the common ones.
+	UnpackLayersAllLevels bool
+
 	NodeFromFileInfo func(snPath, filename string, fi os.FileInfo) (*restic.Node, error)
+
+	// CompleteFileHash is called with the SHA-256 hash of the contents of
+	// each successfully saved file. The hash is only computed if it is set.
+	CompleteFileHash func(target string, fi os.FileInfo, node *restic.Node, hash restic.ID)
+
+	// SealDataBlob encrypts the contents of a blob with a data key, it must
+	// be set to save files with a data key.
+	SealDataBlob func(ctx context.Context, key restic.ID, data []byte) ([]byte, error)
 }
 
 // NewFileSaver returns a new file saver. A worker pool with fileWorkers is
@@ -71,13 +89,20 @@ type CompleteFunc func(*restic.Node, ItemStats)
 // successfully. complete is always called. If completeReading is called, then
 // this will always happen before calling complete.
 func (s *FileSaver) Save(ctx context.Context, snPath string, target string, file fs.File, fi os.FileInfo, start func(), completeReading func(), complete CompleteFunc) FutureNode {
+	return s.SaveWithDataKey(ctx, snPath, target, file, fi, nil, start, completeReading, complete)
+}
+
+// SaveWithDataKey stores the file f like Save. If dataKey is not nil, the
+// contents of the file are encrypted with the data key before saving them.
+func (s *FileSaver) SaveWithDataKey(ctx context.Context, snPath string, target string, file fs.File, fi os.FileInfo, dataKey *restic.ID, start func(), completeReading func(), complete CompleteFunc) FutureNode {
 	fn, ch := newFutureNode()
 	job := saveFileJob{
-		snPath: snPath,
-		target: target,
-		file:   file,
-		fi:     fi,
-		ch:     ch,
+		snPath:  snPath,
+		target:  target,
+		file:    file,
+		fi:      fi,
+		dataKey: dataKey,
+		ch:      ch,
 
 		start:           start,
 		completeReading: completeReading,
@@ -96,11 +121,12 @@ func (s *FileSaver) Save(ctx context.Context, snPath string, target string, file
 }
 
 type saveFileJob struct {
-	snPath string
-	target string
-	file   fs.File
-	fi     os.FileInfo
-	ch     chan<- futureNodeResult
+	snPath  string
+	target  string
+	file    fs.File
+	fi      os.FileInfo
+	dataKey *restic.ID
+	ch      chan<- futureNodeResult
 
 	start           func()
 	completeReading func()
@@ -108,7 +134,7 @@ type saveFileJob struct {
 }
 
 // saveFile stores the file f in the repo, then closes it.
-func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPath string, target string, f fs.File, fi os.FileInfo, start func(), finishReading func(), finish func(res futureNodeResult)) {
+func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPath string, target string, f fs.File, fi os.FileInfo, dataKey *restic.ID, start func(), finishReading func(), finish func(res futureNodeResult)) {
 	start()
 
 	fnr := futureNodeResult{
@@ -118,6 +144,16 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 	var lock sync.Mutex
 	remaining := 0
 	isCompleted := false
+	// blobs before contentStart belong to a discarded attempt to store an
+	// encoded file
+	contentStart := 0
+
+	var src io.Reader = f
+	var fileHash hash.Hash
+	if s.CompleteFileHash != nil {
+		fileHash = sha256.New()
+		src = io.TeeReader(f, fileHash)
+	}
 
 	completeBlob := func() {
 		lock.Lock()
@@ -128,12 +164,16 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 			if isCompleted {
 				panic("completed twice")
 			}
+			fnr.node.Content = fnr.node.Content[contentStart:]
 			for _, id := range fnr.node.Content {
 				if id.IsNull() {
 					panic("completed file with null ID")
 				}
 			}
 			isCompleted = true
+			if s.CompleteFileHash != nil {
+				s.CompleteFileHash(target, fi, fnr.node, restic.IDFromHash(fileHash.Sum(nil)))
+			}
 			finish(fnr)
 		}
 	}
@@ -168,22 +208,95 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return
 	}
 
+	if fi.Size() > 0 {
+		node.Holes, err = findHoles(f, fi.Size())
+		if err != nil {
+			_ = f.Close()
+			completeError(err)
+			return
+		}
+	}
+
+	rd := &countingReader{rd: src}
+	var content io.Reader = rd
+	var holes []restic.FileHole
+	var layerHash hash.Hash
+	if s.UnpackLayers {
+		// used to check that the file still matches the detected layer
+		layerHash = sha256.New()
+		rd.rd = io.TeeReader(src, layerHash)
+
+		content, node.ContentEncoding, err = s.unpackLayer(f, rd)
+		if err != nil {
+			_ = f.Close()
+			completeError(err)
+			return
+		}
+		if node.ContentEncoding != nil {
+			// holes refer to the encoded file, which is restored sequentially
+			holes, node.Holes = node.Holes, nil
+		}
+	}
+
 	// reuse the chunker
-	chnker.Reset(f, s.pol)
+	chnker.Reset(content, s.pol)
 
 	node.Content = []restic.ID{}
+	node.DataKey = dataKey
 	node.Size = 0
+	var contentSize uint64
+	var reported uint64
 	var idx int
 	for {
 		buf := s.saveFilePool.Get()
 		chunk, err := chnker.Next(buf.Data)
 		if err == io.EOF {
 			buf.Release()
-			break
+
+			if node.ContentEncoding == nil {
+				break
+			}
+
+			// count the remaining bytes of the file after the compressed stream
+			_, err = io.Copy(io.Discard, rd)
+			if err != nil {
+				_ = f.Close()
+				completeError(err)
+				return
+			}
+			s.CompleteBlob(rd.n - reported)
+
+			if restic.IDFromHash(layerHash.Sum(nil)).Equal(*node.ContentEncoding.Hash) {
+				break
+			}
+
+			// the file was modified after it was checked, so it possibly
+			// cannot be reconstructed. Store it verbatim instead.
+			debug.Log("%v changed while storing the decoded layer, storing it verbatim", snPath)
+			_, err = f.Seek(0, io.SeekStart)
+			if err != nil {
+				_ = f.Close()
+				completeError(err)
+				return
+			}
+			if fileHash != nil {
+				fileHash.Reset()
+			}
+			node.ContentEncoding = nil
+			node.Holes = holes
+			rd = &countingReader{rd: src}
+			chnker.Reset(rd, s.pol)
+			contentSize = 0
+			reported = 0
+
+			lock.Lock()
+			contentStart = idx
+			lock.Unlock()
+			continue
 		}
 
 		buf.Data = chunk.Data
-		node.Size += uint64(chunk.Length)
+		contentSize += uint64(chunk.Length)
 
 		if err != nil {
 			_ = f.Close()
@@ -197,6 +310,19 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 			return
 		}
 
+		if dataKey != nil {
+			sealed, err := s.SealDataBlob(ctx, *dataKey, buf.Data)
+			if err != nil {
+				buf.Release()
+				_ = f.Close()
+				completeError(err)
+				return
+			}
+			// the sealed data does not fit into the buffers of the pool
+			buf.Release()
+			buf = &Buffer{Data: sealed}
+		}
+
 		// add a place to store the saveBlob result
 		pos := idx
 
@@ -226,7 +352,9 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 			return
 		}
 
-		s.CompleteBlob(uint64(len(chunk.Data)))
+		// report the progress in bytes of the file, not of the decoded content
+		s.CompleteBlob(rd.n - reported)
+		reported = rd.n
 	}
 
 	err = f.Close()
@@ -235,6 +363,12 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return
 	}
 
+	node.Size = contentSize
+	if node.ContentEncoding != nil {
+		node.Size = rd.n
+		node.ContentEncoding.Size = contentSize
+	}
+
 	fnr.node = node
 	lock.Lock()
 	// require one additional completeFuture() call to ensure that the future only completes
@@ -245,6 +379,46 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 	completeBlob()
 }
 
+// unpackLayer checks whether f is a compressed layer and returns a reader for
+// its decoded contents in that case. Otherwise rd is returned.
+func (s *FileSaver) unpackLayer(f fs.File, rd *countingReader) (io.Reader, *restic.ContentEncoding, error) {
+	levels := layer.DefaultLevels
+	if s.UnpackLayersAllLevels {
+		levels = layer.AllLevels
+	}
+	enc, err := layer.Detect(f, levels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+	if enc == nil {
+		return rd, nil, nil
+	}
+
+	debug.Log("storing decompressed layer, level %v", enc.Level)
+	zrd, err := layer.NewReader(rd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zrd, enc, nil
+}
+
+// countingReader counts the bytes read from rd.
+type countingReader struct {
+	rd io.Reader
+	n  uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.n += uint64(n)
+	return n, err
+}
+
 func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
 	chnker := chunker.New(nil, s.pol)
@@ -261,7 +435,7 @@ func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 			}
 		}
 
-		s.saveFile(ctx, chnker, job.snPath, job.target, job.file, job.fi, job.start, func() {
+		s.saveFile(ctx, chnker, job.snPath, job.target, job.file, job.fi, job.dataKey, job.start, func() {
 			if job.completeReading != nil {
 				job.completeReading()
 			}
@@ -274,3 +448,21 @@ func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 		})
 	}
 }
+
+// findHoles returns the holes of the sparse file f. Failing to detect holes
+// is not an error, the file is then restored without holes. An error is only
+// returned if f cannot be read from the start afterwards.
+func findHoles(f fs.File, size int64) ([]restic.FileHole, error) {
+	holes, err := fs.FindHoles(f, size)
+	if err != nil {
+		debug.Log("unable to detect holes in %v: %v", f.Name(), err)
+		_, err = f.Seek(0, io.SeekStart)
+		return nil, errors.WithStack(err)
+	}
+
+	var result []restic.FileHole
+	for _, hole := range holes {
+		result = append(result, restic.FileHole{Offset: uint64(hole.Offset), Length: uint64(hole.Length)})
+	}
+	return result, nil
+}
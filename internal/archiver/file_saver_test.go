@@ -1,8 +1,12 @@
 package archiver
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -100,3 +104,79 @@ func TestFileSaver(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func testLayer(t testing.TB, level int) []byte {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	data := bytes.Repeat([]byte("restic"), 10000)
+	test.OK(t, tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: int64(len(data))}))
+	_, err := tw.Write(data)
+	test.OK(t, err)
+	test.OK(t, tw.Close())
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	test.OK(t, err)
+	_, err = zw.Write(tarBuf.Bytes())
+	test.OK(t, err)
+	test.OK(t, zw.Close())
+	return buf.Bytes()
+}
+
+// changingFile replaces its contents when it is rewound after it has been
+// read completely.
+type changingFile struct {
+	fs.File
+	filename string
+	data     []byte
+	eof      bool
+}
+
+func (f *changingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if err == io.EOF {
+		f.eof = true
+	}
+	return n, err
+}
+
+func (f *changingFile) Seek(offset int64, whence int) (int64, error) {
+	if f.eof && f.data != nil {
+		if err := os.WriteFile(f.filename, f.data, 0600); err != nil {
+			return 0, err
+		}
+		f.data = nil
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func TestFileSaverLayerChanged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filename := filepath.Join(test.TempDir(t), "layer.tar.gz")
+	test.OK(t, os.WriteFile(filename, testLayer(t, gzip.DefaultCompression), 0600))
+	// the replacement is still a valid layer, but differs from the checked one
+	changed := testLayer(t, gzip.BestSpeed)
+
+	s, ctx, wg := startFileSaver(ctx, t)
+	s.UnpackLayers = true
+
+	f, err := fs.Local{}.Open(filename)
+	test.OK(t, err)
+	fi, err := f.Stat()
+	test.OK(t, err)
+
+	file := &changingFile{File: f, filename: filename, data: changed}
+	fn := s.Save(ctx, filename, filename, file, fi, func() {}, func() {}, func(*restic.Node, ItemStats) {})
+	fnr := fn.take(ctx)
+	test.OK(t, fnr.err)
+
+	// the file must be stored verbatim, as it cannot be reconstructed
+	test.Assert(t, fnr.node.ContentEncoding == nil, "changed layer was stored decoded")
+	test.Equals(t, uint64(len(changed)), fnr.node.Size)
+	test.Equals(t, restic.IDs{restic.Hash(changed)}, restic.IDs(fnr.node.Content))
+
+	s.TriggerShutdown()
+	test.OK(t, wg.Wait())
+}
@@ -0,0 +1,155 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// MountSource recognizes files in snapshots mounted via `restic mount`. For
+// these files the blobs stored in the mounted repository can be reused
+// instead of reading the files through FUSE and chunking them again.
+type MountSource struct {
+	repo restic.Repository
+	src  restic.Repository
+
+	getxattr func(path, name string) ([]byte, error)
+
+	m    sync.Mutex
+	dirs map[string]*mountDir
+}
+
+// mountDir describes a directory, repo is nil if the directory is not part of
+// a mounted snapshot.
+type mountDir struct {
+	repo  restic.Repository
+	nodes map[string]*restic.Node
+}
+
+// maxMountDirs limits the number of cached directories.
+const maxMountDirs = 256
+
+// NewMountSource returns a MountSource for a backup to repo. Blobs of files in
+// mounts of repo are reused, blobs of files in mounts of src are copied to
+// repo. src may be nil.
+func NewMountSource(repo, src restic.Repository) *MountSource {
+	return &MountSource{
+		repo:     repo,
+		src:      src,
+		getxattr: restic.Getxattr,
+		dirs:     make(map[string]*mountDir),
+	}
+}
+
+func (m *MountSource) dir(ctx context.Context, dir string) (*mountDir, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if md, ok := m.dirs[dir]; ok {
+		return md, nil
+	}
+
+	md, err := m.loadDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.dirs) >= maxMountDirs {
+		m.dirs = make(map[string]*mountDir)
+	}
+	m.dirs[dir] = md
+	return md, nil
+}
+
+func (m *MountSource) loadDir(ctx context.Context, dir string) (*mountDir, error) {
+	repoID, err := m.getxattr(dir, restic.MountRepositoryXattr)
+	if err != nil || len(repoID) == 0 {
+		return &mountDir{}, nil
+	}
+	treeID, err := m.getxattr(dir, restic.MountTreeXattr)
+	if err != nil || len(treeID) == 0 {
+		return &mountDir{}, nil
+	}
+
+	var repo restic.Repository
+	switch {
+	case string(repoID) == m.repo.Config().ID:
+		repo = m.repo
+	case m.src != nil && string(repoID) == m.src.Config().ID:
+		repo = m.src
+	default:
+		return nil, errors.Fatalf("%v is part of a snapshot of repository %s mounted by restic, "+
+			"pass the repository via --from-repo to copy its data or use --read-restic-mounts to read the files", dir, repoID)
+	}
+
+	id, err := restic.ParseID(string(treeID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid tree ID for %v", dir)
+	}
+
+	tree, err := restic.LoadTree(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &mountDir{repo: repo, nodes: make(map[string]*restic.Node)}
+	for _, node := range tree.Nodes {
+		// the mount shows the contents of nodes with these names instead
+		if node.Type == "dir" && node.Subtree != nil && (node.Name == "." || node.Name == "/") {
+			subtree, err := restic.LoadTree(ctx, repo, *node.Subtree)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range subtree.Nodes {
+				md.nodes[filepath.Base(n.Name)] = n
+			}
+			continue
+		}
+		md.nodes[filepath.Base(node.Name)] = node
+	}
+
+	debug.Log("%v is a mount of tree %v in repository %s", dir, id, repoID)
+	return md, nil
+}
+
+// Lookup returns the node of the file target if it is part of a mounted
+// snapshot. All data blobs referenced by the returned node are available in
+// the repository the backup is written to. If the file is not part of a
+// mounted snapshot, nil is returned.
+func (m *MountSource) Lookup(ctx context.Context, target string, fi os.FileInfo) (*restic.Node, error) {
+	md, err := m.dir(ctx, filepath.Dir(target))
+	if err != nil || md.repo == nil {
+		return nil, err
+	}
+
+	node, ok := md.nodes[filepath.Base(target)]
+	if !ok || node.Type != "file" || node.Size != uint64(fi.Size()) {
+		return nil, nil
+	}
+
+	for _, id := range node.Content {
+		if m.repo.Index().Has(restic.BlobHandle{ID: id, Type: restic.DataBlob}) {
+			continue
+		}
+		if md.repo == m.repo {
+			debug.Log("blob %v of %v is missing, reading the file", id, target)
+			return nil, nil
+		}
+
+		buf, err := md.repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+		if err != nil {
+			return nil, err
+		}
+		_, _, _, err = m.repo.SaveBlob(ctx, restic.DataBlob, buf, id, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
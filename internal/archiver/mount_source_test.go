@@ -0,0 +1,132 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+// prepareMount saves src to repo and creates a directory which pretends to be
+// a mount of the snapshot. The files in that directory have the same names
+// and sizes as in src, but different content.
+func prepareMount(t *testing.T, repo restic.Repository, src TestDir) (string, func(path, name string) ([]byte, error)) {
+	tempdir := restictest.TempDir(t)
+	TestCreateFiles(t, tempdir, src)
+
+	back := restictest.Chdir(t, tempdir)
+	sn := TestSnapshot(t, repo, ".", nil)
+	back()
+
+	if err := repo.LoadIndex(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	mountdir := restictest.TempDir(t)
+	fake := TestDir{}
+	for name, item := range src {
+		if file, ok := item.(TestFile); ok {
+			fake[name] = TestFile{Content: string(restictest.Random(42, len(file.Content)))}
+		}
+	}
+	TestCreateFiles(t, mountdir, fake)
+
+	getxattr := func(path, name string) ([]byte, error) {
+		// the archiver passes paths relative to the current directory
+		abs, err := filepath.Abs(path)
+		if err != nil || abs != mountdir {
+			return nil, err
+		}
+		switch name {
+		case restic.MountRepositoryXattr:
+			return []byte(repo.Config().ID), nil
+		case restic.MountTreeXattr:
+			return []byte(sn.Tree.String()), nil
+		}
+		return nil, nil
+	}
+
+	return mountdir, getxattr
+}
+
+func snapshotMount(t *testing.T, repo restic.Repository, dir string, ms *MountSource) (restic.ID, error) {
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.LookupContent = ms.Lookup
+
+	back := restictest.Chdir(t, dir)
+	defer back()
+	_, id, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	return id, err
+}
+
+func TestMountSourceSameRepository(t *testing.T) {
+	src := TestDir{
+		"file":  TestFile{Content: string(restictest.Random(23, 3*1024*1024))},
+		"empty": TestFile{Content: ""},
+	}
+
+	repo := repository.TestRepository(t)
+	mountdir, getxattr := prepareMount(t, repo, src)
+
+	ms := NewMountSource(repo, nil)
+	ms.getxattr = getxattr
+
+	id, err := snapshotMount(t, repo, mountdir, ms)
+	restictest.OK(t, err)
+
+	// the files are not read, thus the snapshot contains the original content
+	TestEnsureSnapshot(t, repo, id, src)
+}
+
+func TestMountSourceChangedSize(t *testing.T) {
+	repo := repository.TestRepository(t)
+	mountdir, getxattr := prepareMount(t, repo, TestDir{
+		"file": TestFile{Content: "foo"},
+	})
+
+	restictest.OK(t, os.WriteFile(filepath.Join(mountdir, "file"), []byte("foobar"), 0644))
+
+	ms := NewMountSource(repo, nil)
+	ms.getxattr = getxattr
+
+	id, err := snapshotMount(t, repo, mountdir, ms)
+	restictest.OK(t, err)
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"file": TestFile{Content: "foobar"},
+	})
+}
+
+func TestMountSourceOtherRepository(t *testing.T) {
+	src := TestDir{
+		"file": TestFile{Content: string(restictest.Random(23, 2*1024*1024))},
+	}
+
+	srcRepo := repository.TestRepository(t)
+	mountdir, getxattr := prepareMount(t, srcRepo, src)
+
+	repo := repository.TestRepository(t)
+
+	ms := NewMountSource(repo, nil)
+	ms.getxattr = getxattr
+	_, err := snapshotMount(t, repo, mountdir, ms)
+	if err == nil || !errors.IsFatal(err) {
+		t.Fatalf("expected fatal error for unknown repository, got %v", err)
+	}
+
+	// the failed backup leaves the pack uploader of repo running
+	repo = repository.TestRepository(t)
+	ms = NewMountSource(repo, srcRepo)
+	ms.getxattr = getxattr
+	id, err := snapshotMount(t, repo, mountdir, ms)
+	restictest.OK(t, err)
+
+	TestEnsureSnapshot(t, repo, id, src)
+}
@@ -0,0 +1,374 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// tarItem is a node read from a tar archive. Directories also hold their
+// children, as the items of a directory can be spread across the archive.
+type tarItem struct {
+	node     *restic.Node
+	children map[string]*tarItem
+}
+
+func newTarDir(node *restic.Node) *tarItem {
+	return &tarItem{node: node, children: make(map[string]*tarItem)}
+}
+
+// SnapshotTar saves the contents of the tar archive read from rd as a
+// snapshot of the directory target. Each item in the archive is stored as an
+// individual node with the metadata from its tar header. Directories which are
+// not contained in the archive, including target itself, are created with
+// default permissions and the time of the snapshot.
+func (arch *Archiver) SnapshotTar(ctx context.Context, rd io.Reader, target string, opts SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
+	target = path.Join("/", target)
+	root := newTarDir(implicitTarDir("", opts.Time))
+
+	var rootTreeID restic.ID
+
+	wgUp, wgUpCtx := errgroup.WithContext(ctx)
+	arch.Repo.StartPackUploader(wgUpCtx, wgUp)
+
+	wgUp.Go(func() error {
+		wg, wgCtx := errgroup.WithContext(wgUpCtx)
+		start := time.Now()
+
+		wg.Go(func() error {
+			arch.runWorkers(wgCtx, wg)
+			arch.fileSaver.NodeFromFileInfo = func(snPath, filename string, fi os.FileInfo) (*restic.Node, error) {
+				hdr, ok := fi.Sys().(*tar.Header)
+				if !ok {
+					return nil, errors.Errorf("%v is not contained in a tar archive", snPath)
+				}
+				return arch.nodeFromTarHeader(snPath, hdr)
+			}
+
+			debug.Log("starting snapshot of tar archive")
+			tr := tar.NewReader(rd)
+			items := 0
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return errors.Wrap(err, "reading tar archive")
+				}
+
+				saved, err := arch.saveTarItem(wgCtx, root, target, hdr, tr, opts.Time)
+				if err != nil {
+					return err
+				}
+				if saved {
+					items++
+				}
+			}
+			if wgCtx.Err() != nil {
+				return wgCtx.Err()
+			}
+			if items == 0 {
+				return errors.New("snapshot is empty")
+			}
+
+			var err error
+			rootTreeID, err = arch.saveTarDir(wgCtx, "/", root, start)
+			if err != nil {
+				return err
+			}
+			arch.stopWorkers()
+			return nil
+		})
+
+		err := wg.Wait()
+		if err != nil {
+			debug.Log("error while saving tar archive: %v", err)
+			return err
+		}
+
+		return arch.Repo.Flush(ctx)
+	})
+	err := wgUp.Wait()
+	if err != nil {
+		return nil, restic.ID{}, err
+	}
+
+	sn, err := opts.newSnapshot([]string{target}, rootTreeID)
+	if err != nil {
+		return nil, restic.ID{}, err
+	}
+
+	id, err := restic.SaveSnapshot(ctx, arch.Repo, sn)
+	if err != nil {
+		return nil, restic.ID{}, err
+	}
+
+	return sn, id, nil
+}
+
+// saveTarItem adds the item described by hdr to the tree below root. The
+// contents of regular files are read from rd and saved immediately. It
+// returns whether the item was stored.
+func (arch *Archiver) saveTarItem(ctx context.Context, root *tarItem, target string, hdr *tar.Header, rd io.Reader, defaultTime time.Time) (bool, error) {
+	if hdr.Typeflag == tar.TypeXGlobalHeader {
+		return false, nil
+	}
+
+	// cleaning the absolute path resolves any ".." components within target
+	snPath := path.Join(target, path.Clean("/"+hdr.Name))
+	if snPath == "/" {
+		// the root directory is no node
+		return false, nil
+	}
+	if !arch.SelectByName(snPath) || !arch.Select(snPath, hdr.FileInfo()) {
+		debug.Log("%v is excluded", snPath)
+		return false, nil
+	}
+
+	parent, err := tarParent(root, snPath, defaultTime)
+	if err != nil {
+		return false, arch.error(snPath, err)
+	}
+
+	node, err := arch.nodeFromTarHeader(snPath, hdr)
+	if err != nil {
+		return false, arch.error(snPath, err)
+	}
+
+	switch {
+	case node.Type == "dir":
+		if item, ok := parent.children[node.Name]; ok && item.children != nil {
+			// keep the items which precede the directory in the archive
+			item.node = node
+			return true, nil
+		}
+		parent.children[node.Name] = newTarDir(node)
+		return true, nil
+
+	case hdr.Typeflag == tar.TypeLink:
+		// hard links are stored as separate files with the same content
+		linkPath := path.Join(target, path.Clean("/"+hdr.Linkname))
+		src, err := tarLookup(root, linkPath)
+		if err != nil || src.node.Type != "file" {
+			return false, arch.error(snPath, errors.Errorf("hard link target %v not found", linkPath))
+		}
+		node.Content = src.node.Content
+		node.Size = src.node.Size
+		arch.CompleteItem(snPath, nil, node, ItemStats{}, 0)
+
+	case node.Type == "file":
+		start := time.Now()
+		f := &tarFile{Reader: rd, name: snPath, fi: hdr.FileInfo()}
+		fn := arch.fileSaver.Save(ctx, snPath, snPath, f, f.fi, func() {
+			arch.StartFile(snPath)
+		}, func() {}, func(node *restic.Node, stats ItemStats) {
+			arch.CompleteItem(snPath, nil, node, stats, time.Since(start))
+		})
+		// the archive is read sequentially, so wait until the file is completed
+		fnr := fn.take(ctx)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if fnr.err != nil {
+			return false, arch.error(snPath, fnr.err)
+		}
+		node = fnr.node
+
+	default:
+		arch.CompleteItem(snPath, nil, node, ItemStats{}, 0)
+	}
+
+	parent.children[node.Name] = &tarItem{node: node}
+	return true, nil
+}
+
+// saveTarDir saves the tree of dir and all trees below it and returns the
+// ID of its tree.
+func (arch *Archiver) saveTarDir(ctx context.Context, snPath string, dir *tarItem, start time.Time) (restic.ID, error) {
+	names := make([]string, 0, len(dir.children))
+	for name := range dir.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tree := restic.NewTree(len(names))
+	for _, name := range names {
+		item := dir.children[name]
+		if item.children != nil {
+			id, err := arch.saveTarDir(ctx, path.Join(snPath, name), item, start)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			item.node.Subtree = &id
+		}
+		if err := tree.Insert(item.node); err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	id, err := restic.SaveTree(ctx, arch.Repo, tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	node := dir.node
+	if snPath == "/" {
+		// the root directory is reported without a node
+		node = nil
+	}
+	arch.CompleteItem(snPath, nil, node, ItemStats{}, time.Since(start))
+	return id, nil
+}
+
+// tarParent returns the directory containing snPath, missing directories
+// are created.
+func tarParent(root *tarItem, snPath string, defaultTime time.Time) (*tarItem, error) {
+	dir := root
+	p := "/"
+	for _, name := range strings.Split(strings.Trim(path.Dir(snPath), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		p = path.Join(p, name)
+		item, ok := dir.children[name]
+		if !ok {
+			item = newTarDir(implicitTarDir(name, defaultTime))
+			dir.children[name] = item
+		}
+		if item.children == nil {
+			return nil, errors.Errorf("%v is not a directory", p)
+		}
+		dir = item
+	}
+	return dir, nil
+}
+
+// tarLookup returns the item stored at snPath.
+func tarLookup(root *tarItem, snPath string) (*tarItem, error) {
+	parent := root
+	dir, name := path.Split(snPath)
+	if dir != "/" {
+		var err error
+		parent, err = tarLookup(root, path.Clean(dir))
+		if err != nil {
+			return nil, err
+		}
+	}
+	item, ok := parent.children[name]
+	if !ok {
+		return nil, errors.Errorf("%v not found", snPath)
+	}
+	return item, nil
+}
+
+// implicitTarDir returns the node for a directory which is not contained in
+// the archive.
+func implicitTarDir(name string, t time.Time) *restic.Node {
+	return &restic.Node{
+		Name:       name,
+		Type:       "dir",
+		Mode:       os.ModeDir | 0755,
+		ModTime:    t,
+		AccessTime: t,
+		ChangeTime: t,
+	}
+}
+
+// nodeFromTarHeader returns the node for the tar header of the item snPath.
+func (arch *Archiver) nodeFromTarHeader(snPath string, hdr *tar.Header) (*restic.Node, error) {
+	mask := os.ModePerm | os.ModeType | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	node := &restic.Node{
+		Name:       path.Base(snPath),
+		Mode:       hdr.FileInfo().Mode() & mask,
+		ModTime:    hdr.ModTime,
+		ChangeTime: hdr.ChangeTime,
+		UID:        uint32(hdr.Uid),
+		GID:        uint32(hdr.Gid),
+		User:       hdr.Uname,
+		Group:      hdr.Gname,
+	}
+	if node.ChangeTime.IsZero() {
+		node.ChangeTime = node.ModTime
+	}
+	node.AccessTime = node.ModTime
+	if arch.WithAtime && !hdr.AccessTime.IsZero() {
+		node.AccessTime = hdr.AccessTime
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeLink, tar.TypeGNUSparse, '\x00':
+		node.Type = "file"
+		node.Mode &^= os.ModeType
+		node.Size = uint64(hdr.Size)
+	case tar.TypeDir:
+		node.Type = "dir"
+	case tar.TypeSymlink:
+		node.Type = "symlink"
+		node.LinkTarget = hdr.Linkname
+	case tar.TypeFifo:
+		node.Type = "fifo"
+	default:
+		return nil, errors.Errorf("unsupported type %q of tar entry", hdr.Typeflag)
+	}
+
+	var names []string
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "SCHILY.xattr.") {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		node.ExtendedAttributes = append(node.ExtendedAttributes, restic.ExtendedAttribute{
+			Name:  strings.TrimPrefix(key, "SCHILY.xattr."),
+			Value: []byte(hdr.PAXRecords[key]),
+		})
+	}
+	return node, nil
+}
+
+// tarFile is a regular file within a tar archive, which can only be read
+// sequentially.
+type tarFile struct {
+	io.Reader
+	name string
+	fi   os.FileInfo
+}
+
+func (f *tarFile) Fd() uintptr {
+	return 0
+}
+
+func (f *tarFile) Readdirnames(n int) ([]string, error) {
+	return nil, &os.PathError{Op: "readdirnames", Path: f.name, Err: os.ErrInvalid}
+}
+
+func (f *tarFile) Readdir(n int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: os.ErrInvalid}
+}
+
+func (f *tarFile) Seek(int64, int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+}
+
+func (f *tarFile) Close() error {
+	return nil
+}
+
+func (f *tarFile) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *tarFile) Name() string {
+	return f.name
+}
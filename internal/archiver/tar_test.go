@@ -0,0 +1,120 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+type testTarEntry struct {
+	hdr  tar.Header
+	data string
+}
+
+func buildTestTar(t testing.TB, entries []testTarEntry) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.data))
+		restictest.OK(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(e.data))
+		restictest.OK(t, err)
+	}
+	restictest.OK(t, tw.Close())
+	return buf
+}
+
+func TestArchiverSnapshotTar(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mtime := time.Unix(1500000000, 0)
+	largeData := string(restictest.Random(23, 3*1024*1024+12345))
+	rd := buildTestTar(t, []testTarEntry{
+		// the contents of a directory can precede the directory itself
+		{hdr: tar.Header{Name: "dir/sub/file", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime}, data: "sub file"},
+		{hdr: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime, Uid: 1000, Uname: "user"}},
+		{hdr: tar.Header{Name: "dir/large", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime}, data: largeData},
+		{hdr: tar.Header{Name: "./link", Typeflag: tar.TypeSymlink, Linkname: "dir/large", ModTime: mtime}},
+		{hdr: tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "dir/sub/file", ModTime: mtime}},
+		{hdr: tar.Header{Name: "../../escape", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime}, data: "escape"},
+		{hdr: tar.Header{Name: "excluded", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime}, data: "excluded"},
+	})
+
+	repo := repository.TestRepository(t)
+	arch := New(repo, fs.Local{}, Options{})
+	arch.SelectByName = func(item string) bool {
+		return item != "/data/excluded"
+	}
+
+	sn, id, err := arch.SnapshotTar(ctx, rd, "data", SnapshotOptions{Time: time.Now(), Hostname: "host"})
+	restictest.OK(t, err)
+	restictest.Equals(t, []string{"/data"}, sn.Paths)
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"data": TestDir{
+			"dir": TestDir{
+				"sub": TestDir{
+					"file": TestFile{Content: "sub file"},
+				},
+				"large": TestFile{Content: largeData},
+			},
+			"link":     TestSymlink{Target: "dir/large"},
+			"hardlink": TestFile{Content: "sub file"},
+			"escape":   TestFile{Content: "escape"},
+		},
+	})
+
+	// the metadata of the directory is taken from the archive
+	tree, err := restic.LoadTree(ctx, repo, *sn.Tree)
+	restictest.OK(t, err)
+	tree, err = restic.LoadTree(ctx, repo, *tree.Find("data").Subtree)
+	restictest.OK(t, err)
+	dir := tree.Find("dir")
+	restictest.Equals(t, os.ModeDir|0750, dir.Mode)
+	restictest.Equals(t, uint32(1000), dir.UID)
+	restictest.Equals(t, "user", dir.User)
+	restictest.Assert(t, dir.ModTime.Equal(mtime), "wrong modification time %v", dir.ModTime)
+}
+
+func TestArchiverSnapshotTarErrors(t *testing.T) {
+	var tests = []struct {
+		name    string
+		entries []testTarEntry
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "file-as-dir",
+			entries: []testTarEntry{
+				{hdr: tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0644}, data: "foo"},
+				{hdr: tar.Header{Name: "file/foo", Typeflag: tar.TypeReg, Mode: 0644}, data: "bar"},
+			},
+		},
+		{
+			name: "missing-hardlink-target",
+			entries: []testTarEntry{
+				{hdr: tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "missing"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := repository.TestRepository(t)
+			arch := New(repo, fs.Local{}, Options{})
+			_, _, err := arch.SnapshotTar(context.TODO(), buildTestTar(t, test.entries), "stdin", SnapshotOptions{Time: time.Now()})
+			restictest.Assert(t, err != nil, "missing error")
+		})
+	}
+}
@@ -215,6 +215,23 @@ func TestEnsureFileContent(ctx context.Context, t testing.TB, repo restic.Reposi
 		return
 	}
 
+	if node.Bundle != nil {
+		bundle, err := repo.LoadBlob(ctx, restic.DataBlob, node.Content[0], nil)
+		if err != nil {
+			t.Fatalf("error loading bundle %v: %v", node.Content[0].Str(), err)
+			return
+		}
+		if node.Bundle.Offset+node.Size > uint64(len(bundle)) {
+			t.Fatalf("%v: file exceeds bundle of %d bytes", filename, len(bundle))
+			return
+		}
+		content := bundle[node.Bundle.Offset : node.Bundle.Offset+node.Size]
+		if string(content) != file.Content {
+			t.Fatalf("%v: wrong content returned, want %q, got %q", filename, file.Content, content)
+		}
+		return
+	}
+
 	content := make([]byte, crypto.CiphertextLength(len(file.Content)))
 	pos := 0
 	for _, id := range node.Content {
@@ -25,6 +25,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	azContainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/cenkalti/backoff/v4"
 )
 
 // Backend stores data on an azure endpoint.
@@ -42,12 +43,17 @@ const defaultListMaxItems = 5000
 
 // make sure that *Backend implements backend.Backend
 var _ restic.Backend = &Backend{}
+var _ restic.StagingBackend = &Backend{}
 
 func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	debug.Log("open, config %#v", cfg)
 	var client *azContainer.Client
 	var err error
 
+	if strings.EqualFold(cfg.AccessTier, string(blob.AccessTierArchive)) {
+		return nil, errors.New("the archive access tier can only be used for data pack files, use the option azure.data-access-tier instead")
+	}
+
 	url := fmt.Sprintf("https://%s.blob.core.windows.net/%s", cfg.AccountName, cfg.Container)
 	opts := &azContainer.ClientOptions{
 		ClientOptions: azcore.ClientOptions{
@@ -175,25 +181,39 @@ func (be *Backend) Path() string {
 	return be.prefix
 }
 
+// accessTier returns the access tier for new files. Only data pack files can
+// be moved to the archive tier, all other files are needed to plan operations.
+func (be *Backend) accessTier(h restic.Handle) blob.AccessTier {
+	if be.cfg.DataAccessTier != "" && h.Type == restic.PackFile && h.ContainedBlobType == restic.DataBlob {
+		return blob.AccessTier(be.cfg.DataAccessTier)
+	}
+	return blob.AccessTier(be.cfg.AccessTier)
+}
+
 // Save stores data in the backend at the handle.
 func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
 	objName := be.Filename(h)
 
 	debug.Log("InsertObject(%v, %v)", be.cfg.AccountName, objName)
 
+	opts := &blockblob.CommitBlockListOptions{}
+	if tier := be.accessTier(h); tier != "" {
+		opts.Tier = &tier
+	}
+
 	var err error
 	if rd.Length() < saveLargeSize {
 		// if it's smaller than 256miB, then just create the file directly from the reader
-		err = be.saveSmall(ctx, objName, rd)
+		err = be.saveSmall(ctx, objName, rd, opts)
 	} else {
 		// otherwise use the more complicated method
-		err = be.saveLarge(ctx, objName, rd)
+		err = be.saveLarge(ctx, objName, rd, opts)
 	}
 
 	return err
 }
 
-func (be *Backend) saveSmall(ctx context.Context, objName string, rd restic.RewindReader) error {
+func (be *Backend) saveSmall(ctx context.Context, objName string, rd restic.RewindReader, opts *blockblob.CommitBlockListOptions) error {
 	blockBlobClient := be.container.NewBlockBlobClient(objName)
 
 	// upload it as a new "block", use the base64 hash for the ID
@@ -214,11 +234,11 @@ func (be *Backend) saveSmall(ctx context.Context, objName string, rd restic.Rewi
 	}
 
 	blocks := []string{id}
-	_, err = blockBlobClient.CommitBlockList(ctx, blocks, &blockblob.CommitBlockListOptions{})
+	_, err = blockBlobClient.CommitBlockList(ctx, blocks, opts)
 	return errors.Wrap(err, "CommitBlockList")
 }
 
-func (be *Backend) saveLarge(ctx context.Context, objName string, rd restic.RewindReader) error {
+func (be *Backend) saveLarge(ctx context.Context, objName string, rd restic.RewindReader, opts *blockblob.CommitBlockListOptions) error {
 	blockBlobClient := be.container.NewBlockBlobClient(objName)
 
 	buf := make([]byte, 100*1024*1024)
@@ -265,7 +285,7 @@ func (be *Backend) saveLarge(ctx context.Context, objName string, rd restic.Rewi
 		return errors.Errorf("wrote %d bytes instead of the expected %d bytes", uploadedBytes, rd.Length())
 	}
 
-	_, err := blockBlobClient.CommitBlockList(ctx, blocks, &blockblob.CommitBlockListOptions{})
+	_, err := blockBlobClient.CommitBlockList(ctx, blocks, opts)
 
 	debug.Log("uploaded %d parts: %v", len(blocks), blocks)
 	return errors.Wrap(err, "CommitBlockList")
@@ -289,6 +309,10 @@ func (be *Backend) openReader(ctx context.Context, h restic.Handle, length int,
 	})
 
 	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobArchived) {
+			// retrying does not help until the blob has been rehydrated
+			return nil, backoff.Permanent(errors.Errorf("%v is in archive storage, run restore with --stage to retrieve it: %v", h, err))
+		}
 		return nil, err
 	}
 
@@ -313,6 +337,46 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo,
 	return fi, nil
 }
 
+// Stage rehydrates an archived blob to the hot tier. Blobs in other tiers can
+// be read immediately.
+func (be *Backend) Stage(ctx context.Context, h restic.Handle) (bool, error) {
+	objName := be.Filename(h)
+	blobClient := be.container.NewBlobClient(objName)
+
+	props, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "blob.GetProperties")
+	}
+
+	if props.AccessTier == nil || *props.AccessTier != string(blob.AccessTierArchive) {
+		return true, nil
+	}
+
+	if props.ArchiveStatus != nil && strings.HasPrefix(*props.ArchiveStatus, "rehydrate-pending") {
+		return false, nil
+	}
+
+	priority := blob.RehydratePriorityStandard
+	if be.cfg.RehydratePriority != "" {
+		priority = blob.RehydratePriority(be.cfg.RehydratePriority)
+	}
+
+	debug.Log("rehydrating %v with priority %v", objName, priority)
+	_, err = blobClient.SetTier(ctx, blob.AccessTierHot, &blob.SetTierOptions{RehydratePriority: &priority})
+	return false, errors.Wrap(err, "blob.SetTier")
+}
+
+// Archived returns whether the blob is in the archive tier. This includes
+// blobs which are being rehydrated.
+func (be *Backend) Archived(ctx context.Context, h restic.Handle) (bool, error) {
+	props, err := be.container.NewBlobClient(be.Filename(h)).GetProperties(ctx, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "blob.GetProperties")
+	}
+
+	return props.AccessTier != nil && *props.AccessTier == string(blob.AccessTierArchive), nil
+}
+
 // Remove removes the blob with the given name and type.
 func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
 	objName := be.Filename(h)
@@ -17,7 +17,12 @@ type Config struct {
 	Container   string
 	Prefix      string
 
-	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Proxy       string `option:"proxy" help:"proxy URL for this backend (http, https or socks5), or 'direct' to not use a proxy (default: --proxy)"`
+
+	AccessTier        string `option:"access-tier" help:"set the access tier for new files (Hot or Cool, default: account default)"`
+	DataAccessTier    string `option:"data-access-tier" help:"set the access tier for data pack files, Hot, Cool or Archive (default: access-tier)"`
+	RehydratePriority string `option:"rehydrate-priority" help:"priority for rehydrating archived files during staging, Standard or High (default: Standard)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -1,6 +1,10 @@
 package azure
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+)
 
 var configTests = []struct {
 	s   string
@@ -38,3 +42,26 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestAccessTier(t *testing.T) {
+	be := &Backend{cfg: Config{AccessTier: "Cool", DataAccessTier: "Archive"}}
+
+	for _, test := range []struct {
+		h    restic.Handle
+		tier string
+	}{
+		{restic.Handle{Type: restic.PackFile, ContainedBlobType: restic.DataBlob}, "Archive"},
+		{restic.Handle{Type: restic.PackFile, ContainedBlobType: restic.TreeBlob}, "Cool"},
+		{restic.Handle{Type: restic.IndexFile}, "Cool"},
+		{restic.Handle{Type: restic.SnapshotFile}, "Cool"},
+	} {
+		if tier := be.accessTier(test.h); string(tier) != test.tier {
+			t.Errorf("%v: want tier %q, got %q", test.h, test.tier, tier)
+		}
+	}
+
+	be.cfg.DataAccessTier = ""
+	if tier := be.accessTier(restic.Handle{Type: restic.PackFile, ContainedBlobType: restic.DataBlob}); tier != "Cool" {
+		t.Errorf("want default tier for data, got %q", tier)
+	}
+}
@@ -17,7 +17,8 @@ type Config struct {
 	Bucket    string
 	Prefix    string
 
-	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Proxy       string `option:"proxy" help:"proxy URL for this backend (http, https or socks5), or 'direct' to not use a proxy (default: --proxy)"`
 }
 
 // NewConfig returns a new config with default options applied.
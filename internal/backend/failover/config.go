@@ -0,0 +1,38 @@
+package failover
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Config contains the locations of the backends in the order of priority.
+type Config struct {
+	Locations []string
+}
+
+// ParseConfig parses the string s and extracts the locations of the backends.
+// The supported configuration format is failover:location1,location2[,...].
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "failover:") {
+		return nil, errors.New(`invalid format, does not start with "failover:"`)
+	}
+
+	var cfg Config
+	for _, loc := range strings.Split(s[len("failover:"):], ",") {
+		if loc == "" {
+			return nil, errors.New("failover: empty location")
+		}
+		// the locations of nested backends would be split at the commas
+		if strings.HasPrefix(loc, "failover:") || strings.HasPrefix(loc, "mirror:") {
+			return nil, errors.New("failover: nested locations are not supported")
+		}
+		cfg.Locations = append(cfg.Locations, loc)
+	}
+
+	if len(cfg.Locations) < 2 {
+		return nil, errors.New("failover: at least two locations are required")
+	}
+
+	return cfg, nil
+}
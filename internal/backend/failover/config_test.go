@@ -0,0 +1,51 @@
+package failover
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	var tests = []struct {
+		s   string
+		cfg Config
+	}{
+		{"failover:/srv/nas,s3:s3.amazonaws.com/bucket", Config{
+			Locations: []string{"/srv/nas", "s3:s3.amazonaws.com/bucket"},
+		}},
+		{"failover:sftp:user@host:/repo,rest:http://localhost:8000/,/tmp/repo", Config{
+			Locations: []string{"sftp:user@host:/repo", "rest:http://localhost:8000/", "/tmp/repo"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			cfg, err := ParseConfig(test.s)
+			if err != nil {
+				t.Fatalf("%s failed: %v", test.s, err)
+			}
+
+			if !reflect.DeepEqual(cfg, test.cfg) {
+				t.Fatalf("wrong config, want:\n  %#v\ngot:\n  %#v", test.cfg, cfg)
+			}
+		})
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, s := range []string{
+		"failover:",
+		"failover:/srv/nas",
+		"failover:/srv/nas,",
+		"failover:/srv/nas,failover:/tmp/a,/tmp/b",
+		"failover:/srv/nas,mirror:/tmp/a,/tmp/b",
+		"mirror:/srv/nas,/tmp/repo",
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := ParseConfig(s)
+			if err == nil {
+				t.Fatalf("expected error for %q", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,4 @@
+// Package failover implements a backend which uses the first healthy backend
+// of a prioritized list. If the active backend fails persistently, later
+// operations transparently use the next backend in the list.
+package failover
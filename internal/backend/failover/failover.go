@@ -0,0 +1,295 @@
+package failover
+
+import (
+	"context"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// DefaultFailbackInterval is the default minimum time between two checks
+// whether a backend with a higher priority than the active one is healthy
+// again.
+const DefaultFailbackInterval = 5 * time.Minute
+
+// Event describes a switch of the active backend. Err is the error which
+// caused the failover, it is nil when switching back to a backend with a
+// higher priority.
+type Event struct {
+	From string
+	To   string
+	Err  error
+}
+
+// Backend runs all operations on the active backend. If an operation fails
+// and the health check of the active backend fails as well, the operation is
+// retried on the next healthy backend in the order of priority.
+type Backend struct {
+	backends []restic.Backend
+	report   func(Event)
+
+	// FailbackInterval is the minimum time between two checks whether a
+	// backend with a higher priority is healthy again.
+	FailbackInterval time.Duration
+
+	m         sync.Mutex
+	active    int
+	lastCheck time.Time
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// New returns a backend which uses the first healthy backend in backends. The
+// function report, if not nil, is called whenever the active backend changes.
+func New(backends []restic.Backend, report func(Event)) *Backend {
+	debug.Log("created new failover backend with %d backends", len(backends))
+	return &Backend{
+		backends:         backends,
+		report:           report,
+		FailbackInterval: DefaultFailbackInterval,
+	}
+}
+
+// Backends returns the backends in the order of priority.
+func (be *Backend) Backends() []restic.Backend {
+	return be.backends
+}
+
+// Active returns the backend which is currently used.
+func (be *Backend) Active() restic.Backend {
+	be.m.Lock()
+	defer be.m.Unlock()
+	return be.backends[be.active]
+}
+
+// Location returns the locations of all backends.
+func (be *Backend) Location() string {
+	locations := make([]string, 0, len(be.backends))
+	for _, b := range be.backends {
+		locations = append(locations, b.Location())
+	}
+	return "failover:" + strings.Join(locations, ",")
+}
+
+// Connections returns the highest number of connections of all backends, the
+// connections of each backend are limited separately.
+func (be *Backend) Connections() uint {
+	var conns uint = 1
+	for _, b := range be.backends {
+		if c := b.Connections(); c > conns {
+			conns = c
+		}
+	}
+	return conns
+}
+
+// Hasher returns nil, the backends may use different hash functions. Save
+// computes the hash for the backend which is used.
+func (be *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// HasAtomicReplace returns whether all backends can atomically replace files.
+func (be *Backend) HasAtomicReplace() bool {
+	for _, b := range be.backends {
+		if !b.HasAtomicReplace() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file in
+// one of the backends.
+func (be *Backend) IsNotExist(err error) bool {
+	for _, b := range be.backends {
+		if b.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// healthy checks whether b is reachable and contains the config file. A
+// missing config file usually means that a network share is not mounted.
+func healthy(ctx context.Context, b restic.Backend) error {
+	_, err := b.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	return err
+}
+
+func (be *Backend) emit(from, to int, err error) {
+	debug.Log("switching from %v to %v: %v", be.backends[from].Location(), be.backends[to].Location(), err)
+	if be.report != nil {
+		be.report(Event{From: be.backends[from].Location(), To: be.backends[to].Location(), Err: err})
+	}
+}
+
+// current returns the active backend. After the active backend has failed,
+// the backends with a higher priority are checked at most once per
+// FailbackInterval and the first healthy one becomes active again.
+func (be *Backend) current(ctx context.Context) (int, restic.Backend) {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if be.active > 0 && time.Since(be.lastCheck) >= be.FailbackInterval {
+		be.lastCheck = time.Now()
+		for i := 0; i < be.active; i++ {
+			if healthy(ctx, be.backends[i]) == nil {
+				be.emit(be.active, i, nil)
+				be.active = i
+				break
+			}
+		}
+	}
+
+	return be.active, be.backends[be.active]
+}
+
+// failover is called after an operation on backend i has failed with err. It
+// returns true if the operation should be retried on the active backend, which
+// is the case if another backend has become active in the meantime, or if the
+// health check of backend i fails and a healthy replacement is found.
+func (be *Backend) failover(ctx context.Context, i int, err error) bool {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if be.active != i {
+		return true
+	}
+
+	if herr := healthy(ctx, be.backends[i]); herr == nil {
+		// the backend is reachable, the error is specific to the operation
+		return false
+	}
+
+	for j := range be.backends {
+		if j == i || ctx.Err() != nil {
+			continue
+		}
+		if herr := healthy(ctx, be.backends[j]); herr != nil {
+			debug.Log("backend %v is not healthy either: %v", be.backends[j].Location(), herr)
+			continue
+		}
+
+		be.emit(i, j, err)
+		be.active = j
+		be.lastCheck = time.Now()
+		return true
+	}
+
+	return false
+}
+
+// run calls fn with the active backend until it succeeds or the error cannot
+// be resolved by switching to another backend.
+func (be *Backend) run(ctx context.Context, fn func(b restic.Backend) error) error {
+	tried := 0
+	for {
+		i, b := be.current(ctx)
+		err := fn(b)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		// each backend can only fail once per operation
+		tried++
+		if tried >= len(be.backends) || !be.failover(ctx, i, err) {
+			return err
+		}
+	}
+}
+
+// Save stores the file in the active backend.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.run(ctx, func(b restic.Backend) error {
+		if err := rd.Rewind(); err != nil {
+			return err
+		}
+
+		brd, err := backend.WithHash(rd, b.Hasher())
+		if err != nil {
+			return err
+		}
+		return b.Save(ctx, h, brd)
+	})
+}
+
+// Load runs fn with a reader for the file from the active backend.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return be.run(ctx, func(b restic.Backend) error {
+		return b.Load(ctx, h, length, offset, fn)
+	})
+}
+
+// Stat returns information about the file in the active backend.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	var fi restic.FileInfo
+	err := be.run(ctx, func(b restic.Backend) error {
+		var err error
+		fi, err = b.Stat(ctx, h)
+		return err
+	})
+	return fi, err
+}
+
+// Remove removes the file from the active backend.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	return be.run(ctx, func(b restic.Backend) error {
+		return b.Remove(ctx, h)
+	})
+}
+
+// List runs fn for each file in the active backend. If listing fails midway
+// and the next backend takes over, files already passed to fn are skipped.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	seen := make(map[string]struct{})
+	var fnErr error
+
+	err := be.run(ctx, func(b restic.Backend) error {
+		if fnErr != nil {
+			return fnErr
+		}
+		return b.List(ctx, t, func(fi restic.FileInfo) error {
+			if _, ok := seen[fi.Name]; ok {
+				return nil
+			}
+			seen[fi.Name] = struct{}{}
+
+			fnErr = fn(fi)
+			return fnErr
+		})
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// Delete removes all data in all backends.
+func (be *Backend) Delete(ctx context.Context) error {
+	var firstErr error
+	for _, b := range be.backends {
+		if err := b.Delete(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close closes all backends.
+func (be *Backend) Close() error {
+	var firstErr error
+	for _, b := range be.backends {
+		if err := b.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,218 @@
+package failover_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/failover"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type failoverConfig struct {
+	be restic.Backend
+}
+
+func newTestSuite() *test.Suite {
+	return &test.Suite{
+		NewConfig: func() (interface{}, error) {
+			return &failoverConfig{}, nil
+		},
+
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*failoverConfig)
+			if c.be != nil {
+				_, err := c.be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+				if err != nil && !c.be.IsNotExist(err) {
+					return nil, err
+				}
+
+				if err == nil {
+					return nil, errors.New("config already exists")
+				}
+			}
+
+			c.be = failover.New([]restic.Backend{mem.New(), mem.New()}, nil)
+			return c.be, nil
+		},
+
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*failoverConfig)
+			if c.be == nil {
+				c.be = failover.New([]restic.Backend{mem.New(), mem.New()}, nil)
+			}
+			return c.be, nil
+		},
+
+		Cleanup: func(cfg interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestSuiteBackendFailover(t *testing.T) {
+	newTestSuite().RunTests(t)
+}
+
+var errDown = errors.New("host is down")
+
+// flakyBackend fails all operations while down is set.
+type flakyBackend struct {
+	restic.Backend
+	down bool
+}
+
+func (be *flakyBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if be.down {
+		return errDown
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *flakyBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if be.down {
+		return errDown
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func (be *flakyBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	if be.down {
+		return restic.FileInfo{}, errDown
+	}
+	return be.Backend.Stat(ctx, h)
+}
+
+func (be *flakyBackend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	if be.down {
+		return errDown
+	}
+	return be.Backend.List(ctx, t, fn)
+}
+
+// newBackend returns a backend which contains a config file.
+func newBackend(t *testing.T) restic.Backend {
+	be := mem.New()
+	rtest.OK(t, be.Save(context.TODO(), restic.Handle{Type: restic.ConfigFile}, restic.NewByteReader([]byte("config"), be.Hasher())))
+	return be
+}
+
+func save(t *testing.T, be restic.Backend, data string) restic.Handle {
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash([]byte(data)).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte(data), be.Hasher())))
+	return h
+}
+
+func load(t *testing.T, be restic.Backend, h restic.Handle) string {
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	return string(buf)
+}
+
+func TestFailover(t *testing.T) {
+	primary := &flakyBackend{Backend: newBackend(t)}
+	secondary := newBackend(t)
+
+	var events []failover.Event
+	be := failover.New([]restic.Backend{primary, secondary}, func(ev failover.Event) {
+		events = append(events, ev)
+	})
+
+	h := save(t, be, "foo")
+	rtest.Equals(t, "foo", load(t, primary, h))
+	_, err := secondary.Stat(context.TODO(), h)
+	rtest.Assert(t, secondary.IsNotExist(err), "file was saved to the secondary backend")
+	rtest.Equals(t, 0, len(events))
+
+	// a missing file does not cause a failover
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "missing"})
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+	rtest.Equals(t, 0, len(events))
+
+	rtest.OK(t, secondary.Save(context.TODO(), h, restic.NewByteReader([]byte("foo"), secondary.Hasher())))
+	primary.down = true
+
+	rtest.Equals(t, "foo", load(t, be, h))
+	rtest.Equals(t, 1, len(events))
+	rtest.Equals(t, primary.Location(), events[0].From)
+	rtest.Equals(t, secondary.Location(), events[0].To)
+	rtest.Assert(t, errors.Is(events[0].Err, errDown), "unexpected error %v", events[0].Err)
+	rtest.Equals(t, restic.Backend(secondary), be.Active())
+
+	h2 := save(t, be, "bar")
+	rtest.Equals(t, "bar", load(t, secondary, h2))
+	rtest.Equals(t, 1, len(events))
+}
+
+func TestFailoverAllDown(t *testing.T) {
+	primary := &flakyBackend{Backend: newBackend(t), down: true}
+	secondary := &flakyBackend{Backend: newBackend(t), down: true}
+
+	var events []failover.Event
+	be := failover.New([]restic.Backend{primary, secondary}, func(ev failover.Event) {
+		events = append(events, ev)
+	})
+
+	_, err := be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	rtest.Assert(t, errors.Is(err, errDown), "unexpected error %v", err)
+	rtest.Equals(t, 0, len(events))
+	rtest.Equals(t, restic.Backend(primary), be.Active())
+}
+
+func TestFailoverOperationError(t *testing.T) {
+	primary := newBackend(t)
+	secondary := newBackend(t)
+
+	events := 0
+	be := failover.New([]restic.Backend{primary, secondary}, func(failover.Event) {
+		events++
+	})
+
+	h := save(t, be, "foo")
+
+	// errors returned by the callback are not caused by the backend
+	errCallback := errors.New("callback failed")
+	err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		return errCallback
+	})
+	rtest.Assert(t, errors.Is(err, errCallback), "unexpected error %v", err)
+
+	err = be.List(context.TODO(), restic.PackFile, func(restic.FileInfo) error {
+		return errCallback
+	})
+	rtest.Assert(t, errors.Is(err, errCallback), "unexpected error %v", err)
+	rtest.Equals(t, 0, events)
+}
+
+func TestFailback(t *testing.T) {
+	primary := &flakyBackend{Backend: newBackend(t)}
+	secondary := newBackend(t)
+
+	var events []failover.Event
+	be := failover.New([]restic.Backend{primary, secondary}, func(ev failover.Event) {
+		events = append(events, ev)
+	})
+	be.FailbackInterval = 0
+
+	primary.down = true
+	h := save(t, be, "foo")
+	rtest.Equals(t, "foo", load(t, secondary, h))
+	rtest.Equals(t, 1, len(events))
+
+	// the primary backend is still down
+	rtest.Equals(t, "foo", load(t, be, h))
+	rtest.Equals(t, 1, len(events))
+
+	primary.down = false
+	h2 := save(t, be, "bar")
+	rtest.Equals(t, "bar", load(t, primary, h2))
+	rtest.Equals(t, 2, len(events))
+	rtest.Equals(t, secondary.Location(), events[1].From)
+	rtest.Equals(t, primary.Location(), events[1].To)
+	rtest.Equals(t, nil, events[1].Err)
+}
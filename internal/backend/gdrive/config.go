@@ -0,0 +1,52 @@
+package gdrive
+
+import (
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to connect to Google Drive.
+type Config struct {
+	// Path is the folder containing the repository, relative to the root of
+	// "My Drive" or of the shared drive.
+	Path string
+
+	ClientID     string
+	ClientSecret options.SecretString
+	TokenFile    string
+
+	TeamDrive   string `option:"team-drive" help:"ID of the shared drive which contains the repository (default: My Drive)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Proxy       string `option:"proxy" help:"proxy URL for this backend (http, https or socks5), or 'direct' to not use a proxy (default: --proxy)"`
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+func init() {
+	options.Register("gdrive", Config{})
+}
+
+// ParseConfig parses the string s and extracts the Google Drive config. The
+// supported configuration format is gdrive:path/to/folder.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "gdrive:") {
+		return nil, errors.New("gdrive: invalid format")
+	}
+
+	p := strings.Trim(path.Clean(s[len("gdrive:"):]), "/")
+	if p == "" || p == "." {
+		return nil, errors.New("gdrive: folder name not found")
+	}
+
+	cfg := NewConfig()
+	cfg.Path = p
+	return cfg, nil
+}
@@ -0,0 +1,46 @@
+package gdrive
+
+import "testing"
+
+var configTests = []struct {
+	s   string
+	cfg Config
+}{
+	{"gdrive:restic", Config{
+		Path:        "restic",
+		Connections: 5,
+	}},
+	{"gdrive:/backups/restic/", Config{
+		Path:        "backups/restic",
+		Connections: 5,
+	}},
+	{"gdrive:backups//host1", Config{
+		Path:        "backups/host1",
+		Connections: 5,
+	}},
+}
+
+func TestParseConfig(t *testing.T) {
+	for i, test := range configTests {
+		cfg, err := ParseConfig(test.s)
+		if err != nil {
+			t.Errorf("test %d:%s failed: %v", i, test.s, err)
+			continue
+		}
+
+		if cfg != test.cfg {
+			t.Errorf("test %d:\ninput:\n  %s\n wrong config, want:\n  %v\ngot:\n  %v",
+				i, test.s, test.cfg, cfg)
+			continue
+		}
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, s := range []string{"gdrive:", "gdrive:/", "gs:bucket:/foo"} {
+		_, err := ParseConfig(s)
+		if err == nil {
+			t.Errorf("ParseConfig(%q) did not return an error", s)
+		}
+	}
+}
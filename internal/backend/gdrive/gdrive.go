@@ -0,0 +1,553 @@
+// Package gdrive provides a restic backend for Google Drive.
+package gdrive
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/layout"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// Backend stores data in a folder on Google Drive. Drive identifies files by
+// ID and allows several files with the same name in a folder, the names and
+// folders of the repository are therefore mapped to IDs by the backend.
+type Backend struct {
+	service     *drive.Service
+	connections uint
+	teamDrive   string
+	root        string
+	path        string
+	layout.Layout
+
+	// folderMu serializes the creation of folders to prevent duplicates.
+	folderMu sync.Mutex
+
+	// m protects the IDs of known folders and files, indexed by path.
+	m       sync.Mutex
+	folders map[string]string
+	files   map[string]string
+}
+
+// Ensure that *Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+func open(cfg Config, rt http.RoundTripper, opts ...option.ClientOption) (*Backend, error) {
+	debug.Log("open, config %#v", cfg)
+
+	client, err := newHTTPClient(cfg, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]option.ClientOption{option.WithHTTPClient(client)}, opts...)
+	service, err := drive.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewService")
+	}
+
+	be := &Backend{
+		service:     service,
+		connections: cfg.Connections,
+		teamDrive:   cfg.TeamDrive,
+		root:        "root",
+		path:        cfg.Path,
+		Layout: &layout.DefaultLayout{
+			Path: cfg.Path,
+			Join: path.Join,
+		},
+		folders: make(map[string]string),
+		files:   make(map[string]string),
+	}
+	if cfg.TeamDrive != "" {
+		// the ID of a shared drive is also the ID of its root folder
+		be.root = cfg.TeamDrive
+	}
+
+	return be, nil
+}
+
+// Open opens the repository folder on Google Drive. The folder is looked up
+// on first use.
+func Open(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	return open(cfg, rt)
+}
+
+// Create creates the repository folder and its subfolders on Google Drive.
+func Create(ctx context.Context, cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	return create(ctx, cfg, rt)
+}
+
+func create(ctx context.Context, cfg Config, rt http.RoundTripper, opts ...option.ClientOption) (*Backend, error) {
+	be, err := open(cfg, rt, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = be.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err == nil {
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	for _, t := range []restic.FileType{restic.PackFile, restic.KeyFile, restic.LockFile, restic.SnapshotFile, restic.IndexFile, restic.AuditFile, restic.CatalogFile, restic.ParityFile, restic.ScrubFile, restic.DataKeyFile, restic.SearchIndexFile, restic.AuditHeadFile} {
+		dir, _ := be.Basedir(t)
+		if _, err := be.folderID(ctx, dir, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return be, nil
+}
+
+// isRateLimited returns true if the request failed because of the rate limits
+// of Google Drive or a temporary server error. Such requests should be
+// retried with exponential backoff.
+func isRateLimited(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+
+	switch {
+	case gerr.Code == http.StatusTooManyRequests, gerr.Code >= 500:
+		return true
+	case gerr.Code == http.StatusForbidden:
+		for _, item := range gerr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retry runs fn until it succeeds, returns an error which is not caused by
+// rate limiting, or the backoff gives up.
+func retry(ctx context.Context, fn func() error) error {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = time.Second
+	bo.MaxInterval = 64 * time.Second
+	bo.MaxElapsedTime = 5 * time.Minute
+
+	return backoff.RetryNotify(func() error {
+		err := fn()
+		if err != nil && !isRateLimited(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, backoff.WithContext(bo, ctx), func(err error, d time.Duration) {
+		debug.Log("rate limited, retrying in %v: %v", d, err)
+	})
+}
+
+// quote escapes s for use in a query string.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, `'`, `\'`) + "'"
+}
+
+func (be *Backend) listCall(q string) *drive.FilesListCall {
+	call := be.service.Files.List().Q(q + " and trashed = false").
+		SupportsAllDrives(true).
+		IncludeItemsFromAllDrives(true).
+		PageSize(1000)
+	if be.teamDrive != "" {
+		call = call.Corpora("drive").DriveId(be.teamDrive)
+	}
+	return call
+}
+
+// find returns the first entry named name in the folder parent.
+func (be *Backend) find(ctx context.Context, parent, name string, folder bool) (*drive.File, error) {
+	q := fmt.Sprintf("name = %v and %v in parents", quote(name), quote(parent))
+	if folder {
+		q += " and mimeType = " + quote(folderMimeType)
+	} else {
+		q += " and mimeType != " + quote(folderMimeType)
+	}
+
+	var list *drive.FileList
+	err := retry(ctx, func() (err error) {
+		list, err = be.listCall(q).Fields("files(id, name, size, md5Checksum)").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "List")
+	}
+
+	if len(list.Files) == 0 {
+		return nil, nil
+	}
+	return list.Files[0], nil
+}
+
+// folderID returns the ID of the folder at path p, optionally creating
+// missing folders.
+func (be *Backend) folderID(ctx context.Context, p string, create bool) (string, error) {
+	p = strings.Trim(p, "/")
+
+	be.m.Lock()
+	id, ok := be.folders[p]
+	be.m.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	if create {
+		be.folderMu.Lock()
+		defer be.folderMu.Unlock()
+	}
+	return be.resolveFolder(ctx, p, create)
+}
+
+func (be *Backend) resolveFolder(ctx context.Context, p string, create bool) (string, error) {
+	be.m.Lock()
+	id, ok := be.folders[p]
+	be.m.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	parent := be.root
+	if dir := path.Dir(p); dir != "." {
+		var err error
+		parent, err = be.resolveFolder(ctx, dir, create)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	f, err := be.find(ctx, parent, path.Base(p), true)
+	if err != nil {
+		return "", err
+	}
+
+	if f == nil {
+		if !create {
+			return "", &notExistError{path: p}
+		}
+
+		err = retry(ctx, func() (err error) {
+			f, err = be.service.Files.Create(&drive.File{
+				Name:     path.Base(p),
+				MimeType: folderMimeType,
+				Parents:  []string{parent},
+			}).SupportsAllDrives(true).Fields("id").Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "Create")
+		}
+		debug.Log("created folder %v with ID %v", p, f.Id)
+	}
+
+	be.m.Lock()
+	be.folders[p] = f.Id
+	be.m.Unlock()
+	return f.Id, nil
+}
+
+// file returns the ID, size and checksum of the file for h.
+func (be *Backend) file(ctx context.Context, h restic.Handle) (*drive.File, error) {
+	dir, err := be.folderID(ctx, be.Dirname(h), false)
+	if be.IsNotExist(err) {
+		return nil, &notExistError{path: be.Filename(h)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := be.find(ctx, dir, path.Base(be.Filename(h)), false)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, &notExistError{path: be.Filename(h)}
+	}
+	return f, nil
+}
+
+// fileID returns the ID of the file for h, using the IDs recorded by Save and
+// List if possible.
+func (be *Backend) fileID(ctx context.Context, h restic.Handle) (string, error) {
+	name := be.Filename(h)
+
+	be.m.Lock()
+	id, ok := be.files[name]
+	be.m.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	f, err := be.file(ctx, h)
+	if err != nil {
+		return "", err
+	}
+
+	be.m.Lock()
+	be.files[name] = f.Id
+	be.m.Unlock()
+	return f.Id, nil
+}
+
+func (be *Backend) forget(h restic.Handle) {
+	be.m.Lock()
+	delete(be.files, be.Filename(h))
+	be.m.Unlock()
+}
+
+func (be *Backend) Connections() uint {
+	return be.connections
+}
+
+// Location returns this backend's location (the folder path).
+func (be *Backend) Location() string {
+	return "gdrive:" + be.path
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (be *Backend) Hasher() hash.Hash {
+	return md5.New()
+}
+
+// HasAtomicReplace returns whether Save() can atomically replace files
+func (be *Backend) HasAtomicReplace() bool {
+	return false
+}
+
+// Save stores data in the backend at the handle.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	dir, err := be.folderID(ctx, be.Dirname(h), true)
+	if err != nil {
+		return err
+	}
+
+	// Drive does not prevent creating a second file with the same name
+	existing, err := be.file(ctx, h)
+	if err != nil && !be.IsNotExist(err) {
+		return err
+	}
+	if existing != nil {
+		return errors.Errorf("file %v already exists", h)
+	}
+
+	var f *drive.File
+	err = retry(ctx, func() error {
+		if err := rd.Rewind(); err != nil {
+			return backoff.Permanent(err)
+		}
+
+		var err error
+		f, err = be.service.Files.Create(&drive.File{
+			Name:    path.Base(be.Filename(h)),
+			Parents: []string{dir},
+		}).Media(io.NopCloser(rd), googleapi.ContentType("application/octet-stream"), googleapi.ChunkSize(0)).
+			SupportsAllDrives(true).
+			Fields("id, size, md5Checksum").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "Create")
+	}
+
+	if f.Size != rd.Length() {
+		err = errors.Errorf("wrote %d bytes instead of the expected %d bytes", f.Size, rd.Length())
+	} else if hash := rd.Hash(); hash != nil && f.Md5Checksum != hex.EncodeToString(hash) {
+		err = errors.Errorf("MD5 checksum mismatch for %v", h)
+	}
+	if err != nil {
+		_ = be.service.Files.Delete(f.Id).SupportsAllDrives(true).Context(ctx).Do()
+		return err
+	}
+
+	be.m.Lock()
+	be.files[be.Filename(h)] = f.Id
+	be.m.Unlock()
+	return nil
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return backend.DefaultLoad(ctx, h, length, offset, be.openReader, fn)
+}
+
+func (be *Backend) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	id, err := be.fileID(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	err = retry(ctx, func() (err error) {
+		call := be.service.Files.Get(id).SupportsAllDrives(true).Context(ctx)
+		if length > 0 {
+			call.Header().Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1))
+		} else if offset > 0 {
+			call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		resp, err = call.Download()
+		return err
+	})
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			be.forget(h)
+			return nil, &notExistError{path: be.Filename(h)}
+		}
+		return nil, errors.Wrap(err, "Download")
+	}
+
+	if length > 0 {
+		return backend.LimitReadCloser(resp.Body, int64(length)), nil
+	}
+	return resp.Body, nil
+}
+
+// Stat returns information about a blob.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	f, err := be.file(ctx, h)
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+	return restic.FileInfo{Size: f.Size, Name: h.Name}, nil
+}
+
+// Remove removes the blob with the given name and type.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	id, err := be.fileID(ctx, h)
+	if err != nil {
+		return err
+	}
+
+	err = retry(ctx, func() error {
+		return be.service.Files.Delete(id).SupportsAllDrives(true).Context(ctx).Do()
+	})
+	be.forget(h)
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			return &notExistError{path: be.Filename(h)}
+		}
+		return errors.Wrap(err, "Delete")
+	}
+	return nil
+}
+
+// listFolder runs fn for all entries in the folder with the given ID.
+func (be *Backend) listFolder(ctx context.Context, folder string, fn func(*drive.File) error) error {
+	call := be.listCall(quote(folder) + " in parents").
+		Fields("nextPageToken, files(id, name, size, mimeType)").
+		Context(ctx)
+
+	for {
+		var list *drive.FileList
+		err := retry(ctx, func() (err error) {
+			list, err = call.Do()
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "List")
+		}
+
+		for _, f := range list.Files {
+			if err := fn(f); err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+
+		if list.NextPageToken == "" {
+			return nil
+		}
+		call = call.PageToken(list.NextPageToken)
+	}
+}
+
+// List runs fn for each file in the backend which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	basedir, subdirs := be.Basedir(t)
+
+	folder, err := be.folderID(ctx, basedir, false)
+	if be.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var visit func(dir string, id string, recurse bool) error
+	visit = func(dir string, id string, recurse bool) error {
+		return be.listFolder(ctx, id, func(f *drive.File) error {
+			p := path.Join(dir, f.Name)
+			if f.MimeType == folderMimeType {
+				if !recurse {
+					return nil
+				}
+				be.m.Lock()
+				be.folders[p] = f.Id
+				be.m.Unlock()
+				return visit(p, f.Id, false)
+			}
+
+			be.m.Lock()
+			be.files[p] = f.Id
+			be.m.Unlock()
+			return fn(restic.FileInfo{Name: f.Name, Size: f.Size})
+		})
+	}
+
+	err = visit(basedir, folder, subdirs)
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// notExistError is returned whenever the requested file or folder does not
+// exist.
+type notExistError struct {
+	path string
+}
+
+func (e *notExistError) Error() string {
+	return fmt.Sprintf("%v does not exist", e.path)
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	var e *notExistError
+	return errors.As(err, &e)
+}
+
+// Delete removes all restic files in the repository folder.
+func (be *Backend) Delete(ctx context.Context) error {
+	return backend.DefaultDelete(ctx, be)
+}
+
+// Close does nothing.
+func (be *Backend) Close() error { return nil }
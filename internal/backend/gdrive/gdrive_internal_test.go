@@ -0,0 +1,309 @@
+package gdrive
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/options"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+type fakeFile struct {
+	drive.File
+	data []byte
+}
+
+// fakeDrive implements the subset of the Drive API used by the backend.
+type fakeDrive struct {
+	m      sync.Mutex
+	files  map[string]*fakeFile
+	nextID int
+
+	// rateLimit is the number of requests which fail with a rate limit error
+	rateLimit int
+}
+
+func newFakeDrive() *fakeDrive {
+	return &fakeDrive{files: make(map[string]*fakeFile)}
+}
+
+var queryClause = regexp.MustCompile(`^(?:name = '([^']*)'|'([^']*)' in parents|mimeType (!?=) '([^']*)'|trashed = false)$`)
+
+func (d *fakeDrive) matches(f *fakeFile, q string) bool {
+	for _, clause := range strings.Split(q, " and ") {
+		m := queryClause.FindStringSubmatch(clause)
+		switch {
+		case m == nil:
+			panic(fmt.Sprintf("unsupported query %q", q))
+		case m[1] != "" && f.Name != m[1]:
+			return false
+		case m[2] != "" && (len(f.Parents) == 0 || f.Parents[0] != m[2]):
+			return false
+		case m[3] == "=" && f.MimeType != m[4]:
+			return false
+		case m[3] == "!=" && f.MimeType == m[4]:
+			return false
+		}
+	}
+	return true
+}
+
+func (d *fakeDrive) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (d *fakeDrive) create(f *drive.File, data []byte) *drive.File {
+	d.nextID++
+	f.Id = fmt.Sprintf("id%d", d.nextID)
+	if f.MimeType == "" {
+		f.MimeType = "application/octet-stream"
+		f.Size = int64(len(data))
+		sum := md5.Sum(data)
+		f.Md5Checksum = hex.EncodeToString(sum[:])
+	}
+	d.files[f.Id] = &fakeFile{File: *f, data: data}
+	return f
+}
+
+func (d *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.rateLimit > 0 {
+		d.rateLimit--
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error": {"code": 403, "message": "slow down", "errors": [{"reason": "userRateLimitExceeded"}]}}`))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+		var list drive.FileList
+		for _, f := range d.files {
+			if d.matches(f, r.URL.Query().Get("q")) {
+				file := f.File
+				list.Files = append(list.Files, &file)
+			}
+		}
+		sort.Slice(list.Files, func(i, j int) bool { return list.Files[i].Name < list.Files[j].Name })
+
+		// return at most 10 entries per page
+		start := 0
+		if token := r.URL.Query().Get("pageToken"); token != "" {
+			_, _ = fmt.Sscan(token, &start)
+		}
+		if start+10 < len(list.Files) {
+			list.NextPageToken = fmt.Sprint(start + 10)
+			list.Files = list.Files[start : start+10]
+		} else {
+			list.Files = list.Files[start:]
+		}
+		d.writeJSON(w, list)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files":
+		var f drive.File
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		d.writeJSON(w, d.create(&f, nil))
+
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var f drive.File
+		var data []byte
+		for i := 0; i < 2; i++ {
+			part, err := mr.NextPart()
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			buf, err := io.ReadAll(part)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if i == 0 {
+				err = json.Unmarshal(buf, &f)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			} else {
+				data = buf
+			}
+		}
+		d.writeJSON(w, d.create(&f, data))
+
+	case strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+		f, ok := d.files[strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "file not found"}}`))
+			return
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			delete(d.files, f.Id)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			http.ServeContent(w, r, f.Name, time.Time{}, bytes.NewReader(f.data))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeDriveSuite(t testing.TB, d *fakeDrive) *test.Suite {
+	t.Setenv("GOOGLE_ACCESS_TOKEN", "test")
+
+	srv := httptest.NewServer(d)
+	t.Cleanup(srv.Close)
+
+	tr, err := backend.Transport(backend.TransportOptions{})
+	if err != nil {
+		t.Fatalf("cannot create transport for tests: %v", err)
+	}
+	opts := []option.ClientOption{option.WithEndpoint(srv.URL + "/drive/v3/")}
+
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			cfg := NewConfig()
+			cfg.Path = "backups/" + restic.NewRandomID().String()
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			return create(context.TODO(), config.(Config), tr, opts...)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			return open(config.(Config), tr, opts...)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestBackendFakeDrive(t *testing.T) {
+	newFakeDriveSuite(t, newFakeDrive()).RunTests(t)
+}
+
+func TestBackendGoogleDrive(t *testing.T) {
+	repo := os.Getenv("RESTIC_TEST_GDRIVE_REPOSITORY")
+	if repo == "" {
+		t.Skipf("environment variable %v not set", "RESTIC_TEST_GDRIVE_REPOSITORY")
+	}
+
+	tr, err := backend.Transport(backend.TransportOptions{})
+	rtest.OK(t, err)
+
+	(&test.Suite{
+		// do not use excessive data
+		MinimalData: true,
+
+		NewConfig: func() (interface{}, error) {
+			cfg, err := ParseConfig(repo)
+			if err != nil {
+				return nil, err
+			}
+			c := cfg.(Config)
+			c.Path = fmt.Sprintf("%v/test-%d", c.Path, time.Now().UnixNano())
+			c.ClientID = os.Getenv("GOOGLE_DRIVE_CLIENT_ID")
+			c.ClientSecret = options.NewSecretString(os.Getenv("GOOGLE_DRIVE_CLIENT_SECRET"))
+			c.TokenFile = os.Getenv("GOOGLE_DRIVE_TOKEN_FILE")
+			c.TeamDrive = os.Getenv("RESTIC_TEST_GDRIVE_TEAM_DRIVE")
+			return c, nil
+		},
+		Create: func(config interface{}) (restic.Backend, error) {
+			return Create(context.TODO(), config.(Config), tr)
+		},
+		Open: func(config interface{}) (restic.Backend, error) {
+			return Open(config.(Config), tr)
+		},
+		Cleanup: func(config interface{}) error {
+			be, err := Open(config.(Config), tr)
+			if err != nil {
+				return err
+			}
+			return be.Delete(context.TODO())
+		},
+	}).RunTests(t)
+}
+
+func TestRateLimitRetry(t *testing.T) {
+	d := newFakeDrive()
+	suite := newFakeDriveSuite(t, d)
+	cfg, err := suite.NewConfig()
+	rtest.OK(t, err)
+	be, err := suite.Create(cfg)
+	rtest.OK(t, err)
+
+	d.m.Lock()
+	d.rateLimit = 2
+	d.m.Unlock()
+
+	data := rtest.Random(23, 1000)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data, be.Hasher())))
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+}
+
+func TestIsRateLimited(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("foo"), false},
+		{&googleapi.Error{Code: http.StatusNotFound}, false},
+		{&googleapi.Error{Code: http.StatusForbidden}, false},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, true},
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{fmt.Errorf("wrapped: %w", &googleapi.Error{Code: http.StatusInternalServerError}), true},
+	} {
+		rtest.Equals(t, test.want, isRateLimited(test.err))
+	}
+}
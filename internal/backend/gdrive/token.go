@@ -0,0 +1,135 @@
+package gdrive
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// fileTokenSource writes refreshed OAuth tokens back to the token file, so
+// that the refresh token stays usable even if the server rotates it.
+type fileTokenSource struct {
+	filename string
+	src      oauth2.TokenSource
+
+	m    sync.Mutex
+	last string
+}
+
+func (ts *fileTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := ts.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.m.Lock()
+	defer ts.m.Unlock()
+
+	if tok.AccessToken == ts.last {
+		return tok, nil
+	}
+	ts.last = tok.AccessToken
+
+	// failing to save the token is not fatal, it can be refreshed again
+	_ = saveToken(ts.filename, tok)
+	return tok, nil
+}
+
+func loadToken(filename string) (*oauth2.Token, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	var tok oauth2.Token
+	if err := json.Unmarshal(buf, &tok); err != nil {
+		return nil, errors.Wrapf(err, "invalid token file %v", filename)
+	}
+	if tok.RefreshToken == "" && tok.AccessToken == "" {
+		return nil, errors.Errorf("token file %v does not contain a token", filename)
+	}
+	return &tok, nil
+}
+
+// saveToken atomically replaces the token file.
+func saveToken(filename string, tok *oauth2.Token) error {
+	buf, err := json.Marshal(tok)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), ".restic-gdrive-token-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = f.Write(buf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// tokenSource returns the source of OAuth tokens for cfg. A token file
+// created by an OAuth client is used if configured, otherwise the default
+// application credentials, for example a service account, are used.
+func tokenSource(ctx context.Context, cfg Config) (oauth2.TokenSource, error) {
+	if token := os.Getenv("GOOGLE_ACCESS_TOKEN"); token != "" {
+		return oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: token,
+			TokenType:   "Bearer",
+		}), nil
+	}
+
+	if cfg.TokenFile == "" {
+		return google.DefaultTokenSource(ctx, drive.DriveScope)
+	}
+
+	tok, err := loadToken(cfg.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ClientID == "" {
+		return nil, errors.New("client ID ($GOOGLE_DRIVE_CLIENT_ID) is required to refresh the token")
+	}
+
+	conf := &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret.Unwrap(),
+		Endpoint:     google.Endpoint,
+		Scopes:       []string{drive.DriveScope},
+	}
+
+	return &fileTokenSource{
+		filename: cfg.TokenFile,
+		src:      conf.TokenSource(ctx, tok),
+		last:     tok.AccessToken,
+	}, nil
+}
+
+func newHTTPClient(cfg Config, rt http.RoundTripper) (*http.Client, error) {
+	// create a new context with the HTTP client stored at the oauth2.HTTPClient key
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: rt})
+
+	ts, err := tokenSource(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, ts), nil
+}
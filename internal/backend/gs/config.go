@@ -16,7 +16,11 @@ type Config struct {
 	Bucket    string
 	Prefix    string
 
-	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Proxy       string `option:"proxy" help:"proxy URL for this backend (http, https or socks5), or 'direct' to not use a proxy (default: --proxy)"`
+
+	StorageClass     string `option:"storage-class" help:"set the storage class for new files (STANDARD, NEARLINE, COLDLINE or ARCHIVE, default: bucket default)"`
+	DataStorageClass string `option:"data-storage-class" help:"set the storage class for data pack files (default: storage-class)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -1,6 +1,10 @@
 package gs
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+)
 
 var configTests = []struct {
 	s   string
@@ -38,3 +42,21 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestStorageClassFor(t *testing.T) {
+	be := &Backend{storageClass: "STANDARD", dataStorageClass: "COLDLINE"}
+
+	for _, test := range []struct {
+		h     restic.Handle
+		class string
+	}{
+		{restic.Handle{Type: restic.PackFile, ContainedBlobType: restic.DataBlob}, "COLDLINE"},
+		{restic.Handle{Type: restic.PackFile, ContainedBlobType: restic.TreeBlob}, "STANDARD"},
+		{restic.Handle{Type: restic.IndexFile}, "STANDARD"},
+		{restic.Handle{Type: restic.SnapshotFile}, "STANDARD"},
+	} {
+		if class := be.storageClassFor(test.h); class != test.class {
+			t.Errorf("%v: want storage class %q, got %q", test.h, test.class, class)
+		}
+	}
+}
@@ -40,6 +40,9 @@ type Backend struct {
 	bucket       *storage.BucketHandle
 	prefix       string
 	listMaxItems int
+
+	storageClass     string
+	dataStorageClass string
 	layout.Layout
 }
 
@@ -109,6 +112,9 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 			Join: path.Join,
 		},
 		listMaxItems: defaultListMaxItems,
+
+		storageClass:     strings.ToUpper(cfg.StorageClass),
+		dataStorageClass: strings.ToUpper(cfg.DataStorageClass),
 	}
 
 	return be, nil
@@ -192,6 +198,15 @@ func (be *Backend) Path() string {
 	return be.prefix
 }
 
+// storageClassFor returns the storage class for new files. Data pack files are
+// rarely read and can use a colder class than all other files.
+func (be *Backend) storageClassFor(h restic.Handle) string {
+	if be.dataStorageClass != "" && h.Type == restic.PackFile && h.ContainedBlobType == restic.DataBlob {
+		return be.dataStorageClass
+	}
+	return be.storageClass
+}
+
 // Save stores data in the backend at the handle.
 func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
 	objName := be.Filename(h)
@@ -224,6 +239,7 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 	w := be.bucket.Object(objName).NewWriter(ctx)
 	w.ChunkSize = 0
 	w.MD5 = rd.Hash()
+	w.StorageClass = be.storageClassFor(h)
 	wbytes, err := io.Copy(w, rd)
 	cerr := w.Close()
 	if err == nil {
@@ -6,6 +6,7 @@ import (
 	"encoding/pem"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -23,8 +24,48 @@ type TransportOptions struct {
 	// contains the name of a file containing the TLS client certificate and private key in PEM format
 	TLSClientCertKeyFilename string
 
+	// contain the names of separate files with the TLS client certificate
+	// and the private key in PEM format, they take precedence over
+	// TLSClientCertKeyFilename
+	TLSClientCertFilename string
+	TLSClientKeyFilename  string
+
 	// Skip TLS certificate verification
 	InsecureTLS bool
+
+	// URL of the proxy to connect through, the scheme can be http, https or
+	// socks5. "direct" disables the proxy. If empty, the proxy is read from
+	// the environment variables HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	Proxy string
+}
+
+// proxyFunc returns the proxy function for the proxy setting s.
+func proxyFunc(s string) (func(*http.Request) (*url.URL, error), error) {
+	switch s {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Errorf("invalid proxy URL %q: %v", s, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	case "socks5h":
+		// host names are always resolved by the SOCKS5 proxy
+		u.Scheme = "socks5"
+	default:
+		return nil, errors.Errorf("invalid proxy URL %q: unsupported scheme %q", s, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("invalid proxy URL %q: host is missing", s)
+	}
+
+	return http.ProxyURL(u), nil
 }
 
 // readPEMCertKey reads a file and returns the PEM encoded certificate and key
@@ -65,9 +106,14 @@ func readPEMCertKey(filename string) (certs []byte, key []byte, err error) {
 // a custom rootCertFilename is non-empty, it must point to a valid PEM file,
 // otherwise the function will return an error.
 func Transport(opts TransportOptions) (http.RoundTripper, error) {
+	proxy, err := proxyFunc(opts.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
 	// copied from net/http
 	tr := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		Proxy: proxy,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
@@ -99,6 +145,18 @@ func Transport(opts TransportOptions) (http.RoundTripper, error) {
 		tr.TLSClientConfig.Certificates = []tls.Certificate{crt}
 	}
 
+	if opts.TLSClientCertFilename != "" || opts.TLSClientKeyFilename != "" {
+		if opts.TLSClientCertFilename == "" || opts.TLSClientKeyFilename == "" {
+			return nil, errors.New("TLS client certificate and key must be specified together")
+		}
+
+		crt, err := tls.LoadX509KeyPair(opts.TLSClientCertFilename, opts.TLSClientKeyFilename)
+		if err != nil {
+			return nil, errors.Errorf("load TLS client cert or key: %v", err)
+		}
+		tr.TLSClientConfig.Certificates = []tls.Certificate{crt}
+	}
+
 	if opts.RootCertFilenames != nil {
 		pool := x509.NewCertPool()
 		for _, filename := range opts.RootCertFilenames {
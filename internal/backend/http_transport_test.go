@@ -0,0 +1,261 @@
+package backend_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// newTestCert returns a certificate signed by parent, or a self-signed CA
+// certificate if parent is nil.
+func newTestCert(t testing.TB, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rtest.OK(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	rtest.OK(t, err)
+	cert, err := x509.ParseCertificate(der)
+	rtest.OK(t, err)
+
+	return &testCert{
+		cert: cert,
+		key:  key,
+		tls:  tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+}
+
+// write stores the certificate and the key in separate PEM files.
+func (c *testCert) write(t testing.TB, dir string) (certFile, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	rtest.OK(t, err)
+
+	certFile = filepath.Join(dir, c.cert.Subject.CommonName+".crt")
+	keyFile = filepath.Join(dir, c.cert.Subject.CommonName+".key")
+	rtest.OK(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	rtest.OK(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestTransportClientCertificate(t *testing.T) {
+	dir := rtest.TempDir(t)
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	client := newTestCert(t, "client", ca)
+	caFile, _ := ca.write(t, dir)
+	certFile, keyFile := client.write(t, dir)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server.tls},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(opts backend.TransportOptions) error {
+		rt, err := backend.Transport(opts)
+		if err != nil {
+			return err
+		}
+		c := http.Client{Transport: rt}
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	rtest.OK(t, get(backend.TransportOptions{
+		RootCertFilenames:     []string{caFile},
+		TLSClientCertFilename: certFile,
+		TLSClientKeyFilename:  keyFile,
+	}))
+
+	err := get(backend.TransportOptions{RootCertFilenames: []string{caFile}})
+	rtest.Assert(t, err != nil, "request without client certificate succeeded")
+
+	err = get(backend.TransportOptions{TLSClientCertFilename: certFile, TLSClientKeyFilename: keyFile})
+	rtest.Assert(t, err != nil, "server certificate was accepted without the CA")
+
+	_, err = backend.Transport(backend.TransportOptions{TLSClientCertFilename: certFile})
+	rtest.Assert(t, err != nil, "missing error for certificate without key")
+}
+
+// serveSOCKS5 implements the CONNECT command of a SOCKS5 proxy without
+// authentication and sends each requested address to addrs.
+func serveSOCKS5(t testing.TB, l net.Listener, addrs chan<- string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			// greeting: version, number of methods, methods
+			buf := make([]byte, 262)
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+				return
+			}
+			if _, err := conn.Write([]byte{5, 0}); err != nil {
+				return
+			}
+
+			// request: version, command, reserved, address type
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+				return
+			}
+			var host string
+			switch buf[3] {
+			case 1:
+				if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+					return
+				}
+				host = net.IP(buf[:4]).String()
+			case 3:
+				if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+					return
+				}
+				n := int(buf[0])
+				if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+					return
+				}
+				host = string(buf[:n])
+			default:
+				t.Errorf("unsupported address type %d", buf[3])
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+			addrs <- addr
+
+			target, err := net.Dial("tcp", addr)
+			if err != nil {
+				_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer func() {
+				_ = target.Close()
+			}()
+			if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+				return
+			}
+
+			go func() {
+				_, _ = io.Copy(target, conn)
+			}()
+			_, _ = io.Copy(conn, target)
+		}()
+	}
+}
+
+func TestTransportProxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("direct"))
+	}))
+	defer srv.Close()
+
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the proxy receives the absolute URL
+		if r.URL.String() != srv.URL+"/" {
+			t.Errorf("unexpected URL requested from proxy: %v", r.URL)
+		}
+		_, _ = w.Write([]byte("http proxy"))
+	}))
+	defer httpProxy.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	rtest.OK(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+	addrs := make(chan string, 10)
+	go serveSOCKS5(t, l, addrs)
+
+	get := func(proxy string) string {
+		rt, err := backend.Transport(backend.TransportOptions{Proxy: proxy})
+		rtest.OK(t, err)
+		c := http.Client{Transport: rt}
+		resp, err := c.Get(srv.URL + "/")
+		rtest.OK(t, err)
+		buf, err := io.ReadAll(resp.Body)
+		rtest.OK(t, err)
+		rtest.OK(t, resp.Body.Close())
+		return string(buf)
+	}
+
+	rtest.Equals(t, "direct", get("direct"))
+	rtest.Equals(t, "http proxy", get(httpProxy.URL))
+
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		rtest.Equals(t, "direct", get(scheme+"://"+l.Addr().String()))
+		rtest.Equals(t, srv.Listener.Addr().String(), <-addrs)
+	}
+}
+
+func TestTransportInvalidProxy(t *testing.T) {
+	for _, proxy := range []string{
+		"ftp://proxy:21",
+		"socks5://",
+		"proxy.example.com:1080",
+		"http://proxy:port",
+	} {
+		_, err := backend.Transport(backend.TransportOptions{Proxy: proxy})
+		rtest.Assert(t, err != nil, "missing error for proxy %q", proxy)
+	}
+}
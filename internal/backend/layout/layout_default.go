@@ -16,11 +16,18 @@ type DefaultLayout struct {
 }
 
 var defaultLayoutPaths = map[restic.FileType]string{
-	restic.PackFile:     "data",
-	restic.SnapshotFile: "snapshots",
-	restic.IndexFile:    "index",
-	restic.LockFile:     "locks",
-	restic.KeyFile:      "keys",
+	restic.PackFile:        "data",
+	restic.SnapshotFile:    "snapshots",
+	restic.IndexFile:       "index",
+	restic.LockFile:        "locks",
+	restic.KeyFile:         "keys",
+	restic.AuditFile:       "audit",
+	restic.CatalogFile:     "catalog",
+	restic.ParityFile:      "parity",
+	restic.ScrubFile:       "scrub",
+	restic.DataKeyFile:     "datakeys",
+	restic.SearchIndexFile: "searchindex",
+	restic.AuditHeadFile:   "audithead",
 }
 
 func (l *DefaultLayout) String() string {
@@ -11,11 +11,18 @@ type S3LegacyLayout struct {
 }
 
 var s3LayoutPaths = map[restic.FileType]string{
-	restic.PackFile:     "data",
-	restic.SnapshotFile: "snapshot",
-	restic.IndexFile:    "index",
-	restic.LockFile:     "lock",
-	restic.KeyFile:      "key",
+	restic.PackFile:        "data",
+	restic.SnapshotFile:    "snapshot",
+	restic.IndexFile:       "index",
+	restic.LockFile:        "lock",
+	restic.KeyFile:         "key",
+	restic.AuditFile:       "audit",
+	restic.CatalogFile:     "catalog",
+	restic.ParityFile:      "parity",
+	restic.ScrubFile:       "scrub",
+	restic.DataKeyFile:     "datakeys",
+	restic.SearchIndexFile: "searchindex",
+	restic.AuditHeadFile:   "audithead",
 }
 
 func (l *S3LegacyLayout) String() string {
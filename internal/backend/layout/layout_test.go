@@ -110,6 +110,13 @@ func TestDefaultLayout(t *testing.T) {
 			filepath.Join(tempdir, "index"),
 			filepath.Join(tempdir, "locks"),
 			filepath.Join(tempdir, "keys"),
+			filepath.Join(tempdir, "audit"),
+			filepath.Join(tempdir, "catalog"),
+			filepath.Join(tempdir, "parity"),
+			filepath.Join(tempdir, "scrub"),
+			filepath.Join(tempdir, "datakeys"),
+			filepath.Join(tempdir, "searchindex"),
+			filepath.Join(tempdir, "audithead"),
 		}
 
 		for i := 0; i < 256; i++ {
@@ -186,6 +193,13 @@ func TestRESTLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "locks"),
 			filepath.Join(path, "keys"),
+			filepath.Join(path, "audit"),
+			filepath.Join(path, "catalog"),
+			filepath.Join(path, "parity"),
+			filepath.Join(path, "scrub"),
+			filepath.Join(path, "datakeys"),
+			filepath.Join(path, "searchindex"),
+			filepath.Join(path, "audithead"),
 		}
 
 		sort.Strings(want)
@@ -331,6 +345,13 @@ func TestS3LegacyLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "lock"),
 			filepath.Join(path, "key"),
+			filepath.Join(path, "audit"),
+			filepath.Join(path, "catalog"),
+			filepath.Join(path, "parity"),
+			filepath.Join(path, "scrub"),
+			filepath.Join(path, "datakeys"),
+			filepath.Join(path, "searchindex"),
+			filepath.Join(path, "audithead"),
 		}
 
 		sort.Strings(want)
@@ -0,0 +1,223 @@
+package limiter
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/ratelimit"
+	"github.com/restic/restic/internal/errors"
+)
+
+// SchedulePeriod contains the limits which apply during a time of the day.
+// From and To are offsets from midnight. A period with To before From spans
+// midnight, a period with From equal to To lasts the whole day.
+type SchedulePeriod struct {
+	From, To time.Duration
+	Limits   Limits
+}
+
+// contains returns true if the time of the day tod lies within the period.
+func (p SchedulePeriod) contains(tod time.Duration) bool {
+	switch {
+	case p.From == p.To:
+		return true
+	case p.From < p.To:
+		return tod >= p.From && tod < p.To
+	default:
+		return tod >= p.From || tod < p.To
+	}
+}
+
+// Schedule is a list of periods with different bandwidth limits. The first
+// period containing the current time of the day applies.
+type Schedule []SchedulePeriod
+
+// ParseSchedule parses a comma separated list of periods with the format
+// "HH:MM-HH:MM=rate" or "HH:MM-HH:MM=upload/download". A rate is given in
+// KiB/s, the suffixes K, M and G select KiB/s, MiB/s and GiB/s. Zero means
+// unlimited.
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		times, rates, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid period %q, expected HH:MM-HH:MM=rate", item)
+		}
+		from, to, ok := strings.Cut(times, "-")
+		if !ok {
+			return nil, errors.Errorf("invalid period %q, expected HH:MM-HH:MM=rate", item)
+		}
+
+		var p SchedulePeriod
+		var err error
+		if p.From, err = parseTimeOfDay(from); err != nil {
+			return nil, err
+		}
+		if p.To, err = parseTimeOfDay(to); err != nil {
+			return nil, err
+		}
+
+		up, down, ok := strings.Cut(rates, "/")
+		if !ok {
+			down = up
+		}
+		if p.Limits.UploadKb, err = parseRate(up); err != nil {
+			return nil, err
+		}
+		if p.Limits.DownloadKb, err = parseRate(down); err != nil {
+			return nil, err
+		}
+
+		schedule = append(schedule, p)
+	}
+
+	return schedule, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseRate returns rate in KiB/s.
+func parseRate(rate string) (int, error) {
+	s := strings.TrimSpace(rate)
+	scale := 1
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			s = s[:len(s)-1]
+		case 'm', 'M':
+			scale = 1024
+			s = s[:len(s)-1]
+		case 'g', 'G':
+			scale = 1024 * 1024
+			s = s[:len(s)-1]
+		}
+	}
+
+	val, err := strconv.Atoi(s)
+	if err != nil || val < 0 {
+		return 0, errors.Errorf("invalid rate %q", rate)
+	}
+	return val * scale, nil
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// LimitsAt returns the limits which apply at time t. If no period contains t,
+// def is returned.
+func (s Schedule) LimitsAt(t time.Time, def Limits) Limits {
+	tod := timeOfDay(t)
+	for _, p := range s {
+		if p.contains(tod) {
+			return p.Limits
+		}
+	}
+	return def
+}
+
+type scheduledLimiter struct {
+	schedule Schedule
+	periods  []staticLimiter
+	def      staticLimiter
+	now      func() time.Time
+}
+
+// NewScheduledLimiter returns a Limiter which applies the limits of the
+// schedule period containing the current time of the day, and the limits
+// def outside of all periods. The limits are checked for each read and
+// write, so long running transfers switch to the new limits when the period
+// changes.
+func NewScheduledLimiter(schedule Schedule, def Limits) Limiter {
+	l := &scheduledLimiter{
+		schedule: schedule,
+		def:      newStaticLimiter(def),
+		now:      time.Now,
+	}
+	for _, p := range schedule {
+		l.periods = append(l.periods, newStaticLimiter(p.Limits))
+	}
+	return l
+}
+
+// current returns the limiter for the current time of the day.
+func (l *scheduledLimiter) current() staticLimiter {
+	tod := timeOfDay(l.now())
+	for i, p := range l.schedule {
+		if p.contains(tod) {
+			return l.periods[i]
+		}
+	}
+	return l.def
+}
+
+func (l *scheduledLimiter) upstream() *ratelimit.Bucket {
+	return l.current().upstream
+}
+
+func (l *scheduledLimiter) downstream() *ratelimit.Bucket {
+	return l.current().downstream
+}
+
+func (l *scheduledLimiter) Upstream(r io.Reader) io.Reader {
+	return &scheduledReader{r: r, bucket: l.upstream}
+}
+
+func (l *scheduledLimiter) UpstreamWriter(w io.Writer) io.Writer {
+	return &scheduledWriter{w: w, bucket: l.upstream}
+}
+
+func (l *scheduledLimiter) Downstream(r io.Reader) io.Reader {
+	return &scheduledReader{r: r, bucket: l.downstream}
+}
+
+func (l *scheduledLimiter) DownstreamWriter(w io.Writer) io.Writer {
+	return &scheduledWriter{w: w, bucket: l.downstream}
+}
+
+// Transport returns an HTTP transport limited with the limiter l.
+func (l *scheduledLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
+	return limitTransport(l, rt)
+}
+
+// scheduledReader limits each read using the bucket returned by bucket.
+type scheduledReader struct {
+	r      io.Reader
+	bucket func() *ratelimit.Bucket
+}
+
+func (r *scheduledReader) Read(p []byte) (int, error) {
+	b := r.bucket()
+	if b == nil {
+		return r.r.Read(p)
+	}
+	return ratelimit.Reader(r.r, b).Read(p)
+}
+
+// scheduledWriter limits each write using the bucket returned by bucket.
+type scheduledWriter struct {
+	w      io.Writer
+	bucket func() *ratelimit.Bucket
+}
+
+func (w *scheduledWriter) Write(p []byte) (int, error) {
+	b := w.bucket()
+	if b == nil {
+		return w.w.Write(p)
+	}
+	return ratelimit.Writer(w.w, b).Write(p)
+}
@@ -0,0 +1,75 @@
+package limiter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("08:00-18:00=5M, 18:00-08:00=0, 12:30-13:00=512/2g")
+	rtest.OK(t, err)
+	rtest.Equals(t, Schedule{
+		{From: 8 * time.Hour, To: 18 * time.Hour, Limits: Limits{UploadKb: 5 * 1024, DownloadKb: 5 * 1024}},
+		{From: 18 * time.Hour, To: 8 * time.Hour, Limits: Limits{}},
+		{From: 12*time.Hour + 30*time.Minute, To: 13 * time.Hour, Limits: Limits{UploadKb: 512, DownloadKb: 2 * 1024 * 1024}},
+	}, s)
+
+	for _, invalid := range []string{
+		"08:00-18:00",
+		"08:00=5M",
+		"8-18=5M",
+		"08:00-25:00=5M",
+		"08:00-18:00=fast",
+		"08:00-18:00=-1",
+		"08:00-18:00=1M/",
+	} {
+		_, err := ParseSchedule(invalid)
+		rtest.Assert(t, err != nil, "missing error for %q", invalid)
+	}
+}
+
+func TestScheduleLimitsAt(t *testing.T) {
+	s, err := ParseSchedule("08:00-18:00=100,22:00-06:00=200")
+	rtest.OK(t, err)
+	def := Limits{UploadKb: 1}
+
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.Local)
+	for _, test := range []struct {
+		at   time.Duration
+		want Limits
+	}{
+		{7*time.Hour + 59*time.Minute, def},
+		{8 * time.Hour, Limits{100, 100}},
+		{17*time.Hour + 59*time.Minute + 59*time.Second, Limits{100, 100}},
+		{18 * time.Hour, def},
+		{23 * time.Hour, Limits{200, 200}},
+		{3 * time.Hour, Limits{200, 200}},
+		{6 * time.Hour, def},
+	} {
+		rtest.Equals(t, test.want, s.LimitsAt(day.Add(test.at), def))
+	}
+}
+
+func TestScheduledLimiter(t *testing.T) {
+	s, err := ParseSchedule("08:00-18:00=100/0")
+	rtest.OK(t, err)
+	l := NewScheduledLimiter(s, Limits{}).(*scheduledLimiter)
+
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.Local)
+	l.now = func() time.Time { return now }
+	rtest.Assert(t, l.upstream() != nil, "upload is not limited during the period")
+	rtest.Assert(t, l.downstream() == nil, "download is limited during the period")
+
+	now = now.Add(8 * time.Hour)
+	rtest.Assert(t, l.upstream() == nil, "upload is limited outside of the period")
+
+	var buf bytes.Buffer
+	data := []byte("foobar")
+	n, err := l.UpstreamWriter(&buf).Write(data)
+	rtest.OK(t, err)
+	rtest.Equals(t, len(data), n)
+	rtest.Equals(t, data, buf.Bytes())
+}
@@ -22,6 +22,10 @@ type Limits struct {
 // NewStaticLimiter constructs a Limiter with a fixed (static) upload and
 // download rate cap
 func NewStaticLimiter(l Limits) Limiter {
+	return newStaticLimiter(l)
+}
+
+func newStaticLimiter(l Limits) staticLimiter {
 	var (
 		upstreamBucket   *ratelimit.Bucket
 		downstreamBucket *ratelimit.Bucket
@@ -63,7 +67,15 @@ func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return rt(req)
 }
 
-func (l staticLimiter) roundTripper(rt http.RoundTripper, req *http.Request) (*http.Response, error) {
+// limitTransport returns an HTTP transport which limits request and response
+// bodies with l.
+func limitTransport(l Limiter, rt http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		return limitRoundTrip(l, rt, req)
+	})
+}
+
+func limitRoundTrip(l Limiter, rt http.RoundTripper, req *http.Request) (*http.Response, error) {
 	type readCloser struct {
 		io.Reader
 		io.Closer
@@ -90,9 +102,7 @@ func (l staticLimiter) roundTripper(rt http.RoundTripper, req *http.Request) (*h
 
 // Transport returns an HTTP transport limited with the limiter l.
 func (l staticLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
-	return roundTripper(func(req *http.Request) (*http.Response, error) {
-		return l.roundTripper(rt, req)
-	})
+	return limitTransport(l, rt)
 }
 
 func (l staticLimiter) limitReader(r io.Reader, b *ratelimit.Bucket) io.Reader {
@@ -13,6 +13,8 @@ type Config struct {
 	Layout string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
 
 	Connections uint `option:"connections" help:"set a limit for the number of concurrent operations (default: 2)"`
+
+	Sync string `option:"sync" help:"flush written files to disk: always, metadata (data files are flushed before index and snapshot files) or never (default: always)"`
 }
 
 // NewConfig returns a new config with default options applied.
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"syscall"
 
 	"github.com/restic/restic/internal/backend"
@@ -23,6 +24,40 @@ type Local struct {
 	Config
 	layout.Layout
 	backend.Modes
+
+	sync syncPolicy
+
+	// unsynced contains the files which were saved without flushing them to
+	// disk, see syncMetadata
+	unsyncedMu sync.Mutex
+	unsynced   []string
+}
+
+// syncPolicy determines which files are flushed to disk after saving them.
+type syncPolicy int
+
+const (
+	// syncAlways flushes each file and its directory.
+	syncAlways syncPolicy = iota
+	// syncMetadata only flushes the repository metadata. Pack files are
+	// flushed before the next index or snapshot file is saved, so that these
+	// never reference data which may be lost on a crash.
+	syncMetadata
+	// syncNever relies on the operating system to write the files to disk.
+	syncNever
+)
+
+func parseSyncPolicy(s string) (syncPolicy, error) {
+	switch s {
+	case "", "always":
+		return syncAlways, nil
+	case "metadata":
+		return syncMetadata, nil
+	case "never":
+		return syncNever, nil
+	default:
+		return 0, errors.Fatalf("invalid local.sync value %q, must be one of always, metadata or never", s)
+	}
 }
 
 // ensure statically that *Local implements restic.Backend.
@@ -31,6 +66,11 @@ var _ restic.Backend = &Local{}
 const defaultLayout = "default"
 
 func open(ctx context.Context, cfg Config) (*Local, error) {
+	policy, err := parseSyncPolicy(cfg.Sync)
+	if err != nil {
+		return nil, err
+	}
+
 	l, err := layout.ParseLayout(ctx, &layout.LocalFilesystem{}, cfg.Layout, defaultLayout, cfg.Path)
 	if err != nil {
 		return nil, err
@@ -44,6 +84,7 @@ func open(ctx context.Context, cfg Config) (*Local, error) {
 		Config: cfg,
 		Layout: l,
 		Modes:  m,
+		sync:   policy,
 	}, nil
 }
 
@@ -116,6 +157,14 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 		}
 	}()
 
+	syncFile := b.sync == syncAlways || (b.sync == syncMetadata && h.Type != restic.PackFile)
+	if b.sync == syncMetadata && (h.Type == restic.IndexFile || h.Type == restic.SnapshotFile) {
+		// write barrier, the pack files must be on disk before they are referenced
+		if err := b.syncUnsynced(); err != nil {
+			return err
+		}
+	}
+
 	// Create new file with a temporary name.
 	tmpname := filepath.Base(finalname) + "-tmp-"
 	f, err := tempFile(dir, tmpname)
@@ -159,10 +208,13 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 	}
 
 	// Ignore error if filesystem does not support fsync.
-	err = f.Sync()
-	syncNotSup := err != nil && (errors.Is(err, syscall.ENOTSUP) || isMacENOTTY(err))
-	if err != nil && !syncNotSup {
-		return errors.WithStack(err)
+	syncNotSup := false
+	if syncFile {
+		err = f.Sync()
+		syncNotSup = err != nil && (errors.Is(err, syscall.ENOTSUP) || isMacENOTTY(err))
+		if err != nil && !syncNotSup {
+			return errors.WithStack(err)
+		}
 	}
 
 	// Close, then rename. Windows doesn't like the reverse order.
@@ -174,11 +226,15 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 	}
 
 	// Now sync the directory to commit the Rename.
-	if !syncNotSup {
+	if syncFile && !syncNotSup {
 		err = fsyncDir(dir)
 		if err != nil {
 			return errors.WithStack(err)
 		}
+	} else if b.sync == syncMetadata {
+		b.unsyncedMu.Lock()
+		b.unsynced = append(b.unsynced, finalname)
+		b.unsyncedMu.Unlock()
 	}
 
 	// try to mark file as read-only to avoid accidential modifications
@@ -194,6 +250,31 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 
 var tempFile = os.CreateTemp // Overridden by test.
 
+// syncUnsynced flushes all files which were saved without syncing them, and
+// their directories.
+func (b *Local) syncUnsynced() error {
+	b.unsyncedMu.Lock()
+	defer b.unsyncedMu.Unlock()
+
+	dirs := make(map[string]struct{})
+	for len(b.unsynced) > 0 {
+		name := b.unsynced[len(b.unsynced)-1]
+		err := fsyncFile(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.WithStack(err)
+		}
+		dirs[filepath.Dir(name)] = struct{}{}
+		b.unsynced = b.unsynced[:len(b.unsynced)-1]
+	}
+
+	for dir := range dirs {
+		if err := fsyncDir(dir); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
 // Load runs fn with a reader that yields the contents of the file at h at the
 // given offset.
 func (b *Local) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
@@ -345,7 +426,7 @@ func (b *Local) Delete(ctx context.Context) error {
 
 // Close closes all open files.
 func (b *Local) Close() error {
-	// this does not need to do anything, all open files are closed within the
-	// same function.
-	return nil
+	// all open files are closed within the same function, only the files
+	// saved without syncing them must be flushed.
+	return b.syncUnsynced()
 }
@@ -40,3 +40,28 @@ func TestNoSpacePermanent(t *testing.T) {
 	rtest.Assert(t, errors.Is(err, syscall.ENOSPC),
 		"could not recover original ENOSPC error")
 }
+
+func TestSyncPolicy(t *testing.T) {
+	_, err := Open(context.Background(), Config{Path: rtest.TempDir(t), Connections: 2, Sync: "sometimes"})
+	rtest.Assert(t, err != nil, "invalid sync policy was accepted")
+
+	be, err := Create(context.Background(), Config{Path: rtest.TempDir(t), Connections: 2, Sync: "metadata"})
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	save := func(tpe restic.FileType) {
+		data := []byte(tpe.String())
+		h := restic.Handle{Type: tpe, Name: restic.Hash(data).String()}
+		rtest.OK(t, be.Save(context.Background(), h, restic.NewByteReader(data, be.Hasher())))
+	}
+
+	save(restic.PackFile)
+	save(restic.LockFile)
+	rtest.Equals(t, 1, len(be.unsynced))
+
+	// saving an index must flush all pack files first
+	save(restic.IndexFile)
+	rtest.Equals(t, 0, len(be.unsynced))
+}
@@ -34,6 +34,26 @@ func fsyncDir(dir string) error {
 	return err
 }
 
+// fsyncFile flushes the content of the file name to disk.
+func fsyncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	if err != nil && (errors.Is(err, syscall.ENOTSUP) || isMacENOTTY(err)) {
+		err = nil
+	}
+
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
 // The ExFAT driver on some versions of macOS can return ENOTTY,
 // "inappropriate ioctl for device", for fsync.
 //
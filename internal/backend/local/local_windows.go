@@ -7,6 +7,22 @@ import (
 // Can't explicitly flush directory changes on Windows.
 func fsyncDir(dir string) error { return nil }
 
+// fsyncFile flushes the content of the file name to disk. Windows requires
+// write access to flush a file.
+func fsyncFile(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
 // Windows is not macOS.
 func isMacENOTTY(err error) bool { return false }
 
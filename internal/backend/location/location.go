@@ -6,13 +6,20 @@ import (
 
 	"github.com/restic/restic/internal/backend/azure"
 	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/failover"
+	"github.com/restic/restic/internal/backend/gdrive"
 	"github.com/restic/restic/internal/backend/gs"
 	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/plugin"
 	"github.com/restic/restic/internal/backend/rclone"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/smb"
+	"github.com/restic/restic/internal/backend/split"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 	"github.com/restic/restic/internal/errors"
 )
 
@@ -37,10 +44,24 @@ var parsers = []parser{
 	{"sftp", sftp.ParseConfig, noPassword},
 	{"s3", s3.ParseConfig, noPassword},
 	{"gs", gs.ParseConfig, noPassword},
+	{"gdrive", gdrive.ParseConfig, noPassword},
 	{"azure", azure.ParseConfig, noPassword},
 	{"swift", swift.ParseConfig, noPassword},
 	{"rest", rest.ParseConfig, rest.StripPassword},
 	{"rclone", rclone.ParseConfig, noPassword},
+	{"webdav", webdav.ParseConfig, webdav.StripPassword},
+	{"smb", smb.ParseConfig, smb.StripPassword},
+	{"plugin", plugin.ParseConfig, noPassword},
+}
+
+func init() {
+	// registered here, as stripping the passwords of nested locations refers
+	// to parsers
+	parsers = append(parsers,
+		parser{"mirror", mirror.ParseConfig, stripMirrorPassword},
+		parser{"failover", failover.ParseConfig, stripFailoverPassword},
+		parser{"split", split.ParseConfig, stripSplitPassword},
+	)
 }
 
 // noPassword returns the repository location unchanged (there's no sensitive information there)
@@ -126,6 +147,45 @@ func StripPassword(s string) string {
 	return s
 }
 
+// stripMirrorPassword strips the passwords of all mirrored locations.
+func stripMirrorPassword(s string) string {
+	cfg, err := mirror.ParseConfig(s)
+	if err != nil {
+		return s
+	}
+
+	locations := cfg.(mirror.Config).Locations
+	for i, loc := range locations {
+		locations[i] = StripPassword(loc)
+	}
+	return "mirror:" + strings.Join(locations, ",")
+}
+
+// stripFailoverPassword strips the passwords of all failover locations.
+func stripFailoverPassword(s string) string {
+	cfg, err := failover.ParseConfig(s)
+	if err != nil {
+		return s
+	}
+
+	locations := cfg.(failover.Config).Locations
+	for i, loc := range locations {
+		locations[i] = StripPassword(loc)
+	}
+	return "failover:" + strings.Join(locations, ",")
+}
+
+// stripSplitPassword strips the passwords of the hot and cold locations.
+func stripSplitPassword(s string) string {
+	cfg, err := split.ParseConfig(s)
+	if err != nil {
+		return s
+	}
+
+	c := cfg.(split.Config)
+	return "split:hot=" + StripPassword(c.Hot) + ",cold=" + StripPassword(c.Cold)
+}
+
 func extractScheme(s string) string {
 	scheme, _, _ := strings.Cut(s, ":")
 	return scheme
@@ -6,11 +6,18 @@ import (
 	"testing"
 
 	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/failover"
+	"github.com/restic/restic/internal/backend/gdrive"
 	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/plugin"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/smb"
+	"github.com/restic/restic/internal/backend/split"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 )
 
 func parseURL(s string) *url.URL {
@@ -297,6 +304,56 @@ var parseTests = []struct {
 			},
 		},
 	},
+	{
+		"webdav:https://cloud.example.com/remote.php/dav/files/foo/restic",
+		Location{Scheme: "webdav",
+			Config: webdav.Config{
+				URL:         parseURL("https://cloud.example.com/remote.php/dav/files/foo/restic/"),
+				Connections: 5,
+			},
+		},
+	},
+	{
+		"smb://fileserver/backup/restic", Location{Scheme: "smb",
+			Config: smb.Config{
+				Host:        "fileserver",
+				Share:       "backup",
+				Path:        "restic",
+				Connections: 5,
+			},
+		},
+	},
+	{
+		"mirror:/srv/repo,sftp:user@host:/srv/repo", Location{Scheme: "mirror",
+			Config: mirror.Config{
+				Locations: []string{"/srv/repo", "sftp:user@host:/srv/repo"},
+			},
+		},
+	},
+	{
+		"failover:sftp:user@nas:/srv/repo,s3:s3.amazonaws.com/bucket", Location{Scheme: "failover",
+			Config: failover.Config{
+				Locations: []string{"sftp:user@nas:/srv/repo", "s3:s3.amazonaws.com/bucket"},
+			},
+		},
+	},
+	{
+		"plugin:tape:library1/pool2", Location{Scheme: "plugin",
+			Config: plugin.Config{
+				Name:        "tape",
+				Remote:      "library1/pool2",
+				Connections: 1,
+			},
+		},
+	},
+	{
+		"split:hot=/srv/repo,cold=s3:s3.amazonaws.com/bucket", Location{Scheme: "split",
+			Config: split.Config{
+				Hot:  "/srv/repo",
+				Cold: "s3:s3.amazonaws.com/bucket",
+			},
+		},
+	},
 	{
 		"b2:bucketname:/prefix", Location{Scheme: "b2",
 			Config: b2.Config{
@@ -306,6 +363,14 @@ var parseTests = []struct {
 			},
 		},
 	},
+	{
+		"gdrive:backups/restic", Location{Scheme: "gdrive",
+			Config: gdrive.Config{
+				Path:        "backups/restic",
+				Connections: 5,
+			},
+		},
+	},
 	{
 		"b2:bucketname", Location{Scheme: "b2",
 			Config: b2.Config{
@@ -0,0 +1,231 @@
+// Package metrics records statistics about the requests sent to backends.
+package metrics
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// Collector gathers the statistics of all backends wrapped using New. It is
+// safe for concurrent use.
+type Collector struct {
+	mu       sync.Mutex
+	backends map[string]map[string]*operation
+	order    []string
+	retries  map[string]uint64
+}
+
+type operation struct {
+	requests  uint64
+	errors    uint64
+	bytes     uint64
+	latencies []time.Duration
+}
+
+// NewCollector returns an empty Collector.
+func NewCollector() *Collector {
+	return &Collector{
+		backends: make(map[string]map[string]*operation),
+		retries:  make(map[string]uint64),
+	}
+}
+
+func (c *Collector) record(backend, op string, d time.Duration, bytes uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ops, ok := c.backends[backend]
+	if !ok {
+		ops = make(map[string]*operation)
+		c.backends[backend] = ops
+		c.order = append(c.order, backend)
+	}
+	o, ok := ops[op]
+	if !ok {
+		o = &operation{}
+		ops[op] = o
+	}
+
+	o.requests++
+	o.bytes += bytes
+	o.latencies = append(o.latencies, d)
+	if err != nil {
+		o.errors++
+	}
+}
+
+// AddRetry records that the operation op was retried.
+func (c *Collector) AddRetry(op string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retries[strings.ToLower(op)]++
+}
+
+// OperationSummary contains the statistics of one type of request.
+type OperationSummary struct {
+	Operation string
+	Requests  uint64
+	Errors    uint64
+	// Bytes is the amount of data saved or loaded.
+	Bytes uint64
+
+	LatencyP50 time.Duration
+	LatencyP90 time.Duration
+	LatencyP99 time.Duration
+	LatencyMax time.Duration
+}
+
+// BackendSummary contains the statistics of a backend.
+type BackendSummary struct {
+	Name       string
+	Operations []OperationSummary
+}
+
+// Summary returns the statistics of each backend in the order in which the
+// backends were first used.
+func (c *Collector) Summary() []BackendSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	summaries := make([]BackendSummary, 0, len(c.order))
+	for _, name := range c.order {
+		ops := c.backends[name]
+		summary := BackendSummary{Name: name}
+		for op, o := range ops {
+			latencies := append([]time.Duration(nil), o.latencies...)
+			sort.Slice(latencies, func(i, j int) bool {
+				return latencies[i] < latencies[j]
+			})
+
+			summary.Operations = append(summary.Operations, OperationSummary{
+				Operation:  op,
+				Requests:   o.requests,
+				Errors:     o.errors,
+				Bytes:      o.bytes,
+				LatencyP50: percentile(latencies, 50),
+				LatencyP90: percentile(latencies, 90),
+				LatencyP99: percentile(latencies, 99),
+				LatencyMax: latencies[len(latencies)-1],
+			})
+		}
+		sort.Slice(summary.Operations, func(i, j int) bool {
+			return summary.Operations[i].Operation < summary.Operations[j].Operation
+		})
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// Retries returns the number of retries for each operation.
+func (c *Collector) Retries() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	retries := make(map[string]uint64, len(c.retries))
+	for op, n := range c.retries {
+		retries[op] = n
+	}
+	return retries
+}
+
+// percentile returns the p-th percentile of the sorted list using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (len(sorted)*p + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Backend records the requests to the wrapped backend.
+type Backend struct {
+	restic.Backend
+	name      string
+	collector *Collector
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// New wraps be so that all requests are recorded in c under the given name.
+func New(be restic.Backend, c *Collector, name string) *Backend {
+	return &Backend{Backend: be, name: name, collector: c}
+}
+
+// Save stores the data in the backend under the given handle.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	start := time.Now()
+	err := be.Backend.Save(ctx, h, rd)
+
+	var bytes uint64
+	if err == nil {
+		bytes = uint64(rd.Length())
+	}
+	be.collector.record(be.name, "save", time.Since(start), bytes, err)
+	return err
+}
+
+// Load runs fn with a reader that yields the contents of the file at h. The
+// latency includes the time spent in fn.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	start := time.Now()
+	var bytes uint64
+	err := be.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		cr := &countingReader{Reader: rd}
+		err := fn(cr)
+		bytes += cr.n
+		return err
+	})
+	be.collector.record(be.name, "load", time.Since(start), bytes, err)
+	return err
+}
+
+// Stat returns information about the file identified by h.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	start := time.Now()
+	fi, err := be.Backend.Stat(ctx, h)
+
+	// a missing file is an answer, not a failed request
+	reqErr := err
+	if be.Backend.IsNotExist(err) {
+		reqErr = nil
+	}
+	be.collector.record(be.name, "stat", time.Since(start), 0, reqErr)
+	return fi, err
+}
+
+// List runs fn for each file in the backend which has the type t.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	start := time.Now()
+	err := be.Backend.List(ctx, t, fn)
+	be.collector.record(be.name, "list", time.Since(start), 0, err)
+	return err
+}
+
+// Remove removes the file with the given handle.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	start := time.Now()
+	err := be.Backend.Remove(ctx, h)
+	be.collector.record(be.name, "remove", time.Since(start), 0, err)
+	return err
+}
+
+func (be *Backend) Unwrap() restic.Backend { return be.Backend }
+
+type countingReader struct {
+	io.Reader
+	n uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += uint64(n)
+	return n, err
+}
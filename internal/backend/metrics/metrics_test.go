@@ -0,0 +1,83 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/metrics"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestBackendMetrics(t *testing.T) {
+	c := metrics.NewCollector()
+	be := metrics.New(mem.New(), c, "mem")
+
+	data := rtest.Random(23, 1000)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data, be.Hasher())))
+
+	for i := 0; i < 3; i++ {
+		rtest.OK(t, be.Load(context.TODO(), h, 100, 0, func(rd io.Reader) error {
+			_, err := io.Copy(io.Discard, rd)
+			return err
+		}))
+	}
+
+	_, err := be.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "missing"})
+	rtest.Assert(t, be.IsNotExist(err), "unexpected error %v", err)
+	err = be.Remove(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "missing"})
+	rtest.Assert(t, err != nil, "missing error")
+
+	c.AddRetry("Save")
+
+	summary := c.Summary()
+	rtest.Equals(t, 1, len(summary))
+	rtest.Equals(t, "mem", summary[0].Name)
+
+	ops := make(map[string]metrics.OperationSummary)
+	for _, op := range summary[0].Operations {
+		ops[op.Operation] = op
+		rtest.Assert(t, op.LatencyP50 <= op.LatencyP90 && op.LatencyP90 <= op.LatencyP99 && op.LatencyP99 <= op.LatencyMax,
+			"latency percentiles of %v are not ordered: %v", op.Operation, op)
+	}
+	rtest.Equals(t, 4, len(ops))
+	rtest.Equals(t, metrics.OperationSummary{Operation: "save", Requests: 1, Bytes: 1000}, withoutLatency(ops["save"]))
+	rtest.Equals(t, metrics.OperationSummary{Operation: "load", Requests: 3, Bytes: 300}, withoutLatency(ops["load"]))
+	rtest.Equals(t, metrics.OperationSummary{Operation: "stat", Requests: 1}, withoutLatency(ops["stat"]))
+	rtest.Equals(t, metrics.OperationSummary{Operation: "remove", Requests: 1, Errors: 1}, withoutLatency(ops["remove"]))
+
+	rtest.Equals(t, map[string]uint64{"save": 1}, c.Retries())
+}
+
+func withoutLatency(op metrics.OperationSummary) metrics.OperationSummary {
+	op.LatencyP50, op.LatencyP90, op.LatencyP99, op.LatencyMax = 0, 0, 0, 0
+	return op
+}
+
+func TestPercentiles(t *testing.T) {
+	c := metrics.NewCollector()
+	be := metrics.New(&slowBackend{Backend: mem.New()}, c, "slow")
+	for i := 1; i <= 100; i++ {
+		_ = be.Remove(context.WithValue(context.TODO(), delayKey{}, time.Duration(i)*100*time.Microsecond), restic.Handle{Type: restic.PackFile, Name: "x"})
+	}
+
+	op := c.Summary()[0].Operations[0]
+	rtest.Assert(t, op.LatencyP50 >= 5*time.Millisecond && op.LatencyP50 < 6*time.Millisecond, "wrong p50 %v", op.LatencyP50)
+	rtest.Assert(t, op.LatencyP99 >= 9900*time.Microsecond && op.LatencyP99 < op.LatencyMax, "wrong p99 %v", op.LatencyP99)
+	rtest.Assert(t, op.LatencyMax >= 10*time.Millisecond, "wrong max %v", op.LatencyMax)
+}
+
+type delayKey struct{}
+
+type slowBackend struct {
+	restic.Backend
+}
+
+func (be *slowBackend) Remove(ctx context.Context, h restic.Handle) error {
+	time.Sleep(ctx.Value(delayKey{}).(time.Duration))
+	return nil
+}
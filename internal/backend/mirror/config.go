@@ -0,0 +1,37 @@
+package mirror
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Config contains the locations of the mirrored backends.
+type Config struct {
+	Locations []string
+}
+
+// ParseConfig parses the string s and extracts the locations of the mirrors.
+// The supported configuration format is mirror:location1,location2[,...].
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "mirror:") {
+		return nil, errors.New(`invalid format, does not start with "mirror:"`)
+	}
+
+	var cfg Config
+	for _, loc := range strings.Split(s[len("mirror:"):], ",") {
+		if loc == "" {
+			return nil, errors.New("mirror: empty location")
+		}
+		if strings.HasPrefix(loc, "mirror:") {
+			return nil, errors.New("mirror: nested mirrors are not supported")
+		}
+		cfg.Locations = append(cfg.Locations, loc)
+	}
+
+	if len(cfg.Locations) < 2 {
+		return nil, errors.New("mirror: at least two locations are required")
+	}
+
+	return cfg, nil
+}
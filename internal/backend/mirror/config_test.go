@@ -0,0 +1,51 @@
+package mirror
+
+import (
+	"reflect"
+	"testing"
+)
+
+var configTests = []struct {
+	s   string
+	cfg Config
+}{
+	{"mirror:/srv/repo,s3:s3.amazonaws.com/bucket", Config{
+		Locations: []string{"/srv/repo", "s3:s3.amazonaws.com/bucket"},
+	}},
+	{"mirror:local:/a,local:/b,sftp:host:/c", Config{
+		Locations: []string{"local:/a", "local:/b", "sftp:host:/c"},
+	}},
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, test := range configTests {
+		t.Run("", func(t *testing.T) {
+			cfg, err := ParseConfig(test.s)
+			if err != nil {
+				t.Fatalf("%s failed: %v", test.s, err)
+			}
+
+			if !reflect.DeepEqual(cfg, test.cfg) {
+				t.Fatalf("input: %s\n wrong config, want:\n  %v\ngot:\n  %v",
+					test.s, test.cfg, cfg)
+			}
+		})
+	}
+}
+
+var invalidConfigTests = []string{
+	"mirror:/srv/repo",
+	"mirror:/srv/repo,",
+	"mirror:/srv/repo,mirror:/a,/b",
+	"local:/srv/repo",
+}
+
+func TestParseInvalidConfig(t *testing.T) {
+	for _, s := range invalidConfigTests {
+		t.Run("", func(t *testing.T) {
+			if _, err := ParseConfig(s); err == nil {
+				t.Fatalf("expected error for %q", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+// Package mirror implements a backend which stores every file in two or more
+// other backends. Files are read from the first backend which provides them.
+package mirror
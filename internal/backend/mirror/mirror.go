@@ -0,0 +1,236 @@
+package mirror
+
+import (
+	"context"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend stores files in all mirrors and reads them from the first mirror
+// which returns them successfully.
+type Backend struct {
+	mirrors []restic.Backend
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// New returns a backend which mirrors all files to the given backends. The
+// order of mirrors determines the order in which they are tried when reading.
+func New(mirrors []restic.Backend) *Backend {
+	debug.Log("created new mirror backend with %d mirrors", len(mirrors))
+	return &Backend{mirrors: mirrors}
+}
+
+// Mirrors returns the mirrored backends.
+func (be *Backend) Mirrors() []restic.Backend {
+	return be.mirrors
+}
+
+// Location returns the locations of all mirrors.
+func (be *Backend) Location() string {
+	locations := make([]string, 0, len(be.mirrors))
+	for _, m := range be.mirrors {
+		locations = append(locations, m.Location())
+	}
+	return "mirror:" + strings.Join(locations, ",")
+}
+
+// Connections returns the lowest number of connections of all available mirrors.
+func (be *Backend) Connections() uint {
+	var conns uint
+	for _, m := range be.mirrors {
+		if _, ok := m.(*unavailable); ok {
+			continue
+		}
+		if c := m.Connections(); conns == 0 || c < conns {
+			conns = c
+		}
+	}
+	if conns == 0 {
+		conns = 1
+	}
+	return conns
+}
+
+// Hasher returns nil, the mirrors may use different hash functions. Save
+// computes the hash for each mirror separately.
+func (be *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// HasAtomicReplace returns whether all mirrors can atomically replace files.
+func (be *Backend) HasAtomicReplace() bool {
+	for _, m := range be.mirrors {
+		if !m.HasAtomicReplace() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file in
+// one of the mirrors.
+func (be *Backend) IsNotExist(err error) bool {
+	for _, m := range be.mirrors {
+		if m.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Save stores the file in all mirrors. It fails if the file could not be
+// saved to one of them.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	for i, m := range be.mirrors {
+		if i > 0 {
+			if err := rd.Rewind(); err != nil {
+				return err
+			}
+		}
+
+		mrd, err := backend.WithHash(rd, m.Hasher())
+		if err != nil {
+			return err
+		}
+
+		if err := m.Save(ctx, h, mrd); err != nil {
+			debug.Log("saving %v to %v failed: %v", h, m.Location(), err)
+			return errors.Wrapf(err, "mirror %v", m.Location())
+		}
+	}
+	return nil
+}
+
+// selectError returns the error to report after all mirrors failed. A
+// not-exist error is only returned if the file is missing in all mirrors,
+// otherwise it could still be stored in an unreachable mirror.
+func (be *Backend) selectError(errs []error) error {
+	for i, err := range errs {
+		if !be.mirrors[i].IsNotExist(err) {
+			return err
+		}
+	}
+	return errs[0]
+}
+
+// Load runs fn with a reader for the file from the first mirror for which
+// both loading the file and fn succeed.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	errs := make([]error, 0, len(be.mirrors))
+	for _, m := range be.mirrors {
+		err := m.Load(ctx, h, length, offset, fn)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		debug.Log("loading %v from %v failed: %v", h, m.Location(), err)
+		errs = append(errs, err)
+	}
+	return be.selectError(errs)
+}
+
+// Stat returns information about the file from the first mirror which has it.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	errs := make([]error, 0, len(be.mirrors))
+	for _, m := range be.mirrors {
+		fi, err := m.Stat(ctx, h)
+		if err == nil {
+			return fi, nil
+		}
+		if ctx.Err() != nil {
+			return restic.FileInfo{}, ctx.Err()
+		}
+		errs = append(errs, err)
+	}
+	return restic.FileInfo{}, be.selectError(errs)
+}
+
+// Remove removes the file from all mirrors. Mirrors which do not contain the
+// file are ignored, unless none of them contains it.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	var firstErr error
+	missing := 0
+	for _, m := range be.mirrors {
+		err := m.Remove(ctx, h)
+		if err != nil && m.IsNotExist(err) {
+			missing++
+			if missing == len(be.mirrors) {
+				return err
+			}
+			continue
+		}
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "mirror %v", m.Location())
+		}
+	}
+	return firstErr
+}
+
+// List runs fn for each file which is stored in at least one mirror. Mirrors
+// which cannot be listed are skipped, unless all of them fail.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	seen := make(map[string]struct{})
+	var fnErr error
+	errs := make([]error, 0, len(be.mirrors))
+
+	for _, m := range be.mirrors {
+		err := m.List(ctx, t, func(fi restic.FileInfo) error {
+			if _, ok := seen[fi.Name]; ok {
+				return nil
+			}
+			seen[fi.Name] = struct{}{}
+
+			fnErr = fn(fi)
+			return fnErr
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			debug.Log("listing %v in %v failed: %v", t, m.Location(), err)
+		}
+		errs = append(errs, err)
+	}
+
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return be.selectError(errs)
+}
+
+// Delete removes all data in all mirrors.
+func (be *Backend) Delete(ctx context.Context) error {
+	var firstErr error
+	for _, m := range be.mirrors {
+		if err := m.Delete(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close closes all mirrors.
+func (be *Backend) Close() error {
+	var firstErr error
+	for _, m := range be.mirrors {
+		if err := m.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,197 @@
+package mirror_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type mirrorConfig struct {
+	be restic.Backend
+}
+
+func newTestSuite() *test.Suite {
+	return &test.Suite{
+		NewConfig: func() (interface{}, error) {
+			return &mirrorConfig{}, nil
+		},
+
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*mirrorConfig)
+			if c.be != nil {
+				_, err := c.be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+				if err != nil && !c.be.IsNotExist(err) {
+					return nil, err
+				}
+
+				if err == nil {
+					return nil, errors.New("config already exists")
+				}
+			}
+
+			c.be = mirror.New([]restic.Backend{mem.New(), mem.New()})
+			return c.be, nil
+		},
+
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*mirrorConfig)
+			if c.be == nil {
+				c.be = mirror.New([]restic.Backend{mem.New(), mem.New()})
+			}
+			return c.be, nil
+		},
+
+		Cleanup: func(cfg interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestSuiteBackendMirror(t *testing.T) {
+	newTestSuite().RunTests(t)
+}
+
+func save(t *testing.T, be restic.Backend, data string) restic.Handle {
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash([]byte(data)).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte(data), be.Hasher())))
+	return h
+}
+
+func load(t *testing.T, be restic.Backend, h restic.Handle) string {
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	return string(buf)
+}
+
+func list(t *testing.T, be restic.Backend) []string {
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	return names
+}
+
+func TestMirrorSaveAll(t *testing.T) {
+	m1, m2 := mem.New(), mem.New()
+	be := mirror.New([]restic.Backend{m1, m2})
+
+	h := save(t, be, "foo")
+	rtest.Equals(t, "foo", load(t, m1, h))
+	rtest.Equals(t, "foo", load(t, m2, h))
+}
+
+func TestMirrorReadFallback(t *testing.T) {
+	m1, m2 := mem.New(), mem.New()
+	be := mirror.New([]restic.Backend{m1, m2})
+
+	h1 := save(t, be, "foo")
+	rtest.OK(t, m1.Remove(context.TODO(), h1))
+	h2 := save(t, m1, "bar")
+
+	rtest.Equals(t, "foo", load(t, be, h1))
+	rtest.Equals(t, "bar", load(t, be, h2))
+	rtest.Equals(t, 2, len(list(t, be)))
+
+	// a file which is missing in all mirrors
+	h3 := restic.Handle{Type: restic.PackFile, Name: restic.Hash([]byte("baz")).String()}
+	_, err := be.Stat(context.TODO(), h3)
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+	err = be.Remove(context.TODO(), h3)
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+
+	// removing a file stored in only one mirror succeeds
+	rtest.OK(t, be.Remove(context.TODO(), h2))
+	rtest.Equals(t, 1, len(list(t, be)))
+}
+
+func TestMirrorUnavailable(t *testing.T) {
+	m1 := mem.New()
+	h := save(t, m1, "foo")
+
+	be := mirror.New([]restic.Backend{mirror.Unavailable("nas", errors.New("connection refused")), m1})
+
+	rtest.Equals(t, "foo", load(t, be, h))
+	rtest.Equals(t, 1, len(list(t, be)))
+
+	// the file could still exist in the unavailable mirror
+	_, err := be.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "missing"})
+	rtest.Assert(t, err != nil && !be.IsNotExist(err), "expected error of unavailable mirror, got %v", err)
+
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo"), be.Hasher()))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "connection refused"), "unexpected error %v", err)
+
+	err = be.Repair(context.TODO(), false, func(restic.Handle, string, error) {})
+	rtest.Assert(t, err != nil, "repair with unavailable mirror did not fail")
+}
+
+func TestMirrorRepair(t *testing.T) {
+	ctx := context.TODO()
+	m1, m2, m3 := mem.New(), mem.New(), mem.New()
+	be := mirror.New([]restic.Backend{m1, m2, m3})
+
+	config := restic.Handle{Type: restic.ConfigFile}
+	rtest.OK(t, m1.Save(ctx, config, restic.NewByteReader([]byte("config"), m1.Hasher())))
+
+	foo := save(t, be, "foo")
+	bar := save(t, m2, "bar")
+	rtest.OK(t, m1.Remove(ctx, foo))
+
+	// m3 contains a truncated copy of foo, m1 a damaged copy of baz
+	rtest.OK(t, m3.Remove(ctx, foo))
+	rtest.OK(t, m3.Save(ctx, foo, restic.NewByteReader([]byte("fo"), m3.Hasher())))
+	baz := save(t, m2, "baz")
+	rtest.OK(t, m1.Save(ctx, baz, restic.NewByteReader([]byte("bad!"), m1.Hasher())))
+
+	// a file without an intact copy
+	broken := restic.Handle{Type: restic.IndexFile, Name: restic.Hash([]byte("index")).String()}
+	rtest.OK(t, m3.Save(ctx, broken, restic.NewByteReader([]byte("xxx"), m3.Hasher())))
+
+	copies := make(map[string]int)
+	var failed []restic.Handle
+	report := func(h restic.Handle, dst string, err error) {
+		if err != nil {
+			failed = append(failed, h)
+			return
+		}
+		copies[h.Type.String()+"/"+h.Name]++
+	}
+
+	rtest.OK(t, be.Repair(ctx, true, report))
+	rtest.Equals(t, 2, copies["config/"])
+	rtest.Equals(t, 1, len(list(t, m1)))
+	fi, err := m1.Stat(ctx, baz)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(4), fi.Size)
+
+	copies = make(map[string]int)
+	failed = nil
+	rtest.OK(t, be.Repair(ctx, false, report))
+	rtest.Equals(t, 2, copies["config/"])
+	rtest.Equals(t, 2, copies["data/"+foo.Name])
+	rtest.Equals(t, 2, copies["data/"+bar.Name])
+	rtest.Equals(t, 2, copies["data/"+baz.Name])
+	rtest.Equals(t, []restic.Handle{broken}, failed)
+
+	for _, m := range []restic.Backend{m1, m2, m3} {
+		rtest.Equals(t, "config", load(t, m, config))
+		rtest.Equals(t, "foo", load(t, m, foo))
+		rtest.Equals(t, "bar", load(t, m, bar))
+		rtest.Equals(t, "baz", load(t, m, baz))
+	}
+
+	// nothing left to do, except for the broken file
+	copies = make(map[string]int)
+	failed = nil
+	rtest.OK(t, be.Repair(ctx, false, report))
+	rtest.Equals(t, 0, len(copies))
+	rtest.Equals(t, []restic.Handle{broken}, failed)
+}
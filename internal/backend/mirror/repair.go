@@ -0,0 +1,191 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// repairTypes lists the file types in the order in which they are repaired.
+// Pack files are copied before the index and snapshot files referring to
+// them, so that an interrupted repair leaves no dangling references behind.
+var repairTypes = []restic.FileType{
+	restic.ConfigFile,
+	restic.KeyFile,
+	restic.PackFile,
+	restic.IndexFile,
+	restic.SnapshotFile,
+	restic.AuditFile,
+	restic.CatalogFile,
+	restic.ParityFile,
+	restic.ScrubFile,
+	restic.DataKeyFile,
+	restic.SearchIndexFile,
+	restic.AuditHeadFile,
+}
+
+// Repair copies files which are missing in one of the mirrors, or whose size
+// differs from the intact copy, from another mirror. The content of a file is
+// verified against its name before it is copied. Lock files are not copied.
+// For each file which is copied to the mirror at location dst, report is
+// called with a nil error. Files without an intact copy are reported with an
+// error. If dryRun is set, the mirrors are not modified.
+func (be *Backend) Repair(ctx context.Context, dryRun bool, report func(h restic.Handle, dst string, err error)) error {
+	for _, m := range be.mirrors {
+		if u, ok := m.(*unavailable); ok {
+			return errors.Errorf("mirror %v is unavailable: %v", u.location, u.err)
+		}
+	}
+
+	for _, t := range repairTypes {
+		sizes, err := be.listSizes(ctx, t)
+		if err != nil {
+			return err
+		}
+
+		names := make(map[string]struct{})
+		for _, files := range sizes {
+			for name := range files {
+				names[name] = struct{}{}
+			}
+		}
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		for _, name := range sorted {
+			h := restic.Handle{Type: t, Name: name}
+			if err := be.repairFile(ctx, h, sizes, dryRun, report); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// listSizes returns the names and sizes of all files of type t in each mirror.
+func (be *Backend) listSizes(ctx context.Context, t restic.FileType) ([]map[string]int64, error) {
+	sizes := make([]map[string]int64, len(be.mirrors))
+	for i, m := range be.mirrors {
+		files := make(map[string]int64)
+		sizes[i] = files
+
+		if t == restic.ConfigFile {
+			fi, err := m.Stat(ctx, restic.Handle{Type: t})
+			if err != nil && !m.IsNotExist(err) {
+				return nil, errors.Wrapf(err, "mirror %v", m.Location())
+			}
+			if err == nil {
+				files[""] = fi.Size
+			}
+			continue
+		}
+
+		err := m.List(ctx, t, func(fi restic.FileInfo) error {
+			files[fi.Name] = fi.Size
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "mirror %v", m.Location())
+		}
+	}
+	return sizes, nil
+}
+
+func (be *Backend) repairFile(ctx context.Context, h restic.Handle, sizes []map[string]int64, dryRun bool, report func(h restic.Handle, dst string, err error)) error {
+	var candidates []restic.Backend
+	missing, sizeMismatch := false, false
+	var size int64 = -1
+	for i, m := range be.mirrors {
+		s, ok := sizes[i][h.Name]
+		if !ok {
+			missing = true
+			continue
+		}
+		if size >= 0 && s != size {
+			sizeMismatch = true
+		}
+		size = s
+		candidates = append(candidates, m)
+	}
+	if !missing && !sizeMismatch {
+		return nil
+	}
+
+	// the content of the config file cannot be verified
+	if h.Type == restic.ConfigFile && sizeMismatch {
+		report(h, "", errors.New("config file differs between mirrors"))
+		return nil
+	}
+
+	for _, src := range candidates {
+		buf, err := loadVerified(ctx, src, h)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			debug.Log("copy of %v in %v is damaged: %v", h, src.Location(), err)
+			continue
+		}
+
+		for i, dst := range be.mirrors {
+			s, ok := sizes[i][h.Name]
+			if ok && s == int64(len(buf)) {
+				continue
+			}
+			if !dryRun {
+				if err := copyFile(ctx, dst, h, buf, ok); err != nil {
+					return errors.Wrapf(err, "mirror %v", dst.Location())
+				}
+			}
+			report(h, dst.Location(), nil)
+		}
+		return nil
+	}
+
+	report(h, "", errors.New("no intact copy found in any mirror"))
+	return nil
+}
+
+// loadVerified loads the file h from be. Unless h is the config file, the
+// content must match the name of the file.
+func loadVerified(ctx context.Context, be restic.Backend, h restic.Handle) ([]byte, error) {
+	var buf []byte
+	err := be.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		var err error
+		buf, err = io.ReadAll(rd)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Type == restic.ConfigFile {
+		return buf, nil
+	}
+
+	id, err := restic.ParseID(h.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !restic.Hash(buf).Equal(id) {
+		return nil, errors.New("content does not match the file name")
+	}
+	return buf, nil
+}
+
+func copyFile(ctx context.Context, dst restic.Backend, h restic.Handle, buf []byte, exists bool) error {
+	if exists {
+		if err := dst.Remove(ctx, h); err != nil {
+			return err
+		}
+	}
+	return dst.Save(ctx, h, restic.NewByteReader(buf, dst.Hasher()))
+}
@@ -0,0 +1,48 @@
+package mirror
+
+import (
+	"context"
+	"hash"
+	"io"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/restic/restic/internal/restic"
+)
+
+// unavailable represents a mirror which could not be opened.
+type unavailable struct {
+	location string
+	err      error
+}
+
+// Unavailable returns a placeholder for a mirror at location which could not
+// be opened. All operations on it fail with err, which is not retried.
+func Unavailable(location string, err error) restic.Backend {
+	return &unavailable{location: location, err: backoff.Permanent(err)}
+}
+
+func (be *unavailable) Location() string       { return be.location }
+func (be *unavailable) Connections() uint      { return 1 }
+func (be *unavailable) Hasher() hash.Hash      { return nil }
+func (be *unavailable) HasAtomicReplace() bool { return true }
+func (be *unavailable) IsNotExist(error) bool  { return false }
+func (be *unavailable) Close() error           { return nil }
+
+func (be *unavailable) Remove(context.Context, restic.Handle) error { return be.err }
+func (be *unavailable) Delete(context.Context) error                { return be.err }
+
+func (be *unavailable) Save(context.Context, restic.Handle, restic.RewindReader) error {
+	return be.err
+}
+
+func (be *unavailable) Load(context.Context, restic.Handle, int, int64, func(io.Reader) error) error {
+	return be.err
+}
+
+func (be *unavailable) Stat(context.Context, restic.Handle) (restic.FileInfo, error) {
+	return restic.FileInfo{}, be.err
+}
+
+func (be *unavailable) List(context.Context, restic.FileType, func(restic.FileInfo) error) error {
+	return be.err
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to start a backend plugin.
+type Config struct {
+	Name        string
+	Remote      string
+	Program     string `option:"program"     help:"path to the plugin program (default: restic-backend-<name>)"`
+	Args        string `option:"args"        help:"additional arguments passed to the plugin program before the remote"`
+	Connections uint   `option:"connections" help:"set a limit for the number of plugin processes (default: 1)"`
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 1,
+	}
+}
+
+func init() {
+	options.Register("plugin", Config{})
+}
+
+// ParseConfig parses the string s and extracts the name of the plugin and the
+// remote passed to it. The supported configuration format is
+// plugin:<name>:<remote>, the remote is interpreted by the plugin only.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "plugin:") {
+		return nil, errors.New(`invalid format, does not start with "plugin:"`)
+	}
+
+	name, remote, _ := strings.Cut(s[len("plugin:"):], ":")
+	if name == "" {
+		return nil, errors.New("plugin: name of the plugin is missing")
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return nil, errors.Errorf("plugin: invalid name %q, use -o plugin.program to specify a path", name)
+	}
+
+	cfg := NewConfig()
+	cfg.Name = name
+	cfg.Remote = remote
+	return cfg, nil
+}
+
+// program returns the program to start, which is restic-backend-<name> unless
+// the program was specified explicitly.
+func (cfg Config) program() string {
+	if cfg.Program != "" {
+		return cfg.Program
+	}
+	return "restic-backend-" + cfg.Name
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	var tests = []struct {
+		s   string
+		cfg Config
+	}{
+		{"plugin:tape:library1/pool2", Config{
+			Name:        "tape",
+			Remote:      "library1/pool2",
+			Connections: 1,
+		}},
+		{"plugin:objstore:https://store.example.com:9000/bucket", Config{
+			Name:        "objstore",
+			Remote:      "https://store.example.com:9000/bucket",
+			Connections: 1,
+		}},
+		{"plugin:custom", Config{
+			Name:        "custom",
+			Connections: 1,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			cfg, err := ParseConfig(test.s)
+			if err != nil {
+				t.Fatalf("%s failed: %v", test.s, err)
+			}
+
+			if !reflect.DeepEqual(cfg, test.cfg) {
+				t.Fatalf("wrong config, want:\n  %#v\ngot:\n  %#v", test.cfg, cfg)
+			}
+		})
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, s := range []string{
+		"plugin:",
+		"plugin::remote",
+		"plugin:../bin/tape:remote",
+		"rclone:remote:path",
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := ParseConfig(s)
+			if err == nil {
+				t.Fatalf("expected error for %q", s)
+			}
+		})
+	}
+}
+
+func TestProgram(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Name = "tape"
+	if p := cfg.program(); p != "restic-backend-tape" {
+		t.Fatalf("wrong program %q", p)
+	}
+
+	cfg.Program = "/opt/tape/bin/restic-tape"
+	if p := cfg.program(); p != "/opt/tape/bin/restic-tape" {
+		t.Fatalf("wrong program %q", p)
+	}
+}
@@ -0,0 +1,5 @@
+// Package plugin implements a backend which delegates all operations to an
+// external program. The program is started by restic and receives requests on
+// stdin, the responses are read from its stdout. The protocol is described in
+// the file protocol.go, the function Serve implements the program side.
+package plugin
@@ -0,0 +1,375 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend passes all operations to processes of a plugin program.
+type Backend struct {
+	cfg           Config
+	atomicReplace bool
+
+	m      sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// conn is a running plugin process.
+type conn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	rd     *bufio.Reader
+	wr     *bufio.Writer
+	stderr sync.WaitGroup
+
+	// broken is set if the state of the connection is unknown, for example
+	// because a response was not read completely.
+	broken bool
+}
+
+// notExistError is returned if the plugin reports that a file does not exist.
+type notExistError struct {
+	msg string
+}
+
+func (e *notExistError) Error() string {
+	return e.msg
+}
+
+// Open starts the plugin and opens the backend.
+func Open(ctx context.Context, cfg Config) (*Backend, error) {
+	return open(ctx, cfg, false)
+}
+
+// Create starts the plugin and creates a new backend.
+func Create(ctx context.Context, cfg Config) (*Backend, error) {
+	return open(ctx, cfg, true)
+}
+
+func open(ctx context.Context, cfg Config, create bool) (*Backend, error) {
+	debug.Log("open plugin %v, remote %v", cfg.program(), cfg.Remote)
+	if cfg.Connections == 0 {
+		cfg.Connections = 1
+	}
+
+	be := &Backend{cfg: cfg}
+	c, resp, err := be.start(ctx, create)
+	if err != nil {
+		return nil, err
+	}
+	be.atomicReplace = resp.AtomicReplace
+	be.put(c)
+	return be, nil
+}
+
+// start runs a new plugin process and sends the open request.
+func (be *Backend) start(ctx context.Context, create bool) (*conn, Response, error) {
+	args, err := backend.SplitShellStrings(be.cfg.program())
+	if err != nil {
+		return nil, Response{}, err
+	}
+	if be.cfg.Args != "" {
+		a, err := backend.SplitShellStrings(be.cfg.Args)
+		if err != nil {
+			return nil, Response{}, err
+		}
+		args = append(args, a...)
+	}
+	args = append(args, be.cfg.Remote)
+
+	debug.Log("running command: %v", args)
+	cmd := exec.Command(args[0], args[1:]...)
+	c := &conn{cmd: cmd}
+
+	c.stdin, err = cmd.StdinPipe()
+	if err != nil {
+		return nil, Response{}, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, Response{}, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, Response{}, err
+	}
+	c.rd = bufio.NewReader(stdout)
+	c.wr = bufio.NewWriter(c.stdin)
+
+	bg, err := backend.StartForeground(cmd)
+	if err != nil {
+		if backend.IsErrDot(err) {
+			return nil, Response{}, errors.Errorf("cannot implicitly run relative executable %v found in current directory, use -o plugin.program=./<program> to override", cmd.Path)
+		}
+		return nil, Response{}, err
+	}
+
+	// add a prefix to all messages printed to stderr by the plugin
+	c.stderr.Add(1)
+	go func() {
+		defer c.stderr.Done()
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			fmt.Fprintf(os.Stderr, "plugin %v: %v\n", be.cfg.Name, sc.Text())
+		}
+	}()
+
+	resp, err := be.roundtrip(ctx, c, Request{Op: "open", Version: ProtocolVersion, Create: create}, nil)
+	if err == nil {
+		err = bg()
+	}
+	if err == nil && resp.Version != ProtocolVersion {
+		err = errors.Errorf("plugin speaks protocol version %d, expected %d", resp.Version, ProtocolVersion)
+	}
+	if err != nil {
+		c.kill()
+		return nil, Response{}, errors.Wrap(err, "starting plugin")
+	}
+
+	return c, resp, nil
+}
+
+// kill stops the plugin process without waiting for the current request.
+func (c *conn) kill() {
+	_ = c.stdin.Close()
+	if c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+	}
+	c.stderr.Wait()
+	_ = c.cmd.Wait()
+}
+
+// close asks the plugin process to exit by closing its stdin.
+func (c *conn) close() error {
+	err := c.stdin.Close()
+	c.stderr.Wait()
+	if werr := c.cmd.Wait(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// get returns an idle plugin process, or starts a new one.
+func (be *Backend) get(ctx context.Context) (*conn, error) {
+	be.m.Lock()
+	if be.closed {
+		be.m.Unlock()
+		return nil, errors.New("backend is closed")
+	}
+	if n := len(be.idle); n > 0 {
+		c := be.idle[n-1]
+		be.idle = be.idle[:n-1]
+		be.m.Unlock()
+		return c, nil
+	}
+	be.m.Unlock()
+
+	c, _, err := be.start(ctx, false)
+	return c, err
+}
+
+// put returns c to the idle processes. Broken processes are stopped.
+func (be *Backend) put(c *conn) {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if c.broken || be.closed || uint(len(be.idle)) >= be.cfg.Connections {
+		go c.kill()
+		return
+	}
+	be.idle = append(be.idle, c)
+}
+
+// roundtrip sends the request, followed by the payload of a save request, and
+// reads the header of the response. The process is killed if the context is
+// canceled before the response header has been received.
+func (be *Backend) roundtrip(ctx context.Context, c *conn, req Request, payload io.Reader) (Response, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	// only save requests carry a payload
+	var n int64
+	if req.Op == "save" {
+		n = req.Length
+	}
+
+	var resp Response
+	err := writeMessage(c.wr, req, payload, n)
+	if err == nil {
+		err = readMessage(c.rd, &resp)
+	}
+	if ctx.Err() != nil {
+		c.broken = true
+	}
+	if err != nil {
+		c.broken = true
+		if ctx.Err() != nil {
+			return Response{}, ctx.Err()
+		}
+		return Response{}, errors.Wrap(err, "plugin connection")
+	}
+
+	if resp.Error != "" {
+		if resp.Length > 0 {
+			// the payload of failed requests is not expected
+			c.broken = true
+		}
+		if resp.NotExist {
+			return resp, &notExistError{msg: resp.Error}
+		}
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// do runs the request using an idle plugin process. If fn is not nil, it is
+// called with a reader for the payload of the response.
+func (be *Backend) do(ctx context.Context, req Request, payload io.Reader, fn func(rd io.Reader) error) (Response, error) {
+	c, err := be.get(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	defer be.put(c)
+
+	resp, err := be.roundtrip(ctx, c, req, payload)
+	if err != nil || resp.Length == 0 && fn == nil {
+		return resp, err
+	}
+	if fn == nil {
+		c.broken = true
+		return resp, errors.Errorf("unexpected payload in response to %v request", req.Op)
+	}
+
+	rd := io.LimitReader(c.rd, resp.Length)
+	err = fn(rd)
+
+	// the remaining payload must be consumed to read the next response
+	if _, derr := io.Copy(io.Discard, rd); derr != nil {
+		c.broken = true
+		if err == nil {
+			err = derr
+		}
+	}
+	return resp, err
+}
+
+// Location returns the name of the plugin and the remote.
+func (be *Backend) Location() string {
+	return "plugin:" + be.cfg.Name + ":" + be.cfg.Remote
+}
+
+// Connections returns the number of concurrent plugin processes.
+func (be *Backend) Connections() uint {
+	return be.cfg.Connections
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (be *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// HasAtomicReplace returns whether the plugin reported that it can atomically
+// replace files.
+func (be *Backend) HasAtomicReplace() bool {
+	return be.atomicReplace
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	var e *notExistError
+	return errors.As(err, &e)
+}
+
+// Save stores the data in the backend under the given handle.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	req := Request{Op: "save", Type: h.Type.String(), Name: h.Name, Length: rd.Length()}
+	_, err := be.do(ctx, req, rd, nil)
+	return err
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	req := Request{Op: "load", Type: h.Type.String(), Name: h.Name, Offset: offset, Length: int64(length)}
+
+	_, err := be.do(ctx, req, nil, fn)
+	return err
+}
+
+// Stat returns information about a file in the backend.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	resp, err := be.do(ctx, Request{Op: "stat", Type: h.Type.String(), Name: h.Name}, nil, nil)
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+	return restic.FileInfo{Size: resp.Size, Name: h.Name}, nil
+}
+
+// Remove removes the file from the backend.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	_, err := be.do(ctx, Request{Op: "remove", Type: h.Type.String(), Name: h.Name}, nil, nil)
+	return err
+}
+
+// List runs fn for each file of type t in the backend.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	resp, err := be.do(ctx, Request{Op: "list", Type: t.String()}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range resp.Files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := fn(restic.FileInfo{Name: f.Name, Size: f.Size}); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Delete removes all data in the backend.
+func (be *Backend) Delete(ctx context.Context) error {
+	_, err := be.do(ctx, Request{Op: "delete"}, nil, nil)
+	return err
+}
+
+// Close stops all plugin processes.
+func (be *Backend) Close() error {
+	be.m.Lock()
+	be.closed = true
+	idle := be.idle
+	be.idle = nil
+	be.m.Unlock()
+
+	var firstErr error
+	for _, c := range idle {
+		if err := c.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,97 @@
+package plugin_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/plugin"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// TestHelperProcess is not a real test, the test binary is started as a
+// plugin which serves a local backend in the directory passed as remote.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	dir := os.Args[len(os.Args)-1]
+	err := plugin.Serve(context.Background(), os.Stdin, os.Stdout, func(ctx context.Context, create bool) (restic.Backend, error) {
+		cfg := local.NewConfig()
+		cfg.Path = dir
+		if create {
+			return local.Create(ctx, cfg)
+		}
+		return local.Open(ctx, cfg)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func newTestConfig(dir string) plugin.Config {
+	cfg := plugin.NewConfig()
+	cfg.Name = "test"
+	cfg.Program = os.Args[0]
+	cfg.Args = "-test.run=^TestHelperProcess$ --"
+	cfg.Remote = dir
+	cfg.Connections = 2
+	return cfg
+}
+
+func newTestSuite(t testing.TB) *test.Suite {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	return &test.Suite{
+		NewConfig: func() (interface{}, error) {
+			cfg := newTestConfig(rtest.TempDir(t))
+			return cfg, nil
+		},
+
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			return plugin.Create(context.TODO(), cfg.(plugin.Config))
+		},
+
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			return plugin.Open(context.TODO(), cfg.(plugin.Config))
+		},
+
+		Cleanup: func(cfg interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestBackendPlugin(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+func TestPluginMissingProgram(t *testing.T) {
+	cfg := newTestConfig(rtest.TempDir(t))
+	cfg.Program = "restic-backend-does-not-exist"
+	cfg.Args = ""
+
+	_, err := plugin.Open(context.TODO(), cfg)
+	rtest.Assert(t, err != nil, "expected error for missing plugin program")
+}
+
+func TestPluginOpenMissingRepository(t *testing.T) {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	// the local backend does not create directories when opening
+	be, err := plugin.Open(context.TODO(), newTestConfig(rtest.TempDir(t)))
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+}
@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ProtocolVersion is the version of the plugin protocol implemented here.
+//
+// The plugin program is started with the remote as its last argument. restic
+// sends requests to its stdin and reads a response for each request from its
+// stdout. Only one request is in flight per process, restic starts additional
+// processes for concurrent operations. The program must exit when stdin is
+// closed. Messages written to stderr are shown to the user.
+//
+// Each request and each response consists of a header, which is a JSON object
+// on a single line terminated by a newline, optionally followed by a payload
+// of exactly the number of bytes given in the "length" field of the header.
+// Only save requests and load responses carry a payload. File types are
+// encoded as "data", "key", "lock", "snapshot", "index", "config", "audit",
+// "catalog", "parity", "scrub", "datakey" or "searchindex".
+//
+//	open    {"op":"open","version":1,"create":bool}
+//	        -> {"version":1,"atomic_replace":bool}
+//	stat    {"op":"stat","type":"data","name":"..."} -> {"size":n}
+//	save    {"op":"save","type":"data","name":"...","length":n} + payload -> {}
+//	load    {"op":"load","type":"data","name":"...","offset":n,"length":n}
+//	        -> {"length":n} + payload
+//	remove  {"op":"remove","type":"data","name":"..."} -> {}
+//	list    {"op":"list","type":"data"} -> {"files":[{"name":"...","size":n}]}
+//	delete  {"op":"delete"} -> {}
+//
+// The open request is always the first one, with create set when the
+// repository is initialized. A length of zero in a load request requests the
+// remainder of the file. Failed requests are answered with {"error":"..."},
+// which additionally contains "not_exist":true if the file does not exist.
+const ProtocolVersion = 1
+
+// Request is a request sent to the plugin program.
+type Request struct {
+	Op      string `json:"op"`
+	Version int    `json:"version,omitempty"`
+	Create  bool   `json:"create,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Offset  int64  `json:"offset,omitempty"`
+	Length  int64  `json:"length,omitempty"`
+}
+
+// Response is the answer of the plugin program to a request.
+type Response struct {
+	Error         string `json:"error,omitempty"`
+	NotExist      bool   `json:"not_exist,omitempty"`
+	Version       int    `json:"version,omitempty"`
+	AtomicReplace bool   `json:"atomic_replace,omitempty"`
+	Size          int64  `json:"size,omitempty"`
+	Length        int64  `json:"length,omitempty"`
+	Files         []File `json:"files,omitempty"`
+}
+
+// File describes a file returned by a list request.
+type File struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+var fileTypes = []restic.FileType{
+	restic.PackFile,
+	restic.KeyFile,
+	restic.LockFile,
+	restic.SnapshotFile,
+	restic.IndexFile,
+	restic.ConfigFile,
+	restic.AuditFile,
+	restic.CatalogFile,
+	restic.ParityFile,
+	restic.ScrubFile,
+	restic.DataKeyFile,
+	restic.SearchIndexFile,
+	restic.AuditHeadFile,
+}
+
+// parseFileType returns the file type encoded as s.
+func parseFileType(s string) (restic.FileType, error) {
+	for _, t := range fileTypes {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, errors.Errorf("invalid file type %q", s)
+}
+
+// writeMessage writes the header msg followed by n bytes read from payload.
+func writeMessage(wr *bufio.Writer, msg interface{}, payload io.Reader, n int64) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	if _, err := wr.Write(buf); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		copied, err := io.CopyN(wr, payload, n)
+		if err != nil {
+			return errors.Wrapf(err, "payload truncated after %d of %d bytes", copied, n)
+		}
+	}
+	return wr.Flush()
+}
+
+// readMessage reads a header into msg.
+func readMessage(rd *bufio.Reader, msg interface{}) error {
+	buf, err := rd.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(buf) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return errors.Wrap(json.Unmarshal(buf, msg), "invalid message")
+}
@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Serve implements the program side of the plugin protocol. It reads requests
+// from r and writes the responses to w until r is closed. The backend passed
+// to the requests is returned by open, which is called for the open request.
+// Serve allows running an existing backend as a plugin, for example in tests.
+func Serve(ctx context.Context, r io.Reader, w io.Writer, open func(ctx context.Context, create bool) (restic.Backend, error)) error {
+	rd := bufio.NewReader(r)
+	wr := bufio.NewWriter(w)
+
+	var be restic.Backend
+	defer func() {
+		if be != nil {
+			_ = be.Close()
+		}
+	}()
+
+	for {
+		var req Request
+		err := readMessage(rd, &req)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// the payload of a save request must be read in any case
+		var payload []byte
+		if req.Op == "save" && req.Length > 0 {
+			payload = make([]byte, req.Length)
+			if _, err := io.ReadFull(rd, payload); err != nil {
+				return errors.Wrap(err, "reading payload")
+			}
+		}
+
+		var resp Response
+		var data []byte
+		if req.Op == "open" {
+			be, err = serveOpen(ctx, req, be, open, &resp)
+		} else if be == nil {
+			err = errors.New("backend has not been opened")
+		} else {
+			data, err = serveRequest(ctx, be, req, payload, &resp)
+		}
+
+		if err != nil {
+			resp = Response{Error: err.Error(), NotExist: be != nil && be.IsNotExist(err)}
+			data = nil
+		}
+		resp.Length = int64(len(data))
+		if err := writeMessage(wr, resp, bytes.NewReader(data), resp.Length); err != nil {
+			return err
+		}
+	}
+}
+
+func serveOpen(ctx context.Context, req Request, be restic.Backend, open func(ctx context.Context, create bool) (restic.Backend, error), resp *Response) (restic.Backend, error) {
+	if req.Version != ProtocolVersion {
+		return be, errors.Errorf("unsupported protocol version %d, expected %d", req.Version, ProtocolVersion)
+	}
+	if be != nil {
+		return be, errors.New("backend is already open")
+	}
+
+	be, err := open(ctx, req.Create)
+	if err != nil {
+		return nil, err
+	}
+	resp.Version = ProtocolVersion
+	resp.AtomicReplace = be.HasAtomicReplace()
+	return be, nil
+}
+
+// serveRequest runs the request on be and fills in resp. The returned data is
+// sent as the payload of the response.
+func serveRequest(ctx context.Context, be restic.Backend, req Request, payload []byte, resp *Response) ([]byte, error) {
+	if req.Op == "delete" {
+		return nil, be.Delete(ctx)
+	}
+
+	t, err := parseFileType(req.Type)
+	if err != nil {
+		return nil, err
+	}
+	h := restic.Handle{Type: t, Name: req.Name}
+
+	switch req.Op {
+	case "stat":
+		fi, err := be.Stat(ctx, h)
+		resp.Size = fi.Size
+		return nil, err
+
+	case "save":
+		return nil, be.Save(ctx, h, restic.NewByteReader(payload, be.Hasher()))
+
+	case "load":
+		var data []byte
+		err := be.Load(ctx, h, int(req.Length), req.Offset, func(rd io.Reader) error {
+			var err error
+			data, err = io.ReadAll(rd)
+			return err
+		})
+		return data, err
+
+	case "remove":
+		return nil, be.Remove(ctx, h)
+
+	case "list":
+		resp.Files = []File{}
+		return nil, be.List(ctx, t, func(fi restic.FileInfo) error {
+			resp.Files = append(resp.Files, File{Name: fi.Name, Size: fi.Size})
+			return nil
+		})
+	}
+
+	return nil, errors.Errorf("unknown operation %q", req.Op)
+}
@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/options"
 )
@@ -11,7 +12,12 @@ import (
 // Config contains all configuration necessary to connect to a REST server.
 type Config struct {
 	URL         *url.URL
-	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Proxy       string `option:"proxy" help:"proxy URL for this backend (http, https or socks5), or 'direct' to not use a proxy (default: --proxy)"`
+
+	ClientCert string `option:"client-cert" help:"path to a PEM encoded TLS client certificate, which may also contain the private key"`
+	ClientKey  string `option:"client-key" help:"path to the PEM encoded private key of the TLS client certificate"`
+	CACert     string `option:"cacert" help:"only trust server certificates issued by the PEM encoded CA certificate in this file"`
 }
 
 func init() {
@@ -25,6 +31,35 @@ func NewConfig() Config {
 	}
 }
 
+// HasTLSOptions returns true if the TLS settings of the connection are
+// configured for the REST server.
+func (cfg Config) HasTLSOptions() bool {
+	return cfg.ClientCert != "" || cfg.ClientKey != "" || cfg.CACert != ""
+}
+
+// TransportOptions returns opts with the TLS settings of the REST server
+// applied.
+func (cfg Config) TransportOptions(opts backend.TransportOptions) backend.TransportOptions {
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		opts.TLSClientCertKeyFilename = ""
+		opts.TLSClientCertFilename = ""
+		opts.TLSClientKeyFilename = ""
+		if cfg.ClientKey == "" {
+			// the certificate file contains the key as well
+			opts.TLSClientCertKeyFilename = cfg.ClientCert
+		} else {
+			opts.TLSClientCertFilename = cfg.ClientCert
+			opts.TLSClientKeyFilename = cfg.ClientKey
+		}
+	}
+
+	if cfg.CACert != "" {
+		// the system certificates are not trusted anymore
+		opts.RootCertFilenames = []string{cfg.CACert}
+	}
+	return opts
+}
+
 // ParseConfig parses the string s and extracts the REST server URL.
 func ParseConfig(s string) (interface{}, error) {
 	if !strings.HasPrefix(s, "rest:") {
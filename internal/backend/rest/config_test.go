@@ -4,6 +4,8 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+
+	"github.com/restic/restic/internal/backend"
 )
 
 func parseURL(s string) *url.URL {
@@ -50,3 +52,39 @@ func TestParseConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestTransportOptions(t *testing.T) {
+	base := backend.TransportOptions{
+		RootCertFilenames:        []string{"/etc/ca.pem"},
+		TLSClientCertKeyFilename: "/etc/global.pem",
+	}
+
+	var tests = []struct {
+		cfg  Config
+		want backend.TransportOptions
+	}{
+		{Config{}, base},
+		{
+			Config{ClientCert: "client.pem"},
+			backend.TransportOptions{
+				RootCertFilenames:        []string{"/etc/ca.pem"},
+				TLSClientCertKeyFilename: "client.pem",
+			},
+		},
+		{
+			Config{ClientCert: "client.crt", ClientKey: "client.key", CACert: "server-ca.pem"},
+			backend.TransportOptions{
+				RootCertFilenames:     []string{"server-ca.pem"},
+				TLSClientCertFilename: "client.crt",
+				TLSClientKeyFilename:  "client.key",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := test.cfg.TransportOptions(base)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("wrong transport options for %+v, want\n  %+v\ngot\n  %+v", test.cfg, test.want, got)
+		}
+	}
+}
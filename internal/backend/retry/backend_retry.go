@@ -15,9 +15,9 @@ import (
 // backoff.
 type Backend struct {
 	restic.Backend
-	MaxTries int
-	Report   func(string, error, time.Duration)
-	Success  func(string, int)
+	Config
+	Report  func(string, error, time.Duration)
+	Success func(string, int)
 }
 
 // statically ensure that RetryBackend implements restic.Backend.
@@ -28,11 +28,18 @@ var _ restic.Backend = &Backend{}
 // success is called with the number of retries before a successful operation
 // (it is not called if it succeeded on the first try)
 func New(be restic.Backend, maxTries int, report func(string, error, time.Duration), success func(string, int)) *Backend {
+	cfg := NewConfig()
+	cfg.MaxTries = maxTries
+	return NewWithConfig(be, cfg, report, success)
+}
+
+// NewWithConfig is like New, but uses the retry policy cfg.
+func NewWithConfig(be restic.Backend, cfg Config, report func(string, error, time.Duration), success func(string, int)) *Backend {
 	return &Backend{
-		Backend:  be,
-		MaxTries: maxTries,
-		Report:   report,
-		Success:  success,
+		Backend: be,
+		Config:  cfg,
+		Report:  report,
+		Success: success,
 	}
 }
 
@@ -68,7 +75,7 @@ func (be *Backend) retry(ctx context.Context, msg string, f func() error) error
 		return ctx.Err()
 	}
 
-	bo := backoff.NewExponentialBackOff()
+	bo := be.newBackOff()
 	if fastRetries {
 		// speed up integration tests
 		bo.InitialInterval = 1 * time.Millisecond
@@ -386,3 +386,39 @@ func TestNotifyWithSuccessIsCalled(t *testing.T) {
 		t.Fatalf("Success should have been called only once, but was called %d times instead", successCalled)
 	}
 }
+
+func TestBackendRetryDeadline(t *testing.T) {
+	calls := 0
+	be := &mock.Backend{
+		RemoveFn: func(ctx context.Context, h restic.Handle) error {
+			calls++
+			return errors.New("injected error")
+		},
+	}
+
+	cfg := NewConfig()
+	cfg.MaxTries = 1000
+	cfg.InitialInterval = 5 * time.Millisecond
+	cfg.MaxInterval = 5 * time.Millisecond
+	cfg.MaxElapsedTime = 50 * time.Millisecond
+	cfg.Jitter = 0
+	test.OK(t, cfg.Validate())
+	retryBackend := NewWithConfig(be, cfg, nil, nil)
+
+	err := retryBackend.Remove(context.TODO(), restic.Handle{})
+	test.Assert(t, err != nil, "missing error")
+	test.Assert(t, calls > 1 && calls < 100, "unexpected number of calls %d", calls)
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, modify := range []func(cfg *Config){
+		func(cfg *Config) { cfg.MaxTries = -1 },
+		func(cfg *Config) { cfg.InitialInterval = 0 },
+		func(cfg *Config) { cfg.MaxElapsedTime = -time.Second },
+		func(cfg *Config) { cfg.Jitter = 1.5 },
+	} {
+		cfg := NewConfig()
+		modify(&cfg)
+		test.Assert(t, cfg.Validate() != nil, "missing error for %#v", cfg)
+	}
+}
@@ -0,0 +1,61 @@
+package retry
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains the policy for retrying failed backend operations.
+type Config struct {
+	MaxTries        int           `option:"max-tries" help:"retry failed operations at most this many times (default: 10)"`
+	InitialInterval time.Duration `option:"initial-delay" help:"delay before the first retry (default: 500ms)"`
+	MaxInterval     time.Duration `option:"max-delay" help:"upper limit for the delay between retries (default: 1m)"`
+	MaxElapsedTime  time.Duration `option:"deadline" help:"stop retrying an operation after this time, 0 to retry without a deadline (default: 15m)"`
+	Jitter          float64       `option:"jitter" help:"randomize each delay by up to this fraction, between 0 and 1 (default: 0.5)"`
+}
+
+// NewConfig returns a new config with default options applied.
+func NewConfig() Config {
+	return Config{
+		MaxTries:        10,
+		InitialInterval: backoff.DefaultInitialInterval,
+		MaxInterval:     backoff.DefaultMaxInterval,
+		MaxElapsedTime:  backoff.DefaultMaxElapsedTime,
+		Jitter:          backoff.DefaultRandomizationFactor,
+	}
+}
+
+func init() {
+	options.Register("retry", Config{})
+}
+
+// Validate returns an error if the config contains invalid values.
+func (cfg Config) Validate() error {
+	if cfg.MaxTries < 0 {
+		return errors.Fatalf("retry.max-tries must not be negative")
+	}
+	if cfg.InitialInterval <= 0 || cfg.MaxInterval <= 0 {
+		return errors.Fatalf("retry delays must be positive")
+	}
+	if cfg.MaxElapsedTime < 0 {
+		return errors.Fatalf("retry.deadline must not be negative")
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		return errors.Fatalf("retry.jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// newBackOff returns the backoff for a single operation.
+func (cfg Config) newBackOff() *backoff.ExponentialBackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = cfg.InitialInterval
+	bo.MaxInterval = cfg.MaxInterval
+	bo.MaxElapsedTime = cfg.MaxElapsedTime
+	bo.RandomizationFactor = cfg.Jitter
+	bo.Reset()
+	return bo
+}
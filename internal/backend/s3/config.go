@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/options"
@@ -22,10 +23,18 @@ type Config struct {
 	StorageClass string `option:"storage-class" help:"set S3 storage class (STANDARD, STANDARD_IA, ONEZONE_IA, INTELLIGENT_TIERING or REDUCED_REDUNDANCY)"`
 
 	Connections   uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Proxy         string `option:"proxy" help:"proxy URL for this backend (http, https or socks5), or 'direct' to not use a proxy (default: --proxy)"`
 	MaxRetries    uint   `option:"retries" help:"set the number of retries attempted"`
 	Region        string `option:"region" help:"set region"`
 	BucketLookup  string `option:"bucket-lookup" help:"bucket lookup style: 'auto', 'dns', or 'path'"`
 	ListObjectsV1 bool   `option:"list-objects-v1" help:"use deprecated V1 api for ListObjects calls"`
+
+	DataStorageClass string `option:"data-storage-class" help:"set S3 storage class for data pack files, e.g. GLACIER (default: storage-class)"`
+	StageDays        int    `option:"stage-days" help:"number of days archived files remain readable after staging (default: 1)"`
+	StageTier        string `option:"stage-tier" help:"retrieval tier used to stage archived files, Standard, Bulk or Expedited (default: Standard)"`
+
+	ObjectLockMode      string        `option:"object-lock-mode" help:"protect data, index and snapshot files using S3 Object Lock (GOVERNANCE or COMPLIANCE)"`
+	ObjectLockRetention time.Duration `option:"object-lock-retention" help:"set the Object Lock retention period for new files, e.g. 720h"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
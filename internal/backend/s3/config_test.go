@@ -3,6 +3,7 @@ package s3
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 var configTests = []struct {
@@ -125,3 +126,32 @@ func TestParseError(t *testing.T) {
 		}
 	}
 }
+
+func TestObjectLockMode(t *testing.T) {
+	for _, test := range []struct {
+		mode      string
+		retention time.Duration
+		want      string
+		ok        bool
+	}{
+		{"", 0, "", true},
+		{"governance", 24 * time.Hour, "GOVERNANCE", true},
+		{"COMPLIANCE", time.Hour, "COMPLIANCE", true},
+		{"", time.Hour, "", false},
+		{"compliance", 0, "", false},
+		{"legal-hold", time.Hour, "", false},
+	} {
+		cfg := NewConfig()
+		cfg.ObjectLockMode = test.mode
+		cfg.ObjectLockRetention = test.retention
+
+		mode, err := objectLockMode(cfg)
+		if test.ok != (err == nil) {
+			t.Errorf("mode %q, retention %v: unexpected error %v", test.mode, test.retention, err)
+			continue
+		}
+		if string(mode) != test.want {
+			t.Errorf("mode %q: want %q, got %q", test.mode, test.want, mode)
+		}
+	}
+}
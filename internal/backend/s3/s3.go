@@ -17,19 +17,55 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 // Backend stores data on an S3 endpoint.
 type Backend struct {
-	client *minio.Client
-	cfg    Config
+	client   *minio.Client
+	cfg      Config
+	lockMode minio.RetentionMode
 	layout.Layout
 }
 
 // make sure that *Backend implements backend.Backend
 var _ restic.Backend = &Backend{}
+var _ restic.RetentionBackend = &Backend{}
+var _ restic.StagingBackend = &Backend{}
+
+// objectLockMode validates the Object Lock settings in cfg and returns the
+// retention mode, which is empty if Object Lock is not used.
+func objectLockMode(cfg Config) (minio.RetentionMode, error) {
+	if cfg.ObjectLockMode == "" {
+		if cfg.ObjectLockRetention != 0 {
+			return "", errors.New("s3.object-lock-retention requires s3.object-lock-mode to be set")
+		}
+		return "", nil
+	}
+
+	mode := minio.RetentionMode(strings.ToUpper(cfg.ObjectLockMode))
+	if !mode.IsValid() {
+		return "", errors.Errorf("invalid s3.object-lock-mode %q, must be GOVERNANCE or COMPLIANCE", cfg.ObjectLockMode)
+	}
+	if cfg.ObjectLockRetention <= 0 {
+		return "", errors.New("s3.object-lock-mode requires a positive s3.object-lock-retention")
+	}
+	return mode, nil
+}
+
+// isRetainedType returns whether files of type t are protected by Object
+// Lock. Lock files must remain removable, keys and the config are left
+// alone to allow changing passwords.
+func isRetainedType(t restic.FileType) bool {
+	switch t {
+	case restic.PackFile, restic.IndexFile, restic.SnapshotFile:
+		return true
+	default:
+		return false
+	}
+}
 
 const defaultLayout = "default"
 
@@ -40,6 +76,15 @@ func open(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, erro
 		minio.MaxRetry = int(cfg.MaxRetries)
 	}
 
+	lockMode, err := objectLockMode(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if isArchiveStorageClass(cfg.StorageClass) {
+		return nil, errors.Errorf("storage class %v can only be used for data pack files, use the option s3.data-storage-class instead", cfg.StorageClass)
+	}
+
 	// Chains all credential types, in the following order:
 	// 	- Static credentials provided by user
 	//	- AWS env vars (i.e. AWS_ACCESS_KEY_ID)
@@ -100,8 +145,9 @@ func open(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, erro
 	}
 
 	be := &Backend{
-		client: client,
-		cfg:    cfg,
+		client:   client,
+		cfg:      cfg,
+		lockMode: lockMode,
 	}
 
 	l, err := layout.ParseLayout(ctx, be, cfg.Layout, defaultLayout, cfg.Prefix)
@@ -140,8 +186,9 @@ func Create(ctx context.Context, cfg Config, rt http.RoundTripper) (restic.Backe
 	}
 
 	if !found {
-		// create new bucket with default ACL in default region
-		err = be.client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{})
+		// create new bucket with default ACL in default region. Object Lock
+		// can only be enabled when a bucket is created.
+		err = be.client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{ObjectLocking: be.lockMode != ""})
 		if err != nil {
 			return nil, errors.Wrap(err, "client.MakeBucket")
 		}
@@ -260,17 +307,43 @@ func (be *Backend) Path() string {
 	return be.cfg.Prefix
 }
 
+// storageClass returns the storage class for new files. Only pack files
+// containing data blobs can be moved to archive storage, as all other files
+// are required to plan operations like restore.
+func (be *Backend) storageClass(h restic.Handle) string {
+	if be.cfg.DataStorageClass != "" && h.Type == restic.PackFile && h.ContainedBlobType == restic.DataBlob {
+		return be.cfg.DataStorageClass
+	}
+	return be.cfg.StorageClass
+}
+
+// isArchiveStorageClass returns whether objects with the storage class must
+// be restored before they can be read.
+func isArchiveStorageClass(class string) bool {
+	switch class {
+	case "GLACIER", "DEEP_ARCHIVE":
+		return true
+	default:
+		return false
+	}
+}
+
 // Save stores data in the backend at the handle.
 func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
 	objName := be.Filename(h)
 
-	opts := minio.PutObjectOptions{StorageClass: be.cfg.StorageClass}
+	opts := minio.PutObjectOptions{StorageClass: be.storageClass(h)}
 	opts.ContentType = "application/octet-stream"
 	// the only option with the high-level api is to let the library handle the checksum computation
 	opts.SendContentMd5 = true
 	// only use multipart uploads for very large files
 	opts.PartSize = 200 * 1024 * 1024
 
+	if be.lockMode != "" && isRetainedType(h.Type) {
+		opts.Mode = be.lockMode
+		opts.RetainUntilDate = time.Now().Add(be.cfg.ObjectLockRetention).UTC()
+	}
+
 	info, err := be.client.PutObject(ctx, be.cfg.Bucket, objName, io.NopCloser(rd), int64(rd.Length()), opts)
 
 	// sanity check
@@ -308,6 +381,11 @@ func (be *Backend) openReader(ctx context.Context, h restic.Handle, length int,
 	coreClient := minio.Core{Client: be.client}
 	rd, _, _, err := coreClient.GetObject(ctx, be.cfg.Bucket, objName, opts)
 	if err != nil {
+		var e minio.ErrorResponse
+		if errors.As(err, &e) && e.Code == "InvalidObjectState" {
+			// retrying does not help until the object has been restored
+			return nil, backoff.Permanent(errors.Errorf("%v is in archive storage, run restore with --stage to retrieve it: %v", h, err))
+		}
 		return nil, err
 	}
 
@@ -342,6 +420,118 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInf
 	return restic.FileInfo{Size: fi.Size, Name: h.Name}, nil
 }
 
+// RetentionEnabled returns whether Object Lock is configured for the backend.
+func (be *Backend) RetentionEnabled() bool {
+	return be.lockMode != ""
+}
+
+// RetainUntil returns the time until which the file is protected by Object
+// Lock. Retention is only queried if Object Lock is configured for the
+// backend.
+func (be *Backend) RetainUntil(ctx context.Context, h restic.Handle) (time.Time, error) {
+	if be.lockMode == "" || !isRetainedType(h.Type) {
+		return time.Time{}, nil
+	}
+
+	_, until, err := be.client.GetObjectRetention(ctx, be.cfg.Bucket, be.Filename(h), "")
+	if err != nil {
+		var e minio.ErrorResponse
+		if errors.As(err, &e) && (e.Code == "NoSuchObjectLockConfiguration" || e.Code == "ObjectLockConfigurationNotFoundError") {
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.Wrap(err, "client.GetObjectRetention")
+	}
+
+	if until == nil {
+		return time.Time{}, nil
+	}
+	return *until, nil
+}
+
+// ExtendRetention sets the retention of the file to the configured retention
+// period from now. To limit the number of requests, the retention is only
+// renewed once less than half of the period remains.
+func (be *Backend) ExtendRetention(ctx context.Context, h restic.Handle) (bool, error) {
+	if be.lockMode == "" || !isRetainedType(h.Type) {
+		return false, nil
+	}
+
+	current, err := be.RetainUntil(ctx, h)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if current.After(now.Add(be.cfg.ObjectLockRetention / 2)) {
+		return false, nil
+	}
+
+	mode := be.lockMode
+	until := now.Add(be.cfg.ObjectLockRetention).UTC()
+	err = be.client.PutObjectRetention(ctx, be.cfg.Bucket, be.Filename(h), minio.PutObjectRetentionOptions{
+		Mode:            &mode,
+		RetainUntilDate: &until,
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "client.PutObjectRetention")
+	}
+	return true, nil
+}
+
+// Stage requests a temporary copy of an archived file. Objects in storage
+// classes which are not archived can be read immediately.
+func (be *Backend) Stage(ctx context.Context, h restic.Handle) (bool, error) {
+	objName := be.Filename(h)
+
+	info, err := be.client.StatObject(ctx, be.cfg.Bucket, objName, minio.StatObjectOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "client.StatObject")
+	}
+
+	if !isArchiveStorageClass(info.StorageClass) {
+		return true, nil
+	}
+
+	if info.Restore != nil {
+		// either the restore is still running or a copy is available
+		return !info.Restore.OngoingRestore, nil
+	}
+
+	days := be.cfg.StageDays
+	if days <= 0 {
+		days = 1
+	}
+	tier := minio.TierType(be.cfg.StageTier)
+	if tier == "" {
+		tier = minio.TierStandard
+	}
+
+	debug.Log("requesting restore of %v with tier %v for %d days", objName, tier, days)
+	req := minio.RestoreRequest{}
+	req.SetDays(days)
+	req.SetGlacierJobParameters(minio.GlacierJobParameters{Tier: tier})
+
+	err = be.client.RestoreObject(ctx, be.cfg.Bucket, objName, "", req)
+	var e minio.ErrorResponse
+	if errors.As(err, &e) && e.Code == "RestoreAlreadyInProgress" {
+		err = nil
+	}
+	return false, errors.Wrap(err, "client.RestoreObject")
+}
+
+// Archived returns whether the file is archived and no temporary copy of it
+// is available.
+func (be *Backend) Archived(ctx context.Context, h restic.Handle) (bool, error) {
+	info, err := be.client.StatObject(ctx, be.cfg.Bucket, be.Filename(h), minio.StatObjectOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "client.StatObject")
+	}
+
+	if !isArchiveStorageClass(info.StorageClass) {
+		return false, nil
+	}
+	return info.Restore == nil || info.Restore.OngoingRestore, nil
+}
+
 // Remove removes the blob with the given name and type.
 func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
 	objName := be.Filename(h)
@@ -16,7 +16,7 @@ type Config struct {
 	Layout  string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
 	Command string `option:"command" help:"specify command to create sftp connection"`
 
-	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Connections uint `option:"connections" help:"set a limit for the number of concurrent SFTP sessions (default: 5)"`
 }
 
 // NewConfig returns a new config with default options applied.
@@ -0,0 +1,151 @@
+package sftp_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	pkgsftp "github.com/pkg/sftp"
+)
+
+type stdio struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// TestHelperProcess is not a real test, the test binary is started as the
+// sftp command and serves the sftp protocol on stdin and stdout.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	// record the session for TestConcurrentSessions
+	if dir := os.Getenv("SFTP_TEST_SESSIONS_DIR"); dir != "" {
+		f, err := os.CreateTemp(dir, "session-")
+		if err == nil {
+			_ = f.Close()
+		}
+	}
+
+	server, err := pkgsftp.NewServer(stdio{os.Stdin, os.Stdout})
+	if err == nil {
+		err = server.Serve()
+	}
+	if err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func newHelperConfig(t testing.TB) sftp.Config {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	cfg := sftp.NewConfig()
+	cfg.Path = rtest.TempDir(t)
+	cfg.Command = fmt.Sprintf("%q -test.run=^TestHelperProcess$", os.Args[0])
+	return cfg
+}
+
+func TestBackendSFTPSessions(t *testing.T) {
+	newHelperConfig(t)
+
+	suite := newTestSuite(t)
+	suite.NewConfig = func() (interface{}, error) {
+		return newHelperConfig(t), nil
+	}
+	suite.Cleanup = func(config interface{}) error {
+		return nil
+	}
+	suite.RunTests(t)
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	sessions := rtest.TempDir(t)
+	t.Setenv("SFTP_TEST_SESSIONS_DIR", sessions)
+
+	cfg := newHelperConfig(t)
+	cfg.Connections = 3
+
+	be, err := sftp.Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data, be.Hasher())))
+
+	// each load keeps its session busy until all loads are running
+	var wg sync.WaitGroup
+	var running sync.WaitGroup
+	running.Add(int(cfg.Connections))
+	allRunning := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(allRunning)
+	}()
+
+	errs := make(chan error, cfg.Connections)
+	for i := uint(0); i < cfg.Connections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+				running.Done()
+				select {
+				case <-allRunning:
+				case <-time.After(10 * time.Second):
+					return fmt.Errorf("loads did not run concurrently")
+				}
+				_, err := io.ReadAll(rd)
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		rtest.OK(t, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(sessions, "session-*"))
+	rtest.OK(t, err)
+	rtest.Equals(t, int(cfg.Connections), len(files))
+}
+
+func TestSessionStartFailure(t *testing.T) {
+	cfg := newHelperConfig(t)
+	cfg.Connections = 2
+
+	be, err := sftp.Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	// additional sessions cannot be started, the first one is used instead
+	t.Setenv("GO_WANT_HELPER_PROCESS", "0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+			rtest.Assert(t, be.IsNotExist(err), "unexpected error %v", err)
+		}()
+	}
+	wg.Wait()
+}
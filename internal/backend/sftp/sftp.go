@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/restic/restic/internal/backend"
@@ -24,16 +25,23 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-// SFTP is a backend in a directory accessed via SFTP.
+// SFTP is a backend in a directory accessed via SFTP. Concurrent operations
+// use separate SFTP sessions, each running its own ssh process.
 type SFTP struct {
-	c *sftp.Client
 	p string
 
-	cmd    *exec.Cmd
-	result <-chan error
-
 	posixRename bool
 
+	// idle contains the sessions which are not in use.
+	idle chan *session
+
+	m        sync.Mutex
+	sessions []*session
+	starting int
+	// noMoreSessions is set once starting an additional session has failed.
+	noMoreSessions bool
+	closed         bool
+
 	layout.Layout
 	Config
 	backend.Modes
@@ -43,11 +51,24 @@ var _ restic.Backend = &SFTP{}
 
 const defaultLayout = "default"
 
-func startClient(cfg Config) (*SFTP, error) {
+// session is an SFTP session using a single ssh process.
+type session struct {
+	c *sftp.Client
+
+	cmd    *exec.Cmd
+	result <-chan error
+}
+
+// startSession runs the ssh command and opens an SFTP session. For batch
+// sessions, ssh is not allowed to ask for passwords.
+func startSession(cfg Config, batch bool) (*session, error) {
 	program, args, err := buildSSHCommand(cfg)
 	if err != nil {
 		return nil, err
 	}
+	if batch && cfg.Command == "" {
+		args = append([]string{"-o", "BatchMode=yes"}, args...)
+	}
 
 	debug.Log("start client %v %v", program, args)
 	// Connect to a remote host and request the sftp subsystem via the 'ssh'
@@ -106,15 +127,35 @@ func startClient(cfg Config) (*SFTP, error) {
 		return nil, errors.Wrap(err, "bg")
 	}
 
-	_, posixRename := client.HasExtension("posix-rename@openssh.com")
-	return &SFTP{c: client, cmd: cmd, result: ch, posixRename: posixRename}, nil
+	return &session{c: client, cmd: cmd, result: ch}, nil
+}
+
+func startClient(cfg Config) (*SFTP, error) {
+	if cfg.Connections == 0 {
+		cfg.Connections = 1
+	}
+
+	s, err := startSession(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	_, posixRename := s.c.HasExtension("posix-rename@openssh.com")
+	r := &SFTP{
+		posixRename: posixRename,
+		idle:        make(chan *session, cfg.Connections),
+		sessions:    []*session{s},
+		Config:      cfg,
+	}
+	r.idle <- s
+	return r, nil
 }
 
 // clientError returns an error if the client has exited. Otherwise, nil is
 // returned immediately.
-func (r *SFTP) clientError() error {
+func (s *session) clientError() error {
 	select {
-	case err := <-r.result:
+	case err := <-s.result:
 		debug.Log("client has exited with err %v", err)
 		return backoff.Permanent(err)
 	default:
@@ -123,6 +164,64 @@ func (r *SFTP) clientError() error {
 	return nil
 }
 
+// get returns an idle session. A new session is started if all sessions are
+// in use and the connection limit has not been reached yet.
+func (r *SFTP) get(ctx context.Context) (*session, error) {
+	select {
+	case s := <-r.idle:
+		return s, nil
+	default:
+	}
+
+	r.m.Lock()
+	if r.closed {
+		r.m.Unlock()
+		return nil, errors.New("backend is closed")
+	}
+	start := !r.noMoreSessions && uint(len(r.sessions)+r.starting) < r.Config.Connections
+	if start {
+		r.starting++
+	}
+	r.m.Unlock()
+
+	if start {
+		s, err := startSession(r.Config, true)
+
+		r.m.Lock()
+		r.starting--
+		if err == nil && !r.closed {
+			r.sessions = append(r.sessions, s)
+			debug.Log("started sftp session %d", len(r.sessions))
+		} else if err != nil {
+			// continue with the sessions which are already running
+			debug.Log("unable to start additional session: %v", err)
+			r.noMoreSessions = true
+		}
+		closed := r.closed
+		r.m.Unlock()
+
+		if err == nil && closed {
+			_ = s.close()
+			return nil, errors.New("backend is closed")
+		}
+		if err == nil {
+			return s, nil
+		}
+	}
+
+	select {
+	case s := <-r.idle:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// put returns a session obtained from get.
+func (r *SFTP) put(s *session) {
+	r.idle <- s
+}
+
 // Open opens an sftp backend as described by the config by running
 // "ssh" with the appropriate arguments (or cfg.Command, if set).
 func Open(ctx context.Context, cfg Config) (*SFTP, error) {
@@ -141,16 +240,22 @@ func open(ctx context.Context, sftp *SFTP, cfg Config) (*SFTP, error) {
 	var err error
 	sftp.Layout, err = layout.ParseLayout(ctx, sftp, cfg.Layout, defaultLayout, cfg.Path)
 	if err != nil {
+		_ = sftp.Close()
 		return nil, err
 	}
 
 	debug.Log("layout: %v\n", sftp.Layout)
 
-	fi, err := sftp.c.Stat(sftp.Layout.Filename(restic.Handle{Type: restic.ConfigFile}))
+	s, err := sftp.get(ctx)
+	if err != nil {
+		_ = sftp.Close()
+		return nil, err
+	}
+	fi, err := s.c.Stat(sftp.Layout.Filename(restic.Handle{Type: restic.ConfigFile}))
+	sftp.put(s)
 	m := backend.DeriveModesFromFileInfo(fi, err)
 	debug.Log("using (%03O file, %03O dir) permissions", m.File, m.Dir)
 
-	sftp.Config = cfg
 	sftp.p = cfg.Path
 	sftp.Modes = m
 	return sftp, nil
@@ -160,21 +265,27 @@ func (r *SFTP) mkdirAllDataSubdirs(ctx context.Context, nconn uint) error {
 	// Run multiple MkdirAll calls concurrently. These involve multiple
 	// round-trips and we do a lot of them, so this whole operation can be slow
 	// on high-latency links.
-	g, _ := errgroup.WithContext(ctx)
+	g, ctx := errgroup.WithContext(ctx)
 	// Use errgroup's built-in semaphore, because r.sem is not initialized yet.
 	g.SetLimit(int(nconn))
 
 	for _, d := range r.Paths() {
 		d := d
 		g.Go(func() error {
+			s, err := r.get(ctx)
+			if err != nil {
+				return err
+			}
+			defer r.put(s)
+
 			// First try Mkdir. For most directories in Paths, this takes one
 			// round trip, not counting duplicate parent creations causes by
 			// concurrency. MkdirAll first does Stat, then recursive MkdirAll
 			// on the parent, so calls typically take three round trips.
-			if err := r.c.Mkdir(d); err == nil {
+			if err := s.c.Mkdir(d); err == nil {
 				return nil
 			}
-			return r.c.MkdirAll(d)
+			return s.c.MkdirAll(d)
 		})
 	}
 
@@ -188,7 +299,13 @@ func (r *SFTP) Join(p ...string) string {
 
 // ReadDir returns the entries for a directory.
 func (r *SFTP) ReadDir(ctx context.Context, dir string) ([]os.FileInfo, error) {
-	fi, err := r.c.ReadDir(dir)
+	s, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.put(s)
+
+	fi, err := s.c.ReadDir(dir)
 
 	// sftp client does not specify dir name on error, so add it here
 	err = errors.Wrapf(err, "(%v)", dir)
@@ -239,19 +356,22 @@ func Create(ctx context.Context, cfg Config) (*SFTP, error) {
 
 	sftp.Layout, err = layout.ParseLayout(ctx, sftp, cfg.Layout, defaultLayout, cfg.Path)
 	if err != nil {
+		_ = sftp.Close()
 		return nil, err
 	}
 
 	sftp.Modes = backend.DefaultModes
 
 	// test if config file already exists
-	_, err = sftp.c.Lstat(sftp.Layout.Filename(restic.Handle{Type: restic.ConfigFile}))
+	_, err = sftp.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
 	if err == nil {
+		_ = sftp.Close()
 		return nil, errors.New("config file already exists")
 	}
 
 	// create paths for data and refs
-	if err = sftp.mkdirAllDataSubdirs(ctx, cfg.Connections); err != nil {
+	if err = sftp.mkdirAllDataSubdirs(ctx, sftp.Config.Connections); err != nil {
+		_ = sftp.Close()
 		return nil, err
 	}
 
@@ -297,7 +417,13 @@ func tempSuffix() string {
 
 // Save stores data in the backend at the handle.
 func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
-	if err := r.clientError(); err != nil {
+	s, err := r.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.put(s)
+
+	if err := s.clientError(); err != nil {
 		return err
 	}
 
@@ -306,16 +432,16 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 	dirname := r.Dirname(h)
 
 	// create new file
-	f, err := r.c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	f, err := s.c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
 
 	if r.IsNotExist(err) {
 		// error is caused by a missing directory, try to create it
-		mkdirErr := r.c.MkdirAll(r.Dirname(h))
+		mkdirErr := s.c.MkdirAll(r.Dirname(h))
 		if mkdirErr != nil {
 			debug.Log("error creating dir %v: %v", r.Dirname(h), mkdirErr)
 		} else {
 			// try again
-			f, err = r.c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+			f, err = s.c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
 		}
 	}
 
@@ -334,7 +460,7 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 		}
 
 		// Try not to leave a partial file behind.
-		rmErr := r.c.Remove(f.Name())
+		rmErr := s.c.Remove(f.Name())
 		if rmErr != nil {
 			debug.Log("sftp: failed to remove broken file %v: %v",
 				f.Name(), rmErr)
@@ -345,7 +471,7 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 	wbytes, err := f.ReadFrom(rd)
 	if err != nil {
 		_ = f.Close()
-		err = r.checkNoSpace(dirname, rd.Length(), err)
+		err = s.checkNoSpace(dirname, rd.Length(), err)
 		return errors.Wrap(err, "Write")
 	}
 
@@ -362,27 +488,27 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 
 	// Prefer POSIX atomic rename if available.
 	if r.posixRename {
-		err = r.c.PosixRename(tmpFilename, filename)
+		err = s.c.PosixRename(tmpFilename, filename)
 	} else {
-		err = r.c.Rename(tmpFilename, filename)
+		err = s.c.Rename(tmpFilename, filename)
 	}
 	return errors.Wrap(err, "Rename")
 }
 
 // checkNoSpace checks if err was likely caused by lack of available space
 // on the remote, and if so, makes it permanent.
-func (r *SFTP) checkNoSpace(dir string, size int64, origErr error) error {
+func (s *session) checkNoSpace(dir string, size int64, origErr error) error {
 	// The SFTP protocol has a message for ENOSPC,
 	// but pkg/sftp doesn't export it and OpenSSH's sftp-server
 	// sends FX_FAILURE instead.
 
 	e, ok := origErr.(*sftp.StatusError)
-	_, hasExt := r.c.HasExtension("statvfs@openssh.com")
+	_, hasExt := s.c.HasExtension("statvfs@openssh.com")
 	if !ok || e.FxCode() != sftp.ErrSSHFxFailure || !hasExt {
 		return origErr
 	}
 
-	fsinfo, err := r.c.StatVFS(dir)
+	fsinfo, err := s.c.StatVFS(dir)
 	if err != nil {
 		debug.Log("sftp: StatVFS returned %v", err)
 		return origErr
@@ -400,11 +526,31 @@ func (r *SFTP) Load(ctx context.Context, h restic.Handle, length int, offset int
 	return backend.DefaultLoad(ctx, h, length, offset, r.openReader, fn)
 }
 
+// sessionFile is a file which returns the session to the pool when it is
+// closed. It retains the WriteTo method of the sftp file.
+type sessionFile struct {
+	*sftp.File
+	release func()
+}
+
+func (f *sessionFile) Close() error {
+	err := f.File.Close()
+	f.release()
+	return err
+}
+
 func (r *SFTP) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
-	f, err := r.c.Open(r.Filename(h))
+	s, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sf, err := s.c.Open(r.Filename(h))
 	if err != nil {
+		r.put(s)
 		return nil, err
 	}
+	f := &sessionFile{File: sf, release: func() { r.put(s) }}
 
 	if offset > 0 {
 		_, err = f.Seek(offset, 0)
@@ -425,11 +571,17 @@ func (r *SFTP) openReader(ctx context.Context, h restic.Handle, length int, offs
 
 // Stat returns information about a blob.
 func (r *SFTP) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
-	if err := r.clientError(); err != nil {
+	s, err := r.get(ctx)
+	if err != nil {
 		return restic.FileInfo{}, err
 	}
+	defer r.put(s)
 
-	fi, err := r.c.Lstat(r.Filename(h))
+	if err := s.clientError(); err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	fi, err := s.c.Lstat(r.Filename(h))
 	if err != nil {
 		return restic.FileInfo{}, errors.Wrap(err, "Lstat")
 	}
@@ -439,64 +591,110 @@ func (r *SFTP) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, erro
 
 // Remove removes the content stored at name.
 func (r *SFTP) Remove(ctx context.Context, h restic.Handle) error {
-	if err := r.clientError(); err != nil {
+	s, err := r.get(ctx)
+	if err != nil {
 		return err
 	}
+	defer r.put(s)
 
-	return r.c.Remove(r.Filename(h))
+	if err := s.clientError(); err != nil {
+		return err
+	}
+
+	return s.c.Remove(r.Filename(h))
 }
 
 // List runs fn for each file in the backend which has the type t. When an
-// error occurs (or fn returns an error), List stops and returns it.
+// error occurs (or fn returns an error), List stops and returns it. The
+// subdirectories are listed concurrently, but fn is not called concurrently.
 func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
 	basedir, subdirs := r.Basedir(t)
-	walker := r.c.Walk(basedir)
-	for {
-		ok := walker.Step()
-		if !ok {
-			break
-		}
+	if !subdirs {
+		return r.listDir(ctx, basedir, false, fn)
+	}
 
-		if walker.Err() != nil {
-			if r.IsNotExist(walker.Err()) {
-				debug.Log("ignoring non-existing directory")
-				return nil
-			}
-			return walker.Err()
+	entries, err := r.ReadDir(ctx, basedir)
+	if err != nil {
+		if r.IsNotExist(err) {
+			debug.Log("ignoring non-existing directory")
+			return nil
 		}
+		return err
+	}
 
-		if walker.Path() == basedir {
-			continue
+	var m sync.Mutex
+	listFn := func(fi restic.FileInfo) error {
+		m.Lock()
+		defer m.Unlock()
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		return fn(fi)
+	}
 
-		if walker.Stat().IsDir() && !subdirs {
-			walker.SkipDir()
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(int(r.Config.Connections))
+	for _, fi := range entries {
+		if !fi.IsDir() {
+			if fi.Mode().IsRegular() {
+				if err := listFn(restic.FileInfo{Name: fi.Name(), Size: fi.Size()}); err != nil {
+					_ = g.Wait()
+					return err
+				}
+			}
 			continue
 		}
 
-		fi := walker.Stat()
-		if !fi.Mode().IsRegular() {
-			continue
-		}
+		dir := r.Join(basedir, fi.Name())
+		g.Go(func() error {
+			return r.listDir(gctx, dir, true, listFn)
+		})
+	}
 
-		debug.Log("send %v\n", path.Base(walker.Path()))
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
 
-		rfi := restic.FileInfo{
-			Name: path.Base(walker.Path()),
-			Size: fi.Size(),
+// listDir runs fn for each regular file in dir. Subdirectories are only
+// listed if recursive is set.
+func (r *SFTP) listDir(ctx context.Context, dir string, recursive bool, fn func(restic.FileInfo) error) error {
+	entries, err := r.ReadDir(ctx, dir)
+	if err != nil {
+		if r.IsNotExist(err) {
+			debug.Log("ignoring non-existing directory %v", dir)
+			return nil
 		}
+		return err
+	}
 
+	for _, fi := range entries {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 
-		err := fn(rfi)
-		if err != nil {
-			return err
+		if fi.IsDir() {
+			if recursive {
+				if err := r.listDir(ctx, r.Join(dir, fi.Name()), true, fn); err != nil {
+					return err
+				}
+			}
+			continue
 		}
 
-		if ctx.Err() != nil {
-			return ctx.Err()
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		debug.Log("send %v\n", fi.Name())
+
+		err := fn(restic.FileInfo{
+			Name: fi.Name(),
+			Size: fi.Size(),
+		})
+		if err != nil {
+			return err
 		}
 	}
 
@@ -505,46 +703,63 @@ func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileI
 
 var closeTimeout = 2 * time.Second
 
-// Close closes the sftp connection and terminates the underlying command.
+// Close closes all sftp sessions and terminates the underlying commands.
 func (r *SFTP) Close() error {
 	if r == nil {
 		return nil
 	}
 
-	err := r.c.Close()
+	r.m.Lock()
+	r.closed = true
+	sessions := r.sessions
+	r.sessions = nil
+	r.m.Unlock()
+
+	var firstErr error
+	for _, s := range sessions {
+		if err := s.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// close closes the sftp session and terminates the ssh command.
+func (s *session) close() error {
+	err := s.c.Close()
 	debug.Log("Close returned error %v", err)
 
 	// wait for closeTimeout before killing the process
 	select {
-	case err := <-r.result:
+	case err := <-s.result:
 		return err
 	case <-time.After(closeTimeout):
 	}
 
-	if err := r.cmd.Process.Kill(); err != nil {
+	if err := s.cmd.Process.Kill(); err != nil {
 		return err
 	}
 
 	// get the error, but ignore it
-	<-r.result
+	<-s.result
 	return nil
 }
 
-func (r *SFTP) deleteRecursive(ctx context.Context, name string) error {
-	entries, err := r.ReadDir(ctx, name)
+func (s *session) deleteRecursive(ctx context.Context, name string) error {
+	entries, err := s.c.ReadDir(name)
 	if err != nil {
-		return errors.Wrap(err, "ReadDir")
+		return errors.Wrapf(err, "ReadDir(%v)", name)
 	}
 
 	for _, fi := range entries {
-		itemName := r.Join(name, fi.Name())
+		itemName := path.Join(name, fi.Name())
 		if fi.IsDir() {
-			err := r.deleteRecursive(ctx, itemName)
+			err := s.deleteRecursive(ctx, itemName)
 			if err != nil {
 				return errors.Wrap(err, "ReadDir")
 			}
 
-			err = r.c.RemoveDirectory(itemName)
+			err = s.c.RemoveDirectory(itemName)
 			if err != nil {
 				return errors.Wrap(err, "RemoveDirectory")
 			}
@@ -552,7 +767,7 @@ func (r *SFTP) deleteRecursive(ctx context.Context, name string) error {
 			continue
 		}
 
-		err := r.c.Remove(itemName)
+		err := s.c.Remove(itemName)
 		if err != nil {
 			return errors.Wrap(err, "ReadDir")
 		}
@@ -563,5 +778,11 @@ func (r *SFTP) deleteRecursive(ctx context.Context, name string) error {
 
 // Delete removes all data in the backend.
 func (r *SFTP) Delete(ctx context.Context) error {
-	return r.deleteRecursive(ctx, r.p)
+	s, err := r.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.put(s)
+
+	return s.deleteRecursive(ctx, r.p)
 }
@@ -0,0 +1,95 @@
+package smb
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to connect to an SMB share.
+type Config struct {
+	Host, Port string
+	Share      string
+
+	// Path is the directory of the repository, relative to the share.
+	Path string
+
+	User     string
+	Domain   string
+	Password options.SecretString
+
+	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+}
+
+func init() {
+	options.Register("smb", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+// ParseConfig parses the string s and extracts the SMB config. The supported
+// configuration format is smb://[[domain;]user[:password]@]host[:port]/share[/path].
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "smb://") {
+		return nil, errors.New(`invalid format, does not start with "smb://"`)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if u.Hostname() == "" {
+		return nil, errors.New("smb: server name not found")
+	}
+
+	share, dir, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if share == "" {
+		return nil, errors.New("smb: share name not found")
+	}
+
+	dir = path.Clean("/" + dir)[1:]
+
+	cfg := NewConfig()
+	cfg.Host = u.Hostname()
+	cfg.Port = u.Port()
+	cfg.Share = share
+	cfg.Path = dir
+
+	if u.User != nil {
+		cfg.User = u.User.Username()
+		if domain, user, ok := strings.Cut(cfg.User, ";"); ok {
+			cfg.Domain = domain
+			cfg.User = user
+		}
+		if password, ok := u.User.Password(); ok {
+			cfg.Password = options.NewSecretString(password)
+		}
+	}
+
+	return cfg, nil
+}
+
+// StripPassword removes the password from the location. If the repository
+// location cannot be parsed as a valid URL, it is returned as is.
+func StripPassword(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+
+	if _, set := u.User.Password(); !set {
+		return s
+	}
+
+	// a password was set: we replace it with ***
+	return strings.Replace(u.String(), u.User.String()+"@", url.User(u.User.Username()).String()+":***@", 1)
+}
@@ -0,0 +1,687 @@
+package smb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// SMB2 dialects supported by the client. SMB 3.1.1 requires pre-auth
+// integrity checks and is not offered.
+const (
+	dialectSMB21  = 0x0210
+	dialectSMB30  = 0x0300
+	dialectSMB302 = 0x0302
+)
+
+// SMB2 commands, see [MS-SMB2] 2.2.1.
+const (
+	cmdNegotiate      = 0x0000
+	cmdSessionSetup   = 0x0001
+	cmdTreeConnect    = 0x0003
+	cmdCreate         = 0x0005
+	cmdClose          = 0x0006
+	cmdFlush          = 0x0007
+	cmdRead           = 0x0008
+	cmdWrite          = 0x0009
+	cmdQueryDirectory = 0x000e
+	cmdSetInfo        = 0x0011
+)
+
+const (
+	headerSize = 64
+
+	flagResponse = 0x00000001
+	flagAsync    = 0x00000002
+	flagSigned   = 0x00000008
+
+	securitySigningEnabled  = 0x0001
+	securitySigningRequired = 0x0002
+
+	capLargeMTU = 0x00000004
+
+	sessionFlagGuest   = 0x0001
+	sessionFlagNull    = 0x0002
+	sessionFlagEncrypt = 0x0004
+
+	shareTypeDisk     = 0x01
+	shareFlagEncrypt  = 0x00008000
+	creditsPerRequest = 64
+	creditSize        = 64 * 1024
+
+	// maxIOSize limits the size of a single read or write request
+	maxIOSize = 1024 * 1024
+)
+
+// Access masks, dispositions and options used with CREATE requests.
+const (
+	accessReadData       = 0x00000001
+	accessWriteData      = 0x00000002
+	accessReadAttributes = 0x00000080
+	accessDelete         = 0x00010000
+	accessSynchronize    = 0x00100000
+
+	shareRead   = 0x00000001
+	shareWrite  = 0x00000002
+	shareDelete = 0x00000004
+
+	dispositionOpen   = 0x00000001
+	dispositionCreate = 0x00000002
+	dispositionOpenIf = 0x00000003
+
+	optionDirectory     = 0x00000001
+	optionNonDirectory  = 0x00000040
+	optionDeleteOnClose = 0x00001000
+
+	attrDirectory = 0x00000010
+	attrNormal    = 0x00000080
+)
+
+// NT status codes, see [MS-ERREF] 2.3.
+const (
+	statusSuccess                = 0x00000000
+	statusPending                = 0x00000103
+	statusNoMoreFiles            = 0x80000006
+	statusInvalidParameter       = 0xc000000d
+	statusNoSuchFile             = 0xc000000f
+	statusEndOfFile              = 0xc0000011
+	statusMoreProcessingRequired = 0xc0000016
+	statusAccessDenied           = 0xc0000022
+	statusObjectNameNotFound     = 0xc0000034
+	statusObjectNameCollision    = 0xc0000035
+	statusObjectPathNotFound     = 0xc000003a
+	statusSharingViolation       = 0xc0000043
+	statusDeletePending          = 0xc0000056
+	statusLogonFailure           = 0xc000006d
+	statusDiskFull               = 0xc000007f
+	statusNotSupported           = 0xc00000bb
+	statusNetworkNameDeleted     = 0xc00000c9
+	statusBadNetworkName         = 0xc00000cc
+	statusUserSessionDeleted     = 0xc0000203
+	statusNetworkSessionExpired  = 0xc000035c
+)
+
+var statusNames = map[uint32]string{
+	statusNoMoreFiles:           "STATUS_NO_MORE_FILES",
+	statusInvalidParameter:      "STATUS_INVALID_PARAMETER",
+	statusNoSuchFile:            "STATUS_NO_SUCH_FILE",
+	statusEndOfFile:             "STATUS_END_OF_FILE",
+	statusAccessDenied:          "STATUS_ACCESS_DENIED",
+	statusObjectNameNotFound:    "STATUS_OBJECT_NAME_NOT_FOUND",
+	statusObjectNameCollision:   "STATUS_OBJECT_NAME_COLLISION",
+	statusObjectPathNotFound:    "STATUS_OBJECT_PATH_NOT_FOUND",
+	statusSharingViolation:      "STATUS_SHARING_VIOLATION",
+	statusDeletePending:         "STATUS_DELETE_PENDING",
+	statusLogonFailure:          "STATUS_LOGON_FAILURE",
+	statusDiskFull:              "STATUS_DISK_FULL",
+	statusNotSupported:          "STATUS_NOT_SUPPORTED",
+	statusNetworkNameDeleted:    "STATUS_NETWORK_NAME_DELETED",
+	statusBadNetworkName:        "STATUS_BAD_NETWORK_NAME",
+	statusUserSessionDeleted:    "STATUS_USER_SESSION_DELETED",
+	statusNetworkSessionExpired: "STATUS_NETWORK_SESSION_EXPIRED",
+}
+
+// statusError is returned when the server answers a request with an error
+// status.
+type statusError uint32
+
+func (e statusError) Error() string {
+	if name, ok := statusNames[uint32(e)]; ok {
+		return name
+	}
+	return fmt.Sprintf("NT status 0x%08x", uint32(e))
+}
+
+func hasStatus(err error, codes ...uint32) bool {
+	var e statusError
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, code := range codes {
+		if uint32(e) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// fileID identifies an open file on the server.
+type fileID [16]byte
+
+// conn is an authenticated connection to a share. Requests are sent one at a
+// time, concurrent access uses several connections.
+type conn struct {
+	nc net.Conn
+
+	m         sync.Mutex
+	messageID uint64
+	credits   uint32
+	sessionID uint64
+	treeID    uint32
+	dialect   uint16
+	signer    *signer
+
+	maxRead, maxWrite uint32
+
+	// broken is set once the connection cannot be used any more
+	broken bool
+}
+
+// dial connects and authenticates to the share configured in cfg.
+func dial(ctx context.Context, cfg Config) (*conn, error) {
+	port := cfg.Port
+	if port == "" {
+		port = "445"
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", net.JoinHostPort(cfg.Host, port))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := &conn{nc: nc, credits: 1}
+	err = c.negotiate(ctx)
+	if err == nil {
+		err = c.sessionSetup(ctx, cfg)
+	}
+	if err == nil {
+		err = c.treeConnect(ctx, `\\`+cfg.Host+`\`+cfg.Share)
+	}
+	if err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+
+	debug.Log("connected to %v, dialect %#x, max read %d, max write %d", cfg.Host, c.dialect, c.maxRead, c.maxWrite)
+	return c, nil
+}
+
+func (c *conn) close() error {
+	return c.nc.Close()
+}
+
+// chunkSize returns the maximum size of a read or write request.
+func (c *conn) chunkSize(max uint32) int {
+	size := uint32(maxIOSize)
+	if max < size {
+		size = max
+	}
+	if c.credits*creditSize < size {
+		size = c.credits * creditSize
+	}
+	return int(size)
+}
+
+// roundTrip sends a request and returns the response header and body. An
+// error status of the response is returned as statusError, together with the
+// response.
+func (c *conn) roundTrip(ctx context.Context, cmd uint16, body []byte, payloadSize int) (hdr []byte, resp []byte, err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.broken {
+		return nil, nil, errors.New("connection is broken")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// abort reads and writes on the connection
+			_ = c.nc.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	hdr, resp, err = c.exchange(cmd, body, payloadSize)
+	close(done)
+
+	if ctx.Err() != nil {
+		c.broken = true
+		return nil, nil, ctx.Err()
+	}
+	if err != nil {
+		c.broken = true
+		return nil, nil, err
+	}
+
+	status := binary.LittleEndian.Uint32(hdr[8:])
+	switch status {
+	case statusSuccess:
+		return hdr, resp, nil
+	case statusNetworkSessionExpired, statusUserSessionDeleted, statusNetworkNameDeleted:
+		c.broken = true
+	}
+	return hdr, resp, statusError(status)
+}
+
+func (c *conn) exchange(cmd uint16, body []byte, payloadSize int) (hdr []byte, resp []byte, err error) {
+	charge := uint32(1)
+	if payloadSize > creditSize {
+		charge = uint32((payloadSize-1)/creditSize + 1)
+	}
+	if charge > c.credits {
+		return nil, nil, errors.Errorf("not enough credits for request (%d < %d)", c.credits, charge)
+	}
+
+	msg := make([]byte, 4+headerSize+len(body))
+	binary.BigEndian.PutUint32(msg, uint32(headerSize+len(body)))
+	h := msg[4 : 4+headerSize]
+	copy(h, "\xfeSMB")
+	binary.LittleEndian.PutUint16(h[4:], headerSize)
+	binary.LittleEndian.PutUint16(h[6:], uint16(charge))
+	binary.LittleEndian.PutUint16(h[12:], cmd)
+	binary.LittleEndian.PutUint16(h[14:], creditsPerRequest)
+	binary.LittleEndian.PutUint64(h[24:], c.messageID)
+	binary.LittleEndian.PutUint32(h[36:], c.treeID)
+	binary.LittleEndian.PutUint64(h[40:], c.sessionID)
+	copy(msg[4+headerSize:], body)
+
+	if c.signer != nil {
+		c.signer.sign(msg[4:])
+	}
+
+	id := c.messageID
+	c.messageID += uint64(charge)
+	c.credits -= charge
+
+	_ = c.nc.SetDeadline(time.Time{})
+	if _, err := c.nc.Write(msg); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	for {
+		buf, err := c.readMessage()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(buf) < headerSize || !bytes.Equal(buf[:4], []byte("\xfeSMB")) {
+			return nil, nil, errors.New("invalid SMB2 response")
+		}
+
+		flags := binary.LittleEndian.Uint32(buf[16:])
+		c.credits += uint32(binary.LittleEndian.Uint16(buf[14:]))
+		if flags&flagResponse == 0 || binary.LittleEndian.Uint64(buf[24:]) != id {
+			return nil, nil, errors.New("unexpected SMB2 message from server")
+		}
+
+		// the server sends an interim response for requests which take
+		// longer, the final response follows later
+		if flags&flagAsync != 0 && binary.LittleEndian.Uint32(buf[8:]) == statusPending {
+			continue
+		}
+
+		return buf[:headerSize], buf[headerSize:], nil
+	}
+}
+
+func (c *conn) readMessage() ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(c.nc, prefix[:]); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if prefix[0] != 0 || length > 16*1024*1024 {
+		return nil, errors.New("invalid SMB2 message length")
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(c.nc, buf); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf, nil
+}
+
+// buffer returns the variable length part of a response, the offset is
+// relative to the start of the header.
+func buffer(resp []byte, offset, length int) ([]byte, error) {
+	offset -= headerSize
+	if length == 0 {
+		return nil, nil
+	}
+	if offset < 0 || offset+length > len(resp) {
+		return nil, errors.New("invalid buffer in SMB2 response")
+	}
+	return resp[offset : offset+length], nil
+}
+
+func (c *conn) negotiate(ctx context.Context) error {
+	dialects := []uint16{dialectSMB21, dialectSMB30, dialectSMB302}
+
+	body := make([]byte, 36+2*len(dialects))
+	binary.LittleEndian.PutUint16(body, 36)
+	binary.LittleEndian.PutUint16(body[2:], uint16(len(dialects)))
+	binary.LittleEndian.PutUint16(body[4:], securitySigningEnabled)
+	binary.LittleEndian.PutUint32(body[8:], capLargeMTU)
+	if _, err := rand.Read(body[12:28]); err != nil {
+		return errors.WithStack(err)
+	}
+	for i, d := range dialects {
+		binary.LittleEndian.PutUint16(body[36+2*i:], d)
+	}
+
+	_, resp, err := c.roundTrip(ctx, cmdNegotiate, body, 0)
+	if err != nil {
+		return errors.Wrap(err, "negotiate")
+	}
+	if len(resp) < 64 {
+		return errors.New("invalid negotiate response")
+	}
+
+	c.dialect = binary.LittleEndian.Uint16(resp[4:])
+	switch c.dialect {
+	case dialectSMB21, dialectSMB30, dialectSMB302:
+	default:
+		return errors.Errorf("server selected unsupported SMB dialect %#x", c.dialect)
+	}
+
+	c.maxRead = binary.LittleEndian.Uint32(resp[32:])
+	c.maxWrite = binary.LittleEndian.Uint32(resp[36:])
+	return nil
+}
+
+func (c *conn) sessionSetupRequest(ctx context.Context, token []byte) ([]byte, []byte, error) {
+	body := make([]byte, 24+len(token))
+	binary.LittleEndian.PutUint16(body, 25)
+	body[3] = securitySigningEnabled
+	binary.LittleEndian.PutUint16(body[12:], headerSize+24)
+	binary.LittleEndian.PutUint16(body[14:], uint16(len(token)))
+	copy(body[24:], token)
+
+	hdr, resp, err := c.roundTrip(ctx, cmdSessionSetup, body, 0)
+	if err != nil && !hasStatus(err, statusMoreProcessingRequired) {
+		return nil, nil, err
+	}
+	if len(resp) < 8 {
+		return nil, nil, errors.New("invalid session setup response")
+	}
+
+	c.sessionID = binary.LittleEndian.Uint64(hdr[40:])
+	buf, berr := buffer(resp, int(binary.LittleEndian.Uint16(resp[4:])), int(binary.LittleEndian.Uint16(resp[6:])))
+	if berr != nil {
+		return nil, nil, berr
+	}
+	return resp, buf, err
+}
+
+func (c *conn) sessionSetup(ctx context.Context, cfg Config) error {
+	ntlm := &ntlmClient{user: cfg.User, domain: cfg.Domain, password: cfg.Password.Unwrap()}
+
+	token, err := spnegoInit(ntlm.negotiateMessage())
+	if err != nil {
+		return err
+	}
+	_, buf, err := c.sessionSetupRequest(ctx, token)
+	if !hasStatus(err, statusMoreProcessingRequired) {
+		if err == nil {
+			err = errors.New("server did not send a challenge")
+		}
+		return errors.Wrap(err, "session setup")
+	}
+
+	challenge, err := parseSPNEGOResponse(buf)
+	if err != nil {
+		return err
+	}
+	auth, err := ntlm.authenticateMessage(challenge)
+	if err != nil {
+		return err
+	}
+	mic, err := ntlm.mechListMIC()
+	if err != nil {
+		return err
+	}
+	token, err = spnegoResponse(auth, mic)
+	if err != nil {
+		return err
+	}
+
+	resp, _, err := c.sessionSetupRequest(ctx, token)
+	if hasStatus(err, statusLogonFailure) {
+		return errors.Fatalf("login to %v as user %q failed, wrong user name or password", cfg.Host, cfg.User)
+	}
+	if err != nil {
+		return errors.Wrap(err, "session setup")
+	}
+
+	flags := binary.LittleEndian.Uint16(resp[2:])
+	if flags&sessionFlagEncrypt != 0 {
+		return errors.New("the server requires encryption, which is not supported")
+	}
+	if flags&(sessionFlagGuest|sessionFlagNull) == 0 {
+		c.signer = newSigner(c.dialect, ntlm.sessionKey)
+	}
+	return nil
+}
+
+func (c *conn) treeConnect(ctx context.Context, share string) error {
+	name := encodeUTF16(share)
+	body := make([]byte, 8+len(name))
+	binary.LittleEndian.PutUint16(body, 9)
+	binary.LittleEndian.PutUint16(body[4:], headerSize+8)
+	binary.LittleEndian.PutUint16(body[6:], uint16(len(name)))
+	copy(body[8:], name)
+
+	hdr, resp, err := c.roundTrip(ctx, cmdTreeConnect, body, 0)
+	if hasStatus(err, statusBadNetworkName) {
+		return errors.Fatalf("share %v does not exist", share)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "connect to %v", share)
+	}
+	if len(resp) < 16 {
+		return errors.New("invalid tree connect response")
+	}
+	if resp[2] != shareTypeDisk {
+		return errors.Errorf("%v is not a file share", share)
+	}
+	if binary.LittleEndian.Uint32(resp[4:])&shareFlagEncrypt != 0 {
+		return errors.Errorf("share %v requires encryption, which is not supported", share)
+	}
+
+	c.treeID = binary.LittleEndian.Uint32(hdr[36:])
+	return nil
+}
+
+// smbPath converts a slash separated path to the form used by SMB.
+func smbPath(p string) string {
+	return strings.ReplaceAll(strings.Trim(p, "/"), "/", `\`)
+}
+
+type createResult struct {
+	id    fileID
+	size  int64
+	isDir bool
+}
+
+func (c *conn) create(ctx context.Context, name string, access, disposition, options uint32) (createResult, error) {
+	n := encodeUTF16(smbPath(name))
+	body := make([]byte, 56+len(n))
+	if len(n) == 0 {
+		// the buffer must not be empty
+		body = append(body, 0)
+	}
+	binary.LittleEndian.PutUint16(body, 57)
+	binary.LittleEndian.PutUint32(body[4:], 2) // impersonation
+	binary.LittleEndian.PutUint32(body[24:], access)
+	binary.LittleEndian.PutUint32(body[28:], attrNormal)
+	binary.LittleEndian.PutUint32(body[32:], shareRead|shareWrite|shareDelete)
+	binary.LittleEndian.PutUint32(body[36:], disposition)
+	binary.LittleEndian.PutUint32(body[40:], options)
+	binary.LittleEndian.PutUint16(body[44:], headerSize+56)
+	binary.LittleEndian.PutUint16(body[46:], uint16(len(n)))
+	copy(body[56:], n)
+
+	_, resp, err := c.roundTrip(ctx, cmdCreate, body, 0)
+	if err != nil {
+		return createResult{}, errors.Wrapf(err, "open %v", name)
+	}
+	if len(resp) < 88 {
+		return createResult{}, errors.New("invalid create response")
+	}
+
+	var res createResult
+	res.size = int64(binary.LittleEndian.Uint64(resp[48:]))
+	res.isDir = binary.LittleEndian.Uint32(resp[56:])&attrDirectory != 0
+	copy(res.id[:], resp[64:80])
+	return res, nil
+}
+
+func (c *conn) closeFile(ctx context.Context, id fileID) error {
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body, 24)
+	copy(body[8:], id[:])
+
+	_, _, err := c.roundTrip(ctx, cmdClose, body, 0)
+	return errors.Wrap(err, "close")
+}
+
+func (c *conn) flush(ctx context.Context, id fileID) error {
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body, 24)
+	copy(body[8:], id[:])
+
+	_, _, err := c.roundTrip(ctx, cmdFlush, body, 0)
+	return errors.Wrap(err, "flush")
+}
+
+// read reads up to length bytes at offset, it returns io.EOF at the end of
+// the file.
+func (c *conn) read(ctx context.Context, id fileID, offset int64, length int) ([]byte, error) {
+	body := make([]byte, 49)
+	binary.LittleEndian.PutUint16(body, 49)
+	body[2] = headerSize + 16
+	binary.LittleEndian.PutUint32(body[4:], uint32(length))
+	binary.LittleEndian.PutUint64(body[8:], uint64(offset))
+	copy(body[16:], id[:])
+
+	_, resp, err := c.roundTrip(ctx, cmdRead, body, length)
+	if hasStatus(err, statusEndOfFile) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	if len(resp) < 16 {
+		return nil, errors.New("invalid read response")
+	}
+	return buffer(resp, int(resp[2]), int(binary.LittleEndian.Uint32(resp[4:])))
+}
+
+func (c *conn) write(ctx context.Context, id fileID, offset int64, data []byte) (int, error) {
+	body := make([]byte, 48+len(data))
+	binary.LittleEndian.PutUint16(body, 49)
+	binary.LittleEndian.PutUint16(body[2:], headerSize+48)
+	binary.LittleEndian.PutUint32(body[4:], uint32(len(data)))
+	binary.LittleEndian.PutUint64(body[8:], uint64(offset))
+	copy(body[16:], id[:])
+	copy(body[48:], data)
+
+	_, resp, err := c.roundTrip(ctx, cmdWrite, body, len(data))
+	if err != nil {
+		return 0, errors.Wrap(err, "write")
+	}
+	if len(resp) < 8 {
+		return 0, errors.New("invalid write response")
+	}
+	return int(binary.LittleEndian.Uint32(resp[4:])), nil
+}
+
+type dirEntry struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+// queryDirectory returns the next batch of entries in the directory, it
+// returns io.EOF when all entries were returned.
+func (c *conn) queryDirectory(ctx context.Context, id fileID) ([]dirEntry, error) {
+	const fileDirectoryInformation = 0x01
+
+	pattern := encodeUTF16("*")
+	size := c.chunkSize(maxIOSize)
+	body := make([]byte, 32+len(pattern))
+	binary.LittleEndian.PutUint16(body, 33)
+	body[2] = fileDirectoryInformation
+	copy(body[8:], id[:])
+	binary.LittleEndian.PutUint16(body[24:], headerSize+32)
+	binary.LittleEndian.PutUint16(body[26:], uint16(len(pattern)))
+	binary.LittleEndian.PutUint32(body[28:], uint32(size))
+	copy(body[32:], pattern)
+
+	_, resp, err := c.roundTrip(ctx, cmdQueryDirectory, body, size)
+	if hasStatus(err, statusNoMoreFiles) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "query directory")
+	}
+	if len(resp) < 8 {
+		return nil, errors.New("invalid query directory response")
+	}
+	buf, err := buffer(resp, int(binary.LittleEndian.Uint16(resp[2:])), int(binary.LittleEndian.Uint32(resp[4:])))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []dirEntry
+	for len(buf) >= 64 {
+		next := int(binary.LittleEndian.Uint32(buf))
+		nameLen := int(binary.LittleEndian.Uint32(buf[60:]))
+		if 64+nameLen > len(buf) {
+			return nil, errors.New("invalid directory entry")
+		}
+
+		name := decodeUTF16(buf[64 : 64+nameLen])
+		if name != "." && name != ".." {
+			entries = append(entries, dirEntry{
+				name:  name,
+				size:  int64(binary.LittleEndian.Uint64(buf[40:])),
+				isDir: binary.LittleEndian.Uint32(buf[56:])&attrDirectory != 0,
+			})
+		}
+
+		if next == 0 || next > len(buf) {
+			break
+		}
+		buf = buf[next:]
+	}
+	return entries, nil
+}
+
+// rename moves the open file to newName, which is relative to the share.
+func (c *conn) rename(ctx context.Context, id fileID, newName string) error {
+	const (
+		infoTypeFile          = 0x01
+		fileRenameInformation = 10
+	)
+
+	n := encodeUTF16(smbPath(newName))
+	info := make([]byte, 20+len(n))
+	info[0] = 1 // replace if exists
+	binary.LittleEndian.PutUint32(info[16:], uint32(len(n)))
+	copy(info[20:], n)
+
+	body := make([]byte, 32+len(info))
+	binary.LittleEndian.PutUint16(body, 33)
+	body[2] = infoTypeFile
+	body[3] = fileRenameInformation
+	binary.LittleEndian.PutUint32(body[4:], uint32(len(info)))
+	binary.LittleEndian.PutUint16(body[8:], headerSize+32)
+	copy(body[16:], id[:])
+	copy(body[32:], info)
+
+	_, _, err := c.roundTrip(ctx, cmdSetInfo, body, 0)
+	return errors.Wrapf(err, "rename to %v", newName)
+}
@@ -0,0 +1,4 @@
+// Package smb implements repository storage in a directory on an SMB/CIFS
+// file share. It contains a minimal SMB2 client, so the share does not need
+// to be mounted.
+package smb
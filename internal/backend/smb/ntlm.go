@@ -0,0 +1,360 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/asn1"
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/crypto/md4"
+)
+
+// NTLM negotiate flags, see [MS-NLMP] 2.2.2.5.
+const (
+	ntlmNegotiateUnicode     = 0x00000001
+	ntlmRequestTarget        = 0x00000004
+	ntlmNegotiateSign        = 0x00000010
+	ntlmNegotiateNTLM        = 0x00000200
+	ntlmNegotiateAlwaysSign  = 0x00008000
+	ntlmNegotiateExtendedSec = 0x00080000
+	ntlmNegotiateTargetInfo  = 0x00800000
+	ntlmNegotiateVersion     = 0x02000000
+	ntlmNegotiate128         = 0x20000000
+	ntlmNegotiateKeyExch     = 0x40000000
+	ntlmNegotiate56          = 0x80000000
+
+	ntlmDefaultFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateSign |
+		ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSec |
+		ntlmNegotiateTargetInfo | ntlmNegotiateVersion | ntlmNegotiate128 |
+		ntlmNegotiateKeyExch | ntlmNegotiate56
+)
+
+// AV pair IDs used in the target info of the challenge message.
+const (
+	avEOL       = 0x0000
+	avFlags     = 0x0006
+	avTimestamp = 0x0007
+
+	avFlagMICPresent = 0x00000002
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmVersion is sent in the version field, the values are only informational.
+var ntlmVersion = []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15}
+
+// ntlmClient performs NTLMv2 authentication, see [MS-NLMP].
+type ntlmClient struct {
+	user, domain, password string
+
+	negotiate []byte
+	challenge []byte
+
+	// sessionKey becomes available after the authenticate message was created
+	sessionKey []byte
+}
+
+func encodeUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	buf := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(buf[2*i:], c)
+	}
+	return buf
+}
+
+func decodeUTF16(buf []byte) string {
+	u := make([]uint16, len(buf)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(buf[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		_, _ = h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// ntowfv2 computes the NTLMv2 response key from the credentials.
+func ntowfv2(user, domain, password string) []byte {
+	h := md4.New()
+	_, _ = h.Write(encodeUTF16(password))
+	return hmacMD5(h.Sum(nil), encodeUTF16(strings.ToUpper(user)+domain))
+}
+
+// filetime converts t to the number of 100ns intervals since 1601-01-01.
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func (c *ntlmClient) negotiateMessage() []byte {
+	buf := make([]byte, 40)
+	copy(buf, ntlmSignature)
+	binary.LittleEndian.PutUint32(buf[8:], 1)
+	binary.LittleEndian.PutUint32(buf[12:], ntlmDefaultFlags)
+	// the domain and workstation fields are left empty
+	copy(buf[32:], ntlmVersion)
+	c.negotiate = buf
+	return buf
+}
+
+// field returns the payload referenced by the length/offset field at pos.
+func field(msg []byte, pos int) ([]byte, error) {
+	if len(msg) < pos+8 {
+		return nil, errors.New("NTLM message too short")
+	}
+	length := int(binary.LittleEndian.Uint16(msg[pos:]))
+	offset := int(binary.LittleEndian.Uint32(msg[pos+4:]))
+	if offset+length > len(msg) {
+		return nil, errors.New("invalid field in NTLM message")
+	}
+	return msg[offset : offset+length], nil
+}
+
+// parseAVPairs returns the AV pairs of the target info as a list of ID and
+// value, without the terminating EOL pair.
+func parseAVPairs(info []byte) (ids []uint16, values [][]byte, err error) {
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == avEOL {
+			return ids, values, nil
+		}
+		if len(info) < 4+length {
+			break
+		}
+		ids = append(ids, id)
+		values = append(values, info[4:4+length])
+		info = info[4+length:]
+	}
+	return nil, nil, errors.New("invalid target info in NTLM challenge")
+}
+
+func encodeAVPairs(ids []uint16, values [][]byte) []byte {
+	var buf bytes.Buffer
+	for i, id := range ids {
+		_ = binary.Write(&buf, binary.LittleEndian, id)
+		_ = binary.Write(&buf, binary.LittleEndian, uint16(len(values[i])))
+		buf.Write(values[i])
+	}
+	buf.Write([]byte{0, 0, 0, 0})
+	return buf.Bytes()
+}
+
+// authenticateMessage computes the response to the challenge message of the
+// server.
+func (c *ntlmClient) authenticateMessage(challenge []byte) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], ntlmSignature) ||
+		binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("invalid NTLM challenge message")
+	}
+	c.challenge = challenge
+
+	flags := binary.LittleEndian.Uint32(challenge[20:]) & ntlmDefaultFlags
+	serverChallenge := challenge[24:32]
+	targetInfo, err := field(challenge, 40)
+	if err != nil {
+		return nil, err
+	}
+	ids, values, err := parseAVPairs(targetInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	now := filetime(time.Now())
+	haveTimestamp := false
+	for i, id := range ids {
+		if id == avTimestamp && len(values[i]) == 8 {
+			now = binary.LittleEndian.Uint64(values[i])
+			haveTimestamp = true
+		}
+	}
+
+	// announce that the message contains a MIC
+	micFlags := make([]byte, 4)
+	binary.LittleEndian.PutUint32(micFlags, avFlagMICPresent)
+	ids = append(ids, avFlags)
+	values = append(values, micFlags)
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return c.authenticate(flags, serverChallenge, clientChallenge, now, encodeAVPairs(ids, values), !haveTimestamp)
+}
+
+// ntlmv2Response computes the NT challenge response and the session base key.
+func ntlmv2Response(responseKey, serverChallenge, clientChallenge []byte, timestamp uint64, targetInfo []byte) (response, sessionBaseKey []byte) {
+	temp := make([]byte, 28, 28+len(targetInfo)+4)
+	temp[0], temp[1] = 1, 1
+	binary.LittleEndian.PutUint64(temp[8:], timestamp)
+	copy(temp[16:], clientChallenge)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	proof := hmacMD5(responseKey, serverChallenge, temp)
+	return append(proof, temp...), hmacMD5(responseKey, proof)
+}
+
+func (c *ntlmClient) authenticate(flags uint32, serverChallenge, clientChallenge []byte, timestamp uint64, targetInfo []byte, lmResponse bool) ([]byte, error) {
+	responseKey := ntowfv2(c.user, c.domain, c.password)
+	ntResponse, keyExchangeKey := ntlmv2Response(responseKey, serverChallenge, clientChallenge, timestamp, targetInfo)
+
+	lm := make([]byte, 24)
+	if lmResponse {
+		lm = append(hmacMD5(responseKey, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	sessionKey := keyExchangeKey
+	var encryptedKey []byte
+	if flags&ntlmNegotiateKeyExch != 0 {
+		sessionKey = make([]byte, 16)
+		if _, err := rand.Read(sessionKey); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		cipher, err := rc4.NewCipher(keyExchangeKey)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		encryptedKey = make([]byte, 16)
+		cipher.XORKeyStream(encryptedKey, sessionKey)
+	}
+
+	payloads := [][]byte{lm, ntResponse, encodeUTF16(c.domain), encodeUTF16(c.user), nil, encryptedKey}
+
+	const headerLen = 88
+	msg := make([]byte, headerLen)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	offset := headerLen
+	for i, p := range payloads {
+		pos := 12 + 8*i
+		binary.LittleEndian.PutUint16(msg[pos:], uint16(len(p)))
+		binary.LittleEndian.PutUint16(msg[pos+2:], uint16(len(p)))
+		binary.LittleEndian.PutUint32(msg[pos+4:], uint32(offset))
+		msg = append(msg, p...)
+		offset += len(p)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+	copy(msg[64:], ntlmVersion)
+
+	mic := hmacMD5(sessionKey, c.negotiate, c.challenge, msg)
+	copy(msg[72:], mic)
+
+	c.sessionKey = sessionKey
+	return msg, nil
+}
+
+// sign computes the NTLM message signature with sequence number 0, which is
+// used for the SPNEGO mechListMIC.
+func (c *ntlmClient) sign(msg []byte) []byte {
+	signKey := md5.Sum(append(append([]byte{}, c.sessionKey...), "session key to client-to-server signing key magic constant\x00"...))
+	sealKey := md5.Sum(append(append([]byte{}, c.sessionKey...), "session key to client-to-server sealing key magic constant\x00"...))
+
+	seq := make([]byte, 4)
+	checksum := hmacMD5(signKey[:], seq, msg)[:8]
+	cipher, err := rc4.NewCipher(sealKey[:])
+	if err == nil {
+		cipher.XORKeyStream(checksum, checksum)
+	}
+
+	sig := make([]byte, 16)
+	binary.LittleEndian.PutUint32(sig, 1)
+	copy(sig[4:], checksum)
+	copy(sig[12:], seq)
+	return sig
+}
+
+// Object identifiers and structures used by SPNEGO, see RFC 4178.
+var (
+	oidSPNEGO  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	oidNTLMSSP = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+)
+
+type negTokenInit struct {
+	MechTypes []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	MechToken []byte                  `asn1:"explicit,optional,tag:2"`
+}
+
+type negTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+const negStateReject = 2
+
+// spnegoInit wraps the NTLM negotiate message in the initial SPNEGO token.
+func spnegoInit(token []byte) ([]byte, error) {
+	init, err := asn1.Marshal(negTokenInit{
+		MechTypes: []asn1.ObjectIdentifier{oidNTLMSSP},
+		MechToken: token,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	inner, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: init})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	oid, err := asn1.Marshal(oidSPNEGO)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	buf, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: append(oid, inner...)})
+	return buf, errors.WithStack(err)
+}
+
+// spnegoResponse wraps the NTLM authenticate message in a SPNEGO token.
+func spnegoResponse(token, mic []byte) ([]byte, error) {
+	resp, err := asn1.Marshal(negTokenResp{ResponseToken: token, MechListMIC: mic})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	buf, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: resp})
+	return buf, errors.WithStack(err)
+}
+
+// parseSPNEGOResponse extracts the token from a SPNEGO response sent by the
+// server.
+func parseSPNEGOResponse(buf []byte) ([]byte, error) {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(buf, &raw); err != nil {
+		return nil, errors.Wrap(err, "invalid SPNEGO response")
+	}
+	if raw.Class != asn1.ClassContextSpecific || raw.Tag != 1 {
+		return nil, errors.New("unexpected SPNEGO token")
+	}
+
+	var resp negTokenResp
+	if _, err := asn1.Unmarshal(raw.Bytes, &resp); err != nil {
+		return nil, errors.Wrap(err, "invalid SPNEGO response")
+	}
+	if resp.NegState == negStateReject {
+		return nil, errors.New("authentication rejected by server")
+	}
+	return resp.ResponseToken, nil
+}
+
+// mechListMIC returns the MIC for the list of mechanisms offered in the
+// initial SPNEGO token.
+func (c *ntlmClient) mechListMIC() ([]byte, error) {
+	list, err := asn1.Marshal([]asn1.ObjectIdentifier{oidNTLMSSP})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return c.sign(list), nil
+}
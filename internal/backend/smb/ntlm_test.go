@@ -0,0 +1,46 @@
+package smb
+
+import (
+	"encoding/hex"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func decodeHex(s string) []byte {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// test vectors from [MS-NLMP] 4.2.4
+func TestNTLMv2(t *testing.T) {
+	responseKey := ntowfv2("User", "Domain", "Password")
+	rtest.Equals(t, decodeHex("0c868a403bfd7a93a3001ef22ef02e3f"), responseKey)
+
+	targetInfo := encodeAVPairs([]uint16{2, 1}, [][]byte{encodeUTF16("Domain"), encodeUTF16("Server")})
+	response, sessionBaseKey := ntlmv2Response(responseKey, decodeHex("0123456789abcdef"),
+		decodeHex("aaaaaaaaaaaaaaaa"), 0, targetInfo)
+	rtest.Equals(t, decodeHex("68cd0ab851e51c96aabc927bebef6a1c"), response[:16])
+	rtest.Equals(t, decodeHex("8de40ccadbc14a82f15cb0ad0de95ca3"), sessionBaseKey)
+}
+
+// test vectors from RFC 4493
+func TestCMAC(t *testing.T) {
+	key := decodeHex("2b7e151628aed2a6abf7158809cf4f3c")
+	msg := decodeHex("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+
+	for _, test := range []struct {
+		length int
+		mac    string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		rtest.Equals(t, decodeHex(test.mac), cmac(key, msg[:test.length]))
+	}
+}
@@ -0,0 +1,110 @@
+package smb
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// kdf implements the SP800-108 key derivation in counter mode with
+// HMAC-SHA256, which derives the signing key for SMB 3.
+func kdf(key, label, context []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte{0, 0, 0, 1})
+	_, _ = h.Write(label)
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(context)
+	_, _ = h.Write([]byte{0, 0, 0, 128})
+	return h.Sum(nil)[:16]
+}
+
+// cmac computes the AES-CMAC of msg as specified in RFC 4493.
+func cmac(key, msg []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	shift := func(in []byte) []byte {
+		out := make([]byte, len(in))
+		for i := range in {
+			out[i] = in[i] << 1
+			if i+1 < len(in) {
+				out[i] |= in[i+1] >> 7
+			}
+		}
+		if in[0]&0x80 != 0 {
+			out[len(out)-1] ^= 0x87
+		}
+		return out
+	}
+
+	l := make([]byte, aes.BlockSize)
+	block.Encrypt(l, l)
+	k1 := shift(l)
+	k2 := shift(k1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+	if n > 0 && len(msg)%aes.BlockSize == 0 {
+		copy(last, msg[(n-1)*aes.BlockSize:])
+		xor(last, k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[(n-1)*aes.BlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xor(last, k2)
+	}
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xor(x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(x, x)
+	}
+	xor(x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+func xor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// signer computes the signature of SMB2 messages.
+type signer struct {
+	key  []byte
+	smb3 bool
+}
+
+func newSigner(dialect uint16, sessionKey []byte) *signer {
+	if dialect >= dialectSMB30 {
+		return &signer{key: kdf(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00")), smb3: true}
+	}
+	return &signer{key: sessionKey}
+}
+
+// sign sets the signature of the message, which must start with the SMB2
+// header.
+func (s *signer) sign(msg []byte) {
+	binary.LittleEndian.PutUint32(msg[16:], binary.LittleEndian.Uint32(msg[16:])|flagSigned)
+	sig := msg[48:64]
+	for i := range sig {
+		sig[i] = 0
+	}
+
+	var mac []byte
+	if s.smb3 {
+		mac = cmac(s.key, msg)
+	} else {
+		h := hmac.New(sha256.New, s.key)
+		_, _ = h.Write(msg)
+		mac = h.Sum(nil)
+	}
+	copy(sig, mac)
+}
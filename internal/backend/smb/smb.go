@@ -0,0 +1,452 @@
+package smb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"hash"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/layout"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// make sure the SMB backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// Backend stores data in a directory on an SMB file share.
+type Backend struct {
+	cfg Config
+	layout.Layout
+
+	m    sync.Mutex
+	idle []*conn
+}
+
+// Open connects to the share configured in cfg.
+func Open(ctx context.Context, cfg Config) (*Backend, error) {
+	debug.Log("open smb backend at %v/%v/%v", cfg.Host, cfg.Share, cfg.Path)
+
+	be := &Backend{
+		cfg:    cfg,
+		Layout: &layout.DefaultLayout{Path: cfg.Path, Join: path.Join},
+	}
+
+	// connect once to report invalid credentials early
+	c, err := dial(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	be.release(c)
+
+	return be, nil
+}
+
+// Create creates the repository directories on the share configured in cfg.
+func Create(ctx context.Context, cfg Config) (*Backend, error) {
+	be, err := Open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = be.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err == nil {
+		_ = be.Close()
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	err = be.withConn(ctx, func(c *conn) error {
+		for _, dir := range be.Paths() {
+			if err := mkdirAll(ctx, c, dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	return be, nil
+}
+
+// acquire runs fn with an idle or a new connection and returns the
+// connection if fn succeeded. If the connection turns out to be broken, for
+// example because the server closed an idle connection, fn is run again using a
+// new connection.
+func (b *Backend) acquire(ctx context.Context, fn func(c *conn) error) (*conn, error) {
+	for attempt := 0; ; attempt++ {
+		var c *conn
+		if attempt == 0 {
+			b.m.Lock()
+			if len(b.idle) > 0 {
+				c = b.idle[len(b.idle)-1]
+				b.idle = b.idle[:len(b.idle)-1]
+			}
+			b.m.Unlock()
+		}
+
+		if c == nil {
+			var err error
+			c, err = dial(ctx, b.cfg)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := fn(c)
+		if err == nil {
+			return c, nil
+		}
+		b.release(c)
+
+		if !c.broken || attempt > 0 || ctx.Err() != nil {
+			return nil, err
+		}
+		debug.Log("connection failed, reconnecting: %v", err)
+	}
+}
+
+// release returns c to the pool of idle connections.
+func (b *Backend) release(c *conn) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if c.broken || uint(len(b.idle)) >= b.cfg.Connections {
+		_ = c.close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+func (b *Backend) withConn(ctx context.Context, fn func(c *conn) error) error {
+	c, err := b.acquire(ctx, fn)
+	if err != nil {
+		return err
+	}
+	b.release(c)
+	return nil
+}
+
+// mkdirAll creates the directory dir and all missing parents.
+func mkdirAll(ctx context.Context, c *conn, dir string) error {
+	if dir == "" || dir == "." || dir == "/" {
+		return nil
+	}
+
+	res, err := c.create(ctx, dir, accessReadAttributes|accessSynchronize, dispositionOpenIf, optionDirectory)
+	if hasStatus(err, statusObjectPathNotFound) {
+		if err := mkdirAll(ctx, c, path.Dir(dir)); err != nil {
+			return err
+		}
+		res, err = c.create(ctx, dir, accessReadAttributes|accessSynchronize, dispositionOpenIf, optionDirectory)
+	}
+	if err != nil {
+		return err
+	}
+	return c.closeFile(ctx, res.id)
+}
+
+func (b *Backend) Connections() uint {
+	return b.cfg.Connections
+}
+
+// Location returns this backend's location.
+func (b *Backend) Location() string {
+	return "smb://" + path.Join(b.cfg.Host, b.cfg.Share, b.cfg.Path)
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (b *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// HasAtomicReplace returns whether Save() can atomically replace files
+func (b *Backend) HasAtomicReplace() bool {
+	return true
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (b *Backend) IsNotExist(err error) bool {
+	return hasStatus(err, statusObjectNameNotFound, statusObjectPathNotFound, statusNoSuchFile)
+}
+
+// Save stores data in the backend at the handle.
+func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) (err error) {
+	defer func() {
+		// Mark non-retriable errors as such
+		if hasStatus(err, statusDiskFull, statusAccessDenied) {
+			err = backoff.Permanent(err)
+		}
+	}()
+
+	finalname := b.Filename(h)
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return errors.WithStack(err)
+	}
+	tmpname := finalname + "-tmp-" + hex.EncodeToString(suffix)
+
+	return b.withConn(ctx, func(c *conn) error {
+		if err := rd.Rewind(); err != nil {
+			return err
+		}
+
+		res, err := c.create(ctx, tmpname, accessWriteData|accessDelete|accessSynchronize, dispositionCreate, optionNonDirectory)
+		if hasStatus(err, statusObjectPathNotFound) {
+			debug.Log("error %v: creating dir", err)
+			if err := mkdirAll(ctx, c, path.Dir(finalname)); err != nil {
+				return err
+			}
+			res, err = c.create(ctx, tmpname, accessWriteData|accessDelete|accessSynchronize, dispositionCreate, optionNonDirectory)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = b.upload(ctx, c, res.id, rd)
+		if err == nil {
+			err = c.rename(ctx, res.id, finalname)
+		}
+		if cerr := c.closeFile(ctx, res.id); err == nil {
+			err = cerr
+		}
+		if err != nil && !c.broken {
+			b.removeFile(ctx, c, tmpname)
+		}
+		return err
+	})
+}
+
+func (b *Backend) upload(ctx context.Context, c *conn, id fileID, rd restic.RewindReader) error {
+	buf := make([]byte, maxIOSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(rd, buf[:c.chunkSize(c.maxWrite)])
+		if n > 0 {
+			written, werr := c.write(ctx, id, offset, buf[:n])
+			if werr != nil {
+				return werr
+			}
+			if written != n {
+				return errors.Errorf("short write (%d < %d bytes)", written, n)
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if offset != rd.Length() {
+		return errors.Errorf("wrote %d bytes instead of the expected %d bytes", offset, rd.Length())
+	}
+	return c.flush(ctx, id)
+}
+
+// removeFile deletes a temporary file, errors are only logged.
+func (b *Backend) removeFile(ctx context.Context, c *conn, name string) {
+	res, err := c.create(ctx, name, accessDelete, dispositionOpen, optionNonDirectory|optionDeleteOnClose)
+	if err == nil {
+		err = c.closeFile(ctx, res.id)
+	}
+	if err != nil {
+		debug.Log("removing %v failed: %v", name, err)
+	}
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (b *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return backend.DefaultLoad(ctx, h, length, offset, b.openReader, fn)
+}
+
+func (b *Backend) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	var res createResult
+	c, err := b.acquire(ctx, func(c *conn) error {
+		var err error
+		res, err = c.create(ctx, b.Filename(h), accessReadData|accessSynchronize, dispositionOpen, optionNonDirectory)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rd := &fileReader{ctx: ctx, b: b, c: c, id: res.id, offset: offset, remaining: -1}
+	if length > 0 {
+		rd.remaining = int64(length)
+	}
+	return rd, nil
+}
+
+// fileReader reads an open file, the connection is released when the reader
+// is closed.
+type fileReader struct {
+	ctx context.Context
+	b   *Backend
+	c   *conn
+	id  fileID
+
+	offset    int64
+	remaining int64 // -1 means up to the end of the file
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	size := len(p)
+	if max := r.c.chunkSize(r.c.maxRead); size > max {
+		size = max
+	}
+	if r.remaining > 0 && int64(size) > r.remaining {
+		size = int(r.remaining)
+	}
+
+	data, err := r.c.read(r.ctx, r.id, r.offset, size)
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, data)
+	r.offset += int64(n)
+	if r.remaining > 0 {
+		r.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+func (r *fileReader) Close() error {
+	err := r.c.closeFile(r.ctx, r.id)
+	r.b.release(r.c)
+	return err
+}
+
+// Stat returns information about a blob.
+func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	var fi restic.FileInfo
+	err := b.withConn(ctx, func(c *conn) error {
+		res, err := c.create(ctx, b.Filename(h), accessReadAttributes|accessSynchronize, dispositionOpen, optionNonDirectory)
+		if err != nil {
+			return err
+		}
+		fi = restic.FileInfo{Size: res.size, Name: h.Name}
+		return c.closeFile(ctx, res.id)
+	})
+	return fi, err
+}
+
+// Remove removes the blob with the given name and type.
+func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	return b.withConn(ctx, func(c *conn) error {
+		res, err := c.create(ctx, b.Filename(h), accessDelete, dispositionOpen, optionNonDirectory|optionDeleteOnClose)
+		if err != nil {
+			return err
+		}
+		return c.closeFile(ctx, res.id)
+	})
+}
+
+// readDir returns the entries of the directory dir. A missing directory is
+// treated as empty.
+func (b *Backend) readDir(ctx context.Context, dir string) ([]dirEntry, error) {
+	var entries []dirEntry
+	err := b.withConn(ctx, func(c *conn) error {
+		entries = nil
+		res, err := c.create(ctx, dir, accessReadData|accessReadAttributes|accessSynchronize, dispositionOpen, optionDirectory)
+		if b.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for {
+			batch, err := c.queryDirectory(ctx, res.id)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				_ = c.closeFile(ctx, res.id)
+				return err
+			}
+			entries = append(entries, batch...)
+		}
+		return c.closeFile(ctx, res.id)
+	})
+	return entries, err
+}
+
+// List runs fn for each file in the backend which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	basedir, subdirs := b.Basedir(t)
+
+	entries, err := b.readDir(ctx, basedir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var files []dirEntry
+		switch {
+		case entry.isDir && subdirs:
+			files, err = b.readDir(ctx, path.Join(basedir, entry.name))
+			if err != nil {
+				return err
+			}
+		case !entry.isDir:
+			files = []dirEntry{entry}
+		}
+
+		for _, file := range files {
+			if file.isDir {
+				continue
+			}
+
+			err := fn(restic.FileInfo{Name: file.name, Size: file.size})
+			if err != nil {
+				return err
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Close closes all idle connections.
+func (b *Backend) Close() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	for _, c := range b.idle {
+		_ = c.close()
+	}
+	b.idle = nil
+	return nil
+}
+
+// Delete removes all data in the backend.
+func (b *Backend) Delete(ctx context.Context) error {
+	return backend.DefaultDelete(ctx, b)
+}
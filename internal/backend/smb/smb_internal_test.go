@@ -0,0 +1,595 @@
+package smb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rc4"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/options"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeServer implements the subset of SMB2 used by the backend on top of a
+// local directory. It requires signed requests and sends interim responses
+// for writes.
+type fakeServer struct {
+	t        testing.TB
+	ln       net.Listener
+	root     string
+	dialect  uint16
+	password string
+
+	m     sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeServer(t testing.TB, dialect uint16) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	rtest.OK(t, err)
+
+	s := &fakeServer{t: t, ln: ln, root: rtest.TempDir(t), dialect: dialect, password: "secret"}
+	go s.accept()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.dropConnections()
+	})
+	return s
+}
+
+func (s *fakeServer) config(path string) Config {
+	cfg := NewConfig()
+	cfg.Host, cfg.Port, _ = net.SplitHostPort(s.ln.Addr().String())
+	cfg.Share = "share"
+	cfg.Path = path
+	cfg.User = "user"
+	cfg.Domain = "DOMAIN"
+	cfg.Password = options.NewSecretString(s.password)
+	return cfg
+}
+
+func (s *fakeServer) accept() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.m.Lock()
+		s.conns = append(s.conns, nc)
+		s.m.Unlock()
+
+		sc := &serverConn{s: s, nc: nc, files: make(map[uint64]*openFile)}
+		go sc.serve()
+	}
+}
+
+// dropConnections closes all connections, like a server restart would.
+func (s *fakeServer) dropConnections() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, nc := range s.conns {
+		_ = nc.Close()
+	}
+	s.conns = nil
+}
+
+type openFile struct {
+	path          string
+	f             *os.File
+	deleteOnClose bool
+	listed        []os.DirEntry
+	listDone      bool
+}
+
+type serverConn struct {
+	s      *fakeServer
+	nc     net.Conn
+	files  map[uint64]*openFile
+	nextID uint64
+
+	ntlmNegotiate, ntlmChallenge []byte
+	serverChallenge              []byte
+	signer                       *signer
+}
+
+func (c *serverConn) serve() {
+	defer func() { _ = c.nc.Close() }()
+	for {
+		var prefix [4]byte
+		if _, err := io.ReadFull(c.nc, prefix[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(prefix[:]))
+		if _, err := io.ReadFull(c.nc, msg); err != nil {
+			return
+		}
+
+		status, body := c.verify(msg)
+		if status == statusSuccess {
+			status, body = c.handle(msg[:headerSize], msg[headerSize:])
+		}
+		if err := c.reply(msg[:headerSize], status, body); err != nil {
+			return
+		}
+	}
+}
+
+// verify checks the signature of a request.
+func (c *serverConn) verify(msg []byte) (uint32, []byte) {
+	if c.signer == nil {
+		return statusSuccess, nil
+	}
+	if binary.LittleEndian.Uint32(msg[16:])&flagSigned == 0 {
+		return statusAccessDenied, nil
+	}
+	check := append([]byte{}, msg...)
+	c.signer.sign(check)
+	if !bytes.Equal(check[48:64], msg[48:64]) {
+		return statusAccessDenied, nil
+	}
+	return statusSuccess, nil
+}
+
+func (c *serverConn) reply(req []byte, status uint32, body []byte) error {
+	cmd := binary.LittleEndian.Uint16(req[12:])
+	if cmd == cmdWrite && status == statusSuccess {
+		// announce that the request will take longer
+		if err := c.send(req, flagAsync, statusPending, make([]byte, 9)); err != nil {
+			return err
+		}
+	}
+
+	if status != statusSuccess && status != statusMoreProcessingRequired && body == nil {
+		body = make([]byte, 9)
+		binary.LittleEndian.PutUint16(body, 9)
+	}
+	return c.send(req, 0, status, body)
+}
+
+func (c *serverConn) send(req []byte, flags, status uint32, body []byte) error {
+	msg := make([]byte, 4+headerSize+len(body))
+	binary.BigEndian.PutUint32(msg, uint32(headerSize+len(body)))
+	h := msg[4:]
+	copy(h, req[:headerSize])
+	binary.LittleEndian.PutUint32(h[8:], status)
+	binary.LittleEndian.PutUint16(h[14:], binary.LittleEndian.Uint16(req[14:]))
+	binary.LittleEndian.PutUint32(h[16:], flagResponse|flags)
+	binary.LittleEndian.PutUint64(h[40:], 0x1234)
+	binary.LittleEndian.PutUint32(h[36:], 7)
+	for i := 48; i < 64; i++ {
+		h[i] = 0
+	}
+	copy(h[headerSize:], body)
+
+	_, err := c.nc.Write(msg)
+	return err
+}
+
+func (c *serverConn) handle(hdr, req []byte) (uint32, []byte) {
+	charge := int(binary.LittleEndian.Uint16(hdr[6:]))
+
+	switch binary.LittleEndian.Uint16(hdr[12:]) {
+	case cmdNegotiate:
+		return c.negotiate(req)
+	case cmdSessionSetup:
+		return c.sessionSetup(req)
+	case cmdTreeConnect:
+		name := decodeUTF16(req[8:])
+		if !strings.HasSuffix(name, `\share`) {
+			return statusBadNetworkName, nil
+		}
+		resp := make([]byte, 16)
+		binary.LittleEndian.PutUint16(resp, 16)
+		resp[2] = shareTypeDisk
+		return statusSuccess, resp
+	case cmdCreate:
+		length := int(binary.LittleEndian.Uint16(req[46:]))
+		return c.create(decodeUTF16(req[56:56+length]),
+			binary.LittleEndian.Uint32(req[36:]), binary.LittleEndian.Uint32(req[40:]))
+	case cmdClose:
+		f, status := c.file(req[8:])
+		if f == nil {
+			return status, nil
+		}
+		if f.f != nil {
+			_ = f.f.Close()
+		}
+		if f.deleteOnClose {
+			_ = os.Remove(f.path)
+		}
+		delete(c.files, binary.LittleEndian.Uint64(req[8:]))
+		resp := make([]byte, 60)
+		binary.LittleEndian.PutUint16(resp, 60)
+		return statusSuccess, resp
+	case cmdFlush:
+		resp := make([]byte, 4)
+		binary.LittleEndian.PutUint16(resp, 4)
+		return statusSuccess, resp
+	case cmdRead:
+		length := int(binary.LittleEndian.Uint32(req[4:]))
+		if length > charge*creditSize {
+			return statusInvalidParameter, nil
+		}
+		f, status := c.file(req[16:])
+		if f == nil {
+			return status, nil
+		}
+		buf := make([]byte, length)
+		n, err := f.f.ReadAt(buf, int64(binary.LittleEndian.Uint64(req[8:])))
+		if n == 0 && err == io.EOF {
+			return statusEndOfFile, nil
+		}
+		resp := make([]byte, 16+n)
+		binary.LittleEndian.PutUint16(resp, 17)
+		resp[2] = headerSize + 16
+		binary.LittleEndian.PutUint32(resp[4:], uint32(n))
+		copy(resp[16:], buf[:n])
+		return statusSuccess, resp
+	case cmdWrite:
+		length := int(binary.LittleEndian.Uint32(req[4:]))
+		if length > charge*creditSize {
+			return statusInvalidParameter, nil
+		}
+		f, status := c.file(req[16:])
+		if f == nil {
+			return status, nil
+		}
+		offset := int(binary.LittleEndian.Uint16(req[2:])) - headerSize
+		n, err := f.f.WriteAt(req[offset:offset+length], int64(binary.LittleEndian.Uint64(req[8:])))
+		if err != nil {
+			return statusDiskFull, nil
+		}
+		resp := make([]byte, 16)
+		binary.LittleEndian.PutUint16(resp, 17)
+		binary.LittleEndian.PutUint32(resp[4:], uint32(n))
+		return statusSuccess, resp
+	case cmdQueryDirectory:
+		f, status := c.file(req[8:])
+		if f == nil {
+			return status, nil
+		}
+		return c.queryDirectory(f)
+	case cmdSetInfo:
+		f, status := c.file(req[16:])
+		if f == nil {
+			return status, nil
+		}
+		info := req[32:]
+		target := c.path(decodeUTF16(info[20 : 20+binary.LittleEndian.Uint32(info[16:])]))
+		if err := os.Rename(f.path, target); err != nil {
+			return statusObjectPathNotFound, nil
+		}
+		f.path = target
+		resp := make([]byte, 2)
+		binary.LittleEndian.PutUint16(resp, 2)
+		return statusSuccess, resp
+	}
+	return statusNotSupported, nil
+}
+
+func (c *serverConn) negotiate(req []byte) (uint32, []byte) {
+	count := int(binary.LittleEndian.Uint16(req[2:]))
+	found := false
+	for i := 0; i < count; i++ {
+		if binary.LittleEndian.Uint16(req[36+2*i:]) == c.s.dialect {
+			found = true
+		}
+	}
+	if !found {
+		return statusNotSupported, nil
+	}
+
+	resp := make([]byte, 64)
+	binary.LittleEndian.PutUint16(resp, 65)
+	binary.LittleEndian.PutUint16(resp[2:], securitySigningEnabled|securitySigningRequired)
+	binary.LittleEndian.PutUint16(resp[4:], c.s.dialect)
+	binary.LittleEndian.PutUint32(resp[28:], 8*1024*1024)
+	// use a small maximum size so that large files need several requests
+	binary.LittleEndian.PutUint32(resp[32:], 256*1024)
+	binary.LittleEndian.PutUint32(resp[36:], 256*1024)
+	return statusSuccess, resp
+}
+
+func (c *serverConn) sessionSetupResponse(token []byte) []byte {
+	resp := make([]byte, 8+len(token))
+	binary.LittleEndian.PutUint16(resp, 9)
+	binary.LittleEndian.PutUint16(resp[4:], headerSize+8)
+	binary.LittleEndian.PutUint16(resp[6:], uint16(len(token)))
+	copy(resp[8:], token)
+	return resp
+}
+
+func (c *serverConn) sessionSetup(req []byte) (uint32, []byte) {
+	length := int(binary.LittleEndian.Uint16(req[14:]))
+	token := req[24 : 24+length]
+	i := bytes.Index(token, ntlmSignature)
+	if i < 0 {
+		return statusInvalidParameter, nil
+	}
+	msg := token[i:]
+
+	switch binary.LittleEndian.Uint32(msg[8:]) {
+	case 1:
+		c.ntlmNegotiate = append([]byte{}, msg[:40]...)
+		c.serverChallenge = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+		timestamp := make([]byte, 8)
+		binary.LittleEndian.PutUint64(timestamp, filetime(time.Now()))
+		info := encodeAVPairs([]uint16{2, avTimestamp}, [][]byte{encodeUTF16("DOMAIN"), timestamp})
+
+		challenge := make([]byte, 56, 56+len(info))
+		copy(challenge, ntlmSignature)
+		binary.LittleEndian.PutUint32(challenge[8:], 2)
+		binary.LittleEndian.PutUint32(challenge[20:], ntlmDefaultFlags)
+		copy(challenge[24:], c.serverChallenge)
+		binary.LittleEndian.PutUint16(challenge[40:], uint16(len(info)))
+		binary.LittleEndian.PutUint32(challenge[44:], 56)
+		challenge = append(challenge, info...)
+		c.ntlmChallenge = challenge
+
+		resp, err := asn1.Marshal(negTokenResp{NegState: 1, SupportedMech: oidNTLMSSP, ResponseToken: challenge})
+		rtest.OK(c.s.t, err)
+		wrapped, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: resp})
+		rtest.OK(c.s.t, err)
+		return statusMoreProcessingRequired, c.sessionSetupResponse(wrapped)
+
+	case 3:
+		// the token may also contain the mechListMIC after the message
+		msg, err := parseSPNEGOResponse(token)
+		rtest.OK(c.s.t, err)
+
+		ntResponse, err := field(msg, 20)
+		rtest.OK(c.s.t, err)
+		domain, err := field(msg, 28)
+		rtest.OK(c.s.t, err)
+		user, err := field(msg, 36)
+		rtest.OK(c.s.t, err)
+		encryptedKey, err := field(msg, 52)
+		rtest.OK(c.s.t, err)
+
+		responseKey := ntowfv2(decodeUTF16(user), decodeUTF16(domain), c.s.password)
+		proof := hmacMD5(responseKey, c.serverChallenge, ntResponse[16:])
+		if !bytes.Equal(proof, ntResponse[:16]) {
+			return statusLogonFailure, nil
+		}
+
+		sessionKey := hmacMD5(responseKey, proof)
+		if binary.LittleEndian.Uint32(msg[60:])&ntlmNegotiateKeyExch != 0 {
+			cipher, err := rc4.NewCipher(sessionKey)
+			rtest.OK(c.s.t, err)
+			exported := make([]byte, 16)
+			cipher.XORKeyStream(exported, encryptedKey)
+			sessionKey = exported
+		}
+
+		// check the MIC over all three messages
+		auth := append([]byte{}, msg...)
+		mic := append([]byte{}, auth[72:88]...)
+		copy(auth[72:88], make([]byte, 16))
+		if !bytes.Equal(mic, hmacMD5(sessionKey, c.ntlmNegotiate, c.ntlmChallenge, auth)) {
+			return statusLogonFailure, nil
+		}
+
+		c.signer = newSigner(c.s.dialect, sessionKey)
+		resp, err := asn1.Marshal(negTokenResp{NegState: 0})
+		rtest.OK(c.s.t, err)
+		wrapped, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: resp})
+		rtest.OK(c.s.t, err)
+		return statusSuccess, c.sessionSetupResponse(wrapped)
+	}
+	return statusInvalidParameter, nil
+}
+
+func (c *serverConn) path(name string) string {
+	return filepath.Join(c.s.root, filepath.FromSlash(strings.ReplaceAll(name, `\`, "/")))
+}
+
+func (c *serverConn) file(id []byte) (*openFile, uint32) {
+	f, ok := c.files[binary.LittleEndian.Uint64(id)]
+	if !ok {
+		return nil, statusInvalidParameter
+	}
+	return f, statusSuccess
+}
+
+func notFound(p string) uint32 {
+	if _, err := os.Stat(filepath.Dir(p)); err != nil {
+		return statusObjectPathNotFound
+	}
+	return statusObjectNameNotFound
+}
+
+func (c *serverConn) create(name string, disposition, options uint32) (uint32, []byte) {
+	p := c.path(name)
+	f := &openFile{path: p, deleteOnClose: options&optionDeleteOnClose != 0}
+
+	if options&optionDirectory != 0 {
+		fi, err := os.Stat(p)
+		switch {
+		case err == nil && !fi.IsDir():
+			return statusInvalidParameter, nil
+		case err == nil && disposition == dispositionCreate:
+			return statusObjectNameCollision, nil
+		case os.IsNotExist(err) && disposition == dispositionOpen:
+			return notFound(p), nil
+		case os.IsNotExist(err):
+			if err := os.Mkdir(p, 0700); err != nil {
+				return notFound(p), nil
+			}
+		}
+	} else {
+		flags := os.O_RDWR
+		if disposition == dispositionCreate {
+			flags |= os.O_CREATE | os.O_EXCL
+		}
+		fd, err := os.OpenFile(p, flags, 0600)
+		switch {
+		case os.IsExist(err):
+			return statusObjectNameCollision, nil
+		case os.IsNotExist(err):
+			return notFound(p), nil
+		case err != nil:
+			return statusInvalidParameter, nil
+		}
+		f.f = fd
+	}
+
+	fi, err := os.Stat(p)
+	rtest.OK(c.s.t, err)
+
+	c.nextID++
+	c.files[c.nextID] = f
+
+	resp := make([]byte, 88)
+	binary.LittleEndian.PutUint16(resp, 89)
+	binary.LittleEndian.PutUint64(resp[48:], uint64(fi.Size()))
+	attrs := uint32(attrNormal)
+	if fi.IsDir() {
+		attrs = attrDirectory
+	}
+	binary.LittleEndian.PutUint32(resp[56:], attrs)
+	binary.LittleEndian.PutUint64(resp[64:], c.nextID)
+	return statusSuccess, resp
+}
+
+func (c *serverConn) queryDirectory(f *openFile) (uint32, []byte) {
+	if f.listed == nil && !f.listDone {
+		entries, err := os.ReadDir(f.path)
+		if err != nil {
+			return statusInvalidParameter, nil
+		}
+		f.listed = entries
+	}
+
+	names := []string{}
+	if !f.listDone {
+		names = append(names, ".", "..")
+		f.listDone = true
+	}
+	// return at most ten entries per response
+	for len(f.listed) > 0 && len(names) < 10 {
+		names = append(names, f.listed[0].Name())
+		f.listed = f.listed[1:]
+	}
+	if len(names) == 0 {
+		return statusNoMoreFiles, nil
+	}
+
+	var buf []byte
+	for i, name := range names {
+		n := encodeUTF16(name)
+		entry := make([]byte, 64+len(n))
+		fi, err := os.Stat(filepath.Join(f.path, name))
+		rtest.OK(c.s.t, err)
+		binary.LittleEndian.PutUint64(entry[40:], uint64(fi.Size()))
+		if fi.IsDir() {
+			binary.LittleEndian.PutUint32(entry[56:], attrDirectory)
+		}
+		binary.LittleEndian.PutUint32(entry[60:], uint32(len(n)))
+		copy(entry[64:], n)
+		for len(entry)%8 != 0 {
+			entry = append(entry, 0)
+		}
+		if i < len(names)-1 {
+			binary.LittleEndian.PutUint32(entry, uint32(len(entry)))
+		}
+		buf = append(buf, entry...)
+	}
+
+	resp := make([]byte, 8+len(buf))
+	binary.LittleEndian.PutUint16(resp, 9)
+	binary.LittleEndian.PutUint16(resp[2:], headerSize+8)
+	binary.LittleEndian.PutUint32(resp[4:], uint32(len(buf)))
+	copy(resp[8:], buf)
+	return statusSuccess, resp
+}
+
+func newTestSuite(t testing.TB, s *fakeServer) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			return s.config("repos/" + restic.NewRandomID().String()), nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			return Create(context.TODO(), config.(Config))
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			return Open(context.TODO(), config.(Config))
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestBackendSMB21(t *testing.T) {
+	newTestSuite(t, newFakeServer(t, dialectSMB21)).RunTests(t)
+}
+
+func TestBackendSMB302(t *testing.T) {
+	newTestSuite(t, newFakeServer(t, dialectSMB302)).RunTests(t)
+}
+
+func TestReconnect(t *testing.T) {
+	s := newFakeServer(t, dialectSMB302)
+	be, err := Create(context.TODO(), s.config("repo"))
+	rtest.OK(t, err)
+	defer func() { rtest.OK(t, be.Close()) }()
+
+	data := rtest.Random(42, 3*1024*1024)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data, nil)))
+
+	// the idle connections are now broken
+	s.dropConnections()
+
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+
+	s.dropConnections()
+
+	var buf []byte
+	rtest.OK(t, be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = io.ReadAll(rd)
+		return err
+	}))
+	rtest.Equals(t, data, buf)
+}
+
+func TestWrongPassword(t *testing.T) {
+	s := newFakeServer(t, dialectSMB21)
+	cfg := s.config("repo")
+	cfg.Password = options.NewSecretString("wrong")
+
+	_, err := Open(context.TODO(), cfg)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "wrong user name or password"),
+		"unexpected error %v", err)
+}
+
+func TestMissingShare(t *testing.T) {
+	s := newFakeServer(t, dialectSMB21)
+	cfg := s.config("repo")
+	cfg.Share = "other"
+
+	_, err := Open(context.TODO(), cfg)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "does not exist"),
+		"unexpected error %v", err)
+}
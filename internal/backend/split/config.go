@@ -0,0 +1,57 @@
+package split
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Config contains the locations of the backend for metadata (Hot) and for the
+// pack files containing file data (Cold).
+type Config struct {
+	Hot  string
+	Cold string
+}
+
+// ParseConfig parses the string s and extracts the locations of both backends.
+// The supported configuration format is split:hot=location1,cold=location2.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "split:") {
+		return nil, errors.New(`invalid format, does not start with "split:"`)
+	}
+
+	var cfg Config
+	for _, part := range strings.Split(s[len("split:"):], ",") {
+		key, loc, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, errors.Errorf("split: invalid part %q, expected hot=location or cold=location", part)
+		}
+		if loc == "" {
+			return nil, errors.Errorf("split: empty %v location", key)
+		}
+		if strings.HasPrefix(loc, "split:") {
+			return nil, errors.New("split: nested locations are not supported")
+		}
+
+		switch key {
+		case "hot":
+			if cfg.Hot != "" {
+				return nil, errors.New("split: hot location specified twice")
+			}
+			cfg.Hot = loc
+		case "cold":
+			if cfg.Cold != "" {
+				return nil, errors.New("split: cold location specified twice")
+			}
+			cfg.Cold = loc
+		default:
+			return nil, errors.Errorf("split: unknown key %q", key)
+		}
+	}
+
+	if cfg.Hot == "" || cfg.Cold == "" {
+		return nil, errors.New("split: both a hot and a cold location are required")
+	}
+
+	return cfg, nil
+}
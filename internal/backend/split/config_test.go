@@ -0,0 +1,55 @@
+package split
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	var tests = []struct {
+		s   string
+		cfg Config
+	}{
+		{"split:hot=/srv/repo,cold=s3:s3.amazonaws.com/bucket", Config{
+			Hot:  "/srv/repo",
+			Cold: "s3:s3.amazonaws.com/bucket",
+		}},
+		{"split:cold=b2:bucket:/repo,hot=sftp:user@host:/srv/repo", Config{
+			Hot:  "sftp:user@host:/srv/repo",
+			Cold: "b2:bucket:/repo",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			cfg, err := ParseConfig(test.s)
+			if err != nil {
+				t.Fatalf("%s failed: %v", test.s, err)
+			}
+
+			if !reflect.DeepEqual(cfg, test.cfg) {
+				t.Fatalf("wrong config, want:\n  %#v\ngot:\n  %#v", test.cfg, cfg)
+			}
+		})
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, s := range []string{
+		"split:",
+		"split:hot=/srv/repo",
+		"split:hot=/srv/repo,cold=",
+		"split:hot=/srv/repo,cold=/tmp/a,cold=/tmp/b",
+		"split:hot=/srv/repo,warm=/tmp/a",
+		"split:/srv/repo,/tmp/a",
+		"split:hot=/srv/repo,cold=split:hot=/a",
+		"mirror:/srv/repo,/tmp/a",
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := ParseConfig(s)
+			if err == nil {
+				t.Fatalf("expected error for %q", s)
+			}
+		})
+	}
+}
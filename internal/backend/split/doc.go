@@ -0,0 +1,4 @@
+// Package split implements a backend which stores the data packs of a
+// repository in a different backend than all other files. This allows keeping
+// the metadata on fast storage and the bulk of the data on cheap, slow storage.
+package split
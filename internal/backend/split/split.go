@@ -0,0 +1,184 @@
+package split
+
+import (
+	"context"
+	"hash"
+	"io"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend stores pack files with file data in the cold backend and all other
+// files, including the pack files with trees, in the hot backend.
+type Backend struct {
+	hot  restic.Backend
+	cold restic.Backend
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// New returns a backend which stores the data packs in cold and everything
+// else in hot.
+func New(hot, cold restic.Backend) *Backend {
+	debug.Log("created new split backend, hot %v, cold %v", hot.Location(), cold.Location())
+	return &Backend{hot: hot, cold: cold}
+}
+
+// Hot returns the backend which stores the metadata.
+func (be *Backend) Hot() restic.Backend {
+	return be.hot
+}
+
+// Cold returns the backend which stores the data packs.
+func (be *Backend) Cold() restic.Backend {
+	return be.cold
+}
+
+// Location returns the locations of both backends.
+func (be *Backend) Location() string {
+	return "split:hot=" + be.hot.Location() + ",cold=" + be.cold.Location()
+}
+
+// Connections returns the higher number of connections of both backends, the
+// connections of each backend are limited separately.
+func (be *Backend) Connections() uint {
+	if c := be.cold.Connections(); c > be.hot.Connections() {
+		return c
+	}
+	return be.hot.Connections()
+}
+
+// Hasher returns nil, the backends may use different hash functions. Save
+// computes the hash for the backend which stores the file.
+func (be *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// HasAtomicReplace returns whether both backends can atomically replace files.
+func (be *Backend) HasAtomicReplace() bool {
+	return be.hot.HasAtomicReplace() && be.cold.HasAtomicReplace()
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file in
+// one of the backends.
+func (be *Backend) IsNotExist(err error) bool {
+	return be.hot.IsNotExist(err) || be.cold.IsNotExist(err)
+}
+
+// backends returns the backends which may store the file, in the order in
+// which they are tried. Pack files whose content type is unknown are searched
+// in the hot backend first, as accessing it is cheaper.
+func (be *Backend) backends(h restic.Handle) []restic.Backend {
+	if h.Type != restic.PackFile {
+		return []restic.Backend{be.hot}
+	}
+
+	switch h.ContainedBlobType {
+	case restic.DataBlob:
+		return []restic.Backend{be.cold, be.hot}
+	default:
+		return []restic.Backend{be.hot, be.cold}
+	}
+}
+
+// Save stores the file in the hot backend, unless it is a pack file which
+// does not contain trees.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	b := be.hot
+	if h.Type == restic.PackFile && h.ContainedBlobType != restic.TreeBlob {
+		b = be.cold
+	}
+
+	brd, err := backend.WithHash(rd, b.Hasher())
+	if err != nil {
+		return err
+	}
+	return b.Save(ctx, h, brd)
+}
+
+// try runs fn for each backend which may store the file, until fn returns an
+// error which is not caused by a missing file. Errors of the cold backend are
+// not hidden by a missing file in the hot backend.
+func (be *Backend) try(ctx context.Context, h restic.Handle, fn func(b restic.Backend) error) error {
+	var firstErr error
+	for _, b := range be.backends(h) {
+		err := fn(b)
+		if err == nil || !b.IsNotExist(err) || ctx.Err() != nil {
+			return err
+		}
+		debug.Log("%v not found in %v", h, b.Location())
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Load runs fn with a reader for the file from the backend which stores it.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return be.try(ctx, h, func(b restic.Backend) error {
+		return b.Load(ctx, h, length, offset, fn)
+	})
+}
+
+// Stat returns information about the file from the backend which stores it.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	var fi restic.FileInfo
+	err := be.try(ctx, h, func(b restic.Backend) error {
+		var err error
+		fi, err = b.Stat(ctx, h)
+		return err
+	})
+	return fi, err
+}
+
+// Remove removes the file from the backend which stores it.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	return be.try(ctx, h, func(b restic.Backend) error {
+		return b.Remove(ctx, h)
+	})
+}
+
+// List runs fn for each file of type t. Pack files are listed in both
+// backends.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	if t != restic.PackFile {
+		return be.hot.List(ctx, t, fn)
+	}
+
+	seen := make(map[string]struct{})
+	for _, b := range []restic.Backend{be.hot, be.cold} {
+		err := b.List(ctx, t, func(fi restic.FileInfo) error {
+			if _, ok := seen[fi.Name]; ok {
+				return nil
+			}
+			seen[fi.Name] = struct{}{}
+			return fn(fi)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes all data in both backends.
+func (be *Backend) Delete(ctx context.Context) error {
+	err := be.hot.Delete(ctx)
+	if cerr := be.cold.Delete(ctx); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close closes both backends.
+func (be *Backend) Close() error {
+	err := be.hot.Close()
+	if cerr := be.cold.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
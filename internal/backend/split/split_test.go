@@ -0,0 +1,131 @@
+package split_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/split"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type splitConfig struct {
+	be restic.Backend
+}
+
+func newTestSuite() *test.Suite {
+	return &test.Suite{
+		NewConfig: func() (interface{}, error) {
+			return &splitConfig{}, nil
+		},
+
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*splitConfig)
+			if c.be != nil {
+				_, err := c.be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+				if err != nil && !c.be.IsNotExist(err) {
+					return nil, err
+				}
+
+				if err == nil {
+					return nil, errors.New("config already exists")
+				}
+			}
+
+			c.be = split.New(mem.New(), mem.New())
+			return c.be, nil
+		},
+
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*splitConfig)
+			if c.be == nil {
+				c.be = split.New(mem.New(), mem.New())
+			}
+			return c.be, nil
+		},
+
+		Cleanup: func(cfg interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestSuiteBackendSplit(t *testing.T) {
+	newTestSuite().RunTests(t)
+}
+
+func save(t *testing.T, be restic.Backend, tpe restic.FileType, blobType restic.BlobType, data string) restic.Handle {
+	h := restic.Handle{Type: tpe, Name: restic.Hash([]byte(data)).String(), ContainedBlobType: blobType}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte(data), be.Hasher())))
+	return h
+}
+
+func exists(t *testing.T, be restic.Backend, h restic.Handle) bool {
+	_, err := be.Stat(context.TODO(), h)
+	if err != nil && !be.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func list(t *testing.T, be restic.Backend, tpe restic.FileType) int {
+	n := 0
+	rtest.OK(t, be.List(context.TODO(), tpe, func(restic.FileInfo) error {
+		n++
+		return nil
+	}))
+	return n
+}
+
+func TestSplitPlacement(t *testing.T) {
+	hot, cold := mem.New(), mem.New()
+	be := split.New(hot, cold)
+
+	data := save(t, be, restic.PackFile, restic.DataBlob, "data")
+	tree := save(t, be, restic.PackFile, restic.TreeBlob, "tree")
+	index := save(t, be, restic.IndexFile, restic.InvalidBlob, "index")
+	snapshot := save(t, be, restic.SnapshotFile, restic.InvalidBlob, "snapshot")
+
+	rtest.Assert(t, exists(t, cold, data), "data pack not stored in cold backend")
+	for _, h := range []restic.Handle{tree, index, snapshot} {
+		rtest.Assert(t, exists(t, hot, h), "%v not stored in hot backend", h)
+		rtest.Assert(t, !exists(t, cold, h), "%v stored in cold backend", h)
+	}
+	rtest.Assert(t, !exists(t, hot, data), "data pack stored in hot backend")
+
+	// the content type of pack files is not always known when reading
+	for _, h := range []restic.Handle{data, tree} {
+		h.ContainedBlobType = restic.InvalidBlob
+		buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, h.Name, restic.Hash(buf).String())
+	}
+	rtest.Equals(t, 2, list(t, be, restic.PackFile))
+
+	rtest.OK(t, be.Remove(context.TODO(), restic.Handle{Type: restic.PackFile, Name: data.Name}))
+	rtest.Assert(t, !exists(t, cold, data), "data pack was not removed")
+	rtest.Equals(t, 1, list(t, be, restic.PackFile))
+}
+
+func TestSplitColdUnavailable(t *testing.T) {
+	hot, cold := mem.New(), mem.New()
+	be := split.New(hot, cold)
+
+	data := save(t, be, restic.PackFile, restic.DataBlob, "data")
+	tree := save(t, be, restic.PackFile, restic.TreeBlob, "tree")
+	save(t, be, restic.SnapshotFile, restic.InvalidBlob, "snapshot")
+
+	be = split.New(hot, mirror.Unavailable("cold", errors.New("archive tier is offline")))
+
+	// metadata can still be accessed
+	rtest.Assert(t, exists(t, be, tree), "tree pack is missing")
+	rtest.Equals(t, 1, list(t, be, restic.SnapshotFile))
+
+	_, err := be.Stat(context.TODO(), data)
+	rtest.Assert(t, err != nil && !be.IsNotExist(err), "expected error of cold backend, got %v", err)
+}
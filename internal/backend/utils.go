@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"hash"
 	"io"
 
 	"github.com/restic/restic/internal/debug"
@@ -82,7 +83,14 @@ func DefaultDelete(ctx context.Context, be restic.Backend) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.AuditFile,
+		restic.CatalogFile,
+		restic.ParityFile,
+		restic.ScrubFile,
+		restic.DataKeyFile,
+		restic.SearchIndexFile,
+		restic.AuditHeadFile}
 
 	for _, t := range alltypes {
 		err := be.List(ctx, t, func(fi restic.FileInfo) error {
@@ -140,3 +148,50 @@ func MemorizeList(ctx context.Context, be restic.Lister, t restic.FileType) (res
 		tpe:       t,
 	}, nil
 }
+
+// AsBackend unwraps the backend until a backend of type B is found. If none
+// is found, the zero value of B is returned.
+func AsBackend[B restic.Backend](b restic.Backend) B {
+	for b != nil {
+		if be, ok := b.(B); ok {
+			return be
+		}
+
+		if be, ok := b.(restic.BackendUnwrapper); ok {
+			b = be.Unwrap()
+		} else {
+			// not the backend we're looking for
+			break
+		}
+	}
+
+	var be B
+	return be
+}
+
+// hashedReader replaces the hash of a RewindReader.
+type hashedReader struct {
+	restic.RewindReader
+	hash []byte
+}
+
+func (rd *hashedReader) Hash() []byte {
+	return rd.hash
+}
+
+// WithHash returns a reader which yields the data of rd together with the hash
+// computed by hasher. This is used by backends which pass a file to other
+// backends with different hash functions. If hasher is nil, rd is returned.
+func WithHash(rd restic.RewindReader, hasher hash.Hash) (restic.RewindReader, error) {
+	if hasher == nil {
+		return rd, nil
+	}
+
+	if _, err := io.Copy(hasher, rd); err != nil {
+		return nil, err
+	}
+	if err := rd.Rewind(); err != nil {
+		return nil, err
+	}
+	return &hashedReader{RewindReader: rd, hash: hasher.Sum(nil)}, nil
+}
@@ -244,3 +244,24 @@ func TestMemoizeListError(t *testing.T) {
 	_, err := backend.MemorizeList(context.TODO(), be, restic.SnapshotFile)
 	rtest.Assert(t, err != nil, "missing error on list error")
 }
+
+type unwrappableBackend struct {
+	restic.Backend
+}
+
+func (b *unwrappableBackend) Unwrap() restic.Backend {
+	return b.Backend
+}
+
+func TestAsBackend(t *testing.T) {
+	be := mem.New()
+	wrapped := &unwrappableBackend{&unwrappableBackend{be}}
+
+	rtest.Equals(t, be, backend.AsBackend[*mem.MemoryBackend](wrapped))
+	rtest.Equals(t, wrapped, backend.AsBackend[*unwrappableBackend](wrapped))
+
+	rtest.Assert(t, backend.AsBackend[restic.RetentionBackend](wrapped) == nil,
+		"memory backend must not support retention")
+	rtest.Assert(t, backend.AsBackend[*mem.MemoryBackend](mock.NewBackend()) == nil,
+		"unexpected memory backend found")
+}
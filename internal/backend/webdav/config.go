@@ -0,0 +1,85 @@
+package webdav
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to connect to a WebDAV server.
+type Config struct {
+	URL      *url.URL
+	User     string
+	Password options.SecretString
+
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Proxy       string `option:"proxy" help:"proxy URL for this backend (http, https or socks5), or 'direct' to not use a proxy (default: --proxy)"`
+	ChunkSize   uint   `option:"chunk-size" help:"upload files larger than this many MiB in chunks, requires Nextcloud or ownCloud (default: 0, disabled)"`
+}
+
+func init() {
+	options.Register("webdav", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+// ParseConfig parses the string s and extracts the WebDAV server URL. The
+// supported configuration format is webdav:https://host/path. Credentials
+// contained in the URL are moved to the config.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "webdav:") {
+		return nil, errors.New("invalid WebDAV backend specification")
+	}
+
+	u, err := url.Parse(prepareURL(s))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid scheme %q for WebDAV server, must be http or https", u.Scheme)
+	}
+
+	cfg := NewConfig()
+	if u.User != nil {
+		cfg.User = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			cfg.Password = options.NewSecretString(password)
+		}
+		u.User = nil
+	}
+	cfg.URL = u
+	return cfg, nil
+}
+
+// StripPassword removes the password from the URL. If the repository location
+// cannot be parsed as a valid URL, it is returned as is.
+func StripPassword(s string) string {
+	scheme := s[:7]
+	u, err := url.Parse(prepareURL(s))
+	if err != nil {
+		return s
+	}
+
+	if _, set := u.User.Password(); !set {
+		return s
+	}
+
+	// a password was set: we replace it with ***
+	return scheme + strings.Replace(u.String(), u.User.String()+"@", u.User.Username()+":***@", 1)
+}
+
+func prepareURL(s string) string {
+	s = s[7:]
+	if !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	return s
+}
@@ -0,0 +1,613 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/layout"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// make sure the WebDAV backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// Backend stores data on a WebDAV server.
+type Backend struct {
+	url         *url.URL
+	user        string
+	password    string
+	connections uint
+	client      http.Client
+	layout.Layout
+
+	// uploadsURL is the base of the Nextcloud chunked upload API, only set
+	// if chunked uploads are enabled.
+	uploadsURL *url.URL
+	chunkSize  int64
+}
+
+// Open opens the WebDAV backend with the given config.
+func Open(cfg Config, rt http.RoundTripper) (*Backend, error) {
+	basePath := strings.TrimSuffix(cfg.URL.Path, "/")
+
+	be := &Backend{
+		url:         cfg.URL,
+		user:        cfg.User,
+		password:    cfg.Password.Unwrap(),
+		connections: cfg.Connections,
+		client:      http.Client{Transport: rt},
+		Layout:      &layout.DefaultLayout{Path: basePath, Join: path.Join},
+	}
+
+	if cfg.ChunkSize > 0 {
+		uploads, err := uploadsURL(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		be.uploadsURL = uploads
+		be.chunkSize = int64(cfg.ChunkSize) * 1024 * 1024
+	}
+
+	return be, nil
+}
+
+// uploadsURL returns the URL of the chunked upload API for a Nextcloud or
+// ownCloud files URL of the form https://host/remote.php/dav/files/<user>/...
+func uploadsURL(u *url.URL) (*url.URL, error) {
+	const filesPrefix = "/remote.php/dav/files/"
+
+	i := strings.Index(u.Path, filesPrefix)
+	if i < 0 {
+		return nil, errors.Errorf("chunked uploads require a Nextcloud or ownCloud URL containing %v<user>/", filesPrefix)
+	}
+
+	user, _, _ := strings.Cut(u.Path[i+len(filesPrefix):], "/")
+	if user == "" {
+		return nil, errors.New("user name is missing in the WebDAV URL")
+	}
+
+	uploads := *u
+	uploads.Path = u.Path[:i] + "/remote.php/dav/uploads/" + user
+	return &uploads, nil
+}
+
+// Create creates the repository directories on the server configured in cfg.
+func Create(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, error) {
+	be, err := Open(cfg, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = be.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err == nil {
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	// create the top-level directories before their subdirectories
+	basePath := strings.TrimSuffix(cfg.URL.Path, "/")
+	err = be.mkcol(ctx, basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var subdirs []string
+	for _, dir := range be.Paths() {
+		if path.Dir(dir) != basePath {
+			subdirs = append(subdirs, dir)
+			continue
+		}
+		if err := be.mkcol(ctx, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(int(be.connections))
+	for _, dir := range subdirs {
+		dir := dir
+		g.Go(func() error {
+			return be.mkcol(gctx, dir)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return be, nil
+}
+
+func (b *Backend) Connections() uint {
+	return b.connections
+}
+
+// Location returns this backend's location (the server's URL).
+func (b *Backend) Location() string {
+	return b.url.String()
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (b *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// HasAtomicReplace returns whether Save() can atomically replace files
+func (b *Backend) HasAtomicReplace() bool {
+	// existing files are never replaced, see Save
+	return false
+}
+
+// newRequest returns a request for the path p on the server.
+func (b *Backend) newRequest(ctx context.Context, method string, u *url.URL, p string, body io.Reader) (*http.Request, error) {
+	target := *u
+	target.Path = p
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if b.user != "" {
+		req.SetBasicAuth(b.user, b.password)
+	}
+	return req, nil
+}
+
+// do sends the request and discards the response body.
+func (b *Backend) do(req *http.Request) (*http.Response, error) {
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.Do")
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp, errors.Wrap(resp.Body.Close(), "Close")
+}
+
+// mkcol creates the collection at path p, it is not an error if it exists.
+func (b *Backend) mkcol(ctx context.Context, p string) error {
+	req, err := b.newRequest(ctx, "MKCOL", b.url, p+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusOK:
+		return nil
+	case http.StatusMethodNotAllowed:
+		// the collection already exists
+		return nil
+	default:
+		return errors.Errorf("creating directory %v failed, server response: %v", p, resp.Status)
+	}
+}
+
+// Save stores data in the backend at the handle. It never overwrites an
+// existing file.
+func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if b.uploadsURL != nil && rd.Length() > b.chunkSize {
+		return b.saveChunked(ctx, h, rd)
+	}
+
+	for created := false; ; created = true {
+		err := rd.Rewind()
+		if err != nil {
+			return err
+		}
+
+		// make sure that client.Do() cannot close the reader by wrapping it
+		req, err := b.newRequest(ctx, http.MethodPut, b.url, b.Filename(h), io.NopCloser(rd))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = rd.Length()
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("If-None-Match", "*")
+
+		resp, err := b.do(req)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusConflict && !created:
+			// the parent directory is missing, subdirectories of the data
+			// directory are created on demand
+			err = b.mkcol(ctx, strings.TrimSuffix(b.Dirname(h), "/"))
+			if err != nil {
+				return err
+			}
+			continue
+		case resp.StatusCode == http.StatusPreconditionFailed:
+			return errors.Errorf("file %v already exists", h)
+		case resp.StatusCode < 200 || resp.StatusCode > 299:
+			return errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// saveChunked uploads the file using the chunked upload API of Nextcloud and
+// ownCloud. The chunks are stored in a temporary upload directory, which is
+// then moved to the final location in one step.
+func (b *Backend) saveChunked(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	dest := *b.url
+	dest.Path = b.Filename(h)
+	dest.User = nil
+
+	uploadDir := path.Join(b.uploadsURL.Path, "restic-"+restic.NewRandomID().String())
+	debug.Log("chunked upload of %v via %v", h, uploadDir)
+	req, err := b.newRequest(ctx, "MKCOL", b.uploadsURL, uploadDir+"/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", dest.String())
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return errors.Errorf("creating upload directory failed, server response: %v", resp.Status)
+	}
+
+	err = b.uploadChunks(ctx, uploadDir, dest.String(), rd)
+	if err == nil {
+		err = b.assemble(ctx, uploadDir, dest.String(), rd.Length())
+	}
+	if errors.Is(err, errParentMissing) {
+		err = b.mkcol(ctx, strings.TrimSuffix(b.Dirname(h), "/"))
+		if err == nil {
+			err = b.assemble(ctx, uploadDir, dest.String(), rd.Length())
+		}
+	}
+	if err != nil {
+		// clean up the upload directory, the server removes it on success
+		if req, rerr := b.newRequest(context.Background(), http.MethodDelete, b.uploadsURL, uploadDir+"/", nil); rerr == nil {
+			_, _ = b.do(req)
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *Backend) uploadChunks(ctx context.Context, uploadDir, dest string, rd restic.RewindReader) error {
+	err := rd.Rewind()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	buf := make([]byte, b.chunkSize)
+	for i := 1; ; i++ {
+		n, err := io.ReadFull(rd, buf)
+		if err == io.EOF {
+			if total != rd.Length() {
+				return errors.Errorf("wrote %d bytes instead of the expected %d bytes", total, rd.Length())
+			}
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return errors.Wrap(err, "ReadFull")
+		}
+
+		chunk := path.Join(uploadDir, fmt.Sprintf("%05d", i))
+		req, err := b.newRequest(ctx, http.MethodPut, b.uploadsURL, chunk, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = int64(n)
+		req.Header.Set("Destination", dest)
+		req.Header.Set("OC-Total-Length", strconv.FormatInt(rd.Length(), 10))
+
+		resp, err := b.do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return errors.Errorf("uploading chunk %d failed, server response: %v", i, resp.Status)
+		}
+		total += int64(n)
+	}
+}
+
+// errParentMissing is returned by assemble if the parent directory of the
+// destination does not exist.
+var errParentMissing = errors.New("parent directory does not exist")
+
+func (b *Backend) assemble(ctx context.Context, uploadDir, dest string, length int64) error {
+	req, err := b.newRequest(ctx, "MOVE", b.uploadsURL, path.Join(uploadDir, ".file"), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", dest)
+	req.Header.Set("Overwrite", "F")
+	req.Header.Set("OC-Total-Length", strconv.FormatInt(length, 10))
+
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return errParentMissing
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return errors.Errorf("file %v already exists", path.Base(dest))
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return errors.Errorf("assembling chunks failed, server response: %v", resp.Status)
+	}
+	return nil
+}
+
+// notExistError is returned whenever the requested file does not exist on the
+// server.
+type notExistError struct {
+	restic.Handle
+}
+
+func (e *notExistError) Error() string {
+	return fmt.Sprintf("%v does not exist", e.Handle)
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (b *Backend) IsNotExist(err error) bool {
+	var e *notExistError
+	return errors.As(err, &e)
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (b *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return backend.DefaultLoad(ctx, h, length, offset, b.openReader, fn)
+}
+
+func (b *Backend) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	req, err := b.newRequest(ctx, http.MethodGet, b.url, b.Filename(h), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1)
+	}
+	if offset > 0 || length > 0 {
+		req.Header.Set("Range", byteRange)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.Do")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		_ = resp.Body.Close()
+		return nil, &notExistError{h}
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// the server ignored the range header and sends the whole file
+		if offset > 0 {
+			if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+				_ = resp.Body.Close()
+				return nil, errors.Wrap(err, "skipping to offset")
+			}
+		}
+		if length > 0 {
+			return backend.LimitReadCloser(resp.Body, int64(length)), nil
+		}
+		return resp.Body, nil
+	default:
+		_ = resp.Body.Close()
+		return nil, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
+	}
+}
+
+// Stat returns information about a blob.
+func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	req, err := b.newRequest(ctx, http.MethodHead, b.url, b.Filename(h), nil)
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return restic.FileInfo{}, &notExistError{h}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return restic.FileInfo{}, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
+	}
+
+	if resp.ContentLength < 0 {
+		return restic.FileInfo{}, errors.New("negative content length")
+	}
+
+	return restic.FileInfo{Size: resp.ContentLength, Name: h.Name}, nil
+}
+
+// Remove removes the blob with the given name and type.
+func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	req, err := b.newRequest(ctx, http.MethodDelete, b.url, b.Filename(h), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &notExistError{h}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return errors.Errorf("blob not removed, server response: %v (%v)", resp.Status, resp.StatusCode)
+	}
+	return nil
+}
+
+// propfindBody requests the properties used by List.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/></d:prop></d:propfind>`
+
+// multistatus is the response to a PROPFIND request.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				Length       int64 `xml:"DAV: getcontentlength"`
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+type davEntry struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+// readDir returns the entries of the collection at path p. A missing
+// collection is reported as empty.
+func (b *Backend) readDir(ctx context.Context, p string) ([]davEntry, error) {
+	req, err := b.newRequest(ctx, "PROPFIND", b.url, p+"/", strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.Do")
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, errors.Errorf("List failed, server response: %v (%v)", resp.Status, resp.StatusCode)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, errors.Wrap(err, "Decode")
+	}
+
+	var entries []davEntry
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid href")
+		}
+		name := strings.TrimSuffix(href.Path, "/")
+		if name == strings.TrimSuffix(p, "/") {
+			// the collection itself
+			continue
+		}
+
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			entries = append(entries, davEntry{
+				name:  path.Base(name),
+				size:  ps.Prop.Length,
+				isDir: ps.Prop.ResourceType.Collection != nil,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// List runs fn for each file in the backend which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	basedir, subdirs := b.Basedir(t)
+
+	entries, err := b.readDir(ctx, basedir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var files []davEntry
+		switch {
+		case entry.isDir && subdirs:
+			files, err = b.readDir(ctx, path.Join(basedir, entry.name))
+			if err != nil {
+				return err
+			}
+		case !entry.isDir:
+			files = []davEntry{entry}
+		}
+
+		for _, file := range files {
+			if file.isDir {
+				continue
+			}
+
+			err := fn(restic.FileInfo{Name: file.name, Size: file.size})
+			if err != nil {
+				return err
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Close closes all open files.
+func (b *Backend) Close() error {
+	// this does not need to do anything, all open files are closed within the
+	// same function.
+	return nil
+}
+
+// Delete removes all data in the backend.
+func (b *Backend) Delete(ctx context.Context) error {
+	return backend.DefaultDelete(ctx, b)
+}
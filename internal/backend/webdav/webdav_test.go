@@ -0,0 +1,213 @@
+package webdav_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	xwebdav "golang.org/x/net/webdav"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/backend/webdav"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// davServer wraps the x/net WebDAV handler with the behavior of Nextcloud that
+// the backend depends on: conditional and conflicting PUT requests, and
+// assembling chunked uploads.
+type davServer struct {
+	fs xwebdav.FileSystem
+	h  *xwebdav.Handler
+}
+
+func newDAVServer(t testing.TB, dirs ...string) *httptest.Server {
+	fs := xwebdav.NewMemFS()
+	for _, dir := range dirs {
+		p := ""
+		for _, elem := range splitPath(dir) {
+			p += "/" + elem
+			err := fs.Mkdir(context.TODO(), p, 0700)
+			if err != nil && !os.IsExist(err) {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	srv := httptest.NewServer(&davServer{
+		fs: fs,
+		h:  &xwebdav.Handler{FileSystem: fs, LockSystem: xwebdav.NewMemLS()},
+	})
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func splitPath(p string) []string {
+	var elems []string
+	for p != "/" && p != "." && p != "" {
+		elems = append([]string{path.Base(p)}, elems...)
+		p = path.Dir(p)
+	}
+	return elems
+}
+
+func (s *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch {
+	case r.Method == http.MethodPut:
+		if _, err := s.fs.Stat(ctx, path.Dir(r.URL.Path)); err != nil {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if _, err := s.fs.Stat(ctx, r.URL.Path); err == nil && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+	case r.Method == "MOVE" && path.Base(r.URL.Path) == ".file":
+		s.assemble(w, r)
+		return
+	}
+
+	s.h.ServeHTTP(w, r)
+}
+
+func (s *davServer) assemble(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	dir := path.Dir(r.URL.Path)
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.fs.Stat(ctx, dest.Path); err == nil {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	f, err := s.fs.OpenFile(ctx, dir, os.O_RDONLY, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	chunks, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Name() < chunks[j].Name() })
+
+	out, err := s.fs.OpenFile(ctx, dest.Path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	for _, chunk := range chunks {
+		in, err := s.fs.OpenFile(ctx, path.Join(dir, chunk.Name()), os.O_RDONLY, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, err = io.Copy(out, in)
+		_ = in.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := out.Close(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = s.fs.RemoveAll(ctx, dir)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func newTestSuite(t testing.TB, chunkSize uint) *test.Suite {
+	tr, err := backend.Transport(backend.TransportOptions{})
+	if err != nil {
+		t.Fatalf("cannot create transport for tests: %v", err)
+	}
+
+	srv := newDAVServer(t, "/remote.php/dav/files/test", "/remote.php/dav/uploads/test")
+
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			cfg, err := webdav.ParseConfig("webdav:" + srv.URL + "/remote.php/dav/files/test/" + restic.NewRandomID().String())
+			if err != nil {
+				return nil, err
+			}
+			c := cfg.(webdav.Config)
+			c.ChunkSize = chunkSize
+			return c, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(webdav.Config)
+			return webdav.Create(context.TODO(), cfg, tr)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(webdav.Config)
+			return webdav.Open(cfg, tr)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestBackendWebDAV(t *testing.T) {
+	newTestSuite(t, 0).RunTests(t)
+}
+
+func TestBackendWebDAVChunked(t *testing.T) {
+	newTestSuite(t, 1).RunTests(t)
+}
+
+func TestSaveNoOverwrite(t *testing.T) {
+	srv := newDAVServer(t, "/remote.php/dav/files/test", "/remote.php/dav/uploads/test")
+	tr, err := backend.Transport(backend.TransportOptions{})
+	rtest.OK(t, err)
+
+	for _, chunkSize := range []uint{0, 1} {
+		cfg, err := webdav.ParseConfig("webdav:" + srv.URL + "/remote.php/dav/files/test/" + restic.NewRandomID().String())
+		rtest.OK(t, err)
+		c := cfg.(webdav.Config)
+		c.ChunkSize = chunkSize
+
+		be, err := webdav.Create(context.TODO(), c, tr)
+		rtest.OK(t, err)
+
+		data := rtest.Random(23, 3*1024*1024)
+		h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data, be.Hasher())))
+
+		err = be.Save(context.TODO(), h, restic.NewByteReader(rtest.Random(42, len(data)), be.Hasher()))
+		rtest.Assert(t, err != nil, "overwriting %v did not fail", h)
+
+		buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+	}
+}
+
+func BenchmarkBackendWebDAV(t *testing.B) {
+	newTestSuite(t, 0).RunBenchmarks(t)
+}
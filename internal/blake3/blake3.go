@@ -0,0 +1,267 @@
+// Package blake3 implements the BLAKE3 hash function with 256 bit output.
+//
+// Only the default hashing mode is supported, keyed hashing and key
+// derivation are not. The implementation follows the reference
+// implementation and does not use SIMD instructions.
+package blake3
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// Size is the size of a BLAKE3 checksum in bytes.
+const Size = 32
+
+// BlockSize is the block size of BLAKE3 in bytes.
+const BlockSize = 64
+
+const (
+	chunkLen = 1024
+
+	flagChunkStart = 1 << 0
+	flagChunkEnd   = 1 << 1
+	flagParent     = 1 << 2
+	flagRoot       = 1 << 3
+)
+
+var iv = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+// schedule lists the message word indexes used by each round, the message
+// permutation is applied between rounds.
+var schedule = func() (sched [7][16]int) {
+	perm := [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+	for i := range sched[0] {
+		sched[0][i] = i
+	}
+	for r := 1; r < len(sched); r++ {
+		for i := range sched[r] {
+			sched[r][i] = sched[r-1][perm[i]]
+		}
+	}
+	return sched
+}()
+
+func g(a, b, c, d, mx, my uint32) (uint32, uint32, uint32, uint32) {
+	a += b + mx
+	d = bits.RotateLeft32(d^a, -16)
+	c += d
+	b = bits.RotateLeft32(b^c, -12)
+	a += b + my
+	d = bits.RotateLeft32(d^a, -8)
+	c += d
+	b = bits.RotateLeft32(b^c, -7)
+	return a, b, c, d
+}
+
+func compress(cv *[8]uint32, m *[16]uint32, counter uint64, blockLen uint32, flags uint32) [16]uint32 {
+	s0, s1, s2, s3, s4, s5, s6, s7 := cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7]
+	s8, s9, s10, s11 := iv[0], iv[1], iv[2], iv[3]
+	s12, s13, s14, s15 := uint32(counter), uint32(counter>>32), blockLen, flags
+
+	for r := range schedule {
+		sc := &schedule[r]
+		// mix the columns
+		s0, s4, s8, s12 = g(s0, s4, s8, s12, m[sc[0]], m[sc[1]])
+		s1, s5, s9, s13 = g(s1, s5, s9, s13, m[sc[2]], m[sc[3]])
+		s2, s6, s10, s14 = g(s2, s6, s10, s14, m[sc[4]], m[sc[5]])
+		s3, s7, s11, s15 = g(s3, s7, s11, s15, m[sc[6]], m[sc[7]])
+		// mix the diagonals
+		s0, s5, s10, s15 = g(s0, s5, s10, s15, m[sc[8]], m[sc[9]])
+		s1, s6, s11, s12 = g(s1, s6, s11, s12, m[sc[10]], m[sc[11]])
+		s2, s7, s8, s13 = g(s2, s7, s8, s13, m[sc[12]], m[sc[13]])
+		s3, s4, s9, s14 = g(s3, s4, s9, s14, m[sc[14]], m[sc[15]])
+	}
+
+	return [16]uint32{
+		s0 ^ s8, s1 ^ s9, s2 ^ s10, s3 ^ s11, s4 ^ s12, s5 ^ s13, s6 ^ s14, s7 ^ s15,
+		s8 ^ cv[0], s9 ^ cv[1], s10 ^ cv[2], s11 ^ cv[3], s12 ^ cv[4], s13 ^ cv[5], s14 ^ cv[6], s15 ^ cv[7],
+	}
+}
+
+func first8(s [16]uint32) (cv [8]uint32) {
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func blockWords(buf []byte) (words [16]uint32) {
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+	return words
+}
+
+// output is a node of the hash tree whose chaining value or root hash has
+// not yet been computed.
+type output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *output) chainingValue() [8]uint32 {
+	return first8(compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags))
+}
+
+func (o *output) rootHash() (sum [Size]byte) {
+	// the output of a single compression is sufficient for 32 bytes
+	s := compress(&o.cv, &o.block, 0, o.blockLen, o.flags|flagRoot)
+	for i := 0; i < Size/4; i++ {
+		binary.LittleEndian.PutUint32(sum[4*i:], s[i])
+	}
+	return sum
+}
+
+func parentOutput(left, right [8]uint32) output {
+	o := output{cv: iv, blockLen: BlockSize, flags: flagParent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+type chunkState struct {
+	cv               [8]uint32
+	counter          uint64
+	block            [BlockSize]byte
+	blockLen         int
+	blocksCompressed int
+}
+
+func newChunkState(counter uint64) chunkState {
+	return chunkState{cv: iv, counter: counter}
+}
+
+func (c *chunkState) len() int {
+	return BlockSize*c.blocksCompressed + c.blockLen
+}
+
+func (c *chunkState) startFlag() uint32 {
+	if c.blocksCompressed == 0 {
+		return flagChunkStart
+	}
+	return 0
+}
+
+func (c *chunkState) update(p []byte) {
+	for len(p) > 0 {
+		// only compress a full block once more input follows, the last
+		// block of a chunk is compressed with a different flag
+		if c.blockLen == BlockSize {
+			words := blockWords(c.block[:])
+			c.cv = first8(compress(&c.cv, &words, c.counter, BlockSize, c.startFlag()))
+			c.blocksCompressed++
+			c.block = [BlockSize]byte{}
+			c.blockLen = 0
+		}
+
+		// compress full blocks directly from the input
+		for c.blockLen == 0 && len(p) > BlockSize {
+			words := blockWords(p)
+			c.cv = first8(compress(&c.cv, &words, c.counter, BlockSize, c.startFlag()))
+			c.blocksCompressed++
+			p = p[BlockSize:]
+		}
+
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *chunkState) output() output {
+	return output{
+		cv:       c.cv,
+		block:    blockWords(c.block[:]),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | flagChunkEnd,
+	}
+}
+
+// digest is an incremental BLAKE3 hasher.
+type digest struct {
+	chunk chunkState
+	// stack of the chaining values of complete subtrees, enough for 2^54
+	// chunks
+	stack    [54][8]uint32
+	stackLen int
+}
+
+// New returns a new hash.Hash computing the BLAKE3 checksum.
+func New() hash.Hash {
+	d := &digest{}
+	d.Reset()
+	return d
+}
+
+func (d *digest) Reset() {
+	d.chunk = newChunkState(0)
+	d.stackLen = 0
+}
+
+func (d *digest) Size() int { return Size }
+
+func (d *digest) BlockSize() int { return BlockSize }
+
+// addChunkChainingValue pushes the chaining value of a completed chunk onto
+// the stack, first merging all subtrees which are complete after adding it.
+// The number of complete subtrees is the number of set bits in totalChunks.
+func (d *digest) addChunkChainingValue(cv [8]uint32, totalChunks uint64) {
+	for totalChunks&1 == 0 {
+		d.stackLen--
+		o := parentOutput(d.stack[d.stackLen], cv)
+		cv = o.chainingValue()
+		totalChunks >>= 1
+	}
+	d.stack[d.stackLen] = cv
+	d.stackLen++
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// only finish a chunk once more input follows, the last chunk may be
+		// the root of the tree
+		if d.chunk.len() == chunkLen {
+			o := d.chunk.output()
+			totalChunks := d.chunk.counter + 1
+			d.addChunkChainingValue(o.chainingValue(), totalChunks)
+			d.chunk = newChunkState(totalChunks)
+		}
+
+		want := chunkLen - d.chunk.len()
+		if want > len(p) {
+			want = len(p)
+		}
+		d.chunk.update(p[:want])
+		p = p[want:]
+	}
+	return n, nil
+}
+
+func (d *digest) checkSum() [Size]byte {
+	o := d.chunk.output()
+	for i := d.stackLen - 1; i >= 0; i-- {
+		o = parentOutput(d.stack[i], o.chainingValue())
+	}
+	return o.rootHash()
+}
+
+func (d *digest) Sum(b []byte) []byte {
+	sum := d.checkSum()
+	return append(b, sum[:]...)
+}
+
+// Sum256 returns the BLAKE3 checksum of the data.
+func Sum256(data []byte) [Size]byte {
+	var d digest
+	d.Reset()
+	_, _ = d.Write(data)
+	return d.checkSum()
+}
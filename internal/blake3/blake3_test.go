@@ -0,0 +1,84 @@
+package blake3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+// testInput returns the input used by the official test vectors.
+func testInput(n int) []byte {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = byte(i % 251)
+	}
+	return buf
+}
+
+var testVectors = []struct {
+	input []byte
+	hash  string
+}{
+	{testInput(0), "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+	{testInput(1), "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+	{testInput(1024), "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+	{testInput(1025), "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+	{[]byte("abc"), "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+}
+
+func TestSum256(t *testing.T) {
+	for _, test := range testVectors {
+		sum := Sum256(test.input)
+		if got := hex.EncodeToString(sum[:]); got != test.hash {
+			t.Errorf("input length %d: wrong hash, want %v, got %v", len(test.input), test.hash, got)
+		}
+
+		h := New()
+		_, _ = h.Write(test.input)
+		if got := hex.EncodeToString(h.Sum(nil)); got != test.hash {
+			t.Errorf("input length %d: wrong hash from Write, want %v, got %v", len(test.input), test.hash, got)
+		}
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	for _, size := range []int{63, 64, 65, 1023, 1024, 1025, 4096, 5 * 1024, 31*1024 + 17, 1 << 20} {
+		data := testInput(size)
+		want := Sum256(data)
+
+		h := New()
+		for rest := data; len(rest) > 0; {
+			n := rnd.Intn(3000) + 1
+			if n > len(rest) {
+				n = len(rest)
+			}
+			_, _ = h.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if got := h.Sum(nil); !bytes.Equal(want[:], got) {
+			t.Errorf("size %d: streaming returned %x, want %x", size, got, want)
+		}
+
+		// Sum does not change the state
+		if got := h.Sum(nil); !bytes.Equal(want[:], got) {
+			t.Errorf("size %d: second Sum returned %x, want %x", size, got, want)
+		}
+
+		h.Reset()
+		_, _ = h.Write(data)
+		if got := h.Sum(nil); !bytes.Equal(want[:], got) {
+			t.Errorf("size %d: returned %x after Reset, want %x", size, got, want)
+		}
+	}
+}
+
+func BenchmarkSum256(b *testing.B) {
+	data := testInput(1 << 20)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Sum256(data)
+	}
+}
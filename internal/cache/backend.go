@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -132,6 +133,35 @@ func (b *Backend) cacheFile(ctx context.Context, h restic.Handle) error {
 	return nil
 }
 
+// Prime downloads all index or snapshot files which are not cached yet, so
+// that following operations do not have to download them. It returns the
+// number of downloaded files.
+func (b *Backend) Prime(ctx context.Context, t restic.FileType) (int, error) {
+	if t != restic.IndexFile && t != restic.SnapshotFile {
+		return 0, errors.Errorf("cannot prime the cache with files of type %v", t)
+	}
+
+	var missing []restic.Handle
+	err := b.Backend.List(ctx, t, func(fi restic.FileInfo) error {
+		h := restic.Handle{Type: t, Name: fi.Name}
+		if !b.Cache.Has(h) {
+			missing = append(missing, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, h := range missing {
+		debug.Log("prime cache with %v", h)
+		if err := b.cacheFile(ctx, h); err != nil {
+			return i, err
+		}
+	}
+	return len(missing), nil
+}
+
 // loadFromCache will try to load the file from the cache.
 func (b *Backend) loadFromCache(ctx context.Context, h restic.Handle, length int, offset int64, consumer func(rd io.Reader) error) (bool, error) {
 	rd, err := b.Cache.load(h, length, offset)
@@ -200,3 +200,34 @@ func TestBackendRemoveBroken(t *testing.T) {
 		t.Fatalf("wrong data cache")
 	}
 }
+
+func TestBackendPrime(t *testing.T) {
+	be := mem.New()
+	c := TestNewCache(t)
+	wbe := newBackend(be, c)
+
+	cached, cachedData := randomData(1234)
+	save(t, wbe, cached, cachedData)
+
+	var missing []restic.Handle
+	for i := 0; i < 3; i++ {
+		h, data := randomData(4321)
+		save(t, be, h, data)
+		missing = append(missing, h)
+	}
+
+	n, err := wbe.Prime(context.TODO(), restic.IndexFile)
+	test.OK(t, err)
+	test.Equals(t, len(missing), n)
+	for _, h := range append(missing, cached) {
+		test.Assert(t, c.Has(h), "cache does not contain %v", h)
+	}
+
+	// all files are cached now
+	n, err = wbe.Prime(context.TODO(), restic.IndexFile)
+	test.OK(t, err)
+	test.Equals(t, 0, n)
+
+	_, err = wbe.Prime(context.TODO(), restic.PackFile)
+	test.Assert(t, err != nil, "priming pack files did not fail")
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -19,11 +20,29 @@ type Cache struct {
 	path    string
 	Base    string
 	Created bool
+
+	shared   bool
+	dirPerm  os.FileMode
+	filePerm os.FileMode
+	lockFile *os.File
+
+	// size limit of the cache, see SetMaxSize
+	sizeMu  sync.Mutex
+	maxSize int64
+	size    int64
+
+	usedMu    sync.Mutex
+	usedFiles map[string]struct{}
 }
 
 const dirMode = 0700
 const fileMode = 0644
 
+// Permissions used for shared caches. Directories are created with the
+// setgid bit set, so that all files belong to the group of the base directory.
+const sharedDirMode = 0770 | os.ModeSetgid
+const sharedFileMode = 0660
+
 func readVersion(dir string) (v uint, err error) {
 	buf, err := os.ReadFile(filepath.Join(dir, "version"))
 	if errors.Is(err, os.ErrNotExist) {
@@ -76,12 +95,30 @@ func writeCachedirTag(dir string) error {
 	return errors.WithStack(f.Close())
 }
 
+// Options configure a cache.
+type Options struct {
+	// Shared allows all members of the group owning the base directory to
+	// use the cache, for example on a backup server which runs restic for
+	// many users.
+	Shared bool
+}
+
 // New returns a new cache for the repo ID at basedir. If basedir is the empty
 // string, the default cache location (according to the XDG standard) is used.
 //
 // For partial files, the complete file is loaded and stored in the cache when
 // performReadahead returns true.
 func New(id string, basedir string) (c *Cache, err error) {
+	return NewWithOptions(id, basedir, Options{})
+}
+
+// NewWithOptions returns a new cache like New, configured using opts.
+//
+// The cache can be used by multiple restic processes at the same time, also
+// on different hosts if the cache is stored on a network file system. Files
+// are saved atomically and a lock prevents the removal of the cache directory
+// while it is in use.
+func NewWithOptions(id string, basedir string, opts Options) (c *Cache, err error) {
 	if basedir == "" {
 		basedir, err = DefaultDir()
 		if err != nil {
@@ -89,9 +126,22 @@ func New(id string, basedir string) (c *Cache, err error) {
 		}
 	}
 
-	err = fs.MkdirAll(basedir, 0700)
+	c = &Cache{
+		path:      filepath.Join(basedir, id),
+		Base:      basedir,
+		shared:    opts.Shared,
+		dirPerm:   dirMode,
+		filePerm:  fileMode,
+		usedFiles: make(map[string]struct{}),
+	}
+	if opts.Shared {
+		c.dirPerm = sharedDirMode
+		c.filePerm = sharedFileMode
+	}
+
+	err = c.mkdirAll(basedir)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
 	// create base dir and tag it as a cache directory
@@ -99,7 +149,7 @@ func New(id string, basedir string) (c *Cache, err error) {
 		return nil, err
 	}
 
-	cachedir := filepath.Join(basedir, id)
+	cachedir := c.path
 	debug.Log("using cache dir %v", cachedir)
 
 	v, err := readVersion(cachedir)
@@ -112,14 +162,13 @@ func New(id string, basedir string) (c *Cache, err error) {
 	}
 
 	// create the repo cache dir if it does not exist yet
-	var created bool
 	_, err = fs.Lstat(cachedir)
 	if errors.Is(err, os.ErrNotExist) {
-		err = fs.MkdirAll(cachedir, dirMode)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		created = true
+		c.Created = true
+	}
+
+	if err = c.lock(); err != nil {
+		return nil, err
 	}
 
 	// update the timestamp so that we can detect old cache dirs
@@ -129,25 +178,89 @@ func New(id string, basedir string) (c *Cache, err error) {
 	}
 
 	if v < cacheVersion {
-		err = os.WriteFile(filepath.Join(cachedir, "version"), []byte(fmt.Sprintf("%d", cacheVersion)), fileMode)
+		err = c.writeFile(filepath.Join(cachedir, "version"), []byte(fmt.Sprintf("%d", cacheVersion)))
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return nil, err
 		}
 	}
 
 	for _, p := range cacheLayoutPaths {
-		if err = fs.MkdirAll(filepath.Join(cachedir, p), dirMode); err != nil {
+		if err = c.mkdirAll(filepath.Join(cachedir, p)); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// mkdirAll creates the directory dir and its parents.
+func (c *Cache) mkdirAll(dir string) error {
+	_, err := fs.Lstat(dir)
+	if err == nil {
+		return nil
+	}
+
+	if err := c.mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	return c.mkdir(dir)
+}
+
+// mkdir creates the directory dir, if it does not exist yet. The permissions
+// of a new directory are set explicitly for shared caches, as the umask
+// usually removes the write permission for the group.
+func (c *Cache) mkdir(dir string) error {
+	err := fs.Mkdir(dir, c.dirPerm)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.shared {
+		return errors.WithStack(fs.Chmod(dir, c.dirPerm))
+	}
+	return nil
+}
+
+// createTemp creates a temporary file in dir, which is renamed to its final
+// name once it is complete. This allows concurrent processes to use the same
+// cache directory.
+func (c *Cache) createTemp(dir string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+
+	if c.shared {
+		if err := f.Chmod(c.filePerm); err != nil {
+			_ = f.Close()
+			_ = fs.Remove(f.Name())
 			return nil, errors.WithStack(err)
 		}
 	}
+	return f, nil
+}
 
-	c = &Cache{
-		path:    cachedir,
-		Base:    basedir,
-		Created: created,
+// writeFile atomically replaces the file name with data.
+func (c *Cache) writeFile(name string, data []byte) error {
+	f, err := c.createTemp(filepath.Dir(name))
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
-	return c, nil
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fs.Rename(f.Name(), name)
+	}
+	if err != nil {
+		_ = fs.Remove(f.Name())
+	}
+	return errors.WithStack(err)
 }
 
 // updateTimestamp sets the modification timestamp (mtime and atime) for the
@@ -248,3 +361,20 @@ func (c *Cache) Wrap(be restic.Backend) restic.Backend {
 func (c *Cache) BaseDir() string {
 	return c.Base
 }
+
+// ReadExtraFile returns the contents of the file name stored in the cache
+// directory of the repository next to the cached repository files. Such files
+// hold additional local state, for example the file hash cache of backup.
+func (c *Cache) ReadExtraFile(name string) ([]byte, error) {
+	buf, err := os.ReadFile(filepath.Join(c.path, name))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf, nil
+}
+
+// WriteExtraFile atomically replaces the file name in the cache directory of
+// the repository with data, see ReadExtraFile.
+func (c *Cache) WriteExtraFile(name string, data []byte) error {
+	return c.writeFile(filepath.Join(c.path, name), data)
+}
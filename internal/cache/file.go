@@ -40,10 +40,12 @@ func (c *Cache) load(h restic.Handle, length int, offset int64) (io.ReadCloser,
 		return nil, errors.New("cannot be cached")
 	}
 
-	f, err := fs.Open(c.filename(h))
+	filename := c.filename(h)
+	f, err := fs.Open(filename)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	c.used(filename)
 
 	fi, err := f.Stat()
 	if err != nil {
@@ -89,14 +91,14 @@ func (c *Cache) Save(h restic.Handle, rd io.Reader) error {
 
 	finalname := c.filename(h)
 	dir := filepath.Dir(finalname)
-	err := fs.Mkdir(dir, 0700)
-	if err != nil && !errors.Is(err, os.ErrExist) {
+	err := c.mkdir(dir)
+	if err != nil {
 		return err
 	}
 
 	// First save to a temporary location. This allows multiple concurrent
 	// restics to use a single cache dir.
-	f, err := os.CreateTemp(dir, "tmp-")
+	f, err := c.createTemp(dir)
 	if err != nil {
 		return err
 	}
@@ -134,8 +136,12 @@ func (c *Cache) Save(h restic.Handle, rd io.Reader) error {
 		// and the other process has written the desired contents to f.
 		err = nil
 	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-	return errors.WithStack(err)
+	c.added(n)
+	return nil
 }
 
 // Remove deletes a file. When the file is not cache, no error is returned.
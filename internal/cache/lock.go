@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+)
+
+// lockFilename is the name of the file within a repository cache directory
+// which is locked by all processes using the directory.
+const lockFilename = "lock"
+
+var errLocked = errors.New("cache directory is locked by another process")
+var errLockingUnsupported = errors.New("file locking is not supported")
+
+// lock acquires a shared lock for the cache directory, which prevents other
+// processes from removing the directory while it is used. When another
+// process is removing the directory, lock waits until it is done and then
+// creates the directory again. The lock is held until the process exits. If
+// the file system does not support locking, the cache is used without a lock.
+func (c *Cache) lock() error {
+	name := filepath.Join(c.path, lockFilename)
+	for {
+		if err := c.mkdirAll(c.path); err != nil {
+			return err
+		}
+
+		f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE, c.filePerm)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if c.shared {
+			// the umask may have restricted the permissions
+			_ = f.Chmod(c.filePerm)
+		}
+
+		err = lockFile(f, false, true)
+		if errors.Is(err, errLockingUnsupported) {
+			debug.Log("unable to lock %v: %v", name, err)
+			return f.Close()
+		}
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		// make sure the directory was not removed while waiting for the lock
+		locked, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return errors.WithStack(err)
+		}
+		current, err := fs.Stat(name)
+		if err == nil && os.SameFile(locked, current) {
+			c.lockFile = f
+			return nil
+		}
+
+		debug.Log("cache dir %v was removed while waiting for the lock", c.path)
+		_ = f.Close()
+	}
+}
+
+// RemoveDir removes the repository cache directory dir, unless it is in use
+// by another process. It returns false if the directory was not removed.
+func RemoveDir(dir string) (bool, error) {
+	f, err := fs.OpenFile(filepath.Join(dir, lockFilename), os.O_RDWR|os.O_CREATE, fileMode)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	err = lockFile(f, true, false)
+	if errors.Is(err, errLocked) {
+		debug.Log("cache dir %v is in use", dir)
+		return false, f.Close()
+	}
+	if err != nil && !errors.Is(err, errLockingUnsupported) {
+		_ = f.Close()
+		return false, err
+	}
+
+	if canRemoveLockedFiles {
+		defer func() {
+			_ = f.Close()
+		}()
+	} else {
+		_ = f.Close()
+	}
+	return true, errors.WithStack(fs.RemoveAll(dir))
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRemoveDir(t *testing.T) {
+	c := TestNewCache(t)
+	_, err := fs.Stat(filepath.Join(c.path, lockFilename))
+	rtest.OK(t, err)
+
+	// locks held by the same process do not conflict
+	removed, err := RemoveDir(c.path)
+	rtest.OK(t, err)
+	rtest.Assert(t, removed, "cache dir was not removed")
+
+	_, err = fs.Stat(c.path)
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "cache dir still exists: %v", err)
+}
+
+func TestSharedCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not supported on Windows")
+	}
+
+	c, err := NewWithOptions(restic.NewRandomID().String(), rtest.TempDir(t), Options{Shared: true})
+	rtest.OK(t, err)
+
+	data := rtest.Random(23, 4096)
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, c.Save(h, bytes.NewReader(data)))
+
+	for _, dir := range []string{c.path, filepath.Dir(c.filename(h))} {
+		fi, err := fs.Stat(dir)
+		rtest.OK(t, err)
+		rtest.Equals(t, os.FileMode(0770), fi.Mode().Perm())
+		rtest.Assert(t, fi.Mode()&os.ModeSetgid != 0, "setgid bit is not set for %v", dir)
+	}
+
+	for _, name := range []string{c.filename(h), filepath.Join(c.path, lockFilename), filepath.Join(c.path, "version")} {
+		fi, err := fs.Stat(name)
+		rtest.OK(t, err)
+		rtest.Equals(t, os.FileMode(0660), fi.Mode().Perm())
+	}
+}
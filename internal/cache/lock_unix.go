@@ -0,0 +1,46 @@
+//go:build !windows
+// +build !windows
+
+package cache
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lockFile places a lock on the file f. Many processes can hold a shared lock
+// at the same time, an exclusive lock is only granted if no other process
+// holds a lock. POSIX record locks are used as these also work across hosts
+// on NFS.
+func lockFile(f *os.File, exclusive bool, wait bool) error {
+	lk := syscall.Flock_t{Type: syscall.F_RDLCK}
+	if exclusive {
+		lk.Type = syscall.F_WRLCK
+	}
+	cmd := syscall.F_SETLK
+	if wait {
+		cmd = syscall.F_SETLKW
+	}
+
+	for {
+		err := syscall.FcntlFlock(f.Fd(), cmd, &lk)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES):
+			return errLocked
+		case errors.Is(err, syscall.ENOLCK) || errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EOPNOTSUPP):
+			return errLockingUnsupported
+		default:
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// Open files can be removed, so a locked cache directory can be removed
+// before the lock is released.
+const canRemoveLockedFiles = true
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// lockFile places a lock on the file f. Many processes can hold a shared lock
+// at the same time, an exclusive lock is only granted if no other process
+// holds a lock.
+func lockFile(f *os.File, exclusive bool, wait bool) error {
+	var flags uint32
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, math.MaxUint32, math.MaxUint32, &windows.Overlapped{})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, windows.ERROR_LOCK_VIOLATION):
+		return errLocked
+	case errors.Is(err, windows.ERROR_NOT_SUPPORTED) || errors.Is(err, windows.ERROR_INVALID_FUNCTION):
+		return errLockingUnsupported
+	default:
+		return errors.WithStack(err)
+	}
+}
+
+// Windows cannot remove open files, the lock must be released before
+// removing a cache directory.
+const canRemoveLockedFiles = false
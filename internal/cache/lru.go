@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// When the size limit of a cache is exceeded, files are removed until the
+// cache is back at this percentage of the limit. This avoids removing a file
+// for each file that is added.
+const evictTargetPercent = 90
+
+type cachedFile struct {
+	name    string
+	size    int64
+	lastUse time.Time
+}
+
+// listCachedFiles returns all files cached in the repository cache directory
+// dir. Temporary files of concurrently running restic processes are ignored.
+func listCachedFiles(dir string) ([]cachedFile, error) {
+	var files []cachedFile
+	for _, p := range cacheLayoutPaths {
+		err := filepath.Walk(filepath.Join(dir, p), func(name string, fi os.FileInfo, err error) error {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrap(err, "Walk")
+			}
+
+			if !isFile(fi) {
+				return nil
+			}
+			if _, err := restic.ParseID(fi.Name()); err != nil {
+				return nil
+			}
+
+			files = append(files, cachedFile{name: name, size: fi.Size(), lastUse: fi.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// evict removes the least recently used files from the list until the total
+// size is at most maxSize. It returns the number of removed files and the size
+// of the remaining files.
+func evict(files []cachedFile, maxSize int64) (removed int, remaining int64, err error) {
+	for _, f := range files {
+		remaining += f.size
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].lastUse.Before(files[j].lastUse)
+	})
+
+	for _, f := range files {
+		if remaining <= maxSize {
+			break
+		}
+
+		err := fs.Remove(f.name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, remaining, errors.WithStack(err)
+		}
+		remaining -= f.size
+		removed++
+	}
+
+	return removed, remaining, nil
+}
+
+// PruneDir removes the least recently used files from the repository cache
+// directory dir until at most maxSize bytes are used. It returns the number of
+// removed files and the number of bytes freed.
+func PruneDir(dir string, maxSize int64) (removed int, freed int64, err error) {
+	files, err := listCachedFiles(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+
+	removed, remaining, err := evict(files, maxSize)
+	debug.Log("removed %d files from %v, %d bytes remaining", removed, dir, remaining)
+	return removed, size - remaining, err
+}
+
+// SetMaxSize limits the size of the cache to maxSize bytes. When the limit is
+// exceeded, the least recently used files are removed from the cache. Zero
+// disables the limit.
+func (c *Cache) SetMaxSize(maxSize int64) error {
+	c.sizeMu.Lock()
+	defer c.sizeMu.Unlock()
+
+	c.maxSize = maxSize
+	if maxSize <= 0 {
+		return nil
+	}
+
+	files, err := listCachedFiles(c.path)
+	if err != nil {
+		return err
+	}
+
+	c.size = 0
+	for _, f := range files {
+		c.size += f.size
+	}
+	if c.size <= maxSize {
+		return nil
+	}
+	return c.evictLocked(files)
+}
+
+// added records that a file of the given size was added to the cache and
+// removes old files if the size limit is exceeded. Failing to remove files is
+// not an error for the caller, the cache is still usable.
+func (c *Cache) added(size int64) {
+	c.sizeMu.Lock()
+	defer c.sizeMu.Unlock()
+
+	if c.maxSize <= 0 {
+		return
+	}
+
+	c.size += size
+	if c.size <= c.maxSize {
+		return
+	}
+
+	// other restic processes may have changed the cache in the meantime
+	files, err := listCachedFiles(c.path)
+	if err == nil {
+		err = c.evictLocked(files)
+	}
+	if err != nil {
+		debug.Log("unable to remove old files from the cache: %v", err)
+	}
+}
+
+func (c *Cache) evictLocked(files []cachedFile) error {
+	removed, remaining, err := evict(files, c.maxSize*evictTargetPercent/100)
+	debug.Log("cache size limit %d exceeded, removed %d files", c.maxSize, removed)
+	c.size = remaining
+	return err
+}
+
+// used updates the modification time of a cached file when it is used for
+// the first time within this process, so that the least recently used files
+// can be found later on. The modification time is used instead of the access
+// time, as the latter is often not updated.
+func (c *Cache) used(filename string) {
+	c.usedMu.Lock()
+	_, ok := c.usedFiles[filename]
+	if !ok {
+		c.usedFiles[filename] = struct{}{}
+	}
+	c.usedMu.Unlock()
+
+	if !ok {
+		_ = updateTimestamp(filename)
+	}
+}
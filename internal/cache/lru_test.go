@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+const lruFileSize = 1 << 16
+
+// saveAged saves n files of type t, the first one is the least recently
+// used.
+func saveAged(t testing.TB, c *Cache, tpe restic.FileType, n int) []restic.Handle {
+	var handles []restic.Handle
+	for i := 0; i < n; i++ {
+		buf := rtest.Random(i, lruFileSize)
+		h := restic.Handle{Type: tpe, Name: restic.Hash(buf).String()}
+		rtest.OK(t, c.Save(h, bytes.NewReader(buf)))
+
+		ts := time.Now().Add(time.Duration(i-n) * time.Hour)
+		rtest.OK(t, fs.Chtimes(c.filename(h), ts, ts))
+		handles = append(handles, h)
+	}
+	return handles
+}
+
+func TestCacheMaxSize(t *testing.T) {
+	c := TestNewCache(t)
+	handles := saveAged(t, c, restic.PackFile, 4)
+
+	// using a file makes it the most recently used one
+	_ = load(t, c, handles[0])
+
+	// exceeding the limit removes files until 90% of the limit are used
+	rtest.OK(t, c.SetMaxSize(3*lruFileSize))
+	rtest.Assert(t, c.Has(handles[0]), "recently used file was removed")
+	rtest.Assert(t, !c.Has(handles[1]) && !c.Has(handles[2]), "least recently used files were not removed")
+	rtest.Assert(t, c.Has(handles[3]), "too many files were removed")
+
+	// saving files checks the limit, too
+	var added []restic.Handle
+	for i := 0; i < 2; i++ {
+		buf := rtest.Random(23+i, lruFileSize)
+		h := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(buf).String()}
+		rtest.OK(t, c.Save(h, bytes.NewReader(buf)))
+		added = append(added, h)
+	}
+	rtest.Assert(t, !c.Has(handles[3]), "least recently used file was not removed")
+	rtest.Assert(t, c.Has(added[1]), "new file was removed")
+}
+
+func TestPruneDir(t *testing.T) {
+	c := TestNewCache(t)
+	handles := append(saveAged(t, c, restic.SnapshotFile, 2), saveAged(t, c, restic.IndexFile, 2)...)
+
+	removed, freed, err := PruneDir(c.path, 2*lruFileSize)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, removed)
+	rtest.Equals(t, int64(2*lruFileSize), freed)
+
+	// the files of both types are evicted according to their age
+	rtest.Assert(t, !c.Has(handles[0]) && !c.Has(handles[2]), "old files were not removed")
+	rtest.Assert(t, c.Has(handles[1]) && c.Has(handles[3]), "recent files were removed")
+}
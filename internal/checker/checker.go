@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"runtime"
@@ -116,6 +117,13 @@ func computePackTypes(ctx context.Context, idx restic.MasterIndex) map[restic.ID
 func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 	debug.Log("Start")
 
+	// repositories may keep the index in a temporary file to save memory
+	var builder *index.DiskIndexBuilder
+	if r, ok := c.repo.(interface{ DiskIndex() bool }); ok && r.DiskIndex() {
+		builder = index.NewDiskIndexBuilder()
+		defer builder.Close()
+	}
+
 	packToIndex := make(map[restic.ID]restic.IDSet)
 	err := index.ForAllIndexes(ctx, c.repo, func(id restic.ID, index *index.Index, oldFormat bool, err error) error {
 		debug.Log("process index %v, err %v", id, err)
@@ -132,8 +140,6 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 			return nil
 		}
 
-		c.masterIndex.Insert(index)
-
 		debug.Log("process blobs")
 		cnt := 0
 		index.Each(ctx, func(blob restic.PackedBlob) {
@@ -146,12 +152,25 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 		})
 
 		debug.Log("%d blobs processed", cnt)
+
+		if builder != nil {
+			return builder.Add(index)
+		}
+		c.masterIndex.Insert(index)
 		return nil
 	})
 	if err != nil {
 		errs = append(errs, err)
 	}
 
+	if builder != nil {
+		idx, err := builder.Finish()
+		if err != nil {
+			return hints, append(errs, err)
+		}
+		c.masterIndex.Insert(idx)
+	}
+
 	// Merge index before computing pack sizes, as this needs removed duplicates
 	err = c.masterIndex.MergeFinalIndexes()
 	if err != nil {
@@ -199,6 +218,20 @@ func (e *PackError) Error() string {
 	return "pack " + e.ID.String() + ": " + e.Err.Error()
 }
 
+// ReadError is returned by ReadPacks for a pack whose content is damaged.
+type ReadError struct {
+	ID  restic.ID
+	Err error
+}
+
+func (e *ReadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
 // IsOrphanedPack returns true if the error describes a pack which is not
 // contained in any index.
 func IsOrphanedPack(err error) bool {
@@ -346,6 +379,143 @@ func loadSnapshotTreeIDs(ctx context.Context, lister restic.Lister, repo restic.
 	return ids, errs
 }
 
+// SignatureError is returned when a snapshot is not signed by a trusted key.
+type SignatureError struct {
+	SnapshotID restic.ID
+	Err        error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("snapshot %v: %v", e.SnapshotID.Str(), e.Err)
+}
+
+// VerifySignatures checks that every snapshot carries a valid signature
+// created by one of the trusted keys. This detects snapshots which were
+// injected or replaced by someone without access to a signing key.
+func (c *Checker) VerifySignatures(ctx context.Context, trusted []ed25519.PublicKey) (errs []error) {
+	err := restic.ForAllSnapshots(ctx, c.snapshots, c.repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+
+		err = sn.VerifySignature(trusted)
+		if err != nil {
+			debug.Log("snapshot %v: signature verification failed: %v", id, err)
+			errs = append(errs, &SignatureError{SnapshotID: id, Err: err})
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// DuplicateSnapshot is a snapshot with the same or almost the same content as
+// the previous snapshot of the same host and paths.
+type DuplicateSnapshot struct {
+	Snapshot *restic.Snapshot
+	Previous *restic.Snapshot
+	// Similarity is the fraction of the file data referenced by either
+	// snapshot which is shared by both. Metadata is ignored.
+	Similarity float64
+}
+
+// DuplicateSnapshots compares each snapshot with its predecessor of the same
+// host and paths and returns those with a similarity of at least
+// minSimilarity, ordered by time.
+func (c *Checker) DuplicateSnapshots(ctx context.Context, minSimilarity float64) ([]DuplicateSnapshot, error) {
+	var snapshots restic.Snapshots
+	err := restic.ForAllSnapshots(ctx, c.snapshots, c.repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups, _, err := restic.GroupSnapshots(snapshots, restic.SnapshotGroupByOptions{Host: true, Path: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var dups []DuplicateSnapshot
+	for _, group := range groups {
+		sort.Sort(sort.Reverse(group))
+
+		var prevBlobs restic.BlobSet
+		for i := 1; i < len(group); i++ {
+			prev, sn := group[i-1], group[i]
+			if prev.Tree.Equal(*sn.Tree) {
+				dups = append(dups, DuplicateSnapshot{Snapshot: sn, Previous: prev, Similarity: 1})
+				continue
+			}
+
+			if prevBlobs == nil {
+				prevBlobs, err = c.snapshotBlobs(ctx, prev)
+				if err != nil {
+					return nil, err
+				}
+			}
+			blobs, err := c.snapshotBlobs(ctx, sn)
+			if err != nil {
+				return nil, err
+			}
+
+			similarity := c.similarity(prevBlobs, blobs)
+			debug.Log("snapshot %v shares %.4f of its data with %v", sn.ID().Str(), similarity, prev.ID().Str())
+			if similarity >= minSimilarity {
+				dups = append(dups, DuplicateSnapshot{Snapshot: sn, Previous: prev, Similarity: similarity})
+			}
+			prevBlobs = blobs
+		}
+	}
+
+	sort.Slice(dups, func(i, j int) bool {
+		return dups[i].Snapshot.Time.Before(dups[j].Snapshot.Time)
+	})
+	return dups, nil
+}
+
+// snapshotBlobs returns all blobs referenced by the snapshot.
+func (c *Checker) snapshotBlobs(ctx context.Context, sn *restic.Snapshot) (restic.BlobSet, error) {
+	blobs := restic.NewBlobSet()
+	err := restic.FindUsedBlobs(ctx, c.repo, restic.IDs{*sn.Tree}, blobs, nil)
+	return blobs, err
+}
+
+// similarity returns the size of the data blobs contained in both sets
+// relative to the size of all data blobs.
+func (c *Checker) similarity(a, b restic.BlobSet) float64 {
+	var shared, total uint64
+	for h := range a {
+		if h.Type != restic.DataBlob {
+			continue
+		}
+		size, _ := c.repo.LookupBlobSize(h.ID, h.Type)
+		total += uint64(size)
+		if b.Has(h) {
+			shared += uint64(size)
+		}
+	}
+	for h := range b {
+		if h.Type == restic.DataBlob && !a.Has(h) {
+			size, _ := c.repo.LookupBlobSize(h.ID, h.Type)
+			total += uint64(size)
+		}
+	}
+
+	if total == 0 {
+		return 1
+	}
+	return float64(shared) / float64(total)
+}
+
 // Structure checks that for all snapshots all referenced data blobs and
 // subtrees are available in the index. errChan is closed after all trees have
 // been traversed.
@@ -561,7 +731,12 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID, blobs []r
 		})
 	}
 
-	err := repository.StreamPack(ctx, hashingLoader, r.Key(), id, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+	filters, err := repository.LookupBlobFilters(r.Config().Filters)
+	if err != nil {
+		return err
+	}
+
+	err = repository.StreamPack(ctx, hashingLoader, r.Key(), filters, r.Config().HashBlob, id, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
 		debug.Log("  check blob %v: %v", blob.ID, blob)
 		if err != nil {
 			debug.Log("  error verifying blob %v: %v", blob.ID, err)
@@ -659,7 +834,7 @@ func (c *Checker) ReadPacks(ctx context.Context, packs map[restic.ID]int64, p *p
 				select {
 				case <-ctx.Done():
 					return nil
-				case errChan <- err:
+				case errChan <- &ReadError{ID: ps.id, Err: err}:
 				}
 			}
 		})
@@ -623,3 +623,50 @@ func BenchmarkCheckerSnapshotScaling(b *testing.B) {
 		})
 	}
 }
+
+func TestCheckerDuplicateSnapshots(t *testing.T) {
+	repo := repository.TestRepository(t)
+	tempdir := test.TempDir(t)
+	files := archiver.TestDir{}
+	for i := 0; i < 200; i++ {
+		files["file"+strconv.Itoa(i)] = archiver.TestFile{Content: string(test.Random(i, 10000))}
+	}
+	archiver.TestCreateFiles(t, tempdir, files)
+	back := test.Chdir(t, tempdir)
+	defer back()
+
+	first := archiver.TestSnapshot(t, repo, ".", nil)
+
+	// a snapshot of the same tree
+	copied := *first
+	copied.Time = first.Time.Add(time.Nanosecond)
+	identicalID, err := restic.SaveSnapshot(context.TODO(), repo, &copied)
+	test.OK(t, err)
+
+	// modify a single file
+	test.OK(t, os.WriteFile(filepath.Join(tempdir, "file0"), []byte("modified"), 0644))
+	similar := archiver.TestSnapshot(t, repo, ".", nil)
+
+	// replace most of the data
+	for i := 0; i < 150; i++ {
+		test.OK(t, os.WriteFile(filepath.Join(tempdir, "file"+strconv.Itoa(i)), test.Random(1000+i, 10000), 0644))
+	}
+	archiver.TestSnapshot(t, repo, ".", nil)
+
+	chkr := checker.New(repo, false)
+	test.OK(t, chkr.LoadSnapshots(context.TODO()))
+	_, errs := chkr.LoadIndex(context.TODO())
+	test.OKs(t, errs)
+
+	dups, err := chkr.DuplicateSnapshots(context.TODO(), 0.99)
+	test.OK(t, err)
+	test.Equals(t, 2, len(dups))
+
+	test.Equals(t, identicalID, *dups[0].Snapshot.ID())
+	test.Assert(t, dups[0].Previous.Time.Equal(first.Time), "wrong predecessor %v", dups[0].Previous)
+	test.Equals(t, 1.0, dups[0].Similarity)
+
+	test.Assert(t, dups[1].Snapshot.Time.Equal(similar.Time), "wrong near duplicate %v", dups[1].Snapshot)
+	test.Equals(t, identicalID, *dups[1].Previous.ID())
+	test.Assert(t, dups[1].Similarity >= 0.99 && dups[1].Similarity < 1, "unexpected similarity %v", dups[1].Similarity)
+}
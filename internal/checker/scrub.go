@@ -0,0 +1,196 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The scrub state records for each pack file when its data was last read and
+// verified. It is stored in the repository in the subdir "scrub", such that
+// all clients share it. When the state is saved, a new file is written before
+// the files it was loaded from are removed. Files written concurrently are
+// merged when loading them.
+
+// DefaultScrubInterval is the assumed time between two scrub runs if no
+// previous run is recorded.
+const DefaultScrubInterval = 24 * time.Hour
+
+// ScrubRecord describes when a pack file was last verified. For pack files
+// which were never verified, Time is the time they were first seen by scrub.
+type ScrubRecord struct {
+	Time     time.Time
+	Verified bool
+}
+
+// newer returns true if r replaces other when merging two states, verified
+// records take precedence over those of unverified pack files.
+func (r ScrubRecord) newer(other ScrubRecord) bool {
+	if r.Verified != other.Verified {
+		return r.Verified
+	}
+	return r.Time.After(other.Time)
+}
+
+// ScrubState records when each pack file of a repository was last verified.
+type ScrubState struct {
+	LastRun time.Time
+	Packs   map[restic.ID]ScrubRecord
+
+	files restic.IDs
+}
+
+type scrubEntry struct {
+	ID       restic.ID `json:"id"`
+	Time     time.Time `json:"time"`
+	Verified bool      `json:"verified,omitempty"`
+}
+
+type scrubFile struct {
+	LastRun time.Time    `json:"last_run"`
+	Packs   []scrubEntry `json:"packs"`
+}
+
+// LoadScrubState loads and merges all scrub files of the repository. If none
+// exists, an empty state is returned.
+func LoadScrubState(ctx context.Context, repo restic.Repository) (*ScrubState, error) {
+	state := &ScrubState{Packs: make(map[restic.ID]ScrubRecord)}
+	err := repo.List(ctx, restic.ScrubFile, func(id restic.ID, _ int64) error {
+		state.files = append(state.files, id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range state.files {
+		var f scrubFile
+		if err := restic.LoadJSONUnpacked(ctx, repo, restic.ScrubFile, id, &f); err != nil {
+			return nil, errors.Wrapf(err, "loading scrub file %v", id.Str())
+		}
+		if f.LastRun.After(state.LastRun) {
+			state.LastRun = f.LastRun
+		}
+		for _, e := range f.Packs {
+			rec := ScrubRecord{Time: e.Time, Verified: e.Verified}
+			if old, ok := state.Packs[e.ID]; !ok || rec.newer(old) {
+				state.Packs[e.ID] = rec
+			}
+		}
+	}
+	debug.Log("loaded scrub state for %d packs from %d files", len(state.Packs), len(state.files))
+	return state, nil
+}
+
+// Save stores the state in a new scrub file and removes the files the state
+// was loaded from.
+func (s *ScrubState) Save(ctx context.Context, repo restic.Repository) error {
+	f := scrubFile{LastRun: s.LastRun, Packs: make([]scrubEntry, 0, len(s.Packs))}
+	for id, rec := range s.Packs {
+		f.Packs = append(f.Packs, scrubEntry{ID: id, Time: rec.Time, Verified: rec.Verified})
+	}
+	sort.Slice(f.Packs, func(i, j int) bool {
+		return bytes.Compare(f.Packs[i].ID[:], f.Packs[j].ID[:]) < 0
+	})
+
+	id, err := restic.SaveJSONUnpacked(ctx, repo, restic.ScrubFile, f)
+	if err != nil {
+		return errors.Wrap(err, "saving scrub state")
+	}
+	debug.Log("saved scrub state %v for %d packs", id, len(f.Packs))
+
+	for _, old := range s.files {
+		err := repo.Backend().Remove(ctx, restic.Handle{Type: restic.ScrubFile, Name: old.String()})
+		// a concurrent run may already have removed the file
+		if err != nil && !repo.Backend().IsNotExist(err) {
+			return err
+		}
+	}
+	s.files = restic.IDs{id}
+	return nil
+}
+
+// SelectPacks returns the pack files to verify at time now, such that each
+// pack file is verified at least once within window if scrub runs
+// regularly. Records of pack files which no longer exist are removed and pack
+// files without a record are recorded as seen at now.
+//
+// The pack files verified longest ago are selected first, until both all
+// pack files which would otherwise not be verified in time before the next
+// run are selected and the share of the repository size corresponding to the
+// time since the previous run is reached.
+func (s *ScrubState) SelectPacks(packs map[restic.ID]int64, now time.Time, window time.Duration) map[restic.ID]int64 {
+	for id := range s.Packs {
+		if _, ok := packs[id]; !ok {
+			delete(s.Packs, id)
+		}
+	}
+	var total int64
+	ids := make(restic.IDs, 0, len(packs))
+	for id, size := range packs {
+		if _, ok := s.Packs[id]; !ok {
+			s.Packs[id] = ScrubRecord{Time: now}
+		}
+		total += size
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		ri, rj := s.Packs[ids[i]], s.Packs[ids[j]]
+		if !ri.Time.Equal(rj.Time) {
+			return ri.Time.Before(rj.Time)
+		}
+		// prefer pack files which were never verified, or failed to verify
+		if ri.Verified != rj.Verified {
+			return !ri.Verified
+		}
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+
+	interval := DefaultScrubInterval
+	if !s.LastRun.IsZero() && s.LastRun.Before(now) {
+		interval = now.Sub(s.LastRun)
+	}
+	if interval > window {
+		interval = window
+	}
+	budget := int64(math.Ceil(float64(total) * float64(interval) / float64(window)))
+
+	selected := make(map[restic.ID]int64)
+	var size int64
+	for _, id := range ids {
+		due := !s.Packs[id].Time.Add(window).After(now.Add(interval))
+		if !due && size >= budget {
+			// all following pack files were verified later
+			break
+		}
+		selected[id] = packs[id]
+		size += packs[id]
+	}
+	return selected
+}
+
+// MarkVerified records that the pack file was verified at time t.
+func (s *ScrubState) MarkVerified(id restic.ID, t time.Time) {
+	s.Packs[id] = ScrubRecord{Time: t, Verified: true}
+}
+
+// Oldest returns the time of the oldest verification and the number of pack
+// files which were never verified.
+func (s *ScrubState) Oldest() (oldest time.Time, unverified int) {
+	for _, rec := range s.Packs {
+		if !rec.Verified {
+			unverified++
+			continue
+		}
+		if oldest.IsZero() || rec.Time.Before(oldest) {
+			oldest = rec.Time
+		}
+	}
+	return oldest, unverified
+}
@@ -0,0 +1,112 @@
+package checker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestScrubSelectPacks(t *testing.T) {
+	packs := make(map[restic.ID]int64)
+	for i := 0; i < 60; i++ {
+		packs[restic.NewRandomID()] = 1000
+	}
+
+	const day = 24 * time.Hour
+	window := 30 * day
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	state := &checker.ScrubState{Packs: make(map[restic.ID]checker.ScrubRecord)}
+
+	for run := 0; run < 90; run++ {
+		now := start.Add(time.Duration(run) * day)
+		// add some data half-way through
+		if run == 45 {
+			for i := 0; i < 30; i++ {
+				packs[restic.NewRandomID()] = 1000
+			}
+		}
+
+		selected := state.SelectPacks(packs, now, window)
+		// about 1/30 of the repository is read each day
+		test.Assert(t, len(selected) <= 2*len(packs)/30+1, "run %d: %d packs selected", run, len(selected))
+		for id := range selected {
+			state.MarkVerified(id, now)
+		}
+		state.LastRun = now
+
+		for id, rec := range state.Packs {
+			test.Assert(t, now.Sub(rec.Time) < window, "run %d: pack %v was verified at %v", run, id.Str(), rec.Time)
+		}
+	}
+
+	_, unverified := state.Oldest()
+	test.Equals(t, 0, unverified)
+
+	// records of removed pack files are removed
+	for id := range packs {
+		delete(packs, id)
+		break
+	}
+	state.SelectPacks(packs, start.Add(90*day), window)
+	test.Equals(t, len(packs), len(state.Packs))
+}
+
+func TestScrubSelectPacksOverdue(t *testing.T) {
+	packs := make(map[restic.ID]int64)
+	state := &checker.ScrubState{Packs: make(map[restic.ID]checker.ScrubRecord)}
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		id := restic.NewRandomID()
+		packs[id] = 1000
+		state.MarkVerified(id, now.Add(-40*24*time.Hour))
+	}
+	state.LastRun = now.Add(-time.Hour)
+
+	// scrub did not run for a long time, all overdue packs are selected
+	selected := state.SelectPacks(packs, now, 30*24*time.Hour)
+	test.Equals(t, len(packs), len(selected))
+}
+
+func TestScrubStateSaveLoad(t *testing.T) {
+	ctx := context.TODO()
+	repo := repository.TestRepository(t)
+
+	state, err := checker.LoadScrubState(ctx, repo)
+	test.OK(t, err)
+	test.Equals(t, 0, len(state.Packs))
+
+	now := time.Unix(1700000000, 0).UTC()
+	id1, id2 := restic.NewRandomID(), restic.NewRandomID()
+	state.SelectPacks(map[restic.ID]int64{id1: 10, id2: 20}, now, 24*time.Hour)
+	state.MarkVerified(id1, now)
+	state.LastRun = now
+	test.OK(t, state.Save(ctx, repo))
+
+	// a concurrently saved state is merged
+	other, err := checker.LoadScrubState(ctx, repo)
+	test.OK(t, err)
+	later := now.Add(time.Hour)
+	other.MarkVerified(id2, later)
+	state.MarkVerified(id1, later)
+	test.OK(t, other.Save(ctx, repo))
+	test.OK(t, state.Save(ctx, repo))
+
+	loaded, err := checker.LoadScrubState(ctx, repo)
+	test.OK(t, err)
+	test.Equals(t, now, loaded.LastRun)
+	test.Equals(t, checker.ScrubRecord{Time: later, Verified: true}, loaded.Packs[id1])
+	test.Equals(t, checker.ScrubRecord{Time: later, Verified: true}, loaded.Packs[id2])
+
+	test.OK(t, loaded.Save(ctx, repo))
+	var files int
+	test.OK(t, repo.List(ctx, restic.ScrubFile, func(restic.ID, int64) error {
+		files++
+		return nil
+	}))
+	test.Equals(t, 1, files)
+}
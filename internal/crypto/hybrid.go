@@ -0,0 +1,179 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/restic/restic/internal/crypto/mlkem"
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/sha3"
+)
+
+// HybridKEM is the name of the hybrid key encapsulation mechanism, which
+// combines X25519 and ML-KEM-768. A key derived from it is secure as long as
+// one of both is not broken, in particular ML-KEM protects the key against
+// attackers who record it now and decrypt it once large quantum computers
+// exist.
+const HybridKEM = "x25519-mlkem768"
+
+// hybridLabel separates the keys derived by the hybrid KEM from other uses of
+// the shared secrets.
+const hybridLabel = "restic hybrid kem x25519-mlkem768"
+
+// Identity is the private key of the hybrid KEM.
+type Identity struct {
+	Type string `json:"type"`
+	// X25519 is the private scalar.
+	X25519 []byte `json:"x25519"`
+	// MLKEM is the seed of the ML-KEM-768 decapsulation key.
+	MLKEM []byte `json:"mlkem_seed"`
+}
+
+// Recipient is the public key of the hybrid KEM, for which keys can be
+// encapsulated.
+type Recipient struct {
+	Type string `json:"type"`
+	// X25519 is the public point.
+	X25519 []byte `json:"x25519"`
+	// MLKEM is the encoded ML-KEM-768 encapsulation key.
+	MLKEM []byte `json:"mlkem"`
+}
+
+// NewIdentity generates a new random identity.
+func NewIdentity() (*Identity, error) {
+	id := &Identity{
+		Type:   HybridKEM,
+		X25519: make([]byte, curve25519.ScalarSize),
+		MLKEM:  make([]byte, mlkem.SeedSize),
+	}
+	if _, err := rand.Read(id.X25519); err != nil {
+		return nil, errors.Wrap(err, "rand.Read")
+	}
+	if _, err := rand.Read(id.MLKEM); err != nil {
+		return nil, errors.Wrap(err, "rand.Read")
+	}
+	return id, nil
+}
+
+func (id *Identity) check() error {
+	if id.Type != HybridKEM {
+		return errors.Errorf("unsupported identity type %q", id.Type)
+	}
+	if len(id.X25519) != curve25519.ScalarSize {
+		return errors.New("invalid X25519 key in identity")
+	}
+	return nil
+}
+
+// Recipient returns the public key of the identity.
+func (id *Identity) Recipient() (*Recipient, error) {
+	if err := id.check(); err != nil {
+		return nil, err
+	}
+	x, err := curve25519.X25519(id.X25519, curve25519.Basepoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "X25519")
+	}
+	dk, err := mlkem.NewDecapsulationKey(id.MLKEM)
+	if err != nil {
+		return nil, err
+	}
+	return &Recipient{Type: HybridKEM, X25519: x, MLKEM: dk.EncapsulationKey()}, nil
+}
+
+// Fingerprint returns the hex-encoded SHA-256 hash of the public keys of the
+// recipient.
+func (r *Recipient) Fingerprint() string {
+	h := sha256.New()
+	_, _ = h.Write(r.X25519)
+	_, _ = h.Write(r.MLKEM)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HybridCiphertext contains the encapsulated shared secrets.
+type HybridCiphertext struct {
+	// X25519 is the ephemeral public point.
+	X25519 []byte `json:"x25519"`
+	// MLKEM is the ML-KEM-768 ciphertext.
+	MLKEM []byte `json:"mlkem"`
+}
+
+// deriveHybridKey combines both shared secrets with the X25519 ciphertext and
+// public key into the encryption and MAC key, similar to the combiner of the
+// X-Wing KEM.
+func deriveHybridKey(ssMLKEM, ssX25519 []byte, ct *HybridCiphertext, r *Recipient) *Key {
+	h := sha3.NewShake256()
+	for _, part := range [][]byte{[]byte(hybridLabel), ssMLKEM, ssX25519, ct.X25519, r.X25519} {
+		_, _ = h.Write(part)
+	}
+	buf := make([]byte, aesKeySize+macKeySize)
+	_, _ = h.Read(buf)
+
+	k := &Key{}
+	copy(k.EncryptionKey[:], buf[:aesKeySize])
+	macKeyFromSlice(&k.MACKey, buf[aesKeySize:])
+	return k
+}
+
+// Encapsulate generates a new random key for the recipient. The key can be
+// recovered from the ciphertext by the identity of the recipient.
+func (r *Recipient) Encapsulate() (*HybridCiphertext, *Key, error) {
+	if r.Type != HybridKEM {
+		return nil, nil, errors.Errorf("unsupported recipient type %q", r.Type)
+	}
+	if len(r.X25519) != curve25519.PointSize {
+		return nil, nil, errors.New("invalid X25519 key in recipient")
+	}
+
+	ssMLKEM, ctMLKEM, err := mlkem.Encapsulate(r.MLKEM, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	eph := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(eph); err != nil {
+		return nil, nil, errors.Wrap(err, "rand.Read")
+	}
+	ctX25519, err := curve25519.X25519(eph, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "X25519")
+	}
+	// fails for low-order points
+	ssX25519, err := curve25519.X25519(eph, r.X25519)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "X25519")
+	}
+
+	ct := &HybridCiphertext{X25519: ctX25519, MLKEM: ctMLKEM}
+	return ct, deriveHybridKey(ssMLKEM, ssX25519, ct, r), nil
+}
+
+// Decapsulate recovers the key encapsulated for the recipient of the
+// identity. Using a different identity results in a different key, which
+// fails to authenticate any data.
+func (id *Identity) Decapsulate(ct *HybridCiphertext) (*Key, error) {
+	r, err := id.Recipient()
+	if err != nil {
+		return nil, err
+	}
+	dk, err := mlkem.NewDecapsulationKey(id.MLKEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(ct.X25519) != curve25519.PointSize {
+		return nil, errors.New("invalid X25519 ciphertext")
+	}
+
+	ssMLKEM, err := dk.Decapsulate(ct.MLKEM)
+	if err != nil {
+		return nil, err
+	}
+	ssX25519, err := curve25519.X25519(id.X25519, ct.X25519)
+	if err != nil {
+		return nil, errors.Wrap(err, "X25519")
+	}
+	return deriveHybridKey(ssMLKEM, ssX25519, ct, r), nil
+}
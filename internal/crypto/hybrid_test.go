@@ -0,0 +1,69 @@
+package crypto_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestHybridKEM(t *testing.T) {
+	id, err := crypto.NewIdentity()
+	rtest.OK(t, err)
+	r, err := id.Recipient()
+	rtest.OK(t, err)
+
+	ct, key, err := r.Encapsulate()
+	rtest.OK(t, err)
+	rtest.Assert(t, key.Valid(), "encapsulated key is invalid")
+
+	// the identity survives a roundtrip through JSON
+	buf, err := json.Marshal(id)
+	rtest.OK(t, err)
+	loaded := &crypto.Identity{}
+	rtest.OK(t, json.Unmarshal(buf, loaded))
+
+	decapsulated, err := loaded.Decapsulate(ct)
+	rtest.OK(t, err)
+	rtest.Equals(t, key.EncryptionKey, decapsulated.EncryptionKey)
+	rtest.Equals(t, key.MACKey.K, decapsulated.MACKey.K)
+	rtest.Equals(t, key.MACKey.R, decapsulated.MACKey.R)
+
+	data := []byte("master key")
+	nonce := crypto.NewRandomNonce()
+	sealed := key.Seal(nil, nonce, data, nil)
+	plain, err := decapsulated.Open(nil, nonce, sealed, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, plain)
+
+	// another identity derives a different key
+	other, err := crypto.NewIdentity()
+	rtest.OK(t, err)
+	wrong, err := other.Decapsulate(ct)
+	rtest.OK(t, err)
+	_, err = wrong.Open(nil, nonce, sealed, nil)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "expected authentication failure, got %v", err)
+
+	// a new encapsulation results in a new key
+	_, key2, err := r.Encapsulate()
+	rtest.OK(t, err)
+	rtest.Assert(t, key.EncryptionKey != key2.EncryptionKey, "encapsulated keys are identical")
+
+	otherRecipient, err := other.Recipient()
+	rtest.OK(t, err)
+	rtest.Assert(t, r.Fingerprint() != otherRecipient.Fingerprint(), "fingerprints are identical")
+}
+
+func TestHybridKEMInvalid(t *testing.T) {
+	id, err := crypto.NewIdentity()
+	rtest.OK(t, err)
+
+	id.Type = "rsa"
+	_, err = id.Recipient()
+	rtest.Assert(t, err != nil, "identity with unknown type was accepted")
+
+	r := &crypto.Recipient{Type: crypto.HybridKEM, X25519: make([]byte, 32)}
+	_, _, err = r.Encapsulate()
+	rtest.Assert(t, err != nil, "invalid recipient was accepted")
+}
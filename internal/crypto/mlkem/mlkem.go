@@ -0,0 +1,413 @@
+// Package mlkem implements the ML-KEM-768 key encapsulation mechanism as
+// specified in FIPS 203, also known as Kyber.
+//
+// The implementation follows the specification closely and is not optimized
+// for speed, restic only uses it to wrap the repository master key.
+package mlkem
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	n = 256
+	q = 3329
+
+	// parameters of ML-KEM-768
+	k    = 3
+	eta1 = 2
+	eta2 = 2
+	du   = 10
+	dv   = 4
+
+	encodedPolySize = 384
+)
+
+const (
+	// SeedSize is the size of the seed from which a decapsulation key is
+	// derived.
+	SeedSize = 64
+	// EncapsulationKeySize is the size of an encoded encapsulation key.
+	EncapsulationKeySize = k*encodedPolySize + 32
+	// CiphertextSize is the size of a ciphertext.
+	CiphertextSize = 32 * (du*k + dv)
+	// SharedKeySize is the size of the shared secret.
+	SharedKeySize = 32
+)
+
+type poly [n]uint16
+
+// zetas contains 17^BitRev7(i) mod q.
+var zetas [128]uint16
+
+// gammas contains 17^(2*BitRev7(i)+1) mod q.
+var gammas [128]uint16
+
+func init() {
+	pow := func(e int) uint16 {
+		r := uint32(1)
+		for i := 0; i < e; i++ {
+			r = r * 17 % q
+		}
+		return uint16(r)
+	}
+	for i := range zetas {
+		rev := 0
+		for b := 0; b < 7; b++ {
+			rev |= (i >> b & 1) << (6 - b)
+		}
+		zetas[i] = pow(rev)
+		gammas[i] = pow(2*rev + 1)
+	}
+}
+
+func add(a, b uint16) uint16 {
+	return uint16((uint32(a) + uint32(b)) % q)
+}
+
+func sub(a, b uint16) uint16 {
+	return uint16((uint32(a) + q - uint32(b)) % q)
+}
+
+func mul(a, b uint16) uint16 {
+	return uint16(uint32(a) * uint32(b) % q)
+}
+
+func (p *poly) add(o *poly) {
+	for i := range p {
+		p[i] = add(p[i], o[i])
+	}
+}
+
+func (p *poly) ntt() {
+	i := 1
+	for length := 128; length >= 2; length /= 2 {
+		for start := 0; start < n; start += 2 * length {
+			zeta := zetas[i]
+			i++
+			for j := start; j < start+length; j++ {
+				t := mul(zeta, p[j+length])
+				p[j+length] = sub(p[j], t)
+				p[j] = add(p[j], t)
+			}
+		}
+	}
+}
+
+func (p *poly) invNTT() {
+	i := 127
+	for length := 2; length <= 128; length *= 2 {
+		for start := 0; start < n; start += 2 * length {
+			zeta := zetas[i]
+			i--
+			for j := start; j < start+length; j++ {
+				t := p[j]
+				p[j] = add(t, p[j+length])
+				p[j+length] = mul(zeta, sub(p[j+length], t))
+			}
+		}
+	}
+	for j := range p {
+		// 3303 is the inverse of 128 modulo q
+		p[j] = mul(p[j], 3303)
+	}
+}
+
+// mulNTT returns the product of two polynomials in NTT representation.
+func mulNTT(a, b *poly) (r poly) {
+	for i := 0; i < n/2; i++ {
+		a0, a1, b0, b1 := a[2*i], a[2*i+1], b[2*i], b[2*i+1]
+		r[2*i] = add(mul(a0, b0), mul(mul(a1, b1), gammas[i]))
+		r[2*i+1] = add(mul(a0, b1), mul(a1, b0))
+	}
+	return r
+}
+
+// sampleNTT samples a polynomial in NTT representation from the output of
+// SHAKE128(rho || j || i).
+func sampleNTT(rho []byte, j, i byte) (p poly) {
+	xof := sha3.NewShake128()
+	_, _ = xof.Write(rho)
+	_, _ = xof.Write([]byte{j, i})
+
+	var buf [168]byte
+	for c := 0; c < n; {
+		_, _ = xof.Read(buf[:])
+		for off := 0; off+3 <= len(buf) && c < n; off += 3 {
+			d1 := uint16(buf[off]) | uint16(buf[off+1]&0x0f)<<8
+			d2 := uint16(buf[off+1]>>4) | uint16(buf[off+2])<<4
+			if d1 < q {
+				p[c] = d1
+				c++
+			}
+			if d2 < q && c < n {
+				p[c] = d2
+				c++
+			}
+		}
+	}
+	return p
+}
+
+// samplePolyCBD samples a polynomial from the centered binomial distribution
+// with eta = 2 using SHAKE256(s || b).
+func samplePolyCBD(s []byte, b byte) (p poly) {
+	prf := sha3.NewShake256()
+	_, _ = prf.Write(s)
+	_, _ = prf.Write([]byte{b})
+	var buf [64 * eta1]byte
+	_, _ = prf.Read(buf[:])
+
+	for i := 0; i < n; i++ {
+		bits := buf[i/2] >> (4 * (i % 2))
+		x := uint16(bits&1 + bits>>1&1)
+		y := uint16(bits>>2&1 + bits>>3&1)
+		p[i] = sub(x, y)
+	}
+	return p
+}
+
+func compress(x uint16, d uint) uint16 {
+	return uint16(((uint32(x)<<d)+q/2)/q) & (1<<d - 1)
+}
+
+func decompress(y uint16, d uint) uint16 {
+	return uint16((uint32(y)*q + 1<<(d-1)) >> d)
+}
+
+// byteEncode appends the coefficients of p, each d bits long, to buf.
+func byteEncode(buf []byte, p *poly, d uint) []byte {
+	var acc uint32
+	var bits uint
+	for _, c := range p {
+		acc |= uint32(c) << bits
+		bits += d
+		for bits >= 8 {
+			buf = append(buf, byte(acc))
+			acc >>= 8
+			bits -= 8
+		}
+	}
+	return buf
+}
+
+// byteDecode decodes a polynomial with d bits per coefficient from buf, which
+// must have the length 32*d. Coefficients are reduced modulo q.
+func byteDecode(buf []byte, d uint) (p poly) {
+	var acc uint32
+	var bits uint
+	i := 0
+	for _, b := range buf {
+		acc |= uint32(b) << bits
+		bits += 8
+		for bits >= d && i < n {
+			p[i] = uint16(acc&(1<<d-1)) % q
+			acc >>= d
+			bits -= d
+			i++
+		}
+	}
+	return p
+}
+
+func g(parts ...[]byte) (a, b []byte) {
+	h := sha3.New512()
+	for _, p := range parts {
+		_, _ = h.Write(p)
+	}
+	sum := h.Sum(nil)
+	return sum[:32], sum[32:]
+}
+
+func h(data []byte) []byte {
+	sum := sha3.Sum256(data)
+	return sum[:]
+}
+
+// DecapsulationKey is the private key of ML-KEM-768.
+type DecapsulationKey struct {
+	seed []byte
+
+	s  [k]poly // in NTT representation
+	ek []byte
+	hk []byte // H(ek)
+	z  []byte
+}
+
+// GenerateKey returns a new decapsulation key, using random bytes from rd.
+func GenerateKey(rd io.Reader) (*DecapsulationKey, error) {
+	seed := make([]byte, SeedSize)
+	if _, err := io.ReadFull(rd, seed); err != nil {
+		return nil, errors.Wrap(err, "ReadFull")
+	}
+	return NewDecapsulationKey(seed)
+}
+
+// NewDecapsulationKey derives a decapsulation key from the seed d || z.
+func NewDecapsulationKey(seed []byte) (*DecapsulationKey, error) {
+	if len(seed) != SeedSize {
+		return nil, errors.New("invalid ML-KEM seed length")
+	}
+	d, z := seed[:32], seed[32:]
+
+	rho, sigma := g(d, []byte{k})
+	var e [k]poly
+	dk := &DecapsulationKey{seed: append([]byte{}, seed...), z: append([]byte{}, z...)}
+	for i := 0; i < k; i++ {
+		dk.s[i] = samplePolyCBD(sigma, byte(i))
+		dk.s[i].ntt()
+	}
+	for i := 0; i < k; i++ {
+		e[i] = samplePolyCBD(sigma, byte(k+i))
+		e[i].ntt()
+	}
+
+	ek := make([]byte, 0, EncapsulationKeySize)
+	for i := 0; i < k; i++ {
+		t := e[i]
+		for j := 0; j < k; j++ {
+			a := sampleNTT(rho, byte(j), byte(i))
+			prod := mulNTT(&a, &dk.s[j])
+			t.add(&prod)
+		}
+		ek = byteEncode(ek, &t, 12)
+	}
+	dk.ek = append(ek, rho...)
+	dk.hk = h(dk.ek)
+	return dk, nil
+}
+
+// Seed returns the seed the key was derived from.
+func (dk *DecapsulationKey) Seed() []byte {
+	return append([]byte{}, dk.seed...)
+}
+
+// EncapsulationKey returns the encoded public key.
+func (dk *DecapsulationKey) EncapsulationKey() []byte {
+	return append([]byte{}, dk.ek...)
+}
+
+// encrypt implements K-PKE.Encrypt.
+func encrypt(ek []byte, m []byte, r []byte) []byte {
+	var t [k]poly
+	for i := range t {
+		t[i] = byteDecode(ek[i*encodedPolySize:(i+1)*encodedPolySize], 12)
+	}
+	rho := ek[k*encodedPolySize:]
+
+	var y [k]poly
+	for i := range y {
+		y[i] = samplePolyCBD(r, byte(i))
+		y[i].ntt()
+	}
+
+	ct := make([]byte, 0, CiphertextSize)
+	for i := 0; i < k; i++ {
+		var u poly
+		for j := 0; j < k; j++ {
+			// the matrix is transposed
+			a := sampleNTT(rho, byte(i), byte(j))
+			prod := mulNTT(&a, &y[j])
+			u.add(&prod)
+		}
+		u.invNTT()
+		e1 := samplePolyCBD(r, byte(k+i))
+		u.add(&e1)
+		for j := range u {
+			u[j] = compress(u[j], du)
+		}
+		ct = byteEncode(ct, &u, du)
+	}
+
+	var v poly
+	for i := 0; i < k; i++ {
+		prod := mulNTT(&t[i], &y[i])
+		v.add(&prod)
+	}
+	v.invNTT()
+	e2 := samplePolyCBD(r, 2*k)
+	v.add(&e2)
+	mu := byteDecode(m, 1)
+	for j := range v {
+		v[j] = compress(add(v[j], decompress(mu[j], 1)), dv)
+	}
+	return byteEncode(ct, &v, dv)
+}
+
+// decrypt implements K-PKE.Decrypt.
+func (dk *DecapsulationKey) decrypt(ct []byte) []byte {
+	var w poly
+	for i := 0; i < k; i++ {
+		u := byteDecode(ct[i*32*du:(i+1)*32*du], du)
+		for j := range u {
+			u[j] = decompress(u[j], du)
+		}
+		u.ntt()
+		prod := mulNTT(&dk.s[i], &u)
+		w.add(&prod)
+	}
+	w.invNTT()
+
+	v := byteDecode(ct[k*32*du:], dv)
+	for j := range v {
+		v[j] = compress(sub(decompress(v[j], dv), w[j]), 1)
+	}
+	return byteEncode(nil, &v, 1)
+}
+
+// checkEncapsulationKey verifies the length of the key and that all
+// coefficients are reduced modulo q.
+func checkEncapsulationKey(ek []byte) error {
+	if len(ek) != EncapsulationKeySize {
+		return errors.New("invalid ML-KEM encapsulation key length")
+	}
+	for i := 0; i < k; i++ {
+		part := ek[i*encodedPolySize : (i+1)*encodedPolySize]
+		p := byteDecode(part, 12)
+		if !bytes.Equal(byteEncode(nil, &p, 12), part) {
+			return errors.New("invalid ML-KEM encapsulation key")
+		}
+	}
+	return nil
+}
+
+// Encapsulate generates a shared key and its ciphertext for the encoded
+// encapsulation key ek, using random bytes from rd.
+func Encapsulate(ek []byte, rd io.Reader) (sharedKey, ciphertext []byte, err error) {
+	if err := checkEncapsulationKey(ek); err != nil {
+		return nil, nil, err
+	}
+	m := make([]byte, 32)
+	if _, err := io.ReadFull(rd, m); err != nil {
+		return nil, nil, errors.Wrap(err, "ReadFull")
+	}
+
+	key, r := g(m, h(ek))
+	return key, encrypt(ek, m, r), nil
+}
+
+// Decapsulate returns the shared key for the ciphertext. For an invalid
+// ciphertext, a pseudo-random key is returned as required by the
+// specification.
+func (dk *DecapsulationKey) Decapsulate(ct []byte) ([]byte, error) {
+	if len(ct) != CiphertextSize {
+		return nil, errors.New("invalid ML-KEM ciphertext length")
+	}
+
+	m := dk.decrypt(ct)
+	key, r := g(m, dk.hk)
+	reject := make([]byte, SharedKeySize)
+	j := sha3.NewShake256()
+	_, _ = j.Write(dk.z)
+	_, _ = j.Write(ct)
+	_, _ = j.Read(reject)
+
+	valid := subtle.ConstantTimeCompare(encrypt(dk.ek, m, r), ct)
+	subtle.ConstantTimeCopy(1-valid, key, reject)
+	return key, nil
+}
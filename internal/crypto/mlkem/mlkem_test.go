@@ -0,0 +1,110 @@
+package mlkem
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+func hashHex(data []byte) string {
+	sum := sha3.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// TestVector checks the keys and ciphertext derived from fixed inputs, the
+// expected values were cross-checked with another implementation.
+func TestVector(t *testing.T) {
+	seed := make([]byte, SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	dk, err := NewDecapsulationKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hashHex(dk.EncapsulationKey()); got != "a24e16d8f8f9383a95b77050f4d9fd2f5733eec1d63ef3c23ebf9918173669a7" {
+		t.Errorf("wrong encapsulation key, hash is %v", got)
+	}
+
+	key, ct, err := Encapsulate(dk.EncapsulationKey(), bytes.NewReader(bytes.Repeat([]byte{0x42}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hashHex(ct); got != "e9a0824664dba3f8f3c86ecb43a0c889030947ff01d276d04d46c204b62fc221" {
+		t.Errorf("wrong ciphertext, hash is %v", got)
+	}
+	if got := hex.EncodeToString(key); got != "b83e7f23b33f909715c7a50b0d4b1f6684d53e1f4b9056f803b29f058ccb5566" {
+		t.Errorf("wrong shared key %v", got)
+	}
+
+	decapsulated, err := dk.Decapsulate(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, decapsulated) {
+		t.Errorf("decapsulated key %x does not match %x", decapsulated, key)
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		dk, err := GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, ct, err := Encapsulate(dk.EncapsulationKey(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ct) != CiphertextSize || len(key) != SharedKeySize {
+			t.Fatalf("wrong sizes %d and %d", len(ct), len(key))
+		}
+
+		decapsulated, err := dk.Decapsulate(ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, decapsulated) {
+			t.Fatalf("decapsulated key %x does not match %x", decapsulated, key)
+		}
+
+		// a modified ciphertext results in a different key
+		ct[i] ^= 1
+		rejected, err := dk.Decapsulate(ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(key, rejected) {
+			t.Fatal("modified ciphertext returned the same key")
+		}
+
+		other, err := NewDecapsulationKey(dk.Seed())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dk.EncapsulationKey(), other.EncapsulationKey()) {
+			t.Fatal("key derived from the seed differs")
+		}
+	}
+}
+
+func TestInvalidEncapsulationKey(t *testing.T) {
+	dk, err := GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek := dk.EncapsulationKey()
+
+	if _, _, err := Encapsulate(ek[:len(ek)-1], rand.Reader); err == nil {
+		t.Error("short encapsulation key was accepted")
+	}
+
+	// a coefficient of 4095 is not reduced modulo q
+	ek[0], ek[1] = 0xff, ek[1]|0x0f
+	if _, _, err := Encapsulate(ek, rand.Reader); err == nil {
+		t.Error("unreduced encapsulation key was accepted")
+	}
+}
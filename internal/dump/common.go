@@ -7,6 +7,7 @@ import (
 
 	"github.com/restic/restic/internal/bloblru"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/layer"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/walker"
 )
@@ -14,10 +15,11 @@ import (
 // A Dumper writes trees and files from a repository to a Writer
 // in an archive format.
 type Dumper struct {
-	cache  *bloblru.Cache
-	format string
-	repo   restic.Repository
-	w      io.Writer
+	cache    *bloblru.Cache
+	format   string
+	repo     restic.Repository
+	w        io.Writer
+	prefetch *prefetcher
 }
 
 func New(format string, repo restic.Repository, w io.Writer) *Dumper {
@@ -30,12 +32,30 @@ func New(format string, repo restic.Repository, w io.Writer) *Dumper {
 }
 
 func (d *Dumper) DumpTree(ctx context.Context, tree *restic.Tree, rootPath string) error {
+	for _, root := range tree.Nodes {
+		root.Path = path.Join(rootPath, root.Name)
+	}
+	return d.DumpNodes(ctx, tree.Nodes)
+}
+
+// DumpNodes writes the nodes and the contents of all directories among them
+// into a single archive. The Path of each node must be set to its location
+// within the archive.
+func (d *Dumper) DumpNodes(ctx context.Context, roots []*restic.Node) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// ch is buffered to deal with variable download/write speeds.
-	ch := make(chan *restic.Node, 10)
-	go sendTrees(ctx, d.repo, tree, rootPath, ch)
+	// nodes is buffered to deal with variable download/write speeds.
+	nodes := make(chan *restic.Node, 10)
+	go sendRoots(ctx, d.repo, roots, nodes)
+
+	ch, err := d.startPrefetch(ctx, nodes)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		d.prefetch = nil
+	}()
 
 	switch d.format {
 	case "tar":
@@ -47,11 +67,10 @@ func (d *Dumper) DumpTree(ctx context.Context, tree *restic.Tree, rootPath strin
 	}
 }
 
-func sendTrees(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, ch chan *restic.Node) {
+func sendRoots(ctx context.Context, repo restic.Repository, roots []*restic.Node, ch chan *restic.Node) {
 	defer close(ch)
 
-	for _, root := range tree.Nodes {
-		root.Path = path.Join(rootPath, root.Name)
+	for _, root := range roots {
 		if sendNodes(ctx, repo, root, ch) != nil {
 			break
 		}
@@ -99,23 +118,73 @@ func sendNodes(ctx context.Context, repo restic.Repository, root *restic.Node, c
 // WriteNode writes a file node's contents directly to d's Writer,
 // without caring about d's format.
 func (d *Dumper) WriteNode(ctx context.Context, node *restic.Node) error {
-	return d.writeNode(ctx, d.w, node)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	nodes := make(chan *restic.Node, 1)
+	nodes <- node
+	close(nodes)
+
+	ch, err := d.startPrefetch(ctx, nodes)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		d.prefetch = nil
+	}()
+
+	return d.writeNode(ctx, d.w, <-ch)
+}
+
+// startPrefetch loads the content of the file nodes from in ahead of
+// writeNode. The nodes must be written in the order of the returned channel.
+func (d *Dumper) startPrefetch(ctx context.Context, in <-chan *restic.Node) (<-chan *restic.Node, error) {
+	p, err := newPrefetcher(d.repo, d.cache)
+	if err != nil {
+		return nil, err
+	}
+	d.prefetch = p
+	return p.start(ctx, in), nil
+}
+
+// loadBlob returns the data blob id, which is taken from the prefetcher if
+// one is running.
+func (d *Dumper) loadBlob(ctx context.Context, id restic.ID) ([]byte, error) {
+	if d.prefetch != nil {
+		return d.prefetch.next(ctx, id)
+	}
+
+	if blob, ok := d.cache.Get(id); ok {
+		return blob, nil
+	}
+	blob, err := d.repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.cache.Add(id, blob)
+	return blob, nil
 }
 
 func (d *Dumper) writeNode(ctx context.Context, w io.Writer, node *restic.Node) error {
-	var (
-		buf []byte
-		err error
-	)
+	if node.Bundle != nil {
+		return d.writeBundled(ctx, w, node)
+	}
+
+	// reconstruct the original file from the decoded content
+	var enc io.WriteCloser
+	if node.ContentEncoding != nil {
+		var err error
+		enc, err = layer.NewWriter(w, node.ContentEncoding)
+		if err != nil {
+			return err
+		}
+		w = enc
+	}
+
 	for _, id := range node.Content {
-		blob, ok := d.cache.Get(id)
-		if !ok {
-			blob, err = d.repo.LoadBlob(ctx, restic.DataBlob, id, buf)
-			if err != nil {
-				return err
-			}
-
-			buf = d.cache.Add(id, blob) // Reuse evicted buffer.
+		blob, err := d.loadBlob(ctx, id)
+		if err != nil {
+			return err
 		}
 
 		if _, err := w.Write(blob); err != nil {
@@ -123,9 +192,31 @@ func (d *Dumper) writeNode(ctx context.Context, w io.Writer, node *restic.Node)
 		}
 	}
 
+	if enc != nil {
+		return errors.Wrap(enc.Close(), "Close")
+	}
 	return nil
 }
 
+// writeBundled writes the part of the bundle which belongs to node.
+func (d *Dumper) writeBundled(ctx context.Context, w io.Writer, node *restic.Node) error {
+	if len(node.Content) != 1 {
+		return errors.Errorf("bundled file %v must reference exactly one blob", node.Name)
+	}
+
+	blob, err := d.loadBlob(ctx, node.Content[0])
+	if err != nil {
+		return err
+	}
+
+	end := node.Bundle.Offset + node.Size
+	if end > uint64(len(blob)) {
+		return errors.Errorf("bundle of %v is too short", node.Name)
+	}
+	_, err = w.Write(blob[node.Bundle.Offset:end])
+	return errors.Wrap(err, "Write")
+}
+
 // IsDir checks if the given node is a directory.
 func IsDir(node *restic.Node) bool {
 	return node.Type == "dir"
@@ -1,6 +1,7 @@
 package dump
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"testing"
@@ -28,6 +29,7 @@ func WriteTest(t *testing.T, format string, cd CheckDump) {
 		name   string
 		args   archiver.TestDir
 		target string
+		bundle bool
 	}{
 		{
 			name: "single file in root",
@@ -66,6 +68,16 @@ func WriteTest(t *testing.T, format string, cd CheckDump) {
 			},
 			target: "/",
 		},
+		{
+			name: "bundled files in root",
+			args: archiver.TestDir{
+				"file1": archiver.TestFile{Content: "first"},
+				"file2": archiver.TestFile{Content: "second"},
+				"file3": archiver.TestFile{Content: "third"},
+			},
+			target: "/",
+			bundle: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -74,6 +86,9 @@ func WriteTest(t *testing.T, format string, cd CheckDump) {
 
 			tmpdir, repo := prepareTempdirRepoSrc(t, tt.args)
 			arch := archiver.New(repo, fs.Track{FS: fs.Local{}}, archiver.Options{})
+			if tt.bundle {
+				arch.BundleThreshold = 1024
+			}
 
 			back := rtest.Chdir(t, tmpdir)
 			defer back()
@@ -83,6 +98,9 @@ func WriteTest(t *testing.T, format string, cd CheckDump) {
 
 			tree, err := restic.LoadTree(ctx, repo, *sn.Tree)
 			rtest.OK(t, err)
+			if tt.bundle {
+				rtest.Assert(t, tree.Nodes[0].Bundle != nil, "file %v was not bundled", tree.Nodes[0].Name)
+			}
 
 			dst := &bytes.Buffer{}
 			d := New(format, repo, dst)
@@ -95,3 +113,50 @@ func WriteTest(t *testing.T, format string, cd CheckDump) {
 		})
 	}
 }
+
+func TestDumpNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tmpdir, repo := prepareTempdirRepoSrc(t, archiver.TestDir{
+		"dir": archiver.TestDir{
+			"sub": archiver.TestDir{
+				"file1": archiver.TestFile{Content: "first"},
+			},
+			"file2": archiver.TestFile{Content: "second"},
+		},
+		"file3": archiver.TestFile{Content: "third"},
+		"file4": archiver.TestFile{Content: "fourth"},
+	})
+	arch := archiver.New(repo, fs.Track{FS: fs.Local{}}, archiver.Options{})
+
+	back := rtest.Chdir(t, tmpdir)
+	defer back()
+
+	sn, _, err := arch.Snapshot(ctx, []string{"."}, archiver.SnapshotOptions{})
+	rtest.OK(t, err)
+	tree, err := restic.LoadTree(ctx, repo, *sn.Tree)
+	rtest.OK(t, err)
+
+	dir, file := tree.Find("dir"), tree.Find("file3")
+	dir.Path, file.Path = "/dir", "/file3"
+
+	dst := &bytes.Buffer{}
+	rtest.OK(t, New("zip", repo, dst).DumpNodes(ctx, []*restic.Node{dir, file}))
+
+	z, err := zip.NewReader(bytes.NewReader(dst.Bytes()), int64(dst.Len()))
+	rtest.OK(t, err)
+	contents := make(map[string]string)
+	for _, f := range z.File {
+		data, err := readZipFile(f)
+		rtest.OK(t, err)
+		contents[f.Name] = string(data)
+	}
+	rtest.Equals(t, map[string]string{
+		"dir/":          "",
+		"dir/file2":     "second",
+		"dir/sub/":      "",
+		"dir/sub/file1": "first",
+		"file3":         "third",
+	}, contents)
+}
@@ -0,0 +1,315 @@
+package dump
+
+import (
+	"context"
+	"sync"
+
+	"github.com/restic/restic/internal/bloblru"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// prefetchBlobs is the number of blobs which are requested ahead of the blob
+// currently written to the archive.
+const prefetchBlobs = 64
+
+// maxPrefetchBatch limits the amount of data loaded from a pack file in a
+// single request.
+const maxPrefetchBatch = 8 << 20
+
+// blobFuture is a data blob which is loaded in the background.
+type blobFuture struct {
+	id     restic.ID
+	packID restic.ID
+	blob   restic.Blob
+	known  bool
+
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func (f *blobFuture) complete(data []byte, err error) {
+	f.data, f.err = data, err
+	close(f.done)
+}
+
+// prefetcher loads the content of the files which are about to be dumped.
+// The blobs are requested in the order in which they are written, consecutive
+// blobs from the same pack file are loaded using a single request. This keeps
+// high-latency backends busy while the archive is written.
+type prefetcher struct {
+	repo    restic.Repository
+	cache   *bloblru.Cache
+	filters repository.BlobFilters
+
+	// blobs contains one future for each blob requested by writeNode, in
+	// the same order.
+	blobs   chan *blobFuture
+	batches chan []*blobFuture
+
+	batch     []*blobFuture
+	batchSize uint
+	last      *blobFuture
+}
+
+// dataKeyOpener is implemented by repositories which support encrypting the
+// contents of files with data keys.
+type dataKeyOpener interface {
+	OpenDataBlob(ctx context.Context, id restic.ID, buf []byte) ([]byte, error)
+}
+
+func newPrefetcher(repo restic.Repository, cache *bloblru.Cache) (*prefetcher, error) {
+	filters, err := repository.LookupBlobFilters(repo.Config().Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &prefetcher{
+		repo:    repo,
+		cache:   cache,
+		filters: filters,
+		blobs:   make(chan *blobFuture, prefetchBlobs),
+		batches: make(chan []*blobFuture),
+	}, nil
+}
+
+// start forwards the nodes from in to the returned channel and loads the
+// content of the files in the background. The blobs must then be requested
+// using next.
+func (p *prefetcher) start(ctx context.Context, in <-chan *restic.Node) <-chan *restic.Node {
+	out := make(chan *restic.Node, 10)
+
+	var wg sync.WaitGroup
+	for i := uint(0); i < p.repo.Connections(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.worker(ctx)
+		}()
+	}
+
+	go func() {
+		p.run(ctx, in, out)
+		close(p.batches)
+		wg.Wait()
+	}()
+
+	return out
+}
+
+func (p *prefetcher) run(ctx context.Context, in <-chan *restic.Node, out chan<- *restic.Node) {
+	defer close(out)
+	defer close(p.blobs)
+
+	for {
+		var node *restic.Node
+		var ok bool
+		select {
+		case node, ok = <-in:
+		default:
+			// make sure the blobs requested so far are loaded while waiting
+			if !p.flush(ctx) {
+				return
+			}
+			select {
+			case node, ok = <-in:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !ok {
+			p.flush(ctx)
+			return
+		}
+
+		if !p.send(ctx, out, node) {
+			return
+		}
+		if !IsFile(node) {
+			continue
+		}
+
+		content := node.Content
+		if node.Bundle != nil && len(content) > 0 {
+			content = content[:1]
+		}
+		for _, id := range content {
+			if !p.request(ctx, id) {
+				return
+			}
+		}
+	}
+}
+
+// send forwards node to out. The current batch is flushed before blocking.
+func (p *prefetcher) send(ctx context.Context, out chan<- *restic.Node, node *restic.Node) bool {
+	select {
+	case out <- node:
+		return true
+	default:
+	}
+
+	if !p.flush(ctx) {
+		return false
+	}
+	select {
+	case out <- node:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// request schedules the blob id for loading and queues it for next.
+func (p *prefetcher) request(ctx context.Context, id restic.ID) bool {
+	f := p.last
+	if f == nil || f.id != id {
+		f = p.schedule(ctx, id)
+		if f == nil {
+			return false
+		}
+		p.last = f
+	}
+
+	select {
+	case p.blobs <- f:
+		return true
+	default:
+	}
+
+	// the consumer may be waiting for a blob of the current batch
+	if !p.flush(ctx) {
+		return false
+	}
+	select {
+	case p.blobs <- f:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// schedule returns a future for the blob id. Blobs are collected in a batch
+// until a blob from a different pack file is requested.
+func (p *prefetcher) schedule(ctx context.Context, id restic.ID) *blobFuture {
+	f := &blobFuture{id: id, done: make(chan struct{})}
+	if data, ok := p.cache.Get(id); ok {
+		f.complete(data, nil)
+		return f
+	}
+
+	pbs := p.repo.Index().Lookup(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+	if len(pbs) > 0 {
+		f.packID, f.blob, f.known = pbs[0].PackID, pbs[0].Blob, true
+	}
+
+	if len(p.batch) > 0 {
+		first := p.batch[0]
+		if !f.known || !first.known || f.packID != first.packID || p.batchSize+f.blob.Length > maxPrefetchBatch {
+			if !p.flush(ctx) {
+				return nil
+			}
+		}
+	}
+
+	p.batch = append(p.batch, f)
+	p.batchSize += f.blob.Length
+	return f
+}
+
+// flush passes the current batch to the workers.
+func (p *prefetcher) flush(ctx context.Context) bool {
+	if len(p.batch) == 0 {
+		return true
+	}
+
+	select {
+	case p.batches <- p.batch:
+	case <-ctx.Done():
+		return false
+	}
+	p.batch = nil
+	p.batchSize = 0
+	return true
+}
+
+func (p *prefetcher) worker(ctx context.Context) {
+	for batch := range p.batches {
+		p.load(ctx, batch)
+	}
+}
+
+// load loads all blobs of batch, which are either stored in the same pack
+// file or consist of a single blob.
+func (p *prefetcher) load(ctx context.Context, batch []*blobFuture) {
+	if !batch[0].known {
+		// let the repository report the missing blob
+		for _, f := range batch {
+			f.complete(p.repo.LoadBlob(ctx, restic.DataBlob, f.id, nil))
+		}
+		return
+	}
+
+	futures := make(map[restic.ID][]*blobFuture, len(batch))
+	var blobs []restic.Blob
+	for _, f := range batch {
+		if _, ok := futures[f.id]; !ok {
+			blobs = append(blobs, f.blob)
+		}
+		futures[f.id] = append(futures[f.id], f)
+	}
+
+	packID := batch[0].packID
+	debug.Log("loading %d blobs from pack %v", len(blobs), packID.Str())
+	err := repository.StreamPack(ctx, p.repo.Backend().Load, p.repo.Key(), p.filters, p.repo.Config().HashBlob, packID, blobs,
+		func(h restic.BlobHandle, buf []byte, err error) error {
+			var data []byte
+			if opener, ok := p.repo.(dataKeyOpener); ok && err == nil {
+				buf, err = opener.OpenDataBlob(ctx, h.ID, buf)
+			}
+			if err == nil {
+				// buf is reused by StreamPack
+				data = append([]byte(nil), buf...)
+				p.cache.Add(h.ID, data)
+			}
+			for _, f := range futures[h.ID] {
+				f.complete(data, err)
+			}
+			delete(futures, h.ID)
+			return nil
+		})
+	if err == nil {
+		err = errors.Errorf("blobs missing from pack %v", packID.Str())
+	}
+
+	for _, pending := range futures {
+		for _, f := range pending {
+			f.complete(nil, err)
+		}
+	}
+}
+
+// next returns the content of the blob id, which must be the next blob
+// requested by the prefetcher.
+func (p *prefetcher) next(ctx context.Context, id restic.ID) ([]byte, error) {
+	var f *blobFuture
+	var ok bool
+	select {
+	case f, ok = <-p.blobs:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if !ok || f.id != id {
+		return nil, errors.Errorf("blob %v was not prefetched", id.Str())
+	}
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return f.data, f.err
+}
@@ -0,0 +1,53 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestWriteNodePrefetch(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+
+	// spread the blobs over several pack files
+	var blobs [][]byte
+	var ids restic.IDs
+	for i := 0; i < 12; i++ {
+		data := rtest.Random(i, 1000+i)
+		id, _, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+		rtest.OK(t, err)
+		blobs = append(blobs, data)
+		ids = append(ids, id)
+		if i%4 == 3 {
+			rtest.OK(t, repo.Flush(context.TODO()))
+			repo.StartPackUploader(context.TODO(), &wg)
+		}
+	}
+
+	// request blobs repeatedly and out of order
+	order := []int{0, 1, 1, 5, 2, 0, 11, 10, 3, 3, 3, 7, 4, 8, 6, 9, 0}
+	node := &restic.Node{Name: "file", Type: "file"}
+	var expected []byte
+	for _, i := range order {
+		node.Content = append(node.Content, ids[i])
+		expected = append(expected, blobs[i]...)
+	}
+	node.Size = uint64(len(expected))
+
+	var buf bytes.Buffer
+	rtest.OK(t, New("tar", repo, &buf).WriteNode(context.TODO(), node))
+	rtest.Equals(t, expected, buf.Bytes())
+
+	// missing blobs are reported
+	node.Content = append(node.Content, restic.NewRandomID())
+	err := New("tar", repo, &buf).WriteNode(context.TODO(), node)
+	rtest.Assert(t, err != nil, "missing error for unknown blob")
+}
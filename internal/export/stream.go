@@ -0,0 +1,324 @@
+// Package export implements a self-contained encrypted stream of snapshots
+// and the blobs they reference, which can be imported into another
+// repository without access to the original one.
+//
+// A stream starts with a magic string followed by a length-prefixed JSON
+// header, which contains a random stream key that is encrypted with a key
+// derived from the stream password. All following records are encrypted and
+// authenticated with the stream key and carry a sequence number, so that
+// missing or reordered records are detected. The stream is terminated by an
+// end record.
+package export
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+const magic = "RSTCEXP1"
+
+// maxRecordSize limits the size of a single record, blobs are much smaller.
+const maxRecordSize = 128 << 20
+
+// RecordType is the type of a record in a stream.
+type RecordType uint8
+
+// These are the record types.
+const (
+	DataBlobRecord RecordType = iota + 1
+	TreeBlobRecord
+	SnapshotRecord
+	endRecord
+)
+
+// header is stored unencrypted at the start of a stream.
+type header struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"N"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Data    []byte `json:"data"`
+
+	// BlobHash is the blob hash algorithm of the exported repository, see
+	// restic.Config.BlobHash.
+	BlobHash string `json:"blob_hash,omitempty"`
+}
+
+// Record is a single blob or snapshot read from a stream.
+type Record struct {
+	Type RecordType
+
+	// ID and Data are set for blob records.
+	ID   restic.ID
+	Data []byte
+
+	// Snapshot is set for snapshot records.
+	Snapshot *restic.Snapshot
+}
+
+// BlobType returns the type of the blob contained in a blob record.
+func (r Record) BlobType() restic.BlobType {
+	if r.Type == TreeBlobRecord {
+		return restic.TreeBlob
+	}
+	return restic.DataBlob
+}
+
+// Writer writes an encrypted stream.
+type Writer struct {
+	w   io.Writer
+	key *crypto.Key
+	seq uint64
+	buf []byte
+}
+
+// NewWriter writes the header of a new stream to w. The stream key is
+// encrypted with a key derived from password using the KDF parameters. The
+// IDs of all blobs written to the stream must be computed with the blob hash
+// algorithm blobHash.
+func NewWriter(w io.Writer, password string, params crypto.Params, blobHash string) (*Writer, error) {
+	salt, err := crypto.NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	user, err := crypto.KDF(params, salt, password)
+	if err != nil {
+		return nil, err
+	}
+
+	key := crypto.NewRandomKey()
+	buf, err := json.Marshal(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+	nonce := crypto.NewRandomNonce()
+	data := user.Seal(append([]byte{}, nonce...), nonce, buf, nil)
+
+	hdr, err := json.Marshal(header{
+		Version: 1,
+		KDF:     "scrypt",
+		N:       params.N,
+		R:       params.R,
+		P:       params.P,
+		Salt:    salt,
+		Data:    data,
+
+		BlobHash: blobHash,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	out := make([]byte, len(magic)+4, len(magic)+4+len(hdr))
+	copy(out, magic)
+	binary.LittleEndian.PutUint32(out[len(magic):], uint32(len(hdr)))
+	out = append(out, hdr...)
+	if _, err := w.Write(out); err != nil {
+		return nil, errors.Wrap(err, "Write")
+	}
+
+	return &Writer{w: w, key: key}, nil
+}
+
+// WriteBlob adds the blob with the given type and ID to the stream.
+func (w *Writer) WriteBlob(t restic.BlobType, id restic.ID, data []byte) error {
+	tpe := DataBlobRecord
+	if t == restic.TreeBlob {
+		tpe = TreeBlobRecord
+	}
+	return w.writeRecord(tpe, id[:], data)
+}
+
+// WriteSnapshot adds the snapshot to the stream. All blobs referenced by the
+// snapshot must be written before.
+func (w *Writer) WriteSnapshot(sn *restic.Snapshot) error {
+	buf, err := json.Marshal(sn)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	return w.writeRecord(SnapshotRecord, buf)
+}
+
+// Close terminates the stream, it does not close the underlying writer.
+func (w *Writer) Close() error {
+	return w.writeRecord(endRecord)
+}
+
+// writeRecord encrypts the record consisting of the sequence number, the type
+// and the payload parts and writes it prefixed with its length.
+func (w *Writer) writeRecord(tpe RecordType, payload ...[]byte) error {
+	var prefix [9]byte
+	binary.LittleEndian.PutUint64(prefix[:], w.seq)
+	prefix[8] = byte(tpe)
+	plain := append(w.buf[:0], prefix[:]...)
+	for _, p := range payload {
+		plain = append(plain, p...)
+	}
+	w.buf = plain
+	w.seq++
+
+	nonce := crypto.NewRandomNonce()
+	out := make([]byte, 4, 4+crypto.CiphertextLength(len(plain)))
+	out = append(out, nonce...)
+	out = w.key.Seal(out, nonce, plain, nil)
+	binary.LittleEndian.PutUint32(out, uint32(len(out)-4))
+
+	_, err := w.w.Write(out)
+	return errors.Wrap(err, "Write")
+}
+
+// Reader reads an encrypted stream.
+type Reader struct {
+	r        io.Reader
+	key      *crypto.Key
+	blobHash string
+	hash     restic.BlobHashFunc
+	seq      uint64
+	end      bool
+	buf      []byte
+}
+
+// NewReader reads the header of the stream from r and decrypts the stream
+// key with password.
+func NewReader(r io.Reader, password string) (*Reader, error) {
+	var start [len(magic) + 4]byte
+	if _, err := io.ReadFull(r, start[:]); err != nil {
+		return nil, errors.Wrap(err, "reading header")
+	}
+	if string(start[:len(magic)]) != magic {
+		return nil, errors.New("not a restic export stream")
+	}
+	length := binary.LittleEndian.Uint32(start[len(magic):])
+	if length > maxRecordSize {
+		return nil, errors.Errorf("invalid header length %d", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, errors.Wrap(err, "reading header")
+	}
+
+	var hdr header
+	if err := json.Unmarshal(buf, &hdr); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	if hdr.Version != 1 {
+		return nil, errors.Errorf("unsupported stream version %d", hdr.Version)
+	}
+	if hdr.KDF != "scrypt" {
+		return nil, errors.New("only supported KDF is scrypt()")
+	}
+	hash, err := restic.LookupBlobHash(hdr.BlobHash)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := crypto.KDF(crypto.Params{N: hdr.N, R: hdr.R, P: hdr.P}, hdr.Salt, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "crypto.KDF")
+	}
+	if len(hdr.Data) < user.NonceSize() {
+		return nil, errors.New("invalid header")
+	}
+	nonce, ciphertext := hdr.Data[:user.NonceSize()], hdr.Data[user.NonceSize():]
+	plain, err := user.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong password or corrupted stream")
+	}
+
+	key := &crypto.Key{}
+	if err := json.Unmarshal(plain, key); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	if !key.Valid() {
+		return nil, errors.New("invalid stream key")
+	}
+
+	return &Reader{r: r, key: key, blobHash: hdr.BlobHash, hash: hash}, nil
+}
+
+// BlobHash returns the blob hash algorithm used for the IDs of the blobs in
+// the stream.
+func (r *Reader) BlobHash() string {
+	return r.blobHash
+}
+
+// Next returns the next record of the stream. The data of blob records is
+// verified against the blob ID and is only valid until the next call. At the
+// end of the stream io.EOF is returned, a truncated stream results in an
+// error.
+func (r *Reader) Next() (Record, error) {
+	if r.end {
+		return Record{}, io.EOF
+	}
+
+	var length [4]byte
+	if _, err := io.ReadFull(r.r, length[:]); err != nil {
+		return Record{}, errors.Wrap(noEOF(err), "reading record")
+	}
+	n := binary.LittleEndian.Uint32(length[:])
+	if n > maxRecordSize || int(n) < r.key.NonceSize()+r.key.Overhead() {
+		return Record{}, errors.Errorf("invalid record length %d", n)
+	}
+
+	if cap(r.buf) < int(n) {
+		r.buf = make([]byte, n)
+	}
+	buf := r.buf[:n]
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return Record{}, errors.Wrap(noEOF(err), "reading record")
+	}
+
+	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
+	plain, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return Record{}, errors.Errorf("record %d is corrupted: %v", r.seq, err)
+	}
+	if len(plain) < 9 {
+		return Record{}, errors.Errorf("record %d is too short", r.seq)
+	}
+	if seq := binary.LittleEndian.Uint64(plain); seq != r.seq {
+		return Record{}, errors.Errorf("unexpected record %d, expected %d", seq, r.seq)
+	}
+	r.seq++
+
+	rec := Record{Type: RecordType(plain[8])}
+	payload := plain[9:]
+	switch rec.Type {
+	case DataBlobRecord, TreeBlobRecord:
+		if len(payload) < len(rec.ID) {
+			return Record{}, errors.Errorf("record %d is too short", r.seq-1)
+		}
+		copy(rec.ID[:], payload)
+		rec.Data = payload[len(rec.ID):]
+		if id := r.hash(rec.Data); id != rec.ID {
+			return Record{}, errors.Errorf("blob %v has wrong hash %v", rec.ID.Str(), id.Str())
+		}
+	case SnapshotRecord:
+		rec.Snapshot = &restic.Snapshot{}
+		if err := json.Unmarshal(payload, rec.Snapshot); err != nil {
+			return Record{}, errors.Wrap(err, "Unmarshal")
+		}
+	case endRecord:
+		r.end = true
+		return Record{}, io.EOF
+	default:
+		return Record{}, errors.Errorf("unknown record type %d", rec.Type)
+	}
+	return rec, nil
+}
+
+// noEOF converts io.EOF into io.ErrUnexpectedEOF, as a stream must be
+// terminated by an end record.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
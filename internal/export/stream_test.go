@@ -0,0 +1,131 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+var testParams = crypto.Params{N: 1024, R: 1, P: 1}
+
+func writeTestStream(t *testing.T, blobs [][]byte) ([]byte, *restic.Snapshot) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, "secret", testParams, "")
+	rtest.OK(t, err)
+
+	for i, data := range blobs {
+		tpe := restic.DataBlob
+		if i%2 == 1 {
+			tpe = restic.TreeBlob
+		}
+		rtest.OK(t, w.WriteBlob(tpe, restic.Hash(data), data))
+	}
+
+	sn, err := restic.NewSnapshot([]string{"/foo"}, []string{"tag"}, "host", time.Unix(1000, 0))
+	rtest.OK(t, err)
+	id := restic.Hash(blobs[1])
+	sn.Tree = &id
+	rtest.OK(t, w.WriteSnapshot(sn))
+	rtest.OK(t, w.Close())
+	return buf.Bytes(), sn
+}
+
+func TestStreamRoundtrip(t *testing.T) {
+	blobs := [][]byte{rtest.Random(1, 1000), rtest.Random(2, 50), {}, rtest.Random(3, 100000)}
+	stream, sn := writeTestStream(t, blobs)
+
+	rtest.Assert(t, !bytes.Contains(stream, blobs[3][:100]), "stream contains plaintext")
+
+	r, err := NewReader(bytes.NewReader(stream), "secret")
+	rtest.OK(t, err)
+	for i, data := range blobs {
+		rec, err := r.Next()
+		rtest.OK(t, err)
+		expected := restic.DataBlob
+		if i%2 == 1 {
+			expected = restic.TreeBlob
+		}
+		rtest.Equals(t, expected, rec.BlobType())
+		rtest.Equals(t, restic.Hash(data), rec.ID)
+		rtest.Assert(t, bytes.Equal(data, rec.Data), "wrong data for blob %d", i)
+	}
+
+	rec, err := r.Next()
+	rtest.OK(t, err)
+	rtest.Equals(t, SnapshotRecord, rec.Type)
+	rtest.Equals(t, sn.Paths, rec.Snapshot.Paths)
+	rtest.Equals(t, *sn.Tree, *rec.Snapshot.Tree)
+	rtest.Assert(t, sn.Time.Equal(rec.Snapshot.Time), "wrong snapshot time %v", rec.Snapshot.Time)
+
+	_, err = r.Next()
+	rtest.Equals(t, io.EOF, err)
+}
+
+func TestStreamWrongPassword(t *testing.T) {
+	stream, _ := writeTestStream(t, [][]byte{rtest.Random(1, 10), rtest.Random(2, 10)})
+	_, err := NewReader(bytes.NewReader(stream), "wrong")
+	rtest.Assert(t, err != nil, "missing error for wrong password")
+}
+
+func readAll(stream []byte) error {
+	r, err := NewReader(bytes.NewReader(stream), "secret")
+	if err != nil {
+		return err
+	}
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestStreamDamaged(t *testing.T) {
+	stream, _ := writeTestStream(t, [][]byte{rtest.Random(1, 1000), rtest.Random(2, 1000)})
+	rtest.OK(t, readAll(stream))
+
+	// truncated streams are detected, even at record boundaries
+	for _, n := range []int{len(stream) - 1, len(stream) - 45, len(stream) / 2} {
+		err := readAll(stream[:n])
+		rtest.Assert(t, err != nil, "missing error for stream truncated to %d bytes", n)
+	}
+
+	damaged := append([]byte{}, stream...)
+	damaged[len(damaged)/2] ^= 0x01
+	rtest.Assert(t, readAll(damaged) != nil, "missing error for damaged stream")
+}
+
+func TestStreamBlobHash(t *testing.T) {
+	hash, err := restic.LookupBlobHash(restic.BlobHashBLAKE3)
+	rtest.OK(t, err)
+	data := rtest.Random(1, 1000)
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, "secret", testParams, restic.BlobHashBLAKE3)
+	rtest.OK(t, err)
+	rtest.OK(t, w.WriteBlob(restic.DataBlob, hash(data), data))
+	rtest.OK(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), "secret")
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.BlobHashBLAKE3, r.BlobHash())
+	rec, err := r.Next()
+	rtest.OK(t, err)
+	rtest.Equals(t, hash(data), rec.ID)
+
+	// blob IDs are verified using the algorithm of the stream
+	buf.Reset()
+	w, err = NewWriter(buf, "secret", testParams, "")
+	rtest.OK(t, err)
+	rtest.OK(t, w.WriteBlob(restic.DataBlob, hash(data), data))
+	rtest.OK(t, w.Close())
+	rtest.Assert(t, readAll(buf.Bytes()) != nil, "blob with wrong hash was accepted")
+}
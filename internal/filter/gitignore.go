@@ -0,0 +1,165 @@
+package filter
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// GitignorePattern represents a preparsed pattern in the syntax of .gitignore
+// files.
+type GitignorePattern struct {
+	original string
+	// parts does not contain a marker for absolute patterns, "**" is stored
+	// as the empty string
+	parts []patternPart
+
+	// anchored patterns match paths relative to the directory of the
+	// patterns, other patterns match at any depth
+	anchored  bool
+	dirOnly   bool
+	isNegated bool
+}
+
+// ParseGitignore parses the lines of a .gitignore file. Empty lines and
+// comments are skipped. If some patterns are malformed, an
+// InvalidPatternError is returned.
+//
+// As for git, a leading "!" negates a pattern, a trailing "/" restricts a
+// pattern to directories and a pattern containing a "/" except at the end
+// only matches relative to the directory containing the patterns. A leading
+// "\" can be used to escape "#" and "!".
+func ParseGitignore(lines []string) ([]GitignorePattern, error) {
+	var patterns []GitignorePattern
+	var invalid []string
+	for _, line := range lines {
+		line = trimGitignoreLine(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := GitignorePattern{original: line}
+		if strings.HasPrefix(line, "!") {
+			p.isNegated = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		p.anchored = strings.Contains(line, "/")
+
+		for _, part := range strings.Split(line, "/") {
+			if part == "" {
+				continue
+			}
+			if _, err := filepath.Match(part, part); err != nil {
+				invalid = append(invalid, p.original)
+				break
+			}
+			isSimple := !strings.ContainsAny(part, "\\[]*?")
+			if part == "**" {
+				part = ""
+			}
+			p.parts = append(p.parts, patternPart{part, isSimple})
+		}
+		if len(p.parts) == 0 {
+			// e.g. "/" or "!", which do not match anything
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+
+	if len(invalid) > 0 {
+		return nil, &InvalidPatternError{InvalidPatterns: invalid}
+	}
+	return patterns, nil
+}
+
+// trimGitignoreLine removes the line ending and trailing spaces which are not
+// escaped by a backslash.
+func trimGitignoreLine(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// MatchGitignore checks whether str is ignored by the patterns. The path str
+// must be relative to the directory containing the patterns and use slashes
+// as separators, isDir reports whether it refers to a directory. As for git,
+// the last matching pattern decides whether str is ignored. If no pattern
+// matches, matched is false.
+func MatchGitignore(patterns []GitignorePattern, str string, isDir bool) (ignored bool, matched bool, err error) {
+	strs := strings.Split(strings.Trim(str, "/"), "/")
+	if len(strs) == 0 || strs[0] == "" || strs[0] == "." {
+		return false, false, ErrBadString
+	}
+
+	for i := len(patterns) - 1; i >= 0; i-- {
+		p := patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		m, err := p.match(strs)
+		if err != nil {
+			return false, false, err
+		}
+		if m {
+			return !p.isNegated, true, nil
+		}
+	}
+	return false, false, nil
+}
+
+func (p GitignorePattern) match(strs []string) (bool, error) {
+	if p.anchored {
+		return matchAllParts(p.parts, strs)
+	}
+
+	// unanchored patterns consist of a single part, which must match the
+	// last path component
+	return matchAllParts(p.parts, strs[len(strs)-1:])
+}
+
+// matchAllParts returns whether parts matches all components of strs. A "**"
+// matches an arbitrary number of components, at its end at least one.
+func matchAllParts(parts []patternPart, strs []string) (bool, error) {
+	if len(parts) == 0 {
+		return len(strs) == 0, nil
+	}
+
+	if parts[0].pattern == "" {
+		minSkip := 0
+		if len(parts) == 1 {
+			minSkip = 1
+		}
+		for skip := minSkip; skip <= len(strs); skip++ {
+			ok, err := matchAllParts(parts[1:], strs[skip:])
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	if len(strs) == 0 {
+		return false, nil
+	}
+
+	var ok bool
+	if parts[0].isSimple {
+		ok = parts[0].pattern == strs[0]
+	} else {
+		var err error
+		ok, err = filepath.Match(parts[0].pattern, strs[0])
+		if err != nil {
+			return false, err
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+	return matchAllParts(parts[1:], strs[1:])
+}
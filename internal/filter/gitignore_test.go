@@ -0,0 +1,71 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/filter"
+)
+
+var gitignoreTests = []struct {
+	patterns []string
+	path     string
+	isDir    bool
+	ignored  bool
+	matched  bool
+}{
+	{[]string{"*.o"}, "main.o", false, true, true},
+	{[]string{"*.o"}, "src/main.o", false, true, true},
+	{[]string{"*.o"}, "main.c", false, false, false},
+	{[]string{"# comment"}, "# comment", false, false, false},
+	{[]string{`\#file`}, "#file", false, true, true},
+	{[]string{`\!file`}, "!file", false, true, true},
+	{[]string{"file  "}, "file", false, true, true},
+	{[]string{`file\ `}, "file ", false, true, true},
+	{[]string{"build/"}, "build", true, true, true},
+	{[]string{"build/"}, "build", false, false, false},
+	{[]string{"build/"}, "src/build", true, true, true},
+	{[]string{"/build"}, "build", false, true, true},
+	{[]string{"/build"}, "src/build", false, false, false},
+	{[]string{"doc/*.txt"}, "doc/notes.txt", false, true, true},
+	{[]string{"doc/*.txt"}, "doc/api/notes.txt", false, false, false},
+	{[]string{"doc/*.txt"}, "src/doc/notes.txt", false, false, false},
+	{[]string{"**/foo"}, "foo", false, true, true},
+	{[]string{"**/foo"}, "a/b/foo", false, true, true},
+	{[]string{"a/**/b"}, "a/b", false, true, true},
+	{[]string{"a/**/b"}, "a/x/y/b", false, true, true},
+	{[]string{"abc/**"}, "abc", true, false, false},
+	{[]string{"abc/**"}, "abc/x/y", false, true, true},
+	{[]string{"*.log", "!important.log"}, "debug.log", false, true, true},
+	{[]string{"*.log", "!important.log"}, "important.log", false, false, true},
+	{[]string{"!important.log", "*.log"}, "important.log", false, true, true},
+	{[]string{"*", "!*/", "!*.c"}, "src", true, false, true},
+	{[]string{"*", "!*/", "!*.c"}, "src/main.c", false, false, true},
+	{[]string{"*", "!*/", "!*.c"}, "src/main.h", false, true, true},
+}
+
+func TestMatchGitignore(t *testing.T) {
+	for _, test := range gitignoreTests {
+		t.Run("", func(t *testing.T) {
+			patterns, err := filter.ParseGitignore(test.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ignored, matched, err := filter.MatchGitignore(patterns, test.path, test.isDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ignored != test.ignored || matched != test.matched {
+				t.Errorf("patterns %q, path %q (dir %v): want ignored %v, matched %v, got %v, %v",
+					test.patterns, test.path, test.isDir, test.ignored, test.matched, ignored, matched)
+			}
+		})
+	}
+}
+
+func TestParseGitignoreInvalid(t *testing.T) {
+	_, err := filter.ParseGitignore([]string{"*.o", "test/["})
+	if err == nil {
+		t.Error("ParseGitignore accepted invalid pattern")
+	}
+}
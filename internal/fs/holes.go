@@ -0,0 +1,25 @@
+package fs
+
+import "os"
+
+// Hole is a range of a sparse file which is not backed by any data on disk.
+// Reading a hole returns zero bytes.
+type Hole struct {
+	Offset int64
+	Length int64
+}
+
+// FindHoles returns the holes within the first size bytes of f. Only files of
+// the local filesystem can contain holes, for other files nil is returned.
+// The file offset is reset to the start of the file afterwards, unless an
+// error is returned.
+func FindHoles(f File, size int64) ([]Hole, error) {
+	if tf, ok := f.(*trackFile); ok {
+		f = tf.File
+	}
+	osFile, ok := f.(*os.File)
+	if !ok {
+		return nil, nil
+	}
+	return findHoles(osFile, size)
+}
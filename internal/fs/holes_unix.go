@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package fs
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// findHoles asks the filesystem for the holes of f via SEEK_DATA and
+// SEEK_HOLE. Filesystems without support for sparse files report no holes.
+func findHoles(f *os.File, size int64) ([]Hole, error) {
+	var holes []Hole
+	fd := int(f.Fd())
+	offset := int64(0)
+	for offset < size {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if err == unix.ENXIO {
+			// no more data until the end of the file
+			data = size
+		} else if err != nil {
+			return nil, err
+		}
+		if data > size {
+			data = size
+		}
+		if data > offset {
+			holes = append(holes, Hole{Offset: offset, Length: data - offset})
+		}
+		if data >= size {
+			break
+		}
+
+		offset, err = unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return holes, nil
+}
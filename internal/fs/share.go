@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Share describes a network file share (SMB or NFS) which is mounted
+// temporarily so that it can be used as a backup source or restore target.
+type Share struct {
+	Scheme string // "smb" or "nfs"
+	Host   string
+	// Export is the name of the SMB share or the exported path of the NFS server.
+	Export string
+	// Path is the directory within the share to use.
+	Path string
+
+	User     string
+	Password options.SecretString
+	// Options are passed verbatim to the mount command.
+	Options string
+}
+
+// ParseShare parses a share location of the form
+// smb://[user@]host/share[/path] or nfs://host/export/path.
+func ParseShare(s string) (Share, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return Share{}, errors.Wrap(err, "url.Parse")
+	}
+
+	share := Share{Scheme: u.Scheme, Host: u.Host}
+	if share.Host == "" {
+		return Share{}, errors.Errorf("share location %q has no host", s)
+	}
+
+	p := path.Clean("/" + u.Path)
+
+	switch u.Scheme {
+	case "smb", "cifs":
+		share.Scheme = "smb"
+		data := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+		if data[0] == "" {
+			return Share{}, errors.Errorf("share location %q has no share name", s)
+		}
+		share.Export = data[0]
+		share.Path = "/"
+		if len(data) > 1 {
+			share.Path = "/" + data[1]
+		}
+		if u.User != nil {
+			share.User = u.User.Username()
+			if pw, ok := u.User.Password(); ok {
+				share.Password = options.NewSecretString(pw)
+			}
+		}
+	case "nfs":
+		if u.User != nil {
+			return Share{}, errors.New("nfs shares do not support user credentials")
+		}
+		// the complete path is exported, it is not possible to determine
+		// the export root from the location
+		share.Export = p
+		share.Path = "/"
+	default:
+		return Share{}, errors.Errorf("unsupported share type %q, only smb and nfs are supported", u.Scheme)
+	}
+
+	return share, nil
+}
+
+// String returns the share location without the password.
+func (s Share) String() string {
+	u := url.URL{Scheme: s.Scheme, Host: s.Host, Path: path.Join("/", s.Export, s.Path)}
+	if s.User != "" {
+		u.User = url.User(s.User)
+	}
+	return u.String()
+}
+
+// DefaultMountpoint returns the directory below the temporary directory the
+// share is mounted at. It only depends on the share location, so that the
+// paths recorded in snapshots are the same for each backup of the share.
+func (s Share) DefaultMountpoint() string {
+	h := sha256.Sum256([]byte(s.String()))
+	return filepath.Join(os.TempDir(), "restic-share-"+hex.EncodeToString(h[:8]))
+}
+
+// MountShare mounts the share at mountpoint, creating the directory if
+// necessary. The share is mounted read-only unless writable is set. The
+// returned function unmounts the share again.
+func MountShare(s Share, mountpoint string, writable bool) (unmount func() error, err error) {
+	err = os.MkdirAll(mountpoint, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "MkdirAll")
+	}
+
+	err = mountShare(s, mountpoint, writable)
+	if err != nil {
+		_ = os.Remove(mountpoint)
+		return nil, err
+	}
+
+	return func() error {
+		err := unmountShare(mountpoint)
+		if err != nil {
+			return err
+		}
+		return os.Remove(mountpoint)
+	}, nil
+}
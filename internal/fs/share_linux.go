@@ -0,0 +1,51 @@
+package fs
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+func mountShare(s Share, mountpoint string, writable bool) error {
+	opts := "ro"
+	if writable {
+		opts = "rw"
+	}
+	if s.Options != "" {
+		opts += "," + s.Options
+	}
+
+	var cmd *exec.Cmd
+	switch s.Scheme {
+	case "smb":
+		cmd = exec.Command("mount", "-t", "cifs", "//"+s.Host+"/"+s.Export, mountpoint, "-o", opts)
+		// mount.cifs reads the credentials from the environment, this
+		// prevents the password from showing up in the process list
+		cmd.Env = os.Environ()
+		if s.User != "" {
+			cmd.Env = append(cmd.Env, "USER="+s.User)
+		}
+		if s.Password.Unwrap() != "" {
+			cmd.Env = append(cmd.Env, "PASSWD="+s.Password.Unwrap())
+		}
+	case "nfs":
+		cmd = exec.Command("mount", "-t", "nfs", s.Host+":"+s.Export, mountpoint, "-o", opts)
+	default:
+		return errors.Errorf("unsupported share type %q", s.Scheme)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Errorf("unable to mount %v: %v: %s", s, err, out)
+	}
+	return nil
+}
+
+func unmountShare(mountpoint string) error {
+	out, err := exec.Command("umount", mountpoint).CombinedOutput()
+	if err != nil {
+		return errors.Errorf("unable to unmount %v: %v: %s", mountpoint, err, out)
+	}
+	return nil
+}
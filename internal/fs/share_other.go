@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+func mountShare(s Share, mountpoint string, writable bool) error {
+	return errors.New("mounting network shares is only supported on Linux")
+}
+
+func unmountShare(mountpoint string) error {
+	return errors.New("mounting network shares is only supported on Linux")
+}
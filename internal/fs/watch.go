@@ -0,0 +1,123 @@
+package fs
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// Watcher collects the paths of files and directories which changed below a
+// set of watched paths.
+type Watcher struct {
+	m       sync.Mutex
+	changes *Changes
+	notify  chan struct{}
+
+	// Error is called for paths which cannot be watched.
+	Error func(item string, err error)
+
+	// state of the platform specific implementation
+	impl watcherImpl
+}
+
+// NewWatcher starts watching the paths and all directories below them for
+// changes. Errors for individual paths are reported to errFn.
+func NewWatcher(paths []string, errFn func(item string, err error)) (*Watcher, error) {
+	w := &Watcher{
+		changes: newChanges(),
+		notify:  make(chan struct{}, 1),
+		Error:   errFn,
+	}
+	if err := w.start(paths); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Notify returns a channel which receives a value once changes are available.
+func (w *Watcher) Notify() <-chan struct{} {
+	return w.notify
+}
+
+// Take returns the changes since the last call and starts collecting new
+// changes.
+func (w *Watcher) Take() *Changes {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	changes := w.changes
+	w.changes = newChanges()
+	return changes
+}
+
+// changed records that the item at path changed.
+func (w *Watcher) changed(path string) {
+	w.m.Lock()
+	w.changes.add(path)
+	w.m.Unlock()
+	w.signal()
+}
+
+// overflow records that changes were lost, for example as the queue of the
+// operating system overflowed.
+func (w *Watcher) overflow() {
+	w.m.Lock()
+	w.changes.all = true
+	w.m.Unlock()
+	w.signal()
+}
+
+func (w *Watcher) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Changes is the set of changed paths reported by a Watcher.
+type Changes struct {
+	// all is set if any path may have changed
+	all   bool
+	paths map[string]struct{}
+}
+
+func newChanges() *Changes {
+	return &Changes{paths: make(map[string]struct{})}
+}
+
+// add marks path and all directories containing it as changed.
+func (c *Changes) add(path string) {
+	for {
+		if _, ok := c.paths[path]; ok {
+			return
+		}
+		c.paths[path] = struct{}{}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return
+		}
+		path = parent
+	}
+}
+
+// Empty returns whether no changes were collected.
+func (c *Changes) Empty() bool {
+	return !c.all && len(c.paths) == 0
+}
+
+// Merge adds all changes from other to c.
+func (c *Changes) Merge(other *Changes) {
+	c.all = c.all || other.all
+	for path := range other.paths {
+		c.paths[path] = struct{}{}
+	}
+}
+
+// Unchanged returns whether neither the item at path nor anything below it
+// changed.
+func (c *Changes) Unchanged(path string) bool {
+	if c.all {
+		return false
+	}
+	_, ok := c.paths[filepath.Clean(path)]
+	return !ok
+}
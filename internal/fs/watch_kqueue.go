@@ -0,0 +1,244 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/sys/unix"
+)
+
+const kqueueFflags = unix.NOTE_WRITE | unix.NOTE_EXTEND | unix.NOTE_ATTRIB |
+	unix.NOTE_LINK | unix.NOTE_DELETE | unix.NOTE_RENAME | unix.NOTE_REVOKE
+
+// watcherImpl watches paths using kqueue. As kqueue only reports that the
+// entries of a directory changed, but not which ones, each file is watched
+// using a file descriptor of its own.
+type watcherImpl struct {
+	kq int
+	// writing to the pipe stops readEvents
+	wakeR, wakeW int
+	done         chan struct{}
+
+	m     sync.Mutex
+	fds   map[int]kqueueWatch
+	paths map[string]int
+}
+
+type kqueueWatch struct {
+	path string
+	dir  bool
+}
+
+func (w *Watcher) start(paths []string) error {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return errors.Wrap(err, "Kqueue")
+	}
+	unix.CloseOnExec(kq)
+
+	var wake [2]int
+	if err := unix.Pipe(wake[:]); err != nil {
+		_ = unix.Close(kq)
+		return errors.Wrap(err, "Pipe")
+	}
+	unix.CloseOnExec(wake[0])
+	unix.CloseOnExec(wake[1])
+
+	w.impl = watcherImpl{
+		kq:    kq,
+		wakeR: wake[0],
+		wakeW: wake[1],
+		done:  make(chan struct{}),
+		fds:   make(map[int]kqueueWatch),
+		paths: make(map[string]int),
+	}
+
+	var ev unix.Kevent_t
+	unix.SetKevent(&ev, w.impl.wakeR, unix.EVFILT_READ, unix.EV_ADD)
+	if _, err := unix.Kevent(kq, []unix.Kevent_t{ev}, nil, nil); err != nil {
+		w.closeAll()
+		return errors.Wrap(err, "Kevent")
+	}
+
+	for _, path := range paths {
+		w.addRecursive(path, false)
+	}
+
+	go w.readEvents()
+	return nil
+}
+
+// Close stops watching all paths.
+func (w *Watcher) Close() error {
+	_, err := unix.Write(w.impl.wakeW, []byte{0})
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	<-w.impl.done
+	w.closeAll()
+	return nil
+}
+
+func (w *Watcher) closeAll() {
+	w.impl.m.Lock()
+	for fd := range w.impl.fds {
+		_ = unix.Close(fd)
+	}
+	w.impl.fds = nil
+	w.impl.paths = nil
+	w.impl.m.Unlock()
+
+	_ = unix.Close(w.impl.wakeR)
+	_ = unix.Close(w.impl.wakeW)
+	_ = unix.Close(w.impl.kq)
+}
+
+// addRecursive watches path and all files and directories below it. If
+// markChanged is set, all items found are recorded as changed, as they may
+// have been created before the watch for their directory was added.
+func (w *Watcher) addRecursive(path string, markChanged bool) {
+	err := filepath.Walk(path, func(item string, fi os.FileInfo, err error) error {
+		if markChanged && errors.Is(err, os.ErrNotExist) {
+			// removed again before the watch was added
+			return nil
+		}
+		if err != nil {
+			w.Error(item, err)
+			return nil
+		}
+		if markChanged {
+			w.changed(item)
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			// other items are watched through their directory
+			return nil
+		}
+
+		w.impl.m.Lock()
+		_, ok := w.impl.paths[item]
+		w.impl.m.Unlock()
+		if ok {
+			return nil
+		}
+
+		fd, err := unix.Open(item, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NONBLOCK|unix.O_NOFOLLOW, 0)
+		if markChanged && errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		if err != nil {
+			w.Error(item, errors.Wrap(err, "Open"))
+			return nil
+		}
+
+		var ev unix.Kevent_t
+		unix.SetKevent(&ev, fd, unix.EVFILT_VNODE, unix.EV_ADD|unix.EV_CLEAR)
+		ev.Fflags = kqueueFflags
+		if _, err := unix.Kevent(w.impl.kq, []unix.Kevent_t{ev}, nil, nil); err != nil {
+			_ = unix.Close(fd)
+			w.Error(item, errors.Wrap(err, "Kevent"))
+			return nil
+		}
+
+		w.impl.m.Lock()
+		w.impl.fds[fd] = kqueueWatch{path: item, dir: fi.IsDir()}
+		w.impl.paths[item] = fd
+		w.impl.m.Unlock()
+		return nil
+	})
+	if err != nil {
+		w.Error(path, err)
+	}
+}
+
+// remove stops watching the item with the file descriptor fd.
+func (w *Watcher) remove(fd int) {
+	w.impl.m.Lock()
+	watch, ok := w.impl.fds[fd]
+	if ok {
+		delete(w.impl.fds, fd)
+		if w.impl.paths[watch.path] == fd {
+			delete(w.impl.paths, watch.path)
+		}
+	}
+	w.impl.m.Unlock()
+	if ok {
+		// closing the descriptor also removes its events from the kqueue
+		_ = unix.Close(fd)
+	}
+}
+
+func (w *Watcher) readEvents() {
+	defer close(w.impl.done)
+
+	events := make([]unix.Kevent_t, 64)
+	for {
+		n, err := unix.Kevent(w.impl.kq, nil, events, nil)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			w.Error("kqueue", err)
+			w.overflow()
+			return
+		}
+
+		for _, ev := range events[:n] {
+			fd := int(ev.Ident)
+			if fd == w.impl.wakeR {
+				return
+			}
+			w.handleEvent(fd, uint32(ev.Fflags))
+		}
+	}
+}
+
+func (w *Watcher) handleEvent(fd int, fflags uint32) {
+	w.impl.m.Lock()
+	watch, ok := w.impl.fds[fd]
+	w.impl.m.Unlock()
+	if !ok {
+		return
+	}
+	w.changed(watch.path)
+
+	if fflags&(unix.NOTE_DELETE|unix.NOTE_RENAME|unix.NOTE_REVOKE) != 0 {
+		// the item is watched again if it appears in a watched directory
+		w.remove(fd)
+		return
+	}
+
+	if watch.dir && fflags&unix.NOTE_WRITE != 0 {
+		w.addEntries(watch.path)
+	}
+}
+
+// addEntries watches the entries of the directory path which are not watched
+// yet, as they were created or moved into it.
+func (w *Watcher) addEntries(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		// the directory is removed, which is reported separately
+		return
+	}
+	names, err := f.Readdirnames(-1)
+	_ = f.Close()
+	if err != nil {
+		w.Error(path, err)
+		return
+	}
+
+	for _, name := range names {
+		item := filepath.Join(path, name)
+		w.impl.m.Lock()
+		_, ok := w.impl.paths[item]
+		w.impl.m.Unlock()
+		if !ok {
+			w.addRecursive(item, true)
+		}
+	}
+}
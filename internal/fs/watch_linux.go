@@ -0,0 +1,159 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CLOSE_WRITE |
+	unix.IN_CREATE | unix.IN_DELETE | unix.IN_DELETE_SELF |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF
+
+// watcherImpl watches paths using inotify.
+type watcherImpl struct {
+	file *os.File
+	fd   int
+	done chan struct{}
+
+	m     sync.Mutex
+	paths map[int]string
+}
+
+func (w *Watcher) start(paths []string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return errors.Wrap(err, "InotifyInit1")
+	}
+
+	w.impl = watcherImpl{
+		// reading from an os.File for a non-blocking fd uses the runtime
+		// poller, so Close interrupts a pending read
+		file:  os.NewFile(uintptr(fd), "inotify"),
+		fd:    fd,
+		done:  make(chan struct{}),
+		paths: make(map[int]string),
+	}
+
+	for _, path := range paths {
+		w.addRecursive(path, false)
+	}
+
+	go w.readEvents()
+	return nil
+}
+
+// Close stops watching all paths.
+func (w *Watcher) Close() error {
+	err := w.impl.file.Close()
+	<-w.impl.done
+	return err
+}
+
+// addRecursive watches path and all directories below it. If markChanged is
+// set, all items found are recorded as changed, as they may have been created
+// before the watch for their directory was added.
+func (w *Watcher) addRecursive(path string, markChanged bool) {
+	err := filepath.Walk(path, func(item string, fi os.FileInfo, err error) error {
+		if markChanged && errors.Is(err, os.ErrNotExist) {
+			// removed again before the watch was added
+			return nil
+		}
+		if err != nil {
+			w.Error(item, err)
+			return nil
+		}
+		if markChanged {
+			w.changed(item)
+		}
+		if !fi.IsDir() && item != path {
+			// files are watched through their directory
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(w.impl.fd, item, inotifyMask|unix.IN_DONT_FOLLOW)
+		if markChanged && errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		if err != nil {
+			w.Error(item, errors.Wrap(err, "InotifyAddWatch"))
+			return nil
+		}
+		w.impl.m.Lock()
+		w.impl.paths[wd] = item
+		w.impl.m.Unlock()
+		return nil
+	})
+	if err != nil {
+		w.Error(path, err)
+	}
+}
+
+func (w *Watcher) readEvents() {
+	defer close(w.impl.done)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.PathMax))
+	for {
+		n, err := w.impl.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.Error("inotify", err)
+				w.overflow()
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(ev.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(trimNull(buf[nameStart:nameEnd]))
+			offset = nameEnd
+
+			w.handleEvent(int(ev.Wd), ev.Mask, name)
+		}
+	}
+}
+
+func (w *Watcher) handleEvent(wd int, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.overflow()
+		return
+	}
+
+	w.impl.m.Lock()
+	dir, ok := w.impl.paths[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.impl.paths, wd)
+	}
+	w.impl.m.Unlock()
+	if !ok || mask&unix.IN_IGNORED != 0 {
+		return
+	}
+
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+	w.changed(path)
+
+	if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 && mask&unix.IN_ISDIR != 0 {
+		w.addRecursive(path, true)
+	}
+}
+
+func trimNull(buf []byte) []byte {
+	for i, b := range buf {
+		if b == 0 {
+			return buf[:i]
+		}
+	}
+	return buf
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!windows,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+type watcherImpl struct{}
+
+func (w *Watcher) start(paths []string) error {
+	return errors.New("watching for changes is not supported on this platform")
+}
+
+// Close stops watching all paths.
+func (w *Watcher) Close() error {
+	return nil
+}
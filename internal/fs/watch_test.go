@@ -0,0 +1,63 @@
+//go:build linux || windows || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux windows darwin dragonfly freebsd netbsd openbsd
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// waitChanges waits until the watcher reports that path changed.
+func waitChanges(t *testing.T, w *Watcher, changes *Changes, path string) {
+	deadline := time.After(10 * time.Second)
+	for changes.Unchanged(path) {
+		select {
+		case <-w.Notify():
+			changes.Merge(w.Take())
+		case <-deadline:
+			t.Fatalf("timeout waiting for change of %v", path)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	for _, dir := range []string{"a/sub", "b"} {
+		rtest.OK(t, os.MkdirAll(filepath.Join(tempdir, dir), 0755))
+	}
+
+	w, err := NewWatcher([]string{tempdir}, func(item string, err error) {
+		t.Errorf("error for %v: %v", item, err)
+	})
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, w.Close())
+	}()
+
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "a", "sub", "file"), []byte("foo"), 0644))
+	changes := newChanges()
+	waitChanges(t, w, changes, filepath.Join(tempdir, "a", "sub", "file"))
+	for _, path := range []string{tempdir, filepath.Join(tempdir, "a"), filepath.Join(tempdir, "a", "sub")} {
+		rtest.Assert(t, !changes.Unchanged(path), "parent directory %v not marked as changed", path)
+	}
+	rtest.Assert(t, changes.Unchanged(filepath.Join(tempdir, "b")), "unmodified directory marked as changed")
+
+	// directories created after starting the watcher are also watched
+	newDir := filepath.Join(tempdir, "b", "new")
+	rtest.OK(t, os.Mkdir(newDir, 0755))
+	changes = newChanges()
+	waitChanges(t, w, changes, newDir)
+
+	// wait until the watch for the new directory was added
+	time.Sleep(100 * time.Millisecond)
+	changes.Merge(w.Take())
+	rtest.OK(t, os.WriteFile(filepath.Join(newDir, "file"), []byte("bar"), 0644))
+	changes = newChanges()
+	waitChanges(t, w, changes, filepath.Join(newDir, "file"))
+	rtest.Assert(t, changes.Unchanged(filepath.Join(tempdir, "a")), "unmodified directory marked as changed")
+}
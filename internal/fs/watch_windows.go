@@ -0,0 +1,189 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/sys/windows"
+)
+
+const notifyFilter = windows.FILE_NOTIFY_CHANGE_FILE_NAME | windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+	windows.FILE_NOTIFY_CHANGE_ATTRIBUTES | windows.FILE_NOTIFY_CHANGE_SIZE |
+	windows.FILE_NOTIFY_CHANGE_LAST_WRITE | windows.FILE_NOTIFY_CHANGE_CREATION |
+	windows.FILE_NOTIFY_CHANGE_SECURITY
+
+// watcherImpl watches paths using ReadDirectoryChangesW.
+type watcherImpl struct {
+	// stop is signaled by Close
+	stop windows.Handle
+	wg   sync.WaitGroup
+}
+
+// dirWatch watches the directory dir, including all directories below it if
+// name is empty. Otherwise only changes of the file name in dir are reported.
+type dirWatch struct {
+	handle windows.Handle
+	event  windows.Handle
+	dir    string
+	name   string
+}
+
+func (w *Watcher) start(paths []string) error {
+	stop, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return errors.Wrap(err, "CreateEvent")
+	}
+	w.impl.stop = stop
+
+	for _, path := range paths {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			w.Error(path, err)
+			continue
+		}
+
+		dw := &dirWatch{dir: path}
+		if !fi.IsDir() {
+			// files are watched through their directory
+			dw.dir, dw.name = filepath.Dir(path), filepath.Base(path)
+		}
+		if err := dw.open(); err != nil {
+			w.Error(path, err)
+			continue
+		}
+
+		w.impl.wg.Add(1)
+		go w.readEvents(dw)
+	}
+	return nil
+}
+
+func (dw *dirWatch) open() error {
+	p, err := windows.UTF16PtrFromString(fixpath(dw.dir))
+	if err != nil {
+		return err
+	}
+	dw.handle, err = windows.CreateFile(p, windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return errors.Wrap(err, "CreateFile")
+	}
+	dw.event, err = windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		_ = windows.CloseHandle(dw.handle)
+		return errors.Wrap(err, "CreateEvent")
+	}
+	return nil
+}
+
+func (dw *dirWatch) close() {
+	_ = windows.CloseHandle(dw.event)
+	_ = windows.CloseHandle(dw.handle)
+}
+
+// Close stops watching all paths.
+func (w *Watcher) Close() error {
+	err := windows.SetEvent(w.impl.stop)
+	if err != nil {
+		return errors.Wrap(err, "SetEvent")
+	}
+	w.impl.wg.Wait()
+	return windows.CloseHandle(w.impl.stop)
+}
+
+func (w *Watcher) readEvents(dw *dirWatch) {
+	defer w.impl.wg.Done()
+	defer dw.close()
+
+	// the buffer must be DWORD aligned
+	buf := make([]uint32, 16*1024)
+	for {
+		ov := windows.Overlapped{HEvent: dw.event}
+		err := windows.ReadDirectoryChanges(dw.handle, (*byte)(unsafe.Pointer(&buf[0])), uint32(len(buf)*4),
+			dw.name == "", notifyFilter, nil, &ov, 0)
+		if err != nil && !errors.Is(err, windows.ERROR_IO_PENDING) {
+			w.Error(dw.dir, errors.Wrap(err, "ReadDirectoryChanges"))
+			w.overflow()
+			return
+		}
+
+		event, err := windows.WaitForMultipleObjects([]windows.Handle{dw.event, w.impl.stop}, false, windows.INFINITE)
+		stopped := err != nil || event != windows.WAIT_OBJECT_0
+		if stopped {
+			// wait until the read was aborted, as it still uses buf
+			_ = windows.CancelIoEx(dw.handle, &ov)
+		}
+
+		var n uint32
+		err = windows.GetOverlappedResult(dw.handle, &ov, &n, true)
+		if stopped {
+			return
+		}
+		if err != nil {
+			w.Error(dw.dir, errors.Wrap(err, "ReadDirectoryChanges"))
+			w.overflow()
+			return
+		}
+		if n == 0 {
+			// the buffer was too small to hold all changes
+			w.overflow()
+			continue
+		}
+
+		w.handleEvents(dw, unsafe.Slice((*byte)(unsafe.Pointer(&buf[0])), n))
+	}
+}
+
+func (w *Watcher) handleEvents(dw *dirWatch, buf []byte) {
+	nameOffset := int(unsafe.Offsetof(windows.FileNotifyInformation{}.FileName))
+	for offset := 0; offset+nameOffset <= len(buf); {
+		info := (*windows.FileNotifyInformation)(unsafe.Pointer(&buf[offset]))
+		if offset+nameOffset+int(info.FileNameLength) > len(buf) {
+			return
+		}
+		name := windows.UTF16ToString(unsafe.Slice(&info.FileName, info.FileNameLength/2))
+
+		if dw.name == "" || strings.EqualFold(name, dw.name) {
+			path := filepath.Join(dw.dir, name)
+			w.changed(path)
+			if info.Action == windows.FILE_ACTION_ADDED || info.Action == windows.FILE_ACTION_RENAMED_NEW_NAME {
+				w.addedRecursive(path)
+			}
+		}
+
+		if info.NextEntryOffset == 0 {
+			return
+		}
+		offset += int(info.NextEntryOffset)
+	}
+}
+
+// addedRecursive records all items below a directory which was created or
+// moved into a watched directory as changed. For a moved directory, only the
+// directory itself is reported by ReadDirectoryChangesW.
+func (w *Watcher) addedRecursive(path string) {
+	fi, err := os.Lstat(path)
+	if err != nil || !fi.IsDir() {
+		return
+	}
+	err = filepath.Walk(path, func(item string, fi os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			// removed again in the meantime
+			return nil
+		}
+		if err != nil {
+			w.Error(item, err)
+			return nil
+		}
+		w.changed(item)
+		return nil
+	})
+	if err != nil {
+		w.Error(path, err)
+	}
+}
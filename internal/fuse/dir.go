@@ -230,6 +230,15 @@ func (d *dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *f
 
 func (d *dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
 	debug.Log("Getxattr(%v, %v, %v)", d.node.Name, req.Name, req.Size)
+	switch {
+	case req.Name == restic.MountRepositoryXattr:
+		resp.Xattr = []byte(d.root.repo.Config().ID)
+		return nil
+	case req.Name == restic.MountTreeXattr && d.node.Subtree != nil:
+		resp.Xattr = []byte(d.node.Subtree.String())
+		return nil
+	}
+
 	attrval := d.node.GetExtendedAttribute(req.Name)
 	if attrval != nil {
 		resp.Xattr = attrval
@@ -69,6 +69,19 @@ func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
 func (f *file) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
 	debug.Log("open file %v with %d blobs", f.node.Name, len(f.node.Content))
 
+	if f.node.ContentEncoding != nil {
+		// the original contents can only be reconstructed sequentially
+		debug.Log("file %v uses content encoding %v", f.node.Name, f.node.ContentEncoding.Format)
+		return nil, fuse.ENOTSUP
+	}
+
+	if f.node.Bundle != nil {
+		if len(f.node.Content) != 1 {
+			return nil, errors.Errorf("bundled file %v must reference exactly one blob", f.node.Name)
+		}
+		return &openFile{file: *f}, nil
+	}
+
 	var bytes uint64
 	cumsize := make([]uint64, 1+len(f.node.Content))
 	for i, id := range f.node.Content {
@@ -126,6 +139,10 @@ func (f *openFile) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.R
 		return nil
 	}
 
+	if f.node.Bundle != nil {
+		return f.readBundled(ctx, req, resp)
+	}
+
 	// Skip blobs before the offset
 	startContent := -1 + sort.Search(len(f.cumsize), func(i int) bool {
 		return f.cumsize[i] > offset
@@ -166,6 +183,28 @@ func (f *openFile) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.R
 	return nil
 }
 
+// readBundled reads the part of the bundle which belongs to the file.
+func (f *openFile) readBundled(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	blob, err := f.getBlobAt(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	start := f.node.Bundle.Offset + uint64(req.Offset)
+	end := f.node.Bundle.Offset + f.node.Size
+	if end > uint64(len(blob)) {
+		return errors.Errorf("bundle of %v is too short", f.node.Name)
+	}
+	if start >= end {
+		resp.Data = resp.Data[:0]
+		return nil
+	}
+
+	n := copy(resp.Data[:req.Size], blob[start:end])
+	resp.Data = resp.Data[:n]
+	return nil
+}
+
 func (f *file) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
 	debug.Log("Listxattr(%v, %v)", f.node.Name, req.Size)
 	for _, attr := range f.node.ExtendedAttributes {
@@ -0,0 +1,444 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The final index all index files are merged into holds by far the most
+// entries. To reduce its memory usage, these entries are moved from the
+// indexMaps into a compactIndex once enough of them have accumulated.
+//
+// A compactIndex stores the entries of each blob type sorted by ID in blocks
+// of compactBlockSize entries. Only the first ID of a block is stored
+// completely, in the list of blocks used for the binary search. All further
+// IDs are delta-encoded as the number of leading bytes shared with the
+// previous ID followed by the remaining bytes. In a repository with N blobs,
+// neighbouring IDs share about log256(N) bytes. The pack index, offset and
+// lengths follow the IDs and are bit-packed, using the number of bits
+// required for the largest value within the block. For data blobs, these
+// fields take about 11 bytes.
+//
+// Including the block list, an entry therefore takes about 43 bytes compared
+// to the 64 bytes of an indexEntry plus 2 to 4 bytes of bucket pointers. As
+// the encoded data contains no pointers, it does not need to be scanned by
+// the garbage collector.
+//
+// The encoded blocks are stored in segments of at most compactSegmentSize
+// bytes. This allows releasing the memory of the old table segment by segment
+// while entries are moved into a new one.
+const (
+	compactBlockSize   = 16
+	compactSegmentSize = 256 * 1024
+)
+
+// maxFanoutBits limits the size of the fanout table of a compactTable. The
+// table is at most as large as the list of blocks.
+const maxFanoutBits = 24
+
+// compactRatio controls when the entries of the indexMaps are moved into the
+// compactIndex. As this requires rewriting the compactIndex, the indexMaps must
+// hold at least 1/compactRatio of the entries of the compactIndex, such that
+// the costs are amortized.
+const compactRatio = 8
+
+type compactBlock struct {
+	id      restic.ID // first ID in the block
+	segment uint32
+	pos     uint32
+}
+
+type compactTable struct {
+	blocks   []compactBlock
+	segments [][]byte
+	count    int
+
+	// fanout[p] is the number of the first block whose first ID starts with
+	// fanoutBits bits of at least p. This avoids most of the cache misses of
+	// a binary search over all blocks.
+	fanout     []uint32
+	fanoutBits int
+}
+
+type compactIndex struct {
+	byType [restic.NumBlobTypes]compactTable
+}
+
+// block returns the encoded data and the number of entries of block b.
+func (t *compactTable) block(b int) ([]byte, int) {
+	block := &t.blocks[b]
+	n := t.count - b*compactBlockSize
+	if n > compactBlockSize {
+		n = compactBlockSize
+	}
+	return t.segments[block.segment][block.pos:], n
+}
+
+// decodeIDs decodes the IDs of the entries in block b into ids. It returns the
+// number of entries and the fields of the entries.
+func (t *compactTable) decodeIDs(b int, ids *[compactBlockSize]restic.ID) (int, blockFields) {
+	buf, n := t.block(b)
+	ids[0] = t.blocks[b].id
+	for i := 1; i < n; i++ {
+		shared := int(buf[0])
+		ids[i] = ids[i-1]
+		copy(ids[i][shared:], buf[1:])
+		buf = buf[1+len(ids[i])-shared:]
+	}
+	return n, newBlockFields(buf)
+}
+
+// blockFields holds the bit-packed pack index, offset, length and
+// uncompressed length of the entries of a block. The fields use the number of
+// bits required for the largest value within the block.
+type blockFields struct {
+	widths    [4]uint8
+	entryBits uint
+	buf       []byte
+}
+
+func newBlockFields(buf []byte) blockFields {
+	var f blockFields
+	copy(f.widths[:], buf)
+	for _, w := range f.widths {
+		f.entryBits += uint(w)
+	}
+	f.buf = buf[len(f.widths):]
+	return f
+}
+
+func (f *blockFields) read(pos uint, width uint8) uint32 {
+	if width == 0 {
+		return 0
+	}
+
+	var tmp [8]byte
+	copy(tmp[:], f.buf[pos/8:])
+	v := binary.LittleEndian.Uint64(tmp[:]) >> (pos % 8)
+	return uint32(v & (1<<width - 1))
+}
+
+// decode decodes the fields of the i-th entry of the block into e.
+func (f *blockFields) decode(i int, e *indexEntry) {
+	pos := uint(i) * f.entryBits
+	e.packIndex = int(f.read(pos, f.widths[0]))
+	pos += uint(f.widths[0])
+	e.offset = f.read(pos, f.widths[1])
+	pos += uint(f.widths[1])
+	e.length = f.read(pos, f.widths[2])
+	pos += uint(f.widths[2])
+	e.uncompressedLength = f.read(pos, f.widths[3])
+}
+
+func (t *compactTable) foreach(fn func(*indexEntry) bool) {
+	var ids [compactBlockSize]restic.ID
+	for b := range t.blocks {
+		n, fields := t.decodeIDs(b, &ids)
+		for i := 0; i < n; i++ {
+			e := &indexEntry{id: ids[i]}
+			fields.decode(i, e)
+			if !fn(e) {
+				return
+			}
+		}
+	}
+}
+
+// drain calls fn for all entries and releases the memory of each segment once
+// all of its entries have been passed to fn. The entry passed to fn is only
+// valid until fn returns. The table must not be used afterwards.
+func (t *compactTable) drain(fn func(*indexEntry)) {
+	var ids [compactBlockSize]restic.ID
+	var e indexEntry
+	for b := range t.blocks {
+		n, fields := t.decodeIDs(b, &ids)
+		for i := 0; i < n; i++ {
+			e.id = ids[i]
+			fields.decode(i, &e)
+			fn(&e)
+		}
+
+		segment := t.blocks[b].segment
+		if b+1 == len(t.blocks) || t.blocks[b+1].segment != segment {
+			t.segments[segment] = nil
+		}
+	}
+	*t = compactTable{}
+}
+
+func fanoutPrefix(id restic.ID, bits int) int {
+	if bits == 0 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(id[:4]) >> (32 - bits))
+}
+
+func (t *compactTable) foreachWithID(id restic.ID, fn func(*indexEntry)) {
+	if len(t.blocks) == 0 {
+		return
+	}
+
+	// entries with the given id start in the last block whose first ID is
+	// smaller than id, or in the following one
+	p := fanoutPrefix(id, t.fanoutBits)
+	lo, hi := int(t.fanout[p]), int(t.fanout[p+1])
+	start := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(t.blocks[lo+i].id[:], id[:]) >= 0
+	})
+	if start > 0 {
+		start--
+	}
+
+	for b := start; b < len(t.blocks); b++ {
+		if b > start && t.blocks[b].id != id {
+			return
+		}
+
+		// find the range of matching entries [first, last). Once an ID larger
+		// than id is found, the remaining IDs are only skipped to find the
+		// start of the fields.
+		buf, n := t.block(b)
+		cur := t.blocks[b].id
+		cmp := bytes.Compare(cur[:], id[:])
+		first, last := -1, n
+		if cmp == 0 {
+			first = 0
+		}
+		for i := 1; i < n; i++ {
+			shared := int(buf[0])
+			if cmp <= 0 {
+				copy(cur[shared:], buf[1:])
+				prev := cmp
+				cmp = bytes.Compare(cur[:], id[:])
+				if cmp == 0 && prev < 0 {
+					first = i
+				} else if cmp > 0 && prev == 0 {
+					last = i
+				}
+			}
+			buf = buf[1+len(cur)-shared:]
+		}
+
+		if first >= 0 {
+			fields := newBlockFields(buf)
+			for i := first; i < last; i++ {
+				e := &indexEntry{id: id}
+				fields.decode(i, e)
+				fn(e)
+			}
+		}
+		if cmp > 0 {
+			return
+		}
+	}
+}
+
+// compactTableWriter encodes a compactTable. The IDs of a block are stored
+// before the remaining fields, such that a block can be searched without
+// decoding the fields of all entries.
+type compactTableWriter struct {
+	t       compactTable
+	segment []byte
+	ids     []byte
+	fields  [][4]uint32
+	prev    restic.ID
+}
+
+func newCompactTableWriter(count int) *compactTableWriter {
+	return &compactTableWriter{
+		t: compactTable{
+			blocks: make([]compactBlock, 0, (count+compactBlockSize-1)/compactBlockSize),
+		},
+	}
+}
+
+// add appends e to the table. The entries must be added sorted by ID.
+func (w *compactTableWriter) add(e *indexEntry) {
+	if w.t.count%compactBlockSize == 0 {
+		w.flushBlock()
+		w.t.blocks = append(w.t.blocks, compactBlock{id: e.id})
+	} else {
+		shared := 0
+		for shared < len(e.id)-1 && e.id[shared] == w.prev[shared] {
+			shared++
+		}
+		w.ids = append(w.ids, byte(shared))
+		w.ids = append(w.ids, e.id[shared:]...)
+	}
+
+	w.fields = append(w.fields, [4]uint32{uint32(e.packIndex), e.offset, e.length, e.uncompressedLength})
+
+	w.prev = e.id
+	w.t.count++
+}
+
+// flushBlock appends the current block to the current segment.
+func (w *compactTableWriter) flushBlock() {
+	if len(w.t.blocks) == 0 {
+		return
+	}
+
+	var widths [4]uint8
+	for _, f := range w.fields {
+		for i, v := range f {
+			if width := uint8(bits.Len32(v)); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+	entryBits := 0
+	for _, width := range widths {
+		entryBits += int(width)
+	}
+
+	size := len(w.ids) + len(widths) + (len(w.fields)*entryBits+7)/8
+	if len(w.segment)+size > cap(w.segment) {
+		w.flushSegment()
+		w.segment = make([]byte, 0, compactSegmentSize)
+	}
+
+	block := &w.t.blocks[len(w.t.blocks)-1]
+	block.segment = uint32(len(w.t.segments))
+	block.pos = uint32(len(w.segment))
+	w.segment = append(w.segment, w.ids...)
+	w.segment = append(w.segment, widths[:]...)
+
+	var acc uint64
+	var accBits uint8
+	for _, f := range w.fields {
+		for i, v := range f {
+			acc |= uint64(v) << accBits
+			accBits += widths[i]
+			for accBits >= 8 {
+				w.segment = append(w.segment, byte(acc))
+				acc >>= 8
+				accBits -= 8
+			}
+		}
+	}
+	if accBits > 0 {
+		w.segment = append(w.segment, byte(acc))
+	}
+
+	w.ids = w.ids[:0]
+	w.fields = w.fields[:0]
+}
+
+func (w *compactTableWriter) flushSegment() {
+	if len(w.segment) > 0 {
+		w.t.segments = append(w.t.segments, w.segment)
+	}
+	w.segment = nil
+}
+
+// finish returns the table. The last segment is shrunk to its actual size.
+func (w *compactTableWriter) finish() compactTable {
+	w.flushBlock()
+	w.segment = append([]byte(nil), w.segment...)
+	w.flushSegment()
+
+	t := &w.t
+	if len(t.blocks) > 1 {
+		t.fanoutBits = bits.Len(uint(len(t.blocks))) - 1
+		if t.fanoutBits > maxFanoutBits {
+			t.fanoutBits = maxFanoutBits
+		}
+	}
+	t.fanout = make([]uint32, 1<<t.fanoutBits+1)
+	for _, block := range t.blocks {
+		t.fanout[fanoutPrefix(block.id, t.fanoutBits)+1]++
+	}
+	for i := 1; i < len(t.fanout); i++ {
+		t.fanout[i] += t.fanout[i-1]
+	}
+
+	return w.t
+}
+
+func (ci *compactIndex) foreach(typ restic.BlobType, fn func(*indexEntry) bool) {
+	ci.byType[typ].foreach(fn)
+}
+
+func (ci *compactIndex) foreachWithID(typ restic.BlobType, id restic.ID, fn func(*indexEntry)) {
+	ci.byType[typ].foreachWithID(id, fn)
+}
+
+func (ci *compactIndex) get(typ restic.BlobType, id restic.ID) (e *indexEntry) {
+	ci.byType[typ].foreachWithID(id, func(entry *indexEntry) {
+		if e == nil {
+			e = entry
+		}
+	})
+	return e
+}
+
+// compact moves the entries of the indexMaps into the compactIndex, if the
+// indexMaps hold enough entries. Indexes with an on-disk table are not changed.
+func (idx *Index) compact() {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	old, ok := idx.table.(*compactIndex)
+	if idx.table != nil && !ok {
+		return
+	}
+
+	var mapEntries, tableEntries int
+	for typ := range idx.byType {
+		mapEntries += int(idx.byType[typ].len())
+		if old != nil {
+			tableEntries += old.byType[typ].count
+		}
+	}
+	if mapEntries == 0 || mapEntries*compactRatio < tableEntries {
+		return
+	}
+
+	debug.Log("moving %d entries into compact index with %d entries", mapEntries, tableEntries)
+
+	ci := &compactIndex{}
+	for typ := range idx.byType {
+		m := &idx.byType[typ]
+		entries := make([]*indexEntry, 0, m.len())
+		m.foreach(func(e *indexEntry) bool {
+			entries = append(entries, e)
+			return true
+		})
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].id[:], entries[j].id[:]) < 0
+		})
+
+		count := len(entries)
+		var oldTable *compactTable
+		if old != nil {
+			oldTable = &old.byType[typ]
+			count += oldTable.count
+		}
+
+		w := newCompactTableWriter(count)
+		if oldTable != nil {
+			oldTable.drain(func(e *indexEntry) {
+				e.packIndex += idx.tablePackOffset
+				for len(entries) > 0 && bytes.Compare(entries[0].id[:], e.id[:]) < 0 {
+					w.add(entries[0])
+					entries = entries[1:]
+				}
+				w.add(e)
+			})
+		}
+		for _, e := range entries {
+			w.add(e)
+		}
+
+		ci.byType[typ] = w.finish()
+		*m = indexMap{}
+	}
+
+	idx.table = ci
+	idx.tablePackOffset = 0
+}
@@ -0,0 +1,104 @@
+package index
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func randomCompactTestIndex(rng *rand.Rand, packs int, blobs map[restic.BlobHandle]restic.PackedBlob) *Index {
+	idx := NewIndex()
+	for i := 0; i < packs; i++ {
+		var packID restic.ID
+		rng.Read(packID[:])
+
+		var list []restic.Blob
+		offset := 0
+		for j := 0; j < 1+rng.Intn(20); j++ {
+			var id restic.ID
+			rng.Read(id[:])
+			if j == 1 {
+				// IDs sharing a prefix exercise the delta encoding
+				id = list[0].ID
+				id[31]++
+			}
+			typ := restic.DataBlob
+			if rng.Intn(4) == 0 {
+				typ = restic.TreeBlob
+			}
+			length := 1 + rng.Intn(1<<24)
+			blob := restic.Blob{
+				BlobHandle:         restic.BlobHandle{ID: id, Type: typ},
+				Offset:             uint(offset),
+				Length:             uint(length),
+				UncompressedLength: uint(rng.Intn(2) * 2 * length),
+			}
+			list = append(list, blob)
+			blobs[blob.BlobHandle] = restic.PackedBlob{Blob: blob, PackID: packID}
+			offset += length
+		}
+		idx.StorePack(packID, list)
+	}
+	idx.Finalize()
+	return idx
+}
+
+func TestCompactIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	blobs := make(map[restic.BlobHandle]restic.PackedBlob)
+
+	mi := NewMasterIndex()
+	for i := 0; i < 20; i++ {
+		idx := randomCompactTestIndex(rng, 1+rng.Intn(200), blobs)
+		rtest.OK(t, idx.SetID(restic.NewRandomID()))
+		mi.Insert(idx)
+		rtest.OK(t, mi.MergeFinalIndexes())
+	}
+
+	// merging an identical pack again does not add duplicates
+	var dup restic.PackedBlob
+	for _, pb := range blobs {
+		dup = pb
+		break
+	}
+	idx := NewIndex()
+	idx.StorePack(dup.PackID, []restic.Blob{dup.Blob})
+	idx.Finalize()
+	rtest.OK(t, idx.SetID(restic.NewRandomID()))
+	mi.Insert(idx)
+	rtest.OK(t, mi.MergeFinalIndexes())
+
+	final := mi.idx[0]
+	ci, ok := final.table.(*compactIndex)
+	rtest.Assert(t, ok, "entries were not moved into a compact index")
+	count := 0
+	for typ := range ci.byType {
+		count += ci.byType[typ].count
+	}
+	rtest.Assert(t, count > len(blobs)/2, "too few entries in compact index: %d", count)
+
+	for bh, pb := range blobs {
+		rtest.Equals(t, []restic.PackedBlob{pb}, mi.Lookup(bh))
+		size, found := mi.LookupSize(bh)
+		rtest.Assert(t, found, "size of blob %v not found", bh)
+		rtest.Equals(t, pb.DataLength(), size)
+	}
+	rtest.Assert(t, !mi.Has(restic.NewRandomBlobHandle()), "unknown blob found")
+
+	seen := make(map[restic.BlobHandle]restic.PackedBlob)
+	mi.Each(context.TODO(), func(pb restic.PackedBlob) {
+		seen[pb.BlobHandle] = pb
+	})
+	rtest.Equals(t, blobs, seen)
+
+	seen = make(map[restic.BlobHandle]restic.PackedBlob)
+	for bp := range final.EachByPack(context.TODO(), restic.NewIDSet()) {
+		for _, blob := range bp.Blobs {
+			seen[blob.BlobHandle] = restic.PackedBlob{Blob: blob, PackID: bp.PackID}
+		}
+	}
+	rtest.Equals(t, blobs, seen)
+}
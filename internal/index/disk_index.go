@@ -0,0 +1,358 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// For very large repositories, even the compact indexMap requires more memory
+// than is available. A diskIndex stores the index entries in a sorted table in
+// a temporary file instead, which is memory-mapped if the platform supports
+// it. Only the pack IDs and a fanout table, which maps the first two bytes of
+// a blob ID to the range of entries starting with these bytes, are kept in
+// memory. This reduces the memory requirements to roughly 4 bytes per blob
+// (32 bytes per pack ID divided by BP, see above) plus the part of the table
+// cached by the operating system.
+//
+// Each entry in the table takes diskEntrySize bytes:
+//
+//	id                 [32]byte
+//	packIndex          uint32
+//	offset             uint32
+//	length             uint32
+//	uncompressedLength uint32
+//
+// The entries are sorted by blob type and then by ID.
+const diskEntrySize = len(restic.ID{}) + 4*4
+
+const fanoutSize = 1 << 16
+
+// diskTable provides access to the table of a diskIndex.
+type diskTable interface {
+	io.ReaderAt
+	io.Closer
+}
+
+type diskIndex struct {
+	table diskTable
+	// fanout[typ][p] is the number of the first entry of type typ whose ID
+	// has a prefix of at least p. fanout[typ][fanoutSize] is the number of the
+	// first entry after the entries of type typ.
+	fanout [restic.NumBlobTypes][]int64
+}
+
+func idPrefix(id restic.ID) int {
+	return int(id[0])<<8 | int(id[1])
+}
+
+func decodeDiskEntry(buf []byte, e *indexEntry) {
+	copy(e.id[:], buf)
+	buf = buf[len(e.id):]
+	e.packIndex = int(binary.LittleEndian.Uint32(buf[0:]))
+	e.offset = binary.LittleEndian.Uint32(buf[4:])
+	e.length = binary.LittleEndian.Uint32(buf[8:])
+	e.uncompressedLength = binary.LittleEndian.Uint32(buf[12:])
+}
+
+func encodeDiskEntry(buf []byte, id restic.ID, packIndex int, offset, length, uncompressedLength uint32) {
+	copy(buf, id[:])
+	buf = buf[len(id):]
+	binary.LittleEndian.PutUint32(buf[0:], uint32(packIndex))
+	binary.LittleEndian.PutUint32(buf[4:], offset)
+	binary.LittleEndian.PutUint32(buf[8:], length)
+	binary.LittleEndian.PutUint32(buf[12:], uncompressedLength)
+}
+
+// readEntry reads entry i into buf. The table is a local temporary file, thus
+// failing to read it is not expected and there is no way to recover from it.
+func (d *diskIndex) readEntry(i int64, buf []byte) {
+	_, err := d.table.ReadAt(buf[:diskEntrySize], i*int64(diskEntrySize))
+	if err != nil {
+		panic(fmt.Sprintf("reading the on-disk index failed: %v", err))
+	}
+}
+
+func (d *diskIndex) foreachWithID(typ restic.BlobType, id restic.ID, fn func(*indexEntry)) {
+	lo, hi := d.fanout[typ][idPrefix(id)], d.fanout[typ][idPrefix(id)+1]
+	if lo == hi {
+		return
+	}
+
+	var buf [diskEntrySize]byte
+	n := sort.Search(int(hi-lo), func(i int) bool {
+		d.readEntry(lo+int64(i), buf[:])
+		return bytes.Compare(buf[:len(id)], id[:]) >= 0
+	})
+
+	for i := lo + int64(n); i < hi; i++ {
+		d.readEntry(i, buf[:])
+		if !bytes.Equal(buf[:len(id)], id[:]) {
+			return
+		}
+		var e indexEntry
+		decodeDiskEntry(buf[:], &e)
+		fn(&e)
+	}
+}
+
+func (d *diskIndex) get(typ restic.BlobType, id restic.ID) (e *indexEntry) {
+	d.foreachWithID(typ, id, func(entry *indexEntry) {
+		if e == nil {
+			e = entry
+		}
+	})
+	return e
+}
+
+func (d *diskIndex) foreach(typ restic.BlobType, fn func(*indexEntry) bool) {
+	start, end := d.fanout[typ][0], d.fanout[typ][fanoutSize]
+	sr := io.NewSectionReader(d.table, start*int64(diskEntrySize), (end-start)*int64(diskEntrySize))
+	rd := bufio.NewReaderSize(sr, 1<<20)
+
+	var buf [diskEntrySize]byte
+	for i := start; i < end; i++ {
+		_, err := io.ReadFull(rd, buf[:])
+		if err != nil {
+			panic(fmt.Sprintf("reading the on-disk index failed: %v", err))
+		}
+		e := &indexEntry{}
+		decodeDiskEntry(buf[:], e)
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// The entries are collected in disjoint buckets according to the first bits
+// of their ID, so that only a single bucket needs to be sorted in memory.
+const diskBucketBits = 6
+
+// DiskIndexBuilder collects the entries of finalized indexes and builds an
+// Index which keeps these entries in a temporary file instead of in memory.
+// All files are created in the default directory for temporary files and are
+// removed once they are closed.
+type DiskIndexBuilder struct {
+	buckets [restic.NumBlobTypes][1 << diskBucketBits]*diskBucket
+
+	packs      restic.IDs
+	ids        restic.IDs
+	supersedes restic.IDs
+}
+
+type diskBucket struct {
+	f  *os.File
+	wr *bufio.Writer
+}
+
+// NewDiskIndexBuilder returns a new builder.
+func NewDiskIndexBuilder() *DiskIndexBuilder {
+	return &DiskIndexBuilder{}
+}
+
+func (b *DiskIndexBuilder) bucket(typ restic.BlobType, id restic.ID) (*diskBucket, error) {
+	n := id[0] >> (8 - diskBucketBits)
+	bucket := b.buckets[typ][n]
+	if bucket != nil {
+		return bucket, nil
+	}
+
+	f, err := fs.TempFile("", "restic-index-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	bucket = &diskBucket{f: f, wr: bufio.NewWriter(f)}
+	b.buckets[typ][n] = bucket
+	return bucket, nil
+}
+
+// Add adds the entries of the finalized index idx. Afterwards, idx is no
+// longer needed.
+func (b *DiskIndexBuilder) Add(idx *Index) error {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	if !idx.final {
+		return errors.New("index to add is not final")
+	}
+	if idx.table != nil {
+		return errors.New("index to add already uses an entry table")
+	}
+
+	packlen := len(b.packs)
+	b.packs = append(b.packs, idx.packs...)
+
+	var buf [diskEntrySize]byte
+	for typ := range idx.byType {
+		var err error
+		idx.byType[typ].foreach(func(e *indexEntry) bool {
+			var bucket *diskBucket
+			bucket, err = b.bucket(restic.BlobType(typ), e.id)
+			if err != nil {
+				return false
+			}
+			encodeDiskEntry(buf[:], e.id, e.packIndex+packlen, e.offset, e.length, e.uncompressedLength)
+			_, err = bucket.wr.Write(buf[:])
+			return err == nil
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	b.ids = append(b.ids, idx.ids...)
+	b.supersedes = append(b.supersedes, idx.supersedes...)
+	return nil
+}
+
+// diskEntries implements sort.Interface for a buffer of encoded entries.
+type diskEntries []byte
+
+func (s diskEntries) entry(i int) []byte {
+	return s[i*diskEntrySize : (i+1)*diskEntrySize]
+}
+
+func (s diskEntries) Len() int { return len(s) / diskEntrySize }
+
+func (s diskEntries) Less(i, j int) bool {
+	return bytes.Compare(s.entry(i), s.entry(j)) < 0
+}
+
+func (s diskEntries) Swap(i, j int) {
+	var tmp [diskEntrySize]byte
+	copy(tmp[:], s.entry(i))
+	copy(s.entry(i), s.entry(j))
+	copy(s.entry(j), tmp[:])
+}
+
+// readBucket returns the sorted entries of the bucket with exact duplicates
+// removed.
+func (b *DiskIndexBuilder) readBucket(bucket *diskBucket) (diskEntries, error) {
+	if err := bucket.wr.Flush(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	size, err := bucket.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	entries := make(diskEntries, size)
+	if _, err := bucket.f.ReadAt(entries, 0); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Sort(entries)
+
+	// duplicates share the same ID and are thus adjacent, but may reference
+	// the same pack using different pack indexes
+	var e, other indexEntry
+	n := 0
+	runStart := 0
+	for i := 0; i < entries.Len(); i++ {
+		cur := entries.entry(i)
+		if n == 0 || !bytes.Equal(cur[:len(e.id)], entries.entry(n - 1)[:len(e.id)]) {
+			runStart = n
+		}
+
+		decodeDiskEntry(cur, &e)
+		duplicate := false
+		for j := runStart; j < n; j++ {
+			decodeDiskEntry(entries.entry(j), &other)
+			if b.packs[e.packIndex] == b.packs[other.packIndex] && e.offset == other.offset &&
+				e.length == other.length && e.uncompressedLength == other.uncompressedLength {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			copy(entries.entry(n), cur)
+			n++
+		}
+	}
+
+	return entries[:n*diskEntrySize], nil
+}
+
+// Finish writes the table of all added entries to a temporary file and returns
+// a finalized index using it. The builder must not be used afterwards.
+func (b *DiskIndexBuilder) Finish() (*Index, error) {
+	defer b.Close()
+
+	f, err := fs.TempFile("", "restic-index-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	wr := bufio.NewWriterSize(f, 1<<20)
+
+	d := &diskIndex{}
+	var pos int64
+	for typ := range b.buckets {
+		fanout := make([]int64, fanoutSize+1)
+		for _, bucket := range b.buckets[typ] {
+			if bucket == nil {
+				continue
+			}
+
+			entries, err := b.readBucket(bucket)
+			if err != nil {
+				_ = f.Close()
+				return nil, err
+			}
+			for i := 0; i < entries.Len(); i++ {
+				var id restic.ID
+				copy(id[:], entries.entry(i))
+				fanout[idPrefix(id)+1]++
+			}
+			if _, err := wr.Write(entries); err != nil {
+				_ = f.Close()
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		fanout[0] = pos
+		for i := 1; i <= fanoutSize; i++ {
+			fanout[i] += fanout[i-1]
+		}
+		pos = fanout[fanoutSize]
+		d.fanout[typ] = fanout
+	}
+
+	if err := wr.Flush(); err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	d.table, err = openDiskTable(f, pos*int64(diskEntrySize))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	debug.Log("created on-disk index with %d entries for %d packs", pos, len(b.packs))
+
+	idx := NewIndex()
+	idx.table = d
+	idx.packs = b.packs
+	idx.ids = b.ids
+	idx.supersedes = b.supersedes
+	idx.final = true
+	return idx, nil
+}
+
+// Close removes the temporary files of the builder.
+func (b *DiskIndexBuilder) Close() {
+	for typ := range b.buckets {
+		for n, bucket := range b.buckets[typ] {
+			if bucket != nil {
+				_ = bucket.f.Close()
+				b.buckets[typ][n] = nil
+			}
+		}
+	}
+}
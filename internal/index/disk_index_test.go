@@ -0,0 +1,121 @@
+package index_test
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func finalIndex(t testing.TB, idx *index.Index) *index.Index {
+	idx.Finalize()
+	rtest.OK(t, idx.SetID(restic.NewRandomID()))
+	return idx
+}
+
+func sortedBlobs(pbs []restic.PackedBlob) []restic.PackedBlob {
+	sort.Slice(pbs, func(i, j int) bool {
+		if pbs[i].ID != pbs[j].ID {
+			return pbs[i].ID.String() < pbs[j].ID.String()
+		}
+		return pbs[i].PackID.String() < pbs[j].PackID.String()
+	})
+	return pbs
+}
+
+func TestDiskIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+
+	treeBlob := restic.PackedBlob{
+		PackID: restic.NewRandomID(),
+		Blob: restic.Blob{
+			BlobHandle: restic.NewRandomBlobHandle(),
+			Length:     42,
+		},
+	}
+	treeBlob.Type = restic.TreeBlob
+
+	builder := index.NewDiskIndexBuilder()
+	defer builder.Close()
+
+	var expected []restic.PackedBlob
+	var ids restic.IDs
+	for i := 0; i < 3; i++ {
+		idx, _ := createRandomIndex(rng, 50)
+		if i > 0 {
+			// the same pack contained in several indexes is stored only once
+			idx.StorePack(treeBlob.PackID, []restic.Blob{treeBlob.Blob})
+		}
+		idx = finalIndex(t, idx)
+		idx.Each(context.TODO(), func(pb restic.PackedBlob) {
+			if pb.Type == restic.DataBlob {
+				expected = append(expected, pb)
+			}
+		})
+		idxIDs, err := idx.IDs()
+		rtest.OK(t, err)
+		ids = append(ids, idxIDs...)
+
+		rtest.OK(t, builder.Add(idx))
+	}
+	expected = append(expected, treeBlob)
+
+	mi := index.NewMasterIndex()
+	diskIdx, err := builder.Finish()
+	rtest.OK(t, err)
+	mi.Insert(diskIdx)
+	rtest.OK(t, mi.MergeFinalIndexes())
+
+	rtest.Equals(t, restic.NewIDSet(ids...), mi.IDs())
+	for _, pb := range expected {
+		rtest.Equals(t, []restic.PackedBlob{pb}, mi.Lookup(pb.BlobHandle))
+		rtest.Assert(t, mi.Has(pb.BlobHandle), "blob %v not found", pb.BlobHandle)
+		size, found := mi.LookupSize(pb.BlobHandle)
+		rtest.Assert(t, found, "size of blob %v not found", pb.BlobHandle)
+		rtest.Equals(t, pb.DataLength(), size)
+	}
+	rtest.Assert(t, !mi.Has(restic.NewRandomBlobHandle()), "unknown blob found")
+	rtest.Assert(t, len(mi.Lookup(restic.NewRandomBlobHandle())) == 0, "unknown blob found")
+
+	var all []restic.PackedBlob
+	mi.Each(context.TODO(), func(pb restic.PackedBlob) {
+		all = append(all, pb)
+	})
+	rtest.Equals(t, sortedBlobs(expected), sortedBlobs(all))
+
+	var byPack []restic.PackedBlob
+	for bp := range diskIdx.EachByPack(context.TODO(), restic.NewIDSet(treeBlob.PackID)) {
+		for _, blob := range bp.Blobs {
+			byPack = append(byPack, restic.PackedBlob{Blob: blob, PackID: bp.PackID})
+		}
+	}
+	var expectedByPack []restic.PackedBlob
+	for _, pb := range expected {
+		if pb.PackID != treeBlob.PackID {
+			expectedByPack = append(expectedByPack, pb)
+		}
+	}
+	rtest.Equals(t, sortedBlobs(expectedByPack), sortedBlobs(byPack))
+
+	// further indexes are merged into the on-disk index
+	blob := restic.PackedBlob{
+		PackID: restic.NewRandomID(),
+		Blob: restic.Blob{
+			BlobHandle: restic.NewRandomBlobHandle(),
+			Length:     23,
+		},
+	}
+	idx := index.NewIndex()
+	idx.StorePack(blob.PackID, []restic.Blob{blob.Blob})
+	idx.StorePack(treeBlob.PackID, []restic.Blob{treeBlob.Blob})
+	mi.Insert(finalIndex(t, idx))
+	rtest.OK(t, mi.MergeFinalIndexes())
+
+	rtest.Equals(t, []restic.PackedBlob{blob}, mi.Lookup(blob.BlobHandle))
+	rtest.Equals(t, []restic.PackedBlob{treeBlob}, mi.Lookup(treeBlob.BlobHandle))
+	rtest.Equals(t, expectedByPack[:1], mi.Lookup(expectedByPack[0].BlobHandle))
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/sys/unix"
+)
+
+// mmapTable is a read-only memory mapping of a table.
+type mmapTable struct {
+	data []byte
+}
+
+// openDiskTable maps the table of the given size stored in f into memory. The
+// file is closed afterwards, the mapping remains valid until the table is
+// closed.
+func openDiskTable(f *os.File, size int64) (diskTable, error) {
+	if size == 0 {
+		return &mmapTable{}, f.Close()
+	}
+
+	if int64(int(size)) != size {
+		return nil, errors.Errorf("index of %d bytes is too large to be mapped into memory", size)
+	}
+
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrap(err, "Mmap")
+	}
+	return &mmapTable{data: data}, f.Close()
+}
+
+func (t *mmapTable) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(t.data)) {
+		return 0, errors.Errorf("read at %d beyond the end of the table", off)
+	}
+	return copy(p, t.data[off:]), nil
+}
+
+func (t *mmapTable) Close() error {
+	if t.data == nil {
+		return nil
+	}
+	data := t.data
+	t.data = nil
+	return unix.Munmap(data)
+}
@@ -0,0 +1,9 @@
+package index
+
+import "os"
+
+// openDiskTable returns the table of the given size stored in f. The file is
+// read directly, as it is removed once it is closed.
+func openDiskTable(f *os.File, size int64) (diskTable, error) {
+	return f, nil
+}
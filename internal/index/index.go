@@ -40,6 +40,9 @@ import (
 // To save N index entries, we therefore need:
 // N * (56 + 2) bytes + N * 32 bytes / BP = N * 62 bytes,
 // i.e., fewer than 64 bytes per blob in an index.
+//
+// Most entries end up in the final index all index files are merged into,
+// which stores them in a compactIndex that needs about two thirds of this.
 
 // Index holds lookup tables for id -> pack.
 type Index struct {
@@ -47,12 +50,30 @@ type Index struct {
 	byType [restic.NumBlobTypes]indexMap
 	packs  restic.IDs
 
+	// table optionally holds further entries in a compact form, see
+	// compactIndex and DiskIndexBuilder. Their pack indexes are offset by
+	// tablePackOffset.
+	table           entryTable
+	tablePackOffset int
+
 	final      bool       // set to true for all indexes read from the backend ("finalized")
 	ids        restic.IDs // set to the IDs of the contained finalized indexes
 	supersedes restic.IDs
 	created    time.Time
 }
 
+// entryTable is an immutable table of index entries.
+type entryTable interface {
+	// foreach calls fn for all entries of the given type, until fn returns
+	// false. Each entry is passed in a newly allocated indexEntry.
+	foreach(typ restic.BlobType, fn func(*indexEntry) bool)
+	// foreachWithID calls fn for all entries of the given type with the
+	// given id.
+	foreachWithID(typ restic.BlobType, id restic.ID, fn func(*indexEntry))
+	// get returns the first entry of the given type with the given id.
+	get(typ restic.BlobType, id restic.ID) *indexEntry
+}
+
 // NewIndex returns a new index.
 func NewIndex() *Index {
 	return &Index{
@@ -69,6 +90,10 @@ func (idx *Index) addToPacks(id restic.ID) int {
 
 const maxuint32 = 1<<32 - 1
 
+// eachByPackTablePasses is the number of passes EachByPack uses for indexes
+// with an entryTable.
+const eachByPackTablePasses = 16
+
 func (idx *Index) store(packIndex int, blob restic.Blob) {
 	// assert that offset and length fit into uint32!
 	if blob.Offset > maxuint32 || blob.Length > maxuint32 || blob.UncompressedLength > maxuint32 {
@@ -106,12 +131,7 @@ var IndexFull = func(idx *Index, compress bool) bool {
 		blobs += idx.byType[typ].len()
 	}
 	age := time.Since(idx.created)
-	var maxBlobs uint
-	if compress {
-		maxBlobs = indexMaxBlobsCompressed
-	} else {
-		maxBlobs = indexMaxBlobs
-	}
+	maxBlobs := indexMaxBlobCount(compress)
 
 	switch {
 	case age >= indexMaxAge:
@@ -127,6 +147,27 @@ var IndexFull = func(idx *Index, compress bool) bool {
 
 }
 
+func indexMaxBlobCount(compress bool) uint {
+	if compress {
+		return indexMaxBlobsCompressed
+	}
+	return indexMaxBlobs
+}
+
+// IndexSmall returns true iff the index contains less than a quarter of the
+// blobs of a full index. Merging many such indexes into full ones reduces the
+// number of index files that must be listed and loaded.
+func IndexSmall(idx *Index, compress bool) bool {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	var blobs uint
+	for typ := range idx.byType {
+		blobs += idx.byType[typ].len()
+	}
+	return blobs < indexMaxBlobCount(compress)/4
+}
+
 // StorePack remembers the ids of all blobs of a given pack
 // in the index
 func (idx *Index) StorePack(id restic.ID, blobs []restic.Blob) {
@@ -159,13 +200,57 @@ func (idx *Index) toPackedBlob(e *indexEntry, t restic.BlobType) restic.PackedBl
 	}
 }
 
+// foreach calls fn for all entries of the given type, until fn returns false.
+func (idx *Index) foreach(typ restic.BlobType, fn func(*indexEntry) bool) {
+	stopped := false
+	idx.byType[typ].foreach(func(e *indexEntry) bool {
+		stopped = !fn(e)
+		return !stopped
+	})
+	if stopped || idx.table == nil {
+		return
+	}
+
+	idx.table.foreach(typ, func(e *indexEntry) bool {
+		e.packIndex += idx.tablePackOffset
+		return fn(e)
+	})
+}
+
+// foreachWithID calls fn for all entries of the given type with the given id.
+func (idx *Index) foreachWithID(typ restic.BlobType, id restic.ID, fn func(*indexEntry)) {
+	idx.byType[typ].foreachWithID(id, fn)
+	if idx.table == nil {
+		return
+	}
+
+	idx.table.foreachWithID(typ, id, func(e *indexEntry) {
+		e.packIndex += idx.tablePackOffset
+		fn(e)
+	})
+}
+
+// get returns the first entry of the given type with the given id.
+func (idx *Index) get(typ restic.BlobType, id restic.ID) *indexEntry {
+	e := idx.byType[typ].get(id)
+	if e != nil || idx.table == nil {
+		return e
+	}
+
+	e = idx.table.get(typ, id)
+	if e != nil {
+		e.packIndex += idx.tablePackOffset
+	}
+	return e
+}
+
 // Lookup queries the index for the blob ID and returns all entries including
 // duplicates. Adds found entries to blobs and returns the result.
 func (idx *Index) Lookup(bh restic.BlobHandle, pbs []restic.PackedBlob) []restic.PackedBlob {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	idx.byType[bh.Type].foreachWithID(bh.ID, func(e *indexEntry) {
+	idx.foreachWithID(bh.Type, bh.ID, func(e *indexEntry) {
 		pbs = append(pbs, idx.toPackedBlob(e, bh.Type))
 	})
 
@@ -177,7 +262,7 @@ func (idx *Index) Has(bh restic.BlobHandle) bool {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	return idx.byType[bh.Type].get(bh.ID) != nil
+	return idx.get(bh.Type, bh.ID) != nil
 }
 
 // LookupSize returns the length of the plaintext content of the blob with the
@@ -186,7 +271,7 @@ func (idx *Index) LookupSize(bh restic.BlobHandle) (plaintextLength uint, found
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	e := idx.byType[bh.Type].get(bh.ID)
+	e := idx.get(bh.Type, bh.ID)
 	if e == nil {
 		return 0, false
 	}
@@ -222,8 +307,7 @@ func (idx *Index) Each(ctx context.Context, fn func(restic.PackedBlob)) {
 	defer idx.m.Unlock()
 
 	for typ := range idx.byType {
-		m := &idx.byType[typ]
-		m.foreach(func(e *indexEntry) bool {
+		idx.foreach(restic.BlobType(typ), func(e *indexEntry) bool {
 			if ctx.Err() != nil {
 				return false
 			}
@@ -254,35 +338,47 @@ func (idx *Index) EachByPack(ctx context.Context, packBlacklist restic.IDSet) <-
 		defer idx.m.Unlock()
 		defer close(ch)
 
-		byPack := make(map[restic.ID][restic.NumBlobTypes][]*indexEntry)
-
-		for typ := range idx.byType {
-			m := &idx.byType[typ]
-			m.foreach(func(e *indexEntry) bool {
-				packID := idx.packs[e.packIndex]
-				if !idx.final || !packBlacklist.Has(packID) {
-					v := byPack[packID]
-					v[typ] = append(v[typ], e)
-					byPack[packID] = v
-				}
-				return true
-			})
+		// grouping the entries of a table by pack in a single pass would
+		// allocate all of them at once, thus only handle the packs whose ID
+		// starts with certain bytes in each pass.
+		passes := 1
+		if idx.table != nil {
+			passes = eachByPackTablePasses
 		}
 
-		for packID, packByType := range byPack {
-			var result EachByPackResult
-			result.PackID = packID
-			for typ, pack := range packByType {
-				for _, e := range pack {
-					result.Blobs = append(result.Blobs, idx.toPackedBlob(e, restic.BlobType(typ)).Blob)
-				}
+		for pass := 0; pass < passes; pass++ {
+			byPack := make(map[restic.ID][restic.NumBlobTypes][]*indexEntry)
+
+			for typ := range idx.byType {
+				idx.foreach(restic.BlobType(typ), func(e *indexEntry) bool {
+					packID := idx.packs[e.packIndex]
+					if int(packID[0])%passes != pass {
+						return true
+					}
+					if !idx.final || !packBlacklist.Has(packID) {
+						v := byPack[packID]
+						v[typ] = append(v[typ], e)
+						byPack[packID] = v
+					}
+					return ctx.Err() == nil
+				})
 			}
-			// allow GC once entry is no longer necessary
-			delete(byPack, packID)
-			select {
-			case <-ctx.Done():
-				return
-			case ch <- result:
+
+			for packID, packByType := range byPack {
+				var result EachByPackResult
+				result.PackID = packID
+				for typ, pack := range packByType {
+					for _, e := range pack {
+						result.Blobs = append(result.Blobs, idx.toPackedBlob(e, restic.BlobType(typ)).Blob)
+					}
+				}
+				// allow GC once entry is no longer necessary
+				delete(byPack, packID)
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- result:
+				}
 			}
 		}
 	}()
@@ -322,8 +418,7 @@ func (idx *Index) generatePackList() ([]packJSON, error) {
 	packs := make(map[restic.ID]int, len(list)) // Maps to index in list.
 
 	for typ := range idx.byType {
-		m := &idx.byType[typ]
-		m.foreach(func(e *indexEntry) bool {
+		idx.foreach(restic.BlobType(typ), func(e *indexEntry) bool {
 			packID := idx.packs[e.packIndex]
 			if packID.IsNull() {
 				panic("null pack id")
@@ -462,10 +557,21 @@ func (idx *Index) merge(idx2 *Index) error {
 		return errors.New("index to merge is not final")
 	}
 
+	if idx2.table != nil && idx.table != nil {
+		return errors.New("cannot merge two indexes with entry tables")
+	}
+
 	packlen := len(idx.packs)
 	// first append packs as they might be accessed when looking for duplicates below
 	idx.packs = append(idx.packs, idx2.packs...)
 
+	// the entries of a table are not copied but used as they are, this skips
+	// removing duplicates
+	if idx2.table != nil {
+		idx.table = idx2.table
+		idx.tablePackOffset = packlen + idx2.tablePackOffset
+	}
+
 	// copy all index entries of idx2 to idx
 	for typ := range idx2.byType {
 		m2 := &idx2.byType[typ]
@@ -473,7 +579,7 @@ func (idx *Index) merge(idx2 *Index) error {
 
 		// helper func to test if identical entry is contained in idx
 		hasIdenticalEntry := func(e2 *indexEntry) (found bool) {
-			m.foreachWithID(e2.id, func(e *indexEntry) {
+			idx.foreachWithID(restic.BlobType(typ), e2.id, func(e *indexEntry) {
 				b := idx.toPackedBlob(e, restic.BlobType(typ))
 				b2 := idx2.toPackedBlob(e2, restic.BlobType(typ))
 				if b == b2 {
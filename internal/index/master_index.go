@@ -258,6 +258,7 @@ func (mi *MasterIndex) MergeFinalIndexes() error {
 			if err != nil {
 				return fmt.Errorf("MergeFinalIndexes: %w", err)
 			}
+			mi.idx[0].compact()
 		}
 	}
 	mi.idx = newIdx
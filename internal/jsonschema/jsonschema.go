@@ -0,0 +1,225 @@
+// Package jsonschema generates JSON Schema documents describing the JSON
+// encoding of Go values, as produced by encoding/json.
+package jsonschema
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Draft is the JSON Schema version of the generated documents.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a (subset of a) JSON Schema document.
+type Schema struct {
+	Schema          string             `json:"$schema,omitempty"`
+	Title           string             `json:"title,omitempty"`
+	Ref             string             `json:"$ref,omitempty"`
+	Type            interface{}        `json:"type,omitempty"`
+	Format          string             `json:"format,omitempty"`
+	ContentEncoding string             `json:"contentEncoding,omitempty"`
+	Minimum         *int               `json:"minimum,omitempty"`
+	AnyOf           []*Schema          `json:"anyOf,omitempty"`
+	Items           *Schema            `json:"items,omitempty"`
+	Properties      map[string]*Schema `json:"properties,omitempty"`
+	Required        []string           `json:"required,omitempty"`
+	Additional      *Schema            `json:"additionalProperties,omitempty"`
+	Defs            map[string]*Schema `json:"$defs,omitempty"`
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Generate returns a schema for the JSON encoding of v. Named struct types
+// are described once in the definitions of the document and referenced
+// from all places they are used in.
+//
+// Struct fields tagged with `jsonschema:"-"` are left out of the schema.
+func Generate(title string, v interface{}) *Schema {
+	g := &generator{defs: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+
+	t := reflect.TypeOf(v)
+	var s *Schema
+	if t != nil && t.Kind() == reflect.Struct && t != timeType {
+		// describe the top-level struct directly instead of referencing it
+		s = g.structSchema(t)
+	} else {
+		s = g.schema(t)
+	}
+
+	s.Schema = Draft
+	s.Title = title
+	if len(g.defs) > 0 {
+		s.Defs = g.defs
+	}
+	return s
+}
+
+type generator struct {
+	defs  map[string]*Schema
+	names map[reflect.Type]string
+}
+
+// nullable returns a schema which allows null in addition to s.
+func nullable(s *Schema) *Schema {
+	if typ, ok := s.Type.(string); ok && s.Ref == "" {
+		s.Type = []string{typ, "null"}
+		return s
+	}
+	return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+}
+
+func (g *generator) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	// types with custom marshalers are assumed to be encoded as strings,
+	// unless they are structs, which usually only adapt their fields
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface &&
+		(t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) ||
+			reflect.PtrTo(t).Implements(textMarshalerType)) {
+		return &Schema{Type: "string"}
+	}
+
+	zero := 0
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Ptr:
+		return nullable(g.schema(t.Elem()))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nullable(&Schema{Type: "string", ContentEncoding: "base64"})
+		}
+		return nullable(&Schema{Type: "array", Items: g.schema(t.Elem())})
+	case reflect.Array:
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return nullable(&Schema{Type: "object", Additional: g.schema(t.Elem())})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return &Schema{Ref: "#/$defs/" + g.define(t)}
+	default:
+		// interfaces can hold any value
+		return &Schema{}
+	}
+}
+
+// define adds the named struct type to the definitions and returns its name.
+func (g *generator) define(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := t.String()
+	for i := 2; g.defs[name] != nil; i++ {
+		name = fmt.Sprintf("%v_%d", t, i)
+	}
+
+	g.names[t] = name
+	// reserve the name before descending, the type may reference itself
+	g.defs[name] = &Schema{}
+	*g.defs[name] = *g.structSchema(t)
+	return name
+}
+
+type field struct {
+	name      string
+	omitEmpty bool
+	typ       reflect.Type
+}
+
+// fields returns the fields of the struct as encoding/json serializes them,
+// with the fields of embedded structs promoted.
+func fields(t reflect.Type) []field {
+	var list []field
+	seen := make(map[string]bool)
+
+	// fields of embedded structs are visited breadth first, so that fields
+	// closer to the outer struct take precedence
+	level := []reflect.Type{t}
+	for len(level) > 0 {
+		var next []reflect.Type
+
+		for _, t := range level {
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if f.Tag.Get("jsonschema") == "-" {
+					continue
+				}
+
+				tag := f.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+
+				ft := f.Type
+				if f.Anonymous && name == "" {
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Struct {
+						next = append(next, ft)
+						continue
+					}
+				}
+
+				if !f.IsExported() {
+					continue
+				}
+
+				if name == "" {
+					name = f.Name
+				}
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+
+				list = append(list, field{
+					name:      name,
+					omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+					typ:       f.Type,
+				})
+			}
+		}
+
+		level = next
+	}
+
+	return list
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for _, f := range fields(t) {
+		s.Properties[f.name] = g.schema(f.typ)
+		if !f.omitEmpty {
+			s.Required = append(s.Required, f.name)
+		}
+	}
+	return s
+}
@@ -0,0 +1,84 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/jsonschema"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type inner struct {
+	Name   string `json:"name"`
+	Hidden int    `json:"hidden"`
+}
+
+type outer struct {
+	*inner
+	Hidden   string            `json:"hidden,omitempty"`
+	ID       restic.ID         `json:"id"`
+	Parent   *restic.ID        `json:"parent,omitempty"`
+	Time     time.Time         `json:"time"`
+	Size     uint64            `json:"size"`
+	Data     []byte            `json:"data,omitempty"`
+	Children []inner           `json:"children"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Ignored  int               `json:"-"`
+	Internal int               `json:"internal,omitempty" jsonschema:"-"`
+	private  int
+}
+
+func TestGenerate(t *testing.T) {
+	s := jsonschema.Generate("outer", outer{})
+
+	buf, err := json.Marshal(s)
+	rtest.OK(t, err)
+
+	var doc struct {
+		Schema     string                     `json:"$schema"`
+		Title      string                     `json:"title"`
+		Type       string                     `json:"type"`
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+		Defs       map[string]json.RawMessage `json:"$defs"`
+	}
+	rtest.OK(t, json.Unmarshal(buf, &doc))
+
+	rtest.Equals(t, jsonschema.Draft, doc.Schema)
+	rtest.Equals(t, "outer", doc.Title)
+	rtest.Equals(t, "object", doc.Type)
+	rtest.Equals(t, []string{"id", "time", "size", "children", "name"}, doc.Required)
+
+	want := map[string]string{
+		"name":     `{"type":"string"}`,
+		"hidden":   `{"type":"string"}`,
+		"id":       `{"type":"string"}`,
+		"parent":   `{"type":["string","null"]}`,
+		"time":     `{"type":"string","format":"date-time"}`,
+		"size":     `{"type":"integer","minimum":0}`,
+		"data":     `{"type":["string","null"],"contentEncoding":"base64"}`,
+		"children": `{"type":["array","null"],"items":{"$ref":"#/$defs/jsonschema_test.inner"}}`,
+		"labels":   `{"type":["object","null"],"additionalProperties":{"type":"string"}}`,
+	}
+	rtest.Equals(t, len(want), len(doc.Properties))
+	for name, schema := range want {
+		rtest.Equals(t, schema, string(doc.Properties[name]))
+	}
+
+	rtest.Equals(t, 1, len(doc.Defs))
+	rtest.Equals(t, `{"type":"object","properties":{"hidden":{"type":"integer"},"name":{"type":"string"}},"required":["name","hidden"]}`,
+		string(doc.Defs["jsonschema_test.inner"]))
+}
+
+func TestGenerateSlice(t *testing.T) {
+	s := jsonschema.Generate("list", []inner{})
+
+	buf, err := json.Marshal(s)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, `{"$schema":"`+jsonschema.Draft+`","title":"list","type":["array","null"],"items":{"$ref":"#/$defs/jsonschema_test.inner"},`+
+		`"$defs":{"jsonschema_test.inner":{"type":"object","properties":{"hidden":{"type":"integer"},"name":{"type":"string"}},"required":["name","hidden"]}}}`,
+		string(buf))
+}
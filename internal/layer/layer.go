@@ -0,0 +1,278 @@
+// Package layer stores gzip compressed tar files, such as docker and OCI image
+// layers, in decompressed form. Both the uncompressed data and the parameters
+// needed to recreate the exact original file are returned, so that identical
+// files within different layers are deduplicated.
+package layer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Format is the name of the content encoding used for recompressed layers.
+const Format = "gzip"
+
+// DefaultLevels lists the compression levels tried to reproduce a file by
+// default. These cover the levels commonly used by image build tools.
+var DefaultLevels = []int{
+	gzip.DefaultCompression, gzip.BestSpeed, gzip.BestCompression,
+}
+
+// AllLevels lists all compression levels. Trying all of them compresses each
+// file once per level and is therefore considerably slower.
+var AllLevels = []int{
+	gzip.DefaultCompression, gzip.BestSpeed, 2, 3, 4, 5, 7, 8, gzip.BestCompression,
+}
+
+// ErrMismatch is returned by the writer if the reconstructed file differs
+// from the original file.
+var ErrMismatch = errors.New("reconstructed file differs from the original file")
+
+// tarMagicOffset is the position of the magic string in a tar header.
+const tarMagicOffset = 257
+
+var tarMagic = []byte("ustar")
+
+// candidate compares the output of the compressor with the original file.
+type candidate struct {
+	level int
+	d     *detector
+	pos   int64
+	w     *gzip.Writer
+	bad   bool
+}
+
+func (c *candidate) Write(p []byte) (int, error) {
+	if c.bad {
+		return len(p), nil
+	}
+
+	// the compressor never produces more output than the decompressor has
+	// read, unless the output differs from the original
+	start := c.pos - c.d.base
+	if start+int64(len(p)) > int64(len(c.d.orig)) ||
+		!bytes.Equal(p, c.d.orig[start:start+int64(len(p))]) {
+		c.bad = true
+		return len(p), nil
+	}
+	c.pos += int64(len(p))
+	return len(p), nil
+}
+
+// detector records the original data which has not been matched by all
+// candidates yet.
+type detector struct {
+	base       int64
+	orig       []byte
+	candidates []*candidate
+}
+
+func (d *detector) Write(p []byte) (int, error) {
+	d.orig = append(d.orig, p...)
+	return len(p), nil
+}
+
+// compact drops data that was matched by all remaining candidates.
+func (d *detector) compact() {
+	var alive []*candidate
+	min := d.base + int64(len(d.orig))
+	for _, c := range d.candidates {
+		if c.bad {
+			continue
+		}
+		alive = append(alive, c)
+		if c.pos < min {
+			min = c.pos
+		}
+	}
+	d.candidates = alive
+
+	n := copy(d.orig, d.orig[min-d.base:])
+	d.orig = d.orig[:n]
+	d.base = min
+}
+
+// Detect reads rd to the end and checks whether it is a gzip compressed tar
+// file which can be reproduced exactly from its uncompressed contents using
+// one of the compression levels. It returns nil if this is not the case.
+func Detect(rd io.Reader, levels []int) (*restic.ContentEncoding, error) {
+	d := &detector{}
+	h := sha256.New()
+	// only data read by the decompressor is recorded, so all read errors
+	// are reported by the gzip reader
+	brd := bufio.NewReader(io.TeeReader(rd, io.MultiWriter(d, h)))
+
+	magic, err := brd.Peek(3)
+	if err != nil || !bytes.Equal(magic, []byte{0x1f, 0x8b, 0x08}) {
+		return nil, ignoreFormatError(err)
+	}
+
+	zrd, err := gzip.NewReader(brd)
+	if err != nil {
+		return nil, ignoreFormatError(err)
+	}
+	zrd.Multistream(false)
+
+	hdr := zrd.Header
+	enc := &restic.ContentEncoding{
+		Format:  Format,
+		Name:    hdr.Name,
+		Comment: hdr.Comment,
+		OS:      hdr.OS,
+		Extra:   hdr.Extra,
+	}
+	if !hdr.ModTime.IsZero() {
+		enc.ModTime = uint32(hdr.ModTime.Unix())
+	}
+
+	for _, level := range levels {
+		c := &candidate{level: level, d: d}
+		c.w, err = newWriter(c, enc, level)
+		if err != nil {
+			return nil, err
+		}
+		d.candidates = append(d.candidates, c)
+	}
+
+	buf := make([]byte, 64*1024)
+	first := true
+	for len(d.candidates) > 0 {
+		n, err := io.ReadFull(zrd, buf)
+		if first {
+			// the first block of the file must be a tar header
+			if n < tarMagicOffset+len(tarMagic) ||
+				!bytes.Equal(buf[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic) {
+				return nil, ignoreFormatError(err)
+			}
+			first = false
+		}
+
+		for _, c := range d.candidates {
+			_, _ = c.w.Write(buf[:n])
+		}
+		enc.Size += uint64(n)
+		d.compact()
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, ignoreFormatError(err)
+		}
+	}
+
+	if len(d.candidates) == 0 {
+		return nil, nil
+	}
+
+	// the file must not contain anything after the first gzip stream
+	_, err = io.Copy(io.Discard, brd)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range d.candidates {
+		_ = c.w.Close()
+		if !c.bad && c.pos == d.base+int64(len(d.orig)) {
+			enc.Level = c.level
+			id := restic.IDFromHash(h.Sum(nil))
+			enc.Hash = &id
+			return enc, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ignoreFormatError hides errors caused by an invalid file format, which just
+// means that the file is not a layer.
+func ignoreFormatError(err error) error {
+	var ferr flate.CorruptInputError
+	switch {
+	case err == nil,
+		err == io.EOF,
+		err == io.ErrUnexpectedEOF,
+		errors.Is(err, gzip.ErrHeader),
+		errors.Is(err, gzip.ErrChecksum),
+		errors.As(err, &ferr):
+		return nil
+	}
+	return err
+}
+
+// NewReader returns a reader for the uncompressed contents of the file read
+// by rd, which must have been checked by Detect before.
+func NewReader(rd io.Reader) (io.ReadCloser, error) {
+	zrd, err := gzip.NewReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	zrd.Multistream(false)
+	return zrd, nil
+}
+
+// NewWriter returns a writer which compresses all data written to it to w,
+// such that the original file described by enc is reproduced. The writer
+// must be closed to write the end of the file. If enc contains the hash of
+// the original file, Close returns ErrMismatch if the output differs from
+// it.
+func NewWriter(w io.Writer, enc *restic.ContentEncoding) (io.WriteCloser, error) {
+	if enc.Format != Format {
+		return nil, errors.Errorf("unsupported content encoding %q", enc.Format)
+	}
+
+	wr := &writer{want: enc.Hash, h: sha256.New()}
+	zw, err := newWriter(io.MultiWriter(w, wr.h), enc, enc.Level)
+	if err != nil {
+		return nil, err
+	}
+	wr.zw = zw
+	return wr, nil
+}
+
+// writer checks the hash of the reconstructed file.
+type writer struct {
+	zw   *gzip.Writer
+	h    hash.Hash
+	want *restic.ID
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.zw.Write(p)
+}
+
+func (w *writer) Close() error {
+	err := w.zw.Close()
+	if err != nil {
+		return err
+	}
+	if w.want != nil && !restic.IDFromHash(w.h.Sum(nil)).Equal(*w.want) {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func newWriter(w io.Writer, enc *restic.ContentEncoding, level int) (*gzip.Writer, error) {
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+
+	zw.Name = enc.Name
+	zw.Comment = enc.Comment
+	zw.OS = enc.OS
+	zw.Extra = enc.Extra
+	if enc.ModTime != 0 {
+		zw.ModTime = time.Unix(int64(enc.ModTime), 0)
+	}
+	return zw, nil
+}
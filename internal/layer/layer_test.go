@@ -0,0 +1,129 @@
+package layer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/layer"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testTar(t testing.TB) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i, size := range []int{100, 200000, 3000} {
+		data := rtest.Random(i, size)
+		// make the data compressible
+		data = append(data, bytes.Repeat([]byte("restic"), size)...)
+		rtest.OK(t, tw.WriteHeader(&tar.Header{
+			Name: string(rune('a'+i)) + ".txt",
+			Mode: 0644,
+			Size: int64(len(data)),
+		}))
+		_, err := tw.Write(data)
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, tw.Close())
+	return buf.Bytes()
+}
+
+func compress(t testing.TB, data []byte, level int, name string) []byte {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	rtest.OK(t, err)
+	zw.Name = name
+	zw.ModTime = time.Unix(1600000000, 0)
+	_, err = zw.Write(data)
+	rtest.OK(t, err)
+	rtest.OK(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestRoundtrip(t *testing.T) {
+	data := testTar(t)
+
+	for _, level := range []int{gzip.DefaultCompression, gzip.BestSpeed, 4, gzip.BestCompression} {
+		orig := compress(t, data, level, "layer.tar")
+
+		enc, err := layer.Detect(bytes.NewReader(orig), layer.AllLevels)
+		rtest.OK(t, err)
+		rtest.Assert(t, enc != nil, "layer compressed with level %v not detected", level)
+		rtest.Equals(t, restic.Hash(orig), *enc.Hash)
+		rtest.Equals(t, layer.Format, enc.Format)
+		rtest.Equals(t, "layer.tar", enc.Name)
+		rtest.Equals(t, uint64(len(data)), enc.Size)
+
+		rd, err := layer.NewReader(bytes.NewReader(orig))
+		rtest.OK(t, err)
+		decoded, err := io.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, decoded)
+
+		var buf bytes.Buffer
+		wr, err := layer.NewWriter(&buf, enc)
+		rtest.OK(t, err)
+		_, err = wr.Write(decoded)
+		rtest.OK(t, err)
+		rtest.OK(t, wr.Close())
+		rtest.Assert(t, bytes.Equal(orig, buf.Bytes()), "recompressed data differs for level %v", level)
+	}
+}
+
+func TestDetectIgnored(t *testing.T) {
+	data := testTar(t)
+	orig := compress(t, data, gzip.DefaultCompression, "")
+
+	for name, buf := range map[string][]byte{
+		"empty":      {},
+		"plain tar":  data,
+		"gzip":       compress(t, rtest.Random(5, 100000), gzip.DefaultCompression, ""),
+		"truncated":  orig[:len(orig)/2],
+		"trailer":    append(append([]byte{}, orig...), 0),
+		"two stream": append(append([]byte{}, orig...), orig...),
+		"corrupt":    append(append(append([]byte{}, orig[:100]...), 0xff, 0xff, 0xff), orig[103:]...),
+	} {
+		enc, err := layer.Detect(bytes.NewReader(buf), layer.AllLevels)
+		rtest.OK(t, err)
+		rtest.Assert(t, enc == nil, "%v: unexpected content encoding %v", name, enc)
+	}
+}
+
+func TestDetectDefaultLevels(t *testing.T) {
+	data := testTar(t)
+
+	for _, level := range layer.DefaultLevels {
+		enc, err := layer.Detect(bytes.NewReader(compress(t, data, level, "")), layer.DefaultLevels)
+		rtest.OK(t, err)
+		rtest.Assert(t, enc != nil, "layer compressed with level %v not detected", level)
+	}
+
+	// other levels are only tried if requested
+	orig := compress(t, data, 4, "")
+	enc, err := layer.Detect(bytes.NewReader(orig), layer.DefaultLevels)
+	rtest.OK(t, err)
+	rtest.Assert(t, enc == nil, "unexpected content encoding %v", enc)
+}
+
+func TestWriterMismatch(t *testing.T) {
+	data := testTar(t)
+	orig := compress(t, data, gzip.DefaultCompression, "layer.tar")
+
+	enc, err := layer.Detect(bytes.NewReader(orig), layer.DefaultLevels)
+	rtest.OK(t, err)
+	rtest.Assert(t, enc != nil, "layer not detected")
+
+	// a different hash simulates a compressor which produces other output
+	id := restic.NewRandomID()
+	enc.Hash = &id
+
+	wr, err := layer.NewWriter(io.Discard, enc)
+	rtest.OK(t, err)
+	_, err = wr.Write(data)
+	rtest.OK(t, err)
+	rtest.Equals(t, layer.ErrMismatch, wr.Close())
+}
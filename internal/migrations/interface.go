@@ -22,3 +22,26 @@ type Migration interface {
 	// Descr returns a description what the migration does.
 	Desc() string
 }
+
+// Estimate describes the amount of data a migration rewrites.
+type Estimate struct {
+	// Bytes is the amount of data which is read and written again.
+	Bytes uint64
+	// Files is the number of repository files which are rewritten.
+	Files uint64
+}
+
+// Estimator is implemented by migrations which can estimate their cost
+// before they are applied. Estimate must only be called if Check returned
+// true.
+type Estimator interface {
+	Estimate(context.Context, restic.Repository) (Estimate, error)
+}
+
+// Dependent is implemented by migrations which must be applied after other
+// migrations.
+type Dependent interface {
+	// Dependencies returns the names of the migrations which must be applied
+	// first, if they are applicable.
+	Dependencies() []string
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"github.com/restic/restic/internal/errors"
+)
+
+// Dependencies returns the names of the migrations m depends on.
+func Dependencies(m Migration) []string {
+	if d, ok := m.(Dependent); ok {
+		return d.Dependencies()
+	}
+	return nil
+}
+
+// Sort returns the migrations ordered such that each migration follows the
+// migrations it depends on. Otherwise the order of ms is retained.
+// Dependencies which are not contained in ms are ignored.
+func Sort(ms []Migration) ([]Migration, error) {
+	byName := make(map[string]Migration, len(ms))
+	for _, m := range ms {
+		byName[m.Name()] = m
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(ms))
+	sorted := make([]Migration, 0, len(ms))
+
+	var visit func(m Migration) error
+	visit = func(m Migration) error {
+		switch state[m.Name()] {
+		case done:
+			return nil
+		case visiting:
+			return errors.Errorf("migration %v has a circular dependency", m.Name())
+		}
+
+		state[m.Name()] = visiting
+		for _, name := range Dependencies(m) {
+			dep, ok := byName[name]
+			if !ok {
+				continue
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[m.Name()] = done
+		sorted = append(sorted, m)
+		return nil
+	}
+
+	for _, m := range ms {
+		if err := visit(m); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
@@ -0,0 +1,71 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+type testMigration struct {
+	name string
+	deps []string
+}
+
+func (m testMigration) Check(context.Context, restic.Repository) (bool, string, error) {
+	return true, "", nil
+}
+func (m testMigration) RepoCheck() bool                                { return false }
+func (m testMigration) Apply(context.Context, restic.Repository) error { return nil }
+func (m testMigration) Name() string                                   { return m.name }
+func (m testMigration) Desc() string                                   { return "" }
+func (m testMigration) Dependencies() []string                         { return m.deps }
+
+func names(ms []Migration) []string {
+	var res []string
+	for _, m := range ms {
+		res = append(res, m.Name())
+	}
+	return res
+}
+
+func TestSort(t *testing.T) {
+	var tests = []struct {
+		ms   []Migration
+		want []string
+	}{
+		{
+			ms:   []Migration{testMigration{name: "a"}, testMigration{name: "b"}},
+			want: []string{"a", "b"},
+		},
+		{
+			ms: []Migration{
+				testMigration{name: "consolidate", deps: []string{"compress"}},
+				testMigration{name: "compress", deps: []string{"upgrade"}},
+				testMigration{name: "layout"},
+				testMigration{name: "upgrade"},
+			},
+			want: []string{"upgrade", "compress", "consolidate", "layout"},
+		},
+		{
+			// unknown dependencies are ignored
+			ms:   []Migration{testMigration{name: "a", deps: []string{"missing"}}},
+			want: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		sorted, err := Sort(tt.ms)
+		test.OK(t, err)
+		test.Equals(t, tt.want, names(sorted))
+	}
+}
+
+func TestSortCycle(t *testing.T) {
+	_, err := Sort([]Migration{
+		testMigration{name: "a", deps: []string{"b"}},
+		testMigration{name: "b", deps: []string{"a"}},
+	})
+	test.Assert(t, err != nil, "missing error for circular dependency")
+}
@@ -115,6 +115,13 @@ func (m *S3Layout) Apply(ctx context.Context, repo restic.Repository) error {
 		restic.PackFile,
 		restic.KeyFile,
 		restic.LockFile,
+		restic.AuditFile,
+		restic.CatalogFile,
+		restic.ParityFile,
+		restic.ScrubFile,
+		restic.DataKeyFile,
+		restic.SearchIndexFile,
+		restic.AuditHeadFile,
 	} {
 		err := m.moveFiles(ctx, be, newLayout, t)
 		if err != nil {
@@ -56,7 +56,15 @@ func (*UpgradeRepoV2) Check(ctx context.Context, repo restic.Repository) (bool,
 func (*UpgradeRepoV2) RepoCheck() bool {
 	return true
 }
-func (*UpgradeRepoV2) upgrade(ctx context.Context, repo restic.Repository) error {
+func (m *UpgradeRepoV2) Apply(ctx context.Context, repo restic.Repository) error {
+	return upgradeConfig(ctx, repo, "restic-migrate-upgrade-repo-v2-", func(cfg *restic.Config) {
+		cfg.Version = 2
+		cfg.AddCapability(restic.CapabilityCompression, false)
+	})
+}
+
+// replaceConfig overwrites the config of the repository with cfg.
+func replaceConfig(ctx context.Context, repo restic.Repository, cfg restic.Config) error {
 	h := restic.Handle{Type: restic.ConfigFile}
 
 	if !repo.Backend().HasAtomicReplace() {
@@ -67,10 +75,6 @@ func (*UpgradeRepoV2) upgrade(ctx context.Context, repo restic.Repository) error
 		}
 	}
 
-	// upgrade config
-	cfg := repo.Config()
-	cfg.Version = 2
-
 	err := restic.SaveConfig(ctx, repo, cfg)
 	if err != nil {
 		return fmt.Errorf("save new config file failed: %w", err)
@@ -79,8 +83,11 @@ func (*UpgradeRepoV2) upgrade(ctx context.Context, repo restic.Repository) error
 	return nil
 }
 
-func (m *UpgradeRepoV2) Apply(ctx context.Context, repo restic.Repository) error {
-	tempdir, err := os.MkdirTemp("", "restic-migrate-upgrade-repo-v2-")
+// upgradeConfig replaces the config of the repository by the config modified
+// by upgrade. A backup of the original config file is kept in a temporary
+// directory until the new config was saved successfully.
+func upgradeConfig(ctx context.Context, repo restic.Repository, tempPrefix string, upgrade func(cfg *restic.Config)) error {
+	tempdir, err := os.MkdirTemp("", tempPrefix)
 	if err != nil {
 		return fmt.Errorf("create temp dir failed: %w", err)
 	}
@@ -104,7 +111,9 @@ func (m *UpgradeRepoV2) Apply(ctx context.Context, repo restic.Repository) error
 	}
 
 	// run the upgrade
-	err = m.upgrade(ctx, repo)
+	cfg := repo.Config()
+	upgrade(&cfg)
+	err = replaceConfig(ctx, repo, cfg)
 	if err != nil {
 
 		// build an error we can return to the caller
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&UpgradeRepoV3{})
+}
+
+// UpgradeRepoV3 upgrades a repository to version 3, which enables all
+// capabilities used by routine commands. Versions of restic which do not know
+// about the capabilities refuse to open the upgraded repository.
+type UpgradeRepoV3 struct{}
+
+func (*UpgradeRepoV3) Name() string {
+	return "upgrade_repo_v3"
+}
+
+func (*UpgradeRepoV3) Desc() string {
+	return "upgrade a repository to version 3, required for protected snapshots, data keys and file bundles"
+}
+
+func (*UpgradeRepoV3) Check(ctx context.Context, repo restic.Repository) (bool, string, error) {
+	switch v := repo.Config().Version; {
+	case v < 2:
+		return false, "repository must be upgraded to version 2 first", nil
+	case v >= restic.CapabilitiesRepoVersion:
+		return false, fmt.Sprintf("repository is already upgraded to version %v", v), nil
+	}
+	return true, "", nil
+}
+
+func (*UpgradeRepoV3) RepoCheck() bool {
+	return false
+}
+
+func (*UpgradeRepoV3) Dependencies() []string {
+	return []string{"upgrade_repo_v2"}
+}
+
+func (*UpgradeRepoV3) Apply(ctx context.Context, repo restic.Repository) error {
+	return upgradeConfig(ctx, repo, "restic-migrate-upgrade-repo-v3-", func(cfg *restic.Config) {
+		cfg.UpgradeToVersion3()
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestUpgradeRepoV3(t *testing.T) {
+	m := &UpgradeRepoV3{}
+
+	ok, _, err := m.Check(context.TODO(), repository.TestRepositoryWithVersion(t, 1))
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "version 1 repositories must be upgraded to version 2 first")
+
+	repo := repository.TestRepositoryWithVersion(t, 2)
+	ok, _, err = m.Check(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "migration check returned false")
+	rtest.OK(t, m.Apply(context.TODO(), repo))
+
+	cfg, err := restic.LoadConfig(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(restic.CapabilitiesRepoVersion), cfg.Version)
+	for _, name := range []string{restic.CapabilityCompression, restic.CapabilityProtectedSnapshots, restic.CapabilityDataKeys, restic.CapabilityFileBundles, restic.CapabilityContentEncoding} {
+		rtest.Assert(t, cfg.HasCapability(name), "capability %v missing", name)
+	}
+	rtest.OK(t, cfg.RequireCapability(restic.CapabilityProtectedSnapshots, "test"))
+	rtest.Equals(t, []string{restic.CapabilityProtectedSnapshots}, cfg.WriteCapabilities)
+}
@@ -203,6 +203,14 @@ func (o Options) Apply(ns string, dst interface{}) error {
 
 			v.Field(i).SetBool(vi)
 
+		case "float64":
+			vf, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return err
+			}
+
+			v.Field(i).SetFloat(vf)
+
 		case "Duration":
 			d, err := time.ParseDuration(value)
 			if err != nil {
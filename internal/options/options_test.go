@@ -126,6 +126,7 @@ type Target struct {
 	ID      int           `option:"id"`
 	Timeout time.Duration `option:"timeout"`
 	Switch  bool          `option:"switch"`
+	Ratio   float64       `option:"ratio"`
 	Other   string
 }
 
@@ -167,6 +168,14 @@ var setTests = []struct {
 			Switch: true,
 		},
 	},
+	{
+		Options{
+			"ratio": "0.25",
+		},
+		Target{
+			Ratio: 0.25,
+		},
+	},
 }
 
 func TestOptionsApply(t *testing.T) {
@@ -218,6 +227,13 @@ var invalidSetTests = []struct {
 		"ns",
 		`strconv.ParseBool: parsing "yes": invalid syntax`,
 	},
+	{
+		Options{
+			"ratio": "half",
+		},
+		"ns",
+		`strconv.ParseFloat: parsing "half": invalid syntax`,
+	},
 }
 
 func TestOptionsApplyInvalid(t *testing.T) {
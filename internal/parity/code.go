@@ -0,0 +1,164 @@
+// Package parity implements a systematic Reed-Solomon erasure code and the
+// format of the parity files which allow restoring damaged pack files.
+package parity
+
+import (
+	"github.com/restic/restic/internal/errors"
+)
+
+// MaxShards is the maximum number of data and parity shards of a code.
+const MaxShards = 256
+
+// Code is a systematic Reed-Solomon erasure code. The data is split into a
+// number of data shards, from which the parity shards are computed. Any
+// combination of up to the number of parity shards missing shards can be
+// reconstructed from the remaining ones.
+type Code struct {
+	data, parity int
+	// matrix is the (data+parity) x data encoding matrix. The first data
+	// rows form the identity matrix, the remaining rows are a Cauchy matrix,
+	// which guarantees that every square submatrix is invertible.
+	matrix [][]byte
+}
+
+// New returns a code with the given number of data and parity shards.
+func New(data, parity int) (*Code, error) {
+	if data < 1 || parity < 1 {
+		return nil, errors.Errorf("invalid number of shards %d+%d, at least one data and one parity shard are required", data, parity)
+	}
+	if data+parity > MaxShards {
+		return nil, errors.Errorf("invalid number of shards %d+%d, at most %d shards are supported", data, parity, MaxShards)
+	}
+
+	c := &Code{data: data, parity: parity}
+	c.matrix = make([][]byte, data+parity)
+	for i := range c.matrix {
+		c.matrix[i] = make([]byte, data)
+		if i < data {
+			c.matrix[i][i] = 1
+			continue
+		}
+		for j := range c.matrix[i] {
+			// x_i = i and y_j = j are distinct for all rows and columns
+			c.matrix[i][j] = gfInv(byte(i) ^ byte(j))
+		}
+	}
+	return c, nil
+}
+
+// DataShards returns the number of data shards.
+func (c *Code) DataShards() int {
+	return c.data
+}
+
+// ParityShards returns the number of parity shards.
+func (c *Code) ParityShards() int {
+	return c.parity
+}
+
+func (c *Code) shardSize(shards [][]byte) (int, error) {
+	if len(shards) != c.data+c.parity {
+		return 0, errors.Errorf("wrong number of shards, want %d, got %d", c.data+c.parity, len(shards))
+	}
+	size := -1
+	for _, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if size >= 0 && len(shard) != size {
+			return 0, errors.New("shards have different sizes")
+		}
+		size = len(shard)
+	}
+	if size < 0 {
+		return 0, errors.New("all shards are missing")
+	}
+	return size, nil
+}
+
+// Encode computes the parity shards from the data shards. The first
+// DataShards() entries of shards are the data, the remaining ones are
+// overwritten with the parity. Parity shards which are nil are allocated.
+func (c *Code) Encode(shards [][]byte) error {
+	for _, shard := range shards[:c.data] {
+		if shard == nil {
+			return errors.New("data shard is missing")
+		}
+	}
+	size, err := c.shardSize(shards)
+	if err != nil {
+		return err
+	}
+
+	for i := c.data; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+		}
+		c.encodeRow(shards[i], c.matrix[i], shards[:c.data])
+	}
+	return nil
+}
+
+// encodeRow sets dst to the linear combination of the shards with the
+// coefficients in row.
+func (c *Code) encodeRow(dst []byte, row []byte, shards [][]byte) {
+	for i := range dst {
+		dst[i] = 0
+	}
+	for j, shard := range shards {
+		mulAdd(dst, shard, row[j])
+	}
+}
+
+// Reconstruct restores the missing shards, which are marked by a nil entry in
+// shards. At most ParityShards() shards may be missing.
+func (c *Code) Reconstruct(shards [][]byte) error {
+	size, err := c.shardSize(shards)
+	if err != nil {
+		return err
+	}
+
+	var avail []int
+	missingData := false
+	for i, shard := range shards {
+		if shard != nil {
+			avail = append(avail, i)
+		} else if i < c.data {
+			missingData = true
+		}
+	}
+	if len(avail) < c.data {
+		return errors.Errorf("too many missing shards, %d of %d are available but %d are required", len(avail), len(shards), c.data)
+	}
+
+	if missingData {
+		// the data can be computed from any DataShards() rows of the encoding matrix
+		avail = avail[:c.data]
+		sub := make([][]byte, c.data)
+		input := make([][]byte, c.data)
+		for i, idx := range avail {
+			sub[i] = c.matrix[idx]
+			input[i] = shards[idx]
+		}
+		decode, ok := invertMatrix(sub)
+		if !ok {
+			// cannot happen for a Cauchy matrix
+			return errors.New("encoding matrix is singular")
+		}
+
+		for i := 0; i < c.data; i++ {
+			if shards[i] == nil {
+				shards[i] = make([]byte, size)
+				c.encodeRow(shards[i], decode[i], input)
+			}
+		}
+	}
+
+	for i := c.data; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			c.encodeRow(shards[i], c.matrix[i], shards[:c.data])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package parity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// A parity file contains the parity shards for the content of a single pack
+// file, which forms the data shards. It consists of a header, the SHA-256
+// hashes of all data and parity shards, which allow detecting the damaged
+// shards, a SHA-256 hash of everything before it and finally the parity
+// shards:
+//
+//	magic (4) | version (1) | data shards - 1 (1) | parity shards - 1 (1) |
+//	reserved (1) | pack size (8) | shard hashes (32 each) | checksum (32) |
+//	parity shards
+//
+// Integers are encoded in little endian. The last data shard is padded
+// with zeros to the size of the other shards.
+
+var fileMagic = []byte("RPAR")
+
+const (
+	fileVersion    = 1
+	fileHeaderSize = 16
+	hashSize       = sha256.Size
+)
+
+// ErrTooManyDamagedShards is returned by Repair if the data cannot be restored.
+var ErrTooManyDamagedShards = errors.New("too many damaged shards")
+
+func shardSize(dataSize int, dataShards int) int {
+	size := (dataSize + dataShards - 1) / dataShards
+	if size == 0 {
+		size = 1
+	}
+	return size
+}
+
+// splitData splits data into the data shards for the code, the last one is
+// padded with zeros if necessary. The shards share the backing array of data
+// where possible.
+func splitData(data []byte, dataShards int, size int) [][]byte {
+	shards := make([][]byte, dataShards)
+	for i := range shards {
+		start, end := i*size, (i+1)*size
+		switch {
+		case end <= len(data):
+			shards[i] = data[start:end]
+		case start < len(data):
+			shards[i] = make([]byte, size)
+			copy(shards[i], data[start:])
+		default:
+			shards[i] = make([]byte, size)
+		}
+	}
+	return shards
+}
+
+// Create returns the parity file for data using code.
+func Create(code *Code, data []byte) ([]byte, error) {
+	size := shardSize(len(data), code.data)
+	shards := append(splitData(data, code.data, size), make([][]byte, code.parity)...)
+	if err := code.Encode(shards); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, fileHeaderSize+(len(shards)+1)*hashSize+code.parity*size)
+	buf = append(buf, fileMagic...)
+	buf = append(buf, fileVersion, byte(code.data-1), byte(code.parity-1), 0)
+	var sizeBuf [8]byte
+	binary.LittleEndian.PutUint64(sizeBuf[:], uint64(len(data)))
+	buf = append(buf, sizeBuf[:]...)
+	for _, shard := range shards {
+		hash := sha256.Sum256(shard)
+		buf = append(buf, hash[:]...)
+	}
+	checksum := sha256.Sum256(buf)
+	buf = append(buf, checksum[:]...)
+	for _, shard := range shards[code.data:] {
+		buf = append(buf, shard...)
+	}
+	return buf, nil
+}
+
+// Info describes a parity file.
+type Info struct {
+	DataShards   int
+	ParityShards int
+	// DataSize is the size of the data the parity file was created for.
+	DataSize int
+	// ShardSize is the size of each data and parity shard.
+	ShardSize int
+}
+
+type file struct {
+	Info
+	hashes [][]byte
+	parity [][]byte
+}
+
+func parseFile(buf []byte) (file, error) {
+	if len(buf) < fileHeaderSize || !bytes.Equal(buf[:len(fileMagic)], fileMagic) {
+		return file{}, errors.New("invalid parity file, magic is missing")
+	}
+	if buf[4] != fileVersion {
+		return file{}, errors.Errorf("unsupported parity file version %d", buf[4])
+	}
+
+	var f file
+	f.DataShards = int(buf[5]) + 1
+	f.ParityShards = int(buf[6]) + 1
+	if f.DataShards+f.ParityShards > MaxShards {
+		return file{}, errors.New("invalid parity file, too many shards")
+	}
+	dataSize := binary.LittleEndian.Uint64(buf[8:16])
+	if dataSize > uint64(len(buf))*uint64(f.DataShards) {
+		return file{}, errors.New("invalid parity file, data size is too large")
+	}
+	f.DataSize = int(dataSize)
+	f.ShardSize = shardSize(f.DataSize, f.DataShards)
+
+	numShards := f.DataShards + f.ParityShards
+	hashesEnd := fileHeaderSize + numShards*hashSize
+	if len(buf) != hashesEnd+hashSize+f.ParityShards*f.ShardSize {
+		return file{}, errors.Errorf("invalid parity file, unexpected size %d", len(buf))
+	}
+	checksum := sha256.Sum256(buf[:hashesEnd])
+	if !bytes.Equal(checksum[:], buf[hashesEnd:hashesEnd+hashSize]) {
+		return file{}, errors.New("invalid parity file, checksum does not match")
+	}
+
+	for i := 0; i < numShards; i++ {
+		start := fileHeaderSize + i*hashSize
+		f.hashes = append(f.hashes, buf[start:start+hashSize])
+	}
+	start := hashesEnd + hashSize
+	for i := 0; i < f.ParityShards; i++ {
+		f.parity = append(f.parity, buf[start:start+f.ShardSize])
+		start += f.ShardSize
+	}
+	return f, nil
+}
+
+// Parse returns the description of the parity file buf.
+func Parse(buf []byte) (Info, error) {
+	f, err := parseFile(buf)
+	return f.Info, err
+}
+
+// Repair restores data using the parity file buf. data may be damaged
+// anywhere, truncated or contain additional bytes at the end. Damaged shards
+// are detected by comparing their hashes to those stored in the parity file.
+// Repair also returns the number of damaged shards, including the parity
+// shards. If more shards are damaged than the parity file contains parity
+// shards, the error is ErrTooManyDamagedShards.
+func Repair(buf []byte, data []byte) ([]byte, int, error) {
+	f, err := parseFile(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(data) > f.DataSize {
+		data = data[:f.DataSize]
+	}
+	complete := len(data) == f.DataSize
+	shards := splitData(data, f.DataShards, f.ShardSize)
+	shards = append(shards, f.parity...)
+
+	damaged := 0
+	for i, shard := range shards {
+		hash := sha256.Sum256(shard)
+		if !bytes.Equal(hash[:], f.hashes[i]) {
+			shards[i] = nil
+			damaged++
+		}
+	}
+
+	dataDamaged := false
+	for _, shard := range shards[:f.DataShards] {
+		if shard == nil {
+			dataDamaged = true
+		}
+	}
+	if !dataDamaged && complete {
+		return data, damaged, nil
+	}
+
+	if damaged > f.ParityShards {
+		return nil, damaged, ErrTooManyDamagedShards
+	}
+	code, err := New(f.DataShards, f.ParityShards)
+	if err != nil {
+		return nil, damaged, err
+	}
+	if err := code.Reconstruct(shards); err != nil {
+		return nil, damaged, err
+	}
+
+	res := make([]byte, 0, f.DataShards*f.ShardSize)
+	for _, shard := range shards[:f.DataShards] {
+		res = append(res, shard...)
+	}
+	return res[:f.DataSize], damaged, nil
+}
@@ -0,0 +1,103 @@
+package parity
+
+// The code works on the Galois field GF(2^8) generated by the polynomial
+// x^8 + x^4 + x^3 + x^2 + 1 (0x11d), as most Reed-Solomon implementations do.
+// Addition and subtraction are both XOR.
+const fieldPolynomial = 0x11d
+
+var (
+	// expTable is twice as long as necessary so that the sum of two
+	// logarithms can be used as an index without reducing it modulo 255.
+	expTable [2 * 255]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fieldPolynomial
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// gfInv returns the multiplicative inverse of a, which must not be zero.
+func gfInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// mulAdd adds c*src to dst, both must have the same length.
+func mulAdd(dst, src []byte, c byte) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i, s := range src {
+			dst[i] ^= s
+		}
+		return
+	}
+
+	var table [256]byte
+	for i := range table {
+		table[i] = gfMul(c, byte(i))
+	}
+	for i, s := range src {
+		dst[i] ^= table[s]
+	}
+}
+
+// invertMatrix returns the inverse of the square matrix m, or false if it is
+// singular. m is not modified.
+func invertMatrix(m [][]byte) ([][]byte, bool) {
+	n := len(m)
+	// work on the augmented matrix [m | I]
+	work := make([][]byte, n)
+	for i := range work {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, false
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		inv := gfInv(work[col][col])
+		for i := range work[col] {
+			work[col][i] = gfMul(work[col][i], inv)
+		}
+
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				mulAdd(work[row], work[col], work[row][col])
+			}
+		}
+	}
+
+	res := make([][]byte, n)
+	for i := range res {
+		res[i] = work[i][n:]
+	}
+	return res, true
+}
@@ -0,0 +1,136 @@
+package parity_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/restic/restic/internal/parity"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func randomData(rnd *rand.Rand, size int) []byte {
+	buf := make([]byte, size)
+	_, _ = rnd.Read(buf)
+	return buf
+}
+
+func TestCodeReconstruct(t *testing.T) {
+	rnd := rand.New(rand.NewSource(23))
+
+	for _, test := range []struct {
+		data, parity int
+	}{
+		{1, 1}, {4, 2}, {10, 3}, {20, 1}, {200, 56},
+	} {
+		code, err := parity.New(test.data, test.parity)
+		rtest.OK(t, err)
+
+		shards := make([][]byte, test.data+test.parity)
+		for i := 0; i < test.data; i++ {
+			shards[i] = randomData(rnd, 100)
+		}
+		rtest.OK(t, code.Encode(shards))
+
+		orig := make([][]byte, len(shards))
+		copy(orig, shards)
+
+		for run := 0; run < 20; run++ {
+			damaged := make([][]byte, len(shards))
+			copy(damaged, shards)
+			for _, i := range rnd.Perm(len(shards))[:test.parity] {
+				damaged[i] = nil
+			}
+
+			rtest.OK(t, code.Reconstruct(damaged))
+			for i := range orig {
+				rtest.Assert(t, bytes.Equal(orig[i], damaged[i]), "%d+%d: shard %d was not restored correctly", test.data, test.parity, i)
+			}
+		}
+
+		if test.data > 1 {
+			for i := 0; i <= test.parity; i++ {
+				shards[i] = nil
+			}
+			err = code.Reconstruct(shards)
+			rtest.Assert(t, err != nil, "%d+%d: reconstructing too many missing shards did not fail", test.data, test.parity)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, test := range [][2]int{{0, 1}, {1, 0}, {200, 57}} {
+		_, err := parity.New(test[0], test[1])
+		rtest.Assert(t, err != nil, "expected error for %d+%d shards", test[0], test[1])
+	}
+}
+
+func TestRepair(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	code, err := parity.New(20, 1)
+	rtest.OK(t, err)
+
+	data := randomData(rnd, 123457)
+	file, err := parity.Create(code, data)
+	rtest.OK(t, err)
+
+	info, err := parity.Parse(file)
+	rtest.OK(t, err)
+	rtest.Equals(t, parity.Info{DataShards: 20, ParityShards: 1, DataSize: len(data), ShardSize: 6173}, info)
+
+	clone := func() []byte {
+		return append([]byte{}, data...)
+	}
+
+	for _, test := range []struct {
+		name    string
+		damage  func() []byte
+		damaged int
+	}{
+		{"intact", clone, 0},
+		{"bitflip", func() []byte {
+			buf := clone()
+			buf[4711] ^= 0x10
+			return buf
+		}, 1},
+		{"overwritten", func() []byte {
+			buf := clone()
+			copy(buf[6173*3:6173*4], make([]byte, 6173))
+			return buf
+		}, 1},
+		{"truncated", func() []byte {
+			return clone()[:len(data)-100]
+		}, 1},
+		{"appended", func() []byte {
+			return append(clone(), 1, 2, 3)
+		}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res, damaged, err := parity.Repair(file, test.damage())
+			rtest.OK(t, err)
+			rtest.Equals(t, test.damaged, damaged)
+			rtest.Assert(t, bytes.Equal(data, res), "data was not restored")
+		})
+	}
+
+	buf := clone()
+	buf[10] ^= 1
+	buf[len(buf)-10] ^= 1
+	_, damaged, err := parity.Repair(file, buf)
+	rtest.Assert(t, err == parity.ErrTooManyDamagedShards, "unexpected error %v", err)
+	rtest.Equals(t, 2, damaged)
+
+	// a damaged parity shard is detected as well
+	damagedFile := append([]byte{}, file...)
+	damagedFile[len(damagedFile)-1] ^= 1
+	buf = clone()
+	buf[10] ^= 1
+	_, _, err = parity.Repair(damagedFile, buf)
+	rtest.Assert(t, err == parity.ErrTooManyDamagedShards, "unexpected error %v", err)
+
+	// the header is protected by a checksum
+	damagedFile = append([]byte{}, file...)
+	damagedFile[20] ^= 1
+	_, err = parity.Parse(damagedFile)
+	rtest.Assert(t, err != nil, "damaged header was not detected")
+}
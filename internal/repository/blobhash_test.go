@@ -0,0 +1,78 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/blake3"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestBlobHashBLAKE3(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	be := mem.New()
+	repo, err := repository.New(be, repository.Options{BlobHash: restic.BlobHashBLAKE3})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, rtest.TestPassword, nil))
+	rtest.Equals(t, restic.BlobHashBLAKE3, repo.Config().BlobHash)
+	rtest.Assert(t, repo.Config().HasCapability(restic.CapabilityBlobHash), "blob hash capability missing")
+	rtest.Equals(t, uint(restic.CapabilitiesRepoVersion), repo.Config().Version)
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+
+	data := rtest.Random(23, 5000)
+	zero := make([]byte, chunker.MinSize)
+	ids := make(restic.IDs, 0, 2)
+	for _, buf := range [][]byte{data, zero} {
+		id, _, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+		rtest.Equals(t, restic.ID(blake3.Sum256(buf)), id)
+		ids = append(ids, id)
+	}
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	// the algorithm is loaded from the config
+	repo, err = repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	rtest.Equals(t, restic.BlobHashBLAKE3, repo.Config().BlobHash)
+
+	buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, ids[0], nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	pbs := repo.Index().Lookup(restic.BlobHandle{ID: ids[1], Type: restic.DataBlob})
+	rtest.Equals(t, 1, len(pbs))
+	err = repository.StreamPack(context.TODO(), repo.Backend().Load, repo.Key(), nil, repo.Config().HashBlob, pbs[0].PackID, []restic.Blob{pbs[0].Blob},
+		func(h restic.BlobHandle, buf []byte, err error) error {
+			rtest.OK(t, err)
+			rtest.Equals(t, zero, buf)
+			return nil
+		})
+	rtest.OK(t, err)
+
+	// the IDs of the pack files are still computed using SHA-256
+	rtest.OK(t, repo.List(context.TODO(), restic.PackFile, func(id restic.ID, _ int64) error {
+		buf, err := backend.LoadAll(context.TODO(), nil, be, restic.Handle{Type: restic.PackFile, Name: id.String()})
+		rtest.OK(t, err)
+		rtest.Equals(t, id, restic.Hash(buf))
+		return nil
+	}))
+}
+
+func TestBlobHashInvalid(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	repo, err := repository.New(mem.New(), repository.Options{BlobHash: "md5"})
+	rtest.OK(t, err)
+	err = repo.Init(context.TODO(), restic.StableRepoVersion, rtest.TestPassword, nil)
+	rtest.Assert(t, err != nil, "expected error for unknown blob hash")
+}
@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// DataKeyOverhead is the number of bytes by which the contents of a data
+// blob grow by encrypting them with a data key.
+const DataKeyOverhead = crypto.Extension
+
+// ErrDataKeyErased is returned when loading a blob whose data key was erased.
+var ErrDataKeyErased = errors.New("the data key of the blob was erased")
+
+// dataKeyFile is stored as restic.DataKeyFile. It contains data keys and the
+// IDs of the blobs encrypted with them. A key is listed in every file which
+// was written by an operation that used the key. Erased keys are still listed
+// with their blobs, but without the key itself.
+type dataKeyFile struct {
+	Keys []dataKeyEntry `json:"keys"`
+}
+
+type dataKeyEntry struct {
+	ID    restic.ID   `json:"id"`
+	Key   *crypto.Key `json:"key,omitempty"`
+	Blobs restic.IDs  `json:"blobs,omitempty"`
+}
+
+// dataKeys caches the data keys of the repository.
+type dataKeys struct {
+	loaded bool
+	// keys maps the ID of a data key to the key, which is nil once erased
+	keys map[restic.ID]*crypto.Key
+	// blobs maps the ID of a blob to the ID of the key it is encrypted with
+	blobs map[restic.ID]restic.ID
+	// files lists the keys contained in each data key file
+	files map[restic.ID]restic.IDSet
+	// pending contains the keys and blobs which are not stored yet
+	pending map[restic.ID]*dataKeyEntry
+}
+
+func newDataKeys() dataKeys {
+	return dataKeys{
+		keys:    make(map[restic.ID]*crypto.Key),
+		blobs:   make(map[restic.ID]restic.ID),
+		files:   make(map[restic.ID]restic.IDSet),
+		pending: make(map[restic.ID]*dataKeyEntry),
+	}
+}
+
+func (dk *dataKeys) add(fileID restic.ID, f *dataKeyFile) {
+	keys := restic.NewIDSet()
+	for i := range f.Keys {
+		e := &f.Keys[i]
+		keys.Insert(e.ID)
+		if e.Key != nil {
+			dk.keys[e.ID] = e.Key
+		} else if _, ok := dk.keys[e.ID]; !ok {
+			dk.keys[e.ID] = nil
+		}
+		for _, id := range e.Blobs {
+			dk.blobs[id] = e.ID
+		}
+	}
+	dk.files[fileID] = keys
+}
+
+// loadDataKeys loads all data key files, the caller must hold dataKeysMu.
+func (r *Repository) loadDataKeys(ctx context.Context) error {
+	if r.dataKeys.loaded {
+		return nil
+	}
+	if !r.cfg.HasCapability(restic.CapabilityDataKeys) {
+		// no file uses data keys
+		r.dataKeys.loaded = true
+		return nil
+	}
+
+	var ids restic.IDs
+	err := r.List(ctx, restic.DataKeyFile, func(id restic.ID, _ int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var f dataKeyFile
+		if err := restic.LoadJSONUnpacked(ctx, r, restic.DataKeyFile, id, &f); err != nil {
+			return errors.Wrapf(err, "loading data keys %v", id.Str())
+		}
+		r.dataKeys.add(id, &f)
+	}
+
+	debug.Log("loaded %d data keys from %d files", len(r.dataKeys.keys), len(ids))
+	r.dataKeys.loaded = true
+	return nil
+}
+
+// NewDataKey generates a new random data key and returns its ID. The key is
+// stored when the repository is flushed.
+func (r *Repository) NewDataKey(ctx context.Context) (restic.ID, error) {
+	r.dataKeysMu.Lock()
+	defer r.dataKeysMu.Unlock()
+
+	if err := r.loadDataKeys(ctx); err != nil {
+		return restic.ID{}, err
+	}
+
+	id := restic.NewRandomID()
+	key := crypto.NewRandomKey()
+	r.dataKeys.keys[id] = key
+	r.dataKeys.pending[id] = &dataKeyEntry{ID: id, Key: key}
+	return id, nil
+}
+
+// HasDataKey returns true if the data key exists.
+func (r *Repository) HasDataKey(ctx context.Context, id restic.ID) (bool, error) {
+	r.dataKeysMu.Lock()
+	defer r.dataKeysMu.Unlock()
+
+	if err := r.loadDataKeys(ctx); err != nil {
+		return false, err
+	}
+	return r.dataKeys.keys[id] != nil, nil
+}
+
+// SealDataBlob encrypts the contents of a data blob with the data key. The
+// result is deterministic, identical contents encrypted with the same key are
+// deduplicated. The returned data must be saved as a data blob.
+func (r *Repository) SealDataBlob(ctx context.Context, keyID restic.ID, data []byte) ([]byte, error) {
+	r.dataKeysMu.Lock()
+	defer r.dataKeysMu.Unlock()
+
+	if err := r.loadDataKeys(ctx); err != nil {
+		return nil, err
+	}
+	key := r.dataKeys.keys[keyID]
+	if key == nil {
+		return nil, errors.Errorf("data key %v not found", keyID.Str())
+	}
+
+	buf := sealDataBlob(key, data)
+	id := r.cfg.HashBlob(buf)
+	if _, ok := r.dataKeys.blobs[id]; ok {
+		return buf, nil
+	}
+
+	r.dataKeys.blobs[id] = keyID
+	e, ok := r.dataKeys.pending[keyID]
+	if !ok {
+		e = &dataKeyEntry{ID: keyID, Key: key}
+		r.dataKeys.pending[keyID] = e
+	}
+	e.Blobs = append(e.Blobs, id)
+	return buf, nil
+}
+
+// sealDataBlob encrypts data with a nonce derived from the data.
+func sealDataBlob(key *crypto.Key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key.EncryptionKey[:])
+	_, _ = mac.Write(data)
+	nonce := mac.Sum(nil)[:key.NonceSize()]
+
+	buf := make([]byte, 0, len(data)+DataKeyOverhead)
+	buf = append(buf, nonce...)
+	return key.Seal(buf, nonce, data, nil)
+}
+
+// OpenDataBlob decrypts the contents of the data blob id, which was loaded
+// from the repository. Blobs which are not encrypted with a data key are
+// returned unchanged.
+func (r *Repository) OpenDataBlob(ctx context.Context, id restic.ID, buf []byte) ([]byte, error) {
+	key, ok, err := r.lookupDataKey(ctx, id)
+	if err != nil || !ok {
+		return buf, err
+	}
+	if key == nil {
+		return nil, ErrDataKeyErased
+	}
+
+	if len(buf) < DataKeyOverhead {
+		return nil, errors.Errorf("blob %v is too short for a data key", id.Str())
+	}
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.Errorf("decrypting blob %v with data key failed: %v", id.Str(), err)
+	}
+	return plaintext, nil
+}
+
+// lookupDataKey returns the data key of the blob, the key is nil if it was
+// erased.
+func (r *Repository) lookupDataKey(ctx context.Context, id restic.ID) (*crypto.Key, bool, error) {
+	r.dataKeysMu.Lock()
+	defer r.dataKeysMu.Unlock()
+
+	if err := r.loadDataKeys(ctx); err != nil {
+		return nil, false, err
+	}
+	keyID, ok := r.dataKeys.blobs[id]
+	if !ok {
+		return nil, false, nil
+	}
+	return r.dataKeys.keys[keyID], true, nil
+}
+
+// flushDataKeys saves the pending data keys.
+func (r *Repository) flushDataKeys(ctx context.Context) error {
+	r.dataKeysMu.Lock()
+	defer r.dataKeysMu.Unlock()
+
+	if len(r.dataKeys.pending) == 0 {
+		return nil
+	}
+
+	f := &dataKeyFile{}
+	for _, e := range r.dataKeys.pending {
+		f.Keys = append(f.Keys, *e)
+	}
+	id, err := restic.SaveJSONUnpacked(ctx, r, restic.DataKeyFile, f)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("saved %d data keys as %v", len(f.Keys), id.Str())
+	r.dataKeys.add(id, f)
+	r.dataKeys.pending = make(map[restic.ID]*dataKeyEntry)
+	return nil
+}
+
+// EraseDataKeys removes the data keys from all data key files, which are
+// rewritten. Afterwards the blobs encrypted with the keys cannot be read
+// anymore, even though they remain in the repository until they are pruned.
+// It returns the number of data key files which were replaced.
+func (r *Repository) EraseDataKeys(ctx context.Context, keys restic.IDSet) (int, error) {
+	if err := r.flushDataKeys(ctx); err != nil {
+		return 0, err
+	}
+
+	r.dataKeysMu.Lock()
+	defer r.dataKeysMu.Unlock()
+
+	if err := r.loadDataKeys(ctx); err != nil {
+		return 0, err
+	}
+
+	// the replacements are added to files, thus collect the files first
+	var affected restic.IDs
+	for fileID, fileKeys := range r.dataKeys.files {
+		if len(fileKeys.Intersect(keys)) > 0 {
+			affected = append(affected, fileID)
+		}
+	}
+
+	removed := 0
+	for _, fileID := range affected {
+		var f dataKeyFile
+		if err := restic.LoadJSONUnpacked(ctx, r, restic.DataKeyFile, fileID, &f); err != nil {
+			return removed, errors.Wrapf(err, "loading data keys %v", fileID.Str())
+		}
+
+		remaining := &dataKeyFile{}
+		for _, e := range f.Keys {
+			if keys.Has(e.ID) {
+				if len(e.Blobs) == 0 {
+					continue
+				}
+				// keep the blobs, loading them must fail
+				e.Key = nil
+			}
+			remaining.Keys = append(remaining.Keys, e)
+		}
+		if len(remaining.Keys) > 0 {
+			id, err := restic.SaveJSONUnpacked(ctx, r, restic.DataKeyFile, remaining)
+			if err != nil {
+				return removed, err
+			}
+			r.dataKeys.add(id, remaining)
+		}
+
+		err := r.be.Remove(ctx, restic.Handle{Type: restic.DataKeyFile, Name: fileID.String()})
+		if err != nil {
+			return removed, err
+		}
+		delete(r.dataKeys.files, fileID)
+		removed++
+	}
+
+	for id := range keys {
+		if _, ok := r.dataKeys.keys[id]; ok {
+			r.dataKeys.keys[id] = nil
+		}
+	}
+	return removed, nil
+}
+
+// CopyDataKeys adds the data keys of the blobs from src, such that the blobs
+// can be read after copying them. It returns the number of copied keys.
+func (r *Repository) CopyDataKeys(ctx context.Context, src *Repository, blobs restic.BlobSet) (int, error) {
+	src.dataKeysMu.Lock()
+	if err := src.loadDataKeys(ctx); err != nil {
+		src.dataKeysMu.Unlock()
+		return 0, err
+	}
+	entries := make(map[restic.ID]*dataKeyEntry)
+	for h := range blobs {
+		if h.Type != restic.DataBlob {
+			continue
+		}
+		keyID, ok := src.dataKeys.blobs[h.ID]
+		if !ok {
+			continue
+		}
+		e, ok := entries[keyID]
+		if !ok {
+			e = &dataKeyEntry{ID: keyID, Key: src.dataKeys.keys[keyID]}
+			entries[keyID] = e
+		}
+		e.Blobs = append(e.Blobs, h.ID)
+	}
+	src.dataKeysMu.Unlock()
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	// older versions of restic must not read the encrypted contents
+	if err := r.cfg.RequireCapability(restic.CapabilityDataKeys, "copying data keys"); err != nil {
+		return 0, err
+	}
+
+	r.dataKeysMu.Lock()
+	defer r.dataKeysMu.Unlock()
+
+	if err := r.loadDataKeys(ctx); err != nil {
+		return 0, err
+	}
+	for keyID, e := range entries {
+		if r.dataKeys.keys[keyID] == nil {
+			r.dataKeys.keys[keyID] = e.Key
+		}
+		pending, ok := r.dataKeys.pending[keyID]
+		if !ok {
+			pending = &dataKeyEntry{ID: keyID, Key: e.Key}
+			r.dataKeys.pending[keyID] = pending
+		}
+		for _, id := range e.Blobs {
+			if _, ok := r.dataKeys.blobs[id]; ok {
+				continue
+			}
+			r.dataKeys.blobs[id] = keyID
+			pending.Blobs = append(pending.Blobs, id)
+		}
+	}
+	return len(entries), nil
+}
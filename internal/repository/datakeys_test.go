@@ -0,0 +1,92 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func saveSealedBlob(t *testing.T, repo *repository.Repository, keyID restic.ID, data []byte) restic.ID {
+	sealed, err := repo.SealDataBlob(context.TODO(), keyID, data)
+	rtest.OK(t, err)
+	rtest.Equals(t, len(data)+repository.DataKeyOverhead, len(sealed))
+
+	id, _, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, sealed, restic.ID{}, false)
+	rtest.OK(t, err)
+	return id
+}
+
+func TestDataKeys(t *testing.T) {
+	be := mem.New()
+	repo, err := initFilteredRepo(t, be, restic.CapabilitiesRepoVersion, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, repo.Config().HasCapability(restic.CapabilityDataKeys), "data keys capability missing")
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+
+	keep, err := repo.NewDataKey(context.TODO())
+	rtest.OK(t, err)
+	erase, err := repo.NewDataKey(context.TODO())
+	rtest.OK(t, err)
+
+	data := rtest.Random(42, 5000)
+	keptID := saveSealedBlob(t, repo, keep, data)
+	// the same contents with the same key are deduplicated
+	rtest.Equals(t, keptID, saveSealedBlob(t, repo, keep, data))
+	erasedID := saveSealedBlob(t, repo, erase, data)
+	rtest.Assert(t, keptID != erasedID, "contents encrypted with different keys have the same ID")
+	plainID, _, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	// reopen the repository to load the data keys
+	repo, err = repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	for _, id := range []restic.ID{keptID, erasedID, plainID} {
+		// the index must contain the length of the decrypted contents
+		size, found := repo.LookupBlobSize(id, restic.DataBlob)
+		rtest.Assert(t, found, "blob %v not found", id.Str())
+		rtest.Equals(t, uint(len(data)), size)
+
+		buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+	}
+
+	ok, err := repo.HasDataKey(context.TODO(), erase)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "data key %v not found", erase.Str())
+
+	replaced, err := repo.EraseDataKeys(context.TODO(), restic.NewIDSet(erase))
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, replaced)
+
+	// the erased key is also gone after reopening the repository
+	repo, err = repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	ok, err = repo.HasDataKey(context.TODO(), erase)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "erased data key %v still exists", erase.Str())
+	_, err = repo.LoadBlob(context.TODO(), restic.DataBlob, erasedID, nil)
+	rtest.Assert(t, errors.Is(err, repository.ErrDataKeyErased), "unexpected error %v", err)
+
+	for _, id := range []restic.ID{keptID, plainID} {
+		buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+	}
+}
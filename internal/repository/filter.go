@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// BlobFilter transforms the plaintext of blobs before they are compressed and
+// encrypted. Decode must exactly reverse Encode, the blob ID is always
+// computed over the original data.
+type BlobFilter interface {
+	// Name returns the name under which the filter is recorded in the
+	// repository config. It must never change once repositories use it.
+	Name() string
+	Encode(t restic.BlobType, data []byte) ([]byte, error)
+	Decode(t restic.BlobType, data []byte) ([]byte, error)
+}
+
+var blobFilters = struct {
+	sync.Mutex
+	m map[string]BlobFilter
+}{m: make(map[string]BlobFilter)}
+
+// RegisterBlobFilter makes a filter available for use in repositories. It is
+// meant to be called from init functions and panics if a filter with the same
+// name was already registered.
+func RegisterBlobFilter(f BlobFilter) {
+	blobFilters.Lock()
+	defer blobFilters.Unlock()
+
+	if _, ok := blobFilters.m[f.Name()]; ok {
+		panic("blob filter " + f.Name() + " registered twice")
+	}
+	blobFilters.m[f.Name()] = f
+}
+
+// BlobFilters is a chain of filters, applied in order when saving a blob and
+// in reverse order when loading it.
+type BlobFilters []BlobFilter
+
+// LookupBlobFilters returns the chain of registered filters with the given
+// names.
+func LookupBlobFilters(names []string) (BlobFilters, error) {
+	blobFilters.Lock()
+	defer blobFilters.Unlock()
+
+	var chain BlobFilters
+	seen := make(map[string]struct{})
+	for _, name := range names {
+		f, ok := blobFilters.m[name]
+		if !ok {
+			return nil, errors.Errorf("blob filter %q is not supported by this version of restic", name)
+		}
+		if _, ok := seen[name]; ok {
+			return nil, errors.Errorf("blob filter %q is listed twice", name)
+		}
+		seen[name] = struct{}{}
+		chain = append(chain, f)
+	}
+	return chain, nil
+}
+
+// Names returns the names of the filters in the chain.
+func (c BlobFilters) Names() []string {
+	var names []string
+	for _, f := range c {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+// Encode runs data through all filters of the chain.
+func (c BlobFilters) Encode(t restic.BlobType, data []byte) ([]byte, error) {
+	for _, f := range c {
+		var err error
+		data, err = f.Encode(t, data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blob filter %v", f.Name())
+		}
+	}
+	return data, nil
+}
+
+// Decode reverses Encode.
+func (c BlobFilters) Decode(t restic.BlobType, data []byte) ([]byte, error) {
+	for i := len(c) - 1; i >= 0; i-- {
+		var err error
+		data, err = c[i].Decode(t, data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blob filter %v", c[i].Name())
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,114 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var testFilterMagic = []byte("test")
+
+// testFilter prefixes the data with a magic string and inverts all bits.
+type testFilter struct{}
+
+func (testFilter) Name() string { return "test-invert" }
+
+func (testFilter) Encode(t restic.BlobType, data []byte) ([]byte, error) {
+	out := append([]byte{}, testFilterMagic...)
+	for _, b := range data {
+		out = append(out, ^b)
+	}
+	return out, nil
+}
+
+func (testFilter) Decode(t restic.BlobType, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, testFilterMagic) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	out := make([]byte, 0, len(data)-len(testFilterMagic))
+	for _, b := range data[len(testFilterMagic):] {
+		out = append(out, ^b)
+	}
+	return out, nil
+}
+
+func init() {
+	repository.RegisterBlobFilter(testFilter{})
+}
+
+func initFilteredRepo(t *testing.T, be restic.Backend, version uint, filters []string) (*repository.Repository, error) {
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	repo, err := repository.New(be, repository.Options{BlobFilters: filters})
+	rtest.OK(t, err)
+	return repo, repo.Init(context.TODO(), version, rtest.TestPassword, nil)
+}
+
+func TestBlobFilters(t *testing.T) {
+	be := mem.New()
+	repo, err := initFilteredRepo(t, be, 2, []string{"test-invert"})
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"test-invert"}, repo.Config().Filters)
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+
+	data := rtest.Random(23, 5000)
+	id, _, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.Hash(data), id)
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	// the index must contain the length of the original data
+	pbs := repo.Index().Lookup(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+	rtest.Equals(t, 1, len(pbs))
+	rtest.Equals(t, uint(len(data)), pbs[0].DataLength())
+
+	// reopen the repository to load the filters from the config
+	repo, err = repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	filters, err := repository.LookupBlobFilters(repo.Config().Filters)
+	rtest.OK(t, err)
+	blob := pbs[0].Blob
+	err = repository.StreamPack(context.TODO(), repo.Backend().Load, repo.Key(), filters, repo.Config().HashBlob, pbs[0].PackID, []restic.Blob{blob},
+		func(h restic.BlobHandle, buf []byte, err error) error {
+			rtest.OK(t, err)
+			rtest.Equals(t, data, buf)
+			return nil
+		})
+	rtest.OK(t, err)
+
+	// without the filters, the hash of the blob does not match
+	err = repository.StreamPack(context.TODO(), repo.Backend().Load, repo.Key(), nil, repo.Config().HashBlob, pbs[0].PackID, []restic.Blob{blob},
+		func(h restic.BlobHandle, buf []byte, err error) error {
+			rtest.Assert(t, err != nil, "expected hash mismatch without filters")
+			return nil
+		})
+	rtest.OK(t, err)
+}
+
+func TestBlobFiltersInvalid(t *testing.T) {
+	_, err := initFilteredRepo(t, mem.New(), 2, []string{"missing"})
+	rtest.Assert(t, err != nil, "expected error for unknown filter")
+
+	_, err = initFilteredRepo(t, mem.New(), 2, []string{"test-invert", "test-invert"})
+	rtest.Assert(t, err != nil, "expected error for duplicate filter")
+
+	_, err = initFilteredRepo(t, mem.New(), 1, []string{"test-invert"})
+	rtest.Assert(t, err != nil, "expected error for repository version 1")
+}
@@ -37,12 +37,30 @@ type Key struct {
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
+	// KEM is set if the master key is not encrypted with a key derived from a
+	// password, but with a key encapsulated for the recipient of an identity.
+	KEM *KeyKEM `json:"kem,omitempty"`
+
 	user   *crypto.Key
 	master *crypto.Key
 
 	id restic.ID
 }
 
+// KeyKEM describes the key encapsulated for a recipient.
+type KeyKEM struct {
+	Type string `json:"type"`
+	// Recipient is the fingerprint of the recipient.
+	Recipient  string                  `json:"recipient"`
+	Ciphertext crypto.HybridCiphertext `json:"ciphertext"`
+}
+
+// identityKDFParams are stored in key files for recipients. Versions of restic
+// which do not know about the field "kem" derive a key from the password
+// using these cheap parameters, which fails to decrypt the master key, and
+// then continue with the next key file.
+var identityKDFParams = crypto.Params{N: 1024, R: 8, P: 1}
+
 // Params tracks the parameters used for the KDF. If not set, it will be
 // calibrated on the first run of AddKey().
 var Params *crypto.Params
@@ -69,6 +87,11 @@ func OpenKey(ctx context.Context, s *Repository, id restic.ID, password string)
 		return nil, err
 	}
 
+	if k.KEM != nil {
+		// the key can only be opened using an identity
+		return nil, crypto.ErrUnauthenticated
+	}
+
 	// check KDF
 	if k.KDF != "scrypt" {
 		return nil, errors.New("only supported KDF is scrypt()")
@@ -85,11 +108,46 @@ func OpenKey(ctx context.Context, s *Repository, id restic.ID, password string)
 		return nil, errors.Wrap(err, "crypto.KDF")
 	}
 
+	return k, k.decryptMaster(id)
+}
+
+// OpenKeyIdentity tries to decrypt the key specified by name with the given
+// identity.
+func OpenKeyIdentity(ctx context.Context, s *Repository, id restic.ID, identity *crypto.Identity) (*Key, error) {
+	k, err := LoadKey(ctx, s, id)
+	if err != nil {
+		debug.Log("LoadKey(%v) returned error %v", id.String(), err)
+		return nil, err
+	}
+
+	recipient, err := identity.Recipient()
+	if err != nil {
+		return nil, err
+	}
+	if k.KEM == nil || k.KEM.Type != crypto.HybridKEM || k.KEM.Recipient != recipient.Fingerprint() {
+		// the key belongs to a password or another recipient
+		return nil, crypto.ErrUnauthenticated
+	}
+
+	k.user, err = identity.Decapsulate(&k.KEM.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, k.decryptMaster(id)
+}
+
+// decryptMaster decrypts the master key using the user key.
+func (k *Key) decryptMaster(id restic.ID) error {
+	if len(k.Data) < k.user.NonceSize() {
+		return errors.New("invalid key data")
+	}
+
 	// decrypt master keys
 	nonce, ciphertext := k.Data[:k.user.NonceSize()], k.Data[k.user.NonceSize():]
 	buf, err := k.user.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// restore json
@@ -97,15 +155,15 @@ func OpenKey(ctx context.Context, s *Repository, id restic.ID, password string)
 	err = json.Unmarshal(buf, k.master)
 	if err != nil {
 		debug.Log("Unmarshal() returned error %v", err)
-		return nil, errors.Wrap(err, "Unmarshal")
+		return errors.Wrap(err, "Unmarshal")
 	}
 	k.id = id
 
 	if !k.Valid() {
-		return nil, errors.New("Invalid key for repository")
+		return errors.New("Invalid key for repository")
 	}
 
-	return k, nil
+	return nil
 }
 
 // SearchKey tries to decrypt at most maxKeys keys in the backend with the
@@ -113,13 +171,27 @@ func OpenKey(ctx context.Context, s *Repository, id restic.ID, password string)
 // maxKeys is reached, ErrMaxKeysReached is returned. When setting maxKeys to
 // zero, all keys in the repo are checked.
 func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int, keyHint string) (k *Key, err error) {
+	return searchKey(ctx, s, maxKeys, keyHint, func(ctx context.Context, id restic.ID) (*Key, error) {
+		return OpenKey(ctx, s, id, password)
+	})
+}
+
+// SearchKeyIdentity tries to decrypt at most maxKeys keys in the backend with
+// the given identity, like SearchKey.
+func SearchKeyIdentity(ctx context.Context, s *Repository, identity *crypto.Identity, maxKeys int, keyHint string) (k *Key, err error) {
+	return searchKey(ctx, s, maxKeys, keyHint, func(ctx context.Context, id restic.ID) (*Key, error) {
+		return OpenKeyIdentity(ctx, s, id, identity)
+	})
+}
+
+func searchKey(ctx context.Context, s *Repository, maxKeys int, keyHint string, openKey func(ctx context.Context, id restic.ID) (*Key, error)) (k *Key, err error) {
 	checked := 0
 
 	if len(keyHint) > 0 {
 		id, err := restic.Find(ctx, s.Backend(), restic.KeyFile, keyHint)
 
 		if err == nil {
-			key, err := OpenKey(ctx, s, id, password)
+			key, err := openKey(ctx, id)
 
 			if err == nil {
 				debug.Log("successfully opened hinted key %v", id)
@@ -143,7 +215,7 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 		}
 
 		debug.Log("trying key %q", id.String())
-		key, err := OpenKey(ctx, s, id, password)
+		key, err := openKey(ctx, id)
 		if err != nil {
 			debug.Log("key %v returned error %v", id.String(), err)
 
@@ -206,6 +278,44 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 		debug.Log("calibrated KDF parameters are %v", p)
 	}
 
+	newkey, err := newKey(*Params, username, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	// call KDF to derive user key
+	newkey.user, err = crypto.KDF(*Params, newkey.Salt, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return saveKey(ctx, s, newkey, template)
+}
+
+// AddKeyRecipient adds a new key to an already existing repository, which can
+// be opened using the identity of the recipient.
+func AddKeyRecipient(ctx context.Context, s *Repository, recipient *crypto.Recipient, username, hostname string, template *crypto.Key) (*Key, error) {
+	newkey, err := newKey(identityKDFParams, username, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, user, err := recipient.Encapsulate()
+	if err != nil {
+		return nil, err
+	}
+	newkey.user = user
+	newkey.KEM = &KeyKEM{
+		Type:       recipient.Type,
+		Recipient:  recipient.Fingerprint(),
+		Ciphertext: *ciphertext,
+	}
+
+	return saveKey(ctx, s, newkey, template)
+}
+
+// newKey returns a key with the meta data and a random salt for the KDF.
+func newKey(params crypto.Params, username, hostname string) (*Key, error) {
 	// fill meta data about key
 	newkey := &Key{
 		Created:  time.Now(),
@@ -213,9 +323,9 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 		Hostname: hostname,
 
 		KDF: "scrypt",
-		N:   Params.N,
-		R:   Params.R,
-		P:   Params.P,
+		N:   params.N,
+		R:   params.R,
+		P:   params.P,
 	}
 
 	if newkey.Hostname == "" {
@@ -236,12 +346,12 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 		panic("unable to read enough random bytes for salt: " + err.Error())
 	}
 
-	// call KDF to derive user key
-	newkey.user, err = crypto.KDF(*Params, newkey.Salt, password)
-	if err != nil {
-		return nil, err
-	}
+	return newkey, nil
+}
 
+// saveKey encrypts the master key, which is copied from template or newly
+// generated, with the user key and stores the key file.
+func saveKey(ctx context.Context, s *Repository, newkey *Key, template *crypto.Key) (*Key, error) {
 	if template == nil {
 		// generate new random master keys
 		newkey.master = crypto.NewRandomKey()
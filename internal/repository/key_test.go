@@ -0,0 +1,55 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestKeyRecipient(t *testing.T) {
+	repo := repository.TestRepository(t).(*repository.Repository)
+
+	identity, err := crypto.NewIdentity()
+	rtest.OK(t, err)
+	recipient, err := identity.Recipient()
+	rtest.OK(t, err)
+
+	key, err := repository.AddKeyRecipient(context.TODO(), repo, recipient, "user", "host", repo.Key())
+	rtest.OK(t, err)
+	rtest.Assert(t, key.KEM != nil, "KEM is missing")
+	rtest.Equals(t, recipient.Fingerprint(), key.KEM.Recipient)
+
+	// the key cannot be opened using a password
+	_, err = repository.OpenKey(context.TODO(), repo, key.ID(), "")
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "expected ErrUnauthenticated, got %v", err)
+	_, err = repository.SearchKey(context.TODO(), repo, "wrong", 0, key.ID().String())
+	rtest.Assert(t, err == repository.ErrNoKeyFound, "expected ErrNoKeyFound, got %v", err)
+
+	opened, err := repository.OpenKeyIdentity(context.TODO(), repo, key.ID(), identity)
+	rtest.OK(t, err)
+	rtest.Equals(t, key.ID(), opened.ID())
+
+	// the password key belongs to no identity
+	_, err = repository.OpenKeyIdentity(context.TODO(), repo, repo.KeyID(), identity)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "expected ErrUnauthenticated, got %v", err)
+
+	other, err := crypto.NewIdentity()
+	rtest.OK(t, err)
+	_, err = repository.OpenKeyIdentity(context.TODO(), repo, key.ID(), other)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "expected ErrUnauthenticated, got %v", err)
+
+	// opening the repository with the identity returns the same master key
+	repo2, err := repository.New(repo.Backend(), repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo2.SearchIdentity(context.TODO(), identity, 0, ""))
+	rtest.Equals(t, key.ID(), repo2.KeyID())
+	rtest.Equals(t, repo.Config().ID, repo2.Config().ID)
+
+	repo3, err := repository.New(repo.Backend(), repository.Options{})
+	rtest.OK(t, err)
+	err = repo3.SearchIdentity(context.TODO(), other, 0, "")
+	rtest.Assert(t, err == repository.ErrNoKeyFound, "expected ErrNoKeyFound, got %v", err)
+}
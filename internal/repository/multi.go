@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// MultiRepository saves blobs to several repositories at once. All other
+// operations, including loading blobs and saving unpacked files, only use the
+// first repository.
+type MultiRepository struct {
+	restic.Repository
+	others []restic.Repository
+}
+
+// NewMultiRepository returns a repository which saves all blobs to repo and
+// to others.
+func NewMultiRepository(repo restic.Repository, others ...restic.Repository) *MultiRepository {
+	return &MultiRepository{Repository: repo, others: others}
+}
+
+// Index returns an index which only contains a blob if it is contained in
+// all repositories.
+func (r *MultiRepository) Index() restic.MasterIndex {
+	idx := multiIndex{MasterIndex: r.Repository.Index()}
+	for _, repo := range r.others {
+		idx.others = append(idx.others, repo.Index())
+	}
+	return idx
+}
+
+// SaveBlob saves the blob to all repositories. It returns the result for the
+// first repository.
+func (r *MultiRepository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (restic.ID, bool, int, error) {
+	newID, known, size, err := r.Repository.SaveBlob(ctx, t, buf, id, storeDuplicate)
+	if err != nil {
+		return newID, known, size, err
+	}
+	for _, repo := range r.others {
+		if _, _, _, err := repo.SaveBlob(ctx, t, buf, newID, storeDuplicate); err != nil {
+			return restic.ID{}, false, 0, err
+		}
+	}
+	return newID, known, size, nil
+}
+
+// StartPackUploader starts the pack uploaders of all repositories.
+func (r *MultiRepository) StartPackUploader(ctx context.Context, wg *errgroup.Group) {
+	r.Repository.StartPackUploader(ctx, wg)
+	for _, repo := range r.others {
+		repo.StartPackUploader(ctx, wg)
+	}
+}
+
+// Flush saves the remaining packs and the index of all repositories.
+func (r *MultiRepository) Flush(ctx context.Context) error {
+	if err := r.Repository.Flush(ctx); err != nil {
+		return err
+	}
+	for _, repo := range r.others {
+		if err := repo.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// multiIndex reports a blob as present only if all indexes contain it, so
+// that missing blobs are saved to all repositories. All other methods use the
+// first index.
+type multiIndex struct {
+	restic.MasterIndex
+	others []restic.MasterIndex
+}
+
+func (idx multiIndex) Has(h restic.BlobHandle) bool {
+	if !idx.MasterIndex.Has(h) {
+		return false
+	}
+	for _, other := range idx.others {
+		if !other.Has(h) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMultiRepositorySaveBlob(t *testing.T) {
+	repo1 := repository.TestRepository(t)
+	repo2 := repository.TestRepository(t)
+	multi := repository.NewMultiRepository(repo1, repo2)
+
+	// a blob which is only contained in the first repository
+	existing := rtest.Random(1, 1000)
+	var wg errgroup.Group
+	repo1.StartPackUploader(context.TODO(), &wg)
+	existingID, _, _, err := repo1.SaveBlob(context.TODO(), restic.DataBlob, existing, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo1.Flush(context.TODO()))
+
+	h := restic.BlobHandle{ID: existingID, Type: restic.DataBlob}
+	rtest.Assert(t, !multi.Index().Has(h), "blob missing in the second repository is reported as present")
+
+	data := rtest.Random(2, 1000)
+	multi.StartPackUploader(context.TODO(), &wg)
+	id, known, _, err := multi.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.Assert(t, !known, "new blob reported as known")
+	_, known, _, err = multi.SaveBlob(context.TODO(), restic.DataBlob, existing, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.Assert(t, known, "existing blob reported as unknown")
+	rtest.OK(t, multi.Flush(context.TODO()))
+
+	for _, id := range []restic.ID{id, existingID} {
+		h := restic.BlobHandle{ID: id, Type: restic.DataBlob}
+		rtest.Assert(t, multi.Index().Has(h), "blob %v missing", id.Str())
+		for _, repo := range []restic.Repository{repo1, repo2} {
+			buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+			rtest.OK(t, err)
+			rtest.Equals(t, id, restic.Hash(buf))
+		}
+	}
+}
@@ -174,6 +174,13 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 
 	debug.Log("saved as %v", h)
 
+	if r.cfg.Parity != nil {
+		err = r.saveParityFor(ctx, id, p.tmpfile, int64(p.Packer.Size()))
+		if err != nil {
+			return err
+		}
+	}
+
 	err = p.tmpfile.Close()
 	if err != nil {
 		return errors.Wrap(err, "close tempfile")
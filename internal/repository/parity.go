@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"math"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/parity"
+	"github.com/restic/restic/internal/restic"
+)
+
+// If the repository is configured to use parity, a parity file is stored for
+// each pack file, named like the pack file. It contains Reed-Solomon parity
+// shards computed from the pack file, which allow restoring the pack file if
+// some of it was damaged. The parity file is not encrypted, as the pack file
+// already is and the restored pack file is verified using its ID.
+
+// NewParityConfig returns the parity configuration which adds about the given
+// overhead in percent to each pack file.
+func NewParityConfig(overhead float64) (restic.ParityConfig, error) {
+	if overhead <= 0 || overhead > 100 {
+		return restic.ParityConfig{}, errors.Fatalf("invalid parity overhead %v%%, must be between 0 and 100", overhead)
+	}
+
+	// use a single parity shard for small overheads, which repairs damage
+	// in one spot, and more but larger shards otherwise
+	cfg := restic.ParityConfig{DataShards: 20, ParityShards: int(math.Round(20 * overhead / 100))}
+	if cfg.ParityShards <= 1 {
+		cfg.DataShards = int(math.Round(100 / overhead))
+		cfg.ParityShards = 1
+	}
+	if cfg.DataShards+cfg.ParityShards > parity.MaxShards {
+		return restic.ParityConfig{}, errors.Fatalf("parity overhead %v%% is too small", overhead)
+	}
+	return cfg, nil
+}
+
+// EnableParity sets the parity configuration of the repository. Pack files
+// saved afterwards get a parity file.
+func (r *Repository) EnableParity(ctx context.Context, pcfg restic.ParityConfig) error {
+	if _, err := parity.New(pcfg.DataShards, pcfg.ParityShards); err != nil {
+		return err
+	}
+
+	cfg := r.cfg
+	cfg.Parity = &pcfg
+	cfg.AddCapability(restic.CapabilityParity, true)
+	if err := r.replaceConfig(ctx, cfg); err != nil {
+		return err
+	}
+	debug.Log("enabled parity %v+%v", pcfg.DataShards, pcfg.ParityShards)
+	return nil
+}
+
+func (r *Repository) parityCode() (*parity.Code, error) {
+	if r.cfg.Parity == nil {
+		return nil, nil
+	}
+	return parity.New(r.cfg.Parity.DataShards, r.cfg.Parity.ParityShards)
+}
+
+// SaveParity computes and stores the parity file for the pack file with the
+// given ID and content. It does nothing if the repository does not use
+// parity.
+func (r *Repository) SaveParity(ctx context.Context, id restic.ID, pack []byte) error {
+	code, err := r.parityCode()
+	if err != nil || code == nil {
+		return err
+	}
+
+	buf, err := parity.Create(code, pack)
+	if err != nil {
+		return err
+	}
+	h := restic.Handle{Type: restic.ParityFile, Name: id.String()}
+	if err := r.be.Save(ctx, h, restic.NewByteReader(buf, r.be.Hasher())); err != nil {
+		return errors.Wrap(err, "save parity")
+	}
+	debug.Log("saved parity for pack %v", id)
+	return nil
+}
+
+// saveParityFor reads the finished pack file from rd and stores its parity file.
+func (r *Repository) saveParityFor(ctx context.Context, id restic.ID, rd io.ReaderAt, size int64) error {
+	buf := make([]byte, size)
+	if _, err := rd.ReadAt(buf, 0); err != nil {
+		return errors.Wrap(err, "read pack")
+	}
+	return r.SaveParity(ctx, id, buf)
+}
+
+func (r *Repository) loadFile(ctx context.Context, h restic.Handle) ([]byte, error) {
+	var buf []byte
+	err := r.be.Load(ctx, h, 0, 0, func(rd io.Reader) (ierr error) {
+		buf, ierr = io.ReadAll(rd)
+		return ierr
+	})
+	return buf, err
+}
+
+// ReconstructPack loads the pack file with the given ID. If its content does
+// not match the ID, it is restored using the parity file. The returned
+// content is verified, restored reports whether the parity file was used.
+func (r *Repository) ReconstructPack(ctx context.Context, id restic.ID) (buf []byte, restored bool, err error) {
+	buf, err = r.loadFile(ctx, restic.Handle{Type: restic.PackFile, Name: id.String()})
+	if err != nil {
+		if !r.be.IsNotExist(err) {
+			return nil, false, err
+		}
+		// the parity file may contain enough shards to restore the whole pack
+		buf = nil
+	}
+	if restic.Hash(buf).Equal(id) {
+		return buf, false, nil
+	}
+
+	parityBuf, err := r.loadFile(ctx, restic.Handle{Type: restic.ParityFile, Name: id.String()})
+	if err != nil {
+		if r.be.IsNotExist(err) {
+			return nil, false, errors.Errorf("pack %v is damaged and has no parity file", id.Str())
+		}
+		return nil, false, err
+	}
+
+	repaired, damaged, err := parity.Repair(parityBuf, buf)
+	if err != nil {
+		return nil, false, errors.Errorf("pack %v cannot be restored using the parity file: %v", id.Str(), err)
+	}
+	if !restic.Hash(repaired).Equal(id) {
+		return nil, false, errors.Errorf("pack %v restored using the parity file does not match its ID", id.Str())
+	}
+	debug.Log("restored pack %v, %d shards were damaged", id, damaged)
+	return repaired, true, nil
+}
+
+// ReplacePack overwrites the content of the pack file with the given ID with
+// buf, which must match the ID.
+func (r *Repository) ReplacePack(ctx context.Context, id restic.ID, t restic.BlobType, buf []byte) error {
+	if !restic.Hash(buf).Equal(id) {
+		return errors.Errorf("content does not match pack %v", id.Str())
+	}
+
+	h := restic.Handle{Type: restic.PackFile, Name: id.String(), ContainedBlobType: t}
+	if !r.be.HasAtomicReplace() {
+		// remove the original file for backends which do not support atomic overwriting
+		if err := r.be.Remove(ctx, h); err != nil && !r.be.IsNotExist(err) {
+			return errors.Wrap(err, "remove damaged pack")
+		}
+	}
+	return r.be.Save(ctx, h, restic.NewByteReader(buf, r.be.Hasher()))
+}
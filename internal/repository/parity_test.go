@@ -0,0 +1,86 @@
+package repository_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestNewParityConfig(t *testing.T) {
+	for _, test := range []struct {
+		overhead float64
+		cfg      restic.ParityConfig
+	}{
+		{5, restic.ParityConfig{DataShards: 20, ParityShards: 1}},
+		{1, restic.ParityConfig{DataShards: 100, ParityShards: 1}},
+		{10, restic.ParityConfig{DataShards: 20, ParityShards: 2}},
+		{50, restic.ParityConfig{DataShards: 20, ParityShards: 10}},
+	} {
+		cfg, err := repository.NewParityConfig(test.overhead)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.cfg, cfg)
+	}
+
+	for _, overhead := range []float64{0, -1, 0.1, 101} {
+		_, err := repository.NewParityConfig(overhead)
+		rtest.Assert(t, err != nil, "expected error for overhead %v", overhead)
+	}
+}
+
+func TestParity(t *testing.T) {
+	ctx := context.TODO()
+	repo := repository.TestRepository(t)
+
+	pcfg, err := repository.NewParityConfig(5)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.(*repository.Repository).EnableParity(ctx, pcfg))
+
+	r := reopenRepository(t, repo)
+	rtest.Assert(t, r.Config().HasCapability(restic.CapabilityParity), "parity capability is missing")
+
+	var wg errgroup.Group
+	r.StartPackUploader(ctx, &wg)
+	buf := make([]byte, 100*1024)
+	_, _ = rand.New(rand.NewSource(23)).Read(buf)
+	_, _, _, err = r.SaveBlob(ctx, restic.DataBlob, buf, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, r.Flush(ctx))
+
+	packs := listFiles(t, repo, restic.PackFile)
+	rtest.Equals(t, 1, len(packs))
+	rtest.Equals(t, packs, listFiles(t, repo, restic.ParityFile))
+	id := packs[0]
+
+	pack, restored, err := r.ReconstructPack(ctx, id)
+	rtest.OK(t, err)
+	rtest.Assert(t, !restored, "intact pack was restored")
+
+	damaged := append([]byte{}, pack...)
+	damaged[1234] ^= 0x42
+	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
+	rtest.OK(t, repo.Backend().Remove(ctx, h))
+	rtest.OK(t, repo.Backend().Save(ctx, h, restic.NewByteReader(damaged, repo.Backend().Hasher())))
+
+	res, restored, err := r.ReconstructPack(ctx, id)
+	rtest.OK(t, err)
+	rtest.Assert(t, restored, "damaged pack was not restored")
+	rtest.Equals(t, pack, res)
+
+	rtest.Assert(t, r.ReplacePack(ctx, id, restic.DataBlob, damaged) != nil, "damaged content was accepted")
+	rtest.OK(t, r.ReplacePack(ctx, id, restic.DataBlob, res))
+	_, restored, err = r.ReconstructPack(ctx, id)
+	rtest.OK(t, err)
+	rtest.Assert(t, !restored, "replaced pack is still damaged")
+
+	// without a parity file the pack cannot be restored
+	rtest.OK(t, repo.Backend().Remove(ctx, h))
+	rtest.OK(t, repo.Backend().Save(ctx, h, restic.NewByteReader(damaged, repo.Backend().Hasher())))
+	rtest.OK(t, repo.Backend().Remove(ctx, restic.Handle{Type: restic.ParityFile, Name: id.String()}))
+	_, _, err = r.ReconstructPack(ctx, id)
+	rtest.Assert(t, err != nil, "pack without parity file was restored")
+}
@@ -48,6 +48,11 @@ func Repack(ctx context.Context, repo restic.Repository, dstRepo restic.Reposito
 }
 
 func repack(ctx context.Context, repo restic.Repository, dstRepo restic.Repository, packs restic.IDSet, keepBlobs repackBlobSet, p *progress.Counter) (obsoletePacks restic.IDSet, err error) {
+	filters, err := LookupBlobFilters(repo.Config().Filters)
+	if err != nil {
+		return nil, err
+	}
+
 	wg, wgCtx := errgroup.WithContext(ctx)
 
 	var keepMutex sync.Mutex
@@ -77,7 +82,7 @@ func repack(ctx context.Context, repo restic.Repository, dstRepo restic.Reposito
 
 	worker := func() error {
 		for t := range downloadQueue {
-			err := StreamPack(wgCtx, repo.Backend().Load, repo.Key(), t.PackID, t.Blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+			err := StreamPack(wgCtx, repo.Backend().Load, repo.Key(), filters, repo.Config().HashBlob, t.PackID, t.Blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
 				if err != nil {
 					var ierr error
 					// check whether we can get a valid copy somewhere else
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"sort"
 	"sync"
 
@@ -39,9 +40,20 @@ type Repository struct {
 	cfg   restic.Config
 	key   *crypto.Key
 	keyID restic.ID
+
+	filters BlobFilters
+
 	idx   *index.MasterIndex
 	Cache *cache.Cache
 
+	// smallIndexes lists the loaded index files which contain only few blobs
+	smallIndexes restic.IDs
+
+	catalog snapshotCatalog
+
+	dataKeysMu sync.Mutex
+	dataKeys   dataKeys
+
 	opts Options
 
 	noAutoIndexUpdate bool
@@ -60,6 +72,14 @@ type Repository struct {
 type Options struct {
 	Compression CompressionMode
 	PackSize    uint
+	Index       IndexMode
+
+	// BlobFilters lists the filters recorded in the config of newly
+	// initialized repositories.
+	BlobFilters []string
+	// BlobHash is the blob hash algorithm recorded in the config of newly
+	// initialized repositories, see restic.Config.BlobHash.
+	BlobHash string
 }
 
 // CompressionMode configures if data should be compressed.
@@ -107,30 +127,106 @@ func (c *CompressionMode) Type() string {
 	return "mode"
 }
 
+// IndexMode configures where the index is kept after loading it.
+type IndexMode uint
+
+// Constants for the different index modes.
+const (
+	IndexMemory  IndexMode = 0
+	IndexDisk    IndexMode = 1
+	IndexInvalid IndexMode = 2
+)
+
+// Set implements the method needed for pflag command flag parsing.
+func (m *IndexMode) Set(s string) error {
+	switch s {
+	case "memory":
+		*m = IndexMemory
+	case "disk":
+		*m = IndexDisk
+	default:
+		*m = IndexInvalid
+		return fmt.Errorf("invalid index mode %q, must be one of (memory|disk)", s)
+	}
+
+	return nil
+}
+
+func (m *IndexMode) String() string {
+	switch *m {
+	case IndexMemory:
+		return "memory"
+	case IndexDisk:
+		return "disk"
+	default:
+		return "invalid"
+	}
+}
+
+func (m *IndexMode) Type() string {
+	return "mode"
+}
+
 // New returns a new repository with backend be.
 func New(be restic.Backend, opts Options) (*Repository, error) {
 	if opts.Compression == CompressionInvalid {
 		return nil, errors.Fatalf("invalid compression mode")
 	}
+	if opts.Index == IndexInvalid {
+		return nil, errors.Fatalf("invalid index mode")
+	}
 
 	if opts.PackSize == 0 {
 		opts.PackSize = DefaultPackSize
 	}
-	if opts.PackSize > MaxPackSize {
-		return nil, errors.Fatalf("pack size larger than limit of %v MiB", MaxPackSize/1024/1024)
-	} else if opts.PackSize < MinPackSize {
-		return nil, errors.Fatalf("pack size smaller than minimum of %v MiB", MinPackSize/1024/1024)
+	if err := checkPackSize(opts.PackSize); err != nil {
+		return nil, err
 	}
 
 	repo := &Repository{
-		be:   be,
-		opts: opts,
-		idx:  index.NewMasterIndex(),
+		be:       be,
+		opts:     opts,
+		idx:      index.NewMasterIndex(),
+		dataKeys: newDataKeys(),
 	}
 
 	return repo, nil
 }
 
+func checkPackSize(size uint) error {
+	if size > MaxPackSize {
+		return errors.Fatalf("pack size larger than limit of %v MiB", MaxPackSize/1024/1024)
+	} else if size < MinPackSize {
+		return errors.Fatalf("pack size smaller than minimum of %v MiB", MinPackSize/1024/1024)
+	}
+	return nil
+}
+
+// SetCompression changes the compression mode used for new blobs.
+func (r *Repository) SetCompression(mode CompressionMode) error {
+	if mode == CompressionInvalid {
+		return errors.Fatalf("invalid compression mode")
+	}
+	r.opts.Compression = mode
+	return nil
+}
+
+// SetPackSize changes the target size of new pack files. It must be called
+// before the pack uploader is started.
+func (r *Repository) SetPackSize(size uint) error {
+	if err := checkPackSize(size); err != nil {
+		return err
+	}
+	r.opts.PackSize = size
+	return nil
+}
+
+// DiskIndex returns true if the index is kept in a temporary file instead of
+// in memory once it is loaded.
+func (r *Repository) DiskIndex() bool {
+	return r.opts.Index == IndexDisk
+}
+
 // DisableAutoIndexUpdate deactives the automatic finalization and upload of new
 // indexes once these are full
 func (r *Repository) DisableAutoIndexUpdate() {
@@ -138,11 +234,21 @@ func (r *Repository) DisableAutoIndexUpdate() {
 }
 
 // setConfig assigns the given config and updates the repository parameters accordingly
-func (r *Repository) setConfig(cfg restic.Config) {
+func (r *Repository) setConfig(cfg restic.Config) error {
+	filters, err := LookupBlobFilters(cfg.Filters)
+	if err != nil {
+		return err
+	}
+	if len(filters) > 0 && cfg.Version < 2 {
+		return errors.New("blob filters require repository version 2")
+	}
+
 	r.cfg = cfg
+	r.filters = filters
 	if r.cfg.Version >= 2 {
 		r.idx.MarkCompressed()
 	}
+	return nil
 }
 
 // Config returns the repository configuration.
@@ -150,6 +256,49 @@ func (r *Repository) Config() restic.Config {
 	return r.cfg
 }
 
+// SetDefaults replaces the defaults stored in the repository config.
+func (r *Repository) SetDefaults(ctx context.Context, defaults map[string][]string) error {
+	cfg := r.cfg
+	cfg.Defaults = defaults
+	if len(defaults) == 0 {
+		cfg.Defaults = nil
+	}
+	return r.replaceConfig(ctx, cfg)
+}
+
+// SetChunkerPolynomial replaces the chunker polynomial stored in the
+// repository config. Only data saved afterwards is split using it.
+func (r *Repository) SetChunkerPolynomial(ctx context.Context, pol chunker.Pol) error {
+	if !pol.Irreducible() {
+		return errors.New("invalid chunker polynomial, it is not irreducible")
+	}
+	cfg := r.cfg
+	cfg.ChunkerPolynomial = pol
+	return r.replaceConfig(ctx, cfg)
+}
+
+// replaceConfig overwrites the config file with cfg.
+func (r *Repository) replaceConfig(ctx context.Context, cfg restic.Config) error {
+	if !r.be.HasAtomicReplace() {
+		// remove the original file for backends which do not support atomic overwriting
+		err := r.be.Remove(ctx, restic.Handle{Type: restic.ConfigFile})
+		if err != nil {
+			return fmt.Errorf("remove config failed: %w", err)
+		}
+	}
+
+	err := restic.SaveConfig(ctx, r, cfg)
+	if err != nil {
+		if rerr := restic.SaveConfig(ctx, r, r.cfg); rerr != nil {
+			return fmt.Errorf("save new config file failed: %w, restoring the old config failed as well: %v", err, rerr)
+		}
+		return fmt.Errorf("save new config file failed: %w", err)
+	}
+
+	r.cfg = cfg
+	return nil
+}
+
 // PackSize return the target size of a pack file when uploading
 func (r *Repository) PackSize() uint {
 	return r.opts.PackSize
@@ -174,6 +323,15 @@ func (r *Repository) SetDryRun() {
 func (r *Repository) LoadUnpacked(ctx context.Context, t restic.FileType, id restic.ID) ([]byte, error) {
 	debug.Log("load %v with id %v", t, id)
 
+	if t == restic.SnapshotFile {
+		if buf, ok := r.catalogSnapshot(ctx, id); ok {
+			return buf, nil
+		}
+	}
+	return r.loadUnpacked(ctx, t, id)
+}
+
+func (r *Repository) loadUnpacked(ctx context.Context, t restic.FileType, id restic.ID) ([]byte, error) {
 	if t == restic.ConfigFile {
 		id = restic.ID{}
 	}
@@ -322,12 +480,29 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 			}
 		}
 
+		plaintext, err = r.filters.Decode(t, plaintext)
+		if err != nil {
+			lastError = errors.Errorf("decoding blob %v failed: %v", id, err)
+			continue
+		}
+
 		// check hash
-		if !restic.Hash(plaintext).Equal(id) {
+		if !r.cfg.HashBlob(plaintext).Equal(id) {
 			lastError = errors.Errorf("blob %v returned invalid hash", id)
 			continue
 		}
 
+		if t == restic.DataBlob {
+			plaintext, err = r.OpenDataBlob(ctx, id, plaintext)
+			if err == ErrDataKeyErased {
+				return nil, err
+			}
+			if err != nil {
+				lastError = err
+				continue
+			}
+		}
+
 		if len(plaintext) > cap(buf) {
 			return plaintext, nil
 		}
@@ -404,12 +579,34 @@ func (r *Repository) saveAndEncrypt(ctx context.Context, t restic.BlobType, data
 
 	uncompressedLength := 0
 	if r.cfg.Version > 1 {
+		// the index always records the length of the original data, which
+		// is used e.g. to compute file offsets during restore
+		dataLength := len(data)
+		isDataKeyBlob := false
+		if t == restic.DataBlob {
+			_, isDataKeyBlob, err = r.lookupDataKey(ctx, id)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if isDataKeyBlob {
+			// the length is reported for the contents after decrypting
+			// them with the data key
+			dataLength -= DataKeyOverhead
+		}
+		if len(r.filters) > 0 {
+			data, err = r.filters.Encode(t, data)
+			if err != nil {
+				return 0, err
+			}
+		}
 
 		// we have a repo v2, so compression is available. if the user opts to
 		// not compress, we won't compress any data, but everything else is
-		// compressed.
-		if r.opts.Compression != CompressionOff || t != restic.DataBlob {
-			uncompressedLength = len(data)
+		// compressed. Filtered blobs are always marked as compressed, as only
+		// this allows storing the original length.
+		if r.opts.Compression != CompressionOff || t != restic.DataBlob || len(r.filters) > 0 || isDataKeyBlob {
+			uncompressedLength = dataLength
 			data = r.getZstdEncoder().EncodeAll(data, nil)
 		}
 	}
@@ -507,6 +704,10 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 
 // Flush saves all remaining packs and the index
 func (r *Repository) Flush(ctx context.Context) error {
+	// the data keys are needed to read the blobs referenced by the index
+	if err := r.flushDataKeys(ctx); err != nil {
+		return err
+	}
 	if err := r.flushPacks(ctx); err != nil {
 		return err
 	}
@@ -583,11 +784,24 @@ func (r *Repository) SetIndex(i restic.MasterIndex) error {
 // in the master index. The first error that occurred is returned.
 func (r *Repository) LoadIndex(ctx context.Context) error {
 	debug.Log("Loading index")
+	r.smallIndexes = nil
+
+	var builder *index.DiskIndexBuilder
+	if r.DiskIndex() {
+		builder = index.NewDiskIndexBuilder()
+		defer builder.Close()
+	}
 
 	err := index.ForAllIndexes(ctx, r, func(id restic.ID, idx *index.Index, oldFormat bool, err error) error {
 		if err != nil {
 			return err
 		}
+		if index.IndexSmall(idx, r.cfg.Version >= 2) {
+			r.smallIndexes = append(r.smallIndexes, id)
+		}
+		if builder != nil {
+			return builder.Add(idx)
+		}
 		r.idx.Insert(idx)
 		return nil
 	})
@@ -596,6 +810,14 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 		return errors.Fatal(err.Error())
 	}
 
+	if builder != nil {
+		idx, err := builder.Finish()
+		if err != nil {
+			return errors.Fatalf("unable to create on-disk index: %v", err)
+		}
+		r.idx.Insert(idx)
+	}
+
 	err = r.idx.MergeFinalIndexes()
 	if err != nil {
 		return err
@@ -621,6 +843,67 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 	return r.prepareCache()
 }
 
+// SmallIndexes returns the IDs of the index files loaded by LoadIndex which
+// contain considerably fewer blobs than a full index.
+func (r *Repository) SmallIndexes() restic.IDs {
+	return r.smallIndexes
+}
+
+// CompactIndexes merges the given index files into as few full index files as
+// possible. It returns the IDs of the merged index files, which are superseded
+// by the new index files and must be removed by the caller.
+func (r *Repository) CompactIndexes(ctx context.Context, ids restic.IDs, p *progress.Counter) (restic.IDSet, error) {
+	debug.Log("compacting %d index files", len(ids))
+
+	mi := index.NewMasterIndex()
+	if r.cfg.Version >= 2 {
+		mi.MarkCompressed()
+	}
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	ch := make(chan restic.ID)
+	wg.Go(func() error {
+		defer close(ch)
+		for _, id := range ids {
+			select {
+			case <-wgCtx.Done():
+				return wgCtx.Err()
+			case ch <- id:
+			}
+		}
+		return nil
+	})
+
+	worker := func() error {
+		for id := range ch {
+			buf, err := r.LoadUnpacked(wgCtx, restic.IndexFile, id)
+			if err != nil {
+				return err
+			}
+			idx, _, err := index.DecodeIndex(buf, id)
+			if err != nil {
+				return errors.Fatalf("unable to decode index %v: %v", id.Str(), err)
+			}
+			mi.Insert(idx)
+		}
+		return nil
+	}
+
+	// decoding an index is CPU-bound, loading it is IO-bound
+	workerCount := int(r.Connections()) + runtime.GOMAXPROCS(0)
+	for i := 0; i < workerCount; i++ {
+		wg.Go(worker)
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	if err := mi.MergeFinalIndexes(); err != nil {
+		return nil, err
+	}
+	return mi.Save(ctx, r, restic.NewIDSet(), nil, p)
+}
+
 // CreateIndexFromPacks creates a new index by reading all given pack files (with sizes).
 // The index is added to the MasterIndex but not marked as finalized.
 // Returned is the list of pack files which could not be read.
@@ -719,6 +1002,22 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return err
 	}
 
+	return r.useKey(ctx, key)
+}
+
+// SearchIdentity finds a key which can be opened by the identity, like
+// SearchKey.
+func (r *Repository) SearchIdentity(ctx context.Context, identity *crypto.Identity, maxKeys int, keyHint string) error {
+	key, err := SearchKeyIdentity(ctx, r, identity, maxKeys, keyHint)
+	if err != nil {
+		return err
+	}
+
+	return r.useKey(ctx, key)
+}
+
+// useKey sets the master key and loads the config.
+func (r *Repository) useKey(ctx context.Context, key *Key) error {
 	r.key = key.master
 	r.keyID = key.ID()
 	cfg, err := restic.LoadConfig(ctx, r)
@@ -728,8 +1027,17 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return errors.Fatalf("config cannot be loaded: %v", err)
 	}
 
-	r.setConfig(cfg)
-	return nil
+	return r.setConfig(cfg)
+}
+
+// ReloadConfig loads the config from the repository again, for example after
+// it was rewritten by a migration.
+func (r *Repository) ReloadConfig(ctx context.Context) error {
+	cfg, err := restic.LoadConfig(ctx, r)
+	if err != nil {
+		return errors.Fatalf("config cannot be loaded: %v", err)
+	}
+	return r.setConfig(cfg)
 }
 
 // Init creates a new master key with the supplied password, initializes and
@@ -758,6 +1066,22 @@ func (r *Repository) Init(ctx context.Context, version uint, password string, ch
 	if chunkerPolynomial != nil {
 		cfg.ChunkerPolynomial = *chunkerPolynomial
 	}
+	cfg.Filters = r.opts.BlobFilters
+	if len(cfg.Filters) > 0 && version < 2 {
+		return errors.New("blob filters require repository version 2")
+	}
+	if len(cfg.Filters) > 0 {
+		cfg.AddCapability(restic.CapabilityBlobFilters, false)
+	}
+	if _, err := restic.LookupBlobHash(r.opts.BlobHash); err != nil {
+		return err
+	}
+	if r.opts.BlobHash != "" && r.opts.BlobHash != restic.BlobHashSHA256 {
+		// upgrades the repository to version 3, which older versions of
+		// restic that would compute the blob IDs using SHA-256 cannot open
+		cfg.BlobHash = r.opts.BlobHash
+		cfg.AddCapability(restic.CapabilityBlobHash, false)
+	}
 
 	return r.init(ctx, password, cfg)
 }
@@ -765,6 +1089,11 @@ func (r *Repository) Init(ctx context.Context, version uint, password string, ch
 // init creates a new master key with the supplied password and uses it to save
 // the config into the repo.
 func (r *Repository) init(ctx context.Context, password string, cfg restic.Config) error {
+	err := r.setConfig(cfg)
+	if err != nil {
+		return err
+	}
+
 	key, err := createMasterKey(ctx, r, password)
 	if err != nil {
 		return err
@@ -772,7 +1101,6 @@ func (r *Repository) init(ctx context.Context, password string, cfg restic.Confi
 
 	r.key = key.master
 	r.keyID = key.ID()
-	r.setConfig(cfg)
 	return restic.SaveConfig(ctx, r, cfg)
 }
 
@@ -832,9 +1160,9 @@ func (r *Repository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte
 		// useful for sparse files containing large all zero regions. For these we can
 		// process chunks as fast as we can read the from disk.
 		if len(buf) == chunker.MinSize && restic.ZeroPrefixLen(buf) == chunker.MinSize {
-			newID = ZeroChunk()
+			newID = ZeroChunk(r.cfg)
 		} else {
-			newID = restic.Hash(buf)
+			newID = r.cfg.HashBlob(buf)
 		}
 	} else {
 		newID = id
@@ -856,11 +1184,12 @@ type BackendLoadFn func(ctx context.Context, h restic.Handle, length int, offset
 // Skip sections with more than 4MB unused blobs
 const maxUnusedRange = 4 * 1024 * 1024
 
-// StreamPack loads the listed blobs from the specified pack file. The plaintext blob is passed to
-// the handleBlobFn callback or an error if decryption failed or the blob hash does not match. In
+// StreamPack loads the listed blobs from the specified pack file. The plaintext blob, with the
+// filter chain reversed, is passed to the handleBlobFn callback or an error if decryption failed or the blob hash, computed
+// using hash, does not match. In
 // case of download errors handleBlobFn might be called multiple times for the same blob. If the
 // callback returns an error, then StreamPack will abort and not retry it.
-func StreamPack(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+func StreamPack(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, filters BlobFilters, hash restic.BlobHashFunc, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
 	if len(blobs) == 0 {
 		// nothing to do
 		return nil
@@ -879,7 +1208,7 @@ func StreamPack(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, pack
 		}
 		if blobs[i].Offset-lastPos > maxUnusedRange {
 			// load everything up to the skipped file section
-			err := streamPackPart(ctx, beLoad, key, packID, blobs[lowerIdx:i], handleBlobFn)
+			err := streamPackPart(ctx, beLoad, key, filters, hash, packID, blobs[lowerIdx:i], handleBlobFn)
 			if err != nil {
 				return err
 			}
@@ -888,10 +1217,10 @@ func StreamPack(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, pack
 		lastPos = blobs[i].Offset + blobs[i].Length
 	}
 	// load remainder
-	return streamPackPart(ctx, beLoad, key, packID, blobs[lowerIdx:], handleBlobFn)
+	return streamPackPart(ctx, beLoad, key, filters, hash, packID, blobs[lowerIdx:], handleBlobFn)
 }
 
-func streamPackPart(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+func streamPackPart(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, filters BlobFilters, hash restic.BlobHashFunc, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
 	h := restic.Handle{Type: restic.PackFile, Name: packID.String(), ContainedBlobType: restic.DataBlob}
 
 	dataStart := blobs[0].Offset
@@ -969,8 +1298,14 @@ func streamPackPart(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key,
 					err = errors.Errorf("decompressing blob %v failed: %v", h, err)
 				}
 			}
+			if err == nil && len(filters) > 0 {
+				plaintext, err = filters.Decode(entry.Type, plaintext)
+				if err != nil {
+					err = errors.Errorf("decoding blob %v failed: %v", h, err)
+				}
+			}
 			if err == nil {
-				id := restic.Hash(plaintext)
+				id := hash(plaintext)
 				if !id.Equal(entry.ID) {
 					debug.Log("read blob %v/%v from %v: wrong data returned, hash is %v",
 						h.Type, h.ID, packID.Str(), id)
@@ -990,13 +1325,19 @@ func streamPackPart(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key,
 	return errors.Wrap(err, "StreamPack")
 }
 
-var zeroChunkOnce sync.Once
-var zeroChunkID restic.ID
+var zeroChunkMu sync.Mutex
+var zeroChunkIDs = make(map[string]restic.ID)
 
 // ZeroChunk computes and returns (cached) the ID of an all-zero chunk with size chunker.MinSize
-func ZeroChunk() restic.ID {
-	zeroChunkOnce.Do(func() {
-		zeroChunkID = restic.Hash(make([]byte, chunker.MinSize))
-	})
-	return zeroChunkID
+// for the blob hash algorithm of the config.
+func ZeroChunk(cfg restic.Config) restic.ID {
+	zeroChunkMu.Lock()
+	defer zeroChunkMu.Unlock()
+
+	id, ok := zeroChunkIDs[cfg.BlobHash]
+	if !ok {
+		id = cfg.HashBlob(make([]byte, chunker.MinSize))
+		zeroChunkIDs[cfg.BlobHash] = id
+	}
+	return id
 }
@@ -519,7 +519,7 @@ func testStreamPack(t *testing.T, version uint) {
 	switch version {
 	case 1:
 		compress = false
-	case 2:
+	case 2, 3:
 		compress = true
 	default:
 		t.Fatal("test does not suport repository version", version)
@@ -592,7 +592,7 @@ func testStreamPack(t *testing.T, version uint) {
 				}
 
 				loadCalls = 0
-				err = repository.StreamPack(ctx, load, &key, restic.ID{}, test.blobs, handleBlob)
+				err = repository.StreamPack(ctx, load, &key, nil, restic.Hash, restic.ID{}, test.blobs, handleBlob)
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -654,7 +654,7 @@ func testStreamPack(t *testing.T, version uint) {
 					return err
 				}
 
-				err = repository.StreamPack(ctx, load, &key, restic.ID{}, test.blobs, handleBlob)
+				err = repository.StreamPack(ctx, load, &key, nil, restic.Hash, restic.ID{}, test.blobs, handleBlob)
 				if err == nil {
 					t.Fatalf("wanted error %v, got nil", test.err)
 				}
@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The snapshot catalog summarizes the snapshots of a repository in a single
+// file, such that commands which load all snapshots only have to download one
+// file instead of every snapshot file. The catalog is optional, a repository
+// only uses it once it has been created. Snapshots missing from the catalog
+// are loaded from their snapshot file as usual, entries of snapshots which no
+// longer exist are ignored as only listed snapshot files are ever loaded.
+//
+// Several catalog files can exist if they were written concurrently, their
+// entries are merged when loading them.
+
+type catalogEntry struct {
+	ID       restic.ID       `json:"id"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+type catalogFile struct {
+	Snapshots []catalogEntry `json:"snapshots"`
+}
+
+type snapshotCatalog struct {
+	once     sync.Once
+	m        sync.Mutex
+	disabled bool
+
+	files     restic.IDs
+	snapshots map[restic.ID][]byte
+}
+
+// DisableSnapshotCatalog loads all snapshots from their snapshot file, even
+// if they are contained in the snapshot catalog.
+func (r *Repository) DisableSnapshotCatalog() {
+	r.catalog.m.Lock()
+	defer r.catalog.m.Unlock()
+	r.catalog.disabled = true
+}
+
+// loadSnapshotCatalog loads all catalog files the first time it is called.
+// Failing to load the catalog is not fatal, the affected snapshots are then
+// loaded from their snapshot files.
+func (r *Repository) loadSnapshotCatalog(ctx context.Context) {
+	r.catalog.once.Do(func() {
+		var ids restic.IDs
+		err := r.List(ctx, restic.CatalogFile, func(id restic.ID, _ int64) error {
+			ids = append(ids, id)
+			return nil
+		})
+		if err != nil {
+			debug.Log("unable to list snapshot catalogs: %v", err)
+			return
+		}
+
+		snapshots := make(map[restic.ID][]byte)
+		for _, id := range ids {
+			var cf catalogFile
+			err := restic.LoadJSONUnpacked(ctx, r, restic.CatalogFile, id, &cf)
+			if err != nil {
+				// the file is still replaced by the next update
+				debug.Log("unable to load snapshot catalog %v: %v", id, err)
+				continue
+			}
+			for _, e := range cf.Snapshots {
+				snapshots[e.ID] = e.Snapshot
+			}
+		}
+		debug.Log("loaded %d snapshots from %d catalog files", len(snapshots), len(ids))
+
+		r.catalog.m.Lock()
+		defer r.catalog.m.Unlock()
+		r.catalog.files = ids
+		r.catalog.snapshots = snapshots
+	})
+}
+
+// catalogSnapshot returns the content of the snapshot file id from the
+// snapshot catalog. Snapshots already stored in the local cache are not
+// looked up in the catalog, as loading them is cheaper than downloading the
+// catalog.
+func (r *Repository) catalogSnapshot(ctx context.Context, id restic.ID) ([]byte, bool) {
+	r.catalog.m.Lock()
+	disabled := r.catalog.disabled
+	r.catalog.m.Unlock()
+	if disabled {
+		return nil, false
+	}
+	if r.Cache != nil && r.Cache.Has(restic.Handle{Type: restic.SnapshotFile, Name: id.String()}) {
+		return nil, false
+	}
+
+	r.loadSnapshotCatalog(ctx)
+
+	r.catalog.m.Lock()
+	defer r.catalog.m.Unlock()
+	buf, ok := r.catalog.snapshots[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), buf...), true
+}
+
+// UpdateSnapshotCatalog adds the snapshots added to and removes the snapshots
+// removed from the snapshot catalog. Nothing is done if the repository does
+// not use a catalog.
+func (r *Repository) UpdateSnapshotCatalog(ctx context.Context, added restic.IDs, removed restic.IDs) error {
+	r.loadSnapshotCatalog(ctx)
+
+	r.catalog.m.Lock()
+	inUse := len(r.catalog.files) > 0
+	snapshots := make(map[restic.ID][]byte, len(r.catalog.snapshots)+len(added))
+	for id, buf := range r.catalog.snapshots {
+		snapshots[id] = buf
+	}
+	r.catalog.m.Unlock()
+	if !inUse {
+		return nil
+	}
+
+	for _, id := range added {
+		buf, err := r.loadUnpacked(ctx, restic.SnapshotFile, id)
+		if err != nil {
+			return err
+		}
+		snapshots[id] = buf
+	}
+	for _, id := range removed {
+		delete(snapshots, id)
+	}
+
+	return r.saveSnapshotCatalog(ctx, snapshots)
+}
+
+// CreateSnapshotCatalog replaces the snapshot catalog with a new one which
+// contains all snapshots of the repository. The snapshots are read from their
+// snapshot files and not from the previous catalog. It returns the number of
+// snapshots in the catalog.
+func (r *Repository) CreateSnapshotCatalog(ctx context.Context) (int, error) {
+	r.loadSnapshotCatalog(ctx)
+
+	var m sync.Mutex
+	snapshots := make(map[restic.ID][]byte)
+	err := restic.ParallelList(ctx, r.be, restic.SnapshotFile, r.Connections(), func(ctx context.Context, id restic.ID, _ int64) error {
+		buf, err := r.loadUnpacked(ctx, restic.SnapshotFile, id)
+		if err != nil {
+			return err
+		}
+		m.Lock()
+		defer m.Unlock()
+		snapshots[id] = buf
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(snapshots), r.saveSnapshotCatalog(ctx, snapshots)
+}
+
+// RemoveSnapshotCatalog removes all snapshot catalog files, afterwards the
+// repository no longer uses a catalog.
+func (r *Repository) RemoveSnapshotCatalog(ctx context.Context) error {
+	r.loadSnapshotCatalog(ctx)
+	return r.replaceSnapshotCatalog(ctx, nil, nil)
+}
+
+func (r *Repository) saveSnapshotCatalog(ctx context.Context, snapshots map[restic.ID][]byte) error {
+	cf := catalogFile{Snapshots: make([]catalogEntry, 0, len(snapshots))}
+	for id, buf := range snapshots {
+		cf.Snapshots = append(cf.Snapshots, catalogEntry{ID: id, Snapshot: buf})
+	}
+	sort.Slice(cf.Snapshots, func(i, j int) bool {
+		return cf.Snapshots[i].ID.String() < cf.Snapshots[j].ID.String()
+	})
+
+	id, err := restic.SaveJSONUnpacked(ctx, r, restic.CatalogFile, cf)
+	if err != nil {
+		return errors.Wrap(err, "saving snapshot catalog")
+	}
+	debug.Log("saved snapshot catalog %v with %d snapshots", id, len(snapshots))
+
+	return r.replaceSnapshotCatalog(ctx, restic.IDs{id}, snapshots)
+}
+
+// replaceSnapshotCatalog removes the previous catalog files.
+func (r *Repository) replaceSnapshotCatalog(ctx context.Context, files restic.IDs, snapshots map[restic.ID][]byte) error {
+	r.catalog.m.Lock()
+	obsolete := r.catalog.files
+	r.catalog.files = files
+	r.catalog.snapshots = snapshots
+	r.catalog.m.Unlock()
+
+	for _, id := range obsolete {
+		err := r.be.Remove(ctx, restic.Handle{Type: restic.CatalogFile, Name: id.String()})
+		// a concurrent update may already have removed the file
+		if err != nil && !r.be.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
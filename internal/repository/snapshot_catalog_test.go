@@ -0,0 +1,84 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func reopenRepository(t *testing.T, repo restic.Repository) *repository.Repository {
+	r, err := repository.New(repo.Backend(), repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, r.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	return r
+}
+
+func saveTestSnapshot(t *testing.T, repo restic.Repository, path string) restic.ID {
+	sn, err := restic.NewSnapshot([]string{path}, nil, "host", time.Unix(1700000000, 0))
+	rtest.OK(t, err)
+	sn.Tree = &restic.ID{}
+	id, err := restic.SaveSnapshot(context.TODO(), repo, sn)
+	rtest.OK(t, err)
+	return id
+}
+
+func removeSnapshotFile(t *testing.T, repo restic.Repository, id restic.ID) {
+	h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	rtest.OK(t, repo.Backend().Remove(context.TODO(), h))
+}
+
+func TestSnapshotCatalog(t *testing.T) {
+	ctx := context.TODO()
+	repo := repository.TestRepository(t)
+
+	id1 := saveTestSnapshot(t, repo, "/one")
+	id2 := saveTestSnapshot(t, repo, "/two")
+
+	// without a catalog, nothing is updated
+	r := reopenRepository(t, repo)
+	rtest.OK(t, r.UpdateSnapshotCatalog(ctx, restic.IDs{id1}, nil))
+	rtest.Equals(t, 0, len(listFiles(t, repo, restic.CatalogFile)))
+
+	n, err := reopenRepository(t, repo).CreateSnapshotCatalog(ctx)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, n)
+
+	r = reopenRepository(t, repo)
+	id3 := saveTestSnapshot(t, r, "/three")
+	rtest.OK(t, r.UpdateSnapshotCatalog(ctx, restic.IDs{id3}, restic.IDs{id2}))
+	rtest.Equals(t, 1, len(listFiles(t, repo, restic.CatalogFile)))
+
+	// snapshots contained in the catalog are loaded from it
+	removeSnapshotFile(t, repo, id1)
+	removeSnapshotFile(t, repo, id3)
+	r = reopenRepository(t, repo)
+	for id, path := range map[restic.ID]string{id1: "/one", id3: "/three"} {
+		sn, err := restic.LoadSnapshot(ctx, r, id)
+		rtest.OK(t, err)
+		rtest.Equals(t, []string{path}, sn.Paths)
+	}
+	sn, err := restic.LoadSnapshot(ctx, r, id2)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"/two"}, sn.Paths)
+
+	r = reopenRepository(t, repo)
+	r.DisableSnapshotCatalog()
+	_, err = restic.LoadSnapshot(ctx, r, id1)
+	rtest.Assert(t, err != nil, "snapshot loaded from disabled catalog")
+
+	rtest.OK(t, reopenRepository(t, repo).RemoveSnapshotCatalog(ctx))
+	rtest.Equals(t, 0, len(listFiles(t, repo, restic.CatalogFile)))
+}
+
+func listFiles(t *testing.T, repo restic.Repository, tpe restic.FileType) restic.IDs {
+	var ids restic.IDs
+	rtest.OK(t, repo.List(context.TODO(), tpe, func(id restic.ID, _ int64) error {
+		ids = append(ids, id)
+		return nil
+	}))
+	return ids
+}
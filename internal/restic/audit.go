@@ -0,0 +1,248 @@
+package restic
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AuditEntry records a destructive operation performed on the repository.
+// Each entry references the newest entry known at the time it was written,
+// so that removed entries can be detected as gaps in the chain. Removal of
+// the newest entries is detected using the AuditHead.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Details   string    `json:"details,omitempty"`
+	Snapshots IDs       `json:"snapshots,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	UID       uint32    `json:"uid,omitempty"`
+	GID       uint32    `json:"gid,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Key       *ID       `json:"key,omitempty"`
+	Previous  *ID       `json:"previous,omitempty"`
+	Sequence  uint64    `json:"sequence,omitempty"`
+
+	id *ID
+}
+
+// AuditHead references the newest audit entry. It is stored encrypted and
+// can therefore not be forged without access to a key.
+type AuditHead struct {
+	Latest   ID        `json:"latest"`
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+
+	id *ID
+}
+
+// NewAuditEntry returns an audit entry for the operation, filled with
+// information about the current host and user.
+func NewAuditEntry(operation string, details string, snapshots IDs, t time.Time) *AuditEntry {
+	e := &AuditEntry{
+		Time:      t,
+		Operation: operation,
+		Details:   details,
+		Snapshots: snapshots,
+	}
+
+	hn, err := os.Hostname()
+	if err == nil {
+		e.Hostname = hn
+	}
+
+	usr, err := user.Current()
+	if err == nil {
+		e.Username = usr.Username
+		// ignore errors, the IDs are purely informational
+		e.UID, e.GID, _ = uidGidInt(usr)
+	}
+
+	return e
+}
+
+// ID returns the ID of the audit entry.
+func (e AuditEntry) ID() *ID {
+	return e.id
+}
+
+func (e AuditEntry) String() string {
+	return fmt.Sprintf("<AuditEntry %s %v at %s by %s@%s>",
+		e.id.Str(), e.Operation, e.Time, e.Username, e.Hostname)
+}
+
+// ID returns the ID of the audit head.
+func (h AuditHead) ID() *ID {
+	return h.id
+}
+
+// LoadAuditEntry loads the audit entry with the id and returns it.
+func LoadAuditEntry(ctx context.Context, loader LoaderUnpacked, id ID) (*AuditEntry, error) {
+	e := &AuditEntry{id: &id}
+	err := LoadJSONUnpacked(ctx, loader, AuditFile, id, e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit entry %v: %w", id.Str(), err)
+	}
+
+	return e, nil
+}
+
+// ForAllAuditEntries reads all audit entries in parallel and calls the given
+// function. It is guaranteed that the function is not run concurrently.
+func ForAllAuditEntries(ctx context.Context, be Lister, loader LoaderUnpacked, fn func(ID, *AuditEntry, error) error) error {
+	var m sync.Mutex
+
+	return ParallelList(ctx, be, AuditFile, loader.Connections(), func(ctx context.Context, id ID, size int64) error {
+		e, err := LoadAuditEntry(ctx, loader, id)
+		m.Lock()
+		defer m.Unlock()
+		return fn(id, e, err)
+	})
+}
+
+// LoadAllAuditEntries returns all audit entries sorted by time, oldest first.
+func LoadAllAuditEntries(ctx context.Context, be Lister, loader LoaderUnpacked) ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+	err := ForAllAuditEntries(ctx, be, loader, func(id ID, e *AuditEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// LoadAuditHead returns the audit head with the highest sequence number,
+// or nil if the repository does not contain an audit head. All audit heads
+// are returned in the second return value.
+func LoadAuditHead(ctx context.Context, be Lister, loader LoaderUnpacked) (*AuditHead, []*AuditHead, error) {
+	var m sync.Mutex
+	var heads []*AuditHead
+
+	err := ParallelList(ctx, be, AuditHeadFile, loader.Connections(), func(ctx context.Context, id ID, size int64) error {
+		h := &AuditHead{id: &id}
+		err := LoadJSONUnpacked(ctx, loader, AuditHeadFile, id, h)
+		if err != nil {
+			return fmt.Errorf("failed to load audit head %v: %w", id.Str(), err)
+		}
+		m.Lock()
+		heads = append(heads, h)
+		m.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var head *AuditHead
+	for _, h := range heads {
+		if head == nil || h.Sequence > head.Sequence ||
+			(h.Sequence == head.Sequence && h.Time.After(head.Time)) {
+			head = h
+		}
+	}
+	return head, heads, nil
+}
+
+// AppendAuditEntry links the entry to the newest existing audit entry, saves
+// it in the repository and updates the audit head. Only the audit head is
+// read, all entries are only loaded for audit logs without one.
+func AppendAuditEntry(ctx context.Context, repo Repository, e *AuditEntry) (ID, error) {
+	head, heads, err := LoadAuditHead(ctx, repo.Backend(), repo)
+	if err != nil {
+		return ID{}, err
+	}
+
+	if head != nil {
+		latest := head.Latest
+		e.Previous = &latest
+		e.Sequence = head.Sequence + 1
+	} else {
+		// audit log written by an older version
+		entries, err := LoadAllAuditEntries(ctx, repo.Backend(), repo)
+		if err != nil {
+			return ID{}, err
+		}
+		for _, old := range entries {
+			if old.Sequence > 0 {
+				return ID{}, fmt.Errorf("audit head is missing, the audit log has been tampered with")
+			}
+		}
+		if len(entries) > 0 {
+			e.Previous = entries[len(entries)-1].ID()
+		}
+		e.Sequence = uint64(len(entries)) + 1
+	}
+
+	id, err := SaveJSONUnpacked(ctx, repo, AuditFile, e)
+	if err != nil {
+		return ID{}, err
+	}
+	e.id = &id
+
+	newHead := &AuditHead{Latest: id, Sequence: e.Sequence, Time: e.Time}
+	headID, err := SaveJSONUnpacked(ctx, repo, AuditHeadFile, newHead)
+	if err != nil {
+		return ID{}, err
+	}
+	newHead.id = &headID
+
+	for _, h := range heads {
+		err = repo.Backend().Remove(ctx, Handle{Type: AuditHeadFile, Name: h.id.String()})
+		if err != nil {
+			return ID{}, err
+		}
+	}
+	return id, nil
+}
+
+// CheckAuditHead verifies that the newest audit entries have not been
+// removed. The audit head must exist once an entry with a sequence number
+// has been written, and the entry it references must exist.
+func CheckAuditHead(entries []*AuditEntry, head *AuditHead) error {
+	if head == nil {
+		for _, e := range entries {
+			if e.Sequence > 0 {
+				return fmt.Errorf("audit head is missing, the newest audit entries may have been removed")
+			}
+		}
+		return nil
+	}
+
+	for _, e := range entries {
+		if *e.ID() == head.Latest {
+			return nil
+		}
+	}
+	return fmt.Errorf("audit entry %v referenced by the audit head is missing", head.Latest.Str())
+}
+
+// FindAuditGaps returns the IDs of all audit entries which are referenced
+// by another entry but do not exist, indicating that they were removed.
+func FindAuditGaps(entries []*AuditEntry) IDs {
+	known := NewIDSet()
+	for _, e := range entries {
+		known.Insert(*e.ID())
+	}
+
+	var missing IDs
+	for _, e := range entries {
+		if e.Previous != nil && !known.Has(*e.Previous) {
+			missing = append(missing, *e.Previous)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,122 @@
+package restic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestAuditLog(t *testing.T) {
+	repo := repository.TestRepository(t)
+	ctx := context.TODO()
+
+	start := time.Now()
+	var ids restic.IDs
+	for i := 0; i < 3; i++ {
+		e := restic.NewAuditEntry("forget", "", restic.IDs{restic.NewRandomID()}, start.Add(time.Duration(i)*time.Second))
+		id, err := restic.AppendAuditEntry(ctx, repo, e)
+		rtest.OK(t, err)
+		ids = append(ids, id)
+	}
+
+	entries, err := restic.LoadAllAuditEntries(ctx, repo.Backend(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, len(entries))
+	for i, e := range entries {
+		rtest.Equals(t, ids[i], *e.ID())
+		if i == 0 {
+			rtest.Assert(t, e.Previous == nil, "first entry has previous entry %v", e.Previous)
+		} else {
+			rtest.Equals(t, ids[i-1], *e.Previous)
+		}
+	}
+	rtest.Equals(t, 0, len(restic.FindAuditGaps(entries)))
+
+	// removing an entry in the middle of the chain must be detected
+	rtest.OK(t, repo.Backend().Remove(ctx, restic.Handle{Type: restic.AuditFile, Name: ids[1].String()}))
+	entries, err = restic.LoadAllAuditEntries(ctx, repo.Backend(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.IDs{ids[1]}, restic.FindAuditGaps(entries))
+}
+
+func appendTestAuditEntries(t *testing.T, repo restic.Repository, n int) restic.IDs {
+	start := time.Now()
+	var ids restic.IDs
+	for i := 0; i < n; i++ {
+		e := restic.NewAuditEntry("forget", "", nil, start.Add(time.Duration(i)*time.Second))
+		id, err := restic.AppendAuditEntry(context.TODO(), repo, e)
+		rtest.OK(t, err)
+		rtest.Equals(t, uint64(i+1), e.Sequence)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestAuditHead(t *testing.T) {
+	repo := repository.TestRepository(t)
+	ctx := context.TODO()
+	ids := appendTestAuditEntries(t, repo, 3)
+
+	head, heads, err := restic.LoadAuditHead(ctx, repo.Backend(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(heads))
+	rtest.Equals(t, ids[2], head.Latest)
+	rtest.Equals(t, uint64(3), head.Sequence)
+
+	entries, err := restic.LoadAllAuditEntries(ctx, repo.Backend(), repo)
+	rtest.OK(t, err)
+	rtest.OK(t, restic.CheckAuditHead(entries, head))
+
+	// removing the newest entry must be detected
+	rtest.OK(t, repo.Backend().Remove(ctx, restic.Handle{Type: restic.AuditFile, Name: ids[2].String()}))
+	entries, err = restic.LoadAllAuditEntries(ctx, repo.Backend(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(restic.FindAuditGaps(entries)))
+	rtest.Assert(t, restic.CheckAuditHead(entries, head) != nil, "removed newest entry not detected")
+
+	// removing the audit head as well must be detected
+	rtest.OK(t, repo.Backend().Remove(ctx, restic.Handle{Type: restic.AuditHeadFile, Name: head.ID().String()}))
+	head, _, err = restic.LoadAuditHead(ctx, repo.Backend(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, head == nil, "unexpected audit head %v", head)
+	rtest.Assert(t, restic.CheckAuditHead(entries, head) != nil, "removed audit head not detected")
+
+	_, err = restic.AppendAuditEntry(ctx, repo, restic.NewAuditEntry("forget", "", nil, time.Now()))
+	rtest.Assert(t, err != nil, "appending to audit log without head did not fail")
+}
+
+func TestAuditAppendLoadsOnlyHead(t *testing.T) {
+	repo := repository.TestRepository(t)
+	ctx := context.TODO()
+	ids := appendTestAuditEntries(t, repo, 2)
+
+	// an unreadable entry does not affect appending, as only the head is read
+	h := restic.Handle{Type: restic.AuditFile, Name: restic.NewRandomID().String()}
+	rtest.OK(t, repo.Backend().Save(ctx, h, restic.NewByteReader([]byte("invalid"), repo.Backend().Hasher())))
+
+	e := restic.NewAuditEntry("forget", "", nil, time.Now())
+	_, err := restic.AppendAuditEntry(ctx, repo, e)
+	rtest.OK(t, err)
+	rtest.Equals(t, ids[1], *e.Previous)
+	rtest.Equals(t, uint64(3), e.Sequence)
+}
+
+func TestAuditAppendWithoutHead(t *testing.T) {
+	repo := repository.TestRepository(t)
+	ctx := context.TODO()
+
+	// audit entries written by an older version have no sequence number
+	old := restic.NewAuditEntry("forget", "", nil, time.Now())
+	id, err := restic.SaveJSONUnpacked(ctx, repo, restic.AuditFile, old)
+	rtest.OK(t, err)
+
+	e := restic.NewAuditEntry("forget", "", nil, time.Now().Add(time.Second))
+	_, err = restic.AppendAuditEntry(ctx, repo, e)
+	rtest.OK(t, err)
+	rtest.Equals(t, id, *e.Previous)
+	rtest.Equals(t, uint64(2), e.Sequence)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"hash"
 	"io"
+	"time"
 )
 
 // Backend is used to store and access data.
@@ -70,6 +71,38 @@ type Backend interface {
 	Delete(ctx context.Context) error
 }
 
+// RetentionBackend is implemented by backends which can protect files from
+// being removed until their retention period has expired.
+type RetentionBackend interface {
+	Backend
+
+	// RetentionEnabled returns whether new files are protected from removal.
+	RetentionEnabled() bool
+
+	// RetainUntil returns the time until which the file cannot be removed.
+	// Files without retention yield the zero time.
+	RetainUntil(ctx context.Context, h Handle) (time.Time, error)
+
+	// ExtendRetention renews the retention period of the file, such that
+	// files which are still in use remain protected. It returns whether the
+	// retention was changed.
+	ExtendRetention(ctx context.Context, h Handle) (bool, error)
+}
+
+// StagingBackend is implemented by backends which can store files in archive
+// storage, from which they must be retrieved before they can be read.
+type StagingBackend interface {
+	Backend
+
+	// Stage requests that the file is made available for reading, if it is
+	// archived. It returns true once the file can be read.
+	Stage(ctx context.Context, h Handle) (bool, error)
+
+	// Archived returns whether the file is in archive storage and cannot be
+	// read without staging it first.
+	Archived(ctx context.Context, h Handle) (bool, error)
+}
+
 type BackendUnwrapper interface {
 	// Unwrap returns the underlying backend or nil if there is none.
 	Unwrap() Backend
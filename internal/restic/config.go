@@ -2,8 +2,11 @@ package restic
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/restic/restic/internal/blake3"
 	"github.com/restic/restic/internal/errors"
 
 	"github.com/restic/restic/internal/debug"
@@ -16,10 +19,212 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// Filters lists the blob filters which the data of all blobs is passed
+	// through before it is compressed and encrypted.
+	Filters []string `json:"filters,omitempty"`
+
+	// BlobHash is the hash algorithm used to compute the IDs of blobs from
+	// their plaintext, see BlobHashSHA256 and BlobHashBLAKE3. If empty,
+	// SHA-256 is used. The names of files in the backend are always the
+	// SHA-256 hash of their content.
+	BlobHash string `json:"blob_hash,omitempty"`
+
+	// Capabilities lists the features used by the repository. A client must
+	// support all of them to access the repository.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// WriteCapabilities lists features which a client must support to remove
+	// or modify data in the repository. Clients which do not support them
+	// can still read the repository.
+	WriteCapabilities []string `json:"write_capabilities,omitempty"`
+
+	// Parity configures the parity files stored for each pack file, see
+	// CapabilityParity.
+	Parity *ParityConfig `json:"parity,omitempty"`
+
+	// Defaults maps the names of options to the values which clients use
+	// unless the option was set explicitly.
+	Defaults map[string][]string `json:"defaults,omitempty"`
+}
+
+// ParityConfig describes the Reed-Solomon code used for the parity files.
+type ParityConfig struct {
+	DataShards   int `json:"data_shards"`
+	ParityShards int `json:"parity_shards"`
+}
+
+// Capabilities known to this version of restic.
+const (
+	// CapabilityCompression is set for repositories which contain
+	// compressed blobs, that is all repositories since version 2.
+	CapabilityCompression = "compression"
+	// CapabilityBlobFilters is set if the config lists blob filters.
+	CapabilityBlobFilters = "blob-filters"
+	// CapabilityProtectedSnapshots is set as a write capability once a
+	// snapshot has been protected from removal.
+	CapabilityProtectedSnapshots = "protected-snapshots"
+	// CapabilityParity is set as a write capability if a parity file is
+	// stored for each pack file.
+	CapabilityParity = "parity"
+	// CapabilityBlobHash is set if the IDs of blobs are not computed using
+	// SHA-256, but with the algorithm named in the config.
+	CapabilityBlobHash = "blob-hash"
+	// CapabilityDataKeys is set once the contents of a file are encrypted
+	// with a data key, see DataKeyFile.
+	CapabilityDataKeys = "data-keys"
+	// CapabilityFileBundles is set if the contents of several small files
+	// may be stored in one data blob, see ContentBundle.
+	CapabilityFileBundles = "file-bundles"
+	// CapabilityContentEncoding is set if files may be stored decoded and
+	// must be reconstructed on restore, see ContentEncoding.
+	CapabilityContentEncoding = "content-encoding"
+)
+
+// Hash algorithms for the IDs of blobs.
+const (
+	BlobHashSHA256 = "sha256"
+	BlobHashBLAKE3 = "blake3"
+)
+
+// BlobHashFunc computes the ID of a blob from its plaintext.
+type BlobHashFunc func(data []byte) ID
+
+// LookupBlobHash returns the function for the named blob hash algorithm. The
+// empty name selects SHA-256.
+func LookupBlobHash(name string) (BlobHashFunc, error) {
+	switch name {
+	case "", BlobHashSHA256:
+		return Hash, nil
+	case BlobHashBLAKE3:
+		return func(data []byte) ID {
+			return blake3.Sum256(data)
+		}, nil
+	}
+	return nil, errors.Errorf("unknown blob hash algorithm %q", name)
+}
+
+// KnownCapabilities maps the capabilities supported by this version of restic
+// to a short description.
+var KnownCapabilities = map[string]string{
+	CapabilityCompression:        "blobs may be compressed",
+	CapabilityBlobFilters:        "blob data is transformed by the filters listed in the config",
+	CapabilityProtectedSnapshots: "snapshots can be protected from removal",
+	CapabilityParity:             "pack files are protected by parity files",
+	CapabilityBlobHash:           "blob IDs are computed with the hash algorithm listed in the config",
+	CapabilityDataKeys:           "the contents of files may be encrypted with data keys",
+	CapabilityFileBundles:        "small files may be stored together in one data blob",
+	CapabilityContentEncoding:    "files may be stored decoded and are reconstructed on restore",
+}
+
+// CapabilitiesRepoVersion is the first repository version for which
+// clients must check the capabilities listed in the config. Versions of
+// restic which predate the capabilities ignore them, but refuse to open a
+// repository with a version above 2. Adding a capability other than
+// compression therefore upgrades the repository to this version.
+const CapabilitiesRepoVersion = 3
+
+// version3Capabilities are enabled for every repository of version 3, as they
+// are used by routine commands which must not rewrite the config. The value
+// states whether the capability is a write capability.
+var version3Capabilities = map[string]bool{
+	CapabilityProtectedSnapshots: true,
+	CapabilityDataKeys:           false,
+	CapabilityFileBundles:        false,
+	CapabilityContentEncoding:    false,
+}
+
+// UpgradeToVersion3 sets the version of the config to 3 and enables the
+// capabilities which are available for all repositories of that version.
+func (cfg *Config) UpgradeToVersion3() {
+	if cfg.Version < CapabilitiesRepoVersion {
+		cfg.Version = CapabilitiesRepoVersion
+	}
+	cfg.AddCapability(CapabilityCompression, false)
+	for name, write := range version3Capabilities {
+		cfg.AddCapability(name, write)
+	}
+}
+
+// HasCapability returns true if the repository uses the named capability,
+// either for reading or writing.
+func (cfg Config) HasCapability(name string) bool {
+	for _, list := range [][]string{cfg.Capabilities, cfg.WriteCapabilities} {
+		for _, c := range list {
+			if c == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddCapability adds the named capability to the config. The lists of
+// capabilities are kept sorted. All capabilities except compression require at
+// least repository version 3, see CapabilitiesRepoVersion.
+func (cfg *Config) AddCapability(name string, write bool) {
+	if cfg.HasCapability(name) {
+		return
+	}
+	if name != CapabilityCompression && cfg.Version < CapabilitiesRepoVersion {
+		cfg.UpgradeToVersion3()
+		if cfg.HasCapability(name) {
+			return
+		}
+	}
+
+	list := &cfg.Capabilities
+	if write {
+		list = &cfg.WriteCapabilities
+	}
+	// do not modify the backing array of copies of the config
+	*list = append(append([]string{}, *list...), name)
+	sort.Strings(*list)
+}
+
+func unsupported(list []string) []string {
+	var missing []string
+	for _, c := range list {
+		if _, ok := KnownCapabilities[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// HashBlob computes the ID of a blob from its plaintext, using the blob hash
+// algorithm of the repository. LoadConfig rejects unknown algorithms.
+func (cfg Config) HashBlob(data []byte) ID {
+	if cfg.BlobHash == BlobHashBLAKE3 {
+		return blake3.Sum256(data)
+	}
+	return Hash(data)
+}
+
+// RequireCapability returns an error if the repository does not list the named
+// capability. Routine commands do not rewrite the config to add capabilities,
+// instead all capabilities are enabled when upgrading a repository to version
+// 3. The feature describes what requires the capability for the error
+// message.
+func (cfg Config) RequireCapability(name, feature string) error {
+	if cfg.HasCapability(name) {
+		return nil
+	}
+	return errors.Fatalf("%v requires repository version %d, run `restic migrate upgrade_repo_v3` to upgrade the repository",
+		feature, CapabilitiesRepoVersion)
+}
+
+// CheckWriteCapabilities returns an error if the repository uses write
+// capabilities which are not supported by this version of restic.
+func (cfg Config) CheckWriteCapabilities() error {
+	if missing := unsupported(cfg.WriteCapabilities); len(missing) > 0 {
+		return errors.Fatalf("the repository uses the features %v which are not supported by this version of restic, please upgrade restic to modify the repository",
+			strings.Join(missing, ", "))
+	}
+	return nil
 }
 
 const MinRepoVersion = 1
-const MaxRepoVersion = 2
+const MaxRepoVersion = 3
 
 // StableRepoVersion is the version that is written to the config when a repository
 // is newly created with Init().
@@ -45,6 +250,9 @@ func CreateConfig(version uint) (Config, error) {
 
 	cfg.ID = NewRandomID().String()
 	cfg.Version = version
+	if version >= CapabilitiesRepoVersion {
+		cfg.UpgradeToVersion3()
+	}
 
 	debug.Log("New config: %#v", cfg)
 	return cfg, nil
@@ -62,6 +270,9 @@ func TestCreateConfig(t testing.TB, pol chunker.Pol, version uint) (cfg Config)
 		t.Fatalf("version %d is out of range", version)
 	}
 	cfg.Version = version
+	if version >= CapabilitiesRepoVersion {
+		cfg.UpgradeToVersion3()
+	}
 
 	return cfg
 }
@@ -90,6 +301,15 @@ func LoadConfig(ctx context.Context, r LoaderUnpacked) (Config, error) {
 		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
 	}
 
+	if missing := unsupported(cfg.Capabilities); len(missing) > 0 {
+		return Config{}, errors.Errorf("the repository uses the features %v which are not supported by this version of restic, please upgrade restic",
+			strings.Join(missing, ", "))
+	}
+
+	if _, err := LookupBlobHash(cfg.BlobHash); err != nil {
+		return Config{}, err
+	}
+
 	if checkPolynomial {
 		if !cfg.ChunkerPolynomial.Irreducible() {
 			return Config{}, errors.New("invalid chunker polynomial")
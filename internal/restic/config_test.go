@@ -60,6 +60,88 @@ func TestConfig(t *testing.T) {
 	cfg2, err := restic.LoadConfig(context.TODO(), loader{load})
 	rtest.OK(t, err)
 
-	rtest.Assert(t, cfg1 == cfg2,
-		"configs aren't equal: %v != %v", cfg1, cfg2)
+	rtest.Equals(t, cfg1, cfg2)
+}
+
+func TestConfigCapabilities(t *testing.T) {
+	cfg, err := restic.CreateConfig(restic.MaxRepoVersion)
+	rtest.OK(t, err)
+	rtest.Assert(t, cfg.HasCapability(restic.CapabilityCompression), "compression capability missing")
+
+	cfg.AddCapability(restic.CapabilityProtectedSnapshots, true)
+	cfg.AddCapability(restic.CapabilityProtectedSnapshots, false)
+	rtest.Equals(t, []string{restic.CapabilityProtectedSnapshots}, cfg.WriteCapabilities)
+	rtest.OK(t, cfg.CheckWriteCapabilities())
+
+	var buf []byte
+	save := func(tpe restic.FileType, data []byte) (restic.ID, error) {
+		buf = data
+		return restic.ID{}, nil
+	}
+	load := func(tpe restic.FileType, id restic.ID) ([]byte, error) {
+		return buf, nil
+	}
+
+	// unknown write capabilities only prevent modifications
+	write := cfg
+	write.AddCapability("future-write-feature", true)
+	rtest.OK(t, restic.SaveConfig(context.TODO(), saver{save}, write))
+	loaded, err := restic.LoadConfig(context.TODO(), loader{load})
+	rtest.OK(t, err)
+	rtest.Assert(t, loaded.CheckWriteCapabilities() != nil, "unknown write capability was accepted")
+	rtest.Equals(t, []string{restic.CapabilityProtectedSnapshots}, cfg.WriteCapabilities)
+
+	cfg.AddCapability("future-feature", false)
+	rtest.OK(t, restic.SaveConfig(context.TODO(), saver{save}, cfg))
+	_, err = restic.LoadConfig(context.TODO(), loader{load})
+	rtest.Assert(t, err != nil, "config with unknown capability was loaded")
+}
+
+func TestConfigCapabilitiesVersion(t *testing.T) {
+	cfg, err := restic.CreateConfig(2)
+	rtest.OK(t, err)
+	rtest.OK(t, cfg.CheckWriteCapabilities())
+	rtest.Assert(t, cfg.RequireCapability(restic.CapabilityProtectedSnapshots, "test") != nil,
+		"version 2 repository supports protected snapshots")
+
+	// clients which predate the capabilities must refuse to open the repository
+	cfg.AddCapability(restic.CapabilityBlobHash, false)
+	rtest.Equals(t, uint(restic.CapabilitiesRepoVersion), cfg.Version)
+	rtest.Equals(t, []string{restic.CapabilityBlobHash, restic.CapabilityCompression, restic.CapabilityContentEncoding, restic.CapabilityDataKeys, restic.CapabilityFileBundles}, cfg.Capabilities)
+	rtest.Equals(t, []string{restic.CapabilityProtectedSnapshots}, cfg.WriteCapabilities)
+	rtest.OK(t, cfg.RequireCapability(restic.CapabilityProtectedSnapshots, "test"))
+
+	cfg, err = restic.CreateConfig(restic.CapabilitiesRepoVersion)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{restic.CapabilityCompression, restic.CapabilityContentEncoding, restic.CapabilityDataKeys, restic.CapabilityFileBundles}, cfg.Capabilities)
+}
+
+func TestConfigBlobHash(t *testing.T) {
+	data := []byte("foobar")
+	cfg, err := restic.CreateConfig(restic.MaxRepoVersion)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.Hash(data), cfg.HashBlob(data))
+
+	var buf []byte
+	save := func(tpe restic.FileType, data []byte) (restic.ID, error) {
+		buf = data
+		return restic.ID{}, nil
+	}
+	load := func(tpe restic.FileType, id restic.ID) ([]byte, error) {
+		return buf, nil
+	}
+
+	cfg.BlobHash = restic.BlobHashBLAKE3
+	cfg.AddCapability(restic.CapabilityBlobHash, false)
+	rtest.OK(t, restic.SaveConfig(context.TODO(), saver{save}, cfg))
+	loaded, err := restic.LoadConfig(context.TODO(), loader{load})
+	rtest.OK(t, err)
+	id := loaded.HashBlob(data)
+	rtest.Assert(t, !id.Equal(restic.Hash(data)), "blob ID was computed using SHA-256")
+	rtest.Equals(t, "aa51dcd43d5c6c5203ee16906fd6b35db298b9b2e1de3fce81811d4806b76b7d", id.String())
+
+	cfg.BlobHash = "md5"
+	rtest.OK(t, restic.SaveConfig(context.TODO(), saver{save}, cfg))
+	_, err = restic.LoadConfig(context.TODO(), loader{load})
+	rtest.Assert(t, err != nil, "config with unknown blob hash was loaded")
 }
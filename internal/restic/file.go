@@ -17,6 +17,13 @@ const (
 	SnapshotFile
 	IndexFile
 	ConfigFile
+	AuditFile
+	CatalogFile
+	ParityFile
+	ScrubFile
+	DataKeyFile
+	SearchIndexFile
+	AuditHeadFile
 )
 
 func (t FileType) String() string {
@@ -35,6 +42,20 @@ func (t FileType) String() string {
 		s = "index"
 	case ConfigFile:
 		s = "config"
+	case AuditFile:
+		s = "audit"
+	case CatalogFile:
+		s = "catalog"
+	case ParityFile:
+		s = "parity"
+	case ScrubFile:
+		s = "scrub"
+	case DataKeyFile:
+		s = "datakey"
+	case SearchIndexFile:
+		s = "searchindex"
+	case AuditHeadFile:
+		s = "audithead"
 	}
 	return s
 }
@@ -63,6 +84,13 @@ func (h Handle) Valid() error {
 	case SnapshotFile:
 	case IndexFile:
 	case ConfigFile:
+	case AuditFile:
+	case CatalogFile:
+	case ParityFile:
+	case ScrubFile:
+	case DataKeyFile:
+	case SearchIndexFile:
+	case AuditHeadFile:
 	default:
 		return errors.Errorf("invalid Type %d", h.Type)
 	}
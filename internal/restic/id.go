@@ -9,7 +9,8 @@ import (
 	"github.com/minio/sha256-simd"
 )
 
-// Hash returns the ID for data.
+// Hash returns the SHA-256 ID for data, as used for the names of files in the
+// backend. The IDs of blobs are computed using Config.HashBlob.
 func Hash(data []byte) ID {
 	return sha256.Sum256(data)
 }
@@ -0,0 +1,9 @@
+package restic
+
+// Extended attributes which `restic mount` provides for the directories of
+// mounted snapshots. They identify the repository and the tree a directory
+// was loaded from. As they are not listed, they are never stored in a snapshot.
+const (
+	MountRepositoryXattr = "user.restic.repository"
+	MountTreeXattr       = "user.restic.tree"
+)
@@ -25,6 +25,61 @@ type ExtendedAttribute struct {
 	Value []byte `json:"value"`
 }
 
+// ContentEncoding describes how the original contents of a file are
+// reconstructed from the data referenced by the node. Currently only gzip
+// compressed files, which are stored decompressed, are supported.
+type ContentEncoding struct {
+	Format  string `json:"format"`
+	Level   int    `json:"level"`
+	Name    string `json:"name,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	ModTime uint32 `json:"mtime,omitempty"`
+	OS      byte   `json:"os"`
+	Extra   []byte `json:"extra,omitempty"`
+	// Size is the length of the stored, decoded content.
+	Size uint64 `json:"size"`
+	// Hash is the SHA-256 hash of the original file, the reconstructed file
+	// is checked against it.
+	Hash *ID `json:"hash,omitempty"`
+}
+
+// Equal returns true if both encodings are identical.
+func (e *ContentEncoding) Equal(other *ContentEncoding) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	return e.Format == other.Format && e.Level == other.Level &&
+		e.Name == other.Name && e.Comment == other.Comment &&
+		e.ModTime == other.ModTime && e.OS == other.OS &&
+		bytes.Equal(e.Extra, other.Extra) && e.Size == other.Size &&
+		(e.Hash == nil) == (other.Hash == nil) &&
+		(e.Hash == nil || e.Hash.Equal(*other.Hash))
+}
+
+// ContentBundle describes the position of a small file within a data blob,
+// which contains the concatenated contents of several files. The file consists
+// of node.Size bytes starting at Offset within the only blob of the node.
+type ContentBundle struct {
+	Offset uint64 `json:"offset"`
+}
+
+// Equal returns true if both bundle positions are identical.
+func (b *ContentBundle) Equal(other *ContentBundle) bool {
+	if b == nil || other == nil {
+		return b == other
+	}
+	return b.Offset == other.Offset
+}
+
+// FileHole is a range of a sparse file which was not backed by any data on
+// disk. The data blobs of the file still contain the range, which consists of
+// zero bytes unless the file was written after the holes were detected. Holes
+// are only recreated on restore if the data is zero.
+type FileHole struct {
+	Offset uint64 `json:"offset"`
+	Length uint64 `json:"length"`
+}
+
 // Node is a file, directory or other item in a backup.
 type Node struct {
 	Name               string              `json:"name"`
@@ -45,6 +100,10 @@ type Node struct {
 	ExtendedAttributes []ExtendedAttribute `json:"extended_attributes,omitempty"`
 	Device             uint64              `json:"device,omitempty"` // in case of Type == "dev", stat.st_rdev
 	Content            IDs                 `json:"content"`
+	ContentEncoding    *ContentEncoding    `json:"content_encoding,omitempty"`
+	Bundle             *ContentBundle      `json:"bundle,omitempty"`
+	Holes              []FileHole          `json:"holes,omitempty"`
+	DataKey            *ID                 `json:"data_key,omitempty"` // ID of the key the data blobs are encrypted with
 	Subtree            *ID                 `json:"subtree,omitempty"`
 
 	Error string `json:"error,omitempty"`
@@ -413,7 +472,7 @@ func (node Node) Equals(other Node) bool {
 	if !node.sameContent(other) {
 		return false
 	}
-	if !node.sameExtendedAttributes(other) {
+	if !node.SameExtendedAttributes(other) {
 		return false
 	}
 	if node.Subtree != nil {
@@ -437,6 +496,25 @@ func (node Node) Equals(other Node) bool {
 }
 
 func (node Node) sameContent(other Node) bool {
+	if !node.ContentEncoding.Equal(other.ContentEncoding) {
+		return false
+	}
+	if !node.Bundle.Equal(other.Bundle) {
+		return false
+	}
+	if (node.DataKey == nil) != (other.DataKey == nil) ||
+		(node.DataKey != nil && !node.DataKey.Equal(*other.DataKey)) {
+		return false
+	}
+	if len(node.Holes) != len(other.Holes) {
+		return false
+	}
+	for i := range node.Holes {
+		if node.Holes[i] != other.Holes[i] {
+			return false
+		}
+	}
+
 	if node.Content == nil {
 		return other.Content == nil
 	}
@@ -457,7 +535,9 @@ func (node Node) sameContent(other Node) bool {
 	return true
 }
 
-func (node Node) sameExtendedAttributes(other Node) bool {
+// SameExtendedAttributes returns whether both nodes have the same extended
+// attributes, regardless of their order.
+func (node Node) SameExtendedAttributes(other Node) bool {
 	if len(node.ExtendedAttributes) != len(other.ExtendedAttributes) {
 		return false
 	}
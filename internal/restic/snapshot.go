@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,9 +26,62 @@ type Snapshot struct {
 	Tags     []string  `json:"tags,omitempty"`
 	Original *ID       `json:"original,omitempty"`
 
+	// Description is a free-text note about the snapshot, which can be
+	// edited after the snapshot was created.
+	Description string `json:"description,omitempty"`
+
+	// Annotations contain structured metadata, e.g. ticket IDs or database
+	// positions.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Protected snapshots are not removed by forget unless this is
+	// explicitly requested.
+	Protected bool `json:"protected,omitempty"`
+
+	// SourceShare is the location of the network share the snapshot was
+	// created from, if any.
+	SourceShare string `json:"source_share,omitempty"`
+
+	Signature *SnapshotSignature `json:"signature,omitempty"`
+
+	// Invocation and Summary describe the backup which created the snapshot.
+	Invocation *SnapshotInvocation `json:"invocation,omitempty"`
+	Summary    *SnapshotSummary    `json:"summary,omitempty"`
+
 	id *ID // plaintext ID, used during restore
 }
 
+// SnapshotInvocation records how restic was called to create a snapshot.
+type SnapshotInvocation struct {
+	CommandLine    []string `json:"command_line"`
+	ProgramVersion string   `json:"program_version"`
+	// Environment contains the values of selected environment variables,
+	// e.g. the ID of a CI job.
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+// SnapshotSummary contains statistics about the backup which created a
+// snapshot.
+type SnapshotSummary struct {
+	BackupStart time.Time `json:"backup_start"`
+	BackupEnd   time.Time `json:"backup_end"`
+
+	FilesNew        uint `json:"files_new"`
+	FilesChanged    uint `json:"files_changed"`
+	FilesUnmodified uint `json:"files_unmodified"`
+	DirsNew         uint `json:"dirs_new"`
+	DirsChanged     uint `json:"dirs_changed"`
+	DirsUnmodified  uint `json:"dirs_unmodified"`
+
+	DataBlobs       int    `json:"data_blobs"`
+	TreeBlobs       int    `json:"tree_blobs"`
+	DataAdded       uint64 `json:"data_added"`
+	DataAddedPacked uint64 `json:"data_added_packed"`
+
+	TotalFilesProcessed uint   `json:"total_files_processed"`
+	TotalBytesProcessed uint64 `json:"total_bytes_processed"`
+}
+
 // NewSnapshot returns an initialized snapshot struct for the current user and
 // time.
 func NewSnapshot(paths []string, tags []string, hostname string, time time.Time) (*Snapshot, error) {
@@ -194,6 +248,51 @@ func (sn *Snapshot) HasTagList(l []TagList) bool {
 	return false
 }
 
+// SetAnnotations adds the annotations to the snapshot, replacing the values of
+// existing keys. It returns true if any changes were made.
+func (sn *Snapshot) SetAnnotations(annotations map[string]string) (changed bool) {
+	for key, value := range annotations {
+		if old, ok := sn.Annotations[key]; ok && old == value {
+			continue
+		}
+		if sn.Annotations == nil {
+			sn.Annotations = make(map[string]string)
+		}
+		sn.Annotations[key] = value
+		changed = true
+	}
+	return changed
+}
+
+// RemoveAnnotations removes the annotations with the given keys and returns
+// true if any changes were made.
+func (sn *Snapshot) RemoveAnnotations(keys []string) (changed bool) {
+	for _, key := range keys {
+		if _, ok := sn.Annotations[key]; ok {
+			delete(sn.Annotations, key)
+			changed = true
+		}
+	}
+	if len(sn.Annotations) == 0 {
+		sn.Annotations = nil
+	}
+	return changed
+}
+
+// HasAnnotations returns true if the snapshot matches all filters. A filter
+// "key=value" requires the annotation key to have the value, a filter "key"
+// only requires the annotation to exist.
+func (sn *Snapshot) HasAnnotations(filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue := strings.Cut(filter, "=")
+		v, ok := sn.Annotations[key]
+		if !ok || (hasValue && v != value) {
+			return false
+		}
+	}
+	return true
+}
+
 // HasPaths returns true if the snapshot has all of the paths.
 func (sn *Snapshot) HasPaths(paths []string) bool {
 	m := make(map[string]struct{}, len(sn.Paths))
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/restic/restic/internal/errors"
@@ -82,9 +83,99 @@ func (f *SnapshotFilter) findLatest(ctx context.Context, be Lister, loader Loade
 	return latest, nil
 }
 
+// snapshotTimeFormats are the formats accepted for the time of a time-based
+// snapshot reference, they are interpreted in the local time zone.
+var snapshotTimeFormats = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+}
+
+// parseSnapshotTime parses the time of a time-based snapshot reference. Apart
+// from absolute timestamps, "now", "yesterday" (24 hours before now) and
+// durations like "3d12h" (that long before now) are accepted.
+func parseSnapshotTime(s string, now time.Time) (time.Time, error) {
+	switch s {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, format := range snapshotTimeFormats {
+		if t, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := ParseDuration(s); err == nil {
+		return now.AddDate(-d.Years, -d.Months, -d.Days).Add(time.Duration(-d.Hours) * time.Hour), nil
+	}
+
+	return time.Time{}, errors.Errorf("unable to parse time %q", s)
+}
+
+// timeRefFilter returns the filter for a time-based snapshot reference of the
+// form "latest@time" or "host:name@time", which denotes the newest snapshot
+// matching f (and the host, if given) at or before that time. ok is false if s
+// is no time-based reference.
+func (f *SnapshotFilter) timeRefFilter(s string, now time.Time) (filter *SnapshotFilter, ok bool, err error) {
+	selector, timeStr, ok := strings.Cut(s, "@")
+	if !ok {
+		return nil, false, nil
+	}
+
+	t, err := parseSnapshotTime(timeStr, now)
+	if err != nil {
+		return nil, true, err
+	}
+
+	filter = &SnapshotFilter{
+		Hosts:          f.Hosts,
+		Tags:           f.Tags,
+		Paths:          f.Paths,
+		TimestampLimit: f.TimestampLimit,
+	}
+	if filter.TimestampLimit.IsZero() || t.Before(filter.TimestampLimit) {
+		filter.TimestampLimit = t
+	}
+
+	switch {
+	case selector == "latest":
+	case strings.HasPrefix(selector, "host:") && len(selector) > len("host:"):
+		filter.Hosts = []string{strings.TrimPrefix(selector, "host:")}
+	default:
+		return nil, true, errors.Errorf("invalid snapshot reference %q, must be latest@time or host:name@time", s)
+	}
+
+	return filter, true, nil
+}
+
+// findTimeRef returns the snapshot denoted by the filter returned by
+// timeRefFilter.
+func (f *SnapshotFilter) findTimeRef(ctx context.Context, be Lister, loader LoaderUnpacked) (*Snapshot, error) {
+	sn, err := f.findLatest(ctx, be, loader)
+	if err == ErrNoSnapshotFound {
+		err = fmt.Errorf("snapshot filter (Paths:%v Tags:%v Hosts:%v) at or before %v: %w",
+			f.Paths, f.Tags, f.Hosts, f.TimestampLimit.Format(time.RFC3339), err)
+	}
+	return sn, err
+}
+
 // FindSnapshot takes a string and tries to find a snapshot whose ID matches
 // the string as closely as possible.
 func FindSnapshot(ctx context.Context, be Lister, loader LoaderUnpacked, s string) (*Snapshot, error) {
+	if filter, ok, err := (&SnapshotFilter{}).timeRefFilter(s, time.Now()); ok {
+		if err != nil {
+			return nil, err
+		}
+		return filter.findTimeRef(ctx, be, loader)
+	}
+
 	// no need to list snapshots if `s` is already a full id
 	id, err := ParseID(s)
 	if err != nil {
@@ -108,6 +199,12 @@ func (f *SnapshotFilter) FindLatest(ctx context.Context, be Lister, loader Loade
 		}
 		return sn, err
 	}
+	if filter, ok, err := f.timeRefFilter(snapshotID, time.Now()); ok {
+		if err != nil {
+			return nil, err
+		}
+		return filter.findTimeRef(ctx, be, loader)
+	}
 	return FindSnapshot(ctx, be, loader, snapshotID)
 }
 
@@ -138,6 +235,19 @@ func (f *SnapshotFilter) FindAll(ctx context.Context, be Lister, loader LoaderUn
 				if sn != nil {
 					ids.Insert(*sn.ID())
 				}
+			} else if filter, ok, ferr := f.timeRefFilter(s, time.Now()); ok {
+				usedFilter = true
+
+				err = ferr
+				if err == nil {
+					sn, err = filter.findTimeRef(ctx, be, loader)
+				}
+				if sn != nil {
+					if ids.Has(*sn.ID()) {
+						continue
+					}
+					ids.Insert(*sn.ID())
+				}
 			} else {
 				sn, err = FindSnapshot(ctx, be, loader, s)
 				if err == nil {
@@ -43,3 +43,67 @@ func TestFindLatestSnapshotWithMaxTimestamp(t *testing.T) {
 		t.Errorf("FindLatest returned wrong snapshot ID: %v", *sn.ID())
 	}
 }
+
+func TestFindSnapshotAtTime(t *testing.T) {
+	repo := repository.TestRepository(t)
+	restic.TestCreateSnapshot(t, repo, parseTimeUTC("2015-05-05 05:05:05"), 1, 0)
+	desiredSnapshot := restic.TestCreateSnapshot(t, repo, parseTimeUTC("2017-07-07 07:07:07"), 1, 0)
+	latestSnapshot := restic.TestCreateSnapshot(t, repo, parseTimeUTC("2019-09-09 09:09:09"), 1, 0)
+
+	for _, test := range []struct {
+		ref  string
+		want *restic.Snapshot
+	}{
+		{"latest@2018-08-08T08:08:08Z", desiredSnapshot},
+		{"latest@2017-07-07T07:07:07Z", desiredSnapshot},
+		{"host:foo@2018-08-08T08:08:08Z", desiredSnapshot},
+		{"latest@now", latestSnapshot},
+		{"latest@1d", latestSnapshot},
+	} {
+		sn, err := (&restic.SnapshotFilter{}).FindLatest(context.TODO(), repo.Backend(), repo, test.ref)
+		if err != nil {
+			t.Fatalf("FindLatest(%q) returned error: %v", test.ref, err)
+		}
+		if *sn.ID() != *test.want.ID() {
+			t.Errorf("FindLatest(%q) returned wrong snapshot ID: %v", test.ref, *sn.ID())
+		}
+
+		sn, err = restic.FindSnapshot(context.TODO(), repo.Backend(), repo, test.ref)
+		if err != nil {
+			t.Fatalf("FindSnapshot(%q) returned error: %v", test.ref, err)
+		}
+		if *sn.ID() != *test.want.ID() {
+			t.Errorf("FindSnapshot(%q) returned wrong snapshot ID: %v", test.ref, *sn.ID())
+		}
+	}
+
+	for _, ref := range []string{
+		"latest@2010-01-01T00:00:00Z",
+		"host:bar@now",
+		"latest@someday",
+		"foo@now",
+	} {
+		_, err := (&restic.SnapshotFilter{}).FindLatest(context.TODO(), repo.Backend(), repo, ref)
+		if err == nil {
+			t.Errorf("FindLatest(%q) did not return an error", ref)
+		}
+	}
+
+	var found restic.IDs
+	err := (&restic.SnapshotFilter{}).FindAll(context.TODO(), repo.Backend(), repo,
+		[]string{"latest@2018-08-08T08:08:08Z", desiredSnapshot.ID().String()},
+		func(id string, sn *restic.Snapshot, err error) error {
+			if err != nil {
+				t.Errorf("FindAll(%q) returned error: %v", id, err)
+				return nil
+			}
+			found = append(found, *sn.ID())
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0] != *desiredSnapshot.ID() {
+		t.Errorf("FindAll returned wrong snapshots %v", found)
+	}
+}
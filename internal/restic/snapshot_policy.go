@@ -17,6 +17,7 @@ type ExpirePolicy struct {
 	Daily         int       // keep the last n daily snapshots
 	Weekly        int       // keep the last n weekly snapshots
 	Monthly       int       // keep the last n monthly snapshots
+	Quarterly     int       // keep the last n quarterly snapshots
 	Yearly        int       // keep the last n yearly snapshots
 	Within        Duration  // keep snapshots made within this duration
 	WithinHourly  Duration  // keep hourly snapshots made within this duration
@@ -25,6 +26,97 @@ type ExpirePolicy struct {
 	WithinMonthly Duration  // keep monthly snapshots made within this duration
 	WithinYearly  Duration  // keep yearly snapshots made within this duration
 	Tags          []TagList // keep all snapshots that include at least one of the tag lists.
+
+	// Calendar determines the boundaries of the periods.
+	Calendar PolicyCalendar
+}
+
+// PolicyCalendar configures how snapshots are assigned to weeks, months,
+// quarters and years. The zero value uses weeks starting on Monday and
+// calendar months and years.
+type PolicyCalendar struct {
+	// WeekStart is the first day of a week, it defaults to Monday if unset.
+	WeekStart *time.Weekday
+	// MonthStartDay is the day of the month on which a month begins, so that
+	// for example with 26 the month of March ends on the 25th of March.
+	// Values below 2 select calendar months.
+	MonthStartDay int
+	// YearStartMonth is the first month of a (fiscal) year, quarters begin
+	// every three months from it. Zero selects January.
+	YearStartMonth time.Month
+	// KeepFirst keeps the first instead of the last snapshot of each period.
+	KeepFirst bool
+}
+
+func (c PolicyCalendar) String() string {
+	var s []string
+	if c.WeekStart != nil && *c.WeekStart != time.Monday {
+		s = append(s, fmt.Sprintf("weeks starting on %v", *c.WeekStart))
+	}
+	if c.MonthStartDay > 1 {
+		s = append(s, fmt.Sprintf("months starting on day %d", c.MonthStartDay))
+	}
+	if c.YearStartMonth > time.January {
+		s = append(s, fmt.Sprintf("years starting in %v", c.YearStartMonth))
+	}
+	if c.KeepFirst {
+		s = append(s, "keeping the first snapshot of each period")
+	}
+	return strings.Join(s, ", ")
+}
+
+// month returns the number of the month d belongs to, counted from year zero.
+func (c PolicyCalendar) month(d time.Time) int {
+	m := d.Year()*12 + int(d.Month()) - 1
+	if c.MonthStartDay > 1 && d.Day() < c.MonthStartDay {
+		m--
+	}
+	return m
+}
+
+// fiscalMonth returns the number of the month d belongs to, counted from
+// the start of the (fiscal) year zero.
+func (c PolicyCalendar) fiscalMonth(d time.Time) int {
+	m := c.month(d)
+	if c.YearStartMonth > time.January {
+		m -= int(c.YearStartMonth) - 1
+	}
+	return m
+}
+
+// week returns an integer in the form YYYYMMDD for the first day of the week d
+// belongs to.
+func (c PolicyCalendar) week(d time.Time, _ int) int {
+	weekStart := time.Monday
+	if c.WeekStart != nil {
+		weekStart = *c.WeekStart
+	}
+	offset := (int(d.Weekday()) - int(weekStart) + 7) % 7
+	start := time.Date(d.Year(), d.Month(), d.Day()-offset, 0, 0, 0, 0, time.UTC)
+	return ymd(start, 0)
+}
+
+// ym returns the number of the month of d.
+func (c PolicyCalendar) ym(d time.Time, _ int) int {
+	return c.month(d)
+}
+
+// quarter returns the number of the quarter of d.
+func (c PolicyCalendar) quarter(d time.Time, _ int) int {
+	return floorDiv(c.fiscalMonth(d), 3)
+}
+
+// y returns the number of the year of d.
+func (c PolicyCalendar) y(d time.Time, _ int) int {
+	return floorDiv(c.fiscalMonth(d), 12)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b < 0 {
+		q--
+	}
+	return q
 }
 
 func (e ExpirePolicy) String() (s string) {
@@ -40,6 +132,7 @@ func (e ExpirePolicy) String() (s string) {
 		{e.Daily, "daily"},
 		{e.Weekly, "weekly"},
 		{e.Monthly, "monthly"},
+		{e.Quarterly, "quarterly"},
 		{e.Yearly, "yearly"},
 	} {
 		if opt.count > 0 {
@@ -96,6 +189,10 @@ func (e ExpirePolicy) String() (s string) {
 
 	s = "keep " + s
 
+	if cal := e.Calendar.String(); cal != "" {
+		s += " (" + cal + ")"
+	}
+
 	return s
 }
 
@@ -105,7 +202,7 @@ func (e ExpirePolicy) Empty() bool {
 		return false
 	}
 
-	empty := ExpirePolicy{Tags: e.Tags}
+	empty := ExpirePolicy{Tags: e.Tags, Calendar: e.Calendar}
 	return reflect.DeepEqual(e, empty)
 }
 
@@ -119,22 +216,6 @@ func ymd(d time.Time, _ int) int {
 	return d.Year()*10000 + int(d.Month())*100 + d.Day()
 }
 
-// yw returns an integer in the form YYYYWW, where WW is the week number.
-func yw(d time.Time, _ int) int {
-	year, week := d.ISOWeek()
-	return year*100 + week
-}
-
-// ym returns an integer in the form YYYYMM.
-func ym(d time.Time, _ int) int {
-	return d.Year()*100 + int(d.Month())
-}
-
-// y returns the year of d.
-func y(d time.Time, _ int) int {
-	return d.Year()
-}
-
 // always returns a unique number for d.
 func always(d time.Time, nr int) int {
 	return nr
@@ -142,17 +223,16 @@ func always(d time.Time, nr int) int {
 
 // findLatestTimestamp returns the time stamp for the latest (newest) snapshot,
 // for use with policies based on time relative to latest.
-func findLatestTimestamp(list Snapshots) time.Time {
+func findLatestTimestamp(list Snapshots, now time.Time) time.Time {
 	if len(list) == 0 {
 		panic("list of snapshots is empty")
 	}
 
 	var latest time.Time
-	now := time.Now()
 	for _, sn := range list {
 		// Find the latest snapshot in the list
 		// The latest snapshot must, however, not be in the future.
-		if sn.Time.After(latest) && sn.Time.Before(now) {
+		if sn.Time.After(latest) && !sn.Time.After(now) {
 			latest = sn.Time
 		}
 	}
@@ -170,12 +250,13 @@ type KeepReason struct {
 
 	// the counters after evaluating the current snapshot
 	Counters struct {
-		Last    int `json:"last,omitempty"`
-		Hourly  int `json:"hourly,omitempty"`
-		Daily   int `json:"daily,omitempty"`
-		Weekly  int `json:"weekly,omitempty"`
-		Monthly int `json:"monthly,omitempty"`
-		Yearly  int `json:"yearly,omitempty"`
+		Last      int `json:"last,omitempty"`
+		Hourly    int `json:"hourly,omitempty"`
+		Daily     int `json:"daily,omitempty"`
+		Weekly    int `json:"weekly,omitempty"`
+		Monthly   int `json:"monthly,omitempty"`
+		Quarterly int `json:"quarterly,omitempty"`
+		Yearly    int `json:"yearly,omitempty"`
 	} `json:"counters"`
 }
 
@@ -183,6 +264,12 @@ type KeepReason struct {
 // according to the policy p. list is sorted in the process. reasons contains
 // the reasons to keep each snapshot, it is in the same order as keep.
 func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reasons []KeepReason) {
+	return applyPolicy(list, p, time.Now())
+}
+
+// applyPolicy works like ApplyPolicy, snapshots after now are ignored when
+// determining the latest snapshot.
+func applyPolicy(list Snapshots, p ExpirePolicy, now time.Time) (keep, remove Snapshots, reasons []KeepReason) {
 	sort.Stable(list)
 
 	if p.Empty() {
@@ -200,7 +287,8 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 	}
 
 	// These buckets are for keeping last n snapshots of given type
-	var buckets = [6]struct {
+	cal := p.Calendar
+	var buckets = [7]struct {
 		Count  int
 		bucker func(d time.Time, nr int) int
 		Last   int
@@ -209,9 +297,10 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 		{p.Last, always, -1, "last snapshot"},
 		{p.Hourly, ymdh, -1, "hourly snapshot"},
 		{p.Daily, ymd, -1, "daily snapshot"},
-		{p.Weekly, yw, -1, "weekly snapshot"},
-		{p.Monthly, ym, -1, "monthly snapshot"},
-		{p.Yearly, y, -1, "yearly snapshot"},
+		{p.Weekly, cal.week, -1, "weekly snapshot"},
+		{p.Monthly, cal.ym, -1, "monthly snapshot"},
+		{p.Quarterly, cal.quarter, -1, "quarterly snapshot"},
+		{p.Yearly, cal.y, -1, "yearly snapshot"},
 	}
 
 	// These buckets are for keeping snapshots of given type within duration
@@ -223,12 +312,21 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 	}{
 		{p.WithinHourly, ymdh, -1, "hourly within"},
 		{p.WithinDaily, ymd, -1, "daily within"},
-		{p.WithinWeekly, yw, -1, "weekly within"},
-		{p.WithinMonthly, ym, -1, "monthly within"},
-		{p.WithinYearly, y, -1, "yearly within"},
+		{p.WithinWeekly, cal.week, -1, "weekly within"},
+		{p.WithinMonthly, cal.ym, -1, "monthly within"},
+		{p.WithinYearly, cal.y, -1, "yearly within"},
+	}
+
+	// newPeriod returns whether the snapshot at index nr is the one to keep
+	// for its period: the newest one, or with KeepFirst the oldest one.
+	newPeriod := func(bucker func(d time.Time, nr int) int, last, val, nr int) bool {
+		if !cal.KeepFirst {
+			return val != last
+		}
+		return nr == len(list)-1 || bucker(list[nr+1].Time, nr+1) != val
 	}
 
-	latest := findLatestTimestamp(list)
+	latest := findLatestTimestamp(list, now)
 
 	for nr, cur := range list {
 		var keepSnap bool
@@ -256,7 +354,7 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 			// -1 means "keep all"
 			if b.Count > 0 || b.Count == -1 {
 				val := b.bucker(cur.Time, nr)
-				if val != b.Last {
+				if newPeriod(b.bucker, b.Last, val, nr) {
 					debug.Log("keep %v %v, bucker %v, val %v\n", cur.Time, cur.id.Str(), i, val)
 					keepSnap = true
 					buckets[i].Last = val
@@ -275,7 +373,7 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 
 				if cur.Time.After(t) {
 					val := b.bucker(cur.Time, nr)
-					if val != b.Last {
+					if newPeriod(b.bucker, b.Last, val, nr) {
 						debug.Log("keep %v, time %v, ID %v, bucker %v, val %v %v\n", b.reason, cur.Time, cur.id.Str(), i, val, b.Last)
 						keepSnap = true
 						bucketsWithin[i].Last = val
@@ -296,7 +394,8 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 			kr.Counters.Daily = buckets[2].Count
 			kr.Counters.Weekly = buckets[3].Count
 			kr.Counters.Monthly = buckets[4].Count
-			kr.Counters.Yearly = buckets[5].Count
+			kr.Counters.Quarterly = buckets[5].Count
+			kr.Counters.Yearly = buckets[6].Count
 			reasons = append(reasons, kr)
 		} else {
 			remove = append(remove, cur)
@@ -305,3 +404,152 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 
 	return keep, remove, reasons
 }
+
+// KeepProtected moves all protected snapshots from remove to keep and adds
+// a corresponding keep reason. Afterwards, keep and reasons are sorted newest
+// first again, the order of snapshots with the same time is retained.
+func KeepProtected(keep, remove Snapshots, reasons []KeepReason) (Snapshots, Snapshots, []KeepReason) {
+	var unprotected Snapshots
+	for _, sn := range remove {
+		if !sn.Protected {
+			unprotected = append(unprotected, sn)
+			continue
+		}
+
+		debug.Log("keep protected snapshot %v", sn.id.Str())
+		keep = append(keep, sn)
+		reasons = append(reasons, KeepReason{
+			Snapshot: sn,
+			Matches:  []string{"protected"},
+		})
+	}
+
+	sort.Stable(keptSnapshots{keep, reasons})
+	return keep, unprotected, reasons
+}
+
+// keptSnapshots sorts kept snapshots together with their keep reasons.
+type keptSnapshots struct {
+	snapshots Snapshots
+	reasons   []KeepReason
+}
+
+func (k keptSnapshots) Len() int {
+	return len(k.snapshots)
+}
+
+func (k keptSnapshots) Less(i, j int) bool {
+	return k.snapshots.Less(i, j)
+}
+
+func (k keptSnapshots) Swap(i, j int) {
+	k.snapshots.Swap(i, j)
+	k.reasons[i], k.reasons[j] = k.reasons[j], k.reasons[i]
+}
+
+// ApplyIDLists moves the snapshots contained in keepIDs from remove to keep and
+// the snapshots contained in forgetIDs from keep to remove, overriding the
+// decision of the policy. keepReason is recorded for snapshots kept due to
+// keepIDs. The order of keep and reasons is retained, remove stays sorted.
+func ApplyIDLists(keep, remove Snapshots, reasons []KeepReason, keepIDs, forgetIDs IDSet, keepReason string) (Snapshots, Snapshots, []KeepReason) {
+	var newKeep, newRemove Snapshots
+	var newReasons []KeepReason
+
+	for i, sn := range keep {
+		if forgetIDs.Has(*sn.ID()) {
+			debug.Log("forget listed snapshot %v", sn.id.Str())
+			newRemove = append(newRemove, sn)
+			continue
+		}
+		newKeep = append(newKeep, sn)
+		newReasons = append(newReasons, reasons[i])
+	}
+
+	for _, sn := range remove {
+		if !keepIDs.Has(*sn.ID()) {
+			newRemove = append(newRemove, sn)
+			continue
+		}
+
+		debug.Log("keep listed snapshot %v", sn.id.Str())
+		newKeep = append(newKeep, sn)
+		newReasons = append(newReasons, KeepReason{
+			Snapshot: sn,
+			Matches:  []string{keepReason},
+		})
+	}
+
+	sort.Stable(newRemove)
+	return newKeep, newRemove, newReasons
+}
+
+// PolicySimulation is the result of SimulatePolicy.
+type PolicySimulation struct {
+	// Removed contains the time at which each snapshot of the list is
+	// removed. Snapshots which are still kept at the end of the simulation
+	// are not contained.
+	Removed map[ID]time.Time
+	// Keep contains the snapshots which are kept at the end of the simulation,
+	// including the simulated ones.
+	Keep Snapshots
+	// Simulated is the number of simulated snapshots in Keep.
+	Simulated int
+}
+
+// SimulatePolicy simulates regular forget runs with the policy p until the
+// time until. Before each run except the first, which happens at now, a new
+// snapshot is assumed to be created. The runs are interval apart and start
+// after the latest snapshot in list. The simulated snapshots have the
+// hostname, paths and tags of the latest snapshot. Protected snapshots are
+// never removed.
+func SimulatePolicy(list Snapshots, p ExpirePolicy, interval Duration, now, until time.Time) PolicySimulation {
+	res := PolicySimulation{Removed: make(map[ID]time.Time)}
+	if len(list) == 0 {
+		return res
+	}
+
+	current := make(Snapshots, len(list))
+	copy(current, list)
+	sort.Stable(current)
+	// list is sorted newest first
+	template := current[0]
+
+	simulated := make(map[*Snapshot]struct{})
+	run := func(t time.Time) {
+		keep, remove, reasons := applyPolicy(current, p, t)
+		keep, remove, _ = KeepProtected(keep, remove, reasons)
+		for _, sn := range remove {
+			if _, ok := simulated[sn]; !ok {
+				res.Removed[*sn.ID()] = t
+			}
+			delete(simulated, sn)
+		}
+		current = keep
+	}
+
+	run(now)
+
+	start := template.Time
+	if now.After(start) {
+		start = now
+	}
+	next := func(t time.Time) time.Time {
+		return t.AddDate(interval.Years, interval.Months, interval.Days).Add(time.Duration(interval.Hours) * time.Hour)
+	}
+	for t := next(start); !t.After(until) && t.After(start); t = next(t) {
+		sn := &Snapshot{
+			Time:     t,
+			Hostname: template.Hostname,
+			Paths:    template.Paths,
+			Tags:     template.Tags,
+		}
+		simulated[sn] = struct{}{}
+		current = append(current, sn)
+		run(t)
+	}
+
+	sort.Stable(current)
+	res.Keep = current
+	res.Simulated = len(simulated)
+	return res
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
@@ -25,11 +26,11 @@ func parseTimeUTC(s string) time.Time {
 // Returns the maximum number of snapshots to be kept according to this policy.
 // If any of the counts is -1 it will return 0.
 func policySum(e *restic.ExpirePolicy) int {
-	if e.Last == -1 || e.Hourly == -1 || e.Daily == -1 || e.Weekly == -1 || e.Monthly == -1 || e.Yearly == -1 {
+	if e.Last == -1 || e.Hourly == -1 || e.Daily == -1 || e.Weekly == -1 || e.Monthly == -1 || e.Quarterly == -1 || e.Yearly == -1 {
 		return 0
 	}
 
-	return e.Last + e.Hourly + e.Daily + e.Weekly + e.Monthly + e.Yearly
+	return e.Last + e.Hourly + e.Daily + e.Weekly + e.Monthly + e.Quarterly + e.Yearly
 }
 
 func TestExpireSnapshotOps(t *testing.T) {
@@ -285,3 +286,202 @@ func TestApplyPolicy(t *testing.T) {
 		})
 	}
 }
+
+func TestKeepProtected(t *testing.T) {
+	snapshots := restic.Snapshots{
+		{Time: parseTimeUTC("2014-09-01 10:20:30"), Tags: []string{"foo"}},
+		{Time: parseTimeUTC("2014-09-02 10:20:30"), Protected: true},
+		{Time: parseTimeUTC("2014-09-03 10:20:30")},
+		{Time: parseTimeUTC("2014-09-04 10:20:30")},
+	}
+
+	policy := restic.ExpirePolicy{Last: 1, Tags: []restic.TagList{{"foo"}}}
+	keep, remove, reasons := restic.ApplyPolicy(snapshots, policy)
+	keep, remove, reasons = restic.KeepProtected(keep, remove, reasons)
+
+	if len(keep) != 3 || len(reasons) != 3 {
+		t.Fatalf("expected three snapshots to be kept, got %v (reasons %v)", keep, reasons)
+	}
+	if len(remove) != 1 {
+		t.Fatalf("expected one snapshot to be removed, got %v", remove)
+	}
+
+	for _, sn := range remove {
+		if sn.Protected {
+			t.Errorf("protected snapshot %v is removed", sn)
+		}
+	}
+
+	for i, sn := range keep {
+		if reasons[i].Snapshot != sn {
+			t.Errorf("reason %d does not belong to snapshot %v", i, sn)
+		}
+		if i > 0 && sn.Time.After(keep[i-1].Time) {
+			t.Errorf("kept snapshots are not sorted newest first: %v", keep)
+		}
+	}
+	if !keep[1].Protected || reasons[1].Matches[0] != "protected" {
+		t.Errorf("unexpected keep reason for protected snapshot: %v", reasons[1])
+	}
+}
+
+func TestApplyIDLists(t *testing.T) {
+	var snapshots restic.Snapshots
+	for i := 1; i <= 5; i++ {
+		sn := &restic.Snapshot{Time: parseTimeUTC(fmt.Sprintf("2014-09-0%d 10:20:30", i))}
+		id := restic.NewRandomID()
+		restic.TestSetSnapshotID(t, sn, id)
+		snapshots = append(snapshots, sn)
+	}
+
+	// ApplyPolicy sorts the list it is passed
+	list := append(restic.Snapshots{}, snapshots...)
+	keep, remove, reasons := restic.ApplyPolicy(list, restic.ExpirePolicy{Last: 2})
+	keepIDs := restic.NewIDSet(*snapshots[0].ID())
+	forgetIDs := restic.NewIDSet(*snapshots[4].ID())
+	keep, remove, reasons = restic.ApplyIDLists(keep, remove, reasons, keepIDs, forgetIDs, "listed")
+
+	want := restic.Snapshots{snapshots[3], snapshots[0]}
+	if !reflect.DeepEqual(keep, want) {
+		t.Fatalf("wrong snapshots kept, want %v, got %v", want, keep)
+	}
+	if len(reasons) != len(keep) {
+		t.Fatalf("expected %d reasons, got %d", len(keep), len(reasons))
+	}
+	for i, sn := range keep {
+		if reasons[i].Snapshot != sn {
+			t.Errorf("reason %d does not belong to snapshot %v", i, sn)
+		}
+	}
+	if reasons[1].Matches[0] != "listed" {
+		t.Errorf("unexpected keep reason for listed snapshot: %v", reasons[1])
+	}
+
+	// remove is sorted newest first, like the output of ApplyPolicy
+	want = restic.Snapshots{snapshots[4], snapshots[2], snapshots[1]}
+	if !reflect.DeepEqual(remove, want) {
+		t.Fatalf("wrong snapshots removed, want %v, got %v", want, remove)
+	}
+}
+
+func TestSimulatePolicy(t *testing.T) {
+	var snapshots restic.Snapshots
+	for i := 1; i <= 10; i++ {
+		sn := &restic.Snapshot{Time: parseTimeUTC(fmt.Sprintf("2016-01-%02d 10:20:30", i))}
+		restic.TestSetSnapshotID(t, sn, restic.NewRandomID())
+		snapshots = append(snapshots, sn)
+	}
+	snapshots[0].Protected = true
+
+	now := parseTimeUTC("2016-01-10 12:00:00")
+	until := parseTimeUTC("2016-06-01 12:00:00")
+	policy := restic.ExpirePolicy{Daily: 3, Monthly: 3}
+	res := restic.SimulatePolicy(snapshots, policy, restic.Duration{Days: 1}, now, until)
+
+	// all but the snapshots of the last three days are removed by the first run
+	for i := 1; i < 7; i++ {
+		if removed, ok := res.Removed[*snapshots[i].ID()]; !ok || !removed.Equal(now) {
+			t.Errorf("snapshot %v: expected removal at %v, got %v", snapshots[i].Time, now, removed)
+		}
+	}
+	for i, want := range map[int]string{
+		7: "2016-01-11 12:00:00",
+		9: "2016-01-13 12:00:00",
+	} {
+		if removed := res.Removed[*snapshots[i].ID()]; !removed.Equal(parseTimeUTC(want)) {
+			t.Errorf("snapshot %v: expected removal at %v, got %v", snapshots[i].Time, want, removed)
+		}
+	}
+	if _, ok := res.Removed[*snapshots[0].ID()]; ok {
+		t.Errorf("protected snapshot was removed")
+	}
+
+	var want []time.Time
+	for _, s := range []string{"2016-06-01", "2016-05-31", "2016-05-30", "2016-04-30"} {
+		want = append(want, parseTimeUTC(s+" 12:00:00"))
+	}
+	want = append(want, snapshots[0].Time)
+	var got []time.Time
+	for _, sn := range res.Keep {
+		got = append(got, sn.Time)
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("wrong snapshots kept at the end of the simulation: %v", cmp.Diff(want, got))
+	}
+	if res.Simulated != 4 {
+		t.Errorf("expected 4 simulated snapshots to be kept, got %v", res.Simulated)
+	}
+}
+
+func TestApplyPolicyCalendar(t *testing.T) {
+	var monthly, daily restic.Snapshots
+	for m := 1; m <= 12; m++ {
+		monthly = append(monthly, &restic.Snapshot{Time: parseTimeUTC(fmt.Sprintf("2020-%02d-15 10:20:30", m))})
+	}
+	for d := 0; d < 45; d++ {
+		ts := parseTimeUTC("2020-01-01 10:20:30").AddDate(0, 0, d)
+		daily = append(daily, &restic.Snapshot{Time: ts})
+	}
+
+	sunday := time.Sunday
+	for _, test := range []struct {
+		list   restic.Snapshots
+		policy restic.ExpirePolicy
+		keep   []string
+	}{
+		{
+			monthly,
+			restic.ExpirePolicy{Quarterly: -1},
+			[]string{"2020-12-15", "2020-09-15", "2020-06-15", "2020-03-15"},
+		},
+		{
+			monthly,
+			restic.ExpirePolicy{Quarterly: -1, Calendar: restic.PolicyCalendar{YearStartMonth: time.February}},
+			[]string{"2020-12-15", "2020-10-15", "2020-07-15", "2020-04-15", "2020-01-15"},
+		},
+		{
+			monthly,
+			restic.ExpirePolicy{Quarterly: -1, Calendar: restic.PolicyCalendar{YearStartMonth: time.April, KeepFirst: true}},
+			[]string{"2020-10-15", "2020-07-15", "2020-04-15", "2020-01-15"},
+		},
+		{
+			monthly,
+			restic.ExpirePolicy{Yearly: -1, Calendar: restic.PolicyCalendar{YearStartMonth: time.July}},
+			[]string{"2020-12-15", "2020-06-15"},
+		},
+		{
+			daily[:14],
+			restic.ExpirePolicy{Weekly: -1},
+			[]string{"2020-01-14", "2020-01-12", "2020-01-05"},
+		},
+		{
+			daily[:14],
+			restic.ExpirePolicy{Weekly: -1, Calendar: restic.PolicyCalendar{WeekStart: &sunday}},
+			[]string{"2020-01-14", "2020-01-11", "2020-01-04"},
+		},
+		{
+			daily,
+			restic.ExpirePolicy{Monthly: -1, Calendar: restic.PolicyCalendar{MonthStartDay: 26}},
+			[]string{"2020-02-14", "2020-01-25"},
+		},
+		{
+			daily,
+			restic.ExpirePolicy{Monthly: 2, Calendar: restic.PolicyCalendar{MonthStartDay: 26, KeepFirst: true}},
+			[]string{"2020-01-26", "2020-01-01"},
+		},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			list := make(restic.Snapshots, len(test.list))
+			copy(list, test.list)
+			keep, _, _ := restic.ApplyPolicy(list, test.policy)
+
+			var got []string
+			for _, sn := range keep {
+				got = append(got, sn.Time.Format("2006-01-02"))
+			}
+			if !cmp.Equal(test.keep, got) {
+				t.Error(cmp.Diff(test.keep, got))
+			}
+		})
+	}
+}
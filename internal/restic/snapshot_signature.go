@@ -0,0 +1,121 @@
+package restic
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// SnapshotSignature holds an Ed25519 signature over a snapshot.
+type SnapshotSignature struct {
+	KeyID     string `json:"key_id"`
+	Signature []byte `json:"signature"`
+}
+
+// ErrSnapshotNotSigned is returned by VerifySignature for a snapshot without
+// a signature.
+var ErrSnapshotNotSigned = errors.New("snapshot is not signed")
+
+// SigningKeyID returns a short identifier for the public key, which is the
+// hex encoded SHA-256 hash of the key truncated to 16 bytes.
+func SigningKeyID(pub ed25519.PublicKey) string {
+	h := sha256.Sum256(pub)
+	return hex.EncodeToString(h[:16])
+}
+
+// ParseSigningKey parses a PEM encoded PKCS #8 Ed25519 private key, as
+// generated by `openssl genpkey -algorithm ed25519`.
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParsePKCS8PrivateKey")
+	}
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type %T, only Ed25519 is supported", key)
+	}
+	return priv, nil
+}
+
+// ParseVerifyKey parses a PEM encoded PKIX Ed25519 public key, as generated
+// by `openssl pkey -pubout`.
+func ParseVerifyKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParsePKIXPublicKey")
+	}
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.Errorf("unsupported public key type %T, only Ed25519 is supported", key)
+	}
+	return pub, nil
+}
+
+// signedData returns the serialized snapshot without the signature, which is
+// the data covered by the signature.
+func (sn *Snapshot) signedData() ([]byte, error) {
+	unsigned := *sn
+	unsigned.Signature = nil
+	buf, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal")
+	}
+	return buf, nil
+}
+
+// Sign adds a signature created with key to the snapshot. An existing
+// signature is replaced. The snapshot must not be modified afterwards.
+func (sn *Snapshot) Sign(key ed25519.PrivateKey) error {
+	data, err := sn.signedData()
+	if err != nil {
+		return err
+	}
+
+	sn.Signature = &SnapshotSignature{
+		KeyID:     SigningKeyID(key.Public().(ed25519.PublicKey)),
+		Signature: ed25519.Sign(key, data),
+	}
+	return nil
+}
+
+// VerifySignature checks that the snapshot carries a valid signature created
+// by one of the trusted keys.
+func (sn *Snapshot) VerifySignature(trusted []ed25519.PublicKey) error {
+	if sn.Signature == nil {
+		return ErrSnapshotNotSigned
+	}
+
+	data, err := sn.signedData()
+	if err != nil {
+		return err
+	}
+
+	for _, pub := range trusted {
+		if SigningKeyID(pub) != sn.Signature.KeyID {
+			continue
+		}
+		if ed25519.Verify(pub, data, sn.Signature.Signature) {
+			return nil
+		}
+		return errors.Errorf("invalid signature for key %v", sn.Signature.KeyID)
+	}
+
+	return errors.Errorf("snapshot is signed with untrusted key %v", sn.Signature.KeyID)
+}
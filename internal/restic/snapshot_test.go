@@ -2,9 +2,12 @@ package restic_test
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -27,6 +30,23 @@ func TestTagList(t *testing.T) {
 	rtest.Assert(t, r, "Failed to match untagged snapshot")
 }
 
+func TestSnapshotAnnotations(t *testing.T) {
+	sn, _ := restic.NewSnapshot([]string{"/home/foobar"}, nil, "foo", time.Now())
+	rtest.Assert(t, sn.HasAnnotations(nil), "snapshot does not match empty filter")
+	rtest.Assert(t, !sn.HasAnnotations([]string{"ticket"}), "snapshot without annotations matches filter")
+
+	rtest.Assert(t, sn.SetAnnotations(map[string]string{"ticket": "OPS-1", "lsn": "0/16B3748"}), "annotations not changed")
+	rtest.Assert(t, !sn.SetAnnotations(map[string]string{"ticket": "OPS-1"}), "setting the same value changed the annotations")
+	rtest.Assert(t, sn.HasAnnotations([]string{"ticket=OPS-1", "lsn"}), "snapshot does not match filter")
+	rtest.Assert(t, !sn.HasAnnotations([]string{"ticket=OPS-2"}), "snapshot matches filter with other value")
+	rtest.Assert(t, !sn.HasAnnotations([]string{"ticket", "version"}), "snapshot matches filter with missing key")
+
+	rtest.Assert(t, sn.RemoveAnnotations([]string{"ticket", "version"}), "annotations not removed")
+	rtest.Equals(t, map[string]string{"lsn": "0/16B3748"}, sn.Annotations)
+	rtest.Assert(t, sn.RemoveAnnotations([]string{"lsn"}), "annotations not removed")
+	rtest.Assert(t, sn.Annotations == nil, "empty annotations not removed")
+}
+
 func TestLoadJSONUnpacked(t *testing.T) {
 	repository.TestAllVersions(t, testLoadJSONUnpacked)
 }
@@ -49,3 +69,28 @@ func testLoadJSONUnpacked(t *testing.T, version uint) {
 	rtest.Equals(t, sn.Hostname, sn2.Hostname)
 	rtest.Equals(t, sn.Username, sn2.Username)
 }
+
+func TestSnapshotSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	rtest.OK(t, err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	rtest.OK(t, err)
+
+	sn, err := restic.NewSnapshot([]string{"/home/foobar"}, []string{"foo"}, "foo", time.Now())
+	rtest.OK(t, err)
+	tree := restic.NewRandomID()
+	sn.Tree = &tree
+
+	rtest.Assert(t, errors.Is(sn.VerifySignature([]ed25519.PublicKey{pub}), restic.ErrSnapshotNotSigned),
+		"unsigned snapshot was not reported as such")
+
+	rtest.OK(t, sn.Sign(priv))
+	rtest.OK(t, sn.VerifySignature([]ed25519.PublicKey{otherPub, pub}))
+
+	rtest.Assert(t, sn.VerifySignature([]ed25519.PublicKey{otherPub}) != nil,
+		"signature by untrusted key was accepted")
+
+	sn.Hostname = "bar"
+	rtest.Assert(t, sn.VerifySignature([]ed25519.PublicKey{pub}) != nil,
+		"signature of modified snapshot was accepted")
+}
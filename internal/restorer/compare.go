@@ -0,0 +1,216 @@
+package restorer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/restic/restic/internal/bloblru"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// CompareTo compares the items below dst with the snapshot, without
+// modifying anything. The contents of files are compared against the hashes
+// of their blobs, in addition the metadata of all items is compared. For each
+// difference, report is called with the location of the item in the snapshot
+// and a description of the difference. Items not selected by res.SelectFilter
+// are ignored. CompareTo returns the number of compared items.
+func (res *Restorer) CompareTo(ctx context.Context, dst string, report func(location string, msg string)) (int, error) {
+	var err error
+	if !filepath.IsAbs(dst) {
+		dst, err = filepath.Abs(dst)
+		if err != nil {
+			return 0, errors.Wrap(err, "Abs")
+		}
+	}
+
+	type compareJob struct {
+		node             *restic.Node
+		target, location string
+	}
+
+	var (
+		ncompared uint64
+		work      = make(chan compareJob, 2*nVerifyWorkers)
+		m         sync.Mutex
+	)
+	// the tree traversal and the content workers report concurrently
+	reportLocked := func(location, msg string) {
+		m.Lock()
+		defer m.Unlock()
+		report(location, msg)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(work)
+		return res.compareDir(ctx, dst, string(filepath.Separator), *res.sn.Tree, reportLocked, func(node *restic.Node, target, location string) error {
+			atomic.AddUint64(&ncompared, 1)
+			if node.Type != "file" {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case work <- compareJob{node, target, location}:
+				return nil
+			}
+		})
+	})
+
+	bundles := bloblru.New(64 << 20)
+	for i := 0; i < nVerifyWorkers; i++ {
+		g.Go(func() error {
+			var buf []byte
+			var err error
+			for job := range work {
+				if job.node.Bundle != nil {
+					err = res.verifyBundledFile(ctx, job.target, job.node, bundles)
+				} else {
+					buf, err = res.verifyFile(ctx, job.target, job.node, buf)
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil {
+					reportLocked(job.location, err.Error())
+				}
+			}
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	return int(ncompared), err
+}
+
+// compareDir compares the entries of the directory target with the tree
+// treeID. The metadata of each item is compared immediately, compareContent
+// is called for all items which exist and have the right type.
+func (res *Restorer) compareDir(ctx context.Context, target, location string, treeID restic.ID,
+	report func(string, string), compareContent func(node *restic.Node, target, location string) error) error {
+
+	tree, err := restic.LoadTree(ctx, res.repo, treeID)
+	if err != nil {
+		return res.Error(location, err)
+	}
+
+	entries, err := readdirnames(target)
+	if err != nil {
+		return res.Error(location, err)
+	}
+	sort.Strings(entries)
+
+	names := make(map[string]struct{}, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		names[node.Name] = struct{}{}
+
+		nodeTarget := filepath.Join(target, node.Name)
+		nodeLocation := filepath.Join(location, node.Name)
+		if filepath.Base(nodeTarget) != node.Name || node.Type == "socket" {
+			// the restorer does not create these items either
+			continue
+		}
+		selected, childMayBeSelected := res.SelectFilter(nodeLocation, nodeTarget, node)
+		if !selected && !childMayBeSelected {
+			continue
+		}
+
+		fi, err := fs.Lstat(nodeTarget)
+		if os.IsNotExist(err) {
+			if selected {
+				report(nodeLocation, "missing")
+			}
+			continue
+		}
+		if err != nil {
+			if err = res.Error(nodeLocation, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if !sameNodeType(node, fi) {
+			if selected {
+				report(nodeLocation, fmt.Sprintf("type differs, expected %v", node.Type))
+			}
+			continue
+		}
+
+		if selected {
+			local, err := restic.NodeFromFileInfo(nodeTarget, fi)
+			if err != nil {
+				debug.Log("unable to read metadata of %v: %v", nodeTarget, err)
+			}
+			for _, msg := range compareMetadata(node, local) {
+				report(nodeLocation, msg)
+			}
+			if err := compareContent(node, nodeTarget, nodeLocation); err != nil {
+				return err
+			}
+		}
+
+		if node.Type == "dir" && childMayBeSelected {
+			if node.Subtree == nil {
+				return errors.Errorf("Dir without subtree in tree %v", treeID.Str())
+			}
+			if err := res.compareDir(ctx, nodeTarget, nodeLocation, *node.Subtree, report, compareContent); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range entries {
+		if _, ok := names[name]; ok {
+			continue
+		}
+		entryTarget := filepath.Join(target, name)
+		entryLocation := filepath.Join(location, name)
+		node := &restic.Node{Name: name, Type: "file"}
+		if fi, err := fs.Lstat(entryTarget); err == nil && fi.IsDir() {
+			node.Type = "dir"
+		}
+		if selected, _ := res.SelectFilter(entryLocation, entryTarget, node); selected {
+			report(entryLocation, "not contained in the snapshot")
+		}
+	}
+	return nil
+}
+
+// compareMetadata returns a description of each difference between the
+// metadata of node from the snapshot and local from the filesystem. The size
+// and contents of files are compared separately.
+func compareMetadata(node *restic.Node, local *restic.Node) []string {
+	var diffs []string
+	if node.Type != "symlink" && node.Mode != local.Mode {
+		diffs = append(diffs, fmt.Sprintf("mode differs, expected %v, got %v", node.Mode, local.Mode))
+	}
+	if node.Type != "symlink" && !node.ModTime.Equal(local.ModTime) {
+		diffs = append(diffs, fmt.Sprintf("modification time differs, expected %v, got %v", node.ModTime, local.ModTime))
+	}
+	if runtime.GOOS != "windows" && (node.UID != local.UID || node.GID != local.GID) {
+		diffs = append(diffs, fmt.Sprintf("owner differs, expected %v:%v, got %v:%v", node.UID, node.GID, local.UID, local.GID))
+	}
+	if node.LinkTarget != local.LinkTarget {
+		diffs = append(diffs, fmt.Sprintf("link target differs, expected %q, got %q", node.LinkTarget, local.LinkTarget))
+	}
+	if (node.Type == "dev" || node.Type == "chardev") && node.Device != local.Device {
+		diffs = append(diffs, fmt.Sprintf("device differs, expected %v, got %v", node.Device, local.Device))
+	}
+	if !node.SameExtendedAttributes(*local) {
+		diffs = append(diffs, "extended attributes differ")
+	}
+	return diffs
+}
@@ -0,0 +1,110 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// deleteExtraFiles removes all files and directories below target which are
+// not contained in the tree treeID. Items not selected by res.SelectFilter are
+// kept, as well as the directories containing them. In addition, items whose
+// type conflicts with the snapshot, for example a directory in place of a
+// file, are removed so that they can be restored. A nil treeID stands for an
+// empty directory.
+func (res *Restorer) deleteExtraFiles(ctx context.Context, target, location string, treeID *restic.ID) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	entries, err := readdirnames(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return res.Error(location, err)
+	}
+
+	nodes := make(map[string]*restic.Node)
+	if treeID != nil {
+		tree, err := restic.LoadTree(ctx, res.repo, *treeID)
+		if err != nil {
+			return res.Error(location, err)
+		}
+		for _, node := range tree.Nodes {
+			nodes[node.Name] = node
+		}
+	}
+
+	for _, name := range entries {
+		entryTarget := filepath.Join(target, name)
+		entryLocation := filepath.Join(location, name)
+		fi, err := fs.Lstat(entryTarget)
+		if err != nil {
+			if err = res.Error(entryLocation, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		node, ok := nodes[name]
+		if !ok {
+			// the filters only look at the type of the node
+			node = &restic.Node{Name: name, Type: "file"}
+			if fi.IsDir() {
+				node.Type = "dir"
+			}
+		}
+		selected, childMayBeSelected := res.SelectFilter(entryLocation, entryTarget, node)
+
+		extra := !ok || (node.Type == "dir") != fi.IsDir()
+		if fi.IsDir() && childMayBeSelected {
+			// only remove the children which are selected
+			var subtree *restic.ID
+			if !extra {
+				subtree = node.Subtree
+			}
+			if err := res.deleteExtraFiles(ctx, entryTarget, entryLocation, subtree); err != nil {
+				return err
+			}
+			if !extra || !selected {
+				continue
+			}
+			if names, err := readdirnames(entryTarget); err != nil || len(names) > 0 {
+				debug.Log("keeping non-empty directory %v", entryTarget)
+				continue
+			}
+		} else if !extra || !selected {
+			continue
+		}
+
+		debug.Log("removing %v", entryTarget)
+		if err := fs.RemoveAll(entryTarget); err != nil {
+			if err = res.Error(entryLocation, errors.WithStack(err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if res.ItemRemoved != nil {
+			res.ItemRemoved(entryLocation)
+		}
+	}
+	return nil
+}
+
+func readdirnames(dir string) ([]string, error) {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return names, err
+}
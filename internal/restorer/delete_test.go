@@ -0,0 +1,66 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerDelete(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file": File{Data: "content"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"conflict": File{Data: "file in dir"},
+				},
+			},
+		},
+	})
+
+	tempdir := rtest.TempDir(t)
+	for _, dir := range []string{"dir/conflict/sub", "extra/sub"} {
+		rtest.OK(t, os.MkdirAll(filepath.Join(tempdir, filepath.FromSlash(dir)), 0700))
+	}
+	for _, file := range []string{"extra.txt", "extra.keep", "dir/extra", "extra/sub/file", "extra/other.keep"} {
+		rtest.OK(t, os.WriteFile(filepath.Join(tempdir, filepath.FromSlash(file)), []byte("old"), 0600))
+	}
+
+	res := NewRestorer(context.TODO(), repo, sn, false, nil)
+	res.Delete = true
+	res.SelectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		selected := !strings.HasSuffix(item, ".keep")
+		return selected, selected && node.Type == "dir"
+	}
+	var removed []string
+	res.ItemRemoved = func(location string) {
+		removed = append(removed, filepath.ToSlash(location))
+	}
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	sort.Strings(removed)
+	rtest.Equals(t, []string{"/dir/conflict", "/dir/extra", "/extra.txt", "/extra/sub", "/extra/sub/file"}, removed)
+
+	// excluded extra files are kept, as well as the directories containing them
+	rtest.Equals(t, []string{"dir", "extra", "extra.keep", "file"}, readDirNames(t, tempdir))
+	rtest.Equals(t, []string{"other.keep"}, readDirNames(t, filepath.Join(tempdir, "extra")))
+	rtest.Equals(t, []string{"conflict"}, readDirNames(t, filepath.Join(tempdir, "dir")))
+	data, err := os.ReadFile(filepath.Join(tempdir, "dir", "conflict"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "file in dir", string(data))
+}
+
+func readDirNames(t *testing.T, dir string) []string {
+	names, err := readdirnames(dir)
+	rtest.OK(t, err)
+	sort.Strings(names)
+	return names
+}
@@ -2,6 +2,7 @@ package restorer
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"sync"
 
@@ -30,8 +31,13 @@ type fileInfo struct {
 	inProgress bool
 	sparse     bool
 	size       int64
-	location   string      // file on local filesystem relative to restorer basedir
-	blobs      interface{} // blobs of the file
+	bundled    bool              // blob also contains the data of other files
+	offset     int64             // start of the file within its blob, for bundled files
+	location   string            // file on local filesystem relative to restorer basedir
+	blobs      interface{}       // blobs of the file
+	blobsLeft  int               // number of blobs which still have to be written
+	local      map[int]struct{}  // indexes of blobs found locally, see reuseLocalData
+	holes      []restic.FileHole // holes of a sparse file, which are not written
 }
 
 type fileBlobInfo struct {
@@ -45,18 +51,35 @@ type packInfo struct {
 	files map[*fileInfo]struct{} // set of files that use blobs from this pack
 }
 
+// a blob which is written to a file by one of the writers
+type writeJob struct {
+	file   *fileInfo
+	data   []byte
+	offset int64
+}
+
 // fileRestorer restores set of files
 type fileRestorer struct {
-	key        *crypto.Key
+	key      *crypto.Key
+	filters  repository.BlobFilters
+	blobHash restic.BlobHashFunc
+	// openBlob decrypts blobs encrypted with a data key, it may be nil
+	openBlob   func(ctx context.Context, id restic.ID, buf []byte) ([]byte, error)
 	idx        func(restic.BlobHandle) []restic.PackedBlob
 	packLoader repository.BackendLoadFn
 
 	workerCount int
+	writerCount int
+	fsync       bool
 	filesWriter *filesWriter
 	zeroChunk   restic.ID
 	sparse      bool
 	progress    *restore.Progress
 
+	reuseTarget   bool
+	referenceDirs []string
+	reusedBytes   uint64
+
 	dst   string
 	files []*fileInfo
 	Error func(string, error) error
@@ -78,17 +101,25 @@ func newFileRestorer(dst string,
 		idx:         idx,
 		packLoader:  packLoader,
 		filesWriter: newFilesWriter(workerCount),
-		zeroChunk:   repository.ZeroChunk(),
+		blobHash:    restic.Hash,
+		zeroChunk:   repository.ZeroChunk(restic.Config{}),
 		sparse:      sparse,
 		progress:    progress,
 		workerCount: workerCount,
+		writerCount: workerCount,
 		dst:         dst,
 		Error:       restorerAbortOnAllErrors,
 	}
 }
 
-func (r *fileRestorer) addFile(location string, content restic.IDs, size int64) {
-	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size})
+func (r *fileRestorer) addFile(location string, content restic.IDs, size int64, holes []restic.FileHole) {
+	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size, blobsLeft: len(content), holes: holes})
+}
+
+// addBundledFile adds a file which consists of size bytes starting at offset
+// within the blob id.
+func (r *fileRestorer) addBundledFile(location string, id restic.ID, size int64, offset int64) {
+	r.files = append(r.files, &fileInfo{location: location, blobs: restic.IDs{id}, size: size, bundled: true, offset: offset, blobsLeft: 1})
 }
 
 func (r *fileRestorer) targetPath(location string) string {
@@ -112,6 +143,9 @@ func (r *fileRestorer) forEachBlob(blobIDs []restic.ID, fn func(packID restic.ID
 }
 
 func (r *fileRestorer) restoreFiles(ctx context.Context) error {
+	if err := r.reuseLocalData(ctx); err != nil {
+		return err
+	}
 
 	packs := make(map[restic.ID]*packInfo) // all packs
 	// Process packs in order of first access. While this cannot guarantee
@@ -122,17 +156,26 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 	// create packInfo from fileInfo
 	for _, file := range r.files {
 		fileBlobs := file.blobs.(restic.IDs)
-		largeFile := len(fileBlobs) > largeFileBlobCount
+		// files with locally available blobs only list the missing blobs
+		largeFile := len(fileBlobs) > largeFileBlobCount || file.local != nil
 		var packsMap map[restic.ID][]fileBlobInfo
 		if largeFile {
 			packsMap = make(map[restic.ID][]fileBlobInfo)
 		}
 		fileOffset := int64(0)
+		blobIndex := 0
 		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
+			_, isLocal := file.local[blobIndex]
+			blobIndex++
 			if largeFile {
-				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
+				if !isLocal {
+					packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
+				}
 				fileOffset += int64(blob.DataLength())
 			}
+			if isLocal {
+				return
+			}
 			pack, ok := packs[packID]
 			if !ok {
 				pack = &packInfo{
@@ -147,7 +190,7 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 				file.sparse = r.sparse
 			}
 		})
-		if len(fileBlobs) == 1 {
+		if len(fileBlobs) == 1 && file.local == nil {
 			// no need to preallocate files with a single block, thus we can always consider them to be sparse
 			// in addition, a short chunk will never match r.zeroChunk which would prevent sparseness for short files
 			file.sparse = r.sparse
@@ -160,21 +203,52 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		if largeFile {
 			file.blobs = packsMap
 		}
+		if file.local != nil {
+			// the holes would keep the previous contents of the target
+			file.sparse = false
+			file.holes = nil
+			file.local = nil
+		}
 	}
 
 	wg, ctx := errgroup.WithContext(ctx)
 	downloadCh := make(chan *packInfo)
+	// downloads and writes are decoupled, so that the number of concurrently
+	// written files can be tuned for the target filesystem
+	writeCh := make(chan writeJob, r.writerCount)
 
 	worker := func() error {
 		for pack := range downloadCh {
-			if err := r.downloadPack(ctx, pack); err != nil {
+			if err := r.downloadPack(ctx, pack, writeCh); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
+	var downloads sync.WaitGroup
 	for i := 0; i < r.workerCount; i++ {
-		wg.Go(worker)
+		downloads.Add(1)
+		wg.Go(func() error {
+			defer downloads.Done()
+			return worker()
+		})
+	}
+	wg.Go(func() error {
+		downloads.Wait()
+		close(writeCh)
+		return nil
+	})
+
+	writer := func() error {
+		for job := range writeCh {
+			if err := r.writeBlob(job); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < r.writerCount; i++ {
+		wg.Go(writer)
 	}
 
 	// the main restore loop
@@ -197,7 +271,7 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 	return wg.Wait()
 }
 
-func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
+func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo, writeCh chan<- writeJob) error {
 
 	// calculate blob->[]files->[]offsets mappings
 	blobs := make(map[restic.ID]struct {
@@ -246,8 +320,16 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 		return err
 	}
 
-	err := repository.StreamPack(ctx, r.packLoader, r.key, pack.id, blobList, func(h restic.BlobHandle, blobData []byte, err error) error {
+	// StreamPack may pass a blob several times after download errors
+	sent := restic.NewIDSet()
+	err := repository.StreamPack(ctx, r.packLoader, r.key, r.filters, r.blobHash, pack.id, blobList, func(h restic.BlobHandle, blobData []byte, err error) error {
 		blob := blobs[h.ID]
+		if sent.Has(h.ID) {
+			return nil
+		}
+		if err == nil && r.openBlob != nil {
+			blobData, err = r.openBlob(ctx, h.ID, blobData)
+		}
 		if err != nil {
 			for file := range blob.files {
 				if errFile := sanitizeError(file, err); errFile != nil {
@@ -256,35 +338,15 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 			}
 			return nil
 		}
+		sent.Insert(h.ID)
+		// the buffer is reused once the callback returns
+		data := append([]byte(nil), blobData...)
 		for file, offsets := range blob.files {
 			for _, offset := range offsets {
-				writeToFile := func() error {
-					// this looks overly complicated and needs explanation
-					// two competing requirements:
-					// - must create the file once and only once
-					// - should allow concurrent writes to the file
-					// so write the first blob while holding file lock
-					// write other blobs after releasing the lock
-					createSize := int64(-1)
-					file.lock.Lock()
-					if file.inProgress {
-						file.lock.Unlock()
-					} else {
-						defer file.lock.Unlock()
-						file.inProgress = true
-						createSize = file.size
-					}
-					writeErr := r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize, file.sparse)
-
-					if r.progress != nil {
-						r.progress.AddProgress(file.location, uint64(len(blobData)), uint64(file.size))
-					}
-
-					return writeErr
-				}
-				err := sanitizeError(file, writeToFile())
-				if err != nil {
-					return err
+				select {
+				case writeCh <- writeJob{file: file, data: data, offset: offset}:
+				case <-ctx.Done():
+					return ctx.Err()
 				}
 			}
 		}
@@ -301,3 +363,129 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 
 	return nil
 }
+
+func (r *fileRestorer) writeBlob(job writeJob) error {
+	file := job.file
+
+	// only write the part of a bundle which belongs to this file
+	if file.bundled {
+		if int64(len(job.data)) < file.offset+file.size {
+			return r.Error(file.location, errors.Errorf("bundle of %d bytes is too short, expected at least %d bytes", len(job.data), file.offset+file.size))
+		}
+		job.data = job.data[file.offset : file.offset+file.size]
+		job.offset = 0
+	}
+
+	// this looks overly complicated and needs explanation
+	// two competing requirements:
+	// - must create the file once and only once
+	// - should allow concurrent writes to the file
+	// so write the first blob while holding file lock
+	// write other blobs after releasing the lock
+	createSize := int64(-1)
+	file.lock.Lock()
+	if file.inProgress {
+		file.lock.Unlock()
+	} else {
+		defer file.lock.Unlock()
+		file.inProgress = true
+		createSize = file.size
+	}
+	path := r.targetPath(file.location)
+	var err error
+	if file.holes != nil {
+		err = r.writeWithHoles(path, job, createSize, file.sparse)
+	} else {
+		err = r.filesWriter.writeToFile(path, job.data, job.offset, createSize, file.sparse)
+	}
+
+	if r.progress != nil {
+		r.progress.AddProgress(file.location, uint64(len(job.data)), uint64(file.size))
+	}
+
+	if err == nil && r.fsync && r.completeBlob(file, createSize >= 0) {
+		err = syncFile(path)
+	}
+
+	if err != nil {
+		return r.Error(file.location, err)
+	}
+	return nil
+}
+
+// writeWithHoles writes the parts of job.data which do not belong to one of
+// the holes of job.file. The file is created by truncating it, as this
+// already produces the holes, while preallocating it would fill them. A hole
+// is only skipped if job.data is zero for all of it, as the file may have been
+// written after its holes were detected during the backup.
+func (r *fileRestorer) writeWithHoles(path string, job writeJob, createSize int64, sparse bool) error {
+	if createSize >= 0 {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		err = truncateSparse(f, createSize)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	start := job.offset
+	end := job.offset + int64(len(job.data))
+	for _, hole := range job.file.holes {
+		holeStart, holeEnd := int64(hole.Offset), int64(hole.Offset+hole.Length)
+		if holeEnd <= start {
+			continue
+		}
+		if holeStart >= end {
+			break
+		}
+		if holeStart < start {
+			holeStart = start
+		}
+		if holeEnd > end {
+			holeEnd = end
+		}
+		buf := job.data[holeStart-job.offset : holeEnd-job.offset]
+		if restic.ZeroPrefixLen(buf) < len(buf) {
+			continue
+		}
+		if holeStart > start {
+			err := r.filesWriter.writeToFile(path, job.data[start-job.offset:holeStart-job.offset], start, -1, sparse)
+			if err != nil {
+				return err
+			}
+		}
+		start = holeEnd
+	}
+	if start < end {
+		return r.filesWriter.writeToFile(path, job.data[start-job.offset:], start, -1, sparse)
+	}
+	return nil
+}
+
+// completeBlob records that a blob of file was written and returns whether
+// this completed the file. locked must be set if the caller holds file.lock.
+func (r *fileRestorer) completeBlob(file *fileInfo, locked bool) bool {
+	if !locked {
+		file.lock.Lock()
+		defer file.lock.Unlock()
+	}
+	file.blobsLeft--
+	return file.blobsLeft == 0
+}
+
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -123,7 +123,7 @@ func newTestRepo(content []TestFile) *TestRepo {
 		for _, blob := range file.blobs {
 			content = append(content, restic.Hash([]byte(blob.data)))
 		}
-		files = append(files, &fileInfo{location: file.name, blobs: content})
+		files = append(files, &fileInfo{location: file.name, blobs: content, blobsLeft: len(content)})
 	}
 
 	repo := &TestRepo{
@@ -215,6 +215,44 @@ func TestFileRestorerBasic(t *testing.T) {
 	}
 }
 
+func TestFileRestorerWriteStrategy(t *testing.T) {
+	content := []TestFile{
+		{
+			name: "file1",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+				{"data1-2", "pack2"},
+				{"data1-3", "pack1"},
+			},
+		},
+		{
+			name: "file2",
+			blobs: []TestBlob{
+				{"data2-1", "pack2"},
+				{"data1-2", "pack2"},
+				{"data2-1", "pack2"},
+			},
+		},
+	}
+
+	for _, writers := range []int{1, 4} {
+		tempdir := rtest.TempDir(t)
+		repo := newTestRepo(content)
+
+		r := newFileRestorer(tempdir, repo.loader, repo.key, repo.Lookup, 2, false, nil)
+		r.writerCount = writers
+		r.fsync = true
+		r.files = repo.files
+
+		rtest.OK(t, r.restoreFiles(context.TODO()))
+		verifyRestore(t, r, repo)
+
+		for _, file := range r.files {
+			rtest.Equals(t, 0, file.blobsLeft)
+		}
+	}
+}
+
 func TestFileRestorerPackSkip(t *testing.T) {
 	tempdir := rtest.TempDir(t)
 
@@ -0,0 +1,179 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// reuseLocalData checks which blobs of the files to restore are already
+// available locally, either at the same offset in the file already present
+// in the target directory or in the file at the same location below one of
+// the reference directories. Blobs found in the target are left in place,
+// blobs found in a reference directory are copied to the target. Only the
+// remaining blobs are downloaded afterwards.
+func (r *fileRestorer) reuseLocalData(ctx context.Context) error {
+	if !r.reuseTarget && len(r.referenceDirs) == 0 {
+		return nil
+	}
+
+	wg, ctx := errgroup.WithContext(ctx)
+	ch := make(chan *fileInfo)
+	wg.Go(func() error {
+		defer close(ch)
+		for _, file := range r.files {
+			if file.bundled {
+				continue
+			}
+			select {
+			case ch <- file:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < r.writerCount; i++ {
+		wg.Go(func() error {
+			var buf []byte
+			for file := range ch {
+				buf = r.reuseFileData(file, buf)
+			}
+			return nil
+		})
+	}
+	return wg.Wait()
+}
+
+// reuseFileData records the blobs of file which are available locally in
+// file.local. Any error while reading or writing local files is ignored, the
+// affected blobs are then downloaded as usual.
+func (r *fileRestorer) reuseFileData(file *fileInfo, buf []byte) []byte {
+	fileBlobs := file.blobs.(restic.IDs)
+	offsets := make([]int64, len(fileBlobs))
+	lengths := make([]int, len(fileBlobs))
+	var offset int64
+	for i, id := range fileBlobs {
+		packs := r.idx(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+		if len(packs) == 0 {
+			// restoreFiles reports the missing blob
+			return buf
+		}
+		offsets[i] = offset
+		lengths[i] = int(packs[0].DataLength())
+		offset += int64(lengths[i])
+	}
+
+	target := r.targetPath(file.location)
+	local := make(map[int]struct{})
+	if r.reuseTarget {
+		buf = matchLocalBlobs(target, r.blobHash, fileBlobs, offsets, lengths, local, buf, nil)
+	}
+
+	var wr *os.File
+	var writeErr error
+	for _, dir := range r.referenceDirs {
+		if len(local) == len(fileBlobs) || writeErr != nil {
+			break
+		}
+		buf = matchLocalBlobs(filepath.Join(dir, file.location), r.blobHash, fileBlobs, offsets, lengths, local, buf, func(data []byte, offset int64) {
+			if writeErr != nil {
+				return
+			}
+			if wr == nil {
+				wr, writeErr = os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0600)
+				if writeErr != nil {
+					return
+				}
+			}
+			_, writeErr = wr.WriteAt(data, offset)
+		})
+	}
+
+	if len(local) > 0 && wr == nil && writeErr == nil {
+		wr, writeErr = os.OpenFile(target, os.O_WRONLY, 0600)
+	}
+	if writeErr == nil && wr != nil {
+		writeErr = wr.Truncate(file.size)
+	}
+	if writeErr == nil && wr != nil && r.fsync && len(local) == len(fileBlobs) {
+		writeErr = wr.Sync()
+	}
+	if wr != nil {
+		if err := wr.Close(); writeErr == nil {
+			writeErr = err
+		}
+	}
+	if writeErr != nil {
+		// the target is recreated from scratch while downloading
+		debug.Log("unable to reuse local data for %v: %v", target, writeErr)
+		return buf
+	}
+	if len(local) == 0 {
+		return buf
+	}
+
+	var reused uint64
+	for i := range local {
+		reused += uint64(lengths[i])
+	}
+	debug.Log("reusing %d of %d blobs (%d bytes) for %v", len(local), len(fileBlobs), reused, target)
+	atomic.AddUint64(&r.reusedBytes, reused)
+	if r.progress != nil {
+		r.progress.AddProgress(file.location, reused, uint64(file.size))
+	}
+
+	// the target already contains the reused data and must not be truncated,
+	// restoreFiles also disables sparse writes for it
+	file.local = local
+	file.inProgress = true
+	file.blobsLeft -= len(local)
+	return buf
+}
+
+// matchLocalBlobs reads each blob not yet contained in local from path and
+// adds it to local if its contents match, computing blob IDs using hash. If
+// found is not nil, it is called with the data of each matching blob.
+func matchLocalBlobs(path string, hash restic.BlobHashFunc, ids restic.IDs, offsets []int64, lengths []int, local map[int]struct{}, buf []byte, found func(data []byte, offset int64)) []byte {
+	fi, err := os.Lstat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return buf
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		debug.Log("unable to open %v: %v", path, err)
+		return buf
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	for i, id := range ids {
+		if _, ok := local[i]; ok || offsets[i]+int64(lengths[i]) > fi.Size() {
+			continue
+		}
+		if lengths[i] > cap(buf) {
+			buf = make([]byte, 2*lengths[i])
+		}
+		buf = buf[:lengths[i]]
+		if _, err := f.ReadAt(buf, offsets[i]); err != nil {
+			debug.Log("unable to read %v: %v", path, err)
+			return buf
+		}
+		if !id.Equal(hash(buf)) {
+			continue
+		}
+		local[i] = struct{}{}
+		if found != nil {
+			found(buf, offsets[i])
+		}
+	}
+	return buf
+}
@@ -0,0 +1,58 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerReuseLocalData(t *testing.T) {
+	repo := repository.TestRepository(t)
+	content := rtest.Random(42, 5*1024*1024)
+
+	srcdir := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(srcdir, "file"), content, 0644))
+	arch := archiver.New(repo, fs.Track{FS: fs.Local{}}, archiver.Options{})
+	back := rtest.Chdir(t, srcdir)
+	sn, _, err := arch.Snapshot(context.TODO(), []string{"file"}, archiver.SnapshotOptions{Time: time.Now()})
+	back()
+	rtest.OK(t, err)
+
+	restore := func(target string, reuseTarget bool, referenceDirs ...string) uint64 {
+		res := NewRestorer(context.TODO(), repo, sn, false, nil)
+		res.ReuseTarget = reuseTarget
+		res.ReferenceDirs = referenceDirs
+		rtest.OK(t, res.RestoreTo(context.TODO(), target))
+
+		data, err := os.ReadFile(filepath.Join(target, "file"))
+		rtest.OK(t, err)
+		rtest.Assert(t, string(data) == string(content), "restored file has wrong content")
+		return res.ReusedBytes()
+	}
+
+	// a modified copy in the target is partially reused
+	target := rtest.TempDir(t)
+	modified := append([]byte(nil), content...)
+	modified[len(modified)/2] ^= 1
+	modified = append(modified, "trailing data"...)
+	rtest.OK(t, os.WriteFile(filepath.Join(target, "file"), modified, 0644))
+	reused := restore(target, true)
+	rtest.Assert(t, reused > 0 && reused < uint64(len(content)), "unexpected amount of reused data %d", reused)
+
+	// without reuse, the existing target is always overwritten
+	rtest.Equals(t, uint64(0), restore(target, false))
+
+	// all data is copied from the reference directory
+	rtest.Equals(t, uint64(len(content)), restore(rtest.TempDir(t), false, srcdir))
+
+	// the reference directory is only used for data missing in the target
+	rtest.OK(t, os.WriteFile(filepath.Join(target, "file"), modified, 0644))
+	rtest.Equals(t, uint64(len(content)), restore(target, true, srcdir))
+}
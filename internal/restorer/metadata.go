@@ -0,0 +1,61 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// restoreMetadataOnly applies the metadata of all items in the snapshot to
+// the existing items below dst, without creating any items or modifying the
+// contents of files. Items which do not exist or have a different type are
+// skipped.
+func (res *Restorer) restoreMetadataOnly(ctx context.Context, dst string) error {
+	restoreMetadata := func(node *restic.Node, target, location string) error {
+		fi, err := fs.Lstat(target)
+		if err != nil || !sameNodeType(node, fi) {
+			debug.Log("skipping %v, missing or wrong type: %v", target, err)
+			return nil
+		}
+
+		if res.progress != nil {
+			res.progress.AddFile(0)
+		}
+		err = res.restoreNodeMetadataTo(node, target, location)
+		if err == nil && res.progress != nil {
+			res.progress.AddProgress(location, 0, 0)
+		}
+		return err
+	}
+
+	_, err := res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+		visitNode: restoreMetadata,
+		leaveDir:  restoreMetadata,
+	})
+	return err
+}
+
+// sameNodeType returns whether the existing item fi has the type of node.
+func sameNodeType(node *restic.Node, fi os.FileInfo) bool {
+	mode := fi.Mode()
+	switch node.Type {
+	case "file":
+		return mode.IsRegular()
+	case "dir":
+		return mode.IsDir()
+	case "symlink":
+		return mode&os.ModeSymlink != 0
+	case "dev":
+		return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+	case "chardev":
+		return mode&os.ModeCharDevice != 0
+	case "fifo":
+		return mode&os.ModeNamedPipe != 0
+	default:
+		return false
+	}
+}
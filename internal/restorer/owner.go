@@ -0,0 +1,71 @@
+package restorer
+
+import (
+	"strconv"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// OwnerMap changes the owner of restored files and directories. Users and
+// groups from the snapshot are matched either by name or by their numeric ID.
+// The zero value does not change any owner.
+type OwnerMap struct {
+	users  map[string]uint32
+	groups map[string]uint32
+
+	uid, gid *uint32
+}
+
+// MapUser restores items owned by the user old, a user name or a numeric ID,
+// with the owner uid.
+func (m *OwnerMap) MapUser(old string, uid uint32) {
+	if m.users == nil {
+		m.users = make(map[string]uint32)
+	}
+	m.users[old] = uid
+}
+
+// MapGroup restores items owned by the group old, a group name or a numeric
+// ID, with the group gid.
+func (m *OwnerMap) MapGroup(old string, gid uint32) {
+	if m.groups == nil {
+		m.groups = make(map[string]uint32)
+	}
+	m.groups[old] = gid
+}
+
+// SetUser restores all items with the owner uid, regardless of MapUser.
+func (m *OwnerMap) SetUser(uid uint32) {
+	m.uid = &uid
+}
+
+// SetGroup restores all items with the group gid, regardless of MapGroup.
+func (m *OwnerMap) SetGroup(gid uint32) {
+	m.gid = &gid
+}
+
+func lookupOwner(ids map[string]uint32, name string, id uint32) (uint32, bool) {
+	if name != "" {
+		if newID, ok := ids[name]; ok {
+			return newID, true
+		}
+	}
+	newID, ok := ids[strconv.FormatUint(uint64(id), 10)]
+	return newID, ok
+}
+
+// apply returns the owner and group node is restored with.
+func (m *OwnerMap) apply(node *restic.Node) (uid uint32, gid uint32) {
+	uid, gid = node.UID, node.GID
+	if m.uid != nil {
+		uid = *m.uid
+	} else if newUID, ok := lookupOwner(m.users, node.User, node.UID); ok {
+		uid = newUID
+	}
+	if m.gid != nil {
+		gid = *m.gid
+	} else if newGID, ok := lookupOwner(m.groups, node.Group, node.GID); ok {
+		gid = newGID
+	}
+	return uid, gid
+}
@@ -0,0 +1,35 @@
+package restorer
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestOwnerMap(t *testing.T) {
+	var m OwnerMap
+	m.MapUser("alice", 2000)
+	m.MapUser("1001", 2001)
+	m.MapGroup("staff", 3000)
+
+	for _, test := range []struct {
+		node     restic.Node
+		uid, gid uint32
+	}{
+		{restic.Node{User: "alice", UID: 1000, Group: "staff", GID: 50}, 2000, 3000},
+		{restic.Node{User: "bob", UID: 1001, Group: "users", GID: 100}, 2001, 100},
+		{restic.Node{UID: 1001, GID: 50}, 2001, 50},
+		{restic.Node{User: "carol", UID: 1002, GID: 100}, 1002, 100},
+	} {
+		uid, gid := m.apply(&test.node)
+		rtest.Equals(t, test.uid, uid)
+		rtest.Equals(t, test.gid, gid)
+	}
+
+	// a fixed owner takes precedence over the mapping
+	m.SetUser(0)
+	uid, gid := m.apply(&restic.Node{User: "alice", UID: 1000, Group: "staff", GID: 50})
+	rtest.Equals(t, uint32(0), uid)
+	rtest.Equals(t, uint32(3000), gid)
+}
@@ -1,14 +1,21 @@
 package restorer
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"io"
 	"os"
 	"path/filepath"
 	"sync/atomic"
 
+	"github.com/restic/restic/internal/bloblru"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/layer"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	restoreui "github.com/restic/restic/internal/ui/restore"
 
@@ -23,6 +30,30 @@ type Restorer struct {
 
 	progress *restoreui.Progress
 
+	// WriteStrategy controls how files are written, see TargetFS.Strategy.
+	WriteStrategy WriteStrategy
+
+	// ReuseTarget keeps data of files already present in the target directory
+	// instead of downloading it again.
+	ReuseTarget bool
+	// ReferenceDirs are searched for files at the same location as a restored
+	// file. Matching data is copied from them instead of downloading it.
+	ReferenceDirs []string
+	reusedBytes   uint64
+
+	// Owners changes the owner of restored items, see OwnerMap.
+	Owners *OwnerMap
+
+	// Delete removes files and directories from the target which are not
+	// contained in the snapshot before restoring it. ItemRemoved is called
+	// for each removed item.
+	Delete      bool
+	ItemRemoved func(location string)
+
+	// MetadataOnly only applies the metadata of the snapshot to items which
+	// already exist in the target, their contents are not modified.
+	MetadataOnly bool
+
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
 }
@@ -178,6 +209,11 @@ func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, targe
 
 func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location string) error {
 	debug.Log("restoreNodeMetadata %v %v %v", node.Name, target, location)
+	if res.Owners != nil {
+		mapped := *node
+		mapped.UID, mapped.GID = res.Owners.apply(node)
+		node = &mapped
+	}
 	err := node.RestoreMetadata(target)
 	if err != nil {
 		debug.Log("node.RestoreMetadata(%s) error %v", target, err)
@@ -219,6 +255,53 @@ func (res *Restorer) restoreEmptyFileAt(node *restic.Node, target, location stri
 	return res.restoreNodeMetadataTo(node, target, location)
 }
 
+// restoreEncodedFileAt reconstructs the original contents of a file which
+// was stored decoded. The blobs are loaded one after another, as the encoding
+// can only be written sequentially.
+func (res *Restorer) restoreEncodedFileAt(ctx context.Context, node *restic.Node, target, location string) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	wr, err := layer.NewWriter(bw, node.ContentEncoding)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	var buf []byte
+	for _, id := range node.Content {
+		buf, err = res.repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+		if err == nil {
+			_, err = wr.Write(buf)
+		}
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	err = wr.Close()
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	if res.progress != nil {
+		res.progress.AddProgress(location, node.Size, node.Size)
+	}
+	return nil
+}
+
 // RestoreTo creates the directories and files in the snapshot below dst.
 // Before an item is created, res.Filter is called.
 func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
@@ -230,10 +313,45 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		}
 	}
 
+	if res.MetadataOnly {
+		return res.restoreMetadataOnly(ctx, dst)
+	}
+
+	filters, err := repository.LookupBlobFilters(res.repo.Config().Filters)
+	if err != nil {
+		return err
+	}
+
+	type encodedFile struct {
+		node             *restic.Node
+		target, location string
+	}
+	var encodedFiles []encodedFile
+
 	idx := NewHardlinkIndex()
 	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup,
 		res.repo.Connections(), res.sparse, res.progress)
+	filerestorer.filters = filters
+	filerestorer.blobHash = res.repo.Config().HashBlob
+	filerestorer.zeroChunk = repository.ZeroChunk(res.repo.Config())
+	if repo, ok := res.repo.(dataKeyRepository); ok {
+		filerestorer.openBlob = repo.OpenDataBlob
+	}
 	filerestorer.Error = res.Error
+	if res.WriteStrategy.Writers > 0 {
+		filerestorer.writerCount = res.WriteStrategy.Writers
+	}
+	filerestorer.fsync = res.WriteStrategy.Fsync
+	filerestorer.reuseTarget = res.ReuseTarget
+	filerestorer.referenceDirs = res.ReferenceDirs
+
+	if res.Delete {
+		debug.Log("removing extra files from %q", dst)
+		err = res.deleteExtraFiles(ctx, dst, string(filepath.Separator), res.sn.Tree)
+		if err != nil {
+			return err
+		}
+	}
 
 	debug.Log("first pass for %q", dst)
 
@@ -287,7 +405,20 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				res.progress.AddFile(node.Size)
 			}
 
-			filerestorer.addFile(location, node.Content, int64(node.Size))
+			if node.ContentEncoding != nil {
+				encodedFiles = append(encodedFiles, encodedFile{node, target, location})
+				return nil
+			}
+
+			if node.Bundle != nil {
+				if len(node.Content) != 1 {
+					return errors.Errorf("bundled file %v must reference exactly one blob", location)
+				}
+				filerestorer.addBundledFile(location, node.Content[0], int64(node.Size), int64(node.Bundle.Offset))
+				return nil
+			}
+
+			filerestorer.addFile(location, node.Content, int64(node.Size), node.Holes)
 
 			return nil
 		},
@@ -297,10 +428,21 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	}
 
 	err = filerestorer.restoreFiles(ctx)
+	res.reusedBytes = filerestorer.reusedBytes
 	if err != nil {
 		return err
 	}
 
+	for _, file := range encodedFiles {
+		err = res.restoreEncodedFileAt(ctx, file.node, file.target, file.location)
+		if err != nil {
+			err = res.Error(file.location, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
 	debug.Log("second pass for %q", dst)
 
 	// second tree pass: restore special files and filesystem metadata
@@ -336,6 +478,12 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	return err
 }
 
+// ReusedBytes returns the amount of file data which RestoreTo took from the
+// target or the reference directories instead of downloading it.
+func (res *Restorer) ReusedBytes() uint64 {
+	return res.reusedBytes
+}
+
 // Snapshot returns the snapshot this restorer is configured to use.
 func (res *Restorer) Snapshot() *restic.Snapshot {
 	return res.sn
@@ -361,6 +509,10 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 
 	g, ctx := errgroup.WithContext(ctx)
 
+	// bundled files are verified against the loaded bundle, which is shared
+	// by the files of a directory
+	bundles := bloblru.New(64 << 20)
+
 	// Traverse tree and send jobs to work.
 	g.Go(func() error {
 		defer close(work)
@@ -385,7 +537,11 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 		g.Go(func() (err error) {
 			var buf []byte
 			for job := range work {
-				buf, err = res.verifyFile(job.path, job.node, buf)
+				if job.node.Bundle != nil {
+					err = res.verifyBundledFile(ctx, job.path, job.node, bundles)
+				} else {
+					buf, err = res.verifyFile(ctx, job.path, job.node, buf)
+				}
 				if err != nil {
 					err = res.Error(job.path, err)
 				}
@@ -406,7 +562,14 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 // buf and the first return value are scratch space, passed around for reuse.
 // Reusing buffers prevents the verifier goroutines allocating all of RAM and
 // flushing the filesystem cache (at least on Linux).
-func (res *Restorer) verifyFile(target string, node *restic.Node, buf []byte) ([]byte, error) {
+// dataKeyRepository is implemented by repositories which support encrypting
+// the contents of files with data keys.
+type dataKeyRepository interface {
+	OpenDataBlob(ctx context.Context, id restic.ID, buf []byte) ([]byte, error)
+	SealDataBlob(ctx context.Context, key restic.ID, data []byte) ([]byte, error)
+}
+
+func (res *Restorer) verifyFile(ctx context.Context, target string, node *restic.Node, buf []byte) ([]byte, error) {
 	f, err := os.Open(target)
 	if err != nil {
 		return buf, err
@@ -424,6 +587,10 @@ func (res *Restorer) verifyFile(target string, node *restic.Node, buf []byte) ([
 			target, node.Size, fi.Size())
 	}
 
+	if node.ContentEncoding != nil {
+		return res.verifyEncodedFile(ctx, f, target, node, buf)
+	}
+
 	var offset int64
 	for _, blobID := range node.Content {
 		length, found := res.repo.LookupBlobSize(blobID, restic.DataBlob)
@@ -440,7 +607,11 @@ func (res *Restorer) verifyFile(target string, node *restic.Node, buf []byte) ([
 		if err != nil {
 			return buf, err
 		}
-		if !blobID.Equal(restic.Hash(buf)) {
+		ok, err := res.blobMatches(ctx, node, blobID, buf)
+		if err != nil {
+			return buf, err
+		}
+		if !ok {
 			return buf, errors.Errorf(
 				"Unexpected content in %s, starting at offset %d",
 				target, offset)
@@ -450,3 +621,109 @@ func (res *Restorer) verifyFile(target string, node *restic.Node, buf []byte) ([
 
 	return buf, nil
 }
+
+// verifyEncodedFile checks the decoded contents of f against the blobs of
+// node.
+func (res *Restorer) verifyEncodedFile(ctx context.Context, f *os.File, target string, node *restic.Node, buf []byte) ([]byte, error) {
+	rd, err := layer.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return buf, errors.Errorf("Unexpected content in %s: %v", target, err)
+	}
+
+	var offset int64
+	for _, blobID := range node.Content {
+		length, found := res.repo.LookupBlobSize(blobID, restic.DataBlob)
+		if !found {
+			return buf, errors.Errorf("Unable to fetch blob %s", blobID)
+		}
+
+		if length > uint(cap(buf)) {
+			buf = make([]byte, 2*length)
+		}
+		buf = buf[:length]
+
+		_, err = io.ReadFull(rd, buf)
+		if err != nil {
+			return buf, err
+		}
+		ok, err := res.blobMatches(ctx, node, blobID, buf)
+		if err != nil {
+			return buf, err
+		}
+		if !ok {
+			return buf, errors.Errorf(
+				"Unexpected content in %s, starting at decoded offset %d",
+				target, offset)
+		}
+		offset += int64(length)
+	}
+
+	if node.ContentEncoding.Hash == nil {
+		return buf, nil
+	}
+
+	// the encoded file must be identical to the original file
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return buf, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return buf, err
+	}
+	if !restic.IDFromHash(h.Sum(nil)).Equal(*node.ContentEncoding.Hash) {
+		return buf, errors.Errorf("Unexpected content in %s, file differs from the original file", target)
+	}
+
+	return buf, nil
+}
+
+// blobMatches returns true if buf contains the contents of the data blob id
+// of node.
+func (res *Restorer) blobMatches(ctx context.Context, node *restic.Node, id restic.ID, buf []byte) (bool, error) {
+	if node.DataKey != nil {
+		dataKeys, ok := res.repo.(dataKeyRepository)
+		if !ok {
+			return false, errors.New("data keys are not supported")
+		}
+		// the blob ID is computed over the encrypted contents
+		var err error
+		buf, err = dataKeys.SealDataBlob(ctx, *node.DataKey, buf)
+		if err != nil {
+			return false, err
+		}
+	}
+	return id.Equal(res.repo.Config().HashBlob(buf)), nil
+}
+
+// verifyBundledFile checks that the file target contains the part of the
+// bundle which belongs to node.
+func (res *Restorer) verifyBundledFile(ctx context.Context, target string, node *restic.Node, bundles *bloblru.Cache) error {
+	if len(node.Content) != 1 {
+		return errors.Errorf("bundled file %s must reference exactly one blob", target)
+	}
+
+	id := node.Content[0]
+	bundle, ok := bundles.Get(id)
+	if !ok {
+		var err error
+		bundle, err = res.repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+		if err != nil {
+			return err
+		}
+		bundles.Add(id, bundle)
+	}
+	if node.Bundle.Offset+node.Size > uint64(len(bundle)) {
+		return errors.Errorf("bundle %s is too short for %s", id.Str(), target)
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, bundle[node.Bundle.Offset:node.Bundle.Offset+node.Size]) {
+		return errors.Errorf("Unexpected content in %s", target)
+	}
+	return nil
+}
@@ -32,6 +32,7 @@ type File struct {
 	Inode   uint64
 	Mode    os.FileMode
 	ModTime time.Time
+	Holes   []restic.FileHole
 }
 
 type Dir struct {
@@ -88,6 +89,7 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				Size:    uint64(len(n.(File).Data)),
 				Inode:   fi,
 				Links:   lc,
+				Holes:   node.Holes,
 			})
 			rtest.OK(t, err)
 		case Dir:
@@ -875,3 +877,70 @@ func TestRestorerSparseFiles(t *testing.T) {
 	t.Logf("wrote %d zeros as %d blocks, %.1f%% sparse",
 		len(zeros), blocks, 100*sparsity)
 }
+
+func TestRestorerHoles(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	srcdir := rtest.TempDir(t)
+	data := rtest.Random(23, 1<<20)
+	f, err := os.Create(filepath.Join(srcdir, "sparse"))
+	rtest.OK(t, err)
+	rtest.OK(t, f.Truncate(16<<20))
+	_, err = f.WriteAt(data, 8<<20)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	arch := archiver.New(repo, fs.Track{FS: fs.Local{}}, archiver.Options{})
+	back := rtest.Chdir(t, srcdir)
+	sn, _, err := arch.Snapshot(context.TODO(), []string{"sparse"}, archiver.SnapshotOptions{Time: time.Now()})
+	back()
+	rtest.OK(t, err)
+
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	if len(tree.Find("sparse").Holes) == 0 {
+		t.Skip("filesystem does not report holes")
+	}
+
+	res := NewRestorer(context.TODO(), repo, sn, false, nil)
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	filename := filepath.Join(tempdir, "sparse")
+	content, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, 16<<20, len(content))
+	rtest.Equals(t, data, content[8<<20:9<<20])
+	rtest.Equals(t, 8<<20, restic.ZeroPrefixLen(content[:8<<20]))
+	rtest.Equals(t, 7<<20, restic.ZeroPrefixLen(content[9<<20:]))
+
+	blocks := getBlockCount(t, filename)
+	if blocks < 0 {
+		return
+	}
+	rtest.Assert(t, blocks*512 < 4<<20, "holes were not recreated, file uses %d blocks", blocks)
+}
+
+func TestRestorerHolesWithData(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	// the second hole was written after the holes were detected
+	zeros := strings.Repeat("\x00", 8192)
+	data := zeros + "foo" + zeros
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"sparse": File{Data: data, Holes: []restic.FileHole{
+				{Offset: 0, Length: 8192},
+				{Offset: 8192, Length: 8195},
+			}},
+		},
+	})
+
+	res := NewRestorer(context.TODO(), repo, sn, false, nil)
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	content, err := os.ReadFile(filepath.Join(tempdir, "sparse"))
+	rtest.OK(t, err)
+	rtest.Equals(t, data, string(content))
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"testing"
 	"time"
@@ -121,3 +122,76 @@ func TestRestorerProgressBar(t *testing.T) {
 	rtest.Assert(t, mock.allBytesWritten == allBytesWritten, "allBytesWritten: expected %v, got %v", allBytesWritten, mock.allBytesWritten)
 	rtest.Assert(t, mock.allBytesTotal == allBytesTotal, "allBytesTotal: expected %v, got %v", allBytesTotal, mock.allBytesTotal)
 }
+
+func TestRestorerOwners(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner requires root")
+	}
+	repo := repository.TestRepository(t)
+
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content"},
+				},
+			},
+		},
+	})
+
+	res := NewRestorer(context.TODO(), repo, sn, false, nil)
+	res.Owners = &OwnerMap{}
+	res.Owners.MapUser(strconv.Itoa(os.Getuid()), 1234)
+	res.Owners.SetGroup(5678)
+
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	for _, name := range []string{"dir", "dir/file"} {
+		fi, err := os.Lstat(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		st := fi.Sys().(*syscall.Stat_t)
+		rtest.Equals(t, uint32(1234), st.Uid)
+		rtest.Equals(t, uint32(5678), st.Gid)
+	}
+}
+
+func TestRestorerMetadataOnly(t *testing.T) {
+	repo := repository.TestRepository(t)
+	modtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Mode:    0750,
+				ModTime: modtime,
+				Nodes: map[string]Node{
+					"file":    File{Data: "content", Mode: 0640, ModTime: modtime},
+					"missing": File{Data: "missing", Mode: 0640, ModTime: modtime},
+				},
+			},
+		},
+	})
+
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, os.Mkdir(filepath.Join(tempdir, "dir"), 0777))
+	filename := filepath.Join(tempdir, "dir", "file")
+	rtest.OK(t, os.WriteFile(filename, []byte("changed"), 0777))
+
+	res := NewRestorer(context.TODO(), repo, sn, false, nil)
+	res.MetadataOnly = true
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	data, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, "changed", string(data))
+	_, err = os.Lstat(filepath.Join(tempdir, "dir", "missing"))
+	rtest.Assert(t, os.IsNotExist(err), "missing file was created")
+
+	for name, mode := range map[string]os.FileMode{"dir": 0750, "dir/file": 0640} {
+		fi, err := os.Lstat(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		rtest.Equals(t, mode, fi.Mode().Perm())
+		rtest.Equals(t, modtime, fi.ModTime().UTC())
+	}
+}
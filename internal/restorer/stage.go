@@ -0,0 +1,119 @@
+package restorer
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// RequiredPacks returns the pack files which contain the data of all files
+// selected for restore.
+func (res *Restorer) RequiredPacks(ctx context.Context) (restic.IDSet, error) {
+	packs := restic.NewIDSet()
+	idx := res.repo.Index()
+
+	root := string(filepath.Separator)
+	_, err := res.traverseTree(ctx, root, root, *res.sn.Tree, treeVisitor{
+		visitNode: func(node *restic.Node, target, location string) error {
+			if node.Type != "file" {
+				return nil
+			}
+
+			for _, id := range node.Content {
+				// the file restorer reads each blob from the first pack listed in the index
+				pbs := idx.Lookup(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+				if len(pbs) == 0 {
+					return errors.Errorf("Unknown blob %s", id.String())
+				}
+				packs.Insert(pbs[0].PackID)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return packs, nil
+}
+
+// StagePacks requests that the backend retrieves all packs from archive
+// storage and waits until all of them can be read. The backend is polled
+// after each interval, report is called with the number of readable packs
+// after each round.
+func StagePacks(ctx context.Context, repo restic.Repository, packs restic.IDSet, interval time.Duration, report func(ready, total int)) error {
+	be := backend.AsBackend[restic.StagingBackend](repo.Backend())
+	if be == nil {
+		return errors.Fatal("the backend does not support staging archived files")
+	}
+
+	pending := packs.List()
+	total := len(pending)
+
+	for {
+		var m sync.Mutex
+		var notReady restic.IDs
+
+		wg, wgCtx := errgroup.WithContext(ctx)
+		ch := make(chan restic.ID)
+
+		wg.Go(func() error {
+			defer close(ch)
+			for _, id := range pending {
+				select {
+				case ch <- id:
+				case <-wgCtx.Done():
+					return wgCtx.Err()
+				}
+			}
+			return nil
+		})
+
+		for i := 0; i < int(repo.Connections()); i++ {
+			wg.Go(func() error {
+				for id := range ch {
+					h := restic.Handle{Type: restic.PackFile, Name: id.String(), ContainedBlobType: restic.DataBlob}
+					ready, err := be.Stage(wgCtx, h)
+					if err != nil {
+						return errors.Wrapf(err, "staging pack %v", id.Str())
+					}
+
+					if !ready {
+						m.Lock()
+						notReady = append(notReady, id)
+						m.Unlock()
+					}
+				}
+				return nil
+			})
+		}
+
+		err := wg.Wait()
+		if err != nil {
+			return err
+		}
+
+		pending = notReady
+		debug.Log("%d of %d packs are ready", total-len(pending), total)
+		if report != nil {
+			report(total-len(pending), total)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
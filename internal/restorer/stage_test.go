@@ -0,0 +1,78 @@
+package restorer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// stagingBackend reports each file as ready after it was staged twice.
+type stagingBackend struct {
+	restic.Backend
+
+	m      sync.Mutex
+	staged map[restic.Handle]int
+}
+
+func (be *stagingBackend) Stage(ctx context.Context, h restic.Handle) (bool, error) {
+	be.m.Lock()
+	defer be.m.Unlock()
+	be.staged[h]++
+	return be.staged[h] >= 2, nil
+}
+
+func (be *stagingBackend) Archived(ctx context.Context, h restic.Handle) (bool, error) {
+	be.m.Lock()
+	defer be.m.Unlock()
+	return be.staged[h] < 2, nil
+}
+
+func TestRestorerStagePacks(t *testing.T) {
+	be := &stagingBackend{Backend: mem.New(), staged: make(map[restic.Handle]int)}
+	repo := repository.TestRepositoryWithBackend(t, be, 0)
+
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"bar": File{Data: "content: bar\n"},
+				},
+			},
+		},
+	})
+
+	res := NewRestorer(context.TODO(), repo, sn, false, nil)
+	res.SelectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		return item != "/dir", item != "/dir"
+	}
+
+	packs, err := res.RequiredPacks(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(packs))
+
+	var rounds []int
+	err = StagePacks(context.TODO(), repo, packs, time.Millisecond, func(ready, total int) {
+		rtest.Equals(t, len(packs), total)
+		rounds = append(rounds, ready)
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, []int{0, 1}, rounds)
+
+	for id := range packs {
+		h := restic.Handle{Type: restic.PackFile, Name: id.String(), ContainedBlobType: restic.DataBlob}
+		rtest.Equals(t, 2, be.staged[h])
+	}
+}
+
+func TestRestorerStagePacksUnsupported(t *testing.T) {
+	repo := repository.TestRepository(t)
+	err := StagePacks(context.TODO(), repo, restic.NewIDSet(restic.NewRandomID()), time.Millisecond, nil)
+	rtest.Assert(t, err != nil, "expected error for backend without staging support")
+}
@@ -0,0 +1,89 @@
+package restorer
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// TargetFS is the kind of filesystem a snapshot is restored to. It determines
+// how files are written.
+type TargetFS string
+
+// The supported kinds of target filesystems.
+const (
+	TargetUnknown TargetFS = ""
+	TargetSSD     TargetFS = "ssd"
+	TargetHDD     TargetFS = "hdd"
+	TargetNFS     TargetFS = "nfs"
+	TargetSMB     TargetFS = "smb"
+)
+
+// ParseTargetFS parses the name of a kind of target filesystem. The empty
+// string and "auto" yield TargetUnknown.
+func ParseTargetFS(s string) (TargetFS, error) {
+	switch t := TargetFS(s); t {
+	case "", "auto":
+		return TargetUnknown, nil
+	case TargetSSD, TargetHDD, TargetNFS, TargetSMB:
+		return t, nil
+	}
+	return TargetUnknown, errors.Errorf("invalid target filesystem %q, must be one of auto, ssd, hdd, nfs or smb", s)
+}
+
+func (t TargetFS) String() string {
+	if t == TargetUnknown {
+		return "unknown"
+	}
+	return string(t)
+}
+
+// DetectTargetFS returns the kind of filesystem dir is stored on. If dir does
+// not exist yet, its closest existing parent directory is used.
+func DetectTargetFS(dir string) TargetFS {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return TargetUnknown
+	}
+
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return detectTargetFS(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return TargetUnknown
+		}
+		dir = parent
+	}
+}
+
+// WriteStrategy controls how restored files are written.
+type WriteStrategy struct {
+	// Writers is the number of files which are written concurrently. If it
+	// is zero, the number of backend connections is used.
+	Writers int
+	// Fsync requests that each file is synced to disk once it is complete.
+	Fsync bool
+}
+
+// Strategy returns the write strategy for the target filesystem. Spinning
+// disks suffer from seeks between many concurrently written files, while
+// solid state disks benefit from more parallel writes. Network filesystems
+// collapse under many concurrent writes, for SMB the kernel also buffers large
+// amounts of dirty data, which is flushed in bursts unless each file is
+// synced.
+func (t TargetFS) Strategy() WriteStrategy {
+	switch t {
+	case TargetSSD:
+		return WriteStrategy{Writers: 8}
+	case TargetHDD:
+		return WriteStrategy{Writers: 2}
+	case TargetNFS:
+		return WriteStrategy{Writers: 4}
+	case TargetSMB:
+		return WriteStrategy{Writers: 2, Fsync: true}
+	}
+	return WriteStrategy{}
+}
@@ -0,0 +1,47 @@
+package restorer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"golang.org/x/sys/unix"
+)
+
+func detectTargetFS(dir string) TargetFS {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		debug.Log("statfs %v failed: %v", dir, err)
+		return TargetUnknown
+	}
+
+	switch uint32(st.Type) {
+	case unix.NFS_SUPER_MAGIC:
+		return TargetNFS
+	case unix.SMB_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC:
+		return TargetSMB
+	}
+
+	var s unix.Stat_t
+	if err := unix.Stat(dir, &s); err != nil {
+		return TargetUnknown
+	}
+
+	// sysfs has the queue directory only for whole disks, not for partitions
+	dev := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(s.Dev), unix.Minor(s.Dev))
+	for _, path := range []string{dev + "/queue/rotational", dev + "/../queue/rotational"} {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		debug.Log("%v: %q", path, buf)
+		if strings.TrimSpace(string(buf)) == "1" {
+			return TargetHDD
+		}
+		return TargetSSD
+	}
+
+	return TargetUnknown
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package restorer
+
+func detectTargetFS(dir string) TargetFS {
+	return TargetUnknown
+}
@@ -0,0 +1,33 @@
+package restorer
+
+import (
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseTargetFS(t *testing.T) {
+	for s, want := range map[string]TargetFS{
+		"":     TargetUnknown,
+		"auto": TargetUnknown,
+		"ssd":  TargetSSD,
+		"hdd":  TargetHDD,
+		"nfs":  TargetNFS,
+		"smb":  TargetSMB,
+	} {
+		got, err := ParseTargetFS(s)
+		rtest.OK(t, err)
+		rtest.Equals(t, want, got)
+	}
+
+	_, err := ParseTargetFS("floppy")
+	rtest.Assert(t, err != nil, "invalid target filesystem was accepted")
+}
+
+func TestDetectTargetFSMissingDir(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+
+	// a target which does not exist yet is detected using its parent
+	rtest.Equals(t, DetectTargetFS(tempdir), DetectTargetFS(filepath.Join(tempdir, "missing", "subdir")))
+}
@@ -0,0 +1,408 @@
+// Package sqlite writes database files in the SQLite 3 file format. It only
+// supports creating a new database, whose tables are filled once by appending
+// rows with increasing row IDs. The database does not contain indexes, these
+// can be added later using the sqlite3 tool.
+package sqlite
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// The layout of the file is described at https://www.sqlite.org/fileformat.html.
+
+const (
+	pageSize = 4096
+	// headerSize is the size of the database header on the first page
+	headerSize = 100
+
+	pageTypeInterior = 0x05
+	pageTypeLeaf     = 0x0d
+
+	leafHeaderSize     = 8
+	interiorHeaderSize = 12
+
+	// maxInteriorChildren is the number of children which always fit into an
+	// interior page, each cell consists of a cell pointer, the child page
+	// number and a varint of at most nine bytes.
+	maxInteriorChildren = (pageSize-interiorHeaderSize)/(2+4+9) + 1
+
+	// maxLocal and minLocal are the limits of the payload stored within a
+	// leaf cell, the remaining payload is stored on overflow pages.
+	maxLocal = pageSize - 35
+	minLocal = (pageSize-12)*32/255 - 23
+)
+
+// DB is a database file which is being written.
+type DB struct {
+	f      *os.File
+	pages  uint32
+	tables []*Table
+	closed bool
+}
+
+// Table is a table within a database.
+type Table struct {
+	db        *DB
+	name, sql string
+	rowid     int64
+
+	// cells of the current leaf page
+	cells     [][]byte
+	cellsSize int
+	// leaves contains the full leaf pages
+	leaves []child
+}
+
+// child references a page of a table b-tree together with the largest row
+// ID stored in it.
+type child struct {
+	page uint32
+	key  int64
+}
+
+// Create creates a new database file, which must not exist.
+func Create(filename string) (*DB, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// the first page is written last, it contains the schema
+	return &DB{f: f, pages: 1}, nil
+}
+
+// CreateTable adds a table created by the SQL statement sql to the schema.
+func (db *DB) CreateTable(name, sql string) *Table {
+	t := &Table{db: db, name: name, sql: sql}
+	db.tables = append(db.tables, t)
+	return t
+}
+
+func (db *DB) writePage(page uint32, buf []byte) error {
+	_, err := db.f.WriteAt(buf, int64(page-1)*pageSize)
+	return errors.WithStack(err)
+}
+
+func (db *DB) allocPage() uint32 {
+	db.pages++
+	return db.pages
+}
+
+// Insert appends a row and returns its row ID. The values can be nil, bool,
+// int, int64, uint32, uint64, float64, string or []byte. A column declared as
+// INTEGER PRIMARY KEY is an alias for the row ID and must be nil.
+func (t *Table) Insert(values ...interface{}) (int64, error) {
+	if t.db.closed {
+		return 0, errors.New("database is closed")
+	}
+
+	payload, err := encodeRecord(values)
+	if err != nil {
+		return 0, err
+	}
+	cell, err := t.db.leafCell(t.rowid+1, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	if !fits(leafHeaderSize, len(t.cells), t.cellsSize, len(cell)) {
+		if err := t.flushLeaf(); err != nil {
+			return 0, err
+		}
+	}
+	t.rowid++
+	t.cells = append(t.cells, cell)
+	t.cellsSize += len(cell)
+	return t.rowid, nil
+}
+
+// fits returns true if another cell of the given size fits into a page with
+// the header and the existing cells.
+func fits(header, cells, cellsSize, size int) bool {
+	return header+2*(cells+1)+cellsSize+size <= pageSize
+}
+
+func (t *Table) flushLeaf() error {
+	page := t.db.allocPage()
+	buf := make([]byte, pageSize)
+	encodePage(buf, 0, pageTypeLeaf, t.cells, 0)
+	if err := t.db.writePage(page, buf); err != nil {
+		return err
+	}
+
+	t.leaves = append(t.leaves, child{page: page, key: t.rowid})
+	t.cells = nil
+	t.cellsSize = 0
+	return nil
+}
+
+// finish writes the remaining pages and returns the root page of the table.
+func (t *Table) finish() (uint32, error) {
+	if len(t.cells) > 0 || len(t.leaves) == 0 {
+		if err := t.flushLeaf(); err != nil {
+			return 0, err
+		}
+	}
+
+	level := t.leaves
+	for len(level) > 1 {
+		// distribute the children evenly, such that each page has at least
+		// two of them
+		pages := (len(level) + maxInteriorChildren - 1) / maxInteriorChildren
+		var next []child
+		for i := 0; i < pages; i++ {
+			children := level[len(level)*i/pages : len(level)*(i+1)/pages]
+			right := children[len(children)-1]
+
+			var cells [][]byte
+			for _, c := range children[:len(children)-1] {
+				cell := make([]byte, 4, 13)
+				binary.BigEndian.PutUint32(cell, c.page)
+				cells = append(cells, putVarint(cell, uint64(c.key)))
+			}
+
+			page := t.db.allocPage()
+			buf := make([]byte, pageSize)
+			encodePage(buf, 0, pageTypeInterior, cells, right.page)
+			if err := t.db.writePage(page, buf); err != nil {
+				return 0, err
+			}
+			next = append(next, child{page: page, key: right.key})
+		}
+		level = next
+	}
+	return level[0].page, nil
+}
+
+// Close writes the schema and closes the file.
+func (db *DB) Close() error {
+	if db.closed {
+		return nil
+	}
+
+	err := db.writeSchema()
+	db.closed = true
+	if err != nil {
+		_ = db.f.Close()
+		return err
+	}
+	if err := db.f.Sync(); err != nil {
+		_ = db.f.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(db.f.Close())
+}
+
+func (db *DB) writeSchema() error {
+	var cells [][]byte
+	size := 0
+	for i, t := range db.tables {
+		root, err := t.finish()
+		if err != nil {
+			return err
+		}
+
+		payload, err := encodeRecord([]interface{}{"table", t.name, t.name, int64(root), t.sql})
+		if err != nil {
+			return err
+		}
+		cell, err := db.leafCell(int64(i+1), payload)
+		if err != nil {
+			return err
+		}
+		if !fits(headerSize+leafHeaderSize, len(cells), size, len(cell)) {
+			return errors.New("schema does not fit into the first page")
+		}
+		cells = append(cells, cell)
+		size += len(cell)
+	}
+
+	buf := make([]byte, pageSize)
+	copy(buf, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(buf[16:], pageSize)
+	// file format versions, reserved space and payload fractions
+	copy(buf[18:24], []byte{1, 1, 0, 64, 32, 32})
+	// file change counter
+	binary.BigEndian.PutUint32(buf[24:], 1)
+	binary.BigEndian.PutUint32(buf[28:], db.pages)
+	// schema cookie
+	binary.BigEndian.PutUint32(buf[40:], 1)
+	// schema format 4 supports the serial types for the integers 0 and 1
+	binary.BigEndian.PutUint32(buf[44:], 4)
+	// UTF-8 text encoding
+	binary.BigEndian.PutUint32(buf[56:], 1)
+	// change counter for which the version number is valid
+	binary.BigEndian.PutUint32(buf[92:], 1)
+	binary.BigEndian.PutUint32(buf[96:], 3037000)
+
+	encodePage(buf, headerSize, pageTypeLeaf, cells, 0)
+	return db.writePage(1, buf)
+}
+
+// encodePage writes a b-tree page with the header at offset into buf. The
+// cells are stored at the end of the page.
+func encodePage(buf []byte, offset int, pageType byte, cells [][]byte, right uint32) {
+	header := leafHeaderSize
+	if pageType == pageTypeInterior {
+		header = interiorHeaderSize
+		binary.BigEndian.PutUint32(buf[offset+8:], right)
+	}
+
+	content := pageSize
+	for i, cell := range cells {
+		content -= len(cell)
+		copy(buf[content:], cell)
+		binary.BigEndian.PutUint16(buf[offset+header+2*i:], uint16(content))
+	}
+
+	buf[offset] = pageType
+	binary.BigEndian.PutUint16(buf[offset+3:], uint16(len(cells)))
+	binary.BigEndian.PutUint16(buf[offset+5:], uint16(content))
+}
+
+// leafCell returns the cell of a table leaf page. The part of the payload
+// which does not fit into the cell is written to overflow pages.
+func (db *DB) leafCell(rowid int64, payload []byte) ([]byte, error) {
+	cell := putVarint(nil, uint64(len(payload)))
+	cell = putVarint(cell, uint64(rowid))
+
+	local := len(payload)
+	if local > maxLocal {
+		local = minLocal + (len(payload)-minLocal)%(pageSize-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	cell = append(cell, payload[:local]...)
+	if local == len(payload) {
+		return cell, nil
+	}
+
+	// the overflow pages are allocated consecutively
+	rest := payload[local:]
+	cell = append(cell, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(cell[len(cell)-4:], db.pages+1)
+	for len(rest) > 0 {
+		page := db.allocPage()
+		buf := make([]byte, pageSize)
+		n := copy(buf[4:], rest)
+		rest = rest[n:]
+		if len(rest) > 0 {
+			binary.BigEndian.PutUint32(buf, page+1)
+		}
+		if err := db.writePage(page, buf); err != nil {
+			return nil, err
+		}
+	}
+	return cell, nil
+}
+
+// encodeRecord returns the values in the record format.
+func encodeRecord(values []interface{}) ([]byte, error) {
+	var header, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			header = putVarint(header, 0)
+		case bool:
+			if v {
+				header = putVarint(header, 9)
+			} else {
+				header = putVarint(header, 8)
+			}
+		case int:
+			header, body = appendInt(header, body, int64(v))
+		case int64:
+			header, body = appendInt(header, body, v)
+		case uint32:
+			header, body = appendInt(header, body, int64(v))
+		case uint64:
+			if v > math.MaxInt64 {
+				return nil, errors.Errorf("integer %d is too large", v)
+			}
+			header, body = appendInt(header, body, int64(v))
+		case float64:
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+			header = putVarint(header, 7)
+			body = append(body, buf[:]...)
+		case string:
+			header = putVarint(header, uint64(13+2*len(v)))
+			body = append(body, v...)
+		case []byte:
+			header = putVarint(header, uint64(12+2*len(v)))
+			body = append(body, v...)
+		default:
+			return nil, errors.Errorf("unsupported type %T", v)
+		}
+	}
+
+	// the size of the header includes the varint of the size itself
+	size := len(header) + 1
+	for varintLen(uint64(size)) != size-len(header) {
+		size++
+	}
+
+	record := putVarint(make([]byte, 0, size+len(body)), uint64(size))
+	record = append(record, header...)
+	return append(record, body...), nil
+}
+
+// appendInt stores the integer using the smallest serial type.
+func appendInt(header, body []byte, v int64) ([]byte, []byte) {
+	switch {
+	case v == 0:
+		return putVarint(header, 8), body
+	case v == 1:
+		return putVarint(header, 9), body
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+
+	serial, size := 6, 8
+	for _, t := range []struct{ serial, size int }{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+		limit := int64(1) << (8*t.size - 1)
+		if v >= -limit && v < limit {
+			serial, size = t.serial, t.size
+			break
+		}
+	}
+	return putVarint(header, uint64(serial)), append(body, buf[8-size:]...)
+}
+
+// putVarint appends the variable length integer to buf. The integer is
+// stored big endian in groups of seven bits, the ninth byte contains eight
+// bits.
+func putVarint(buf []byte, v uint64) []byte {
+	if v>>56 != 0 {
+		var b [9]byte
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(buf, b[:]...)
+	}
+
+	var b [8]byte
+	n := len(b)
+	for {
+		n--
+		b[n] = byte(v&0x7f) | 0x80
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	b[len(b)-1] &^= 0x80
+	return append(buf, b[n:]...)
+}
+
+func varintLen(v uint64) int {
+	return len(putVarint(nil, v))
+}
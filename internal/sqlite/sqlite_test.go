@@ -0,0 +1,217 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// reader decodes the tables of a database file, it only supports the subset
+// of the file format produced by DB.
+type reader struct {
+	t   testing.TB
+	buf []byte
+}
+
+func (r *reader) page(no uint32) []byte {
+	rtest.Assert(r.t, no >= 1 && int(no)*pageSize <= len(r.buf), "invalid page %d", no)
+	return r.buf[int(no-1)*pageSize : int(no)*pageSize]
+}
+
+func readVarint(buf []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(buf[i]&0x7f)
+		if buf[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(buf[8]), 9
+}
+
+// rows returns the rows of the table b-tree with the root page as a map from
+// the row ID to the payload.
+func (r *reader) rows(root uint32, rows map[int64][]byte) {
+	page := r.page(root)
+	offset := 0
+	if root == 1 {
+		offset = headerSize
+	}
+
+	n := int(binary.BigEndian.Uint16(page[offset+3:]))
+	switch page[offset] {
+	case pageTypeLeaf:
+		for i := 0; i < n; i++ {
+			cell := page[binary.BigEndian.Uint16(page[offset+leafHeaderSize+2*i:]):]
+			size, l1 := readVarint(cell)
+			rowid, l2 := readVarint(cell[l1:])
+			cell = cell[l1+l2:]
+
+			local := int(size)
+			if local > maxLocal {
+				local = minLocal + (int(size)-minLocal)%(pageSize-4)
+				if local > maxLocal {
+					local = minLocal
+				}
+			}
+			payload := append([]byte{}, cell[:local]...)
+			if local < int(size) {
+				next := binary.BigEndian.Uint32(cell[local:])
+				for next != 0 {
+					overflow := r.page(next)
+					payload = append(payload, overflow[4:]...)
+					next = binary.BigEndian.Uint32(overflow)
+				}
+				payload = payload[:size]
+			}
+			_, ok := rows[int64(rowid)]
+			rtest.Assert(r.t, !ok, "duplicate row %d", rowid)
+			rows[int64(rowid)] = payload
+		}
+	case pageTypeInterior:
+		for i := 0; i < n; i++ {
+			cell := page[binary.BigEndian.Uint16(page[offset+interiorHeaderSize+2*i:]):]
+			r.rows(binary.BigEndian.Uint32(cell), rows)
+		}
+		r.rows(binary.BigEndian.Uint32(page[offset+8:]), rows)
+	default:
+		r.t.Fatalf("unexpected page type %x of page %d", page[offset], root)
+	}
+}
+
+func decodeRecord(t testing.TB, buf []byte) []interface{} {
+	size, n := readVarint(buf)
+	header, body := buf[n:size], buf[size:]
+
+	var values []interface{}
+	for len(header) > 0 {
+		serial, n := readVarint(header)
+		header = header[n:]
+
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial >= 1 && serial <= 6:
+			size := []int{0, 1, 2, 3, 4, 6, 8}[serial]
+			var v int64
+			for _, b := range body[:size] {
+				v = v<<8 | int64(b)
+			}
+			// sign extension
+			v = v << (64 - 8*size) >> (64 - 8*size)
+			values = append(values, v)
+			body = body[size:]
+		case serial == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(body)))
+			body = body[8:]
+		case serial == 8 || serial == 9:
+			values = append(values, int64(serial-8))
+		case serial >= 12:
+			l := int((serial - 12) / 2)
+			if serial%2 == 1 {
+				values = append(values, string(body[:l]))
+			} else {
+				values = append(values, append([]byte{}, body[:l]...))
+			}
+			body = body[l:]
+		default:
+			t.Fatalf("unexpected serial type %d", serial)
+		}
+	}
+	rtest.Equals(t, 0, len(body))
+	return values
+}
+
+// readTables returns the rows of all tables.
+func readTables(t testing.TB, filename string) map[string]map[int64][]interface{} {
+	buf, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, "SQLite format 3\x00", string(buf[:16]))
+	rtest.Equals(t, uint16(pageSize), binary.BigEndian.Uint16(buf[16:]))
+	rtest.Equals(t, len(buf)/pageSize, int(binary.BigEndian.Uint32(buf[28:])))
+
+	r := &reader{t: t, buf: buf}
+	schema := make(map[int64][]byte)
+	r.rows(1, schema)
+
+	tables := make(map[string]map[int64][]interface{})
+	for _, payload := range schema {
+		entry := decodeRecord(t, payload)
+		rtest.Equals(t, "table", entry[0])
+
+		rows := make(map[int64][]byte)
+		r.rows(uint32(entry[3].(int64)), rows)
+		table := make(map[int64][]interface{})
+		for rowid, payload := range rows {
+			table[rowid] = decodeRecord(t, payload)
+		}
+		tables[entry[1].(string)] = table
+	}
+	return tables
+}
+
+func TestVarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 16383, 16384, 1<<56 - 1, 1 << 56, math.MaxUint64} {
+		buf := putVarint(nil, v)
+		res, n := readVarint(buf)
+		rtest.Equals(t, v, res)
+		rtest.Equals(t, len(buf), n)
+	}
+	rtest.Equals(t, []byte{0x81, 0x00}, putVarint(nil, 128))
+}
+
+func TestWrite(t *testing.T) {
+	filename := filepath.Join(rtest.TempDir(t), "test.db")
+	db, err := Create(filename)
+	rtest.OK(t, err)
+
+	ints := []int64{0, 1, -1, 127, -128, 128, 40000, -40000, 1 << 23, 1 << 40, -(1 << 47), math.MaxInt64, math.MinInt64}
+	// enough rows for a b-tree with three levels
+	const rows = 200000
+
+	large := db.CreateTable("large", "CREATE TABLE large (id INTEGER PRIMARY KEY, n INTEGER, s TEXT, f REAL, b BLOB)")
+	empty := db.CreateTable("empty", "CREATE TABLE empty (x)")
+	overflow := db.CreateTable("overflow", "CREATE TABLE overflow (id INTEGER PRIMARY KEY, s TEXT)")
+	for i := 0; i < rows; i++ {
+		id, err := large.Insert(nil, ints[i%len(ints)], fmt.Sprint("row", i), float64(i)/3, []byte{byte(i)})
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(i+1), id)
+
+		if i%100 == 0 {
+			_, err = overflow.Insert(nil, strings.Repeat("x", i%20000))
+			rtest.OK(t, err)
+		}
+	}
+	_, err = empty.Insert(uint64(math.MaxUint64))
+	rtest.Assert(t, err != nil, "inserting a too large integer did not fail")
+	_, err = empty.Insert(struct{}{})
+	rtest.Assert(t, err != nil, "inserting an unsupported type did not fail")
+	rtest.OK(t, db.Close())
+
+	tables := readTables(t, filename)
+	rtest.Equals(t, 3, len(tables))
+	rtest.Equals(t, 0, len(tables["empty"]))
+
+	rtest.Equals(t, rows, len(tables["large"]))
+	for i := 0; i < rows; i++ {
+		rtest.Equals(t, []interface{}{nil, ints[i%len(ints)], fmt.Sprint("row", i), float64(i) / 3, []byte{byte(i)}}, tables["large"][int64(i+1)])
+	}
+
+	rtest.Equals(t, rows/100, len(tables["overflow"]))
+	for i := 0; i < rows; i += 100 {
+		rtest.Equals(t, []interface{}{nil, strings.Repeat("x", i%20000)}, tables["overflow"][int64(i/100+1)])
+	}
+}
+
+func TestCreateExisting(t *testing.T) {
+	filename := filepath.Join(rtest.TempDir(t), "test.db")
+	rtest.OK(t, os.WriteFile(filename, []byte("foo"), 0600))
+	_, err := Create(filename)
+	rtest.Assert(t, err != nil, "overwriting an existing file did not fail")
+}
@@ -198,6 +198,17 @@ func (b *JSONProgress) Finish(snapshotID restic.ID, start time.Time, summary *Su
 func (b *JSONProgress) Reset() {
 }
 
+// JSONMessageTypes returns a value of each message type printed by
+// JSONProgress, keyed by the message_type of the message.
+func JSONMessageTypes() map[string]interface{} {
+	return map[string]interface{}{
+		"status":         statusUpdate{},
+		"error":          errorUpdate{},
+		"verbose_status": verboseUpdate{},
+		"summary":        summaryOutput{},
+	}
+}
+
 type statusUpdate struct {
 	MessageType      string   `json:"message_type"` // "status"
 	SecondsElapsed   uint64   `json:"seconds_elapsed,omitempty"`
@@ -204,6 +204,34 @@ func (p *Progress) ReportTotal(item string, s archiver.ScanStats) {
 	}
 }
 
+// SnapshotSummary returns the statistics collected so far in the form stored
+// in snapshots.
+func (p *Progress) SnapshotSummary() *restic.SnapshotSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.summary
+	return &restic.SnapshotSummary{
+		BackupStart: p.start,
+		BackupEnd:   time.Now(),
+
+		FilesNew:        s.Files.New,
+		FilesChanged:    s.Files.Changed,
+		FilesUnmodified: s.Files.Unchanged,
+		DirsNew:         s.Dirs.New,
+		DirsChanged:     s.Dirs.Changed,
+		DirsUnmodified:  s.Dirs.Unchanged,
+
+		DataBlobs:       s.DataBlobs,
+		TreeBlobs:       s.TreeBlobs,
+		DataAdded:       s.DataSize + s.TreeSize,
+		DataAddedPacked: s.DataSizeInRepo + s.TreeSizeInRepo,
+
+		TotalFilesProcessed: s.Files.New + s.Files.Changed + s.Files.Unchanged,
+		TotalBytesProcessed: s.ProcessedBytes,
+	}
+}
+
 // Finish prints the finishing messages.
 func (p *Progress) Finish(snapshotID restic.ID, dryrun bool) {
 	// wait for the status update goroutine to shut down